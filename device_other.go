@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+// deviceID is not supported on this platform, mounts are never reported as different
+func deviceID(path string) (uint64, bool) {
+	return 0, false
+}
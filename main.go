@@ -1,20 +1,41 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"fmt"
+	htmltemplate "html/template"
+	"io"
 	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/smtp"
 	"os"
+	osexec "os/exec"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"text/template"
 	"time"
+	"unicode"
 
-	"github.com/bitfield/script"
 	"github.com/cheynewallace/tabby"
+	"github.com/peteraba/ffr/rename"
+	"github.com/peteraba/ffr/truncate"
 	cli "github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+	"mvdan.cc/sh/v3/shell"
 )
 
 const (
@@ -27,9 +48,11 @@ const (
 )
 
 const (
-	encoderH264 = "libx264"
-	encoderH265 = "libx265"
-	encoderVP9  = "vp9"
+	encoderH264   = "libx264"
+	encoderH265   = "libx265"
+	encoderVP9    = "vp9"
+	encoderProRes = "prores_ks"
+	encoderDNxHR  = "dnxhd"
 )
 
 const (
@@ -65,6 +88,11 @@ const (
 	sdHeight     = 480
 )
 
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+)
+
 const (
 	defaultCodec  = encoderH265
 	defaultPreset = "ultrafast"
@@ -74,613 +102,12738 @@ var (
 	allowedPresets = []string{"ultrafast", "superfast", "veryfast", "faster", "fast", "medium", "slow", "slower", "veryslow"}
 )
 
+// logger collects the messages of a single invocation and prints them unless it is silent. It is safe for concurrent
+// use.
 type logger struct {
+	lock    *sync.Mutex
 	silent  bool
 	history []string
 }
 
-func (l *logger) Printf(msg string, args ...interface{}) {
-	if l.silent {
-		l.history = append(l.history, fmt.Sprintf(msg, args...))
-		return
+func newLogger(silent bool) *logger {
+	return &logger{
+		lock:   &sync.Mutex{},
+		silent: silent,
 	}
+}
+
+// reset clears the history of the logger and sets whether it is silent, so that every invocation of an app starts with
+// an empty history
+func (l *logger) reset(silent bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.silent = silent
+	l.history = nil
+}
 
-	log.Printf(msg, args...)
+func (l *logger) Printf(msg string, args ...interface{}) {
+	l.record(fmt.Sprintf(msg, args...))
 }
 
 func (l *logger) Println(msg ...any) {
-	if l.silent {
-		l.history = append(l.history, fmt.Sprintln(msg...))
-		return
-	}
+	l.record(fmt.Sprintln(msg...))
+}
+
+func (l *logger) record(msg string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
 
-	log.Println(msg...)
+	l.history = append(l.history, msg)
+
+	if !l.silent {
+		log.Print(msg)
+	}
 }
 
-var l logger
+// History returns a copy of the messages logged so far, printed or not
+func (l *logger) History() []string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
 
-func safeRename(oldPath, newPath string, forceOverwrite bool) error {
-	if oldPath == newPath {
-		l.Printf("no file name change. path: '%s'", newPath)
+	return append([]string{}, l.history...)
+}
 
+// WriteHistory appends the messages logged so far to a file, preceded by a header naming the invocation
+func (l *logger) WriteHistory(path string, args []string) error {
+	if path == "" {
 		return nil
 	}
 
-	l.Println(oldPath, " -> ", newPath)
-
-	_, err := os.Stat(newPath)
-	if err == nil || !os.IsNotExist(err) {
-		if !forceOverwrite {
-			l.Printf("file already exists. path: %q", newPath)
-			return err
-		}
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "# %s ffr %s\n", time.Now().Format(time.RFC3339), strings.Join(args, " "))
+	for _, msg := range l.History() {
+		sb.WriteString(strings.TrimRight(msg, "\n"))
+		sb.WriteString("\n")
+	}
 
-		l.Printf("force overwrite. path: %q", newPath)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log history. path: %q, err: %w", path, err)
 	}
+	defer f.Close()
 
-	err = os.Rename(oldPath, newPath)
+	_, err = f.WriteString(sb.String())
 	if err != nil {
-		l.Printf("unexpected error during renaming file. old path: %q, new path: %q, err: %s", oldPath, newPath, err)
+		return fmt.Errorf("failed to write log history. path: %q, err: %w", path, err)
 	}
 
-	return err
+	return nil
 }
 
-func concat(parts []string, skip int, newPart, ext, separator string) string {
-	if len(parts) < skip {
-		panic(fmt.Errorf("unsafe usage of concat. len(parts): %d, skip: %d", len(parts), skip))
+// exportLogHistory writes the history of an invocation's logger to path if one was given, failures are only logged
+func exportLogHistory(l *logger, path string) {
+	err := l.WriteHistory(path, invocation)
+	if err != nil {
+		log.Println(err)
 	}
+}
+
+// state contains everything an invocation of ffr configures or changes. It is owned by the app, configure resets it
+// at the start of every invocation.
+type state struct {
+	log *logger
+	// progress logs status messages which are hidden in quiet mode
+	progress *logger
+	// useColor enables coloring the output with ANSI escape codes
+	useColor bool
+
+	// rootDir is the directory no changes can be made outside of, an empty rootDir disables the check
+	rootDir string
+	// journal records the changes of the invocation, nil if disabled or during a dry-run
+	journal *journal
+	// workspace contains the temporary directories of the invocation
+	workspace *workspace
+	// config is the configuration file read
+	config config
+
+	// changes contains the renames and outputs planned (during a dry-run) or performed by the invocation
+	changes []renamePair
+	// outputs contains the files and directories planned (during a dry-run) or created by encoding during the invocation
+	outputs []string
+
+	// followSymlinks makes the targets of symbolic links to be processed under their own names instead of the links,
+	// the links are updated to point to the renamed targets. By default, the links themselves are renamed.
+	followSymlinks bool
+	// skipSymlinks makes symbolic links to be ignored when collecting the files to process
+	skipSymlinks bool
+	// symlinkSources maps the targets of the symbolic links followed to the links, so that the links can be updated
+	// when their targets are renamed
+	symlinkSources map[string][]string
+	// linkMode makes renames keep the original files and create hard links (or copies if linking is not possible)
+	linkMode bool
+	// sidecarExtensions contains the extensions of files which are renamed together with the file sharing their base
+	// name
+	sidecarExtensions []string
+
+	// allowedExtensions contains the extensions of files to process, all files are processed if empty
+	allowedExtensions []string
+	// defaultExtensions contains the extensions of files listed in directories if no extensions are allowed explicitly
+	defaultExtensions []string
+	// skipPartial makes file lists skip files which are likely to be incomplete
+	skipPartial bool
+	// skipMissing makes file lists skip arguments which do not exist instead of failing
+	skipMissing bool
+	// skippedArgs contains the missing arguments skipped, summarized at the end of the invocation
+	skippedArgs []string
+	// fileFilters contains the filters all files must match to be processed
+	fileFilters []fileFilter
+
+	// ioLimit is the maximum number of bytes per second read from files, zero means no limit
+	ioLimit int64
+	// ioRetries is the number of times files failing with a transient I/O error are retried
+	ioRetries int
+	// jobs is the number of files probed at the same time
+	jobs int
+	// probes caches the probes of the invocation
+	probes *probeCache
+	// videoStream is the index of the video stream probed in files having more than one
+	videoStream int
+	// ignoreRotation makes dimensions reported as they are stored instead of as they are displayed
+	ignoreRotation bool
+	// dateLocation is the timezone metadata dates are converted to before they are used in file names
+	dateLocation *time.Location
+
+	// commandLogDir is the directory the output of every command is saved to, an empty commandLogDir disables saving
+	commandLogDir string
+	// commandLogPath is the log file of the file currently processed
+	commandLogPath string
+	// printCommands makes the commands of ffmpeg and ffprobe printed in a copy-pasteable form
+	printCommands bool
+
+	// resultFormat is the format of the machine-readable result printed to stdout for each processed file, an empty
+	// resultFormat disables printing results
+	resultFormat string
+	// rawSeconds makes durations display as seconds instead of hh:mm:ss.s
+	rawSeconds bool
+	// unitSystem and unitPrecision control how sizes and bit rates are displayed
+	unitSystem    string
+	unitPrecision int
+}
 
-	start := strings.Join(parts[:skip], separator)
-	if start != "" {
-		start += separator
+// newState returns the state of an invocation before it is configured
+func newState() *state {
+	return &state{
+		log:               newLogger(false),
+		progress:          newLogger(false),
+		workspace:         newWorkspace(""),
+		symlinkSources:    map[string][]string{},
+		defaultExtensions: defaultVideoExtensions,
+		probes:            newProbeCache(),
+		dateLocation:      time.Local,
+		unitSystem:        unitsSI,
+		unitPrecision:     1,
 	}
+}
 
-	end := strings.Join(parts[skip:], separator)
-	if end != "" {
-		end = separator + end
+const (
+	colorRed    = "31"
+	colorGreen  = "32"
+	colorYellow = "33"
+
+	colorAuto   = "auto"
+	colorAlways = "always"
+	colorNever  = "never"
+)
+
+// shouldUseColor decides if the output should be colored. NO_COLOR is respected unless colors are forced, in auto mode
+// colors are used only if the output is a terminal.
+func shouldUseColor(mode string, noColor bool, output *os.File) (bool, error) {
+	switch {
+	case noColor || mode == colorNever:
+		return false, nil
+	case mode == colorAlways:
+		return true, nil
+	case mode != colorAuto && mode != "":
+		return false, fmt.Errorf("invalid color mode. mode: %s", mode)
+	case os.Getenv("NO_COLOR") != "":
+		return false, nil
 	}
 
-	return start + newPart + end + ext
+	return isTerminal(output), nil
 }
 
-func getFileInfoList(filePaths []string, backwardsFlag bool) []os.FileInfo {
-	if len(filePaths) == 0 {
-		log.Fatalf("no files provided")
-
-		return nil
+func isTerminal(output *os.File) bool {
+	fi, err := output.Stat()
+	if err != nil {
+		return false
 	}
 
-	var fileInfoList []os.FileInfo
+	return fi.Mode()&os.ModeCharDevice != 0
+}
 
-	for _, filePath := range filePaths {
-		fi, err := os.Stat(filePath)
-		if err != nil {
-			log.Fatalf("argument is not a file: %q, err: %s", filePath, err)
-		}
+const (
+	defaultTerminalWidth = 80
+	minNameLength        = 20
+)
 
-		if fi.IsDir() {
-			log.Fatalf("file is a directory: %q", filePath)
-		}
+// terminalWidth returns the number of columns available for tables. COLUMNS takes precedence, the size of terminals
+// is asked from stty, defaultTerminalWidth is used if the width can not be detected (e.g. when piping to a file).
+func terminalWidth(output *os.File) int {
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		return n
+	}
 
-		l.Printf("file is okay: %q", filePath)
+	if !isTerminal(output) {
+		return defaultTerminalWidth
+	}
 
-		fileInfoList = append(fileInfoList, fi)
+	cmd := osexec.Command("stty", "size")
+	cmd.Stdin = output
+	out, err := cmd.Output()
+	if err != nil {
+		return defaultTerminalWidth
 	}
 
-	if backwardsFlag {
-		var fis2 []os.FileInfo
-		for i := len(fileInfoList) - 1; i >= 0; i-- {
-			fis2 = append(fis2, fileInfoList[i])
-		}
-		fileInfoList = fis2
+	parts := strings.Fields(string(out))
+	if len(parts) != 2 {
+		return defaultTerminalWidth
+	}
+
+	n, err := strconv.Atoi(parts[1])
+	if err != nil || n <= 0 {
+		return defaultTerminalWidth
 	}
 
-	return fileInfoList
+	return n
 }
 
-func process(c *cli.Context, argCount int, fn func(*cli.Context, []string, os.FileInfo, bool) error) error {
-	args := c.Args().Slice()
-	dryRun := c.Bool(dryRunFlag)
+// fitColumn returns the width left for the column at index in a tabby table of the given lines so that the whole
+// table fits into width, but at least minNameLength
+func fitColumn(lines [][]interface{}, index, width int) int {
+	var widths []int
+	for _, line := range lines {
+		for i, col := range line {
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
 
-	l = logger{
-		silent: !(c.Bool(verboseFlag) || c.Bool(dryRunFlag)),
+			n := truncate.Len(fmt.Sprint(col))
+			if n > widths[i] {
+				widths[i] = n
+			}
+		}
 	}
 
-	if argCount > len(args) {
-		return errors.New("not enough arguments")
+	// tabby pads every column but the last one with 2 spaces
+	available := width
+	for i, w := range widths {
+		if i != index {
+			available -= w
+		}
+		if i != len(widths)-1 {
+			available -= 2
+		}
 	}
 
-	fileInfoList := getFileInfoList(args[argCount:], c.Bool(backwardsFlag))
-	for _, fi := range fileInfoList {
-		l.Printf("file found: %q", fi.Name())
+	if available < minNameLength {
+		return minNameLength
 	}
 
-	args = args[:argCount]
+	return available
+}
 
-	t0 := time.Now()
-	for _, fi := range fileInfoList {
-		t1 := time.Now()
-		err := fn(c, args, fi, dryRun)
-		if err != nil {
-			l.Println(err)
-		}
-		log.Printf("done in %s.", time.Since(t1).String())
+func colorize(st *state, color, text string) string {
+	if !st.useColor {
+		return text
 	}
-	log.Printf("all done in %s.", time.Since(t0).String())
 
-	return nil
+	return "\033[" + color + "m" + text + "\033[0m"
 }
 
-func processAll(c *cli.Context, argCount int, fn func(*cli.Context, []string, []os.FileInfo, bool) error) error {
-	args := c.Args().Slice()
-	dryRun := c.Bool(dryRunFlag)
+const (
+	journalRename  = "rename"
+	journalMkdir   = "mkdir"
+	journalRmdir   = "rmdir"
+	journalLink    = "link"
+	journalCopy    = "copy"
+	journalUnlink  = "unlink"
+	journalSymlink = "symlink"
+	journalEncode  = "encode"
+	journalDelete  = "delete"
+)
 
-	l = logger{
-		silent: !(c.Bool(verboseFlag) || c.Bool(dryRunFlag)),
-	}
+type journalEntry struct {
+	Run     string    `json:"run"`
+	Time    time.Time `json:"time"`
+	Command string    `json:"command"`
+	Action  string    `json:"action"`
+	From    string    `json:"from,omitempty"`
+	To      string    `json:"to,omitempty"`
+	Target  string    `json:"target,omitempty"`
+	Undoes  string    `json:"undoes,omitempty"`
+}
 
-	if argCount > len(args) {
-		return errors.New("not enough arguments")
-	}
+// journal records the file system changes made by ffr so that they can be reviewed and undone later
+type journal struct {
+	lock    *sync.Mutex
+	log     *logger
+	path    string
+	run     string
+	command string
+	undoes  string
+}
 
-	fileInfoList := getFileInfoList(args[argCount:], c.Bool(backwardsFlag))
-	for _, fi := range fileInfoList {
-		l.Printf("file found: %q", fi.Name())
+func newJournal(l *logger, path, command string) *journal {
+	if path == "" {
+		return nil
 	}
 
-	args = args[:argCount]
+	return &journal{
+		lock:    &sync.Mutex{},
+		log:     l,
+		path:    path,
+		run:     time.Now().Format(time.RFC3339Nano),
+		command: command,
+	}
+}
 
-	t0 := time.Now()
-	err := fn(c, args, fileInfoList, dryRun)
+func defaultJournalPath() string {
+	dir, err := os.UserConfigDir()
 	if err != nil {
-		l.Println(err)
+		return ""
 	}
-	log.Printf("all done in %s.", time.Since(t0).String())
 
-	return nil
+	return filepath.Join(dir, "ffr", "journal.jsonl")
 }
 
-func exec(command string) (string, error) {
-	p := script.Exec(command)
-	output, err := p.String()
-	if err != nil {
-		l.Println(err)
-	}
+// Record appends an entry to the journal. It is safe to call on a nil journal, which is a no-op.
+func (j *journal) Record(action, from, to string) {
+	j.record(action, from, to, "")
+}
 
-	return output, err
+// RecordSymlink records the removal or creation of a symbolic link, including its destination
+func (j *journal) RecordSymlink(action, path, target string) {
+	if action == journalSymlink {
+		j.record(action, "", path, target)
+	} else {
+		j.record(action, path, "", target)
+	}
 }
 
-type App struct{}
+func (j *journal) record(action, from, to, target string) {
+	if j == nil {
+		return
+	}
 
-func findKeyFrames(fi os.FileInfo) ([]string, error) {
-	command := fmt.Sprintf(`ffprobe -loglevel error -select_streams v:0 -show_entries packet=pts_time,flags -of csv=print_section=0 %q`, fi.Name())
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if from != "" {
+		from, _ = filepath.Abs(from)
+	}
+	if to != "" {
+		to, _ = filepath.Abs(to)
+	}
 
-	res, err := script.Exec(command).Match(",K__").FilterLine(func(line string) string {
-		return strings.Split(line, ",")[0]
-	}).Slice()
+	entry := journalEntry{
+		Run:     j.run,
+		Time:    time.Now(),
+		Command: j.command,
+		Action:  action,
+		From:    from,
+		To:      to,
+		Target:  target,
+		Undoes:  j.undoes,
+	}
 
+	data, err := json.Marshal(entry)
 	if err != nil {
-		return nil, fmt.Errorf("unable to retrieve keyframes. err: %w", err)
+		j.log.Printf("failed to encode journal entry. err: %s", err)
+		return
 	}
 
-	maxCount := 4
-	var numbers []string
-	for i, line := range res {
-		if i >= maxCount {
-			break
-		}
+	err = os.MkdirAll(filepath.Dir(j.path), 0755)
+	if err != nil {
+		j.log.Printf("failed to create journal directory. path: %q, err: %s", j.path, err)
+		return
+	}
+
+	f, err := os.OpenFile(j.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		j.log.Printf("failed to open journal. path: %q, err: %s", j.path, err)
+		return
+	}
+	defer f.Close()
 
-		if line == "" {
+	_, err = f.Write(append(data, '\n'))
+	if err != nil {
+		j.log.Printf("failed to write journal. path: %q, err: %s", j.path, err)
+	}
+}
+
+func readJournal(path string) ([]journalEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read journal. path: %q, err: %w", path, err)
+	}
+
+	var entries []journalEntry
+	for i, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
 			continue
 		}
 
-		n, err := strconv.ParseFloat(line, 32)
+		var entry journalEntry
+		err = json.Unmarshal([]byte(line), &entry)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("invalid journal entry. path: %q, line: %d, err: %w", path, i+1, err)
 		}
 
-		numbers = append(numbers, fmt.Sprintf("%.1f", n))
+		entries = append(entries, entry)
 	}
 
-	return numbers, nil
+	return entries, nil
 }
 
-func keyFrames(fi os.FileInfo) error {
-	numbers, err := findKeyFrames(fi)
+func safeRename(st *state, oldPath, newPath string, forceOverwrite bool) error {
+	if oldPath == newPath {
+		st.log.Printf("no file name change. path: '%s'", newPath)
+
+		return nil
+	}
+
+	st.log.Println(oldPath, " -> ", newPath)
+
+	// the symbolic links followed to oldPath are updated as well
+	err := checkRoot(st, append([]string{oldPath, newPath}, st.symlinkSources[oldPath]...)...)
 	if err != nil {
 		return err
 	}
 
-	l.Printf("file: %s", fi.Name())
-	l.Printf("indexes: %s...", strings.Join(numbers, ", "))
-
-	return nil
-}
+	// Lstat is used so that dangling symbolic links are not overwritten silently
+	_, err = os.Lstat(newPath)
+	if err == nil || !os.IsNotExist(err) {
+		if !forceOverwrite {
+			st.log.Printf("file already exists. path: %q", newPath)
+			return &RenameCollision{Path: newPath}
+		}
 
-func (a App) keyFrames(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
-	return keyFrames(fi)
-}
+		st.log.Printf("force overwrite. path: %q", newPath)
+	}
 
-const (
-	videoCodecKey    = "-c:v"
-	audioCodecKey    = "-c:a"
-	crfKey           = "-crf"
-	bitRateKey       = "-b:v"
-	maxRateKey       = "-maxrate"
-	bufsizeKey       = "-bufsize"
-	presetKey        = "-preset"
-	losslessKey      = "-lossless"
-	hwaccelKey       = "-hwaccel"
-	hwaccelDeviceKey = "-hwaccel_device"
-	inputKey         = "-i"
-)
+	if st.linkMode {
+		err = linkOrCopy(st, oldPath, newPath)
+	} else {
+		err = moveFile(st, oldPath, newPath)
+		if err == nil {
+			st.journal.Record(journalRename, oldPath, newPath)
+			err = updateSymlinks(st, oldPath, newPath)
+		}
+	}
 
-type ReEncoder struct {
-	lock     *sync.Mutex
-	params   map[string]string
-	order    []string
-	keys     []string
-	boolKeys []string
-}
+	if err != nil {
+		st.log.Printf("unexpected error during renaming file. old path: %q, new path: %q, err: %s", oldPath, newPath, err)
 
-func NewReEncoder() *ReEncoder {
-	return &ReEncoder{
-		lock:     &sync.Mutex{},
-		params:   make(map[string]string),
-		keys:     []string{videoCodecKey, hwaccelKey, crfKey, losslessKey, presetKey},
-		boolKeys: []string{losslessKey},
+		return err
 	}
-}
 
-func (r *ReEncoder) Set(key, value string) *ReEncoder {
-	r.lock.Lock()
-	defer r.lock.Unlock()
-
-	_, ok := r.params[key]
-	if ok {
-		r.params[key] = value
+	recordChange(st, oldPath, newPath)
 
-		return r
+	err = moveLabels(oldPath, newPath, st.linkMode)
+	if err != nil {
+		st.log.Printf("failed to move labels. old path: %q, new path: %q, err: %s", oldPath, newPath, err)
 	}
 
-	r.params[key] = value
-	r.order = append(r.order, key)
+	err = updateDirIndex(oldPath, newPath, st.linkMode)
+	if err != nil {
+		st.log.Printf("failed to update directory index. old path: %q, new path: %q, err: %s", oldPath, newPath, err)
+	}
 
-	return r
+	return renameSidecars(st, oldPath, newPath, forceOverwrite)
 }
 
-func (r *ReEncoder) Delete(key string) *ReEncoder {
-	r.lock.Lock()
-	defer r.lock.Unlock()
-
-	_, ok := r.params[key]
-	if !ok {
-		return r
+func isSymlink(path string) bool {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return false
 	}
 
-	delete(r.params, key)
-	for i, k := range r.order {
-		if k == key {
-			r.order = append(r.order[:i], r.order[i+1:]...)
-		}
+	return fi.Mode()&os.ModeSymlink != 0
+}
+
+// followSymlink returns the target of a symbolic link if symbolic links are followed, so that the target is processed
+// under its own name, and the path itself otherwise
+func followSymlink(st *state, filePath string) string {
+	if !st.followSymlinks || !isSymlink(filePath) {
+		return filePath
 	}
 
-	return r
-}
+	dest, err := os.Readlink(filePath)
+	if err != nil {
+		st.log.Printf("failed to read symbolic link. path: %q, err: %s", filePath, err)
 
-func (r *ReEncoder) String() string {
-	r.lock.Lock()
-	defer r.lock.Unlock()
+		return filePath
+	}
 
-	params := []string{}
-	for _, key := range r.order {
-		params = append(params, fmt.Sprintf("%s %q", key, r.params[key]))
+	target := dest
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(filePath), dest)
 	}
 
-	return strings.Join(params, " ")
+	st.log.Printf("following symbolic link. path: %q, target: %q", filePath, target)
+	st.symlinkSources[target] = append(st.symlinkSources[target], filePath)
+
+	return target
 }
 
-func (r *ReEncoder) GetPath() string {
-	r.lock.Lock()
-	defer r.lock.Unlock()
+// updateSymlinks replaces the symbolic links followed to oldPath with ones pointing to newPath. Relative links are
+// kept relative.
+func updateSymlinks(st *state, oldPath, newPath string) error {
+	links := st.symlinkSources[oldPath]
+	delete(st.symlinkSources, oldPath)
 
-	values := []string{}
+	for _, link := range links {
+		dest, err := os.Readlink(link)
+		if err != nil {
+			return fmt.Errorf("failed to read symbolic link. path: %q, err: %w", link, err)
+		}
 
-	for _, key := range r.keys {
-		if value, ok := r.params[key]; ok {
-			b := false
-			for _, bv := range r.boolKeys {
-				if bv == key {
-					b = true
-					break
-				}
+		newDest := newPath
+		if !filepath.IsAbs(dest) {
+			newDest, err = relPath(filepath.Dir(link), newPath)
+			if err != nil {
+				return err
 			}
-			if b {
-				values = append(values, strings.Trim(key, "-"))
-			} else {
-				values = append(values, value)
+		} else if !filepath.IsAbs(newDest) {
+			newDest, err = filepath.Abs(newDest)
+			if err != nil {
+				return err
 			}
 		}
-	}
 
-	return strings.Join(values, "-")
-}
+		st.log.Printf("updating symbolic link. path: %q, target: %q", link, newDest)
 
-func findPreset(preset string) (string, error) {
-	for _, p := range allowedPresets {
-		if p == preset {
-			return preset, nil
+		err = os.Remove(link)
+		if err != nil {
+			return err
+		}
+		st.journal.RecordSymlink(journalUnlink, link, dest)
+
+		err = os.Symlink(newDest, link)
+		if err != nil {
+			return err
 		}
+		st.journal.RecordSymlink(journalSymlink, link, newDest)
 	}
 
-	return "", fmt.Errorf("invalid preset. preset: %s", preset)
+	if len(links) > 0 {
+		st.symlinkSources[newPath] = links
+	}
+
+	return nil
 }
 
-func getNewBitRates(fi os.FileInfo, encoder string) (string, string, error) {
-	oldCodec, err := getCodec(fi)
+// relPath returns path relative to base, resolving both to absolute paths first
+func relPath(base, path string) (string, error) {
+	absBase, err := filepath.Abs(base)
 	if err != nil {
-		return "", "", fmt.Errorf("unable to get codec. err: %w", err)
+		return "", err
 	}
 
-	rawBitRate, err := getBitRate(fi)
+	absPath, err := filepath.Abs(path)
 	if err != nil {
-		return "", "", fmt.Errorf("unable to get bitrate. err: %w", err)
+		return "", err
 	}
 
-	if rawBitRate == 0 {
-		vt := info(fi, true)
+	return filepath.Rel(absBase, absPath)
+}
 
-		rawBitRate = vt.width * vt.height / 10 * int64(vt.frameRate)
+// linkOrCopy creates a hard link of oldPath at newPath, falling back to copying if hard links are not supported,
+// e.g. because the paths are on different devices
+func linkOrCopy(st *state, oldPath, newPath string) error {
+	_, err := os.Lstat(newPath)
+	if err == nil {
+		err = os.Remove(newPath)
+		if err != nil {
+			return err
+		}
 	}
 
-	rbr := intToString(rawBitRate, "", "")
-	l.Printf("file: %s, old codec: %s, encoder: %s, old bit rate: %d, rbr human: %s", fi.Name(), oldCodec, encoder, rawBitRate, rbr)
+	err = os.Link(oldPath, newPath)
+	if err == nil {
+		st.journal.Record(journalLink, oldPath, newPath)
 
-	if encoder == encoderH265 && oldCodec != codecH265 {
-		rawBitRate = rawBitRate * 6 / 10
+		return nil
+	}
+
+	st.log.Printf("failed to create hard link, copying instead. old path: %q, new path: %q, err: %s", oldPath, newPath, err)
+
+	err = copyFile(st, oldPath, newPath)
+	if err != nil {
+		return err
+	}
+
+	st.journal.Record(journalCopy, oldPath, newPath)
+
+	return nil
+}
+
+// parseIOLimit parses a throughput like "50MB/s" or "10MiB" into bytes per second, an empty string means no limit
+func parseIOLimit(spec string) (int64, error) {
+	if spec == "" {
+		return 0, nil
+	}
+
+	limit, err := parseTargetSize(strings.TrimSuffix(strings.ToLower(strings.TrimSpace(spec)), "/s"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid I/O limit. limit: %s", spec)
+	}
+
+	return limit, nil
+}
+
+// limitedReader throttles reads so that the average throughput stays below limit bytes per second
+type limitedReader struct {
+	r       io.Reader
+	limit   int64
+	started time.Time
+	read    int64
+}
+
+// newLimitedReader returns r throttled to limit bytes per second, r itself if there is no limit
+func newLimitedReader(r io.Reader, limit int64) io.Reader {
+	if limit <= 0 {
+		return r
+	}
+
+	return &limitedReader{r: r, limit: limit, started: time.Now()}
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	// small chunks keep the throughput even instead of bursting a large buffer and sleeping long
+	if chunk := r.limit / 10; chunk > 0 && int64(len(p)) > chunk {
+		p = p[:chunk]
+	}
+
+	n, err := r.r.Read(p)
+	r.read += int64(n)
+
+	expected := time.Duration(float64(r.read) / float64(r.limit) * float64(time.Second))
+	if wait := expected - time.Since(r.started); wait > 0 {
+		time.Sleep(wait)
+	}
+
+	return n, err
+}
+
+// withReadRate limits how fast ffmpeg reads its file inputs to share ioLimit. ffmpeg limits the read rate as a factor
+// of realtime playback, so the factor is calculated from the average byte rate of each input.
+func withReadRate(st *state, args []string) []string {
+	if st.ioLimit <= 0 || len(args) == 0 || args[0] != "ffmpeg" {
+		return args
+	}
+
+	var inputs []int
+	for i := 1; i < len(args)-1; i++ {
+		if args[i] == "-i" {
+			inputs = append(inputs, i)
+		}
+	}
+	if len(inputs) == 0 {
+		return args
+	}
+
+	limit := float64(st.ioLimit) / float64(len(inputs))
+
+	result := make([]string, 0, len(args)+2*len(inputs))
+	last := 0
+	for _, i := range inputs {
+		result = append(result, args[last:i]...)
+		last = i
+
+		// the inputs of commands shown before running them are limited already
+		if i >= 2 && args[i-2] == "-readrate" {
+			continue
+		}
+
+		path := args[i+1]
+		fi, err := os.Stat(path)
+		if err != nil || fi.IsDir() {
+			continue
+		}
+
+		length, err := getLength(st, pathFileInfo{FileInfo: fi, path: path})
+		if err != nil || length <= 0 || fi.Size() == 0 {
+			continue
+		}
+
+		readRate := limit / (float64(fi.Size()) / length)
+		result = append(result, "-readrate", strconv.FormatFloat(readRate, 'f', 3, 64))
+	}
+
+	return append(result, args[last:]...)
+}
+
+// ioRetryDelay is the time waited before retrying a file failed with a transient I/O error, it doubles with every retry
+var ioRetryDelay = 5 * time.Second
+
+// mountCheckTimeout is the time a directory has to respond in before its mount is considered stale
+var mountCheckTimeout = 10 * time.Second
+
+// transientErrnos are errors of network shares which often go away when retried, e.g. after a reconnect
+var transientErrnos = []syscall.Errno{
+	syscall.EIO,
+	syscall.ESTALE,
+	syscall.ETIMEDOUT,
+	syscall.ENOTCONN,
+	syscall.ECONNRESET,
+	syscall.EHOSTDOWN,
+	syscall.EHOSTUNREACH,
+	syscall.ENETDOWN,
+	syscall.ENETUNREACH,
+}
+
+// transientMessages are the messages external commands like ffmpeg print for the errors in transientErrnos
+var transientMessages = []struct {
+	message string
+	errno   syscall.Errno
+}{
+	{"Input/output error", syscall.EIO},
+	{"Stale file handle", syscall.ESTALE},
+	{"Connection timed out", syscall.ETIMEDOUT},
+	{"Transport endpoint is not connected", syscall.ENOTCONN},
+	{"Connection reset by peer", syscall.ECONNRESET},
+	{"Host is down", syscall.EHOSTDOWN},
+	{"No route to host", syscall.EHOSTUNREACH},
+	{"Network is down", syscall.ENETDOWN},
+	{"Network is unreachable", syscall.ENETUNREACH},
+}
+
+// ExitIOError is returned when an external command, e.g. ffmpeg, exits with an error because of an I/O error it
+// reported. It unwraps to the errno too, so that these runs are retried like the copies and renames of ffr.
+type ExitIOError struct {
+	Err   error
+	Errno syscall.Errno
+}
+
+func (e *ExitIOError) Error() string {
+	return fmt.Sprintf("%s, reported error: %s", e.Err, e.Errno)
+}
+
+func (e *ExitIOError) Unwrap() []error {
+	return []error{e.Err, e.Errno}
+}
+
+// classifyExitError returns an ExitIOError if a command exited with an error and its output reports a transient I/O
+// error, other errors are returned as they are
+func classifyExitError(err error, output string) error {
+	var exitErr *osexec.ExitError
+	if !errors.As(err, &exitErr) {
+		return err
+	}
+
+	for _, transient := range transientMessages {
+		if strings.Contains(output, transient.message) {
+			return &ExitIOError{Err: err, Errno: transient.errno}
+		}
+	}
+
+	return err
+}
+
+func isTransientIOError(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+
+	for _, transient := range transientErrnos {
+		if errno == transient {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkDir lists dir in the background so that stale mounts, on which file system calls hang, are reported instead of
+// blocking the batch
+func checkDir(dir string, timeout time.Duration) error {
+	done := make(chan error, 1)
+	go func() {
+		f, err := os.Open(dir)
+		if err == nil {
+			_, err = f.Readdirnames(1)
+			if err == io.EOF {
+				err = nil
+			}
+			_ = f.Close()
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("directory is not accessible, the mount may be stale. dir: %q, err: %w", dir, err)
+		}
+
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("directory is not responding, the mount may be stale. dir: %q, timeout: %s", dir, timeout)
+	}
+}
+
+// checkMounts checks the directories of the files before a batch is started
+func checkMounts(fileInfoList []os.FileInfo) error {
+	var errs []error
+
+	checked := map[string]bool{}
+	for _, fi := range fileInfoList {
+		dir := filepath.Dir(fi.Name())
+		if checked[dir] {
+			continue
+		}
+		checked[dir] = true
+
+		errs = append(errs, checkDir(dir, mountCheckTimeout))
+	}
+
+	return errors.Join(errs...)
+}
+
+// existingDir returns the closest existing directory of path, e.g. of outputs planned in a dry-run
+func existingDir(path string) string {
+	dir := filepath.Dir(path)
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// mountWarnings contains the pairs of mounts already warned about
+type mountWarnings map[[2]uint64]bool
+
+// check warns once per pair of mounts if a file is written to another mount than the one of its input, as files are
+// moved between mounts by copying them
+func (w mountWarnings) check(st *state, input, output string) {
+	from, ok := deviceID(existingDir(input))
+	if !ok {
+		return
+	}
+
+	to, ok := deviceID(existingDir(output))
+	if !ok || from == to || w[[2]uint64{from, to}] {
+		return
+	}
+	w[[2]uint64{from, to}] = true
+
+	log.Print(colorize(st, colorYellow, fmt.Sprintf("input and output are on different mounts, files are moved between them by copying. input: %q, output: %q", input, output)))
+}
+
+func copyFile(st *state, oldPath, newPath string) error {
+	src, err := os.Open(oldPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	fi, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	dst, err := os.OpenFile(newPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(dst, newLimitedReader(src, st.ioLimit))
+	if err != nil {
+		_ = dst.Close()
+
+		return err
+	}
+
+	err = dst.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Chtimes(newPath, fi.ModTime(), fi.ModTime())
+}
+
+// moveFile renames oldPath to newPath, copying and then removing it if the paths are on different mounts
+func moveFile(st *state, oldPath, newPath string) error {
+	err := os.Rename(oldPath, newPath)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	st.log.Printf("copying file to another mount. old path: %q, new path: %q", oldPath, newPath)
+
+	err = copyFile(st, oldPath, newPath)
+	if err != nil {
+		_ = os.Remove(newPath)
+
+		return err
+	}
+
+	return os.Remove(oldPath)
+}
+
+type renamePair struct {
+	oldPath string
+	newPath string
+}
+
+func recordChange(st *state, oldPath, newPath string) {
+	st.changes = append(st.changes, renamePair{oldPath: oldPath, newPath: newPath})
+}
+
+// recordEncode journals and records a new file or directory created out of an existing file
+func recordEncode(st *state, oldPath, newPath string) {
+	st.journal.Record(journalEncode, oldPath, newPath)
+	recordChange(st, oldPath, newPath)
+	st.outputs = append(st.outputs, newPath)
+}
+
+// ProbeError is returned when ffprobe fails to retrieve information about a file
+type ProbeError struct {
+	Path string
+	Err  error
+}
+
+func (e *ProbeError) Error() string {
+	return fmt.Sprintf("failed to probe file. file: %q, err: %s", e.Path, e.Err)
+}
+
+func (e *ProbeError) Unwrap() error {
+	return e.Err
+}
+
+// ErrNoVideoStream is returned by probes if the file has no video stream, or none with the selected index
+var ErrNoVideoStream = errors.New("no video stream found")
+
+// videoStreamSpecifier returns the ffprobe stream specifier of the selected video stream
+func videoStreamSpecifier(st *state) string {
+	return fmt.Sprintf("v:%d", st.videoStream)
+}
+
+// EncodeError is returned when ffmpeg fails to create a new file out of an existing one
+type EncodeError struct {
+	Path      string
+	Operation string
+	Err       error
+}
+
+func (e *EncodeError) Error() string {
+	return fmt.Sprintf("failed to %s. file: %q, err: %s", e.Operation, e.Path, e.Err)
+}
+
+func (e *EncodeError) Unwrap() error {
+	return e.Err
+}
+
+// RenameCollision is returned when the target of a rename or the output of an encode already exists
+type RenameCollision struct {
+	Path string
+}
+
+func (e *RenameCollision) Error() string {
+	return fmt.Sprintf("file already exists. path: %q", e.Path)
+}
+
+// RootError is returned when a change would escape the root directory
+type RootError struct {
+	Path string
+	Root string
+}
+
+func (e *RootError) Error() string {
+	return fmt.Sprintf("path is outside of the root directory, use --%s to change it. path: %q, root: %q", rootFlag, e.Path, e.Root)
+}
+
+// errorKind returns a human readable name for the type of error, used for grouping failures
+func errorKind(err error) string {
+	var probeErr *ProbeError
+	var encodeErr *EncodeError
+	var collision *RenameCollision
+	var rootErr *RootError
+	var uploadErr *UploadError
+
+	switch {
+	case errors.As(err, &probeErr):
+		return "probe errors"
+	case errors.As(err, &encodeErr):
+		return "encode errors"
+	case errors.As(err, &collision):
+		return "rename collisions"
+	case errors.As(err, &rootErr):
+		return "outside of root"
+	case errors.As(err, &uploadErr):
+		return "upload errors"
+	}
+
+	return "other errors"
+}
+
+// failureSummary groups the files which failed to be processed and their errors by the kind of error
+type failureSummary struct {
+	kinds []string
+	files map[string][]summaryFailure
+}
+
+func (f *failureSummary) Add(path string, err error) {
+	if f.files == nil {
+		f.files = map[string][]summaryFailure{}
+	}
+
+	kind := errorKind(err)
+	if _, ok := f.files[kind]; !ok {
+		f.kinds = append(f.kinds, kind)
+	}
+	f.files[kind] = append(f.files[kind], summaryFailure{File: path, Error: err.Error()})
+}
+
+func (f *failureSummary) Print(st *state) {
+	if len(f.kinds) == 0 {
+		return
+	}
+
+	count := 0
+	for _, files := range f.files {
+		count += len(files)
+	}
+	log.Print(colorize(st, colorRed, fmt.Sprintf("%d file(s) failed.", count)))
+
+	for _, kind := range f.kinds {
+		log.Print(colorize(st, colorRed, fmt.Sprintf("%s (%d):", kind, len(f.files[kind]))))
+		for _, failure := range f.files[kind] {
+			log.Printf("  %s: %s", failure.File, failure.Error)
+		}
+	}
+}
+
+// Err returns an error if any of the files failed so that ffr exits with a non-zero status
+func (f *failureSummary) Err() error {
+	count := 0
+	for _, files := range f.files {
+		count += len(files)
+	}
+
+	if count == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("%d file(s) failed", count)
+}
+
+const (
+	resultJSON = "json"
+
+	resultOK        = "ok"
+	resultUnchanged = "unchanged"
+	resultPlanned   = "planned"
+	resultFailed    = "failed"
+)
+
+// fileResult is the machine-readable result of processing a file
+type fileResult struct {
+	OldPath   string  `json:"oldPath"`
+	NewPath   string  `json:"newPath,omitempty"`
+	Status    string  `json:"status"`
+	Error     string  `json:"error,omitempty"`
+	Duration  float64 `json:"duration"`
+	SizeDelta int64   `json:"sizeDelta"`
+}
+
+func newFileResult(fi os.FileInfo, fileChanges []renamePair, elapsed time.Duration, err error, dryRun bool) fileResult {
+	result := fileResult{
+		OldPath:  fi.Name(),
+		Status:   resultUnchanged,
+		Duration: elapsed.Seconds(),
+	}
+
+	for _, change := range fileChanges {
+		result.NewPath = change.newPath
+	}
+
+	switch {
+	case err != nil:
+		result.Status = resultFailed
+		result.Error = err.Error()
+	case result.NewPath != "" && dryRun:
+		result.Status = resultPlanned
+	case result.NewPath != "":
+		result.Status = resultOK
+	}
+
+	if result.Status == resultOK {
+		if newFi, err := os.Stat(result.NewPath); err == nil {
+			result.SizeDelta = newFi.Size() - fi.Size()
+		}
+	}
+
+	return result
+}
+
+// printResult writes the result as a single JSON line
+func printResult(l *logger, w io.Writer, result fileResult) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		l.Printf("failed to encode result. err: %s", err)
+		return
+	}
+
+	_, _ = fmt.Fprintln(w, string(data))
+}
+
+// checkRoot makes sure that none of the paths escape the root directory
+func checkRoot(st *state, paths ...string) error {
+	if st.rootDir == "" {
+		return nil
+	}
+
+	for _, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return fmt.Errorf("failed to resolve path. path: %q, err: %w", path, err)
+		}
+
+		rel, err := filepath.Rel(st.rootDir, absPath)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return &RootError{Path: path, Root: st.rootDir}
+		}
+	}
+
+	return nil
+}
+
+func planRename(st *state, oldPath, newPath string) {
+	st.log.Printf(`%q -> %q`, oldPath, newPath)
+
+	recordChange(st, oldPath, newPath)
+}
+
+// planEncode records a new file or directory which would be created out of an existing file during a dry-run
+func planEncode(st *state, oldPath, newPath string) {
+	planRename(st, oldPath, newPath)
+
+	st.outputs = append(st.outputs, newPath)
+}
+
+// pathExists checks if a path exists, taking the changes of the current run into account. The changes planned by a
+// dry-run do not exist on the file system, so free paths picked based on the file system only would differ from the
+// ones picked by a real run, where the files created or renamed before already take their paths.
+func pathExists(st *state, path string) bool {
+	created := make(map[string]bool, len(st.outputs))
+	for _, output := range st.outputs {
+		created[output] = true
+	}
+
+	// the last change of a path decides
+	for i := len(st.changes) - 1; i >= 0; i-- {
+		pair := st.changes[i]
+		if pair.newPath == path {
+			return true
+		}
+		if pair.oldPath == path && !created[pair.newPath] {
+			return false
+		}
+	}
+
+	_, err := os.Lstat(path)
+
+	return !os.IsNotExist(err)
+}
+
+// checkCollision returns a RenameCollision if path is taken and may not be overwritten. It takes the changes of the
+// current run into account, so that dry-runs report the collisions a real run would run into.
+func checkCollision(st *state, path string, forceOverwrite bool) error {
+	if forceOverwrite || !pathExists(st, path) {
+		return nil
+	}
+
+	return &RenameCollision{Path: path}
+}
+
+func globEscape(path string) string {
+	r := strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`)
+
+	return r.Replace(path)
+}
+
+// findSidecars finds the existing sidecar files of oldPath and calculates their new paths based on newPath.
+// Sidecars are files like "foo.srt", "foo.mp4.xmp" or "foo.en.srt" for a file called "foo.mp4". Extensions are
+// compared case-insensitively, so that e.g. "IMG_0001.HEIC" is found for "IMG_0001.MOV", and sidecars keep theirs.
+func findSidecars(oldPath, newPath string, extensions []string) []renamePair {
+	if len(extensions) == 0 {
+		return nil
+	}
+
+	dir, oldName := filepath.Split(oldPath)
+	oldBase := strings.TrimSuffix(oldName, filepath.Ext(oldName))
+	newBase := strings.TrimSuffix(newPath, filepath.Ext(newPath))
+
+	entries, err := os.ReadDir(filepath.Dir(oldPath))
+	if err != nil {
+		return nil
+	}
+
+	var pairs []renamePair
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || name == oldName || !hasExtension(name, extensions) {
+			continue
+		}
+
+		ext := filepath.Ext(name)
+		rest := strings.TrimSuffix(name, ext)
+
+		switch {
+		case rest == oldBase:
+			pairs = append(pairs, renamePair{oldPath: dir + name, newPath: newBase + ext})
+		case rest == oldName:
+			pairs = append(pairs, renamePair{oldPath: dir + name, newPath: newPath + ext})
+		case strings.HasPrefix(rest, oldBase+".") && !strings.Contains(rest[len(oldBase)+1:], "."):
+			pairs = append(pairs, renamePair{oldPath: dir + name, newPath: newBase + rest[len(oldBase):] + ext})
+		}
+	}
+
+	return pairs
+}
+
+// renameSidecars renames the sidecars of a renamed file. Sidecars whose new path is taken are left in place and
+// reported as a collision once the others are renamed.
+func renameSidecars(st *state, oldPath, newPath string, forceOverwrite bool) error {
+	var collision error
+	for _, pair := range findSidecars(oldPath, newPath, st.sidecarExtensions) {
+		st.log.Println(pair.oldPath, " -> ", pair.newPath)
+
+		if err := checkCollision(st, pair.newPath, forceOverwrite); err != nil {
+			st.progress.Println(colorize(st, colorYellow, fmt.Sprintf("sidecar already exists, skipping: %q", pair.newPath)))
+			if collision == nil {
+				collision = err
+			}
+
+			continue
+		}
+
+		err := moveFile(st, pair.oldPath, pair.newPath)
+		if err != nil {
+			return fmt.Errorf("failed to rename sidecar. old path: %q, new path: %q, err: %w", pair.oldPath, pair.newPath, err)
+		}
+
+		st.journal.Record(journalRename, pair.oldPath, pair.newPath)
+	}
+
+	return collision
+}
+
+func splitList(list string) []string {
+	var result []string
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			result = append(result, item)
+		}
+	}
+
+	return result
+}
+
+const (
+	sortNone   = "none"
+	sortName   = "name"
+	sortMtime  = "mtime"
+	sortSize   = "size"
+	sortRandom = "random"
+)
+
+// naturalLess compares strings so that numeric parts are compared by their value, e.g. "file2" < "file10"
+func naturalLess(a, b string) bool {
+	ca, cb := splitNatural(strings.ToLower(a)), splitNatural(strings.ToLower(b))
+
+	for i := 0; i < len(ca) && i < len(cb); i++ {
+		x, y := ca[i], cb[i]
+		if x == y {
+			continue
+		}
+
+		if isDigit(x[0]) && isDigit(y[0]) {
+			tx, ty := strings.TrimLeft(x, "0"), strings.TrimLeft(y, "0")
+			if len(tx) != len(ty) {
+				return len(tx) < len(ty)
+			}
+			if tx != ty {
+				return tx < ty
+			}
+
+			// equal values, fewer leading zeros first
+			return len(x) < len(y)
+		}
+
+		return x < y
+	}
+
+	if len(ca) != len(cb) {
+		return len(ca) < len(cb)
+	}
+
+	return a < b
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
+// splitNatural splits a string into alternating chunks of digits and non-digits
+func splitNatural(s string) []string {
+	var chunks []string
+
+	start := 0
+	for i := 1; i <= len(s); i++ {
+		if i == len(s) || isDigit(s[i]) != isDigit(s[start]) {
+			chunks = append(chunks, s[start:i])
+			start = i
+		}
+	}
+
+	return chunks
+}
+
+func sortFileInfoList(fileInfoList []os.FileInfo, sortBy string) error {
+	switch sortBy {
+	case sortNone, "":
+	case sortName:
+		sort.SliceStable(fileInfoList, func(i, j int) bool {
+			return naturalLess(fileInfoList[i].Name(), fileInfoList[j].Name())
+		})
+	case sortMtime:
+		sort.SliceStable(fileInfoList, func(i, j int) bool {
+			return fileInfoList[i].ModTime().Before(fileInfoList[j].ModTime())
+		})
+	case sortSize:
+		sort.SliceStable(fileInfoList, func(i, j int) bool {
+			return fileInfoList[i].Size() < fileInfoList[j].Size()
+		})
+	case sortRandom:
+		rand.Shuffle(len(fileInfoList), func(i, j int) {
+			fileInfoList[i], fileInfoList[j] = fileInfoList[j], fileInfoList[i]
+		})
+	default:
+		return fmt.Errorf("invalid sort order. sort: %s", sortBy)
+	}
+
+	return nil
+}
+
+const (
+	orderShortestFirst = "shortest-first"
+	orderLongestFirst  = "longest-first"
+	orderSmallestFirst = "smallest-first"
+	orderLargestFirst  = "largest-first"
+)
+
+// orderFileInfoList reorders the encode queue by size or duration. Files of unknown length are encoded last.
+func orderFileInfoList(l *logger, fileInfoList []os.FileInfo, order string, lengthOf func(fi os.FileInfo) (float64, error)) error {
+	switch order {
+	case "":
+	case orderSmallestFirst:
+		sort.SliceStable(fileInfoList, func(i, j int) bool {
+			return fileInfoList[i].Size() < fileInfoList[j].Size()
+		})
+	case orderLargestFirst:
+		sort.SliceStable(fileInfoList, func(i, j int) bool {
+			return fileInfoList[i].Size() > fileInfoList[j].Size()
+		})
+	case orderShortestFirst, orderLongestFirst:
+		lengths := make(map[string]float64, len(fileInfoList))
+		for _, fi := range fileInfoList {
+			length, err := lengthOf(fi)
+			if err != nil {
+				l.Printf("failed to get length, encoding it last. file: %q, err: %s", fi.Name(), err)
+				length = -1
+			}
+			lengths[fi.Name()] = length
+		}
+
+		sort.SliceStable(fileInfoList, func(i, j int) bool {
+			a, b := lengths[fileInfoList[i].Name()], lengths[fileInfoList[j].Name()]
+			if a < 0 || b < 0 {
+				return b < 0 && a >= 0
+			}
+			if order == orderLongestFirst {
+				return a > b
+			}
+
+			return a < b
+		})
+	default:
+		return fmt.Errorf("invalid order. order: %s", order)
+	}
+
+	return nil
+}
+
+var defaultVideoExtensions = []string{"mp4", "mkv", "mov", "avi", "wmv", "webm", "m4v", "mpg", "mpeg", "flv", "ts", "m2ts", "3gp"}
+
+// commandExtensions contains the extensions listed by default in directories by commands working on non-video files
+var commandExtensions = map[string][]string{
+	reencodeAudioCommand:    audioExtensions,
+	convertImageCommand:     imageExtensions,
+	framesImportCommand:     imageExtensions,
+	infoCommand:             append(append([]string{}, defaultVideoExtensions...), imageExtensions...),
+	insertDimensionsCommand: append(append([]string{}, defaultVideoExtensions...), imageExtensions...),
+	langTagCommand:          append(append([]string{}, defaultVideoExtensions...), audioExtensions...),
+	streamHashCommand:       append(append([]string{}, defaultVideoExtensions...), audioExtensions...),
+	contentIDCommand:        append(append([]string{}, defaultVideoExtensions...), audioExtensions...),
+}
+
+// partialExtensions are the extensions used by downloaders and editors for files which are not complete yet
+var partialExtensions = []string{"part", "partial", "tmp", "temp", "crdownload", "download", "opdownload", "ffr-tmp"}
+
+// isPartialFile checks if a file is likely to be still in progress. Growing files are detected by --settle.
+func isPartialFile(fi os.FileInfo) bool {
+	return fi.Size() == 0 || hasExtension(fi.Name(), partialExtensions)
+}
+
+func hasExtension(filePath string, extensions []string) bool {
+	ext := strings.TrimPrefix(filepath.Ext(filePath), ".")
+	for _, e := range extensions {
+		if strings.EqualFold(ext, strings.TrimPrefix(e, ".")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pathFileInfo is an os.FileInfo returning the path it was found at as its name, so that files outside of the
+// working directory can be processed
+type pathFileInfo struct {
+	os.FileInfo
+	path string
+}
+
+func (fi pathFileInfo) Name() string {
+	return fi.path
+}
+
+func withPath(fi os.FileInfo, filePath string) os.FileInfo {
+	if fi.Name() == filePath {
+		return fi
+	}
+
+	return pathFileInfo{FileInfo: fi, path: filePath}
+}
+
+// getDirFileInfoList lists the files of a directory, non-recursively. If no extensions are allowed explicitly, only
+// files the command works with, usually videos, are listed.
+func getDirFileInfoList(st *state, dir string) ([]os.FileInfo, error) {
+	extensions := st.allowedExtensions
+	if len(extensions) == 0 {
+		extensions = st.defaultExtensions
+	}
+
+	return listDirFiles(st, dir, extensions)
+}
+
+// listDirFiles lists the files of a directory having one of the given extensions, non-recursively
+func listDirFiles(st *state, dir string, extensions []string) ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileInfoList []os.FileInfo
+	for _, entry := range entries {
+		filePath := filepath.Join(dir, entry.Name())
+
+		if st.skipSymlinks && entry.Type()&os.ModeSymlink != 0 {
+			st.log.Printf("skipping symbolic link: %q", filePath)
+
+			continue
+		}
+
+		if !hasExtension(filePath, extensions) {
+			continue
+		}
+
+		fi, err := os.Stat(filePath)
+		if err != nil {
+			return nil, err
+		}
+
+		if fi.IsDir() {
+			continue
+		}
+
+		st.log.Printf("file is okay: %q", filePath)
+
+		fileInfoList = append(fileInfoList, withPath(fi, followSymlink(st, filePath)))
+	}
+
+	return fileInfoList, nil
+}
+
+// fileFilter decides whether a file should be processed
+type fileFilter func(fi os.FileInfo) (bool, error)
+
+var filterRegexp = regexp.MustCompile(`^([a-z]+)\s*(=|!=|>=|<=|>|<)\s*(.+)$`)
+
+func compareInts(a int, operator string, b int) bool {
+	switch operator {
+	case "=":
+		return a == b
+	case "!=":
+		return a != b
+	case ">=":
+		return a >= b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case "<":
+		return a < b
+	}
+
+	return false
+}
+
+// parseFilter parses filter expressions like "label=todo-encode" or "rating>=4"
+func parseFilter(expr string) (fileFilter, error) {
+	m := filterRegexp.FindStringSubmatch(strings.TrimSpace(expr))
+	if m == nil {
+		return nil, fmt.Errorf("invalid filter. filter: %q", expr)
+	}
+
+	key, operator, value := m[1], m[2], m[3]
+
+	switch key {
+	case "rating":
+		want, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rating in filter. filter: %q, err: %w", expr, err)
+		}
+
+		return func(fi os.FileInfo) (bool, error) {
+			return compareInts(getRating(fi.Name()), operator, want), nil
+		}, nil
+	case "label":
+		if operator != "=" && operator != "!=" {
+			return nil, fmt.Errorf("invalid operator for labels. filter: %q", expr)
+		}
+
+		return func(fi os.FileInfo) (bool, error) {
+			labels, err := getLabels(fi.Name())
+			if err != nil {
+				return false, err
+			}
+
+			return containsString(labels, value) == (operator == "="), nil
+		}, nil
+	}
+
+	return nil, fmt.Errorf("invalid filter key. filter: %q, key: %s", expr, key)
+}
+
+func matchesFilters(l *logger, fi os.FileInfo, filters []fileFilter) bool {
+	for _, filter := range filters {
+		ok, err := filter(fi)
+		if err != nil {
+			l.Printf("failed to apply filter. file: %q, err: %s", fi.Name(), err)
+
+			return false
+		}
+
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// statJobs is the number of arguments checked at the same time when collecting the files to process
+const statJobs = 16
+
+type statResult struct {
+	fi      os.FileInfo
+	symlink bool
+	err     error
+}
+
+// statAll stats the paths concurrently, the results are in the order of the paths
+func statAll(st *state, filePaths []string) []statResult {
+	results := make([]statResult, len(filePaths))
+
+	work := make(chan int)
+	wg := sync.WaitGroup{}
+	for i := 0; i < statJobs && i < len(filePaths); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range work {
+				if st.skipSymlinks && isSymlink(filePaths[i]) {
+					results[i].symlink = true
+
+					continue
+				}
+
+				results[i].fi, results[i].err = os.Stat(filePaths[i])
+			}
+		}()
+	}
+
+	for i := range filePaths {
+		work <- i
+	}
+	close(work)
+
+	wg.Wait()
+
+	return results
+}
+
+func getFileInfoList(st *state, filePaths []string, sortBy string, backwardsFlag bool) ([]os.FileInfo, error) {
+	if len(filePaths) == 0 {
+		return nil, errors.New("no files provided")
+	}
+
+	var (
+		fileInfoList []os.FileInfo
+		invalid      int
+		missing      int
+	)
+
+	filePaths = expandArgs(st.log, filePaths)
+	for i, result := range statAll(st, filePaths) {
+		filePath := filePaths[i]
+
+		if result.symlink {
+			st.log.Printf("skipping symbolic link: %q", filePath)
+
+			continue
+		}
+
+		fi, err := result.fi, result.err
+		if os.IsNotExist(err) && st.skipMissing {
+			st.progress.Println(colorize(st, colorYellow, fmt.Sprintf("skipping missing file: %q", filePath)))
+			st.skippedArgs = append(st.skippedArgs, filePath)
+
+			continue
+		}
+		if err != nil {
+			log.Print(colorize(st, colorRed, fmt.Sprintf("argument is not a file: %q, err: %s", filePath, err)))
+			invalid++
+			if os.IsNotExist(err) {
+				missing++
+			}
+
+			continue
+		}
+
+		if fi.IsDir() {
+			dirList, err := getDirFileInfoList(st, filePath)
+			if err != nil {
+				log.Print(colorize(st, colorRed, fmt.Sprintf("failed to list directory: %q, err: %s", filePath, err)))
+				invalid++
+
+				continue
+			}
+
+			fileInfoList = append(fileInfoList, dirList...)
+
+			continue
+		}
+
+		if len(st.allowedExtensions) > 0 && !hasExtension(filePath, st.allowedExtensions) {
+			st.log.Printf("skipping file, extension is not allowed: %q", filePath)
+
+			continue
+		}
+
+		st.log.Printf("file is okay: %q", filePath)
+
+		fileInfoList = append(fileInfoList, withPath(fi, followSymlink(st, filePath)))
+	}
+
+	if len(st.fileFilters) > 0 {
+		var filtered []os.FileInfo
+		for _, fi := range fileInfoList {
+			if !matchesFilters(st.log, fi, st.fileFilters) {
+				st.log.Printf("skipping file, filters do not match: %q", fi.Name())
+
+				continue
+			}
+
+			filtered = append(filtered, fi)
+		}
+		fileInfoList = filtered
+	}
+
+	if st.skipPartial {
+		var complete []os.FileInfo
+		for _, fi := range fileInfoList {
+			if isPartialFile(fi) {
+				st.progress.Println(colorize(st, colorYellow, fmt.Sprintf("skipping partial file, use --%s to process it: %q", includePartialFlag, fi.Name())))
+
+				continue
+			}
+
+			complete = append(complete, fi)
+		}
+		fileInfoList = complete
+	}
+
+	if invalid > 0 {
+		if missing > 0 {
+			return nil, fmt.Errorf("invalid arguments, use --%s to skip missing files. invalid: %d, missing: %d", skipMissingFlag, invalid, missing)
+		}
+
+		return nil, fmt.Errorf("invalid arguments. invalid: %d", invalid)
+	}
+
+	err := sortFileInfoList(fileInfoList, sortBy)
+	if err != nil {
+		return nil, err
+	}
+
+	if backwardsFlag {
+		var fis2 []os.FileInfo
+		for i := len(fileInfoList) - 1; i >= 0; i-- {
+			fis2 = append(fis2, fileInfoList[i])
+		}
+		fileInfoList = fis2
+	}
+
+	return fileInfoList, nil
+}
+
+// printSkipped prints the missing arguments skipped by --skip-missing
+func printSkipped(st *state) {
+	if len(st.skippedArgs) == 0 {
+		return
+	}
+
+	log.Print(colorize(st, colorYellow, fmt.Sprintf("%d missing file(s) skipped:", len(st.skippedArgs))))
+	for _, path := range st.skippedArgs {
+		log.Printf("  %s", path)
+	}
+}
+
+var braceRangeRegexp = regexp.MustCompile(`^(-?\d+)\.\.(-?\d+)$`)
+
+// expandArgs expands brace expressions (ep{01..10}.mkv, {foo,bar}.mp4) and glob patterns (*.mp4) of arguments not
+// matching an existing file, so that command lines behave the same in shells without globbing (e.g. Windows cmd).
+// Patterns matching nothing are kept as they are.
+func expandArgs(l *logger, args []string) []string {
+	var result []string
+	for _, arg := range args {
+		if _, err := os.Lstat(arg); err == nil {
+			result = append(result, arg)
+
+			continue
+		}
+
+		for _, expanded := range expandBraces(arg) {
+			matches, err := filepath.Glob(expanded)
+			if err != nil || len(matches) == 0 {
+				result = append(result, expanded)
+
+				continue
+			}
+
+			l.Printf("pattern expanded: %q -> %d files", expanded, len(matches))
+
+			result = append(result, matches...)
+		}
+	}
+
+	return result
+}
+
+// expandBraces expands the first brace expression of s recursively, expressions can be comma separated lists
+// ({a,b,c}) or numeric ranges ({1..10}) which keep the zero padding of their bounds ({01..10})
+func expandBraces(s string) []string {
+	start := strings.Index(s, "{")
+	if start < 0 {
+		return []string{s}
+	}
+
+	end, depth := -1, 0
+	var commas []int
+	for i := start; i < len(s) && end < 0; i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				end = i
+			}
+		case ',':
+			if depth == 1 {
+				commas = append(commas, i)
+			}
+		}
+	}
+
+	if end < 0 {
+		return []string{s}
+	}
+
+	prefix, body, suffix := s[:start], s[start+1:end], s[end+1:]
+
+	var parts []string
+	switch {
+	case len(commas) > 0:
+		last := start + 1
+		for _, i := range commas {
+			parts = append(parts, s[last:i])
+			last = i + 1
+		}
+		parts = append(parts, s[last:end])
+	case braceRangeRegexp.MatchString(body):
+		parts = expandBraceRange(body)
+	default:
+		// not an expression, the brace is kept and the rest of the string is expanded
+		var result []string
+		for _, rest := range expandBraces(suffix) {
+			result = append(result, s[:end+1]+rest)
+		}
+
+		return result
+	}
+
+	var result []string
+	for _, part := range parts {
+		result = append(result, expandBraces(prefix+part+suffix)...)
+	}
+
+	return result
+}
+
+func expandBraceRange(body string) []string {
+	m := braceRangeRegexp.FindStringSubmatch(body)
+
+	from, _ := strconv.Atoi(m[1])
+	to, _ := strconv.Atoi(m[2])
+
+	// like in bash, bounds with leading zeros make all numbers padded to the same width
+	width := 0
+	for _, bound := range m[1:] {
+		digits := strings.TrimPrefix(bound, "-")
+		if len(digits) > 1 && digits[0] == '0' {
+			width = len(m[1])
+			if len(m[2]) > width {
+				width = len(m[2])
+			}
+		}
+	}
+
+	step := 1
+	if from > to {
+		step = -1
+	}
+
+	var parts []string
+	for i := from; ; i += step {
+		parts = append(parts, fmt.Sprintf("%0*d", width, i))
+		if i == to {
+			break
+		}
+	}
+
+	return parts
+}
+
+// config contains the user settings stored in the config file
+type config struct {
+	FilterPresets map[string]string `json:"filterPresets,omitempty"`
+	Macros        map[string]macro  `json:"macros,omitempty"`
+}
+
+// macro is a list of ffr command lines run one after the other on the same files, it can be defined in the config
+// as a single command line or as a list of them, e.g. "reencode --codec libx265 --crf 24"
+type macro []string
+
+func (m *macro) UnmarshalJSON(data []byte) error {
+	var line string
+	if json.Unmarshal(data, &line) == nil {
+		*m = macro{line}
+
+		return nil
+	}
+
+	var lines []string
+	err := json.Unmarshal(data, &lines)
+	if err != nil {
+		return fmt.Errorf("macro must be a command line or a list of command lines. err: %w", err)
+	}
+
+	*m = lines
+
+	return nil
+}
+
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "ffr", "config.json")
+}
+
+// readConfig reads the config file, a missing config file results in an empty config
+func readConfig(path string) (config, error) {
+	cfg := config{}
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read config. path: %q, err: %w", path, err)
+	}
+
+	err = json.Unmarshal(data, &cfg)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to parse config. path: %q, err: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// configure resets the state of the app and sets it up based on the global flags, the state is shared by all commands of
+// an invocation
+func configure(st *state, c *cli.Context) error {
+	dryRun := c.Bool(dryRunFlag)
+
+	quiet := c.Bool(quietFlag)
+
+	// invocations of the same app, e.g. the steps of a macro, share the logger but nothing else
+	lg := st.log
+	*st = *newState()
+	st.log = lg
+	st.log.reset(!(c.Bool(verboseFlag) || (dryRun && !quiet)))
+	st.progress = newLogger(quiet)
+
+	var err error
+	st.useColor, err = shouldUseColor(c.String(colorFlag), c.Bool(noColorFlag), os.Stderr)
+	if err != nil {
+		return err
+	}
+	root := c.String(rootFlag)
+	if root == "" {
+		root = "."
+	}
+
+	st.rootDir, err = filepath.Abs(root)
+	if err != nil {
+		return fmt.Errorf("failed to resolve root directory. root: %q, err: %w", root, err)
+	}
+
+	st.sidecarExtensions = splitList(c.String(withSidecarsFlag))
+	st.followSymlinks = c.Bool(followSymlinksFlag)
+	st.skipSymlinks = c.Bool(noFollowFlag)
+	st.linkMode = c.Bool(linkFlag)
+	st.allowedExtensions = splitList(c.String(extFlag))
+	st.skipPartial = !c.Bool(includePartialFlag)
+	st.skipMissing = c.Bool(skipMissingFlag)
+
+	st.unitSystem = c.String(unitsFlag)
+	if st.unitSystem != unitsSI && st.unitSystem != unitsIEC {
+		return fmt.Errorf("invalid unit system. units: %s", st.unitSystem)
+	}
+	st.resultFormat = c.String(resultFlag)
+	st.printCommands = c.Bool(printCommandFlag)
+
+	st.ignoreRotation = c.Bool(ignoreRotationFlag)
+
+	if timezone := c.String(timezoneFlag); timezone != "" {
+		st.dateLocation, err = time.LoadLocation(timezone)
+		if err != nil {
+			return fmt.Errorf("invalid timezone. timezone: %s, err: %w", timezone, err)
+		}
+	}
+
+	st.ioLimit, err = parseIOLimit(c.String(ioLimitFlag))
+	if err != nil {
+		return err
+	}
+
+	st.workspace = newWorkspace(c.String(tempDirFlag))
+
+	st.ioRetries = c.Int(ioRetriesFlag)
+	if st.ioRetries < 0 {
+		return fmt.Errorf("invalid number of I/O retries. retries: %d", st.ioRetries)
+	}
+
+	st.jobs = c.Int(jobsFlag)
+	if st.jobs < 1 {
+		return fmt.Errorf("invalid number of jobs. jobs: %d", st.jobs)
+	}
+
+	st.videoStream = c.Int(streamFlag)
+	if st.videoStream < 0 {
+		return fmt.Errorf("invalid stream index. stream: %d", st.videoStream)
+	}
+	if st.resultFormat != "" && st.resultFormat != resultJSON {
+		return fmt.Errorf("invalid result format. result: %s", st.resultFormat)
+	}
+	st.rawSeconds = c.Bool(secondsFlag)
+	st.unitPrecision = c.Int(precisionFlag)
+	if st.unitPrecision < 0 {
+		return fmt.Errorf("invalid precision. precision: %d", st.unitPrecision)
+	}
+
+	st.commandLogDir = c.String(saveLogsFlag)
+	if extensions, ok := commandExtensions[c.Command.Name]; ok {
+		st.defaultExtensions = extensions
+	}
+
+	for _, expr := range c.StringSlice(filterFlag) {
+		filter, err := parseFilter(expr)
+		if err != nil {
+			return err
+		}
+
+		st.fileFilters = append(st.fileFilters, filter)
+	}
+
+	st.config, err = readConfig(c.String(configFlag))
+	if err != nil {
+		return err
+	}
+
+	if st.commandLogDir != "" {
+		err := os.MkdirAll(st.commandLogDir, 0755)
+		if err != nil {
+			return fmt.Errorf("failed to create log directory. dir: %q, err: %w", st.commandLogDir, err)
+		}
+	}
+
+	if !dryRun {
+		st.journal = newJournal(st.log, c.String(journalFlag), c.Command.Name)
+
+		if st.journal == nil && c.Bool(atomicFlag) {
+			return errors.New("atomic runs need the journal to roll back, set --journal")
+		}
+
+		// temporary directories are only swept once the command line is known to be valid
+		sweepTemp(st.log, os.TempDir(), st.workspace.base)
+	}
+
+	return nil
+}
+
+const lockFileName = ".ffr.lock"
+
+// readOnlyCommands are the commands which never change files, so they need neither locks nor writable files
+var readOnlyCommands = map[string]bool{
+	infoCommand:         true,
+	statsCommand:        true,
+	efficiencyCommand:   true,
+	similarNamesCommand: true,
+	streamHashCommand:   true,
+	checkNewCommand:     true,
+	keyFramesCommand:    true,
+	labelListCommand:    true,
+}
+
+// isReadOnly checks if the command run never changes files, lint-names only changes them when it fixes the names
+func isReadOnly(c *cli.Context) bool {
+	if c.Command.Name == lintNamesCommand {
+		return !c.Bool(fixFlag)
+	}
+
+	return readOnlyCommands[c.Command.Name]
+}
+
+// needsWritableFiles checks if the command run renames or replaces its files in place. Encoding commands write new
+// files next to theirs, except for cleanup-recordings which renames them, and labels and language tags are not stored
+// in the files they describe.
+func needsWritableFiles(c *cli.Context) bool {
+	switch c.Command.Name {
+	case cleanupRecordingsCommand:
+		return true
+	case labelAddCommand, labelRemoveCommand, langTagCommand:
+		return false
+	}
+
+	return !isEncodingCommand(c)
+}
+
+// isProcessAlive checks if a process with the given pid is still running
+func isProcessAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	return p.Signal(syscall.Signal(0)) == nil
+}
+
+// tempSuffix marks the temporary directories of ffr so that the ones left behind by crashed runs can be swept
+const tempSuffix = ".ffr-tmp"
+
+// tempRegexp matches the temporary directories of ffr, the first group is the pid of the run which created them
+var tempRegexp = regexp.MustCompile(`^\.ffr-(\d+)-.*\.ffr-tmp$`)
+
+// workspace manages the temporary directories of a run, intermediate files like two-pass logs and file lists are
+// created in them. There is one directory per base directory, named after the pid of the run, so that parallel runs
+// don't share them.
+type workspace struct {
+	lock *sync.Mutex
+	base string
+	dirs map[string]string
+}
+
+func newWorkspace(base string) *workspace {
+	return &workspace{
+		lock: &sync.Mutex{},
+		base: base,
+		dirs: map[string]string{},
+	}
+}
+
+// baseDir returns the directory to create temporary files for near in. If no base directory is configured, the
+// system's temporary directory is used, unless it is on another file system than near, so that moving intermediate
+// files next to near is a rename instead of a copy.
+func (w *workspace) baseDir(near string) string {
+	if w.base != "" {
+		return w.base
+	}
+
+	base := os.TempDir()
+	if near == "" {
+		return base
+	}
+
+	dir := existingDir(near)
+	from, ok1 := deviceID(base)
+	to, ok2 := deviceID(dir)
+	if ok1 && ok2 && from != to {
+		return dir
+	}
+
+	return base
+}
+
+func (w *workspace) dir(near string) (string, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	base := w.baseDir(near)
+	if dir, ok := w.dirs[base]; ok {
+		return dir, nil
+	}
+
+	err := os.MkdirAll(base, 0755)
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary directory. dir: %q, err: %w", base, err)
+	}
+
+	dir, err := os.MkdirTemp(base, fmt.Sprintf(".ffr-%d-*%s", os.Getpid(), tempSuffix))
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary directory. dir: %q, err: %w", base, err)
+	}
+	w.dirs[base] = dir
+
+	return dir, nil
+}
+
+// CreateTemp creates a temporary file for near, see os.CreateTemp for the pattern. near may be empty.
+func (w *workspace) CreateTemp(near, pattern string) (*os.File, error) {
+	dir, err := w.dir(near)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.CreateTemp(dir, pattern)
+}
+
+// MkdirTemp creates a temporary directory for near, see os.MkdirTemp for the pattern. near may be empty.
+func (w *workspace) MkdirTemp(near, pattern string) (string, error) {
+	dir, err := w.dir(near)
+	if err != nil {
+		return "", err
+	}
+
+	return os.MkdirTemp(dir, pattern)
+}
+
+// Cleanup removes the temporary directories of the run
+func (w *workspace) Cleanup() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	var errs []error
+	for base, dir := range w.dirs {
+		err := os.RemoveAll(dir)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove temporary directory. dir: %q, err: %w", dir, err))
+		}
+		delete(w.dirs, base)
+	}
+
+	return errors.Join(errs...)
+}
+
+// sweepTemp removes the temporary directories left behind in dirs by runs which are not alive anymore
+func sweepTemp(l *logger, dirs ...string) {
+	swept := map[string]bool{}
+	for _, dir := range dirs {
+		if dir == "" || swept[dir] {
+			continue
+		}
+		swept[dir] = true
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		for _, entry := range entries {
+			m := tempRegexp.FindStringSubmatch(entry.Name())
+			if m == nil {
+				continue
+			}
+
+			pid, _ := strconv.Atoi(m[1])
+			if pid == os.Getpid() || isProcessAlive(pid) {
+				continue
+			}
+
+			path := filepath.Join(dir, entry.Name())
+			l.Printf("removing orphaned temporary directory. path: %q", path)
+			_ = os.RemoveAll(path)
+		}
+	}
+}
+
+// lockDirs creates a lock file in each directory of the files so that concurrent runs don't race on the same files.
+// Lock files of runs which are not alive anymore are removed.
+func lockDirs(l *logger, fileInfoList []os.FileInfo) (func(), error) {
+	var locks []string
+	unlock := func() {
+		for _, lock := range locks {
+			_ = os.Remove(lock)
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, fi := range fileInfoList {
+		dir := filepath.Dir(fi.Name())
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+
+		lock := filepath.Join(dir, lockFileName)
+
+		f, err := os.OpenFile(lock, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			data, _ := os.ReadFile(lock)
+			pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+			if pid > 0 && isProcessAlive(pid) {
+				unlock()
+
+				return nil, fmt.Errorf("directory is locked by another run. dir: %q, pid: %d", dir, pid)
+			}
+
+			l.Printf("removing stale lock. path: %q", lock)
+			_ = os.Remove(lock)
+
+			f, err = os.OpenFile(lock, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		}
+		if err != nil {
+			unlock()
+
+			return nil, fmt.Errorf("failed to lock directory, is it writable? dir: %q, err: %w", dir, err)
+		}
+
+		_, err = fmt.Fprintf(f, "%d\n", os.Getpid())
+		_ = f.Close()
+		locks = append(locks, lock)
+		if err != nil {
+			unlock()
+
+			return nil, fmt.Errorf("failed to write lock. path: %q, err: %w", lock, err)
+		}
+	}
+
+	return unlock, nil
+}
+
+// checkFiles skips files which are read-only, if writable files are needed, or which are still being written to, i.e.
+// their size or modification time changes during the settle duration
+func checkFiles(st *state, fileInfoList []os.FileInfo, needWritable bool, settle time.Duration) []os.FileInfo {
+	var result []os.FileInfo
+	for _, fi := range fileInfoList {
+		if needWritable && fi.Mode().Perm()&0200 == 0 {
+			st.progress.Println(colorize(st, colorYellow, fmt.Sprintf("skipping read-only file: %q", fi.Name())))
+
+			continue
+		}
+
+		result = append(result, fi)
+	}
+
+	if settle <= 0 {
+		return result
+	}
+
+	time.Sleep(settle)
+
+	stable := result[:0]
+	for _, fi := range result {
+		current, err := os.Stat(fi.Name())
+		if err != nil || current.Size() != fi.Size() || !current.ModTime().Equal(fi.ModTime()) {
+			st.progress.Println(colorize(st, colorYellow, fmt.Sprintf("skipping file being written to: %q", fi.Name())))
+
+			continue
+		}
+
+		stable = append(stable, fi)
+	}
+
+	return stable
+}
+
+// prepareFiles checks and locks the files before a command changes them
+func prepareFiles(st *state, c *cli.Context, fileInfoList []os.FileInfo) ([]os.FileInfo, func(), error) {
+	if c.Bool(dryRunFlag) || isReadOnly(c) {
+		return fileInfoList, func() {}, nil
+	}
+
+	fileInfoList = checkFiles(st, fileInfoList, needsWritableFiles(c), c.Duration(settleFlag))
+
+	unlock, err := lockDirs(st.log, fileInfoList)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return fileInfoList, unlock, nil
+}
+
+// splitPassThrough splits the file arguments at "--", the arguments after it are passed on to the command
+func splitPassThrough(args []string) ([]string, []string) {
+	for i, arg := range args {
+		if arg == "--" {
+			return args[:i], args[i+1:]
+		}
+	}
+
+	return args, nil
+}
+
+var argsUsageRegexp = regexp.MustCompile(`\[([^\]]+)\]`)
+
+// missingArgumentError returns an error naming the first missing argument of the command based on its ArgsUsage
+func missingArgumentError(c *cli.Context, given int) error {
+	usage := strings.SplitN(c.Command.ArgsUsage, "\n", 2)[0]
+
+	names := argsUsageRegexp.FindAllStringSubmatch(usage, -1)
+	if given >= len(names) {
+		return errors.New("not enough arguments")
+	}
+
+	return fmt.Errorf("missing argument: %s. usage: %s %s", names[given][1], c.Command.HelpName, usage)
+}
+
+// findFlag returns the flag arg refers to (e.g. --codec, -c or --codec=libx265), nil if arg is not one of flags
+func findFlag(flags []cli.Flag, arg string) cli.Flag {
+	if len(arg) < 2 || arg[0] != '-' {
+		return nil
+	}
+
+	name := strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+	name = strings.SplitN(name, "=", 2)[0]
+	for _, flag := range flags {
+		if containsString(flag.Names(), name) {
+			return flag
+		}
+	}
+
+	return nil
+}
+
+// flagArgCount returns the number of arguments a flag found at the beginning of args uses
+func flagArgCount(flag cli.Flag, args []string) int {
+	if f, ok := flag.(cli.DocGenerationFlag); ok && !f.TakesValue() {
+		return 1
+	}
+
+	if strings.Contains(args[0], "=") || len(args) < 2 {
+		return 1
+	}
+
+	return 2
+}
+
+// reorderArgs moves the flags given after the positional arguments of a command in front of them, as urfave/cli stops
+// parsing flags at the first positional argument, so that e.g. "ffr reencode *.mp4 --codec libx265" works. Global
+// flags given after the command are moved in front of the command, arguments after "--" are left untouched.
+func reorderArgs(app *cli.App, args []string) []string {
+	if len(args) < 2 {
+		return args
+	}
+
+	var global, commandPath, commandFlags, positional []string
+
+	commands := app.Commands
+	var command *cli.Command
+	i := 1
+	for i < len(args) {
+		if flag := findFlag(app.Flags, args[i]); flag != nil {
+			n := flagArgCount(flag, args[i:])
+			global = append(global, args[i:i+n]...)
+			i += n
+
+			continue
+		}
+
+		var found *cli.Command
+		for _, cmd := range commands {
+			if cmd.HasName(args[i]) {
+				found = cmd
+			}
+		}
+		if found == nil {
+			break
+		}
+
+		command = found
+		commandPath = append(commandPath, args[i])
+		commands = found.Subcommands
+		i++
+	}
+
+	if command == nil {
+		return args
+	}
+
+	for i < len(args) {
+		arg := args[i]
+		if arg == "--" {
+			positional = append(positional, args[i:]...)
+
+			break
+		}
+
+		if flag := findFlag(command.Flags, arg); flag != nil {
+			n := flagArgCount(flag, args[i:])
+			commandFlags = append(commandFlags, args[i:i+n]...)
+			i += n
+
+			continue
+		}
+
+		if flag := findFlag(app.Flags, arg); flag != nil {
+			n := flagArgCount(flag, args[i:])
+			global = append(global, args[i:i+n]...)
+			i += n
+
+			continue
+		}
+
+		positional = append(positional, arg)
+		i++
+	}
+
+	result := []string{args[0]}
+	result = append(result, global...)
+	result = append(result, commandPath...)
+	result = append(result, commandFlags...)
+
+	return append(result, positional...)
+}
+
+func process(st *state, c *cli.Context, argCount int, fn func(*cli.Context, []string, os.FileInfo, bool) error) error {
+	args := c.Args().Slice()
+	dryRun := c.Bool(dryRunFlag)
+
+	err := configure(st, c)
+	if err != nil {
+		return err
+	}
+	defer exportLogHistory(st.log, c.String(logHistoryFlag))
+
+	if argCount > len(args) {
+		return missingArgumentError(c, len(args))
+	}
+
+	recordInvocation(st.log, c.String(commandHistoryFlag), args, argCount)
+
+	filePaths, passThrough := splitPassThrough(args[argCount:])
+
+	fileInfoList, err := getFileInfoList(st, filePaths, c.String(sortFlag), c.Bool(backwardsFlag))
+	if err != nil {
+		return err
+	}
+	for _, fi := range fileInfoList {
+		st.log.Printf("file found: %q", fi.Name())
+	}
+
+	args = append(args[:argCount:argCount], passThrough...)
+
+	fileInfoList, unlock, err := prepareFiles(st, c, fileInfoList)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	up, err := newUploader(c.String(uploadFlag), c.Int(uploadJobsFlag), c.Int(uploadRetriesFlag), c.Bool(uploadDeleteFlag))
+	if err != nil {
+		return err
+	}
+
+	err = checkMounts(fileInfoList)
+	if err != nil {
+		return err
+	}
+
+	if !dryRun {
+		var dirs []string
+		for _, fi := range fileInfoList {
+			dirs = append(dirs, filepath.Dir(fi.Name()))
+		}
+		sweepTemp(st.log, dirs...)
+	}
+
+	if prefetcher, ok := prefetchers[c.Command.Name]; ok {
+		prefetch(st, fileInfoList, st.jobs, prefetcher(st, c))
+	}
+
+	if order := c.String(orderFlag); order != "" {
+		if !isEncodingCommand(c) {
+			return fmt.Errorf("--%s only applies to encoding commands. command: %s", orderFlag, c.Command.Name)
+		}
+
+		err = orderFileInfoList(st.log, fileInfoList, order, func(fi os.FileInfo) (float64, error) {
+			return getLength(st, fi)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	rep := newBatchReport(c.String(reportFlag), c.Command.Name)
+	measure := !dryRun && isEncodingCommand(c) && (c.Bool(verboseFlag) || rep != nil)
+	summary := newBatchSummary(c.Command.Name, dryRun, c.String(summaryWebhookFlag) != "" || c.String(summaryEmailFlag) != "")
+	failures := &failureSummary{}
+	crossMounts := mountWarnings{}
+
+	// atomic runs stop at the first failure and roll back the changes made before it
+	atomic := c.Bool(atomicFlag) && !dryRun
+	failed := false
+
+	// files are not started once the time budget is exhausted, the ones left are recorded for resume. the first file is
+	// always processed so that resumed runs make progress
+	budget := c.Duration(timeBudgetFlag)
+	var (
+		remaining  []os.FileInfo
+		stopReason string
+	)
+
+	window, err := parseScheduleWindow(c.String(scheduleFlag))
+	if err != nil {
+		return err
+	}
+	onWindowClose := c.String(onWindowCloseFlag)
+	if onWindowClose != windowCloseWait && onWindowClose != windowCloseExit {
+		return fmt.Errorf("invalid --%s. value: %s", onWindowCloseFlag, onWindowClose)
+	}
+
+	t0 := time.Now()
+	for i, fi := range fileInfoList {
+		if budget > 0 && !dryRun && i > 0 && time.Since(t0) >= budget {
+			remaining, stopReason = fileInfoList[i:], "time budget exhausted"
+			break
+		}
+
+		if window != nil && !dryRun && !window.contains(time.Now()) {
+			if onWindowClose == windowCloseExit {
+				remaining, stopReason = fileInfoList[i:], "outside of the schedule window"
+				break
+			}
+
+			wait := window.untilOpen(time.Now())
+			st.progress.Printf("outside of the schedule window, waiting %s", wait.Round(time.Second))
+			time.Sleep(wait)
+		}
+
+		row := rep.Probe(st, fi)
+		n, o := len(st.changes), len(st.outputs)
+
+		setCommandLog(st, fi.Name())
+
+		var length, frameRate float64
+		if measure {
+			length, _ = getLength(st, fi)
+			frameRate, _ = getFrameRate(st, fi)
+		}
+
+		t1 := time.Now()
+		err := fn(c, args, fi, dryRun)
+
+		// transient errors of network shares are retried with a backoff as long as nothing was changed yet
+		delay := ioRetryDelay
+		for attempt := 0; attempt < st.ioRetries && isTransientIOError(err) && len(st.changes) == n && len(st.outputs) == o; attempt++ {
+			st.progress.Println(colorize(st, colorYellow, fmt.Sprintf("transient I/O error, retrying in %s. file: %q, err: %s", delay, fi.Name(), err)))
+			time.Sleep(delay)
+			delay *= 2
+
+			if dirErr := checkDir(filepath.Dir(fi.Name()), mountCheckTimeout); dirErr != nil {
+				st.log.Println(dirErr)
+				continue
+			}
+
+			err = fn(c, args, fi, dryRun)
+		}
+
+		if err != nil {
+			st.log.Println(err)
+			failures.Add(fi.Name(), err)
+		} else if !dryRun {
+			up.Add(st, st.outputs[o:]...)
+		}
+
+		for _, change := range st.changes[n:] {
+			crossMounts.check(st, change.oldPath, change.newPath)
+		}
+		failed = failed || err != nil
+		elapsed := time.Since(t1)
+		st.progress.Printf("done in %s.", elapsed.String())
+
+		if measure && err == nil {
+			tp := getThroughput(length, frameRate, fi.Size(), elapsed)
+			st.log.Printf("throughput: %s", tp)
+			row.FPS = fmt.Sprintf("%.1f", tp.fps)
+			row.Speed = fmt.Sprintf("%.1fx", tp.speed)
+			row.MBps = fmt.Sprintf("%.1f", tp.mbps)
+		}
+
+		rep.Add(st, row, st.changes[n:], elapsed, err)
+		summary.Add(fi, st.outputs[o:], err)
+
+		if st.resultFormat == resultJSON {
+			printResult(st.log, os.Stdout, newFileResult(fi, st.changes[n:], elapsed, err, dryRun))
+		}
+
+		if failed && atomic {
+			break
+		}
+	}
+	for _, err := range up.Wait() {
+		failures.Add(err.Path, err)
+		summary.AddFailure(err.Path, err)
+	}
+
+	var rollbackErr error
+	if failed && atomic {
+		rollbackErr = rollbackRun(st)
+	}
+	st.progress.Println(colorize(st, colorGreen, fmt.Sprintf("all done in %s.", time.Since(t0).String())))
+	failures.Print(st)
+	printSkipped(st)
+	if len(remaining) > 0 {
+		stopEarly(st, c.String(resumeManifestFlag), stopReason, c.Args().Slice(), argCount, remaining)
+	}
+
+	if dryRun && c.Bool(previewMontageFlag) {
+		err = previewMontage(st, st.changes)
+		if err != nil {
+			return err
+		}
+	} else if dryRun && st.resultFormat == "" {
+		previewChanges(st.changes, getMaxNameLength(c))
+	}
+
+	err = rep.Write(st, c.String(reportPathFlag), time.Since(t0))
+	if err != nil {
+		return err
+	}
+
+	if summary != nil {
+		summary.Remaining = len(remaining)
+	}
+	err = summary.Send(st, c.String(summaryWebhookFlag), newSMTPConfig(c), time.Since(t0))
+	if err != nil {
+		log.Print(colorize(st, colorRed, err.Error()))
+	}
+
+	return errors.Join(failures.Err(), rollbackErr)
+}
+
+func processAll(st *state, c *cli.Context, argCount int, fn func(*cli.Context, []string, []os.FileInfo, bool) error) error {
+	args := c.Args().Slice()
+	dryRun := c.Bool(dryRunFlag)
+
+	err := configure(st, c)
+	if err != nil {
+		return err
+	}
+	defer exportLogHistory(st.log, c.String(logHistoryFlag))
+
+	if argCount > len(args) {
+		return missingArgumentError(c, len(args))
+	}
+
+	recordInvocation(st.log, c.String(commandHistoryFlag), args, argCount)
+
+	filePaths, passThrough := splitPassThrough(args[argCount:])
+
+	fileInfoList, err := getFileInfoList(st, filePaths, c.String(sortFlag), c.Bool(backwardsFlag))
+	if err != nil {
+		return err
+	}
+	for _, fi := range fileInfoList {
+		st.log.Printf("file found: %q", fi.Name())
+	}
+
+	args = append(args[:argCount:argCount], passThrough...)
+
+	fileInfoList, unlock, err := prepareFiles(st, c, fileInfoList)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	up, err := newUploader(c.String(uploadFlag), c.Int(uploadJobsFlag), c.Int(uploadRetriesFlag), c.Bool(uploadDeleteFlag))
+	if err != nil {
+		return err
+	}
+
+	// commands working on the whole list log into a single file named after the command
+	setCommandLog(st, c.Command.Name)
+
+	rep := newBatchReport(c.String(reportFlag), c.Command.Name)
+	rows := make([]reportRow, 0, len(fileInfoList))
+	for _, fi := range fileInfoList {
+		rows = append(rows, rep.Probe(st, fi))
+	}
+
+	t0 := time.Now()
+	fnErr := fn(c, args, fileInfoList, dryRun)
+
+	var rollbackErr error
+	if fnErr != nil {
+		st.log.Println(fnErr)
+
+		if c.Bool(atomicFlag) && !dryRun {
+			rollbackErr = rollbackRun(st)
+		}
+	} else if !dryRun {
+		up.Add(st, st.outputs...)
+	}
+	uploadErrors := up.Wait()
+	st.progress.Println(colorize(st, colorGreen, fmt.Sprintf("all done in %s.", time.Since(t0).String())))
+	printSkipped(st)
+
+	for _, row := range rows {
+		rep.Add(st, row, nil, 0, nil)
+	}
+
+	err = rep.Write(st, c.String(reportPathFlag), time.Since(t0))
+	if err != nil {
+		return err
+	}
+
+	var uploadErr error
+	if len(uploadErrors) > 0 {
+		uploadErr = fmt.Errorf("%d upload(s) failed", len(uploadErrors))
+	}
+
+	return errors.Join(fnErr, rollbackErr, uploadErr)
+}
+
+// setCommandLog makes the following commands log into the log file belonging to filePath
+func setCommandLog(st *state, filePath string) {
+	st.commandLogPath = ""
+	if st.commandLogDir == "" || filePath == "" {
+		return
+	}
+
+	name := strings.NewReplacer(string(filepath.Separator), "_", "..", "_").Replace(filepath.Clean(filePath))
+	st.commandLogPath = filepath.Join(st.commandLogDir, name+".log")
+}
+
+// saveCommandLog appends a command and its full output to the current log file
+func saveCommandLog(st *state, command, output string, err error) {
+	if st.commandLogPath == "" {
+		return
+	}
+
+	f, fErr := os.OpenFile(st.commandLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if fErr != nil {
+		st.log.Printf("failed to open command log. path: %q, err: %s", st.commandLogPath, fErr)
+
+		return
+	}
+	defer f.Close()
+
+	status := "ok"
+	if err != nil {
+		status = err.Error()
+	}
+
+	_, _ = fmt.Fprintf(f, "$ %s\n%s\n[%s] %s\n\n", command, output, time.Now().Format(time.RFC3339), status)
+}
+
+// CommandRunner runs external programs like ffmpeg and ffprobe and returns their combined output
+type CommandRunner interface {
+	Run(args []string) (string, error)
+}
+
+// ExecRunner runs commands for real
+type ExecRunner struct{}
+
+func (ExecRunner) Run(args []string) (string, error) {
+	if len(args) == 0 {
+		return "", errors.New("empty command")
+	}
+
+	output, err := osexec.Command(args[0], args[1:]...).CombinedOutput()
+
+	return string(output), err
+}
+
+// FakeRunner records the arguments of the commands instead of running them, so that ffr can run without ffmpeg,
+// e.g. in tests
+type FakeRunner struct {
+	// Respond returns the output of a command, if it is nil commands succeed without any output
+	Respond func(args []string) (string, error)
+	// Commands contains the arguments of the commands run, in order
+	Commands [][]string
+
+	lock sync.Mutex
+}
+
+func (r *FakeRunner) Run(args []string) (string, error) {
+	r.lock.Lock()
+	r.Commands = append(r.Commands, args)
+	r.lock.Unlock()
+
+	if r.Respond == nil {
+		return "", nil
+	}
+
+	return r.Respond(args)
+}
+
+// runner runs the external commands of ffr
+var runner CommandRunner = ExecRunner{}
+
+var shellSafeRegexp = regexp.MustCompile(`^[a-zA-Z0-9_./:=,+@%-]+$`)
+
+// quoteArgs renders args as a shell-safe command line, arguments are single-quoted if they contain anything but
+// characters which are safe in all common shells
+func quoteArgs(args []string) string {
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		if shellSafeRegexp.MatchString(arg) {
+			quoted = append(quoted, arg)
+
+			continue
+		}
+
+		quoted = append(quoted, "'"+strings.ReplaceAll(arg, "'", `'\''`)+"'")
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+// showCommand logs a command about to be run and prints it if printing commands is enabled. Commands go to stderr if
+// stdout is reserved for results. The read rate of ffmpeg is limited before, so the command returned, which is the one
+// to run, is the one printed.
+func showCommand(st *state, args []string) []string {
+	args = withReadRate(st, args)
+	command := quoteArgs(args)
+
+	st.log.Printf("command: %s", command)
+
+	if !st.printCommands {
+		return args
+	}
+
+	if st.resultFormat != "" {
+		fmt.Fprintln(os.Stderr, command)
+
+		return args
+	}
+
+	fmt.Println(command)
+
+	return args
+}
+
+func exec(st *state, args []string) (string, error) {
+	// commands run without being shown, e.g. analyses, are limited here
+	args = withReadRate(st, args)
+
+	pending := pendingOutput(args)
+
+	output, err := runner.Run(args)
+	if err != nil {
+		st.log.Println(err)
+
+		err = classifyExitError(err, output)
+		if pending != "" && isTransientIOError(err) {
+			// the partial output would stop the retry as a collision
+			_ = os.Remove(pending)
+		}
+	}
+
+	saveCommandLog(st, quoteArgs(args), output, err)
+
+	return output, err
+}
+
+// pendingOutput returns the output of an ffmpeg command, which is its last argument, if it does not exist yet
+func pendingOutput(args []string) string {
+	if len(args) < 2 || args[0] != "ffmpeg" {
+		return ""
+	}
+
+	path := args[len(args)-1]
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		return ""
+	}
+
+	return path
+}
+
+type probeResult struct {
+	output string
+	err    error
+}
+
+// probeCache stores the results of ffprobe commands so that files are only probed once per invocation
+type probeCache struct {
+	lock    *sync.Mutex
+	entries map[string]probeResult
+}
+
+func newProbeCache() *probeCache {
+	return &probeCache{
+		lock:    &sync.Mutex{},
+		entries: map[string]probeResult{},
+	}
+}
+
+// probeKey returns the cache key of a probe, the file probed is always the last argument. The size and the
+// modification time of the file are part of the key so that changed files are probed again. Probes of files which
+// can not be found are not cached.
+func probeKey(args []string) (string, bool) {
+	if len(args) == 0 {
+		return "", false
+	}
+
+	fi, err := os.Stat(args[len(args)-1])
+	if err != nil {
+		return "", false
+	}
+
+	return fmt.Sprintf("%s\x00%d\x00%d", strings.Join(args, "\x00"), fi.Size(), fi.ModTime().UnixNano()), true
+}
+
+func (pc *probeCache) get(key string) (probeResult, bool) {
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+
+	result, ok := pc.entries[key]
+
+	return result, ok
+}
+
+func (pc *probeCache) set(key string, result probeResult) {
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+
+	pc.entries[key] = result
+}
+
+// probe runs an ffprobe command unless its result is already cached
+func probe(st *state, args []string) (string, error) {
+	key, ok := probeKey(args)
+	if !ok {
+		return exec(st, args)
+	}
+
+	if result, found := st.probes.get(key); found {
+		saveCommandLog(st, quoteArgs(args)+" # cached", result.output, result.err)
+
+		return result.output, result.err
+	}
+
+	output, err := exec(st, args)
+	st.probes.set(key, probeResult{output: output, err: err})
+
+	return output, err
+}
+
+// prefetch calls fn for every file on a pool of jobs goroutines so that the probes fn runs are cached by the time the
+// files are processed one by one. Nothing is written to the command log in the meantime, the cached results are logged
+// when they are used.
+func prefetch(st *state, fileList []os.FileInfo, jobs int, fn func(fi os.FileInfo)) {
+	if jobs < 2 || len(fileList) < 2 {
+		return
+	}
+
+	logPath := st.commandLogPath
+	st.commandLogPath = ""
+	defer func() { st.commandLogPath = logPath }()
+
+	work := make(chan os.FileInfo)
+	wg := sync.WaitGroup{}
+	for i := 0; i < jobs && i < len(fileList); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for fi := range work {
+				fn(fi)
+			}
+		}()
+	}
+
+	for _, fi := range fileList {
+		work <- fi
+	}
+	close(work)
+
+	wg.Wait()
+}
+
+const (
+	uploadS3Prefix     = "s3://"
+	uploadRclonePrefix = "rclone:"
+)
+
+const (
+	defaultUploadJobs    = 2
+	defaultUploadRetries = 3
+)
+
+// uploadRetryDelay is the time waited before retrying a failed upload for the first time, it doubles with every retry
+var uploadRetryDelay = 2 * time.Second
+
+// UploadError is returned when an output could not be uploaded, or deleted after it was uploaded
+type UploadError struct {
+	Path        string
+	Destination string
+	Err         error
+}
+
+func (e *UploadError) Error() string {
+	return fmt.Sprintf("failed to upload file. file: %q, destination: %s, err: %s", e.Path, e.Destination, e.Err)
+}
+
+func (e *UploadError) Unwrap() error {
+	return e.Err
+}
+
+// checkUploadDestination checks if a destination is an S3 URL or an rclone remote
+func checkUploadDestination(destination string) error {
+	switch {
+	case strings.HasPrefix(destination, uploadS3Prefix) && len(destination) > len(uploadS3Prefix):
+		return nil
+	case strings.HasPrefix(destination, uploadRclonePrefix) && strings.Contains(destination[len(uploadRclonePrefix):], ":"):
+		return nil
+	}
+
+	return fmt.Errorf("invalid upload destination, use %sbucket/prefix or %sremote:path. destination: %s", uploadS3Prefix, uploadRclonePrefix, destination)
+}
+
+// getUploadCommand returns the command copying a local file or directory into a destination, keeping its name
+func getUploadCommand(destination, path string, isDir bool) ([]string, error) {
+	err := checkUploadDestination(destination)
+	if err != nil {
+		return nil, err
+	}
+
+	name := filepath.Base(path)
+
+	if strings.HasPrefix(destination, uploadS3Prefix) {
+		target := strings.TrimSuffix(destination, "/") + "/" + name
+		if isDir {
+			return []string{"aws", "s3", "cp", "--only-show-errors", "--recursive", path, target + "/"}, nil
+		}
+
+		return []string{"aws", "s3", "cp", "--only-show-errors", path, target}, nil
+	}
+
+	remote := strings.TrimPrefix(destination, uploadRclonePrefix)
+	if !strings.HasSuffix(remote, ":") && !strings.HasSuffix(remote, "/") {
+		remote += "/"
+	}
+	if isDir {
+		return []string{"rclone", "copy", path, remote + name}, nil
+	}
+
+	return []string{"rclone", "copyto", path, remote + name}, nil
+}
+
+// uploader uploads outputs in the background while the next files are processed, at most jobs of them at the same time
+type uploader struct {
+	destination string
+	retries     int
+	deleteLocal bool
+
+	slots  chan struct{}
+	wg     *sync.WaitGroup
+	lock   *sync.Mutex
+	errors []*UploadError
+}
+
+// newUploader returns an uploader to destination, or nil if no destination is given
+func newUploader(destination string, jobs, retries int, deleteLocal bool) (*uploader, error) {
+	if destination == "" {
+		return nil, nil
+	}
+
+	err := checkUploadDestination(destination)
+	if err != nil {
+		return nil, err
+	}
+
+	if jobs < 1 {
+		return nil, fmt.Errorf("invalid number of upload jobs. jobs: %d", jobs)
+	}
+
+	if retries < 0 {
+		return nil, fmt.Errorf("invalid number of upload retries. retries: %d", retries)
+	}
+
+	return &uploader{
+		destination: destination,
+		retries:     retries,
+		deleteLocal: deleteLocal,
+		slots:       make(chan struct{}, jobs),
+		wg:          &sync.WaitGroup{},
+		lock:        &sync.Mutex{},
+	}, nil
+}
+
+// Add starts uploading paths as soon as there are free jobs. It is safe to call on a nil uploader, which is a no-op.
+func (u *uploader) Add(st *state, paths ...string) {
+	if u == nil {
+		return
+	}
+
+	for _, path := range paths {
+		u.wg.Add(1)
+		go func(path string) {
+			defer u.wg.Done()
+
+			u.slots <- struct{}{}
+			defer func() { <-u.slots }()
+
+			err := u.upload(st, path)
+			if err != nil {
+				st.log.Println(err)
+
+				u.lock.Lock()
+				u.errors = append(u.errors, err)
+				u.lock.Unlock()
+			}
+		}(path)
+	}
+}
+
+// Wait waits for all uploads to finish and returns the ones which failed
+func (u *uploader) Wait() []*UploadError {
+	if u == nil {
+		return nil
+	}
+
+	u.wg.Wait()
+
+	return u.errors
+}
+
+// upload copies a file to the destination, retrying failed attempts. The command is run without being saved to the
+// command log, which belongs to the file being processed in the meantime.
+func (u *uploader) upload(st *state, path string) *UploadError {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return &UploadError{Path: path, Destination: u.destination, Err: err}
+	}
+
+	command, err := getUploadCommand(u.destination, path, fi.IsDir())
+	if err != nil {
+		return &UploadError{Path: path, Destination: u.destination, Err: err}
+	}
+
+	command = showCommand(st, command)
+
+	delay := uploadRetryDelay
+	for attempt := 0; ; attempt++ {
+		output, err := runner.Run(command)
+		if err == nil {
+			break
+		}
+
+		st.log.Println(output)
+		if attempt >= u.retries {
+			return &UploadError{Path: path, Destination: u.destination, Err: err}
+		}
+
+		st.log.Printf("upload failed, retrying in %s. file: %q, err: %s", delay, path, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+
+	st.progress.Printf("uploaded: %q", path)
+
+	if !u.deleteLocal {
+		return nil
+	}
+
+	err = os.RemoveAll(path)
+	if err != nil {
+		return &UploadError{Path: path, Destination: u.destination, Err: fmt.Errorf("failed to delete uploaded output. err: %w", err)}
+	}
+
+	st.journal.Record(journalDelete, path, "")
+
+	return nil
+}
+
+// App contains the actions of the commands, they share the state of the app
+type App struct {
+	*state
+}
+
+// getKeyFrameTimes returns the time stamps of all key frames of a video in seconds
+func getKeyFrameTimes(st *state, fi os.FileInfo) ([]float64, error) {
+	command := []string{"ffprobe", "-loglevel", "error", "-select_streams", videoStreamSpecifier(st), "-show_entries", "packet=pts_time,flags", "-of", "csv=print_section=0", fi.Name()}
+
+	output, err := exec(st, command)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve keyframes. err: %w", err)
+	}
+
+	var times []float64
+	for _, line := range strings.Split(output, "\n") {
+		if !strings.Contains(line, ",K__") {
+			continue
+		}
+
+		pts := strings.Split(line, ",")[0]
+		if pts == "" {
+			continue
+		}
+
+		n, err := strconv.ParseFloat(pts, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		times = append(times, n)
+	}
+
+	return times, nil
+}
+
+func findKeyFrames(st *state, fi os.FileInfo) ([]string, error) {
+	times, err := getKeyFrameTimes(st, fi)
+	if err != nil {
+		return nil, err
+	}
+
+	maxCount := 4
+	var numbers []string
+	for i, n := range times {
+		if i >= maxCount {
+			break
+		}
+
+		numbers = append(numbers, fmt.Sprintf("%.1f", n))
+	}
+
+	return numbers, nil
+}
+
+func keyFrames(st *state, fi os.FileInfo) error {
+	numbers, err := findKeyFrames(st, fi)
+	if err != nil {
+		return err
+	}
+
+	st.log.Printf("file: %s", fi.Name())
+	st.log.Printf("indexes: %s...", strings.Join(numbers, ", "))
+
+	return nil
+}
+
+func (a App) keyFrames(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
+	return keyFrames(a.state, fi)
+}
+
+const defaultClipLength = 10.0
+
+// parseTimestamp parses a time stamp given in seconds, as mm:ss or as hh:mm:ss, fractions of seconds are allowed
+func parseTimestamp(timestamp string) (float64, error) {
+	parts := strings.Split(timestamp, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid timestamp. timestamp: %s", timestamp)
+	}
+
+	var seconds float64
+	for i, part := range parts {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil || n < 0 || (i > 0 && n >= 60) {
+			return 0, fmt.Errorf("invalid timestamp. timestamp: %s", timestamp)
+		}
+
+		seconds = seconds*60 + n
+	}
+
+	return seconds, nil
+}
+
+// nearestKeyFrame returns the key frame closest to a time stamp, before or after it
+func nearestKeyFrame(times []float64, at float64) (float64, error) {
+	if len(times) == 0 {
+		return 0, errors.New("no keyframes found")
+	}
+
+	nearest := times[0]
+	for _, t := range times[1:] {
+		if math.Abs(t-at) < math.Abs(nearest-at) {
+			nearest = t
+		}
+	}
+
+	return nearest, nil
+}
+
+// previewClip extracts a short clip starting at the key frame nearest to a time stamp. Starting at a key frame lets the
+// streams be copied, so the clip is created instantly and without quality loss.
+func previewClip(st *state, fi os.FileInfo, at, length float64, forceOverwrite, dryRun bool) (string, error) {
+	filePath := fi.Name()
+
+	basePath := filepath.Base(filePath)
+	ext := filepath.Ext(filePath)
+	if ext != "" {
+		basePath = basePath[:len(basePath)-len(ext)]
+	}
+
+	if length == 0 {
+		length = defaultClipLength
+	}
+	if length < 0 {
+		return "", fmt.Errorf("invalid clip length. length: %.1f", length)
+	}
+
+	times, err := getKeyFrameTimes(st, fi)
+	if err != nil {
+		return "", err
+	}
+
+	start, err := nearestKeyFrame(times, at)
+	if err != nil {
+		return "", fmt.Errorf("%w. file: %q", err, filePath)
+	}
+
+	st.log.Printf("file: %s, requested: %.3f, keyframe: %.3f", filePath, at, start)
+
+	outputPath := filepath.Join(filepath.Dir(filePath), fmt.Sprintf("%s-preview-%ds%s", basePath, int(start), ext))
+
+	command := []string{
+		"ffmpeg",
+		"-ss", fmt.Sprintf("%.3f", start),
+		inputKey, filePath,
+		"-t", fmt.Sprintf("%.3f", length),
+		"-map", "0",
+		"-c", "copy",
+		"-avoid_negative_ts", "make_zero",
+		outputPath,
+	}
+
+	st.log.Printf("new path: %s", outputPath)
+	command = showCommand(st, command)
+
+	if err := checkRoot(st, filePath, outputPath); err != nil {
+		return "", err
+	}
+
+	if err := checkCollision(st, outputPath, forceOverwrite); err != nil {
+		return "", err
+	}
+
+	if dryRun {
+		planEncode(st, filePath, outputPath)
+
+		return outputPath, nil
+	}
+
+	output, err := exec(st, command)
+	if err != nil {
+		st.log.Println(output)
+
+		return "", &EncodeError{Path: filePath, Operation: "extract preview clip", Err: err}
+	}
+
+	recordEncode(st, filePath, outputPath)
+
+	return outputPath, nil
+}
+
+func (a App) previewClip(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
+	forceOverwrite := c.Bool(forceFlag)
+
+	at, err := parseTimestamp(args[0])
+	if err != nil {
+		return err
+	}
+
+	length := c.Float64(clipLengthFlag)
+
+	_, err = previewClip(a.state, fi, at, length, forceOverwrite, dryRun)
+
+	return err
+}
+
+const (
+	videoCodecKey    = "-c:v"
+	audioCodecKey    = "-c:a"
+	crfKey           = "-crf"
+	bitRateKey       = "-b:v"
+	maxRateKey       = "-maxrate"
+	bufsizeKey       = "-bufsize"
+	presetKey        = "-preset"
+	losslessKey      = "-lossless"
+	hwaccelKey       = "-hwaccel"
+	hwaccelDeviceKey = "-hwaccel_device"
+	inputKey         = "-i"
+	videoFilterKey   = "-vf"
+	vaapiDeviceKey   = "-vaapi_device"
+	pixelFormatKey   = "-pix_fmt"
+	profileKey       = "-profile:v"
+	tuneKey          = "-tune"
+	keyFrameKey      = "-g"
+	x265ParamsKey    = "-x265-params"
+	x264ParamsKey    = "-x264-params"
+	fpsModeKey       = "-fps_mode"
+)
+
+const (
+	hwaccelAutoDetect   = "auto-detect"
+	hwaccelQSV          = "qsv"
+	hwaccelNVENC        = "nvenc"
+	hwaccelVAAPI        = "vaapi"
+	hwaccelVideoToolbox = "videotoolbox"
+
+	vaapiFilter        = "format=%s,hwupload"
+	defaultVAAPIDevice = "/dev/dri/renderD128"
+)
+
+// hwaccelEncoders maps the hardware acceleration backends to their encoders for each supported software encoder
+var hwaccelEncoders = map[string]map[string]string{
+	hwaccelQSV: {
+		encoderH265: "hevc_qsv",
+		encoderH264: "h264_qsv",
+		encoderVP9:  "vp9_qsv",
+	},
+	hwaccelNVENC: {
+		encoderH265: "hevc_nvenc",
+		encoderH264: "h264_nvenc",
+	},
+	hwaccelVAAPI: {
+		encoderH265: "hevc_vaapi",
+		encoderH264: "h264_vaapi",
+		encoderVP9:  "vp9_vaapi",
+	},
+	hwaccelVideoToolbox: {
+		encoderH265: "hevc_videotoolbox",
+		encoderH264: "h264_videotoolbox",
+	},
+}
+
+// hwaccelPriority is the order in which backends are preferred when auto-detecting
+var hwaccelPriority = []string{hwaccelNVENC, hwaccelQSV, hwaccelVideoToolbox, hwaccelVAAPI}
+
+func defaultHWAccelCachePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "ffr", "hwaccel.json")
+}
+
+// probeHWEncoder checks if a hardware encoder actually works by encoding a single blank frame
+func probeHWEncoder(st *state, backend, encoder string) bool {
+	command := []string{"ffmpeg", "-hide_banner", "-v", "error", "-f", "lavfi", "-i", "color=black:s=256x256:d=0.1"}
+	if backend == hwaccelVAAPI {
+		command = append(command, vaapiDeviceKey, defaultVAAPIDevice, videoFilterKey, fmt.Sprintf(vaapiFilter, "nv12"))
+	}
+	command = append(command, "-frames:v", "1", "-c:v", encoder, "-f", "null", "-")
+	command = showCommand(st, command)
+
+	_, err := exec(st, command)
+
+	return err == nil
+}
+
+// detectHWAccel returns the best working hardware acceleration backend for an encoder or an empty string if none of
+// them work. Results are cached per encoder in cachePath, an empty cachePath disables caching.
+func detectHWAccel(l *logger, encoder, cachePath string, probe func(backend, encoder string) bool) string {
+	cache := map[string]string{}
+	if cachePath != "" {
+		data, err := os.ReadFile(cachePath)
+		if err == nil {
+			_ = json.Unmarshal(data, &cache)
+		}
+	}
+
+	backend, ok := cache[encoder]
+	if ok {
+		return backend
+	}
+
+	for _, b := range hwaccelPriority {
+		hwEncoder, ok := hwaccelEncoders[b][encoder]
+		if ok && probe(b, hwEncoder) {
+			backend = b
+			break
+		}
+	}
+
+	if cachePath == "" {
+		return backend
+	}
+
+	cache[encoder] = backend
+
+	data, err := json.Marshal(cache)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(cachePath), 0755)
+	}
+	if err == nil {
+		err = os.WriteFile(cachePath, data, 0644)
+	}
+	if err != nil {
+		l.Printf("failed to cache hardware acceleration. path: %q, err: %s", cachePath, err)
+	}
+
+	return backend
+}
+
+type ReEncoder struct {
+	lock     *sync.Mutex
+	params   map[string]string
+	order    []string
+	keys     []string
+	boolKeys []string
+}
+
+func NewReEncoder() *ReEncoder {
+	return &ReEncoder{
+		lock:     &sync.Mutex{},
+		params:   make(map[string]string),
+		keys:     []string{videoCodecKey, hwaccelKey, pixelFormatKey, crfKey, losslessKey, presetKey, tuneKey, fpsModeKey},
+		boolKeys: []string{losslessKey},
+	}
+}
+
+func (r *ReEncoder) Set(key, value string) *ReEncoder {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	_, ok := r.params[key]
+	if ok {
+		r.params[key] = value
+
+		return r
+	}
+
+	r.params[key] = value
+	r.order = append(r.order, key)
+
+	return r
+}
+
+func (r *ReEncoder) Delete(key string) *ReEncoder {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	_, ok := r.params[key]
+	if !ok {
+		return r
+	}
+
+	delete(r.params, key)
+
+	order := make([]string, 0, len(r.order))
+	for _, k := range r.order {
+		if k != key {
+			order = append(order, k)
+		}
+	}
+	r.order = order
+
+	return r
+}
+
+// inputKeys are the options which have to precede the input of ffmpeg, in this order
+var inputKeys = []string{hwaccelKey, hwaccelDeviceKey, vaapiDeviceKey}
+
+// outputKeys is the order of the well-known output options, other options follow them in the order they were set
+var outputKeys = []string{
+	videoCodecKey, x265ParamsKey, x264ParamsKey, pixelFormatKey, profileKey, crfKey, losslessKey, presetKey, tuneKey,
+	keyFrameKey, bitRateKey, maxRateKey, bufsizeKey, fpsModeKey, videoFilterKey, audioCodecKey,
+}
+
+// Args returns the arguments of the ffmpeg command writing outputPath. The order of the options does not depend on
+// the order they were set or deleted in.
+func (r *ReEncoder) Args(outputPath string) []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	args := []string{"ffmpeg"}
+	for _, key := range inputKeys {
+		if value, ok := r.params[key]; ok {
+			args = append(args, key, value)
+		}
+	}
+
+	args = append(args, inputKey, r.params[inputKey])
+
+	for _, key := range outputKeys {
+		if value, ok := r.params[key]; ok {
+			args = append(args, key, value)
+		}
+	}
+
+	for _, key := range r.order {
+		if key == inputKey || containsString(inputKeys, key) || containsString(outputKeys, key) {
+			continue
+		}
+
+		args = append(args, key, r.params[key])
+	}
+
+	return append(args, outputPath)
+}
+
+// Get returns the value of a parameter, empty if it is not set
+func (r *ReEncoder) Get(key string) string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.params[key]
+}
+
+func (r *ReEncoder) GetPath() string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	values := []string{}
+
+	for _, key := range r.keys {
+		if value, ok := r.params[key]; ok {
+			b := false
+			for _, bv := range r.boolKeys {
+				if bv == key {
+					b = true
+					break
+				}
+			}
+			if b {
+				values = append(values, strings.Trim(key, "-"))
+			} else {
+				values = append(values, value)
+			}
+		}
+	}
+
+	return strings.Join(values, "-")
+}
+
+func findPreset(preset string) (string, error) {
+	for _, p := range allowedPresets {
+		if p == preset {
+			return preset, nil
+		}
+	}
+
+	return "", fmt.Errorf("invalid preset. preset: %s", preset)
+}
+
+func getNewBitRates(st *state, fi os.FileInfo, encoder string) (string, string, error) {
+	oldCodec, err := getCodec(st, fi)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to get codec. err: %w", err)
+	}
+
+	rawBitRate, err := getBitRate(st, fi)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to get bitrate. err: %w", err)
+	}
+
+	if rawBitRate == 0 {
+		vt := info(st, fi, true)
+
+		rawBitRate = vt.width * vt.height / 10 * int64(vt.frameRate)
+	}
+
+	rbr := intToString(rawBitRate, "", "")
+	st.log.Printf("file: %s, old codec: %s, encoder: %s, old bit rate: %d, rbr human: %s", fi.Name(), oldCodec, encoder, rawBitRate, rbr)
+
+	if encoder == encoderH265 && oldCodec != codecH265 {
+		rawBitRate = rawBitRate * 6 / 10
+	}
+
+	rbr = intToString(rawBitRate, "", "")
+	rbr2 := intToString(rawBitRate*2, "", "")
+	st.log.Printf("file: %s, old codec: %s, encoder: %s, new bit rate: %d, rbr human: %s", fi.Name(), oldCodec, encoder, rawBitRate, rbr)
+
+	return rbr, rbr2, nil
+}
+
+type reEncodeOptions struct {
+	codec         string
+	crf           int
+	preset        string
+	hwaccel       string
+	hwaccelDevice string
+	bitDepth      int
+	profile       string
+	tune          string
+	x265Params    string
+	x264Params    string
+	keyInt        string
+	allIntra      bool
+	cfr           bool
+	container     string
+	nameTemplate  string
+	outputDir     string
+	force         bool
+}
+
+const (
+	// defaultReEncodeName names re-encoded files after the original and the encoding parameters
+	defaultReEncodeName = "{{.Base}}-{{.Params}}"
+	// noSuffixReEncodeName keeps the original name, only the extension may change
+	noSuffixReEncodeName = "{{.Base}}"
+)
+
+// reEncodeNameFields are the fields of the name template of re-encoded files
+type reEncodeNameFields struct {
+	Base        string
+	Params      string
+	Encoder     string
+	CRF         string
+	Preset      string
+	Tune        string
+	PixelFormat string
+	HWAccel     string
+	FPSMode     string
+}
+
+// getReEncodeName returns the name of a re-encoded file without the extension. By default, it is the original name
+// followed by the encoding parameters joined by dashes, e.g. "foo-libx265-28-slow".
+func getReEncodeName(basePath string, params *ReEncoder, nameTemplate string) (string, error) {
+	if nameTemplate == "" {
+		nameTemplate = defaultReEncodeName
+	}
+
+	tpl, err := template.New("name").Option("missingkey=error").Parse(nameTemplate)
+	if err != nil {
+		return "", fmt.Errorf("invalid name template. template: %q, err: %w", nameTemplate, err)
+	}
+
+	fields := reEncodeNameFields{
+		Base:        basePath,
+		Params:      params.GetPath(),
+		Encoder:     params.Get(videoCodecKey),
+		CRF:         params.Get(crfKey),
+		Preset:      params.Get(presetKey),
+		Tune:        params.Get(tuneKey),
+		PixelFormat: params.Get(pixelFormatKey),
+		HWAccel:     params.Get(hwaccelKey),
+		FPSMode:     params.Get(fpsModeKey),
+	}
+
+	buf := &strings.Builder{}
+	err = tpl.Execute(buf, fields)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute name template. template: %q, err: %w", nameTemplate, err)
+	}
+
+	name := buf.String()
+	if name == "" {
+		return "", fmt.Errorf("name template resulted in an empty name. template: %q", nameTemplate)
+	}
+
+	if strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("name must not contain path separators, use --output-dir instead. name: %q", name)
+	}
+
+	return name, nil
+}
+
+const (
+	defaultKeyInt = "250"
+	keyIntAuto    = "auto"
+)
+
+// getKeyInt returns the maximum distance between key frames. "auto" means ten seconds of video.
+func getKeyInt(st *state, fi os.FileInfo, keyInt string, allIntra bool) (int, error) {
+	if allIntra {
+		return 1, nil
+	}
+
+	if keyInt == "" {
+		keyInt = defaultKeyInt
+	}
+
+	if keyInt == keyIntAuto {
+		frameRate, err := getFrameRate(st, fi)
+		if err != nil || frameRate <= 0 {
+			st.log.Printf("failed to retrieve frame rate, falling back to default keyint. err: %v", err)
+
+			return strconv.Atoi(defaultKeyInt)
+		}
+
+		return int(math.Round(frameRate * 10)), nil
+	}
+
+	n, err := strconv.Atoi(keyInt)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid keyint. keyint: %s", keyInt)
+	}
+
+	return n, nil
+}
+
+// allowedTunes lists the tunes supported by each software encoder
+var allowedTunes = map[string][]string{
+	encoderH265: {"animation", "grain", "fastdecode", "zerolatency"},
+	encoderH264: {"film", "animation", "grain", "stillimage", "fastdecode", "zerolatency"},
+}
+
+func findTune(codec, tune string) (string, error) {
+	if containsString(allowedTunes[codec], tune) {
+		return tune, nil
+	}
+
+	return "", fmt.Errorf("invalid tune. codec: %s, tune: %s", codec, tune)
+}
+
+// mergeEncoderParams merges colon separated key=value encoder parameters, custom values overriding generated ones
+func mergeEncoderParams(generated, custom string) (string, error) {
+	var keys []string
+	values := map[string]string{}
+
+	for _, params := range []string{generated, custom} {
+		if params == "" {
+			continue
+		}
+
+		for _, param := range strings.Split(params, ":") {
+			key, value, ok := strings.Cut(param, "=")
+			if !ok || key == "" {
+				return "", fmt.Errorf("invalid encoder parameter. parameter: %q", param)
+			}
+
+			if _, ok := values[key]; !ok {
+				keys = append(keys, key)
+			}
+			values[key] = value
+		}
+	}
+
+	params := make([]string, 0, len(keys))
+	for _, key := range keys {
+		params = append(params, key+"="+values[key])
+	}
+
+	return strings.Join(params, ":"), nil
+}
+
+// profiles maps the supported encoders to their profiles for each bit depth
+var profiles = map[string]map[int]string{
+	encoderH265: {8: "main", 10: "main10"},
+	encoderH264: {8: "high", 10: "high10"},
+	encoderVP9:  {8: "0", 10: "2"},
+}
+
+// intermediateProfile is a profile of an intermediate codec, the pixel format is implied by the profile
+type intermediateProfile struct {
+	profile     string
+	pixelFormat string
+}
+
+// intermediateProfiles contains the profiles of the intermediate codecs used by editors, by their short names
+var intermediateProfiles = map[string]map[string]intermediateProfile{
+	encoderProRes: {
+		"proxy":    {profile: "0", pixelFormat: "yuv422p10le"},
+		"lt":       {profile: "1", pixelFormat: "yuv422p10le"},
+		"standard": {profile: "2", pixelFormat: "yuv422p10le"},
+		"hq":       {profile: "3", pixelFormat: "yuv422p10le"},
+		"4444":     {profile: "4", pixelFormat: "yuva444p10le"},
+		"4444xq":   {profile: "5", pixelFormat: "yuva444p10le"},
+	},
+	encoderDNxHR: {
+		"lb":  {profile: "dnxhr_lb", pixelFormat: "yuv422p"},
+		"sq":  {profile: "dnxhr_sq", pixelFormat: "yuv422p"},
+		"hq":  {profile: "dnxhr_hq", pixelFormat: "yuv422p"},
+		"hqx": {profile: "dnxhr_hqx", pixelFormat: "yuv422p10le"},
+		"444": {profile: "dnxhr_444", pixelFormat: "yuv444p10le"},
+	},
+}
+
+const defaultIntermediateProfile = "hq"
+
+// intermediateContainers are the containers editors accept intermediate codecs in, the first one is the default
+var intermediateContainers = []string{"mov", "mxf"}
+
+// containerSame keeps the container of the original file
+const containerSame = "same"
+
+// codecContainers are the containers encoders can be written to, the first one is the default
+var codecContainers = map[string][]string{
+	encoderH265:   {"mp4", "mkv", "mov"},
+	encoderH264:   {"mp4", "mkv", "mov"},
+	encoderVP9:    {"mkv", "webm"},
+	encoderProRes: intermediateContainers,
+	encoderDNxHR:  intermediateContainers,
+}
+
+// forcedContainers are containers which can hold an encoder, but many players and editors fail to open them
+var forcedContainers = map[string][]string{
+	encoderVP9: {"mp4"},
+}
+
+// getContainer returns the container, i.e. the extension, of a file re-encoded with codec. An empty container means
+// the default one of the codec, "same" means the container of the original file.
+func getContainer(codec, container, filePath string, force bool) (string, error) {
+	containers, ok := codecContainers[codec]
+	if !ok {
+		containers = []string{"mp4"}
+	}
+
+	switch container {
+	case "":
+		return containers[0], nil
+	case containerSame:
+		container = strings.TrimPrefix(strings.ToLower(filepath.Ext(filePath)), ".")
+	}
+
+	if containsString(containers, container) {
+		return container, nil
+	}
+
+	if containsString(forcedContainers[codec], container) {
+		if !force {
+			return "", fmt.Errorf("container is poorly supported for encoder, use --force to use it anyway. encoder: %s, container: %s", codec, container)
+		}
+
+		return container, nil
+	}
+
+	return "", fmt.Errorf("invalid container for encoder. encoder: %s, container: %q, valid: %s", codec, container, strings.Join(containers, ", "))
+}
+
+// getIntermediateProfile returns the profile of an intermediate codec by its short name, e.g. "hq" or "4444"
+func getIntermediateProfile(codec, name string) (intermediateProfile, error) {
+	if name == "" {
+		name = defaultIntermediateProfile
+	}
+
+	p, ok := intermediateProfiles[codec][strings.ToLower(name)]
+	if !ok {
+		return intermediateProfile{}, fmt.Errorf("invalid profile for encoder. encoder: %s, profile: %s", codec, name)
+	}
+
+	return p, nil
+}
+
+// getPixelFormat returns the pixel format to use for a bit depth. Hardware encoders use semi-planar formats.
+func getPixelFormat(bitDepth int, hw bool) (string, error) {
+	switch {
+	case bitDepth == 8 && hw:
+		return "nv12", nil
+	case bitDepth == 8:
+		return "yuv420p", nil
+	case bitDepth == 10 && hw:
+		return "p010le", nil
+	case bitDepth == 10:
+		return "yuv420p10le", nil
+	}
+
+	return "", fmt.Errorf("invalid bit depth. bit depth: %d", bitDepth)
+}
+
+func reEncode(st *state, fi os.FileInfo, o reEncodeOptions, dryRun bool) (string, error) {
+	codec, crf, preset, hwaccel, hwaccelDevice := o.codec, o.crf, o.preset, o.hwaccel, o.hwaccelDevice
+
+	filePath := fi.Name()
+
+	basePath := filepath.Base(filePath)
+	ext := filepath.Ext(filePath)
+	if ext != "" {
+		basePath = basePath[:len(basePath)-len(ext)]
+	}
+
+	extNew, err := getContainer(codec, o.container, filePath, o.force)
+	if err != nil {
+		return "", err
+	}
+
+	params := NewReEncoder()
+	params.
+		Set(hwaccelKey, "auto").
+		Set(inputKey, filePath).
+		Set(crfKey, fmt.Sprintf("%d", crf)).
+		Set(presetKey, preset)
+	if hwaccelDevice != "" {
+		params.Set(hwaccelDeviceKey, hwaccelDevice)
+	}
+
+	keyInt, err := getKeyInt(st, fi, o.keyInt, o.allIntra)
+	if err != nil {
+		return "", err
+	}
+
+	warnHDR(st, fi, o)
+
+	switch codec {
+	case encoderH265:
+		// https://trac.ffmpeg.org/wiki/Encode/H.265
+		if crf == 0 {
+			crf = defaultCRFs[encoderH265]
+		}
+
+		preset, err := findPreset(preset)
+		if err != nil {
+			return "", err
+		}
+
+		x265ParamValue, err := mergeEncoderParams(fmt.Sprintf("keyint=%d", keyInt), o.x265Params)
+		if err != nil {
+			return "", err
+		}
+
+		params.
+			Set(videoCodecKey, encoderH265).
+			Set(x265ParamsKey, x265ParamValue).
+			Set(presetKey, preset).
+			Set(crfKey, fmt.Sprintf("%d", crf)).
+			Set(audioCodecKey, "copy").
+			Set("-tag:v", "hvc1")
+	case encoderH264:
+		// https://trac.ffmpeg.org/wiki/Encode/H.264
+		if crf == 0 {
+			crf = defaultCRFs[encoderH264]
+		}
+
+		preset, err := findPreset(preset)
+		if err != nil {
+			return "", err
+		}
+
+		x264ParamValue, err := mergeEncoderParams(fmt.Sprintf("keyint=%d", keyInt), o.x264Params)
+		if err != nil {
+			return "", err
+		}
+
+		params.
+			Set(videoCodecKey, encoderH264).
+			Set(x264ParamsKey, x264ParamValue).
+			Set(presetKey, preset).
+			Set(crfKey, fmt.Sprintf("%d", crf)).
+			Set(audioCodecKey, "copy")
+	case encoderVP9:
+		// https://trac.ffmpeg.org/wiki/Encode/VP9
+		params.
+			Delete(presetKey).
+			Set(videoCodecKey, encoderVP9).
+			Set(keyFrameKey, fmt.Sprintf("%d", keyInt)).
+			Set(crfKey, fmt.Sprintf("%d", crf)).
+			Set(audioCodecKey, "copy")
+
+		if crf == 0 {
+			params.
+				Delete(crfKey).
+				Set(losslessKey, "1")
+		}
+	case encoderProRes, encoderDNxHR:
+		// https://trac.ffmpeg.org/wiki/Encode/VFX
+		// every frame of intermediate codecs is a key frame and the quality is set by the profile
+		if o.bitDepth != 0 {
+			return "", fmt.Errorf("bit depth is set by the profile for encoder. encoder: %s", codec)
+		}
+
+		p, err := getIntermediateProfile(codec, o.profile)
+		if err != nil {
+			return "", err
+		}
+		o.profile = p.profile
+
+		params.
+			Delete(presetKey).
+			Delete(crfKey).
+			Set(videoCodecKey, codec).
+			Set(pixelFormatKey, p.pixelFormat).
+			Set(audioCodecKey, "pcm_s16le")
+	}
+
+	// the hvc1 tag is only understood by the QuickTime family of containers
+	if extNew != "mp4" && extNew != "mov" {
+		params.Delete("-tag:v")
+	}
+
+	// webm only allows opus and vorbis audio
+	if extNew == "webm" {
+		params.Set(audioCodecKey, "libopus")
+	}
+
+	if o.tune != "" {
+		tune, err := findTune(codec, o.tune)
+		if err != nil {
+			return "", err
+		}
+
+		params.Set(tuneKey, tune)
+	}
+
+	if o.cfr {
+		params.Set(fpsModeKey, "cfr")
+	}
+
+	if hwaccel == hwaccelAutoDetect {
+		hwaccel = detectHWAccel(st.log, codec, defaultHWAccelCachePath(), func(backend, encoder string) bool {
+			return probeHWEncoder(st, backend, encoder)
+		})
+		st.log.Printf("detected hardware acceleration: %q", hwaccel)
+	}
+
+	hwEncoder, ok := hwaccelEncoders[hwaccel][codec]
+	if ok {
+		params.
+			Delete(presetKey).
+			Delete(crfKey).
+			Delete(tuneKey).
+			Set(videoCodecKey, hwEncoder).
+			Set(keyFrameKey, fmt.Sprintf("%d", keyInt))
+
+		if hwaccel == hwaccelVAAPI {
+			device := hwaccelDevice
+			if device == "" {
+				device = defaultVAAPIDevice
+			}
+
+			params.
+				Set(vaapiDeviceKey, device).
+				Set(videoFilterKey, fmt.Sprintf(vaapiFilter, "nv12"))
+		}
+	} else {
+		params.
+			Delete(hwaccelKey).
+			Delete(hwaccelDeviceKey)
+	}
+
+	if o.bitDepth != 0 {
+		if ok && codec == encoderH264 && o.bitDepth == 10 {
+			return "", fmt.Errorf("10-bit encoding is not supported by encoder. encoder: %s", hwEncoder)
+		}
+
+		pixelFormat, err := getPixelFormat(o.bitDepth, ok)
+		if err != nil {
+			return "", err
+		}
+
+		if hwaccel == hwaccelVAAPI && ok {
+			params.Set(videoFilterKey, fmt.Sprintf(vaapiFilter, strings.TrimSuffix(pixelFormat, "le")))
+		} else {
+			params.Set(pixelFormatKey, pixelFormat)
+		}
+	}
+
+	profile := o.profile
+	if profile == "" && o.bitDepth != 0 {
+		profile = profiles[codec][o.bitDepth]
+	}
+	if profile != "" {
+		params.Set(profileKey, profile)
+	}
+
+	// intermediate codecs have no bit rate control
+	if _, intermediate := intermediateProfiles[codec]; hwaccel != "" && !intermediate {
+		avgBitRate, maxBitRate, err := getNewBitRates(st, fi, codec)
+		if err != nil {
+			return "", fmt.Errorf("unable to get bit rates. err: %w", err)
+		}
+
+		params.
+			Set(bitRateKey, avgBitRate).
+			Set(maxRateKey, maxBitRate).
+			Set(bufsizeKey, maxBitRate)
+	}
+
+	outputName, err := getReEncodeName(basePath, params, o.nameTemplate)
+	if err != nil {
+		return "", err
+	}
+
+	outputDir := filepath.Dir(filePath)
+	if o.outputDir != "" {
+		outputDir = o.outputDir
+	}
+
+	outputPath := filepath.Join(outputDir, fmt.Sprintf("%s.%s", outputName, extNew))
+	if filepath.Clean(outputPath) == filepath.Clean(filePath) {
+		return "", fmt.Errorf("output would overwrite the input, use --output-dir or --name. file: %q", filePath)
+	}
+
+	command := params.Args(outputPath)
+
+	st.log.Printf("new path: %s", outputPath)
+	command = showCommand(st, command)
+
+	if err := checkRoot(st, filePath, outputPath); err != nil {
+		return "", err
+	}
+
+	if dryRun {
+		planEncode(st, filePath, outputPath)
+
+		return outputPath, nil
+	}
+
+	if o.outputDir != "" {
+		err = createDirs(st, o.outputDir)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	output, err := exec(st, command)
+	st.log.Println(output)
+
+	if err != nil {
+		return outputPath, &EncodeError{Path: filePath, Operation: "re-encode video", Err: err}
+	}
+
+	recordEncode(st, filePath, outputPath)
+
+	return outputPath, nil
+}
+
+const renditionAudio = "audio"
+
+type rendition struct {
+	preset string
+	height int
+	codec  string
+}
+
+// parseRendition parses an output specification like "720p:libx264", "1080p" or "audio"
+func parseRendition(spec, defaultCodec string) (rendition, error) {
+	preset, codec, _ := strings.Cut(spec, ":")
+	if preset == renditionAudio {
+		return rendition{preset: preset}, nil
+	}
+
+	if codec == "" {
+		codec = defaultCodec
+	}
+
+	if codec != encoderH264 && codec != encoderH265 && codec != encoderVP9 {
+		return rendition{}, fmt.Errorf("invalid codec. output: %q, codec: %s", spec, codec)
+	}
+
+	_, height := getPresetDimensions(preset, 0, 0)
+	if height == 0 {
+		return rendition{}, fmt.Errorf("invalid dimension preset. output: %q, preset: %s", spec, preset)
+	}
+
+	return rendition{preset: preset, height: height, codec: codec}, nil
+}
+
+// defaultCRFs contains the crf used for each codec if none is set
+var defaultCRFs = map[string]int{
+	encoderH265: 23,
+	encoderH264: 20,
+	encoderVP9:  31,
+}
+
+// reEncodeOutputs decodes a file once and encodes it into several renditions at once
+func reEncodeOutputs(st *state, fi os.FileInfo, outputs []string, o reEncodeOptions, dryRun bool) ([]string, error) {
+	filePath := fi.Name()
+
+	basePath := filepath.Base(filePath)
+	ext := filepath.Ext(filePath)
+	if ext != "" {
+		basePath = basePath[:len(basePath)-len(ext)]
+	}
+
+	keyInt, err := getKeyInt(st, fi, o.keyInt, o.allIntra)
+	if err != nil {
+		return nil, err
+	}
+
+	warnHDR(st, fi, o)
+
+	command := []string{"ffmpeg", inputKey, filePath}
+	var outputPaths []string
+
+	for _, spec := range outputs {
+		r, err := parseRendition(spec, o.codec)
+		if err != nil {
+			return nil, err
+		}
+
+		var outputPath string
+		if r.preset == renditionAudio {
+			encoder := audioEncoders[defaultAudioCodec]
+			outputPath = filepath.Join(filepath.Dir(filePath), fmt.Sprintf("%s-%s.%s", basePath, renditionAudio, encoder.ext))
+			command = append(command, "-map", "0:a", "-vn", audioCodecKey, encoder.name, outputPath)
+		} else {
+			crf := o.crf
+			if crf == 0 {
+				crf = defaultCRFs[r.codec]
+			}
+
+			extNew := "mp4"
+			codecParams := []string{videoCodecKey, r.codec, crfKey, fmt.Sprintf("%d", crf), presetKey, o.preset}
+			if r.codec == encoderVP9 {
+				extNew = "mkv"
+				codecParams = []string{videoCodecKey, r.codec, crfKey, fmt.Sprintf("%d", crf), bitRateKey, "0"}
+			}
+
+			outputPath = filepath.Join(filepath.Dir(filePath), fmt.Sprintf("%s-%s-%s.%s", basePath, r.preset, r.codec, extNew))
+			command = append(command, "-map", "0:"+videoStreamSpecifier(st), "-map", "0:a?", videoFilterKey, fmt.Sprintf("scale=-2:%d", r.height))
+			command = append(command, codecParams...)
+			if o.cfr {
+				command = append(command, fpsModeKey, "cfr")
+			}
+			command = append(command, keyFrameKey, fmt.Sprintf("%d", keyInt), audioCodecKey, "copy", outputPath)
+		}
+
+		outputPaths = append(outputPaths, outputPath)
+	}
+
+	st.log.Printf("new paths: %s", strings.Join(outputPaths, ", "))
+	command = showCommand(st, command)
+
+	if err := checkRoot(st, append([]string{filePath}, outputPaths...)...); err != nil {
+		return nil, err
+	}
+
+	if dryRun {
+		for _, outputPath := range outputPaths {
+			planEncode(st, filePath, outputPath)
+		}
+
+		return outputPaths, nil
+	}
+
+	output, err := exec(st, command)
+	st.log.Println(output)
+
+	if err != nil {
+		return nil, &EncodeError{Path: filePath, Operation: "encode outputs", Err: err}
+	}
+
+	for _, outputPath := range outputPaths {
+		recordEncode(st, filePath, outputPath)
+	}
+
+	return outputPaths, nil
+}
+
+// sizeSafetyMargin is the part of a target size given to the streams, the rest is left for the container overhead and
+// for the encoder missing the requested bit rate
+const sizeSafetyMargin = 0.96
+
+// minTargetVideoBitRate is the lowest video bit rate in kbit/s worth encoding for a target size
+const minTargetVideoBitRate = 64
+
+// targetSizePresets contains the upload limits of common messengers in bytes
+var targetSizePresets = map[string]int64{
+	"discord":       10_000_000,
+	"discord-nitro": 500_000_000,
+	"whatsapp":      16_000_000,
+	"telegram":      2_000_000_000,
+}
+
+var sizeUnits = map[string]int64{
+	"":    1,
+	"b":   1,
+	"k":   1_000,
+	"kb":  1_000,
+	"m":   1_000_000,
+	"mb":  1_000_000,
+	"g":   1_000_000_000,
+	"gb":  1_000_000_000,
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+}
+
+var targetSizeRegexp = regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*([a-z]*)$`)
+
+// parseTargetSize parses a size like "8MB", "1.5GiB" or the name of a messenger preset like "discord" into bytes
+func parseTargetSize(spec string) (int64, error) {
+	spec = strings.ToLower(strings.TrimSpace(spec))
+	if size, ok := targetSizePresets[spec]; ok {
+		return size, nil
+	}
+
+	m := targetSizeRegexp.FindStringSubmatch(spec)
+	if m == nil {
+		return 0, fmt.Errorf("invalid target size. size: %s", spec)
+	}
+
+	unit, ok := sizeUnits[m[2]]
+	if !ok {
+		return 0, fmt.Errorf("invalid target size unit. size: %s, unit: %s", spec, m[2])
+	}
+
+	n, err := strconv.ParseFloat(m[1], 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid target size. size: %s", spec)
+	}
+
+	return int64(n * float64(unit)), nil
+}
+
+// sizeBudget is the split of the bit rate allowed by a target size, bit rates are in kbit/s
+type sizeBudget struct {
+	video  int
+	audio  int
+	height int
+}
+
+// audioBudgets and heightBudgets contain the audio bit rates and the video heights used from a minimum total or video
+// bit rate, the best one available is used
+var (
+	audioBudgets  = [][2]int{{1000, 128}, {400, 96}, {150, 64}, {0, 32}}
+	heightBudgets = [][2]int{{4000, 1080}, {2000, 720}, {1000, 540}, {500, 480}, {250, 360}, {0, 240}}
+)
+
+// getSizeBudget splits the bit rate allowed by a target size between video and audio. A positive audioBitRate is kept
+// as is, otherwise the audio gets a share fitting the total. The height is capped so that low bit rates are not spread
+// over too many pixels.
+func getSizeBudget(targetSize int64, length float64, audioBitRate int, hasAudio bool) (sizeBudget, error) {
+	if length <= 0 {
+		return sizeBudget{}, fmt.Errorf("invalid length. length: %.1f", length)
+	}
+
+	total := int(float64(targetSize) * 8 * sizeSafetyMargin / length / 1000)
+
+	audio := 0
+	if hasAudio {
+		audio = audioBitRate
+		for _, b := range audioBudgets {
+			if audio > 0 {
+				break
+			}
+			if total >= b[0] {
+				audio = b[1]
+			}
+		}
+	}
+
+	video := total - audio
+	if video < minTargetVideoBitRate {
+		return sizeBudget{}, fmt.Errorf("target size is too small for the length of the video. size: %d, length: %.1f", targetSize, length)
+	}
+
+	height := 0
+	for _, b := range heightBudgets {
+		if video >= b[0] {
+			height = b[1]
+
+			break
+		}
+	}
+
+	return sizeBudget{video: video, audio: audio, height: height}, nil
+}
+
+// getPassArgs returns the arguments of a pass of a two-pass encoding, x265 only takes them as encoder parameters
+func getPassArgs(codec string, pass int, passLog string) []string {
+	if codec == encoderH265 {
+		return []string{x265ParamsKey, fmt.Sprintf("pass=%d:stats=%s.log", pass, passLog)}
+	}
+
+	return []string{"-pass", strconv.Itoa(pass), "-passlogfile", passLog}
+}
+
+// ffmpegCommand returns the beginning of an ffmpeg command, which overwrites its outputs without asking if
+// forceOverwrite is set
+func ffmpegCommand(forceOverwrite bool) []string {
+	if forceOverwrite {
+		return []string{"ffmpeg", "-y"}
+	}
+
+	return []string{"ffmpeg"}
+}
+
+// encodeToSize encodes a video in two passes at the bit rate which makes it fit a target size, e.g. the upload limit
+// of a messenger. The video is scaled down if the bit rate is too low for its resolution.
+func encodeToSize(st *state, fi os.FileInfo, targetSize int64, label, codec, preset string, audioBitRate, maxHeight int, forceOverwrite, dryRun bool) (string, error) {
+	filePath := fi.Name()
+
+	basePath := filepath.Base(filePath)
+	ext := filepath.Ext(filePath)
+	if ext != "" {
+		basePath = basePath[:len(basePath)-len(ext)]
+	}
+
+	extNew, audioCodec := "mp4", "aac"
+	switch codec {
+	case encoderH264, encoderH265:
+		var err error
+		preset, err = findPreset(preset)
+		if err != nil {
+			return "", err
+		}
+	case encoderVP9:
+		extNew, audioCodec = "webm", "libopus"
+	default:
+		return "", fmt.Errorf("invalid codec for target size. codec: %s", codec)
+	}
+
+	length, err := getLength(st, fi)
+	if err != nil {
+		return "", err
+	}
+
+	streams, err := getStreams(st, fi)
+	if err != nil {
+		return "", err
+	}
+	hasAudio := false
+	for _, stream := range streams {
+		if stream.streamType == streamTypeAudio {
+			hasAudio = true
+		}
+	}
+
+	budget, err := getSizeBudget(targetSize, length, audioBitRate, hasAudio)
+	if err != nil {
+		return "", err
+	}
+	if maxHeight > 0 && maxHeight < budget.height {
+		budget.height = maxHeight
+	}
+
+	st.log.Printf("file: %s, video: %dk, audio: %dk, max height: %d", filePath, budget.video, budget.audio, budget.height)
+
+	passLog := "<passlog>"
+	if !dryRun {
+		dir, err := st.workspace.MkdirTemp(filePath, "pass-")
+		if err != nil {
+			return "", fmt.Errorf("failed to create pass log directory. err: %w", err)
+		}
+		defer os.RemoveAll(dir)
+
+		passLog = filepath.Join(dir, "pass")
+	}
+
+	outputPath := filepath.Join(filepath.Dir(filePath), fmt.Sprintf("%s-%s.%s", basePath, strings.ReplaceAll(label, " ", ""), extNew))
+
+	video := []string{
+		inputKey, filePath,
+		"-map", "0:" + videoStreamSpecifier(st),
+		videoFilterKey, fmt.Sprintf("scale=-2:'min(ih,%d)'", budget.height),
+		videoCodecKey, codec,
+		bitRateKey, fmt.Sprintf("%dk", budget.video),
+	}
+	if codec != encoderVP9 {
+		video = append(video, presetKey, preset)
+	}
+
+	firstPass := append(ffmpegCommand(forceOverwrite), video...)
+	firstPass = append(firstPass, getPassArgs(codec, 1, passLog)...)
+	firstPass = append(firstPass, "-an", "-f", "null", "-")
+
+	secondPass := append(ffmpegCommand(forceOverwrite), video...)
+	secondPass = append(secondPass, getPassArgs(codec, 2, passLog)...)
+	if hasAudio {
+		secondPass = append(secondPass, "-map", "0:a:0", audioCodecKey, audioCodec, "-b:a", fmt.Sprintf("%dk", budget.audio))
+	}
+	if extNew == "mp4" {
+		secondPass = append(secondPass, "-movflags", "+faststart")
+	}
+	secondPass = append(secondPass, outputPath)
+
+	st.log.Printf("new path: %s", outputPath)
+	firstPass = showCommand(st, firstPass)
+	secondPass = showCommand(st, secondPass)
+
+	if err := checkRoot(st, filePath, outputPath); err != nil {
+		return "", err
+	}
+
+	if err := checkCollision(st, outputPath, forceOverwrite); err != nil {
+		return "", err
+	}
+
+	if dryRun {
+		planEncode(st, filePath, outputPath)
+
+		return outputPath, nil
+	}
+
+	for _, command := range [][]string{firstPass, secondPass} {
+		output, err := exec(st, command)
+		if err != nil {
+			st.log.Println(output)
+
+			return "", &EncodeError{Path: filePath, Operation: "encode to target size", Err: err}
+		}
+	}
+
+	recordEncode(st, filePath, outputPath)
+
+	stat, err := os.Stat(outputPath)
+	if err != nil {
+		return outputPath, err
+	}
+
+	if stat.Size() > targetSize {
+		return outputPath, &EncodeError{Path: filePath, Operation: "encode to target size", Err: fmt.Errorf("output is larger than the target size. size: %d, target: %d", stat.Size(), targetSize)}
+	}
+
+	st.log.Printf("output size: %d bytes, target: %d bytes", stat.Size(), targetSize)
+
+	return outputPath, nil
+}
+
+func (a App) reEncode(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
+	o := reEncodeOptions{
+		codec:         c.String(codecFlag),
+		crf:           c.Int(crfFlag),
+		preset:        c.String(presetFlag),
+		hwaccel:       c.String(hwaccelFlag),
+		hwaccelDevice: c.String(hwaccelDeviceFlag),
+		bitDepth:      c.Int(bitDepthFlag),
+		profile:       c.String(profileFlag),
+		tune:          c.String(tuneFlag),
+		x265Params:    c.String(x265ParamsFlag),
+		x264Params:    c.String(x264ParamsFlag),
+		keyInt:        c.String(keyIntFlag),
+		allIntra:      c.Bool(allIntraFlag),
+		cfr:           c.Bool(cfrFlag),
+		container:     c.String(containerFlag),
+		nameTemplate:  c.String(nameTemplateFlag),
+		outputDir:     c.String(outputDirFlag),
+		force:         c.Bool(forceFlag),
+	}
+
+	if c.Bool(noSuffixFlag) {
+		if o.nameTemplate != "" {
+			return fmt.Errorf("--%s and --%s can not be used together", noSuffixFlag, nameTemplateFlag)
+		}
+
+		o.nameTemplate = noSuffixReEncodeName
+	}
+
+	if spec := c.String(targetSizeFlag); spec != "" {
+		targetSize, err := parseTargetSize(spec)
+		if err != nil {
+			return err
+		}
+
+		// H.264 plays everywhere, so it is the default for files meant to be shared
+		if !c.IsSet(codecFlag) {
+			o.codec = encoderH264
+		}
+
+		audioBitRate := 0
+		if c.IsSet(audioBitRateFlag) {
+			audioBitRate, err = strconv.Atoi(strings.TrimSuffix(strings.ToLower(c.String(audioBitRateFlag)), "k"))
+			if err != nil {
+				return fmt.Errorf("invalid audio bit rate. bit rate: %s", c.String(audioBitRateFlag))
+			}
+		}
+
+		_, err = encodeToSize(a.state, fi, targetSize, spec, o.codec, o.preset, audioBitRate, c.Int(maxHeightFlag), c.Bool(forceFlag), dryRun)
+
+		return err
+	}
+
+	outputs := c.StringSlice(outputsFlag)
+	if len(outputs) > 0 {
+		_, err := reEncodeOutputs(a.state, fi, outputs, o, dryRun)
+
+		return err
+	}
+
+	_, err := reEncode(a.state, fi, o, dryRun)
+
+	return err
+}
+
+const (
+	proxyH264   = "h264"
+	proxyDNxHR  = "dnxhr"
+	proxyProRes = "prores"
+)
+
+// proxyDirName is the directory proxies are created in, next to the source files. Editors link proxies to their
+// sources by their names, so the names are kept.
+const proxyDirName = "Proxy"
+
+const defaultProxyHeight = 540
+
+type proxyFormat struct {
+	ext    string
+	params []string
+}
+
+// proxyFormats contains the encoding parameters of the proxy formats supported by common editors. Every frame is a
+// key frame so that scrubbing and cutting is fast.
+var proxyFormats = map[string]proxyFormat{
+	proxyH264: {
+		ext:    "mp4",
+		params: []string{videoCodecKey, encoderH264, crfKey, "23", presetKey, "veryfast", pixelFormatKey, "yuv420p", audioCodecKey, "aac"},
+	},
+	proxyDNxHR: {
+		ext:    "mov",
+		params: []string{videoCodecKey, encoderDNxHR, profileKey, "dnxhr_lb", pixelFormatKey, "yuv422p", audioCodecKey, "pcm_s16le"},
+	},
+	proxyProRes: {
+		ext:    "mov",
+		params: []string{videoCodecKey, encoderProRes, profileKey, "0", pixelFormatKey, "yuv422p10le", audioCodecKey, "pcm_s16le"},
+	},
+}
+
+// proxy creates a low resolution, all-intra editing proxy of a video in the Proxy directory next to it
+func proxy(st *state, fi os.FileInfo, format string, height int, forceOverwrite, dryRun bool) (string, error) {
+	filePath := fi.Name()
+
+	basePath := filepath.Base(filePath)
+	ext := filepath.Ext(filePath)
+	if ext != "" {
+		basePath = basePath[:len(basePath)-len(ext)]
+	}
+
+	pf, ok := proxyFormats[format]
+	if !ok {
+		return "", fmt.Errorf("invalid proxy format. format: %s", format)
+	}
+
+	if height == 0 {
+		height = defaultProxyHeight
+	}
+	if height < 0 || height%2 != 0 {
+		return "", fmt.Errorf("invalid proxy height, it must be positive and even. height: %d", height)
+	}
+
+	keyInt, err := getKeyInt(st, fi, "", true)
+	if err != nil {
+		return "", err
+	}
+
+	outputDir := filepath.Join(filepath.Dir(filePath), proxyDirName)
+	outputPath := filepath.Join(outputDir, fmt.Sprintf("%s.%s", basePath, pf.ext))
+
+	command := []string{"ffmpeg", inputKey, filePath, "-map", "0:" + videoStreamSpecifier(st), "-map", "0:a?", videoFilterKey, fmt.Sprintf("scale=-2:%d", height)}
+	command = append(command, pf.params...)
+	command = append(command, keyFrameKey, fmt.Sprintf("%d", keyInt), outputPath)
+
+	st.log.Printf("new path: %s", outputPath)
+	command = showCommand(st, command)
+
+	if err := checkRoot(st, filePath, outputPath); err != nil {
+		return "", err
+	}
+
+	if err := checkCollision(st, outputPath, forceOverwrite); err != nil {
+		return "", err
+	}
+
+	if dryRun {
+		planEncode(st, filePath, outputPath)
+
+		return outputPath, nil
+	}
+
+	err = createDirs(st, outputDir)
+	if err != nil {
+		return "", err
+	}
+
+	output, err := exec(st, command)
+	if err != nil {
+		st.log.Println(output)
+
+		return "", &EncodeError{Path: filePath, Operation: "create proxy", Err: err}
+	}
+
+	recordEncode(st, filePath, outputPath)
+
+	return outputPath, nil
+}
+
+func (a App) proxy(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
+	forceOverwrite := c.Bool(forceFlag)
+
+	format := c.String(proxyFormatFlag)
+	height := c.Int(proxyHeightFlag)
+
+	_, err := proxy(a.state, fi, format, height, forceOverwrite, dryRun)
+
+	return err
+}
+
+const (
+	scanProgressive = "progressive"
+	scanInterlaced  = "interlaced"
+	scanTelecined   = "telecined"
+)
+
+// restoreAnalyzeFrames is the number of frames analyzed to detect the scan type and the black borders of a video
+const restoreAnalyzeFrames = 2000
+
+const defaultRestorePreset = "slow"
+
+var (
+	idetMultiRegexp    = regexp.MustCompile(`Multi frame detection: TFF:\s*(\d+)\s+BFF:\s*(\d+)\s+Progressive:\s*(\d+)`)
+	idetRepeatedRegexp = regexp.MustCompile(`Repeated Fields: Neither:\s*(\d+)\s+Top:\s*(\d+)\s+Bottom:\s*(\d+)`)
+	cropDetectRegexp   = regexp.MustCompile(`crop=(\d+:\d+:\d+:\d+)`)
+)
+
+// parseScanType returns the scan type and the field order found by the idet filter. Telecined videos repeat a field in
+// two out of every five frames, so a fifth of the frames having repeated fields is a safe sign of pulldown.
+func parseScanType(output string) (string, string) {
+	atoi := func(s string) int {
+		n, _ := strconv.Atoi(s)
+
+		return n
+	}
+
+	var tff, bff, progressive, neither, repeated int
+	if m := idetMultiRegexp.FindStringSubmatch(output); m != nil {
+		tff, bff, progressive = atoi(m[1]), atoi(m[2]), atoi(m[3])
+	}
+	if m := idetRepeatedRegexp.FindStringSubmatch(output); m != nil {
+		neither, repeated = atoi(m[1]), atoi(m[2])+atoi(m[3])
+	}
+
+	parity := "tff"
+	if bff > tff {
+		parity = "bff"
+	}
+
+	switch {
+	case repeated > 0 && repeated*5 >= neither+repeated:
+		return scanTelecined, parity
+	case tff+bff > progressive:
+		return scanInterlaced, parity
+	}
+
+	return scanProgressive, parity
+}
+
+// parseCropDetect returns the crop area reported most often by the cropdetect filter as width:height:x:y, fades and
+// dark scenes make single reports unreliable
+func parseCropDetect(output string) string {
+	counts := map[string]int{}
+	best := ""
+	for _, m := range cropDetectRegexp.FindAllStringSubmatch(output, -1) {
+		counts[m[1]]++
+		if counts[m[1]] > counts[best] {
+			best = m[1]
+		}
+	}
+
+	return best
+}
+
+// getRestoreFilter returns the filter graph undoing the telecine or the interlacing of a video and cropping its
+// black borders. Interlaced videos are deinterlaced to one frame per field to keep their motion smooth.
+func getRestoreFilter(scanType, parity, cropArea, dimensions string) string {
+	var filters []string
+	switch scanType {
+	case scanTelecined:
+		filters = append(filters, "fieldmatch", "yadif=deint=interlaced", "decimate")
+	case scanInterlaced:
+		filters = append(filters, fmt.Sprintf("bwdif=mode=send_field:parity=%s", parity))
+	}
+
+	if cropArea != "" && cropArea != strings.Replace(dimensions, "x", ":", 1)+":0:0" {
+		filters = append(filters, "crop="+cropArea)
+	}
+
+	return strings.Join(filters, ",")
+}
+
+// analyzeSD detects the scan type, the field order and the black borders of a video in a single decoding pass
+func analyzeSD(st *state, fi os.FileInfo) (string, string, string, error) {
+	command := []string{
+		"ffmpeg", "-hide_banner", "-nostats",
+		inputKey, fi.Name(),
+		"-map", "0:" + videoStreamSpecifier(st),
+		"-frames:v", strconv.Itoa(restoreAnalyzeFrames),
+		videoFilterKey, "idet,cropdetect=round=2",
+		"-f", "null", "-",
+	}
+
+	output, err := exec(st, command)
+	if err != nil {
+		return "", "", "", fmt.Errorf("failed to analyze video. file: %q, err: %w", fi.Name(), err)
+	}
+
+	scanType, parity := parseScanType(output)
+
+	return scanType, parity, parseCropDetect(output), nil
+}
+
+// restoreSD deinterlaces or inverse telecines a standard definition video as needed, crops its black borders and
+// encodes it at a constant quality, e.g. for digitized home videos and DVD rips
+func restoreSD(st *state, fi os.FileInfo, codec string, crf int, preset string, forceOverwrite, dryRun bool) (string, error) {
+	filePath := fi.Name()
+
+	basePath := filepath.Base(filePath)
+	ext := filepath.Ext(filePath)
+	if ext != "" {
+		basePath = basePath[:len(basePath)-len(ext)]
+	}
+
+	if crf == 0 {
+		crf = defaultCRFs[codec]
+	}
+	if crf == 0 {
+		return "", fmt.Errorf("crf is required for codec. codec: %s", codec)
+	}
+
+	preset, err := findPreset(preset)
+	if err != nil {
+		return "", err
+	}
+
+	dimensions, err := getDimensions(st, fi)
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve video dimensions. err: %w", err)
+	}
+
+	scanType, parity, cropArea, err := analyzeSD(st, fi)
+	if err != nil {
+		return "", err
+	}
+
+	st.log.Printf("file: %s, scan type: %s, field order: %s, crop: %s", filePath, scanType, parity, cropArea)
+
+	outputPath := filepath.Join(filepath.Dir(filePath), fmt.Sprintf("%s-restored.mkv", basePath))
+
+	command := []string{"ffmpeg", inputKey, filePath, "-map", "0:" + videoStreamSpecifier(st), "-map", "0:a?", "-map", "0:s?"}
+	if filter := getRestoreFilter(scanType, parity, cropArea, dimensions); filter != "" {
+		command = append(command, videoFilterKey, filter)
+	}
+	command = append(command, videoCodecKey, codec, crfKey, strconv.Itoa(crf), presetKey, preset, audioCodecKey, "copy", "-c:s", "copy", outputPath)
+
+	st.log.Printf("new path: %s", outputPath)
+	command = showCommand(st, command)
+
+	if err := checkRoot(st, filePath, outputPath); err != nil {
+		return "", err
+	}
+
+	if err := checkCollision(st, outputPath, forceOverwrite); err != nil {
+		return "", err
+	}
+
+	if dryRun {
+		planEncode(st, filePath, outputPath)
+
+		return outputPath, nil
+	}
+
+	output, err := exec(st, command)
+	if err != nil {
+		st.log.Println(output)
+
+		return "", &EncodeError{Path: filePath, Operation: "restore video", Err: err}
+	}
+
+	recordEncode(st, filePath, outputPath)
+
+	return outputPath, nil
+}
+
+func (a App) restoreSD(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
+	forceOverwrite := c.Bool(forceFlag)
+
+	codec := c.String(codecFlag)
+	crf := c.Int(crfFlag)
+	preset := defaultRestorePreset
+	if c.IsSet(presetFlag) {
+		preset = c.String(presetFlag)
+	}
+
+	_, err := restoreSD(a.state, fi, codec, crf, preset, forceOverwrite, dryRun)
+
+	return err
+}
+
+const (
+	archiveFFV1 = "ffv1"
+	archiveX265 = "x265"
+)
+
+// archiveCodecParams contains the parameters of the lossless archival codecs. FFV1 version 3 is used with a checksum
+// per slice so that damage can be detected and localized, as recommended for preservation.
+var archiveCodecParams = map[string][]string{
+	archiveFFV1: {videoCodecKey, "ffv1", "-level", "3", "-coder", "1", "-context", "1", keyFrameKey, "1", "-slices", "24", "-slicecrc", "1"},
+	archiveX265: {videoCodecKey, encoderH265, x265ParamsKey, "lossless=1", presetKey, "medium"},
+}
+
+// frameHashes returns the MD5 hashes of the decoded frames of the selected video stream of a file
+func frameHashes(st *state, filePath string) ([]string, error) {
+	output, err := exec(st, []string{"ffmpeg", "-v", "error", inputKey, filePath, "-map", "0:" + videoStreamSpecifier(st), fpsModeKey, "passthrough", "-f", "framemd5", "-"})
+	if err != nil {
+		return nil, &EncodeError{Path: filePath, Operation: "hash frames", Err: err}
+	}
+
+	var hashes []string
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, ",")
+		hashes = append(hashes, strings.TrimSpace(fields[len(fields)-1]))
+	}
+
+	return hashes, nil
+}
+
+// verifyArchive checks that the frames of the archive decode to the same pixels as the frames of the source.
+// Timestamps are not compared as containers store them in different time bases.
+func verifyArchive(st *state, sourcePath, archivePath string) error {
+	want, err := frameHashes(st, sourcePath)
+	if err != nil {
+		return err
+	}
+
+	got, err := frameHashes(st, archivePath)
+	if err != nil {
+		return err
+	}
+
+	if len(want) == 0 {
+		return fmt.Errorf("no frames to verify. path: %q", sourcePath)
+	}
+
+	if len(got) != len(want) {
+		return fmt.Errorf("archive has a different number of frames. path: %q, source: %d, archive: %d", archivePath, len(want), len(got))
+	}
+
+	for i := range want {
+		if got[i] != want[i] {
+			return fmt.Errorf("archive frame differs from the source. path: %q, frame: %d", archivePath, i)
+		}
+	}
+
+	return nil
+}
+
+// archive encodes a video losslessly into Matroska for preservation, keeping all other streams as they are, then
+// verifies the decoded frames against the source unless skipVerify is set
+func archive(st *state, fi os.FileInfo, codec string, skipVerify, forceOverwrite, dryRun bool) (string, error) {
+	filePath := fi.Name()
+
+	basePath := filepath.Base(filePath)
+	ext := filepath.Ext(filePath)
+	if ext != "" {
+		basePath = basePath[:len(basePath)-len(ext)]
+	}
+
+	codecParams, ok := archiveCodecParams[codec]
+	if !ok {
+		return "", fmt.Errorf("invalid archive codec. codec: %s", codec)
+	}
+
+	outputPath := filepath.Join(filepath.Dir(filePath), fmt.Sprintf("%s-archive-%s.mkv", basePath, codec))
+
+	command := []string{"ffmpeg", inputKey, filePath, "-map", "0", "-c", "copy"}
+	command = append(command, codecParams...)
+	command = append(command, outputPath)
+
+	st.log.Printf("new path: %s", outputPath)
+	command = showCommand(st, command)
+
+	if err := checkRoot(st, filePath, outputPath); err != nil {
+		return "", err
+	}
+
+	if err := checkCollision(st, outputPath, forceOverwrite); err != nil {
+		return "", err
+	}
+
+	if dryRun {
+		planEncode(st, filePath, outputPath)
+
+		return outputPath, nil
+	}
+
+	output, err := exec(st, command)
+	if err != nil {
+		st.log.Println(output)
+
+		return "", &EncodeError{Path: filePath, Operation: "archive", Err: err}
+	}
+
+	recordEncode(st, filePath, outputPath)
+
+	if skipVerify {
+		return outputPath, nil
+	}
+
+	err = verifyArchive(st, filePath, outputPath)
+	if err != nil {
+		return outputPath, err
+	}
+
+	st.log.Printf("archive verified: %q", outputPath)
+
+	return outputPath, nil
+}
+
+func (a App) archive(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
+	forceOverwrite := c.Bool(forceFlag)
+
+	codec := c.String(archiveCodecFlag)
+	skipVerify := c.Bool(noVerifyFlag)
+
+	_, err := archive(a.state, fi, codec, skipVerify, forceOverwrite, dryRun)
+
+	return err
+}
+
+const (
+	dvdDirName    = "VIDEO_TS"
+	blurayDirName = "BDMV"
+
+	dvdSectorSize  = 2048
+	blurayTimeBase = 45000
+)
+
+// defaultMinTitleLength is the length in seconds titles of discs need to be ingested, shorter ones are usually menus,
+// trailers and warnings
+const defaultMinTitleLength = 300
+
+// discTitle is a title of a disc, played by concatenating its segments
+type discTitle struct {
+	number   int
+	segments []string
+	length   float64
+	chapters []float64
+}
+
+var vobRegexp = regexp.MustCompile(`(?i)^VTS_(\d{2})_(\d)\.VOB$`)
+
+func bcd(b byte) int {
+	return int(b>>4)*10 + int(b&0x0f)
+}
+
+// parseDVDTime parses a playback time of a DVD, stored as BCD hours, minutes, seconds and frames, the frame rate is
+// stored in the two highest bits of the frames
+func parseDVDTime(b []byte) float64 {
+	fps := 25.0
+	if b[3]>>6 == 3 {
+		fps = 30000.0 / 1001
+	}
+
+	return float64(bcd(b[0])*3600+bcd(b[1])*60+bcd(b[2])) + float64(bcd(b[3]&0x3f))/fps
+}
+
+// parseVTSIFO returns the length and the chapter starts of the longest program chain of a DVD title set
+func parseVTSIFO(data []byte) (float64, []float64, error) {
+	if len(data) < 0xd0 || string(data[:12]) != "DVDVIDEO-VTS" {
+		return 0, nil, errors.New("not a title set information file")
+	}
+
+	pgciti := int(binary.BigEndian.Uint32(data[0xcc:])) * dvdSectorSize
+	if pgciti+8 > len(data) {
+		return 0, nil, errors.New("program chain table out of bounds")
+	}
+
+	var (
+		bestLength   float64
+		bestChapters []float64
+	)
+
+	count := int(binary.BigEndian.Uint16(data[pgciti:]))
+	for i := 0; i < count; i++ {
+		srp := pgciti + 8 + i*8
+		if srp+8 > len(data) {
+			return 0, nil, errors.New("program chain search pointer out of bounds")
+		}
+
+		pgc := pgciti + int(binary.BigEndian.Uint32(data[srp+4:]))
+		if pgc+0xec > len(data) {
+			return 0, nil, errors.New("program chain out of bounds")
+		}
+
+		programs, cells := int(data[pgc+2]), int(data[pgc+3])
+		programMap := pgc + int(binary.BigEndian.Uint16(data[pgc+0xe6:]))
+		cellPlayback := pgc + int(binary.BigEndian.Uint16(data[pgc+0xe8:]))
+		if programMap+programs > len(data) || cellPlayback+cells*24 > len(data) {
+			return 0, nil, errors.New("program chain cells out of bounds")
+		}
+
+		length := parseDVDTime(data[pgc+4:])
+		if length <= bestLength {
+			continue
+		}
+
+		cellStarts := make([]float64, cells+1)
+		for c := 0; c < cells; c++ {
+			cellStarts[c+1] = cellStarts[c] + parseDVDTime(data[cellPlayback+c*24+4:])
+		}
+
+		var chapters []float64
+		for p := 0; p < programs; p++ {
+			entryCell := int(data[programMap+p])
+			if entryCell >= 1 && entryCell <= cells {
+				chapters = append(chapters, cellStarts[entryCell-1])
+			}
+		}
+
+		bestLength, bestChapters = length, chapters
+	}
+
+	return bestLength, bestChapters, nil
+}
+
+// findDVDTitles returns the title sets of a VIDEO_TS directory, the menus in the first VOB of each set are left out
+func findDVDTitles(l *logger, dir string) ([]discTitle, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	segments := map[int][]string{}
+	var numbers []int
+	for _, entry := range entries {
+		match := vobRegexp.FindStringSubmatch(entry.Name())
+		if match == nil || match[2] == "0" {
+			continue
+		}
+
+		n, _ := strconv.Atoi(match[1])
+		if _, ok := segments[n]; !ok {
+			numbers = append(numbers, n)
+		}
+		segments[n] = append(segments[n], filepath.Join(dir, entry.Name()))
+	}
+	sort.Ints(numbers)
+
+	var titles []discTitle
+	for _, n := range numbers {
+		sort.Slice(segments[n], func(i, j int) bool {
+			return naturalLess(segments[n][i], segments[n][j])
+		})
+
+		title := discTitle{number: n, segments: segments[n]}
+
+		data, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("VTS_%02d_0.IFO", n)))
+		if err == nil {
+			title.length, title.chapters, err = parseVTSIFO(data)
+		}
+		if err != nil {
+			l.Printf("failed to read title set information, chapters are not kept. title: %d, err: %v", n, err)
+		}
+
+		titles = append(titles, title)
+	}
+
+	return titles, nil
+}
+
+// parseMPLS returns the clips, the length and the chapter starts of a Blu-ray playlist
+func parseMPLS(data []byte) ([]string, float64, []float64, error) {
+	if len(data) < 20 || string(data[:4]) != "MPLS" {
+		return nil, 0, nil, errors.New("not a playlist file")
+	}
+
+	playList := int(binary.BigEndian.Uint32(data[8:]))
+	marks := int(binary.BigEndian.Uint32(data[12:]))
+	if playList+10 > len(data) || marks+6 > len(data) {
+		return nil, 0, nil, errors.New("playlist out of bounds")
+	}
+
+	var (
+		clips   []string
+		inTimes []uint32
+		offsets []float64
+		length  float64
+	)
+
+	count := int(binary.BigEndian.Uint16(data[playList+6:]))
+	item := playList + 10
+	for i := 0; i < count; i++ {
+		if item+22 > len(data) {
+			return nil, 0, nil, errors.New("play item out of bounds")
+		}
+
+		size := int(binary.BigEndian.Uint16(data[item:]))
+		in := binary.BigEndian.Uint32(data[item+14:])
+		out := binary.BigEndian.Uint32(data[item+18:])
+
+		clips = append(clips, string(data[item+2:item+7]))
+		inTimes = append(inTimes, in)
+		offsets = append(offsets, length)
+		length += float64(out-in) / blurayTimeBase
+
+		item += 2 + size
+	}
+
+	var chapters []float64
+	markCount := int(binary.BigEndian.Uint16(data[marks+4:]))
+	for i := 0; i < markCount; i++ {
+		mark := marks + 6 + i*14
+		if mark+14 > len(data) {
+			return nil, 0, nil, errors.New("playlist mark out of bounds")
+		}
+
+		// only entry marks are chapters
+		if data[mark+1] != 1 {
+			continue
+		}
+
+		ref := int(binary.BigEndian.Uint16(data[mark+2:]))
+		if ref >= len(clips) {
+			continue
+		}
+
+		ts := binary.BigEndian.Uint32(data[mark+4:])
+		chapters = append(chapters, offsets[ref]+float64(int64(ts)-int64(inTimes[ref]))/blurayTimeBase)
+	}
+
+	return clips, length, chapters, nil
+}
+
+// findBlurayTitles returns the playlists of a BDMV directory, playlists playing the same clips as an earlier one are
+// left out
+func findBlurayTitles(l *logger, dir string) ([]discTitle, error) {
+	playlists, err := filepath.Glob(filepath.Join(globEscape(dir), "PLAYLIST", "*.mpls"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(playlists)
+
+	var titles []discTitle
+	seen := map[string]bool{}
+	for _, playlist := range playlists {
+		data, err := os.ReadFile(playlist)
+		if err != nil {
+			return nil, err
+		}
+
+		clips, length, chapters, err := parseMPLS(data)
+		if err != nil {
+			l.Printf("skipping invalid playlist. path: %q, err: %v", playlist, err)
+
+			continue
+		}
+
+		key := strings.Join(clips, ",")
+		if len(clips) == 0 || seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		n, _ := strconv.Atoi(strings.TrimSuffix(filepath.Base(playlist), filepath.Ext(playlist)))
+		title := discTitle{number: n, length: length, chapters: chapters}
+		for _, clip := range clips {
+			title.segments = append(title.segments, filepath.Join(dir, "STREAM", clip+".m2ts"))
+		}
+
+		titles = append(titles, title)
+	}
+
+	return titles, nil
+}
+
+// writeChapters writes chapters in the FFMETADATA format, each chapter lasts until the next one or the end
+func writeChapters(w io.Writer, chapters []float64, length float64) error {
+	sb := strings.Builder{}
+	sb.WriteString(";FFMETADATA1\n")
+	for i, start := range chapters {
+		end := length
+		if i+1 < len(chapters) {
+			end = chapters[i+1]
+		}
+
+		sb.WriteString(fmt.Sprintf("[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=Chapter %d\n", int64(start*1000), int64(end*1000), i+1))
+	}
+
+	_, err := io.WriteString(w, sb.String())
+
+	return err
+}
+
+// findDiscTitles finds the titles of a DVD or a Blu-ray, dir can be the root of the disc or its VIDEO_TS or BDMV
+// directory. The name of the disc is the name of its root directory.
+func findDiscTitles(l *logger, dir string) (string, []discTitle, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", nil, err
+	}
+
+	switch base := strings.ToUpper(filepath.Base(abs)); {
+	case base == dvdDirName:
+		titles, err := findDVDTitles(l, abs)
+
+		return filepath.Dir(abs), titles, err
+	case base == blurayDirName:
+		titles, err := findBlurayTitles(l, abs)
+
+		return filepath.Dir(abs), titles, err
+	}
+
+	for _, name := range []string{dvdDirName, blurayDirName} {
+		if fi, err := os.Stat(filepath.Join(abs, name)); err == nil && fi.IsDir() {
+			return findDiscTitles(l, filepath.Join(abs, name))
+		}
+	}
+
+	return "", nil, fmt.Errorf("no %s or %s directory found. dir: %q", dvdDirName, blurayDirName, dir)
+}
+
+// ingestDisc remuxes the titles of a DVD or a Blu-ray into Matroska files next to the disc, named after the disc and
+// the title. The segments of each title are concatenated with regenerated timestamps and the chapters of the disc
+// are kept. DVD title sets with several program chains, e.g. episodes, are remuxed as a whole with the chapters of the
+// longest chain.
+func ingestDisc(st *state, dir string, minLength float64, forceOverwrite, dryRun bool) ([]string, error) {
+	root, titles, err := findDiscTitles(st.log, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	discName := filepath.Base(root)
+
+	var outputPaths []string
+	for _, title := range titles {
+		if title.length > 0 && title.length < minLength {
+			st.log.Printf("skipping short title. disc: %q, title: %d, length: %s", discName, title.number, formatDuration(st, title.length))
+
+			continue
+		}
+
+		outputPath := filepath.Join(filepath.Dir(root), fmt.Sprintf("%s-title-%02d.mkv", discName, title.number))
+
+		getCommand := func(chaptersPath string) []string {
+			command := []string{
+				"ffmpeg", "-fflags", "+genpts", "-analyzeduration", "100M", "-probesize", "100M",
+				inputKey, "concat:" + strings.Join(title.segments, "|"),
+			}
+			if chaptersPath != "" {
+				command = append(command, inputKey, chaptersPath, "-map_chapters", "1")
+			}
+
+			return append(command, "-map", "0:v", "-map", "0:a?", "-map", "0:s?", "-c", "copy", outputPath)
+		}
+
+		st.log.Printf("title: %d, segments: %d, new path: %s", title.number, len(title.segments), outputPath)
+
+		if err := checkRoot(st, append([]string{outputPath}, title.segments...)...); err != nil {
+			return outputPaths, err
+		}
+
+		if err := checkCollision(st, outputPath, forceOverwrite); err != nil {
+			return outputPaths, err
+		}
+
+		if dryRun {
+			chaptersPath := ""
+			if len(title.chapters) > 0 {
+				chaptersPath = "<chapters>"
+			}
+			showCommand(st, getCommand(chaptersPath))
+			planEncode(st, title.segments[0], outputPath)
+			outputPaths = append(outputPaths, outputPath)
+
+			continue
+		}
+
+		err := ingestTitle(st, title, getCommand)
+		if err != nil {
+			return outputPaths, err
+		}
+
+		recordEncode(st, title.segments[0], outputPath)
+		outputPaths = append(outputPaths, outputPath)
+	}
+
+	return outputPaths, nil
+}
+
+// ingestTitle writes the chapters of a title to a temporary file and runs the remux
+func ingestTitle(st *state, title discTitle, getCommand func(chaptersPath string) []string) error {
+	chaptersPath := ""
+	if len(title.chapters) > 0 {
+		f, err := st.workspace.CreateTemp("", "chapters-*.txt")
+		if err != nil {
+			return fmt.Errorf("failed to create chapters. err: %w", err)
+		}
+		defer os.Remove(f.Name())
+
+		err = writeChapters(f, title.chapters, title.length)
+		if err == nil {
+			err = f.Close()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write chapters. err: %w", err)
+		}
+
+		chaptersPath = f.Name()
+	}
+
+	command := getCommand(chaptersPath)
+	command = showCommand(st, command)
+
+	output, err := exec(st, command)
+	if err != nil {
+		st.log.Println(output)
+
+		return &EncodeError{Path: title.segments[0], Operation: "ingest disc", Err: err}
+	}
+
+	return nil
+}
+
+func (a App) ingestDisc(c *cli.Context) error {
+	err := configure(a.state, c)
+	if err != nil {
+		return err
+	}
+	defer exportLogHistory(a.log, c.String(logHistoryFlag))
+
+	dirs := c.Args().Slice()
+	if len(dirs) == 0 {
+		dirs = []string{"."}
+	}
+
+	forceOverwrite := c.Bool(forceFlag)
+	dryRun := c.Bool(dryRunFlag)
+	minLength := c.Float64(minLengthFlag)
+
+	var errs []error
+	for _, dir := range dirs {
+		_, err := ingestDisc(a.state, dir, minLength, forceOverwrite, dryRun)
+		if err != nil {
+			a.log.Println(err)
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+var audioExtensions = []string{"mp3", "flac", "wav", "m4a", "aac", "ogg", "opus"}
+
+type audioEncoder struct {
+	name string
+	ext  string
+}
+
+// audioEncoders maps the audio codecs to their ffmpeg encoders and file extensions
+var audioEncoders = map[string]audioEncoder{
+	"opus": {name: "libopus", ext: "opus"},
+	"aac":  {name: "aac", ext: "m4a"},
+	"mp3":  {name: "libmp3lame", ext: "mp3"},
+	"flac": {name: "flac", ext: "flac"},
+}
+
+const defaultAudioCodec = "opus"
+
+// reEncodeAudio re-encodes an audio file. A negative vbrQuality means constant bit rate, a zero channel count keeps
+// the original channel layout.
+func reEncodeAudio(st *state, fi os.FileInfo, codec, bitRate string, vbrQuality, channels int, forceOverwrite, dryRun bool) (string, error) {
+	filePath := fi.Name()
+
+	encoder, ok := audioEncoders[codec]
+	if !ok {
+		return "", fmt.Errorf("invalid audio codec. codec: %s", codec)
+	}
+
+	basePath := filepath.Base(filePath)
+	ext := filepath.Ext(filePath)
+	if ext != "" {
+		basePath = basePath[:len(basePath)-len(ext)]
+	}
+
+	command := []string{"ffmpeg", inputKey, filePath, "-vn", audioCodecKey, encoder.name}
+	pathParts := []string{basePath, codec}
+
+	switch {
+	case vbrQuality >= 0 && codec == "opus":
+		command = append(command, "-vbr", "on")
+		if bitRate != "" {
+			command = append(command, "-b:a", bitRate)
+			pathParts = append(pathParts, bitRate)
+		}
+		pathParts = append(pathParts, "vbr")
+	case vbrQuality >= 0:
+		command = append(command, "-q:a", fmt.Sprintf("%d", vbrQuality))
+		pathParts = append(pathParts, fmt.Sprintf("q%d", vbrQuality))
+	case bitRate != "" && codec != "flac":
+		command = append(command, "-b:a", bitRate)
+		pathParts = append(pathParts, bitRate)
+	}
+
+	if channels > 0 {
+		command = append(command, "-ac", fmt.Sprintf("%d", channels))
+		pathParts = append(pathParts, fmt.Sprintf("%dch", channels))
+	}
+
+	outputPath := filepath.Join(filepath.Dir(filePath), fmt.Sprintf("%s.%s", strings.Join(pathParts, "-"), encoder.ext))
+	command = append(command, outputPath)
+
+	st.log.Printf("new path: %s", outputPath)
+	command = showCommand(st, command)
+
+	if err := checkRoot(st, filePath, outputPath); err != nil {
+		return "", err
+	}
+
+	if err := checkCollision(st, outputPath, forceOverwrite); err != nil {
+		return "", err
+	}
+
+	if dryRun {
+		planEncode(st, filePath, outputPath)
+
+		return outputPath, nil
+	}
+
+	output, err := exec(st, command)
+	if err != nil {
+		st.log.Println(output)
+
+		return "", &EncodeError{Path: filePath, Operation: "re-encode audio", Err: err}
+	}
+
+	recordEncode(st, filePath, outputPath)
+
+	return outputPath, nil
+}
+
+func (a App) reEncodeAudio(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
+	forceOverwrite := c.Bool(forceFlag)
+
+	codec := c.String(audioCodecFlag)
+	bitRate := c.String(audioBitRateFlag)
+	vbrQuality := c.Int(vbrQualityFlag)
+	channels := c.Int(channelsFlag)
+
+	_, err := reEncodeAudio(a.state, fi, codec, bitRate, vbrQuality, channels, forceOverwrite, dryRun)
+
+	return err
+}
+
+func prefix(st *state, fi os.FileInfo, newPart string, skip int, forceOverwrite bool, dryRun bool) error {
+	filePath := fi.Name()
+
+	newPath, err := rename.Preview(rename.Prefix{Text: newPart, Skip: skip}, filePath)
+	if err != nil {
+		return err
+	}
+
+	if err := checkRoot(st, filePath, newPath); err != nil {
+		return err
+	}
+
+	if dryRun {
+		planRename(st, filePath, newPath)
+
+		return nil
+	}
+
+	return safeRename(st, filePath, newPath, forceOverwrite)
+}
+
+func (a App) prefix(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
+	if len(args) == 0 {
+		return nil
+	}
+
+	newPart := args[0]
+	skip := c.Int(skipPartsFlag)
+	forceOverwrite := c.Bool(forceFlag)
+
+	return prefix(a.state, fi, newPart, skip, forceOverwrite, dryRun)
+}
+
+func suffix(st *state, fi os.FileInfo, newPart string, skip int, forceOverwrite, dryRun bool) error {
+	filePath := fi.Name()
+
+	newPath, err := rename.Preview(rename.Suffix{Text: newPart, Skip: skip}, filePath)
+	if err != nil {
+		return err
+	}
+
+	if err := checkRoot(st, filePath, newPath); err != nil {
+		return err
+	}
+
+	if dryRun {
+		planRename(st, filePath, newPath)
+
+		return nil
+	}
+
+	return safeRename(st, filePath, newPath, forceOverwrite)
+}
+
+func (a App) suffix(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
+	skip := c.Int(skipPartsFlag)
+	newPart := args[0]
+	forceOverwrite := c.Bool(forceFlag)
+
+	return suffix(a.state, fi, newPart, skip, forceOverwrite, dryRun)
+}
+
+func replace(st *state, fi os.FileInfo, search, replaceWith string, skip int, forceOverwrite bool, dryRun bool) error {
+	filePath := fi.Name()
+
+	newPath, err := rename.Preview(rename.Replace{Search: search, With: replaceWith, Skip: skip}, filePath)
+	if err != nil {
+		return err
+	}
+
+	if newPath == filepath.Clean(filePath) {
+		// safe rename is called to handle standard logging
+		return safeRename(st, filePath, filePath, false)
+	}
+
+	st.log.Printf(`%q -> %q, search: %q, replace with: %q`, filePath, newPath, search, replaceWith)
+
+	if err := checkRoot(st, filePath, newPath); err != nil {
+		return err
+	}
+
+	if dryRun {
+		planRename(st, filePath, newPath)
+
+		return nil
+	}
+
+	return safeRename(st, filePath, newPath, forceOverwrite)
+}
+
+func (a App) replace(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
+	if len(args) < 2 {
+		return nil
+	}
+
+	search := args[0]
+	replaceWith := args[1]
+	skip := c.Int(skipFindsFlag)
+	forceOverwrite := c.Bool(forceFlag)
+
+	return replace(a.state, fi, search, replaceWith, skip, forceOverwrite, dryRun)
+}
+
+func mergeParts(st *state, fi os.FileInfo, spec rename.MergeParts, forceOverwrite, dryRun bool) error {
+	filePath := fi.Name()
+
+	newPath, err := rename.Preview(spec, filePath)
+	if err != nil {
+		return err
+	}
+
+	if err := checkRoot(st, filePath, newPath); err != nil {
+		return err
+	}
+
+	if dryRun {
+		planRename(st, filePath, newPath)
+
+		return nil
+	}
+
+	return safeRename(st, filePath, newPath, forceOverwrite)
+}
+
+func (a App) mergeParts(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
+	spec := rename.MergeParts{
+		Regexp:     c.String(regexpFlag),
+		Tags:       splitList(c.String(tagsFlag)),
+		Units:      c.Bool(timeUnitsFlag),
+		DeleteText: c.String(deleteTextFlag),
+	}
+	forceOverwrite := c.Bool(forceFlag)
+
+	return mergeParts(a.state, fi, spec, forceOverwrite, dryRun)
+}
+
+func normalizeUnicode(st *state, fi os.FileInfo, spec rename.NormalizeUnicode, forceOverwrite, dryRun bool) error {
+	filePath := fi.Name()
+
+	newPath, err := rename.Preview(spec, filePath)
+	if err != nil {
+		return err
+	}
+
+	if err := checkRoot(st, filePath, newPath); err != nil {
+		return err
+	}
+
+	if dryRun {
+		planRename(st, filePath, newPath)
+
+		return nil
+	}
+
+	return safeRename(st, filePath, newPath, forceOverwrite)
+}
+
+func (a App) normalizeUnicode(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
+	spec := rename.NormalizeUnicode{
+		Form:       c.String(formFlag),
+		ASCII:      c.Bool(asciiFlag),
+		NoMojibake: c.Bool(noMojibakeFlag),
+	}
+	forceOverwrite := c.Bool(forceFlag)
+
+	return normalizeUnicode(a.state, fi, spec, forceOverwrite, dryRun)
+}
+
+func padNumbers(st *state, fi os.FileInfo, spec rename.PadNumbers, forceOverwrite, dryRun bool) error {
+	filePath := fi.Name()
+
+	newPath, err := rename.Preview(spec, filePath)
+	if err != nil {
+		return err
+	}
+
+	if err := checkRoot(st, filePath, newPath); err != nil {
+		return err
+	}
+
+	if dryRun {
+		planRename(st, filePath, newPath)
+
+		return nil
+	}
+
+	return safeRename(st, filePath, newPath, forceOverwrite)
+}
+
+func (a App) padNumbers(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
+	width, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid width. width: %s, err: %w", args[0], err)
+	}
+
+	spec := rename.PadNumbers{
+		Width:       width,
+		Regexp:      c.String(regexpFlag),
+		RegexpGroup: c.Int(regexpGroupFlag),
+		Position:    c.Int(positionFlag),
+		FromBack:    c.Bool(fromBackFlag),
+	}
+	forceOverwrite := c.Bool(forceFlag)
+
+	return padNumbers(a.state, fi, spec, forceOverwrite, dryRun)
+}
+
+// defaultMaxRoman is enough for the usual parts, seasons and sequels
+const defaultMaxRoman = 20
+
+func normalizeNumbers(st *state, fi os.FileInfo, spec rename.NormalizeNumbers, forceOverwrite, dryRun bool) error {
+	filePath := fi.Name()
+
+	newPath, err := rename.Preview(spec, filePath)
+	if err != nil {
+		return err
+	}
+
+	if err := checkRoot(st, filePath, newPath); err != nil {
+		return err
+	}
+
+	if dryRun {
+		planRename(st, filePath, newPath)
+
+		return nil
+	}
+
+	return safeRename(st, filePath, newPath, forceOverwrite)
+}
+
+func (a App) normalizeNumbers(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
+	spec := rename.NormalizeNumbers{
+		Padding:  c.Int(paddingFlag),
+		MaxRoman: c.Int(maxRomanFlag),
+		NoRoman:  c.Bool(noRomanFlag),
+		NoWords:  c.Bool(noWordsFlag),
+	}
+	forceOverwrite := c.Bool(forceFlag)
+
+	return normalizeNumbers(a.state, fi, spec, forceOverwrite, dryRun)
+}
+
+func deleteRegexp(st *state, fi os.FileInfo, regularExpression string, regexpGroup, skipFinds, maxCount int, forceOverwrite, dryRun bool) error {
+	filePath := fi.Name()
+
+	spec := rename.DeleteRegexp{Regexp: regularExpression, RegexpGroup: regexpGroup, SkipFinds: skipFinds, MaxCount: maxCount}
+
+	newPath, err := rename.Preview(spec, filePath)
+	if err != nil {
+		return err
+	}
+
+	if err := checkRoot(st, filePath, newPath); err != nil {
+		return err
+	}
+
+	if dryRun {
+		planRename(st, filePath, newPath)
+
+		return nil
+	}
+
+	return safeRename(st, filePath, newPath, forceOverwrite)
+}
+
+func (a App) deleteRegexp(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
+	regularExpression := c.String(regexpFlag)
+	forceOverwrite := c.Bool(forceFlag)
+	regexpGroup := c.Int(regexpGroupFlag)
+	skipFinds := c.Int(skipFindsFlag)
+	maxCount := c.Int(maxCountFlag)
+
+	return deleteRegexp(a.state, fi, regularExpression, regexpGroup, skipFinds, maxCount, forceOverwrite, dryRun)
+}
+
+func deleteParts(st *state, fi os.FileInfo, partsToDelete []int, fromBack, forceOverwrite, dryRun bool) error {
+	filePath := fi.Name()
+
+	newPath, err := rename.Preview(rename.DeleteParts{Parts: partsToDelete, FromBack: fromBack}, filePath)
+	if err != nil {
+		return err
+	}
+
+	if err := checkRoot(st, filePath, newPath); err != nil {
+		return err
+	}
+
+	if dryRun {
+		planRename(st, filePath, newPath)
+
+		return nil
+	}
+
+	return safeRename(st, filePath, newPath, forceOverwrite)
+}
+
+// parsePartNumbers parses a comma separated list of part numbers, counted from 1
+func parsePartNumbers(list string) ([]int, error) {
+	strList := strings.Split(list, ",")
+	parts := make([]int, 0, len(strList))
+	for _, str := range strList {
+		num, err := strconv.ParseInt(strings.TrimSpace(str), 10, 32)
+		if err != nil || num < 1 {
+			return nil, fmt.Errorf("invalid part number, parts are counted from 1. part: %q", str)
+		}
+
+		parts = append(parts, int(num))
+	}
+
+	return parts, nil
+}
+
+func (a App) deleteParts(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
+	forceOverwrite := c.Bool(forceFlag)
+	fromBack := c.Bool(fromBackFlag)
+
+	partsToDelete, err := parsePartNumbers(args[0])
+	if err != nil {
+		return err
+	}
+
+	return deleteParts(a.state, fi, partsToDelete, fromBack, forceOverwrite, dryRun)
+}
+
+func addNumber(st *state, fi os.FileInfo, spec rename.EditNumber, forceOverwrite, dryRun bool) error {
+	filePath := fi.Name()
+
+	newPath, err := rename.Preview(spec, filePath)
+	if err != nil {
+		return err
+	}
+
+	if err := checkRoot(st, filePath, newPath); err != nil {
+		return err
+	}
+
+	if dryRun {
+		planRename(st, filePath, newPath)
+
+		return nil
+	}
+
+	return safeRename(st, filePath, newPath, forceOverwrite)
+}
+
+func (a App) addNumber(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
+	spec := rename.EditNumber{
+		Regexp:      c.String(regexpFlag),
+		RegexpGroup: c.Int(regexpGroupFlag),
+		SkipFinds:   c.Int(skipFindsFlag),
+		MaxCount:    c.Int(maxCountFlag),
+		Op:          rename.Add,
+		Operand:     args[0],
+	}
+	forceOverwrite := c.Bool(forceFlag)
+
+	switch {
+	case c.Bool(multiplyFlag) && c.Bool(setFlag):
+		return fmt.Errorf("--%s and --%s can not be used together", multiplyFlag, setFlag)
+	case c.Bool(multiplyFlag):
+		spec.Op = rename.Multiply
+	case c.Bool(setFlag):
+		spec.Op = rename.Set
+	}
+
+	return addNumber(a.state, fi, spec, forceOverwrite, dryRun)
+}
+
+func insertBefore(st *state, fi os.FileInfo, regularExpression, insertText string, skipDuplicate, skipDashPrefix, forceOverwrite, dryRun bool) error {
+	filePath := fi.Name()
+
+	if regularExpression == "" {
+		regularExpression = "\\d+[a-z]+"
+	}
+
+	if skipDuplicate && strings.Contains(filePath, insertText) {
+		st.log.Printf(`skipping as duplicate is found. needle: %q, haystack: %q`, insertText, filePath)
+
+		return nil
+	}
+
+	basePath := filepath.Base(filePath)
+	ext := filepath.Ext(filePath)
+	if ext != "" {
+		basePath = basePath[:len(basePath)-len(ext)]
+	}
+
+	regularExpression = "(" + regularExpression + ")"
+	if !skipDashPrefix {
+		regularExpression = "-" + regularExpression
+	}
+	r, err := regexp.Compile(regularExpression)
+	if err != nil {
+		return fmt.Errorf("regexp failed, err: %w", err)
+	}
+	matched := r.FindAllStringSubmatch(basePath, -1)
+
+	// fallback in case of no match is to insert text at the end of the string
+	newPath := basePath + "-" + insertText + ext
+	if len(matched) > 0 {
+		insertText += "-" + matched[len(matched)-1][1]
+		newPath = strings.Replace(basePath, matched[len(matched)-1][1], insertText, 1) + ext
+	}
+	newPath = filepath.Join(filepath.Dir(filePath), newPath)
+
+	st.log.Printf(`%q -> %q, found: %q, new: %q`, filePath, newPath, matched, insertText)
+
+	if err := checkRoot(st, filePath, newPath); err != nil {
+		return err
+	}
+
+	if dryRun {
+		planRename(st, filePath, newPath)
+
+		return nil
+	}
+
+	return safeRename(st, filePath, newPath, forceOverwrite)
+}
+
+func (a App) insertBefore(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
+	regularExpression := c.String(regexpFlag)
+	skipDashPrefix := c.Bool(skipDashPrefixFlag)
+	skipDuplicate := c.Bool(skipDuplicateFlag)
+	insert := args[1]
+
+	forceOverwrite := c.Bool(forceFlag)
+
+	return insertBefore(a.state, fi, regularExpression, insert, skipDuplicate, skipDashPrefix, forceOverwrite, dryRun)
+}
+
+var wellKnown = map[string]string{
+	"640x480":   "sd-480p",
+	"1280x720":  "hd-720p",
+	"1920x1080": "fullhd-1080p",
+	"2560x1440": "qhd-1440p",
+	"2048x1080": "2k-1080p",
+	"3840x2160": "4k-2160p",
+	"7680x4320": "8k-4320p",
+}
+
+// isPortraitRotation checks if a rotation in degrees swaps the width and the height of a video
+func isPortraitRotation(rotation float64) bool {
+	return int(math.Round(math.Abs(rotation)))%180 == 90
+}
+
+// getDimensions returns the display dimensions of a video, e.g. "1080x1920" for a phone video stored as 1920x1080 with
+// a rotation of 90 degrees, unless rotation is ignored
+func getDimensions(st *state, fi os.FileInfo) (string, error) {
+	cmd := []string{"ffprobe", "-v", "error", "-select_streams", videoStreamSpecifier(st), "-show_entries", "stream=width,height:stream_tags=rotate:stream_side_data=rotation", "-of", "json", fi.Name()}
+
+	raw, err := probe(st, cmd)
+	if err != nil {
+		return "", &ProbeError{Path: fi.Name(), Err: fmt.Errorf("command: %s, err: %w", quoteArgs(cmd), err)}
+	}
+
+	var probed struct {
+		Streams []struct {
+			Width        int `json:"width"`
+			Height       int `json:"height"`
+			SideDataList []struct {
+				Rotation float64 `json:"rotation"`
+			} `json:"side_data_list"`
+			Tags struct {
+				Rotate string `json:"rotate"`
+			} `json:"tags"`
+		} `json:"streams"`
+	}
+
+	err = json.Unmarshal([]byte(raw), &probed)
+	if err != nil {
+		return "", &ProbeError{Path: fi.Name(), Err: fmt.Errorf("output was invalid. command: %s, err: %w", quoteArgs(cmd), err)}
+	}
+
+	if len(probed.Streams) == 0 {
+		return "", &ProbeError{Path: fi.Name(), Err: ErrNoVideoStream}
+	}
+
+	stream := probed.Streams[0]
+	if stream.Width <= 0 || stream.Height <= 0 {
+		return "", &ProbeError{Path: fi.Name(), Err: fmt.Errorf("output was empty or invalid. command: %s", quoteArgs(cmd))}
+	}
+
+	// the display matrix is the current way of storing rotation, the rotate tag was used by older versions of ffmpeg
+	var rotation float64
+	for _, sideData := range stream.SideDataList {
+		if sideData.Rotation != 0 {
+			rotation = sideData.Rotation
+		}
+	}
+	if rotation == 0 && stream.Tags.Rotate != "" {
+		rotation, err = strconv.ParseFloat(stream.Tags.Rotate, 64)
+		if err != nil {
+			st.log.Printf("invalid rotate tag. file: %q, rotate: %s", fi.Name(), stream.Tags.Rotate)
+		}
+	}
+
+	if !st.ignoreRotation && isPortraitRotation(rotation) {
+		return fmt.Sprintf("%dx%d", stream.Height, stream.Width), nil
+	}
+
+	return fmt.Sprintf("%dx%d", stream.Width, stream.Height), nil
+}
+
+var dimensionTokenRegexp = regexp.MustCompile(`(^|[^0-9])\d{2,5}x\d{2,5}([^0-9]|$)`)
+
+var namePartSeparatorRegexp = regexp.MustCompile(`[^a-z0-9]+`)
+
+// nameParts returns the lowercase alphanumeric parts of the name of a file, the directory is ignored
+func nameParts(filePath string) []string {
+	return namePartSeparatorRegexp.Split(strings.ToLower(filepath.Base(filePath)), -1)
+}
+
+// dimensionTokens returns the tokens equivalent to dimensions, e.g. "1920x1080", "fullhd-1080p", "fullhd" and "1080p"
+func dimensionTokens(dimensions string) []string {
+	tokens := []string{dimensions}
+
+	label, ok := wellKnown[dimensions]
+	if ok {
+		tokens = append(tokens, label)
+		tokens = append(tokens, strings.Split(label, "-")...)
+	}
+
+	return tokens
+}
+
+// matchesDimensionTokens checks if any part of the name of a file, or a run of consecutive parts, is one of tokens
+func matchesDimensionTokens(filePath string, tokens []string) bool {
+	parts := nameParts(filePath)
+	for _, token := range tokens {
+		n := len(strings.Split(token, "-"))
+		for i := 0; i+n <= len(parts); i++ {
+			if strings.Join(parts[i:i+n], "-") == token {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// hasDimensionToken checks if a file name already contains complete dimensions, either raw or a well-known label.
+// Partial labels like "1080p" are not enough as they can only be compared to the probed dimensions.
+func hasDimensionToken(filePath string) bool {
+	if dimensionTokenRegexp.MatchString(filepath.Base(filePath)) {
+		return true
+	}
+
+	for _, label := range wellKnown {
+		if matchesDimensionTokens(filePath, []string{label}) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasEquivalentDimensions checks if a file name already contains dimensions equivalent to the given ones, e.g.
+// "1080p" or "fullhd" for 1920x1080
+func hasEquivalentDimensions(filePath, dimensions string) bool {
+	return matchesDimensionTokens(filePath, dimensionTokens(dimensions))
+}
+
+func insertDimensionsBefore(st *state, fi os.FileInfo, regularExpression string, skipDuplicatePrefix, skipDashPrefix, forceOverwrite, dryRun bool) error {
+	if skipDuplicatePrefix && hasDimensionToken(fi.Name()) {
+		st.log.Printf("skipping as dimensions are found. file: %q", fi.Name())
+
+		return nil
+	}
+
+	dimensions, err := getDimensions(st, fi)
+	if err != nil {
+		return err
+	}
+
+	if skipDuplicatePrefix && hasEquivalentDimensions(fi.Name(), dimensions) {
+		st.log.Printf("skipping as equivalent dimensions are found. file: %q, dimensions: %s", fi.Name(), dimensions)
+
+		return nil
+	}
+
+	if found, ok := wellKnown[dimensions]; ok {
+		dimensions = found
+	}
+
+	return insertBefore(st, fi, regularExpression, dimensions, skipDuplicatePrefix, skipDashPrefix, forceOverwrite, dryRun)
+}
+
+var dateRegexp1 = regexp.MustCompile(`20\d{6}`)
+var dateRegexp2 = regexp.MustCompile(`\d{6}`)
+var dateFormat1 = "20060102"
+var dateFormat2 = "060102"
+var dateFormat3 = "2006.01.02"
+
+// unixTimestampLayout marks date patterns matching unix timestamps in seconds or milliseconds
+const unixTimestampLayout = "unix"
+
+type datePattern struct {
+	regexp *regexp.Regexp
+	layout string
+}
+
+// datePatterns are tried in order, later patterns can not match a part of the name already matched by an earlier one.
+// If a pattern has a group, only the group is parsed.
+var datePatterns = []datePattern{
+	{regexp: regexp.MustCompile(`(?:IMG|VID|AUD|PTT)-(\d{8})-WA\d+`), layout: dateFormat1},
+	{regexp: regexp.MustCompile(`(?:19|20)\d{2}-\d{2}-\d{2}`), layout: "2006-01-02"},
+	{regexp: regexp.MustCompile(`\d{2}\.\d{2}\.(?:19|20)\d{2}`), layout: "02.01.2006"},
+	{regexp: regexp.MustCompile(`(?:^|\D)(1\d{9}(?:\d{3})?)(?:\D|$)`), layout: unixTimestampLayout},
+	{regexp: dateRegexp1, layout: dateFormat1},
+}
+
+// fallbackDatePatterns are only used if none of datePatterns match as they are likely to match other numbers too
+var fallbackDatePatterns = []datePattern{
+	{regexp: dateRegexp2, layout: dateFormat2},
+}
+
+const (
+	datePickFirst       = "first"
+	datePickLast        = "last"
+	datePickInteractive = "interactive"
+)
+
+// dateOptions override how prefix-date finds dates
+type dateOptions struct {
+	regex    string
+	format   string
+	pick     string
+	metadata bool
+	in       io.Reader
+}
+
+// patterns returns the date patterns to use, a format without a regex is turned into a regex matching it
+func (o dateOptions) patterns() ([]datePattern, []datePattern, error) {
+	if o.regex == "" && o.format == "" {
+		return datePatterns, fallbackDatePatterns, nil
+	}
+
+	if o.format == "" {
+		return nil, nil, fmt.Errorf("date format is required for a custom date regex. regex: %q", o.regex)
+	}
+
+	regex := o.regex
+	if regex == "" {
+		regex = layoutRegexp(o.format)
+	}
+
+	r, err := regexp.Compile(regex)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid date regex. regex: %q, err: %w", regex, err)
+	}
+
+	return []datePattern{{regexp: r, layout: o.format}}, nil, nil
+}
+
+// layoutRegexp returns a regular expression matching the dates formatted using a numeric time layout
+func layoutRegexp(layout string) string {
+	sb := strings.Builder{}
+	for _, c := range layout {
+		if unicode.IsDigit(c) {
+			sb.WriteString(`\d`)
+		} else {
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	return sb.String()
+}
+
+type dateCandidate struct {
+	text  string
+	date  time.Time
+	start int
+}
+
+func parseDate(text, layout string) (time.Time, error) {
+	if layout != unixTimestampLayout {
+		return time.Parse(layout, text)
+	}
+
+	n, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if len(text) > 10 {
+		return time.UnixMilli(n).UTC(), nil
+	}
+
+	return time.Unix(n, 0).UTC(), nil
+}
+
+// findDates returns the valid dates found in name in the order they appear
+func findDates(l *logger, name string, patterns []datePattern) []dateCandidate {
+	var (
+		candidates []dateCandidate
+		claimed    [][2]int
+	)
+
+	for _, pattern := range patterns {
+		group := 0
+		if pattern.regexp.NumSubexp() > 0 {
+			group = 1
+		}
+
+	matches:
+		for _, match := range pattern.regexp.FindAllStringSubmatchIndex(name, -1) {
+			start, end := match[2*group], match[2*group+1]
+			if start < 0 {
+				continue
+			}
+
+			for _, span := range claimed {
+				if start < span[1] && end > span[0] {
+					continue matches
+				}
+			}
+
+			date, err := parseDate(name[start:end], pattern.layout)
+			if err != nil {
+				l.Printf("not a date. text: %q, layout: %s, err: %s", name[start:end], pattern.layout, err)
+
+				continue
+			}
+
+			claimed = append(claimed, [2]int{start, end})
+			candidates = append(candidates, dateCandidate{text: name[start:end], date: date, start: start})
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].start < candidates[j].start
+	})
+
+	return candidates
+}
+
+// pickDate chooses one of the dates found, candidates meaning the same day are not considered ambiguous
+func pickDate(candidates []dateCandidate, pick string, r io.Reader) (dateCandidate, error) {
+	if len(candidates) == 0 {
+		return dateCandidate{}, errors.New("no matches")
+	}
+
+	ambiguous := false
+	for _, c := range candidates[1:] {
+		if c.date.Format(dateFormat3) != candidates[0].date.Format(dateFormat3) {
+			ambiguous = true
+		}
+	}
+	if !ambiguous {
+		return candidates[0], nil
+	}
+
+	switch pick {
+	case datePickFirst:
+		return candidates[0], nil
+	case datePickLast:
+		return candidates[len(candidates)-1], nil
+	case datePickInteractive:
+		t := tabby.New()
+		t.AddHeader("#", "MATCH", "DATE")
+		for i, c := range candidates {
+			t.AddLine(i+1, c.text, c.date.Format(dateFormat3))
+		}
+		t.Print()
+
+		fmt.Print("date to use: ")
+
+		var n int
+		_, err := fmt.Fscanln(r, &n)
+		if err != nil || n < 1 || n > len(candidates) {
+			return dateCandidate{}, fmt.Errorf("invalid choice. choice: %d", n)
+		}
+
+		return candidates[n-1], nil
+	}
+
+	return dateCandidate{}, fmt.Errorf("too many matches, use --%s to choose. matches: %d", pickFlag, len(candidates))
+}
+
+func prefixDate(st *state, fi os.FileInfo, o dateOptions, forceOverwrite, dryRun bool) error {
+	filePath := fi.Name()
+
+	basePath := filepath.Base(filePath)
+	ext := filepath.Ext(filePath)
+	if ext != "" {
+		basePath = basePath[:len(basePath)-len(ext)]
+	}
+
+	patterns, fallbackPatterns, err := o.patterns()
+	if err != nil {
+		return err
+	}
+
+	candidates := findDates(st.log, basePath, patterns)
+	if len(candidates) == 0 {
+		candidates = findDates(st.log, basePath, fallbackPatterns)
+	}
+	st.log.Printf("basePath: %s, matches: %d", basePath, len(candidates))
+
+	var date time.Time
+	if len(candidates) == 0 && o.metadata {
+		date = getMetadataDate(st, fi)
+	} else {
+		found, err := pickDate(candidates, o.pick, o.in)
+		if err != nil {
+			return err
+		}
+		date = found.date
+	}
+
+	newPath := filepath.Join(filepath.Dir(filePath), date.Format(dateFormat3)+"-"+basePath+ext)
+
+	if err := checkRoot(st, filePath, newPath); err != nil {
+		return err
+	}
+
+	if dryRun {
+		planRename(st, filePath, newPath)
+
+		return nil
+	}
+
+	return safeRename(st, filePath, newPath, forceOverwrite)
+}
+
+func (a App) datePrefix(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
+	forceOverwrite := c.Bool(forceFlag)
+
+	o := dateOptions{
+		regex:    c.String(dateRegexFlag),
+		format:   c.String(dateFormatFlag),
+		pick:     c.String(pickFlag),
+		metadata: c.Bool(metadataDateFlag),
+		in:       os.Stdin,
+	}
+
+	switch o.pick {
+	case "", datePickFirst, datePickLast, datePickInteractive:
+	default:
+		return fmt.Errorf("invalid pick. pick: %s", o.pick)
+	}
+
+	return prefixDate(a.state, fi, o, forceOverwrite, dryRun)
+}
+
+func (a App) insertDimensionsBefore(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
+	regularExpression := c.String(regexpFlag)
+	skipDashPrefix := c.Bool(skipDashPrefixFlag)
+	skipDuplicatePrefix := c.Bool(skipDuplicateFlag)
+	forceOverwrite := c.Bool(forceFlag)
+
+	return insertDimensionsBefore(a.state, fi, regularExpression, skipDuplicatePrefix, skipDashPrefix, forceOverwrite, dryRun)
+}
+
+func parseDimensions(dimensions string) (int, int, error) {
+	d := strings.Split(dimensions, "x")
+	if len(d) != 2 {
+		return 0, 0, fmt.Errorf("wrong old dimensions: %s", dimensions)
+	}
+
+	widthOrigin, err := strconv.Atoi(d[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("wrong old dimensions: %s", dimensions)
+	}
+
+	heightOrigin, err := strconv.Atoi(d[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("wrong old dimensions: %s", dimensions)
+	}
+
+	return widthOrigin, heightOrigin, nil
+}
+
+// getPresetDimensions returns the dimensions of a dimension preset, or the given width and height if there's no such
+// preset
+func getPresetDimensions(dimensionPreset string, width, height int) (int, int) {
+	switch dimensionPreset {
+	case eightKPreset, eightKPreset2:
+		return eightKWidth, eightKHeight
+	case fourKPreset, fourKPreset2:
+		return fourKWidth, fourKHeight
+	case qHDPreset, qHDPreset2:
+		return qHDWidth, qHDHeight
+	case twoKPreset:
+		return twoKWidth, twoKHeight
+	case fullHDPreset, fullHDPreset2:
+		return fullHDWidth, fullHDHeight
+	case hdPreset, hdPreset2:
+		return hdWidth, hdHeight
+	case sdPreset, sdPreset2:
+		return sdWidth, sdHeight
+	}
+
+	return width, height
+}
+
+func crop(st *state, fi os.FileInfo, width, height int, x, y, dimensionPreset string, forceOverwrite, dryRun bool) error {
+	basePath := filepath.Base(fi.Name())
+	ext := filepath.Ext(fi.Name())
+	if ext != "" {
+		basePath = basePath[:len(basePath)-len(ext)]
+	}
+
+	width, height = getPresetDimensions(dimensionPreset, width, height)
+
+	st.log.Printf("preset: %s, width: %d, height: %d", dimensionPreset, width, height)
+
+	if width == 0 || height == 0 {
+		return fmt.Errorf("wrong dimensions. width: %d, height: %d", width, height)
+	}
+
+	dimensions, err := getDimensions(st, fi)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve video dimensions. err: %w", err)
+	}
+
+	widthOrigin, heightOrigin, err := parseDimensions(dimensions)
+	if err != nil {
+		return fmt.Errorf("failed to parse video dimensions. err: %w", err)
+	}
+
+	st.log.Printf("origin width: %d, origin height: %d", width, height)
+
+	if widthOrigin < width || heightOrigin < height {
+		return fmt.Errorf("wrong dimensions. new dimensions: %dx%d, old dimensions: %s", width, height, dimensions)
+	}
+
+	var xPos int
+	switch x {
+	case "left":
+	case "center", "":
+		xPos = (widthOrigin - width) / 2
+	case "right":
+		xPos = widthOrigin - width
+	default:
+		xPos, err = strconv.Atoi(x)
+		if err != nil {
+			return fmt.Errorf("wrong instructions, x: %s", x)
+		}
+	}
+
+	var yPos int
+	switch y {
+	case "top":
+	case "center", "":
+		yPos = (heightOrigin - height) / 2
+	case "bottom":
+		yPos = heightOrigin - height
+	default:
+		yPos, err = strconv.Atoi(y)
+		if err != nil {
+			return fmt.Errorf("wrong instructions, y: %s", y)
+		}
+	}
+
+	st.log.Printf("x: %d, y: %d", xPos, yPos)
+
+	if widthOrigin < width+yPos || heightOrigin < height+xPos {
+		return fmt.Errorf("wrong instructions. new dimensions: %dx%d, pos x: %d, pos y: %d, old dimensions: %s", width, height, xPos, yPos, dimensions)
+	}
+
+	newPath := filepath.Join(filepath.Dir(fi.Name()), fmt.Sprintf("%s-%dx%d%s", basePath, width, height, ext))
+
+	// ffmpeg rotates the input before filtering, so the crop has to be skipped too if rotation is ignored
+	cmd := []string{"ffmpeg"}
+	if st.ignoreRotation {
+		cmd = append(cmd, "-noautorotate")
+	}
+	cmd = append(cmd, inputKey, fi.Name(), "-filter:v", fmt.Sprintf("crop=%d:%d:%d:%d", width, height, xPos, yPos), newPath)
+	cmd = showCommand(st, cmd)
+
+	if err := checkRoot(st, fi.Name(), newPath); err != nil {
+		return err
+	}
+
+	if err := checkCollision(st, newPath, forceOverwrite); err != nil {
+		return err
+	}
+
+	if dryRun {
+		planEncode(st, fi.Name(), newPath)
+
+		return nil
+	}
+
+	output, err := exec(st, cmd)
+	if err != nil {
+		st.log.Printf(output)
+
+		return &EncodeError{Path: fi.Name(), Operation: "crop video", Err: err}
+	}
+
+	recordEncode(st, fi.Name(), newPath)
+
+	return nil
+}
+
+var imageExtensions = []string{"jpg", "jpeg", "png", "webp", "avif", "heic", "heif", "gif", "bmp", "tif", "tiff"}
+
+// imageEncoderParams contains the extra ffmpeg parameters needed to write some image formats
+var imageEncoderParams = map[string][]string{
+	"avif": {videoCodecKey, "libaom-av1", "-still-picture", "1"},
+	"webp": {videoCodecKey, "libwebp"},
+}
+
+// convertImage converts an image to a different format, optionally scaling it down to fit the given dimensions while
+// keeping its aspect ratio
+func convertImage(st *state, fi os.FileInfo, format string, width, height int, dimensionPreset string, forceOverwrite, dryRun bool) (string, error) {
+	filePath := fi.Name()
+
+	basePath := filepath.Base(filePath)
+	ext := filepath.Ext(filePath)
+	if ext != "" {
+		basePath = basePath[:len(basePath)-len(ext)]
+	}
+
+	format = strings.ToLower(strings.TrimPrefix(format, "."))
+	if format == "" {
+		format = strings.ToLower(strings.TrimPrefix(ext, "."))
+	}
+
+	if !containsString(imageExtensions, format) {
+		return "", fmt.Errorf("invalid image format. format: %s", format)
+	}
+
+	width, height = getPresetDimensions(dimensionPreset, width, height)
+
+	params := []string{inputKey, filePath}
+	scaled := false
+	if width > 0 || height > 0 {
+		switch {
+		case height == 0:
+			basePath = fmt.Sprintf("%s-%dx", basePath, width)
+		case width == 0:
+			basePath = fmt.Sprintf("%s-x%d", basePath, height)
+		default:
+			basePath = fmt.Sprintf("%s-%dx%d", basePath, width, height)
+		}
+
+		// -2 keeps the aspect ratio while making sure the dimension is divisible by 2
+		if width == 0 {
+			width = -2
+		}
+		if height == 0 {
+			height = -2
+		}
+
+		params = append(params, videoFilterKey, fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease", width, height))
+		scaled = true
+	}
+
+	if !scaled && strings.EqualFold(strings.TrimPrefix(ext, "."), format) {
+		return "", fmt.Errorf("nothing to convert. file: %q, format: %s", filePath, format)
+	}
+
+	if extra, ok := imageEncoderParams[format]; ok {
+		params = append(params, extra...)
+	}
+
+	outputPath := filepath.Join(filepath.Dir(filePath), fmt.Sprintf("%s.%s", basePath, format))
+	command := append([]string{"ffmpeg"}, params...)
+	command = append(command, "-frames:v", "1", "-update", "1", outputPath)
+
+	st.log.Printf("new path: %s", outputPath)
+	command = showCommand(st, command)
+
+	if err := checkRoot(st, filePath, outputPath); err != nil {
+		return "", err
+	}
+
+	if err := checkCollision(st, outputPath, forceOverwrite); err != nil {
+		return "", err
+	}
+
+	if dryRun {
+		planEncode(st, filePath, outputPath)
+
+		return outputPath, nil
+	}
+
+	output, err := exec(st, command)
+	if err != nil {
+		st.log.Println(output)
+
+		return "", &EncodeError{Path: filePath, Operation: "convert image", Err: err}
+	}
+
+	recordEncode(st, filePath, outputPath)
+
+	return outputPath, nil
+}
+
+func (a App) convertImage(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
+	forceOverwrite := c.Bool(forceFlag)
+
+	format := c.String(imageFormatFlag)
+	width := c.Int(widthFlag)
+	height := c.Int(heightFlag)
+	dimensionPreset := c.String(dimensionPresetFlag)
+
+	_, err := convertImage(a.state, fi, format, width, height, dimensionPreset, forceOverwrite, dryRun)
+
+	return err
+}
+
+const (
+	defaultFrameFormat = "png"
+	defaultImportFPS   = 24
+)
+
+// framesExport exports the frames of a video as numbered images into a directory next to the video. A zero fps
+// exports every frame.
+func framesExport(st *state, fi os.FileInfo, format string, fps float64, forceOverwrite, dryRun bool) (string, error) {
+	filePath := fi.Name()
+
+	basePath := filepath.Base(filePath)
+	ext := filepath.Ext(filePath)
+	if ext != "" {
+		basePath = basePath[:len(basePath)-len(ext)]
+	}
+
+	if format == "" {
+		format = defaultFrameFormat
+	}
+
+	format = strings.ToLower(strings.TrimPrefix(format, "."))
+	if format != "png" && format != "jpg" && format != "jpeg" {
+		return "", fmt.Errorf("invalid frame format. format: %s", format)
+	}
+
+	dir := filepath.Join(filepath.Dir(filePath), basePath+"-frames")
+	pattern := filepath.Join(dir, fmt.Sprintf("%s-%%06d.%s", basePath, format))
+
+	command := []string{"ffmpeg", inputKey, filePath}
+	if fps > 0 {
+		command = append(command, videoFilterKey, fmt.Sprintf("fps=%s", strconv.FormatFloat(fps, 'f', -1, 64)))
+	}
+	if format != "png" {
+		command = append(command, "-q:v", "2")
+	}
+	command = append(command, pattern)
+
+	st.log.Printf("frames directory: %s", dir)
+	command = showCommand(st, command)
+
+	if err := checkRoot(st, filePath, pattern); err != nil {
+		return "", err
+	}
+
+	if err := checkCollision(st, dir, forceOverwrite); err != nil {
+		return "", err
+	}
+
+	if dryRun {
+		planEncode(st, filePath, pattern)
+
+		return dir, nil
+	}
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", fmt.Errorf("failed to create frames directory. path: %s, err: %w", dir, err)
+	}
+
+	output, err := exec(st, command)
+	if err != nil {
+		st.log.Println(output)
+
+		return "", &EncodeError{Path: filePath, Operation: "export frames", Err: err}
+	}
+
+	recordEncode(st, filePath, dir)
+
+	return dir, nil
+}
+
+func (a App) framesExport(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
+	forceOverwrite := c.Bool(forceFlag)
+
+	format := c.String(imageFormatFlag)
+	fps := c.Float64(fpsFlag)
+
+	_, err := framesExport(a.state, fi, format, fps, forceOverwrite, dryRun)
+
+	return err
+}
+
+// writeConcatList writes an ffmpeg concat demuxer list which shows each image for one frame
+func writeConcatList(w io.Writer, fileList []os.FileInfo, fps float64) error {
+	duration := strconv.FormatFloat(1/fps, 'f', -1, 64)
+
+	for _, fi := range fileList {
+		absPath, err := filepath.Abs(fi.Name())
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintf(w, "file '%s'\nduration %s\n", strings.Replace(absPath, "'", `'\''`, -1), duration)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the last file needs to be repeated for its duration to be applied
+	if len(fileList) > 0 {
+		absPath, err := filepath.Abs(fileList[len(fileList)-1].Name())
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintf(w, "file '%s'\n", strings.Replace(absPath, "'", `'\''`, -1))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// framesImport creates a video out of images, in the order they are provided
+func framesImport(st *state, fileList []os.FileInfo, outputPath, codec string, crf int, fps float64, forceOverwrite, dryRun bool) error {
+	if len(fileList) == 0 {
+		return errors.New("no images to import")
+	}
+
+	if fps <= 0 {
+		return fmt.Errorf("invalid fps. fps: %f", fps)
+	}
+
+	params := []string{
+		videoFilterKey, fmt.Sprintf("fps=%s,pad=ceil(iw/2)*2:ceil(ih/2)*2", strconv.FormatFloat(fps, 'f', -1, 64)),
+		videoCodecKey, codec,
+		pixelFormatKey, "yuv420p",
+	}
+	if crf > 0 {
+		params = append(params, crfKey, fmt.Sprintf("%d", crf))
+	}
+
+	getCommand := func(listPath string) []string {
+		command := []string{"ffmpeg", "-f", "concat", "-safe", "0", inputKey, listPath}
+		command = append(command, params...)
+
+		return append(command, outputPath)
+	}
+
+	st.log.Printf("images: %d, new path: %s", len(fileList), outputPath)
+
+	if err := checkRoot(st, outputPath); err != nil {
+		return err
+	}
+
+	if err := checkCollision(st, outputPath, forceOverwrite); err != nil {
+		return err
+	}
+
+	if dryRun {
+		showCommand(st, getCommand("<list of images>"))
+		planEncode(st, fileList[0].Name(), outputPath)
+
+		return nil
+	}
+
+	f, err := st.workspace.CreateTemp(outputPath, "frames-*.txt")
+	if err != nil {
+		return fmt.Errorf("failed to create image list. err: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	err = writeConcatList(f, fileList, fps)
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write image list. err: %w", err)
+	}
+
+	command := getCommand(f.Name())
+	command = showCommand(st, command)
+
+	output, err := exec(st, command)
+	if err != nil {
+		st.log.Println(output)
+
+		return &EncodeError{Path: fileList[0].Name(), Operation: "import frames", Err: err}
+	}
+
+	recordEncode(st, fileList[0].Name(), outputPath)
+
+	return nil
+}
+
+func (a App) framesImport(c *cli.Context, args []string, fileList []os.FileInfo, dryRun bool) error {
+	forceOverwrite := c.Bool(forceFlag)
+
+	codec := c.String(codecFlag)
+	crf := c.Int(crfFlag)
+	fps := c.Float64(fpsFlag)
+	if fps == 0 {
+		fps = defaultImportFPS
+	}
+
+	return framesImport(a.state, fileList, args[0], codec, crf, fps, forceOverwrite, dryRun)
+}
+
+const defaultTimelapseCRF = 18
+
+// getAtempoFilter returns a chain of atempo filters speeding up audio by factor, as a single atempo filter is limited
+// to a factor of 2 in older ffmpeg versions
+func getAtempoFilter(factor float64) string {
+	var filters []string
+	for factor > 2 {
+		filters = append(filters, "atempo=2")
+		factor /= 2
+	}
+
+	filters = append(filters, "atempo="+strconv.FormatFloat(factor, 'f', -1, 64))
+
+	return strings.Join(filters, ",")
+}
+
+// timelapse speeds up a video either by factor or to a target duration in seconds
+func timelapse(st *state, fi os.FileInfo, factor, duration float64, dropAudio bool, codec string, crf int, forceOverwrite, dryRun bool) (string, error) {
+	filePath := fi.Name()
+
+	basePath := filepath.Base(filePath)
+	ext := filepath.Ext(filePath)
+	if ext != "" {
+		basePath = basePath[:len(basePath)-len(ext)]
+	}
+
+	if duration > 0 {
+		length, err := getLength(st, fi)
+		if err != nil {
+			return "", fmt.Errorf("failed to retrieve video length. err: %w", err)
+		}
+
+		factor = math.Round(length/duration*100) / 100
+	}
+
+	if factor <= 1 {
+		return "", fmt.Errorf("invalid speed up factor. factor: %f", factor)
+	}
+
+	if crf == 0 {
+		crf = defaultTimelapseCRF
+	}
+
+	factorString := strconv.FormatFloat(factor, 'f', -1, 64)
+
+	command := []string{
+		"ffmpeg",
+		inputKey, filePath,
+		"-filter:v", fmt.Sprintf("setpts=PTS/%s", factorString),
+		videoCodecKey, codec,
+		crfKey, fmt.Sprintf("%d", crf),
+	}
+	if dropAudio {
+		command = append(command, "-an")
+	} else {
+		command = append(command, "-filter:a", getAtempoFilter(factor))
+	}
+
+	outputPath := filepath.Join(filepath.Dir(filePath), fmt.Sprintf("%s-x%s%s", basePath, factorString, ext))
+	command = append(command, outputPath)
+
+	st.log.Printf("new path: %s", outputPath)
+	command = showCommand(st, command)
+
+	if err := checkRoot(st, filePath, outputPath); err != nil {
+		return "", err
+	}
+
+	if err := checkCollision(st, outputPath, forceOverwrite); err != nil {
+		return "", err
+	}
+
+	if dryRun {
+		planEncode(st, filePath, outputPath)
+
+		return outputPath, nil
+	}
+
+	output, err := exec(st, command)
+	if err != nil {
+		st.log.Println(output)
+
+		return "", &EncodeError{Path: filePath, Operation: "create timelapse", Err: err}
+	}
+
+	recordEncode(st, filePath, outputPath)
+
+	return outputPath, nil
+}
+
+func (a App) timelapse(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
+	forceOverwrite := c.Bool(forceFlag)
+
+	factor := c.Float64(factorFlag)
+	duration := c.Float64(durationFlag)
+	dropAudio := c.Bool(dropAudioFlag)
+	codec := c.String(codecFlag)
+	crf := c.Int(crfFlag)
+
+	_, err := timelapse(a.state, fi, factor, duration, dropAudio, codec, crf, forceOverwrite, dryRun)
+
+	return err
+}
+
+const (
+	overlayFilename = "filename"
+	overlayTimecode = "timecode"
+	overlayCustom   = "text"
+)
+
+const defaultReviewCRF = 28
+
+// overlayPlacements contains the drawtext coordinates of the text for each placement, m is the margin
+var overlayPlacements = map[string][2]string{
+	"top-left":     {"m", "m"},
+	"top":          {"(w-text_w)/2", "m"},
+	"top-right":    {"w-text_w-m", "m"},
+	"center":       {"(w-text_w)/2", "(h-text_h)/2"},
+	"bottom-left":  {"m", "h-text_h-m"},
+	"bottom":       {"(w-text_w)/2", "h-text_h-m"},
+	"bottom-right": {"w-text_w-m", "h-text_h-m"},
+}
+
+const defaultOverlayPlacement = "bottom"
+
+// escapeDrawtext escapes literal text for drawtext in a filter graph. The text is unescaped three times: as part of the
+// filter graph, as an option value and by the text expansion of drawtext.
+func escapeDrawtext(text string) string {
+	text = strings.NewReplacer(`\`, `\\`, `%`, `\%`).Replace(text)
+
+	return escapeFilterOption(text)
+}
+
+// escapeFilterOption escapes a filter option value, once for the option parser and once for the filter graph parser
+func escapeFilterOption(value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `'`, `\'`, `:`, `\:`).Replace(value)
+
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`, `[`, `\[`, `]`, `\]`, `,`, `\,`, `;`, `\;`).Replace(value)
+}
+
+// getOverlayFilter returns the drawtext filter burning the parts of the overlay into the video, separated by spaces
+func getOverlayFilter(filePath string, parts []string, text, placement string, fontSize int, box bool) (string, error) {
+	var segments []string
+	for _, part := range parts {
+		switch part {
+		case overlayFilename:
+			segments = append(segments, escapeDrawtext(filepath.Base(filePath)))
+		case overlayTimecode:
+			segments = append(segments, escapeFilterOption("%{pts:hms}"))
+		case overlayCustom:
+			if text == "" {
+				return "", fmt.Errorf("missing text, use --%s", textFlag)
+			}
+			segments = append(segments, escapeDrawtext(text))
+		default:
+			return "", fmt.Errorf("invalid overlay, use %s, %s or %s. overlay: %s", overlayFilename, overlayTimecode, overlayCustom, part)
+		}
+	}
+
+	if placement == "" {
+		placement = defaultOverlayPlacement
+	}
+	xy, ok := overlayPlacements[placement]
+	if !ok {
+		return "", fmt.Errorf("invalid placement. placement: %s", placement)
+	}
+
+	size := "h/24"
+	if fontSize > 0 {
+		size = strconv.Itoa(fontSize)
+	}
+
+	margin := "h/40"
+	x := strings.ReplaceAll(xy[0], "m", margin)
+	y := strings.ReplaceAll(xy[1], "m", margin)
+
+	filter := fmt.Sprintf("drawtext=text=%s:fontsize=%s:fontcolor=white:x=%s:y=%s", strings.Join(segments, " "), size, x, y)
+	if box {
+		filter += ":box=1:boxcolor=black@0.5:boxborderw=10"
+	}
+
+	return filter, nil
+}
+
+// overlayText burns the file name, the timecode or a custom text into a review copy of a video
+func overlayText(st *state, fi os.FileInfo, parts []string, text, placement string, fontSize int, box bool, codec string, crf int, forceOverwrite, dryRun bool) (string, error) {
+	filePath := fi.Name()
+
+	basePath := filepath.Base(filePath)
+	ext := filepath.Ext(filePath)
+	if ext != "" {
+		basePath = basePath[:len(basePath)-len(ext)]
+	}
+
+	filter, err := getOverlayFilter(filePath, parts, text, placement, fontSize, box)
+	if err != nil {
+		return "", err
+	}
+
+	if crf == 0 {
+		crf = defaultReviewCRF
+	}
+
+	outputPath := filepath.Join(filepath.Dir(filePath), fmt.Sprintf("%s-review%s", basePath, ext))
+
+	command := []string{
+		"ffmpeg",
+		inputKey, filePath,
+		"-filter:v", filter,
+		videoCodecKey, codec,
+		crfKey, fmt.Sprintf("%d", crf),
+		audioCodecKey, "copy",
+		outputPath,
+	}
+
+	st.log.Printf("new path: %s", outputPath)
+	command = showCommand(st, command)
+
+	if err := checkRoot(st, filePath, outputPath); err != nil {
+		return "", err
+	}
+
+	if err := checkCollision(st, outputPath, forceOverwrite); err != nil {
+		return "", err
+	}
+
+	if dryRun {
+		planEncode(st, filePath, outputPath)
+
+		return outputPath, nil
+	}
+
+	output, err := exec(st, command)
+	if err != nil {
+		st.log.Println(output)
+
+		return "", &EncodeError{Path: filePath, Operation: "overlay text", Err: err}
+	}
+
+	recordEncode(st, filePath, outputPath)
+
+	return outputPath, nil
+}
+
+func (a App) overlayText(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
+	forceOverwrite := c.Bool(forceFlag)
+
+	parts := splitList(args[0])
+	text := c.String(textFlag)
+	placement := c.String(placementFlag)
+	fontSize := c.Int(fontSizeFlag)
+	box := !c.Bool(noBoxFlag)
+	codec := c.String(codecFlag)
+	crf := c.Int(crfFlag)
+
+	_, err := overlayText(a.state, fi, parts, text, placement, fontSize, box, codec, crf, forceOverwrite, dryRun)
+
+	return err
+}
+
+// maxLoopFrames is the maximum number of frames the loop filter can repeat
+const maxLoopFrames = 32767
+
+// loop repeats a clip count times. Simple loops use stream copy, boomerangs play the clip forward then backwards and
+// have to be re-encoded without audio.
+func loop(st *state, fi os.FileInfo, count int, boomerang, forceOverwrite, dryRun bool) (string, error) {
+	filePath := fi.Name()
+
+	basePath := filepath.Base(filePath)
+	ext := filepath.Ext(filePath)
+	if ext != "" {
+		basePath = basePath[:len(basePath)-len(ext)]
+	}
+
+	if count < 1 || (count < 2 && !boomerang) {
+		return "", fmt.Errorf("invalid repetition count. count: %d", count)
+	}
+
+	var (
+		outputPath string
+		command    []string
+	)
+	if boomerang {
+		filter := "[0:v]reverse[r];[0:v][r]concat=n=2:v=1:a=0[v]"
+		if count > 1 {
+			filter = fmt.Sprintf("[0:v]reverse[r];[0:v][r]concat=n=2:v=1:a=0[b];[b]loop=loop=%d:size=%d[v]", count-1, maxLoopFrames)
+		}
+
+		outputPath = filepath.Join(filepath.Dir(filePath), fmt.Sprintf("%s-boomerang%d%s", basePath, count, ext))
+		command = []string{"ffmpeg", inputKey, filePath, "-filter_complex", filter, "-map", "[v]", "-an", outputPath}
+	} else {
+		outputPath = filepath.Join(filepath.Dir(filePath), fmt.Sprintf("%s-loop%d%s", basePath, count, ext))
+		command = []string{"ffmpeg", "-stream_loop", fmt.Sprintf("%d", count-1), inputKey, filePath, "-c", "copy", outputPath}
+	}
+
+	st.log.Printf("new path: %s", outputPath)
+	command = showCommand(st, command)
+
+	if err := checkRoot(st, filePath, outputPath); err != nil {
+		return "", err
+	}
+
+	if err := checkCollision(st, outputPath, forceOverwrite); err != nil {
+		return "", err
+	}
+
+	if dryRun {
+		planEncode(st, filePath, outputPath)
+
+		return outputPath, nil
+	}
+
+	output, err := exec(st, command)
+	if err != nil {
+		st.log.Println(output)
+
+		return "", &EncodeError{Path: filePath, Operation: "loop video", Err: err}
+	}
+
+	recordEncode(st, filePath, outputPath)
+
+	return outputPath, nil
+}
+
+func (a App) loop(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
+	forceOverwrite := c.Bool(forceFlag)
+
+	count := c.Int(repeatFlag)
+	boomerang := c.Bool(boomerangFlag)
+
+	_, err := loop(a.state, fi, count, boomerang, forceOverwrite, dryRun)
+
+	return err
+}
+
+// muxAudio replaces the audio of a video with, or adds as a new track, an audio file using stream copy. A positive
+// offset delays the audio, a negative one makes it start earlier.
+func muxAudio(st *state, fi os.FileInfo, audioPath string, offset float64, addTrack, shortest, forceOverwrite, dryRun bool) (string, error) {
+	filePath := fi.Name()
+
+	basePath := filepath.Base(filePath)
+	ext := filepath.Ext(filePath)
+	if ext != "" {
+		basePath = basePath[:len(basePath)-len(ext)]
+	}
+
+	_, err := os.Stat(audioPath)
+	if err != nil {
+		return "", fmt.Errorf("audio file not found. path: %q, err: %w", audioPath, err)
+	}
+
+	command := []string{"ffmpeg", inputKey, filePath}
+	if offset != 0 {
+		command = append(command, "-itsoffset", strconv.FormatFloat(offset, 'f', -1, 64))
+	}
+	command = append(command, inputKey, audioPath)
+
+	if addTrack {
+		command = append(command, "-map", "0", "-map", "1:a")
+	} else {
+		command = append(command, "-map", "0:v", "-map", "1:a")
+	}
+
+	command = append(command, "-c", "copy")
+	if shortest {
+		command = append(command, "-shortest")
+	}
+
+	outputPath := filepath.Join(filepath.Dir(filePath), fmt.Sprintf("%s-muxed%s", basePath, ext))
+	command = append(command, outputPath)
+
+	st.log.Printf("new path: %s", outputPath)
+	command = showCommand(st, command)
+
+	if err := checkRoot(st, filePath, outputPath); err != nil {
+		return "", err
+	}
+
+	if err := checkCollision(st, outputPath, forceOverwrite); err != nil {
+		return "", err
+	}
+
+	if dryRun {
+		planEncode(st, filePath, outputPath)
+
+		return outputPath, nil
+	}
+
+	output, err := exec(st, command)
+	if err != nil {
+		st.log.Println(output)
+
+		return "", &EncodeError{Path: filePath, Operation: "mux audio", Err: err}
+	}
+
+	recordEncode(st, filePath, outputPath)
+
+	return outputPath, nil
+}
+
+func (a App) muxAudio(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
+	forceOverwrite := c.Bool(forceFlag)
+
+	offset := c.Float64(offsetFlag)
+	addTrack := c.Bool(addTrackFlag)
+	shortest := c.Bool(shortestFlag)
+
+	_, err := muxAudio(a.state, fi, args[0], offset, addTrack, shortest, forceOverwrite, dryRun)
+
+	return err
+}
+
+const (
+	streamTypeAudio    = "a"
+	streamTypeSubtitle = "s"
+
+	undeterminedLanguage = "und"
+)
+
+// streamInfo is an audio or subtitle stream of a file, index is counted per stream type as in ffmpeg stream
+// specifiers, e.g. 1 for s:1
+type streamInfo struct {
+	streamType string
+	index      int
+	language   string
+}
+
+var codecTypeStreamTypes = map[string]string{
+	"audio":    streamTypeAudio,
+	"subtitle": streamTypeSubtitle,
+}
+
+func getStreams(st *state, fi os.FileInfo) ([]streamInfo, error) {
+	raw, err := probe(st, []string{"ffprobe", "-v", "error", "-show_entries", "stream=index,codec_type:stream_tags=language", "-of", "json", fi.Name()})
+	if err != nil {
+		return nil, &ProbeError{Path: fi.Name(), Err: fmt.Errorf("streams: %w", err)}
+	}
+
+	var data struct {
+		Streams []struct {
+			CodecType string `json:"codec_type"`
+			Tags      struct {
+				Language string `json:"language"`
+			} `json:"tags"`
+		} `json:"streams"`
+	}
+
+	err = json.Unmarshal([]byte(raw), &data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse streams. file: %q, err: %w", fi.Name(), err)
+	}
+
+	var streams []streamInfo
+	counts := map[string]int{}
+	for _, s := range data.Streams {
+		streamType, ok := codecTypeStreamTypes[s.CodecType]
+		if !ok {
+			continue
+		}
+
+		streams = append(streams, streamInfo{streamType: streamType, index: counts[streamType], language: s.Tags.Language})
+		counts[streamType]++
+	}
+
+	return streams, nil
+}
+
+// streamLanguages returns the languages of the audio and the subtitle streams of a file, "und" for missing ones
+func streamLanguages(st *state, fi os.FileInfo) ([]string, []string, error) {
+	streams, err := getStreams(st, fi)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var audio, subtitles []string
+	for _, s := range streams {
+		language := s.language
+		if language == "" {
+			language = undeterminedLanguage
+		}
+
+		if s.streamType == streamTypeAudio {
+			audio = append(audio, language)
+		} else {
+			subtitles = append(subtitles, language)
+		}
+	}
+
+	return audio, subtitles, nil
+}
+
+// languageCodes maps ISO 639-1 codes to the ISO 639-2 codes used in media containers
+var languageCodes = map[string]string{
+	"en": "eng",
+	"hu": "hun",
+	"de": "ger",
+	"fr": "fre",
+	"es": "spa",
+	"it": "ita",
+	"pt": "por",
+	"nl": "dut",
+	"ja": "jpn",
+	"zh": "chi",
+	"ru": "rus",
+	"pl": "pol",
+}
+
+var langSpecRegexp = regexp.MustCompile(`^(?:([as])(?::(\d+))?=)?([a-zA-Z]{2,3})$`)
+
+// langSpec sets the language of a stream, of all audio or subtitle streams missing a language if index is -1, or of
+// all streams missing a language if streamType is empty as well
+type langSpec struct {
+	streamType string
+	index      int
+	language   string
+}
+
+// parseLangSpec parses a language spec, e.g. "eng", "s=hun" or "a:1=en"
+func parseLangSpec(spec string) (langSpec, error) {
+	match := langSpecRegexp.FindStringSubmatch(spec)
+	if match == nil {
+		return langSpec{}, fmt.Errorf("invalid language, expected a language code optionally prefixed with a stream, e.g. a:1=eng. language: %q", spec)
+	}
+
+	language := strings.ToLower(match[3])
+	if len(language) == 2 {
+		code, ok := languageCodes[language]
+		if !ok {
+			return langSpec{}, fmt.Errorf("unknown two-letter language code, use a three-letter one. language: %q", match[3])
+		}
+		language = code
+	}
+
+	index := -1
+	if match[2] != "" {
+		index, _ = strconv.Atoi(match[2])
+	}
+
+	return langSpec{streamType: match[1], index: index, language: language}, nil
+}
+
+// stopWords contains frequent short words of languages, used to tell the language of subtitles
+var stopWords = map[string][]string{
+	"eng": {"the", "and", "you", "that", "is", "to", "of", "it", "what", "this", "in", "have", "not", "i"},
+	"hun": {"a", "az", "és", "hogy", "nem", "egy", "is", "meg", "van", "mi", "ez", "de", "csak", "már"},
+	"ger": {"der", "die", "und", "das", "ist", "nicht", "ich", "du", "sie", "es", "ein", "zu", "mit", "was"},
+	"fre": {"le", "la", "les", "et", "est", "pas", "je", "vous", "un", "une", "que", "ce", "il", "tu"},
+	"spa": {"el", "la", "que", "de", "y", "no", "es", "los", "en", "un", "por", "qué", "lo", "se"},
+	"ita": {"il", "che", "non", "di", "è", "la", "un", "per", "sono", "ma", "mi", "ti", "lo", "ho"},
+	"por": {"o", "que", "não", "de", "é", "um", "uma", "você", "eu", "se", "os", "para", "com", "está"},
+	"dut": {"de", "het", "een", "en", "is", "niet", "ik", "je", "dat", "van", "wat", "zijn", "met", "op"},
+}
+
+// minDetectionWords is the number of words needed to tell the language of a text
+const minDetectionWords = 20
+
+var subtitleMarkupRegexp = regexp.MustCompile(`<[^>]*>|\{[^}]*\}`)
+
+// detectLanguage tells the language of a text by the share of stop words of each language, an empty string is
+// returned if the text is too short or no language stands out
+func detectLanguage(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(subtitleMarkupRegexp.ReplaceAllString(text, " ")), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	if len(words) < minDetectionWords {
+		return ""
+	}
+
+	best, bestScore, secondScore := "", 0.0, 0.0
+	for language, list := range stopWords {
+		set := map[string]bool{}
+		for _, w := range list {
+			set[w] = true
+		}
+
+		count := 0
+		for _, w := range words {
+			if set[w] {
+				count++
+			}
+		}
+
+		score := float64(count) / float64(len(words))
+		switch {
+		case score > bestScore:
+			best, bestScore, secondScore = language, score, bestScore
+		case score > secondScore:
+			secondScore = score
+		}
+	}
+
+	// the most frequent words of related languages overlap, the best one has to stand out
+	if bestScore < 0.1 || bestScore < secondScore*1.2 {
+		return ""
+	}
+
+	return best
+}
+
+// detectSubtitleLanguage extracts the text of a subtitle stream and tells its language. Image based subtitles can not
+// be extracted.
+func detectSubtitleLanguage(st *state, fi os.FileInfo, index int) (string, error) {
+	text, err := exec(st, []string{"ffmpeg", "-v", "error", inputKey, fi.Name(), "-map", fmt.Sprintf("0:s:%d", index), "-f", "srt", "-"})
+	if err != nil {
+		return "", &EncodeError{Path: fi.Name(), Operation: "extract subtitles", Err: err}
+	}
+
+	return detectLanguage(text), nil
+}
+
+// languageArgs returns the ffmpeg arguments setting the languages of the streams. Specs with an index always set the
+// language, the others and detection only fill in missing languages.
+func languageArgs(streams []streamInfo, specs []langSpec, detect func(index int) string) []string {
+	var args []string
+	for _, s := range streams {
+		language := ""
+		for _, spec := range specs {
+			if spec.index >= 0 && spec.streamType == s.streamType && spec.index == s.index {
+				language = spec.language
+
+				break
+			}
+		}
+
+		missing := s.language == "" || s.language == undeterminedLanguage
+		if language == "" && missing {
+			for _, spec := range specs {
+				if spec.index < 0 && (spec.streamType == "" || spec.streamType == s.streamType) {
+					language = spec.language
+
+					break
+				}
+			}
+		}
+
+		if language == "" && missing && s.streamType == streamTypeSubtitle && detect != nil {
+			language = detect(s.index)
+		}
+
+		if language == "" || language == s.language {
+			continue
+		}
+
+		args = append(args, fmt.Sprintf("-metadata:s:%s:%d", s.streamType, s.index), "language="+language)
+	}
+
+	return args
+}
+
+// langTag sets the language metadata of audio and subtitle streams, copying the streams into a new file
+func langTag(st *state, fi os.FileInfo, specs []langSpec, detect, forceOverwrite, dryRun bool) (string, error) {
+	filePath := fi.Name()
+
+	streams, err := getStreams(st, fi)
+	if err != nil {
+		return "", err
+	}
+
+	var detectFn func(index int) string
+	if detect {
+		detectFn = func(index int) string {
+			language, err := detectSubtitleLanguage(st, fi, index)
+			if err != nil {
+				st.log.Printf("failed to detect subtitle language. file: %q, stream: s:%d, err: %v", filePath, index, err)
+
+				return ""
+			}
+			if language == "" {
+				st.log.Printf("subtitle language not recognized. file: %q, stream: s:%d", filePath, index)
+			}
+
+			return language
+		}
+	}
+
+	args := languageArgs(streams, specs, detectFn)
+	if len(args) == 0 {
+		st.log.Printf("no language to set. file: %q", filePath)
+
+		return "", nil
+	}
+
+	basePath := filepath.Base(filePath)
+	ext := filepath.Ext(filePath)
+	if ext != "" {
+		basePath = basePath[:len(basePath)-len(ext)]
+	}
+
+	outputPath := filepath.Join(filepath.Dir(filePath), fmt.Sprintf("%s-lang%s", basePath, ext))
+
+	command := []string{"ffmpeg", inputKey, filePath, "-map", "0", "-c", "copy"}
+	command = append(command, args...)
+	command = append(command, outputPath)
+
+	st.log.Printf("new path: %s", outputPath)
+	command = showCommand(st, command)
+
+	if err := checkRoot(st, filePath, outputPath); err != nil {
+		return "", err
+	}
+
+	if err := checkCollision(st, outputPath, forceOverwrite); err != nil {
+		return "", err
+	}
+
+	if dryRun {
+		planEncode(st, filePath, outputPath)
+
+		return outputPath, nil
+	}
+
+	output, err := exec(st, command)
+	if err != nil {
+		st.log.Println(output)
+
+		return "", &EncodeError{Path: filePath, Operation: "tag languages", Err: err}
+	}
+
+	recordEncode(st, filePath, outputPath)
+
+	return outputPath, nil
+}
+
+func (a App) langTag(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
+	forceOverwrite := c.Bool(forceFlag)
+	detect := c.Bool(detectFlag)
+
+	var specs []langSpec
+	for _, raw := range c.StringSlice(langFlag) {
+		spec, err := parseLangSpec(raw)
+		if err != nil {
+			return err
+		}
+		specs = append(specs, spec)
+	}
+
+	if len(specs) == 0 && !detect {
+		return fmt.Errorf("no language given, use --%s or --%s", langFlag, detectFlag)
+	}
+
+	_, err := langTag(a.state, fi, specs, detect, forceOverwrite, dryRun)
+
+	return err
+}
+
+const (
+	channelsDownmix = "downmix"
+	channelsSwap    = "swap"
+	channelsExtract = "extract"
+)
+
+var channelNameRegexp = regexp.MustCompile(`^(FL|FR|FC|LFE|BL|BR|SL|SR|c\d+)$`)
+
+// getPanFilter returns the pan filter and the file name token for changing the channel layout. Downmixing uses channel
+// indexes so that it works for both the back and side variants of 5.1.
+func getPanFilter(mode, channel string) (string, string, error) {
+	switch mode {
+	case channelsDownmix:
+		return "pan=stereo|c0<c0+0.707*c2+0.707*c4|c1<c1+0.707*c2+0.707*c5", "stereo", nil
+	case channelsSwap:
+		return "pan=stereo|c0=c1|c1=c0", "swapped", nil
+	case channelsExtract:
+		if _, err := strconv.Atoi(channel); err == nil {
+			channel = "c" + channel
+		}
+
+		if !channelNameRegexp.MatchString(channel) {
+			return "", "", fmt.Errorf("invalid channel. channel: %q", channel)
+		}
+
+		return fmt.Sprintf("pan=mono|c0=%s", channel), channel, nil
+	}
+
+	return "", "", fmt.Errorf("invalid channel mode. mode: %q", mode)
+}
+
+// audioChannels changes the channel layout of a file, copying the video stream if there is one
+func audioChannels(st *state, fi os.FileInfo, mode, channel string, forceOverwrite, dryRun bool) (string, error) {
+	filePath := fi.Name()
+
+	basePath := filepath.Base(filePath)
+	ext := filepath.Ext(filePath)
+	if ext != "" {
+		basePath = basePath[:len(basePath)-len(ext)]
+	}
+
+	filter, token, err := getPanFilter(mode, channel)
+	if err != nil {
+		return "", err
+	}
+
+	outputPath := filepath.Join(filepath.Dir(filePath), fmt.Sprintf("%s-%s%s", basePath, token, ext))
+	command := []string{"ffmpeg", inputKey, filePath, videoCodecKey, "copy", "-filter:a", filter, outputPath}
+
+	st.log.Printf("new path: %s", outputPath)
+	command = showCommand(st, command)
+
+	if err := checkRoot(st, filePath, outputPath); err != nil {
+		return "", err
+	}
+
+	if err := checkCollision(st, outputPath, forceOverwrite); err != nil {
+		return "", err
+	}
+
+	if dryRun {
+		planEncode(st, filePath, outputPath)
+
+		return outputPath, nil
+	}
+
+	output, err := exec(st, command)
+	if err != nil {
+		st.log.Println(output)
+
+		return "", &EncodeError{Path: filePath, Operation: "change audio channels", Err: err}
+	}
+
+	recordEncode(st, filePath, outputPath)
+
+	return outputPath, nil
+}
+
+func (a App) audioChannels(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
+	forceOverwrite := c.Bool(forceFlag)
+
+	channel := c.String(channelFlag)
+
+	_, err := audioChannels(a.state, fi, args[0], channel, forceOverwrite, dryRun)
+
+	return err
+}
+
+// getFilterGraph returns the filter graph of a preset from the config file, or the ad hoc graph if no preset is used
+func getFilterGraph(presets map[string]string, preset, graph string) (string, string, error) {
+	if preset == "" {
+		if graph == "" {
+			return "", "", errors.New("either a filter preset or a filter graph is required")
+		}
+
+		return graph, "filtered", nil
+	}
+
+	graph, ok := presets[preset]
+	if !ok {
+		return "", "", fmt.Errorf("unknown filter preset. preset: %q", preset)
+	}
+
+	return graph, preset, nil
+}
+
+// filter applies a video filter graph to a file, the name of the preset used is added to the file name
+func filter(st *state, fi os.FileInfo, preset, graph string, forceOverwrite, dryRun bool) (string, error) {
+	filePath := fi.Name()
+
+	basePath := filepath.Base(filePath)
+	ext := filepath.Ext(filePath)
+	if ext != "" {
+		basePath = basePath[:len(basePath)-len(ext)]
+	}
+
+	graph, token, err := getFilterGraph(st.config.FilterPresets, preset, graph)
+	if err != nil {
+		return "", err
+	}
+
+	outputPath := filepath.Join(filepath.Dir(filePath), fmt.Sprintf("%s-%s%s", basePath, token, ext))
+	command := []string{"ffmpeg", inputKey, filePath, "-filter:v", graph, audioCodecKey, "copy", outputPath}
+
+	st.log.Printf("new path: %s", outputPath)
+	command = showCommand(st, command)
+
+	if err := checkRoot(st, filePath, outputPath); err != nil {
+		return "", err
+	}
+
+	if err := checkCollision(st, outputPath, forceOverwrite); err != nil {
+		return "", err
+	}
+
+	if dryRun {
+		planEncode(st, filePath, outputPath)
+
+		return outputPath, nil
+	}
+
+	output, err := exec(st, command)
+	if err != nil {
+		st.log.Println(output)
+
+		return "", &EncodeError{Path: filePath, Operation: "filter video", Err: err}
+	}
+
+	recordEncode(st, filePath, outputPath)
+
+	return outputPath, nil
+}
+
+func (a App) filter(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
+	forceOverwrite := c.Bool(forceFlag)
+
+	preset := c.String(filterPresetFlag)
+	graph := c.String(filterGraphFlag)
+
+	_, err := filter(a.state, fi, preset, graph, forceOverwrite, dryRun)
+
+	return err
+}
+
+const (
+	eachInputPlaceholder  = "{in}"
+	eachOutputPlaceholder = "{out}"
+)
+
+// getEachCommand builds an ffmpeg command out of custom arguments, replacing the input and output placeholders
+func getEachCommand(ffmpegArgs []string, inputPath, outputPath string) ([]string, error) {
+	hasOutput := false
+	params := []string{"ffmpeg"}
+	for _, arg := range ffmpegArgs {
+		if strings.Contains(arg, eachOutputPlaceholder) {
+			hasOutput = true
+		}
+
+		arg = strings.Replace(arg, eachInputPlaceholder, inputPath, -1)
+		arg = strings.Replace(arg, eachOutputPlaceholder, outputPath, -1)
+		params = append(params, arg)
+	}
+
+	if !hasOutput {
+		return nil, fmt.Errorf("output placeholder is missing from the ffmpeg arguments. placeholder: %s", eachOutputPlaceholder)
+	}
+
+	return params, nil
+}
+
+// each runs a custom ffmpeg command on a file, the output is named after the input with a tag and optionally a new
+// extension
+func each(st *state, fi os.FileInfo, ffmpegArgs []string, tag, newExt string, forceOverwrite, dryRun bool) (string, error) {
+	filePath := fi.Name()
+
+	basePath := filepath.Base(filePath)
+	ext := filepath.Ext(filePath)
+	if ext != "" {
+		basePath = basePath[:len(basePath)-len(ext)]
+	}
+
+	if newExt != "" {
+		ext = "." + strings.TrimPrefix(newExt, ".")
+	}
+
+	outputPath := filepath.Join(filepath.Dir(filePath), fmt.Sprintf("%s-%s%s", basePath, tag, ext))
+
+	command, err := getEachCommand(ffmpegArgs, filePath, outputPath)
+	if err != nil {
+		return "", err
+	}
+
+	st.log.Printf("new path: %s", outputPath)
+	command = showCommand(st, command)
+
+	if err := checkRoot(st, filePath, outputPath); err != nil {
+		return "", err
+	}
+
+	if err := checkCollision(st, outputPath, forceOverwrite); err != nil {
+		return "", err
+	}
+
+	if dryRun {
+		planEncode(st, filePath, outputPath)
+
+		return outputPath, nil
+	}
+
+	output, err := exec(st, command)
+	if err != nil {
+		st.log.Println(output)
+
+		return "", &EncodeError{Path: filePath, Operation: "run ffmpeg", Err: err}
+	}
+
+	recordEncode(st, filePath, outputPath)
+
+	return outputPath, nil
+}
+
+func (a App) each(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
+	forceOverwrite := c.Bool(forceFlag)
+
+	tag := c.String(tagFlag)
+	newExt := c.String(outputExtFlag)
+
+	_, err := each(a.state, fi, args, tag, newExt, forceOverwrite, dryRun)
+
+	return err
+}
+
+func (a App) crop(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
+	forceOverwrite := c.Bool(forceFlag)
+
+	width := c.Int(widthFlag)
+	height := c.Int(heightFlag)
+	x := c.String(xFlag)
+	y := c.String(yFlag)
+
+	dimensionPreset := c.String(dimensionPresetFlag)
+
+	return crop(a.state, fi, width, height, x, y, dimensionPreset, forceOverwrite, dryRun)
+}
+
+type videoType struct {
+	name      string
+	size      int64
+	bitRate   int64
+	length    float64
+	frameRate float64
+	width     int64
+	height    int64
+	codec     string
+	indexes   []string
+	vfr       bool
+	color     colorInfo
+	audio     []string
+	subtitles []string
+}
+
+type videoTypes []videoType
+
+// infoColumns contains the headers of the columns info can display
+var infoColumns = map[string]string{
+	"name":      "FILE",
+	"size":      "SIZE",
+	"bitrate":   "BITRATE",
+	"length":    "LENGTH",
+	"framerate": "FRAMERATE",
+	"width":     "WIDTH",
+	"height":    "HEIGHT",
+	"codec":     "CODEC",
+	"primaries": "PRIMARIES",
+	"transfer":  "TRANSFER",
+	"hdr":       "HDR",
+	"audio":     "AUDIO",
+	"subtitles": "SUBTITLES",
+	"indexes":   "INDEXES",
+}
+
+var defaultInfoColumns = []string{"name", "size", "bitrate", "length", "framerate", "width", "height", "codec", "hdr", "indexes"}
+
+func (v videoType) column(st *state, column string, skipKeyFrames bool, maxNameLength int) string {
+	switch column {
+	case "name":
+		return truncate.String(v.name, maxNameLength, truncate.Middle)
+	case "size":
+		return formatUnits(st, v.size, " ", "B")
+	case "bitrate":
+		return formatUnits(st, v.bitRate, " ", "bit")
+	case "length":
+		return formatDuration(st, v.length)
+	case "framerate":
+		if v.vfr {
+			return fmt.Sprintf("%v VFR", float64(int(v.frameRate*10))/10)
+		}
+
+		return fmt.Sprint(float64(int(v.frameRate*10)) / 10)
+	case "width":
+		return fmt.Sprint(v.width)
+	case "height":
+		return fmt.Sprint(v.height)
+	case "codec":
+		return v.codec
+	case "primaries":
+		return v.color.primaries
+	case "transfer":
+		return v.color.transfer
+	case "hdr":
+		if v.codec == "" {
+			return ""
+		}
+
+		return v.color.HDRFormat()
+	case "audio":
+		return strings.Join(v.audio, ",")
+	case "subtitles":
+		return strings.Join(v.subtitles, ",")
+	case "indexes":
+		if skipKeyFrames {
+			return "SKIPPED"
+		}
+
+		return strings.Join(v.indexes, " ")
+	}
+
+	return ""
+}
+
+// Table returns the header and the lines of the info table, including a line of totals. Names longer than
+// maxNameLength are truncated unless maxNameLength is 0.
+func (vs videoTypes) Table(st *state, columns []string, skipKeyFrames bool, maxNameLength int) ([]interface{}, [][]interface{}, error) {
+	if len(columns) == 0 {
+		columns = defaultInfoColumns
+	}
+
+	var header []interface{}
+	for _, column := range columns {
+		h, ok := infoColumns[column]
+		if !ok {
+			return nil, nil, fmt.Errorf("invalid column. column: %s", column)
+		}
+
+		header = append(header, h)
+	}
+
+	var lines [][]interface{}
+	var totalSize int64
+	var totalLength float64
+	for _, v := range vs {
+		var cols []interface{}
+		for _, column := range columns {
+			cols = append(cols, v.column(st, column, skipKeyFrames, maxNameLength))
+		}
+
+		lines = append(lines, cols)
+		totalSize += v.size
+		totalLength += v.length
+	}
+
+	var totals []interface{}
+	for i, column := range columns {
+		switch {
+		case column == "size":
+			totals = append(totals, formatUnits(st, totalSize, " ", "B"))
+		case column == "length":
+			totals = append(totals, formatDuration(st, totalLength))
+		case i == 0:
+			totals = append(totals, "TOTAL")
+		default:
+			totals = append(totals, "")
+		}
+	}
+	lines = append(lines, totals)
+
+	return header, lines, nil
+}
+
+func (vs videoTypes) Print(st *state, columns []string, skipKeyFrames bool, maxNameLength int) error {
+	header, lines, err := vs.Table(st, columns, skipKeyFrames, maxNameLength)
+	if err != nil {
+		return err
+	}
+
+	t := tabby.New()
+	t.AddHeader(header...)
+	for _, line := range lines {
+		t.AddLine(line...)
+	}
+	t.Print()
+
+	return nil
+}
+
+// formatDuration formats a duration in seconds as hh:mm:ss.s
+func formatDuration(st *state, seconds float64) string {
+	if st.rawSeconds {
+		return fmt.Sprintf("%.1f", seconds)
+	}
+
+	tenths := int64(math.Round(seconds * 10))
+	hours := tenths / 36000
+	minutes := tenths / 600 % 60
+	secs := float64(tenths%600) / 10
+
+	return fmt.Sprintf("%02d:%02d:%04.1f", hours, minutes, secs)
+}
+
+const (
+	unitsSI  = "si"
+	unitsIEC = "iec"
+)
+
+// formatUnits formats a number for display with either decimal (SI) or binary (IEC) prefixes. Unlike intToString,
+// which is also used for ffmpeg arguments, the output depends on the unit settings.
+func formatUnits(st *state, n int64, s, s2 string) string {
+	base := 1000.0
+	infix := ""
+	if st.unitSystem == unitsIEC {
+		base = 1024
+		infix = "i"
+	}
+
+	prefixes := []string{"K", "M", "G", "T"}
+
+	v := float64(n)
+	i := -1
+	for i+1 < len(prefixes) && math.Abs(v) > base {
+		v /= base
+		i++
+	}
+
+	if i < 0 {
+		return fmt.Sprintf("%d%s%s", n, s, s2)
+	}
+
+	return fmt.Sprintf("%.*f%s%s%s%s", st.unitPrecision, v, s, prefixes[i], infix, s2)
+}
+
+func intToString(n int64, s, s2 string) string {
+	if n > 1000*1000*1000*1000 {
+		return fmt.Sprintf("%.1f%sT%s", float64(n)/1000/1000/1000/1000, s, s2)
+	} else if n > 1000*1000*1000 {
+		return fmt.Sprintf("%.1f%sG%s", float64(n)/1000/1000/1000, s, s2)
+	} else if n > 1000*1000 {
+		return fmt.Sprintf("%.1f%sM%s", float64(n)/1000/1000, s, s2)
+	} else if n > 1000 {
+		return fmt.Sprintf("%.1f%sK%s", float64(n)/1000, s, s2)
+	}
+
+	return fmt.Sprintf("%d%s%s", n, s, s2)
+}
+
+func getBitRate(st *state, fi os.FileInfo) (int64, error) {
+	bitrateRaw, err := probe(st, []string{"ffprobe", "-v", "quiet", "-select_streams", videoStreamSpecifier(st), "-show_entries", "stream=bit_rate", "-of", "default=noprint_wrappers=1", fi.Name()})
+	if err != nil {
+		return 0, &ProbeError{Path: fi.Name(), Err: err}
+	}
+
+	if strings.TrimSpace(bitrateRaw) == "" {
+		return 0, &ProbeError{Path: fi.Name(), Err: ErrNoVideoStream}
+	}
+
+	if len(bitrateRaw) < 10 {
+		return 0, fmt.Errorf("invalid probe result. file: %q, bitrate found: %s", fi.Name(), bitrateRaw)
+	}
+
+	bitrateRaw = strings.TrimSpace(bitrateRaw[9:])
+	if bitrateRaw == "N/A" {
+		return 0, nil
+	}
+
+	bitRate, err := strconv.ParseInt(bitrateRaw, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse bit rate. file: %q, err: %w", fi.Name(), err)
+	}
+
+	return bitRate, nil
+}
+
+func getCodec(st *state, fi os.FileInfo) (string, error) {
+	codec, err := probe(st, []string{"ffprobe", "-v", "quiet", "-select_streams", videoStreamSpecifier(st), "-show_entries", "stream=codec_name", "-of", "default=noprint_wrappers=1:nokey=1", fi.Name()})
+	if err != nil {
+		return "", &ProbeError{Path: fi.Name(), Err: fmt.Errorf("codec: %w", err)}
+	}
+
+	codec = strings.TrimSpace(codec)
+	if codec == "" {
+		return "", &ProbeError{Path: fi.Name(), Err: ErrNoVideoStream}
+	}
+
+	parts := strings.Fields(codec)
+	if len(parts) > 1 {
+		return "", fmt.Errorf("suspicious codec found. file: %q, codec: %s", fi.Name(), codec)
+	}
+
+	return parts[0], nil
+}
+
+func getLength(st *state, fi os.FileInfo) (float64, error) {
+	lengthRaw, err := probe(st, []string{"ffprobe", "-v", "quiet", "-show_entries", "format=duration", "-of", "default=noprint_wrappers=1:nokey=1", fi.Name()})
+	if err != nil {
+		return 0.0, &ProbeError{Path: fi.Name(), Err: fmt.Errorf("length: %w", err)}
+	}
+
+	length, err := strconv.ParseFloat(strings.TrimSpace(lengthRaw), 64)
+	if err != nil {
+		return 0.0, fmt.Errorf("failed to parse length. file: %q, err: %w", fi.Name(), err)
+	}
+
+	return length, nil
+}
+
+// parseFrameRate parses a frame rate reported by ffprobe, e.g. "30000/1001" or "25". Unknown frame rates, reported
+// as "0/0", are returned as zero.
+func parseFrameRate(raw string) (float64, error) {
+	numerator, denominator, found := strings.Cut(strings.TrimSpace(raw), "/")
+
+	p0, err := strconv.ParseFloat(numerator, 64)
+	if err != nil {
+		return 0.0, fmt.Errorf("invalid frame rate. frame rate: %q, err: %w", raw, err)
+	}
+
+	if !found {
+		return p0, nil
+	}
+
+	p1, err := strconv.ParseFloat(denominator, 64)
+	if err != nil {
+		return 0.0, fmt.Errorf("invalid frame rate. frame rate: %q, err: %w", raw, err)
+	}
+
+	if p1 == 0 {
+		return 0.0, nil
+	}
+
+	return p0 / p1, nil
+}
+
+// getFrameRates returns the real base frame rate and the average frame rate of the selected video stream, unknown
+// frame rates are zero
+func getFrameRates(st *state, fi os.FileInfo) (float64, float64, error) {
+	frameRateRaw, err := probe(st, []string{"ffprobe", "-v", "quiet", "-select_streams", videoStreamSpecifier(st), "-of", "default=noprint_wrappers=1:nokey=1", "-show_entries", "stream=r_frame_rate,avg_frame_rate", fi.Name()})
+	if err != nil {
+		return 0.0, 0.0, &ProbeError{Path: fi.Name(), Err: fmt.Errorf("frame rate: %w", err)}
+	}
+
+	lines := strings.Fields(frameRateRaw)
+	if len(lines) == 0 {
+		return 0.0, 0.0, &ProbeError{Path: fi.Name(), Err: ErrNoVideoStream}
+	}
+
+	var rates [2]float64
+	for i := 0; i < len(lines) && i < len(rates); i++ {
+		rates[i], err = parseFrameRate(lines[i])
+		if err != nil {
+			return 0.0, 0.0, fmt.Errorf("failed to parse frame rate. file: %q, err: %w", fi.Name(), err)
+		}
+	}
+
+	return rates[0], rates[1], nil
+}
+
+// getFrameRate returns the frame rate of the selected video stream. The average frame rate is used if the real base
+// frame rate is unknown, zero is returned if neither is known.
+func getFrameRate(st *state, fi os.FileInfo) (float64, error) {
+	realRate, avgRate, err := getFrameRates(st, fi)
+	if err != nil {
+		return 0.0, err
+	}
+
+	if realRate > 0 {
+		return realRate, nil
+	}
+
+	return avgRate, nil
+}
+
+const (
+	hdrFormatHDR10       = "HDR10"
+	hdrFormatHLG         = "HLG"
+	hdrFormatDolbyVision = "Dolby Vision"
+	hdrFormatSDR         = "SDR"
+
+	transferPQ  = "smpte2084"
+	transferHLG = "arib-std-b67"
+
+	dolbyVisionSideData = "DOVI configuration record"
+)
+
+// colorInfo contains the color properties of a video stream
+type colorInfo struct {
+	primaries   string
+	transfer    string
+	space       string
+	dolbyVision bool
+}
+
+// HDRFormat returns the HDR format of the stream, Dolby Vision takes precedence over the base layer's format
+func (ci colorInfo) HDRFormat() string {
+	switch {
+	case ci.dolbyVision:
+		return hdrFormatDolbyVision
+	case ci.transfer == transferPQ:
+		return hdrFormatHDR10
+	case ci.transfer == transferHLG:
+		return hdrFormatHLG
+	}
+
+	return hdrFormatSDR
+}
+
+// IsHDR checks if the stream is in any of the HDR formats
+func (ci colorInfo) IsHDR() bool {
+	return ci.HDRFormat() != hdrFormatSDR
+}
+
+func getColorInfo(st *state, fi os.FileInfo) (colorInfo, error) {
+	raw, err := probe(st, []string{"ffprobe", "-v", "quiet", "-select_streams", videoStreamSpecifier(st), "-show_entries", "stream=color_primaries,color_transfer,color_space:stream_side_data=side_data_type", "-of", "json", fi.Name()})
+	if err != nil {
+		return colorInfo{}, &ProbeError{Path: fi.Name(), Err: fmt.Errorf("color info: %w", err)}
+	}
+
+	var probed struct {
+		Streams []struct {
+			ColorPrimaries string `json:"color_primaries"`
+			ColorTransfer  string `json:"color_transfer"`
+			ColorSpace     string `json:"color_space"`
+			SideDataList   []struct {
+				SideDataType string `json:"side_data_type"`
+			} `json:"side_data_list"`
+		} `json:"streams"`
+	}
+
+	err = json.Unmarshal([]byte(raw), &probed)
+	if err != nil {
+		return colorInfo{}, fmt.Errorf("failed to parse color info. file: %q, err: %w", fi.Name(), err)
+	}
+
+	if len(probed.Streams) == 0 {
+		return colorInfo{}, &ProbeError{Path: fi.Name(), Err: ErrNoVideoStream}
+	}
+
+	stream := probed.Streams[0]
+	ci := colorInfo{
+		primaries: stream.ColorPrimaries,
+		transfer:  stream.ColorTransfer,
+		space:     stream.ColorSpace,
+	}
+	for _, sideData := range stream.SideDataList {
+		if sideData.SideDataType == dolbyVisionSideData {
+			ci.dolbyVision = true
+		}
+	}
+
+	return ci, nil
+}
+
+// hdrWarning returns a warning if re-encoding a stream with the given options would lose its HDR metadata, an empty
+// string otherwise
+func hdrWarning(ci colorInfo, o reEncodeOptions) string {
+	if !ci.IsHDR() {
+		return ""
+	}
+
+	switch {
+	case ci.dolbyVision:
+		return "Dolby Vision metadata is dropped by re-encoding, only the base layer is kept"
+	case o.bitDepth == 8:
+		return fmt.Sprintf("%s needs at least 10-bit color, 8-bit output will look washed out", ci.HDRFormat())
+	case o.codec == encoderH265 && ci.transfer == transferPQ && !strings.Contains(o.x265Params, "master-display"):
+		return fmt.Sprintf("HDR10 mastering display metadata is not carried over, pass it via --%s master-display=...", x265ParamsFlag)
+	}
+
+	return ""
+}
+
+// warnHDR warns if re-encoding a file with the given options would lose its HDR metadata
+func warnHDR(st *state, fi os.FileInfo, o reEncodeOptions) {
+	ci, err := getColorInfo(st, fi)
+	if err != nil {
+		st.log.Printf("failed to retrieve video color info. err: %q", err)
+
+		return
+	}
+
+	if warning := hdrWarning(ci, o); warning != "" {
+		st.progress.Println(colorize(st, colorYellow, fmt.Sprintf("%s. file: %q", warning, fi.Name())))
+	}
+}
+
+// vfrTolerance is the relative difference between the real and the average frame rate above which a video is
+// considered to have a variable frame rate
+const vfrTolerance = 0.002
+
+// isVariableFrameRate checks if the average frame rate of a video differs from its real base frame rate, which is
+// the case for most phone and screen recordings
+func isVariableFrameRate(realRate, avgRate float64) bool {
+	if realRate <= 0 || avgRate <= 0 {
+		return false
+	}
+
+	return math.Abs(realRate-avgRate)/realRate > vfrTolerance
+}
+
+func info(st *state, fi os.FileInfo, skipKeyFrames bool) videoType {
+	if hasExtension(fi.Name(), imageExtensions) {
+		return imageInfo(st, fi)
+	}
+
+	length, err := getLength(st, fi)
+	if err != nil {
+		st.log.Printf("failed to retrieve video length. err: %q", err)
+	}
+
+	vt := videoType{
+		name:   fi.Name(),
+		size:   fi.Size(),
+		length: length,
+	}
+
+	// the codec is probed first so that files without a video stream, e.g. audio files, are only reported once
+	vt.codec, err = getCodec(st, fi)
+	if errors.Is(err, ErrNoVideoStream) {
+		st.log.Printf("no video stream to probe. file: %q, stream: %s", fi.Name(), videoStreamSpecifier(st))
+
+		return vt
+	}
+	if err != nil {
+		st.log.Printf("failed to retrieve video codec. err: %q", err)
+	}
+
+	vt.bitRate, err = getBitRate(st, fi)
+	if err != nil {
+		st.log.Printf("failed to retrieve video bitrate. err: %q", err)
+	}
+
+	realRate, avgRate, err := getFrameRates(st, fi)
+	if err != nil {
+		st.log.Printf("failed to retrieve video frame rate. err: %q", err)
+	}
+	vt.frameRate = realRate
+	if vt.frameRate <= 0 {
+		vt.frameRate = avgRate
+	}
+	vt.vfr = isVariableFrameRate(realRate, avgRate)
+
+	vt.color, err = getColorInfo(st, fi)
+	if err != nil {
+		st.log.Printf("failed to retrieve video color info. err: %q", err)
+	}
+
+	dimensions, err := getDimensions(st, fi)
+	if err != nil {
+		st.log.Printf("failed to retrieve video dimensions. err: %q", err)
+	} else {
+		width, height, err := parseDimensions(dimensions)
+		if err != nil {
+			st.log.Printf("failed to parse video dimensions. err: %q", err)
+		}
+		vt.width, vt.height = int64(width), int64(height)
+	}
+
+	if !skipKeyFrames {
+		vt.indexes, err = findKeyFrames(st, fi)
+		if err != nil {
+			st.log.Printf("failed to find key frames. err: %q", err)
+		}
+	}
+
+	return vt
+}
+
+// imageInfo probes only the properties which make sense for still images
+func imageInfo(st *state, fi os.FileInfo) videoType {
+	dimensions, err := getDimensions(st, fi)
+	if err != nil {
+		st.log.Printf("failed to retrieve image dimensions. err: %q", err)
+	}
+
+	width, height, err := parseDimensions(dimensions)
+	if err != nil {
+		st.log.Printf("failed to parse image dimensions. err: %q", err)
+	}
+
+	codec, err := getCodec(st, fi)
+	if err != nil {
+		st.log.Printf("failed to retrieve image codec. err: %q", err)
+	}
+
+	return videoType{
+		name:   fi.Name(),
+		size:   fi.Size(),
+		width:  int64(width),
+		height: int64(height),
+		codec:  codec,
+	}
+}
+
+// prefetchInfo runs the probes of info, key frames are left out as they are expensive to store
+func prefetchInfo(st *state, fi os.FileInfo) {
+	if fi.IsDir() {
+		return
+	}
+
+	_, _ = getDimensions(st, fi)
+	_, _ = getCodec(st, fi)
+	if hasExtension(fi.Name(), imageExtensions) {
+		return
+	}
+
+	_, _ = getLength(st, fi)
+	_, _ = getBitRate(st, fi)
+	_, _, _ = getFrameRates(st, fi)
+	_, _ = getColorInfo(st, fi)
+}
+
+// fitNameLength returns the maximum name length making the info table fit into width
+func (vs videoTypes) fitNameLength(st *state, columns []string, skipKeyFrames bool, width int) (int, error) {
+	if len(columns) == 0 {
+		columns = defaultInfoColumns
+	}
+
+	index := -1
+	for i, column := range columns {
+		if column == "name" {
+			index = i
+		}
+	}
+	if index < 0 {
+		return 0, nil
+	}
+
+	header, lines, err := vs.Table(st, columns, skipKeyFrames, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	return fitColumn(append([][]interface{}{header}, lines...), index, width), nil
+}
+
+// infoAll prints the info table of the files, a maxNameLength of -1 makes the names fit the terminal width
+func infoAll(st *state, fileList []os.FileInfo, columns []string, skipKeyFrames bool, maxNameLength int) error {
+	prefetch(st, fileList, st.jobs, func(fi os.FileInfo) {
+		prefetchInfo(st, fi)
+	})
+
+	v := videoTypes{}
+	for _, fi := range fileList {
+		if fi.IsDir() {
+			continue
+		}
+
+		vt := info(st, fi, skipKeyFrames)
+		if containsString(columns, "audio") || containsString(columns, "subtitles") {
+			var err error
+			vt.audio, vt.subtitles, err = streamLanguages(st, fi)
+			if err != nil {
+				st.log.Printf("failed to retrieve stream languages. err: %q", err)
+			}
+		}
+
+		v = append(v, vt)
+	}
+
+	if maxNameLength < 0 {
+		var err error
+		maxNameLength, err = v.fitNameLength(st, columns, skipKeyFrames, terminalWidth(os.Stdout))
+		if err != nil {
+			return err
+		}
+	}
+
+	return v.Print(st, columns, skipKeyFrames, maxNameLength)
+}
+
+func (a App) infoAll(c *cli.Context, args []string, fileList []os.FileInfo, dryRun bool) error {
+	skipKeyFrames := c.Bool(skipKeyframesFlag)
+	maxNameLength := getMaxNameLength(c)
+	columns := splitList(c.String(columnsFlag))
+
+	return infoAll(a.state, fileList, columns, skipKeyFrames, maxNameLength)
+}
+
+// getMaxNameLength returns the maximum name length of tables, 0 disables truncation and -1 makes names fit the terminal
+func getMaxNameLength(c *cli.Context) int {
+	switch {
+	case c.Bool(fullNamesFlag):
+		return 0
+	case c.IsSet(maxNameLengthFlag):
+		return c.Int(maxNameLengthFlag)
+	}
+
+	return -1
+}
+
+// recordingRegexp matches the default names of screenshots and screen recordings created by macOS and GNOME, e.g.
+// "Screen Recording 2024-01-02 at 10.11.12.mov", "Screen Shot 2020-01-02 at 1.02.03 PM.png" or
+// "Screencast from 2024-01-02 10-11-12.webm". Recent macOS versions put a narrow no-break space before AM and PM.
+var recordingRegexp = regexp.MustCompile(`^(Screen Recording|Screenshot|Screen Shot|Screencast from) (\d{4}-\d{2}-\d{2})(?: at)? (\d{1,2})[.:-](\d{2})[.:-](\d{2})(?:[ \x{202F}]?([AP]M))?(?: \((\d+)\))?$`)
+
+var recordingKinds = map[string]string{
+	"Screen Recording": "screen-recording",
+	"Screenshot":       "screenshot",
+	"Screen Shot":      "screenshot",
+	"Screencast from":  "screencast",
+}
+
+func getRecordingName(basePath string) (string, error) {
+	m := recordingRegexp.FindStringSubmatch(basePath)
+	if m == nil {
+		return "", fmt.Errorf("not a screenshot or screen recording name. name: %q", basePath)
+	}
+
+	date, err := time.Parse("2006-01-02", m[2])
+	if err != nil {
+		return "", fmt.Errorf("failed to parse date. err: %w", err)
+	}
+
+	hour, err := strconv.Atoi(m[3])
+	if err != nil {
+		return "", fmt.Errorf("failed to parse hour. err: %w", err)
+	}
+
+	switch {
+	case m[6] == "PM" && hour < 12:
+		hour += 12
+	case m[6] == "AM" && hour == 12:
+		hour = 0
+	}
+
+	parts := []string{date.Format(dateFormat3), fmt.Sprintf("%02d%s%s", hour, m[4], m[5]), recordingKinds[m[1]]}
+	if m[7] != "" {
+		parts = append(parts, m[7])
+	}
+
+	return strings.Join(parts, separator), nil
+}
+
+var (
+	silenceStartRegexp = regexp.MustCompile(`silence_start: (-?[\d.]+)`)
+	silenceEndRegexp   = regexp.MustCompile(`silence_end: (-?[\d.]+)`)
+)
+
+// parseSilenceBounds returns the start and end of the non-silent part of a video based on the output of ffmpeg's
+// silencedetect filter. Only silence touching the start or the end of the video is considered.
+func parseSilenceBounds(output string, length float64) (float64, float64) {
+	start, end := 0.0, length
+
+	var lastStart, lastEnd float64 = -1, -1
+	for _, line := range strings.Split(output, "\n") {
+		if m := silenceStartRegexp.FindStringSubmatch(line); m != nil {
+			lastStart, _ = strconv.ParseFloat(m[1], 64)
+			lastEnd = -1
+
+			continue
+		}
+
+		if m := silenceEndRegexp.FindStringSubmatch(line); m != nil {
+			lastEnd, _ = strconv.ParseFloat(m[1], 64)
+			if lastStart <= 0.01 && start == 0.0 {
+				start = lastEnd
+			}
+		}
+	}
+
+	if lastStart > start && (lastEnd < 0 || lastEnd >= length-0.01) {
+		end = lastStart
+	}
+
+	return start, end
+}
+
+func findSilenceBounds(st *state, fi os.FileInfo) (float64, float64, error) {
+	length, err := getLength(st, fi)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	output, err := exec(st, []string{"ffmpeg", "-hide_banner", "-nostats", "-i", fi.Name(), "-af", "silencedetect=noise=-50dB:d=0.5", "-f", "null", "-"})
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to detect silence. file: %q, err: %w", fi.Name(), err)
+	}
+
+	start, end := parseSilenceBounds(output, length)
+
+	return start, end, nil
+}
+
+func cleanupRecording(st *state, fi os.FileInfo, trimSilence, deleteOriginal, forceOverwrite, dryRun bool) error {
+	filePath := fi.Name()
+
+	basePath := filepath.Base(filePath)
+	ext := filepath.Ext(filePath)
+	if ext != "" {
+		basePath = basePath[:len(basePath)-len(ext)]
+	}
+
+	newBase, err := getRecordingName(basePath)
+	if err != nil {
+		return err
+	}
+
+	convert := strings.EqualFold(ext, ".mov") || trimSilence
+	if strings.EqualFold(ext, ".png") {
+		convert = false
+	}
+
+	if !convert {
+		newPath := filepath.Join(filepath.Dir(filePath), newBase+strings.ToLower(ext))
+
+		if err := checkRoot(st, filePath, newPath); err != nil {
+			return err
+		}
+
+		if dryRun {
+			planRename(st, filePath, newPath)
+
+			return nil
+		}
+
+		return safeRename(st, filePath, newPath, forceOverwrite)
+	}
+
+	newPath := filepath.Join(filepath.Dir(filePath), newBase+".mp4")
+
+	cmd := ffmpegCommand(forceOverwrite)
+
+	if trimSilence {
+		start, end, err := findSilenceBounds(st, fi)
+		if err != nil {
+			return err
+		}
+
+		st.log.Printf("file: %s, non-silent part: %.2f - %.2f", filePath, start, end)
+
+		cmd = append(cmd, "-ss", fmt.Sprintf("%.3f", start), "-to", fmt.Sprintf("%.3f", end))
+	}
+
+	cmd = append(cmd, inputKey, filePath, videoCodecKey, "copy", audioCodecKey, "aac", "-movflags", "+faststart", newPath)
+	cmd = showCommand(st, cmd)
+
+	if err := checkRoot(st, filePath, newPath); err != nil {
+		return err
+	}
+
+	if err := checkCollision(st, newPath, forceOverwrite); err != nil {
+		return err
+	}
+
+	if dryRun {
+		planEncode(st, filePath, newPath)
+
+		return nil
+	}
+
+	output, err := exec(st, cmd)
+	if err != nil {
+		st.log.Println(output)
+
+		return &EncodeError{Path: filePath, Operation: "convert recording", Err: err}
+	}
+
+	recordEncode(st, filePath, newPath)
+
+	if deleteOriginal {
+		// the original is moved into the trash so that it can be restored
+		return removeFile(st, filePath, false, false)
+	}
+
+	return nil
+}
+
+func (a App) cleanupRecording(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
+	trimSilence := c.Bool(trimSilenceFlag)
+	deleteOriginal := c.Bool(deleteOriginalFlag)
+	forceOverwrite := c.Bool(forceFlag)
+
+	return cleanupRecording(a.state, fi, trimSilence, deleteOriginal, forceOverwrite, dryRun)
+}
+
+const defaultOrganizeTemplate = "{{.Year}}/{{.Year}}-{{.Month}}"
+
+type organizeFields struct {
+	Year       string
+	Month      string
+	Day        string
+	Date       string
+	Codec      string
+	Resolution string
+	Width      int
+	Height     int
+	Ext        string
+}
+
+var dateRegexp3 = regexp.MustCompile(`\d{4}\.\d{2}\.\d{2}`)
+
+// getCreationTime returns the creation time stored in the container of a video, ffmpeg and most cameras store it in UTC
+func getCreationTime(st *state, fi os.FileInfo) (time.Time, error) {
+	cmd := []string{"ffprobe", "-v", "error", "-show_entries", "format_tags=creation_time", "-of", "default=noprint_wrappers=1:nokey=1", fi.Name()}
+
+	raw, err := probe(st, cmd)
+	if err != nil {
+		return time.Time{}, &ProbeError{Path: fi.Name(), Err: fmt.Errorf("command: %s, err: %w", quoteArgs(cmd), err)}
+	}
+
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return time.Time{}, &ProbeError{Path: fi.Name(), Err: errors.New("no creation time")}
+	}
+
+	creationTime, err := time.Parse(time.RFC3339Nano, raw)
+	if err != nil {
+		return time.Time{}, &ProbeError{Path: fi.Name(), Err: fmt.Errorf("invalid creation time. creation_time: %s, err: %w", raw, err)}
+	}
+
+	return creationTime, nil
+}
+
+// getMetadataDate returns the creation time of a video, falling back to the modification time of the file, in the
+// timezone set by the user so that footage shot in the evening is not dated to the next day
+func getMetadataDate(st *state, fi os.FileInfo) time.Time {
+	creationTime, err := getCreationTime(st, fi)
+	if err == nil {
+		return creationTime.In(st.dateLocation)
+	}
+
+	st.log.Printf("using modification time. file: %q, err: %s", fi.Name(), err)
+
+	return fi.ModTime().In(st.dateLocation)
+}
+
+// getFileDate returns the date found in the file name, falling back to the creation time of the video or the
+// modification time of the file
+func getFileDate(st *state, fi os.FileInfo) time.Time {
+	basePath := filepath.Base(fi.Name())
+
+	candidates := []struct {
+		r      *regexp.Regexp
+		format string
+	}{
+		{dateRegexp3, dateFormat3},
+		{dateRegexp1, dateFormat1},
+		{dateRegexp2, dateFormat2},
+	}
+	for _, c := range candidates {
+		matches := c.r.FindAllString(basePath, -1)
+		if len(matches) != 1 {
+			continue
+		}
+
+		parsedDate, err := time.Parse(c.format, matches[0])
+		if err == nil {
+			return parsedDate
+		}
+	}
+
+	return getMetadataDate(st, fi)
+}
+
+// createDirs creates dir and all of its missing parents, journaling each directory created
+func createDirs(st *state, dir string) error {
+	dir = filepath.Clean(dir)
+
+	_, err := os.Stat(dir)
+	if err == nil {
+		return nil
+	}
+
+	parent := filepath.Dir(dir)
+	if parent != dir {
+		err = createDirs(st, parent)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = os.Mkdir(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create directory. path: %q, err: %w", dir, err)
+	}
+
+	st.journal.Record(journalMkdir, "", dir)
+
+	return nil
+}
+
+func organize(st *state, fi os.FileInfo, pathTemplate string, forceOverwrite, dryRun bool) error {
+	filePath := fi.Name()
+
+	tpl, err := template.New("organize").Parse(pathTemplate)
+	if err != nil {
+		return fmt.Errorf("invalid template. template: %q, err: %w", pathTemplate, err)
+	}
+
+	date := getFileDate(st, fi)
+	fields := organizeFields{
+		Year:  date.Format("2006"),
+		Month: date.Format("01"),
+		Day:   date.Format("02"),
+		Date:  date.Format(dateFormat3),
+		Ext:   strings.TrimPrefix(strings.ToLower(filepath.Ext(filePath)), "."),
+	}
+
+	if strings.Contains(pathTemplate, ".Resolution") || strings.Contains(pathTemplate, ".Width") || strings.Contains(pathTemplate, ".Height") {
+		dimensions, err := getDimensions(st, fi)
+		if err != nil {
+			return err
+		}
+
+		fields.Width, fields.Height, err = parseDimensions(dimensions)
+		if err != nil {
+			return err
+		}
+
+		fields.Resolution = dimensions
+		if found, ok := wellKnown[dimensions]; ok {
+			fields.Resolution = found
+		}
+	}
+
+	if strings.Contains(pathTemplate, ".Codec") {
+		fields.Codec, err = getCodec(st, fi)
+		if err != nil {
+			return err
+		}
+	}
+
+	buf := &strings.Builder{}
+	err = tpl.Execute(buf, fields)
+	if err != nil {
+		return fmt.Errorf("failed to execute template. template: %q, err: %w", pathTemplate, err)
+	}
+
+	dir := filepath.Join(filepath.Dir(filePath), buf.String())
+	newPath := filepath.Join(dir, filepath.Base(filePath))
+
+	if err := checkRoot(st, filePath, newPath); err != nil {
+		return err
+	}
+
+	if dryRun {
+		planRename(st, filePath, newPath)
+
+		return nil
+	}
+
+	err = createDirs(st, dir)
+	if err != nil {
+		return err
+	}
+
+	return safeRename(st, filePath, newPath, forceOverwrite)
+}
+
+func (a App) organize(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
+	pathTemplate := c.String(templateFlag)
+	forceOverwrite := c.Bool(forceFlag)
+
+	return organize(a.state, fi, pathTemplate, forceOverwrite, dryRun)
+}
+
+// flatten moves the files found in the subdirectories of dir into dir, prefixing the file names with their relative
+// directory path, then removes the directories emptied
+func flatten(st *state, dir string, forceOverwrite, dryRun bool) error {
+	var (
+		pairs []renamePair
+		dirs  []string
+	)
+
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if rel != "." {
+				dirs = append(dirs, path)
+			}
+
+			return nil
+		}
+
+		relDir := filepath.Dir(rel)
+		if relDir == "." {
+			return nil
+		}
+
+		parts := strings.Split(filepath.ToSlash(relDir), "/")
+		newName := strings.Join(append(parts, d.Name()), separator)
+		pairs = append(pairs, renamePair{oldPath: path, newPath: filepath.Join(dir, newName)})
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to walk directory. dir: %q, err: %w", dir, err)
+	}
+
+	for _, pair := range pairs {
+		err = checkRoot(st, pair.oldPath, pair.newPath)
+		if err != nil {
+			return err
+		}
+
+		if dryRun {
+			planRename(st, pair.oldPath, pair.newPath)
+
+			continue
+		}
+
+		err = safeRename(st, pair.oldPath, pair.newPath, forceOverwrite)
+		if err != nil {
+			return err
+		}
+	}
+
+	if dryRun {
+		return nil
+	}
+
+	// deepest directories come last in walk order
+	for i := len(dirs) - 1; i >= 0; i-- {
+		entries, err := os.ReadDir(dirs[i])
+		if err != nil || len(entries) > 0 {
+			continue
+		}
+
+		err = os.Remove(dirs[i])
+		if err != nil {
+			st.log.Printf("failed to remove directory. path: %q, err: %s", dirs[i], err)
+
+			continue
+		}
+
+		st.log.Printf("directory removed: %q", dirs[i])
+		st.journal.Record(journalRmdir, dirs[i], "")
+	}
+
+	return nil
+}
+
+func (a App) flatten(c *cli.Context) error {
+	err := configure(a.state, c)
+	if err != nil {
+		return err
+	}
+	defer exportLogHistory(a.log, c.String(logHistoryFlag))
+
+	dirs := c.Args().Slice()
+	if len(dirs) == 0 {
+		dirs = []string{"."}
+	}
+
+	forceOverwrite := c.Bool(forceFlag)
+	dryRun := c.Bool(dryRunFlag)
+
+	for _, dir := range dirs {
+		err := flatten(a.state, dir, forceOverwrite, dryRun)
+		if err != nil {
+			a.log.Println(err)
+		}
+	}
+
+	return nil
+}
+
+// lastRun returns the id of the last run in the journal which changed anything and was not undone yet
+func lastRun(entries []journalEntry) string {
+	undone := map[string]struct{}{}
+	for _, entry := range entries {
+		if entry.Undoes != "" {
+			undone[entry.Undoes] = struct{}{}
+		}
+	}
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if entry.Command == undoCommand {
+			continue
+		}
+
+		if _, ok := undone[entry.Run]; !ok {
+			return entry.Run
+		}
+	}
+
+	return ""
+}
+
+func undo(st *state, journalPath string, dryRun bool) error {
+	entries, err := readJournal(journalPath)
+	if err != nil {
+		return err
+	}
+
+	run := lastRun(entries)
+	if run == "" {
+		return errors.New("nothing to undo")
+	}
+
+	return undoRun(st, entries, run, dryRun)
+}
+
+// undoRun reverts the changes recorded in the journal entries of a run, in reverse order
+func undoRun(st *state, entries []journalEntry, run string, dryRun bool) error {
+	st.log.Printf("undoing run: %s", run)
+	if st.journal != nil {
+		st.journal.undoes = run
+	}
+
+	// sidecars have their own journal entries
+	st.sidecarExtensions = nil
+
+	var err error
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if entry.Run != run {
+			continue
+		}
+
+		switch entry.Action {
+		case journalRename:
+			if dryRun {
+				st.log.Printf(`%q -> %q`, entry.To, entry.From)
+
+				continue
+			}
+
+			_, err = os.Stat(entry.To)
+			if err != nil {
+				st.log.Printf("file to restore is missing. path: %q, err: %s", entry.To, err)
+
+				continue
+			}
+
+			err = safeRename(st, entry.To, entry.From, false)
+			if err != nil {
+				return err
+			}
+		case journalLink, journalCopy, journalSymlink:
+			st.log.Printf("removing: %q", entry.To)
+
+			err = checkRoot(st, entry.To)
+			if err != nil {
+				return err
+			}
+
+			if dryRun {
+				continue
+			}
+
+			err = os.Remove(entry.To)
+			if err != nil {
+				return fmt.Errorf("failed to remove file. path: %q, err: %w", entry.To, err)
+			}
+
+			st.journal.RecordSymlink(journalUnlink, entry.To, entry.Target)
+		case journalUnlink:
+			st.log.Printf("restoring symbolic link: %q -> %q", entry.From, entry.Target)
+			if dryRun || entry.Target == "" {
+				continue
+			}
+
+			err = os.Symlink(entry.Target, entry.From)
+			if err != nil {
+				return fmt.Errorf("failed to restore symbolic link. path: %q, err: %w", entry.From, err)
+			}
+
+			st.journal.RecordSymlink(journalSymlink, entry.From, entry.Target)
+		case journalEncode, journalDelete:
+			st.log.Printf("can not be undone, skipping. action: %s, from: %q, to: %q", entry.Action, entry.From, entry.To)
+		case journalMkdir:
+			st.log.Printf("removing directory: %q", entry.To)
+
+			err = checkRoot(st, entry.To)
+			if err != nil {
+				return err
+			}
+
+			if dryRun {
+				continue
+			}
+
+			err = os.Remove(entry.To)
+			if err != nil {
+				st.log.Printf("failed to remove directory. path: %q, err: %s", entry.To, err)
+
+				continue
+			}
+
+			st.journal.Record(journalRmdir, entry.To, "")
+		}
+	}
+
+	return nil
+}
+
+// rollbackRun reverts the changes recorded in the journal by the current run, so that an atomic run failing part way
+// leaves the files in their original state
+func rollbackRun(st *state) error {
+	if st.journal == nil {
+		return errors.New("nothing to roll back, the journal is disabled")
+	}
+
+	entries, err := readJournal(st.journal.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	st.progress.Println(colorize(st, colorYellow, "rolling back the changes made by the run"))
+
+	err = undoRun(st, entries, st.journal.run, false)
+	if err != nil {
+		return fmt.Errorf("failed to roll back, some changes remain. err: %w", err)
+	}
+
+	return nil
+}
+
+const (
+	mappingCSV  = "csv"
+	mappingTSV  = "tsv"
+	mappingJSON = "json"
+)
+
+// mappingHeaders are the first columns of header rows in CSV and TSV mappings
+var mappingHeaders = map[string]bool{"old": true, "oldpath": true, "old path": true, "from": true, "source": true}
+
+// mappingFormat returns the format of a mapping file, derived from its extension unless set explicitly
+func mappingFormat(path, format string) (string, error) {
+	if format != "" {
+		switch format {
+		case mappingCSV, mappingTSV, mappingJSON:
+			return format, nil
+		}
+
+		return "", fmt.Errorf("invalid mapping format. format: %s", format)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return mappingCSV, nil
+	case ".tsv", ".tab":
+		return mappingTSV, nil
+	case ".json", ".jsonl", ".ndjson":
+		return mappingJSON, nil
+	}
+
+	return "", fmt.Errorf("unknown mapping format, use --%s. path: %q", mappingFormatFlag, path)
+}
+
+// readRenameMapping reads old and new paths from a CSV or TSV file with two columns and an optional header, or from a
+// JSON array or JSON lines of objects with oldPath and newPath, e.g. the output of a dry-run with --result json.
+// Entries without a new path, failed entries and entries not changing anything are skipped.
+func readRenameMapping(r io.Reader, format string) ([]renamePair, error) {
+	var pairs []renamePair
+	add := func(oldPath, newPath string) {
+		if newPath == "" || oldPath == newPath {
+			return
+		}
+
+		pairs = append(pairs, renamePair{oldPath: oldPath, newPath: newPath})
+	}
+
+	switch format {
+	case mappingCSV, mappingTSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		if format == mappingTSV {
+			cr.Comma = '\t'
+			cr.LazyQuotes = true
+		}
+
+		records, err := cr.ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read mapping. err: %w", err)
+		}
+
+		for i, record := range records {
+			if i == 0 && len(record) > 0 && mappingHeaders[strings.ToLower(strings.TrimSpace(record[0]))] {
+				continue
+			}
+
+			if len(record) < 2 {
+				return nil, fmt.Errorf("mapping needs an old and a new path. line: %d", i+1)
+			}
+
+			add(record[0], record[1])
+		}
+	case mappingJSON:
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read mapping. err: %w", err)
+		}
+
+		var results []fileResult
+		if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "[") {
+			err = json.Unmarshal(data, &results)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse mapping. err: %w", err)
+			}
+		} else {
+			dec := json.NewDecoder(strings.NewReader(trimmed))
+			for dec.More() {
+				var result fileResult
+				err = dec.Decode(&result)
+				if err != nil {
+					return nil, fmt.Errorf("failed to parse mapping. err: %w", err)
+				}
+				results = append(results, result)
+			}
+		}
+
+		for _, result := range results {
+			if result.Status == resultFailed {
+				continue
+			}
+
+			add(result.OldPath, result.NewPath)
+		}
+	default:
+		return nil, fmt.Errorf("invalid mapping format. format: %s", format)
+	}
+
+	return pairs, nil
+}
+
+// orderRenameMapping validates a mapping and orders it so that files are renamed away before others take their
+// names. Freed paths are the ones of files removed before the renames. All problems found are returned together so
+// that the mapping can be fixed at once.
+func orderRenameMapping(st *state, pairs []renamePair, freed map[string]bool, forceOverwrite bool) ([]renamePair, error) {
+	var errs []error
+
+	sources := map[string]int{}
+	targets := map[string]int{}
+	for i, pair := range pairs {
+		if _, ok := sources[pair.oldPath]; ok {
+			errs = append(errs, fmt.Errorf("file is renamed more than once. path: %q", pair.oldPath))
+		}
+		sources[pair.oldPath] = i
+
+		if _, ok := targets[pair.newPath]; ok {
+			errs = append(errs, fmt.Errorf("several files are renamed to the same path. path: %q", pair.newPath))
+		}
+		targets[pair.newPath] = i
+
+		if err := checkRoot(st, pair.oldPath, pair.newPath); err != nil {
+			errs = append(errs, err)
+		}
+
+		if _, err := os.Lstat(pair.oldPath); err != nil {
+			errs = append(errs, fmt.Errorf("file to rename is missing. path: %q", pair.oldPath))
+		}
+	}
+
+	for _, pair := range pairs {
+		if _, ok := sources[pair.newPath]; ok || freed[pair.newPath] || forceOverwrite {
+			continue
+		}
+
+		if _, err := os.Lstat(pair.newPath); err == nil {
+			errs = append(errs, &RenameCollision{Path: pair.newPath})
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	// a file can be renamed once the file having its new name is renamed
+	ordered := make([]renamePair, 0, len(pairs))
+	done := make([]bool, len(pairs))
+	for len(ordered) < len(pairs) {
+		progressed := false
+		for i, pair := range pairs {
+			if done[i] {
+				continue
+			}
+
+			if blocker, ok := sources[pair.newPath]; ok && !done[blocker] {
+				continue
+			}
+
+			ordered = append(ordered, pair)
+			done[i] = true
+			progressed = true
+		}
+
+		if !progressed {
+			for i, pair := range pairs {
+				if !done[i] {
+					errs = append(errs, fmt.Errorf("renames form a cycle, rename through a temporary name. path: %q", pair.oldPath))
+				}
+			}
+
+			return nil, errors.Join(errs...)
+		}
+	}
+
+	return ordered, nil
+}
+
+// applyRenames renames files in the order given, creating the missing directories
+func applyRenames(st *state, pairs []renamePair, forceOverwrite, dryRun bool) error {
+	for _, pair := range pairs {
+		if dryRun {
+			planRename(st, pair.oldPath, pair.newPath)
+
+			continue
+		}
+
+		err := createDirs(st, filepath.Dir(pair.newPath))
+		if err != nil {
+			return err
+		}
+
+		err = safeRename(st, pair.oldPath, pair.newPath, forceOverwrite)
+		if err != nil {
+			return fmt.Errorf("failed to rename, the renames done can be reverted with %s. old path: %q, new path: %q, err: %w", undoCommand, pair.oldPath, pair.newPath, err)
+		}
+	}
+
+	return nil
+}
+
+// renameFromFile renames files according to a mapping file, after validating all of the mapping. Paths are relative
+// to the working directory.
+func renameFromFile(st *state, mappingPath, format string, forceOverwrite, dryRun bool) error {
+	format, err := mappingFormat(mappingPath, format)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(mappingPath)
+	if err != nil {
+		return fmt.Errorf("failed to open mapping. path: %q, err: %w", mappingPath, err)
+	}
+	defer f.Close()
+
+	pairs, err := readRenameMapping(f, format)
+	if err != nil {
+		return err
+	}
+
+	pairs, err = orderRenameMapping(st, pairs, nil, forceOverwrite)
+	if err != nil {
+		return err
+	}
+
+	err = applyRenames(st, pairs, forceOverwrite, dryRun)
+	if err != nil {
+		return err
+	}
+
+	if dryRun {
+		st.progress.Printf("%d rename(s) planned.", len(pairs))
+
+		return nil
+	}
+
+	st.progress.Printf("%d file(s) renamed.", len(pairs))
+
+	return nil
+}
+
+const (
+	mirrorByIndex    = "index"
+	mirrorByDuration = "duration"
+	mirrorByName     = "name"
+)
+
+// defaultDurationTolerance is the maximum difference in seconds between the lengths of matching files
+const defaultDurationTolerance = 1.0
+
+type mirrorCandidate struct {
+	reference, target int
+	score             float64
+}
+
+// matchGreedily pairs reference and target files starting with the best scoring candidates, each file is used once.
+// The returned slice contains the index of the matching reference file for each target file, or -1.
+func matchGreedily(candidates []mirrorCandidate, targetCount int) []int {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
+
+	matches := make([]int, targetCount)
+	for i := range matches {
+		matches[i] = -1
+	}
+
+	used := map[int]bool{}
+	for _, candidate := range candidates {
+		if used[candidate.reference] || matches[candidate.target] >= 0 {
+			continue
+		}
+
+		matches[candidate.target] = candidate.reference
+		used[candidate.reference] = true
+	}
+
+	return matches
+}
+
+// matchMirrorFiles returns the index of the matching reference file for each target file, or -1 if there is none.
+// Files are matched by their position in natural order, by their length or by the similarity of their names.
+func matchMirrorFiles(st *state, reference, target []os.FileInfo, by string, threshold, tolerance float64) ([]int, error) {
+	switch by {
+	case mirrorByIndex, "":
+		if len(reference) != len(target) {
+			return nil, fmt.Errorf("directories have a different number of files, match by %s or %s instead. reference: %d, target: %d", mirrorByName, mirrorByDuration, len(reference), len(target))
+		}
+
+		for _, list := range [][]os.FileInfo{reference, target} {
+			err := sortFileInfoList(list, sortName)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		matches := make([]int, len(target))
+		for i := range matches {
+			matches[i] = i
+		}
+
+		return matches, nil
+	case mirrorByDuration:
+		if tolerance < 0 {
+			return nil, fmt.Errorf("invalid tolerance, it must not be negative. tolerance: %v", tolerance)
+		}
+
+		lengths := make([]float64, len(reference))
+		for i, fi := range reference {
+			length, err := getLength(st, fi)
+			if err != nil {
+				return nil, err
+			}
+			lengths[i] = length
+		}
+
+		var candidates []mirrorCandidate
+		for j, fi := range target {
+			length, err := getLength(st, fi)
+			if err != nil {
+				return nil, err
+			}
+
+			for i := range reference {
+				diff := math.Abs(lengths[i] - length)
+				if diff <= tolerance {
+					candidates = append(candidates, mirrorCandidate{reference: i, target: j, score: -diff})
+				}
+			}
+		}
+
+		return matchGreedily(candidates, len(target)), nil
+	case mirrorByName:
+		if threshold <= 0 || threshold > 1 {
+			return nil, fmt.Errorf("invalid threshold, it must be between 0 and 1. threshold: %v", threshold)
+		}
+
+		var candidates []mirrorCandidate
+		for j, fi := range target {
+			targetTokens := nameTokens(fi.Name())
+			for i, ref := range reference {
+				similarity := nameSimilarity(nameTokens(ref.Name()), targetTokens)
+				if similarity >= threshold {
+					candidates = append(candidates, mirrorCandidate{reference: i, target: j, score: similarity})
+				}
+			}
+		}
+
+		return matchGreedily(candidates, len(target)), nil
+	}
+
+	return nil, fmt.Errorf("invalid match. match: %s", by)
+}
+
+// mirrorPairs returns the renames giving the target files the names of their matching reference files, keeping their
+// own directories and extensions. Target files without a match are logged and left alone.
+func mirrorPairs(l *logger, reference, target []os.FileInfo, matches []int) []renamePair {
+	var pairs []renamePair
+	for j, fi := range target {
+		if matches[j] < 0 {
+			l.Printf("no matching reference file found: %q", fi.Name())
+
+			continue
+		}
+
+		refBase := filepath.Base(reference[matches[j]].Name())
+		refBase = strings.TrimSuffix(refBase, filepath.Ext(refBase))
+
+		newPath := filepath.Join(filepath.Dir(fi.Name()), refBase+filepath.Ext(fi.Name()))
+		if newPath == fi.Name() {
+			continue
+		}
+
+		pairs = append(pairs, renamePair{oldPath: fi.Name(), newPath: newPath})
+	}
+
+	return pairs
+}
+
+// mirrorNames renames the files of the target directory to match the names of the corresponding files in the
+// reference directory, e.g. to keep subtitles or other renditions in sync with the videos
+func mirrorNames(st *state, referenceDir, targetDir string, targetExtensions []string, by string, threshold, tolerance float64, forceOverwrite, dryRun bool) error {
+	reference, err := getDirFileInfoList(st, referenceDir)
+	if err != nil {
+		return fmt.Errorf("failed to list reference directory. dir: %q, err: %w", referenceDir, err)
+	}
+
+	var target []os.FileInfo
+	if len(targetExtensions) > 0 {
+		target, err = listDirFiles(st, targetDir, targetExtensions)
+	} else {
+		target, err = getDirFileInfoList(st, targetDir)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to list target directory. dir: %q, err: %w", targetDir, err)
+	}
+
+	matches, err := matchMirrorFiles(st, reference, target, by, threshold, tolerance)
+	if err != nil {
+		return err
+	}
+
+	pairs, err := orderRenameMapping(st, mirrorPairs(st.log, reference, target, matches), nil, forceOverwrite)
+	if err != nil {
+		return err
+	}
+
+	err = applyRenames(st, pairs, forceOverwrite, dryRun)
+	if err != nil {
+		return err
+	}
+
+	if dryRun {
+		st.progress.Printf("%d rename(s) planned.", len(pairs))
+
+		return nil
+	}
+
+	st.progress.Printf("%d file(s) renamed.", len(pairs))
+
+	return nil
+}
+
+var subtitleExtensions = []string{"srt", "ass", "ssa", "vtt", "sub"}
+
+// maxSubtitleOverrun is the number of seconds subtitles may last longer than the video they belong to
+const maxSubtitleOverrun = 5.0
+
+var (
+	subtitleLanguageRegexp  = regexp.MustCompile(`(\.[a-z]{2,3}(?:[-_][A-Za-z]{2,4})?)?(\.(?i:forced|sdh|cc))?$`)
+	subtitleTimestampRegexp = regexp.MustCompile(`(\d{1,2}):(\d{2}):(\d{2})[,.](\d{1,3})`)
+	seasonEpisodeRegexp     = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])s(\d{1,2})[ ._-]?e(\d{1,3})(?:[^0-9]|$)`)
+	crossEpisodeRegexp      = regexp.MustCompile(`(?i)(?:^|[^0-9])(\d{1,2})x(\d{2,3})(?:[^0-9]|$)`)
+	episodeRegexp           = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])(?:e|ep|episode)[ ._-]?(\d{1,3})(?:[^0-9]|$)`)
+)
+
+// splitSubtitleName splits the base name of a subtitle file into the name and the language suffix, e.g.
+// "foo.en.forced" into "foo" and ".en.forced". Suffixes which can not be told apart from the name are kept in the name.
+func splitSubtitleName(base string) (string, string) {
+	match := subtitleLanguageRegexp.FindStringSubmatchIndex(base)
+	if match[0] == 0 {
+		return base, ""
+	}
+
+	return base[:match[0]], base[match[0]:]
+}
+
+type episode struct {
+	// title is the part of the name before the episode, usually the name of the show
+	title          string
+	season, number int
+}
+
+// findEpisode extracts the season and the episode number of a file name, e.g. S01E02, 1x02 or Episode 2. Season is -1
+// if only the episode number is found.
+func findEpisode(name string) (episode, bool) {
+	for _, re := range []*regexp.Regexp{seasonEpisodeRegexp, crossEpisodeRegexp} {
+		if match := re.FindStringSubmatchIndex(name); match != nil {
+			season, _ := strconv.Atoi(name[match[2]:match[3]])
+			number, _ := strconv.Atoi(name[match[4]:match[5]])
+
+			return episode{title: name[:match[0]], season: season, number: number}, true
+		}
+	}
+
+	if match := episodeRegexp.FindStringSubmatchIndex(name); match != nil {
+		number, _ := strconv.Atoi(name[match[2]:match[3]])
+
+		return episode{title: name[:match[0]], season: -1, number: number}, true
+	}
+
+	return episode{}, false
+}
+
+// sameEpisode compares episodes, ignoring the season if either of them is unknown
+func sameEpisode(a, b episode) bool {
+	if a.number != b.number {
+		return false
+	}
+
+	return a.season < 0 || b.season < 0 || a.season == b.season
+}
+
+// getSubtitleEnd returns the time in seconds the last subtitle of a SubRip, ASS or WebVTT file ends
+func getSubtitleEnd(filePath string) (float64, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read subtitles. path: %q, err: %w", filePath, err)
+	}
+
+	end := 0.0
+	for _, match := range subtitleTimestampRegexp.FindAllStringSubmatch(string(data), -1) {
+		hours, _ := strconv.Atoi(match[1])
+		minutes, _ := strconv.Atoi(match[2])
+		seconds, _ := strconv.Atoi(match[3])
+		fraction, _ := strconv.ParseFloat("0."+match[4], 64)
+
+		t := float64(hours*3600+minutes*60+seconds) + fraction
+		if t > end {
+			end = t
+		}
+	}
+
+	return end, nil
+}
+
+// subtitleMatch scores how likely a subtitle file belongs to a video, the second return value is false if it surely
+// does not. Matching episodes of similarly named shows or similar names are required, a subtitle ending well after
+// the video is rejected.
+func subtitleMatch(subName, videoName string, subEnd, videoLength, threshold float64) (float64, bool) {
+	score := nameSimilarity(nameTokens(subName), nameTokens(videoName))
+
+	subEpisode, subOk := findEpisode(filepath.Base(subName))
+	videoEpisode, videoOk := findEpisode(filepath.Base(videoName))
+	switch {
+	case subOk && videoOk:
+		if !sameEpisode(subEpisode, videoEpisode) {
+			return 0, false
+		}
+
+		subTitle, videoTitle := nameTokens(subEpisode.title), nameTokens(videoEpisode.title)
+		if len(subTitle) > 0 && len(videoTitle) > 0 && nameSimilarity(subTitle, videoTitle) < threshold {
+			return 0, false
+		}
+
+		score++
+	case score < threshold:
+		return 0, false
+	}
+
+	if subEnd > 0 && videoLength > 0 {
+		if subEnd > videoLength+maxSubtitleOverrun {
+			return 0, false
+		}
+
+		// subtitles usually last until the end of the video, closeness only breaks ties
+		score += 0.1 * subEnd / videoLength
+	}
+
+	return score, true
+}
+
+// findSubtitleMatches pairs the orphaned subtitles of a directory, the ones not named after a video, with the videos
+// and returns the renames naming them after their videos, keeping their language suffixes. Each video gets one
+// subtitle file per language suffix.
+func findSubtitleMatches(st *state, videos, subtitles []os.FileInfo, threshold float64, useDuration bool) []renamePair {
+	baseName := func(filePath string) string {
+		return strings.TrimSuffix(filePath, filepath.Ext(filePath))
+	}
+
+	videoBases := map[string]bool{}
+	for _, fi := range videos {
+		videoBases[baseName(fi.Name())] = true
+	}
+
+	// taken holds the video and language suffix combinations which already have subtitles
+	taken := map[string]bool{}
+	var orphans []os.FileInfo
+	var suffixes []string
+	for _, fi := range subtitles {
+		name, suffix := splitSubtitleName(baseName(fi.Name()))
+		if videoBases[name] {
+			taken[name+strings.ToLower(suffix)] = true
+
+			continue
+		}
+
+		orphans = append(orphans, fi)
+		suffixes = append(suffixes, suffix)
+	}
+
+	lengths := make([]float64, len(videos))
+	if useDuration && len(orphans) > 0 {
+		for i, fi := range videos {
+			length, err := getLength(st, fi)
+			if err != nil {
+				st.log.Printf("failed to get length, matching without it. file: %q, err: %v", fi.Name(), err)
+
+				continue
+			}
+			lengths[i] = length
+		}
+	}
+
+	var candidates []mirrorCandidate
+	for j, fi := range orphans {
+		subEnd := 0.0
+		if useDuration {
+			end, err := getSubtitleEnd(fi.Name())
+			if err != nil {
+				st.log.Println(err)
+			}
+			subEnd = end
+		}
+
+		subName, _ := splitSubtitleName(baseName(fi.Name()))
+		for i, video := range videos {
+			score, ok := subtitleMatch(subName, video.Name(), subEnd, lengths[i], threshold)
+			if ok {
+				candidates = append(candidates, mirrorCandidate{reference: i, target: j, score: score})
+			}
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
+
+	var pairs []renamePair
+	done := map[int]bool{}
+	for _, candidate := range candidates {
+		videoBase := baseName(videos[candidate.reference].Name())
+		key := videoBase + strings.ToLower(suffixes[candidate.target])
+		if done[candidate.target] || taken[key] {
+			continue
+		}
+
+		sub := orphans[candidate.target].Name()
+		pairs = append(pairs, renamePair{oldPath: sub, newPath: videoBase + suffixes[candidate.target] + filepath.Ext(sub)})
+		done[candidate.target] = true
+		taken[key] = true
+	}
+
+	for j, fi := range orphans {
+		if !done[j] {
+			st.log.Printf("no matching video found: %q", fi.Name())
+		}
+	}
+
+	return pairs
+}
+
+// matchSubs renames the orphaned subtitles of a directory after the videos they belong to
+func matchSubs(st *state, dir string, threshold float64, useDuration, forceOverwrite, dryRun bool) (int, error) {
+	if threshold <= 0 || threshold > 1 {
+		return 0, fmt.Errorf("invalid threshold, it must be between 0 and 1. threshold: %v", threshold)
+	}
+
+	videos, err := getDirFileInfoList(st, dir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list videos. dir: %q, err: %w", dir, err)
+	}
+
+	subtitles, err := listDirFiles(st, dir, subtitleExtensions)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list subtitles. dir: %q, err: %w", dir, err)
+	}
+
+	pairs, err := orderRenameMapping(st, findSubtitleMatches(st, videos, subtitles, threshold, useDuration), nil, forceOverwrite)
+	if err != nil {
+		return 0, err
+	}
+
+	err = applyRenames(st, pairs, forceOverwrite, dryRun)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(pairs), nil
+}
+
+func (a App) matchSubs(c *cli.Context) error {
+	err := configure(a.state, c)
+	if err != nil {
+		return err
+	}
+	defer exportLogHistory(a.log, c.String(logHistoryFlag))
+
+	dirs := c.Args().Slice()
+	if len(dirs) == 0 {
+		dirs = []string{"."}
+	}
+
+	dryRun := c.Bool(dryRunFlag)
+
+	count := 0
+	for _, dir := range dirs {
+		n, err := matchSubs(a.state, dir, c.Float64(similarityFlag), !c.Bool(noDurationFlag), c.Bool(forceFlag), dryRun)
+		if err != nil {
+			a.log.Println(err)
+		}
+		count += n
+	}
+
+	if dryRun {
+		a.progress.Printf("%d rename(s) planned.", count)
+
+		if a.resultFormat == "" {
+			previewChanges(a.changes, getMaxNameLength(c))
+		}
+
+		return nil
+	}
+
+	a.progress.Printf("%d subtitle file(s) renamed.", count)
+
+	return nil
+}
+
+// trashDirName is the directory files removed by edit are moved into, next to the files
+const trashDirName = ".ffr-trash"
+
+// editHeader explains the format of the file list opened by edit
+const editHeader = `# Edit the paths to rename the files, empty a path to remove the file, keep the numbers.
+# Removed files are moved into ` + trashDirName + ` unless --permanent is set. Lines starting with # are ignored.
+`
+
+var editLineRegexp = regexp.MustCompile(`^(\d+)(?:\t(.*))?$`)
+
+// runEditor opens a file in the editor of the user, $VISUAL or $EDITOR, falling back to vi
+var runEditor = func(path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	args := append(strings.Fields(editor), path)
+
+	cmd := osexec.Command(args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
+// parseEditList returns the renames and the removals of an edited file list, lines not mentioned are kept as they are
+func parseEditList(r io.Reader, filePaths []string) ([]renamePair, []string, error) {
+	var (
+		pairs    []renamePair
+		removals []string
+		seen     = map[int]bool{}
+	)
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		match := editLineRegexp.FindStringSubmatch(line)
+		if match == nil {
+			return nil, nil, fmt.Errorf("invalid line, expected a number, a tab and a path. line: %d", n)
+		}
+
+		i, err := strconv.Atoi(match[1])
+		if err != nil || i < 1 || i > len(filePaths) {
+			return nil, nil, fmt.Errorf("unknown file number. line: %d, number: %s", n, match[1])
+		}
+		if seen[i] {
+			return nil, nil, fmt.Errorf("file number is used more than once. line: %d, number: %d", n, i)
+		}
+		seen[i] = true
+
+		oldPath, newPath := filePaths[i-1], match[2]
+		switch {
+		case newPath == "":
+			removals = append(removals, oldPath)
+		case newPath != oldPath:
+			pairs = append(pairs, renamePair{oldPath: oldPath, newPath: newPath})
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read file list. err: %w", err)
+	}
+
+	return pairs, removals, nil
+}
+
+// trashPath returns a free path for filePath in the trash directory next to it
+func trashPath(st *state, filePath string) string {
+	dir := filepath.Join(filepath.Dir(filePath), trashDirName)
+	base := filepath.Base(filePath)
+	ext := filepath.Ext(base)
+
+	candidate := filepath.Join(dir, base)
+	for i := 1; ; i++ {
+		if !pathExists(st, candidate) {
+			return candidate
+		}
+
+		candidate = filepath.Join(dir, fmt.Sprintf("%s-%d%s", strings.TrimSuffix(base, ext), i, ext))
+	}
+}
+
+// removeFile moves a file into the trash so that the removal can be undone, or deletes it if permanent is set
+func removeFile(st *state, filePath string, permanent, dryRun bool) error {
+	err := checkRoot(st, filePath)
+	if err != nil {
+		return err
+	}
+
+	if !permanent {
+		newPath := trashPath(st, filePath)
+		if dryRun {
+			planRename(st, filePath, newPath)
+
+			return nil
+		}
+
+		err = createDirs(st, filepath.Dir(newPath))
+		if err != nil {
+			return err
+		}
+
+		return safeRename(st, filePath, newPath, false)
+	}
+
+	st.log.Printf("deleting: %q", filePath)
+	if dryRun {
+		return nil
+	}
+
+	err = os.Remove(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to delete file. path: %q, err: %w", filePath, err)
+	}
+
+	st.journal.Record(journalDelete, filePath, "")
+
+	return nil
+}
+
+// edit opens the list of files in an editor and applies the renames and removals made, vidir-style. Everything is
+// validated before changing any of the files.
+func edit(st *state, fileList []os.FileInfo, permanent, forceOverwrite, dryRun bool) error {
+	filePaths := make([]string, 0, len(fileList))
+	for _, fi := range fileList {
+		filePaths = append(filePaths, fi.Name())
+	}
+
+	f, err := st.workspace.CreateTemp("", "edit-*.txt")
+	if err != nil {
+		return fmt.Errorf("failed to create file list. err: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	sb := strings.Builder{}
+	sb.WriteString(editHeader)
+	for i, filePath := range filePaths {
+		sb.WriteString(fmt.Sprintf("%d\t%s\n", i+1, filePath))
+	}
+
+	_, err = f.WriteString(sb.String())
+	if err != nil {
+		f.Close()
+
+		return fmt.Errorf("failed to write file list. path: %q, err: %w", f.Name(), err)
+	}
+	f.Close()
+
+	err = runEditor(f.Name())
+	if err != nil {
+		return fmt.Errorf("editor failed. err: %w", err)
+	}
+
+	edited, err := os.Open(f.Name())
+	if err != nil {
+		return fmt.Errorf("failed to read file list. path: %q, err: %w", f.Name(), err)
+	}
+	defer edited.Close()
+
+	pairs, removals, err := parseEditList(edited, filePaths)
+	if err != nil {
+		return err
+	}
+
+	freed := map[string]bool{}
+	for _, filePath := range removals {
+		freed[filePath] = true
+	}
+
+	pairs, err = orderRenameMapping(st, pairs, freed, forceOverwrite)
+	if err != nil {
+		return err
+	}
+
+	for _, filePath := range removals {
+		err = removeFile(st, filePath, permanent, dryRun)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = applyRenames(st, pairs, forceOverwrite, dryRun)
+	if err != nil {
+		return err
+	}
+
+	st.progress.Printf("%d file(s) renamed, %d file(s) removed.", len(pairs), len(removals))
+
+	return nil
+}
+
+func (a App) edit(c *cli.Context, args []string, fileList []os.FileInfo, dryRun bool) error {
+	permanent := c.Bool(permanentFlag)
+	forceOverwrite := c.Bool(forceFlag)
+
+	return edit(a.state, fileList, permanent, forceOverwrite, dryRun)
+}
+
+type statsGroup struct {
+	Name   string  `json:"name"`
+	Count  int     `json:"count"`
+	Size   int64   `json:"size"`
+	Length float64 `json:"length"`
+}
+
+type libraryStats struct {
+	Count          int          `json:"count"`
+	Size           int64        `json:"size"`
+	Length         float64      `json:"length"`
+	AverageBitRate int64        `json:"averageBitRate"`
+	Codecs         []statsGroup `json:"codecs"`
+	Resolutions    []statsGroup `json:"resolutions"`
+	Containers     []statsGroup `json:"containers"`
+}
+
+// getResolutionBucket returns the name of the smallest dimension preset the given height fits in
+func getResolutionBucket(height int64) string {
+	switch {
+	case height <= 0:
+		return "unknown"
+	case height <= sdHeight:
+		return sdPreset
+	case height <= hdHeight:
+		return hdPreset
+	case height <= fullHDHeight:
+		return fullHDPreset
+	case height <= qHDHeight:
+		return qHDPreset
+	case height <= fourKHeight:
+		return fourKPreset
+	}
+
+	return eightKPreset
+}
+
+func addToStatsGroups(groups []statsGroup, name string, v videoType) []statsGroup {
+	if name == "" {
+		name = "unknown"
+	}
+
+	for i := range groups {
+		if groups[i].Name == name {
+			groups[i].Count++
+			groups[i].Size += v.size
+			groups[i].Length += v.length
+
+			return groups
+		}
+	}
+
+	return append(groups, statsGroup{Name: name, Count: 1, Size: v.size, Length: v.length})
+}
+
+func computeStats(vs videoTypes) libraryStats {
+	s := libraryStats{}
+
+	var bits, bitLength float64
+	for _, v := range vs {
+		s.Count++
+		s.Size += v.size
+		s.Length += v.length
+
+		if v.bitRate > 0 && v.length > 0 {
+			bits += float64(v.bitRate) * v.length
+			bitLength += v.length
+		}
+
+		container := strings.TrimPrefix(strings.ToLower(filepath.Ext(v.name)), ".")
+
+		s.Codecs = addToStatsGroups(s.Codecs, v.codec, v)
+		s.Resolutions = addToStatsGroups(s.Resolutions, getResolutionBucket(v.height), v)
+		s.Containers = addToStatsGroups(s.Containers, container, v)
+	}
+
+	if bitLength > 0 {
+		s.AverageBitRate = int64(bits / bitLength)
+	}
+
+	for _, groups := range [][]statsGroup{s.Codecs, s.Resolutions, s.Containers} {
+		sort.SliceStable(groups, func(i, j int) bool {
+			return groups[i].Size > groups[j].Size
+		})
+	}
+
+	return s
+}
+
+func (s libraryStats) Print(st *state) {
+	t := tabby.New()
+	t.AddHeader("GROUP", "NAME", "COUNT", "SIZE", "LENGTH")
+
+	groups := []struct {
+		name   string
+		groups []statsGroup
+	}{
+		{"codec", s.Codecs},
+		{"resolution", s.Resolutions},
+		{"container", s.Containers},
+	}
+	for _, g := range groups {
+		for _, sg := range g.groups {
+			t.AddLine(g.name, sg.Name, sg.Count, formatUnits(st, sg.Size, " ", "B"), formatDuration(st, sg.Length))
+		}
+	}
+	t.AddLine("total", "", s.Count, formatUnits(st, s.Size, " ", "B"), formatDuration(st, s.Length))
+
+	t.Print()
+
+	fmt.Printf("\naverage bit rate: %s\n", formatUnits(st, s.AverageBitRate, " ", "bit"))
+}
+
+func stats(st *state, fileList []os.FileInfo, format string) error {
+	v := videoTypes{}
+	for _, fi := range fileList {
+		v = append(v, info(st, fi, true))
+	}
+
+	s := computeStats(v)
+
+	switch format {
+	case formatJSON:
+		data, err := json.MarshalIndent(s, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(string(data))
+	case formatTable, "":
+		s.Print(st)
+	default:
+		return fmt.Errorf("invalid format. format: %s", format)
+	}
+
+	return nil
+}
+
+func (a App) stats(c *cli.Context, args []string, fileList []os.FileInfo, dryRun bool) error {
+	format := c.String(formatFlag)
+
+	return stats(a.state, fileList, format)
+}
+
+const (
+	efficiencyUnknown = "unknown"
+	efficiencyLow     = "low"
+	efficiencyOk      = "ok"
+	efficiencyHigh    = "high"
+)
+
+type bitsPerPixelRange struct {
+	low  float64
+	high float64
+}
+
+// typicalBitsPerPixel contains the usual bits per pixel per frame ranges of codecs, used to find outliers
+var typicalBitsPerPixel = map[string]bitsPerPixelRange{
+	codecH264:    {low: 0.05, high: 0.2},
+	codecH265:    {low: 0.025, high: 0.1},
+	"vp9":        {low: 0.025, high: 0.1},
+	"av1":        {low: 0.02, high: 0.08},
+	"mpeg4":      {low: 0.08, high: 0.3},
+	"mpeg2video": {low: 0.15, high: 0.5},
+}
+
+func bitsPerPixel(v videoType) float64 {
+	if v.width <= 0 || v.height <= 0 || v.frameRate <= 0 {
+		return 0
+	}
+
+	return float64(v.bitRate) / (float64(v.width*v.height) * v.frameRate)
+}
+
+func rateEfficiency(codec string, bpp float64) string {
+	r, ok := typicalBitsPerPixel[codec]
+	if !ok || bpp <= 0 {
+		return efficiencyUnknown
+	}
+
+	switch {
+	case bpp < r.low:
+		return efficiencyLow
+	case bpp > r.high:
+		return efficiencyHigh
+	}
+
+	return efficiencyOk
+}
+
+type efficiencyResult struct {
+	Name         string  `json:"name"`
+	Codec        string  `json:"codec"`
+	Width        int64   `json:"width"`
+	Height       int64   `json:"height"`
+	FrameRate    float64 `json:"frameRate"`
+	BitRate      int64   `json:"bitRate"`
+	BitsPerPixel float64 `json:"bitsPerPixel"`
+	Verdict      string  `json:"verdict"`
+}
+
+func efficiency(st *state, fileList []os.FileInfo, format string) error {
+	var results []efficiencyResult
+	for _, fi := range fileList {
+		v := info(st, fi, true)
+		bpp := bitsPerPixel(v)
+
+		results = append(results, efficiencyResult{
+			Name:         v.name,
+			Codec:        v.codec,
+			Width:        v.width,
+			Height:       v.height,
+			FrameRate:    v.frameRate,
+			BitRate:      v.bitRate,
+			BitsPerPixel: bpp,
+			Verdict:      rateEfficiency(v.codec, bpp),
+		})
+	}
+
+	switch format {
+	case formatJSON:
+		data, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(string(data))
+	case formatTable, "":
+		t := tabby.New()
+		t.AddHeader("FILE", "CODEC", "WIDTH", "HEIGHT", "FRAMERATE", "BITRATE", "BPP", "VERDICT")
+		for _, r := range results {
+			t.AddLine(r.Name, r.Codec, r.Width, r.Height, float64(int(r.FrameRate*10))/10, formatUnits(st, r.BitRate, " ", "bit"), fmt.Sprintf("%.3f", r.BitsPerPixel), r.Verdict)
+		}
+		t.Print()
+	default:
+		return fmt.Errorf("invalid format. format: %s", format)
+	}
+
+	return nil
+}
+
+func (a App) efficiency(c *cli.Context, args []string, fileList []os.FileInfo, dryRun bool) error {
+	format := c.String(formatFlag)
+
+	return efficiency(a.state, fileList, format)
+}
+
+// hashAlgorithms are the hash algorithms of the streamhash and framehash muxers of ffmpeg offered
+var hashAlgorithms = []string{"md5", "sha160", "sha256", "sha512", "crc32", "adler32", "murmur3"}
+
+const defaultHashAlgorithm = "md5"
+
+type streamHash struct {
+	File   string `json:"file"`
+	Stream int    `json:"stream"`
+	Type   string `json:"type"`
+	Hash   string `json:"hash"`
+}
+
+// streamHashArgs returns the ffmpeg command hashing the decoded video and audio streams of a file with a muxer
+func streamHashArgs(filePath, muxer, algorithm string) []string {
+	return []string{"ffmpeg", "-v", "error", inputKey, filePath, "-map", "0:v?", "-map", "0:a?", fpsModeKey, "passthrough", "-f", muxer, "-hash", algorithm, "-"}
+}
+
+// getStreamHashes hashes the decoded video and audio streams of a file, so that the hashes only change if the content
+// changes, not if it is remuxed into another container
+func getStreamHashes(st *state, filePath, algorithm string) ([]streamHash, error) {
+	output, err := exec(st, streamHashArgs(filePath, "streamhash", algorithm))
+	if err != nil {
+		return nil, &EncodeError{Path: filePath, Operation: "hash streams", Err: err}
+	}
+
+	var hashes []streamHash
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Split(strings.TrimSpace(line), ",")
+		if len(fields) != 3 {
+			continue
+		}
+
+		index, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+
+		_, hash, _ := strings.Cut(fields[2], "=")
+		hashes = append(hashes, streamHash{File: filePath, Stream: index, Type: fields[1], Hash: hash})
+	}
+
+	if len(hashes) == 0 {
+		return nil, fmt.Errorf("no video or audio stream to hash. path: %q", filePath)
+	}
+
+	return hashes, nil
+}
+
+// getFrameHashes hashes each decoded frame of the video and audio streams of a file, by stream index
+func getFrameHashes(st *state, filePath, algorithm string) (map[int][]string, error) {
+	output, err := exec(st, streamHashArgs(filePath, "framehash", algorithm))
+	if err != nil {
+		return nil, &EncodeError{Path: filePath, Operation: "hash frames", Err: err}
+	}
+
+	hashes := map[int][]string{}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, ",")
+		index, err := strconv.Atoi(strings.TrimSpace(fields[0]))
+		if err != nil {
+			continue
+		}
+
+		hashes[index] = append(hashes[index], strings.TrimSpace(fields[len(fields)-1]))
+	}
+
+	return hashes, nil
+}
+
+// firstFrameDifference returns the index of the first differing frame of a stream of two files, -1 if all frames match
+func firstFrameDifference(a, b []string) int {
+	for i := range a {
+		if i >= len(b) || a[i] != b[i] {
+			return i
+		}
+	}
+
+	if len(b) > len(a) {
+		return len(a)
+	}
+
+	return -1
+}
+
+// compareStreamHashes compares the stream hashes of files with the ones of the first file. If frames is set, the
+// first differing frame of each differing stream is looked up.
+func compareStreamHashes(st *state, hashes [][]streamHash, algorithm string, frames bool) error {
+	var errs []error
+	reference := hashes[0]
+	for _, other := range hashes[1:] {
+		if len(other) != len(reference) {
+			errs = append(errs, fmt.Errorf("files have a different number of streams. file: %q, streams: %d, other: %q, streams: %d", reference[0].File, len(reference), other[0].File, len(other)))
+
+			continue
+		}
+
+		var refFrames, otherFrames map[int][]string
+		for i := range reference {
+			if other[i].Hash == reference[i].Hash {
+				continue
+			}
+
+			if !frames {
+				errs = append(errs, fmt.Errorf("stream differs. file: %q, other: %q, stream: %d", reference[i].File, other[i].File, reference[i].Stream))
+
+				continue
+			}
+
+			if refFrames == nil {
+				var err error
+				refFrames, err = getFrameHashes(st, reference[i].File, algorithm)
+				if err != nil {
+					return err
+				}
+
+				otherFrames, err = getFrameHashes(st, other[i].File, algorithm)
+				if err != nil {
+					return err
+				}
+			}
+
+			frame := firstFrameDifference(refFrames[reference[i].Stream], otherFrames[other[i].Stream])
+			errs = append(errs, fmt.Errorf("stream differs. file: %q, other: %q, stream: %d, frame: %d", reference[i].File, other[i].File, reference[i].Stream, frame))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// streamHashes prints the hashes of the decoded streams of files. If compare is set, the files are expected to have
+// the same content, e.g. a remux and its source.
+func streamHashes(st *state, fileList []os.FileInfo, algorithm string, compare, frames bool, format string) error {
+	if !containsString(hashAlgorithms, algorithm) {
+		return fmt.Errorf("invalid hash algorithm. algorithm: %s", algorithm)
+	}
+
+	if compare && len(fileList) < 2 {
+		return fmt.Errorf("at least two files are needed to compare. files: %d", len(fileList))
+	}
+
+	var all [][]streamHash
+	var results []streamHash
+	for _, fi := range fileList {
+		hashes, err := getStreamHashes(st, fi.Name(), algorithm)
+		if err != nil {
+			return err
+		}
+
+		all = append(all, hashes)
+		results = append(results, hashes...)
+	}
+
+	switch format {
+	case formatJSON:
+		data, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(string(data))
+	case formatTable, "":
+		t := tabby.New()
+		t.AddHeader("FILE", "STREAM", "TYPE", strings.ToUpper(algorithm))
+		for _, r := range results {
+			t.AddLine(r.File, r.Stream, r.Type, r.Hash)
+		}
+		t.Print()
+	default:
+		return fmt.Errorf("invalid format. format: %s", format)
+	}
+
+	if !compare {
+		return nil
+	}
+
+	err := compareStreamHashes(st, all, algorithm, frames)
+	if err != nil {
+		return err
+	}
+
+	st.log.Printf("the streams of %d file(s) are identical", len(fileList))
+
+	return nil
+}
+
+func (a App) streamHashes(c *cli.Context, args []string, fileList []os.FileInfo, dryRun bool) error {
+	algorithm := c.String(hashFlag)
+	compare := c.Bool(compareFlag)
+	frames := c.Bool(framesFlag)
+	format := c.String(formatFlag)
+
+	return streamHashes(a.state, fileList, algorithm, compare, frames, format)
+}
+
+// contentIDLength is the number of hexadecimal digits of the murmur3 hash kept in content IDs
+const contentIDLength = 8
+
+// contentIDPrefix marks content IDs in file names, e.g. "foo-id3fa2c91b.mp4"
+const contentIDPrefix = "id"
+
+var contentIDRegexp = regexp.MustCompile(`(?:^|-)` + contentIDPrefix + `([0-9a-f]{` + strconv.Itoa(contentIDLength) + `})(?:-|$)`)
+
+// findContentID returns the content ID found in the name of a file, if any
+func findContentID(filePath string) string {
+	base := filepath.Base(filePath)
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+
+	m := contentIDRegexp.FindStringSubmatch(base)
+	if m == nil {
+		return ""
+	}
+
+	return m[1]
+}
+
+// getContentID hashes the packets of the video and audio streams of a file and returns the beginning of the hash.
+// The packets are copied, not decoded, so hashing is about as fast as reading the file, and the ID does not depend on
+// the name or the metadata of the file.
+func getContentID(st *state, filePath string) (string, error) {
+	command := []string{"ffmpeg", "-v", "error", inputKey, filePath, "-map", "0:v?", "-map", "0:a?", "-c", "copy", "-f", "hash", "-hash", "murmur3", "-"}
+
+	output, err := exec(st, command)
+	if err != nil {
+		return "", &EncodeError{Path: filePath, Operation: "hash streams", Err: err}
+	}
+
+	_, hash, found := strings.Cut(strings.TrimSpace(output), "=")
+	if !found || len(hash) < contentIDLength {
+		return "", fmt.Errorf("unexpected hash output. path: %q, output: %q", filePath, output)
+	}
+
+	return strings.ToLower(hash[:contentIDLength]), nil
+}
+
+// contentID adds a short, content derived ID as the last part of a file name, so that copies can be found even after
+// they are renamed. Outdated IDs, e.g. of re-encoded files, are replaced.
+func contentID(st *state, fi os.FileInfo, forceOverwrite, dryRun bool) error {
+	filePath := fi.Name()
+
+	id, err := getContentID(st, filePath)
+	if err != nil {
+		return err
+	}
+
+	var spec rename.Spec = rename.Suffix{Text: contentIDPrefix + id}
+
+	switch found := findContentID(filePath); found {
+	case id:
+		st.log.Printf("skipping as the content ID is found. file: %q, id: %s", filePath, id)
+
+		return nil
+	case "":
+	default:
+		st.log.Printf("replacing outdated content ID. file: %q, old: %s, new: %s", filePath, found, id)
+		spec = rename.Replace{Search: contentIDPrefix + found, With: contentIDPrefix + id}
+	}
+
+	newPath, err := rename.Preview(spec, filePath)
+	if err != nil {
+		return err
+	}
+
+	if err := checkRoot(st, filePath, newPath); err != nil {
+		return err
+	}
+
+	if dryRun {
+		planRename(st, filePath, newPath)
+
+		return nil
+	}
+
+	return safeRename(st, filePath, newPath, forceOverwrite)
+}
+
+func (a App) contentID(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
+	forceOverwrite := c.Bool(forceFlag)
+
+	return contentID(a.state, fi, forceOverwrite, dryRun)
+}
+
+const (
+	similarDuplicate = "duplicate"
+	similarSeries    = "series"
+	similarSimilar   = "similar"
+)
+
+const defaultSimilarity = 0.8
+
+// nameTokens returns the lowercase words and numbers of the name of a file, without the directory and the extension
+func nameTokens(filePath string) []string {
+	base := filepath.Base(filePath)
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+
+	return strings.FieldsFunc(strings.ToLower(base), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// levenshtein returns the number of rune insertions, deletions and substitutions needed to turn a into b
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			cur[j] = prev[j] + 1
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+			if prev[j-1]+cost < cur[j] {
+				cur[j] = prev[j-1] + cost
+			}
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev[len(rb)]
+}
+
+// tokenOverlap returns the Jaccard index of two token lists, the share of distinct tokens found in both
+func tokenOverlap(a, b []string) float64 {
+	set := map[string]int{}
+	for _, t := range a {
+		set[t] |= 1
+	}
+	for _, t := range b {
+		set[t] |= 2
+	}
+
+	if len(set) == 0 {
+		return 1
+	}
+
+	both := 0
+	for _, v := range set {
+		if v == 3 {
+			both++
+		}
+	}
+
+	return float64(both) / float64(len(set))
+}
+
+// nameSimilarity returns how similar two tokenized names are between 0 and 1, the higher of the normalized edit
+// distance and the token overlap, so that both typos and reordered words are caught
+func nameSimilarity(a, b []string) float64 {
+	ka, kb := strings.Join(a, " "), strings.Join(b, " ")
+
+	longest := len([]rune(ka))
+	if n := len([]rune(kb)); n > longest {
+		longest = n
+	}
+
+	edit := 1.0
+	if longest > 0 {
+		edit = 1 - float64(levenshtein(ka, kb))/float64(longest)
+	}
+
+	if overlap := tokenOverlap(a, b); overlap > edit {
+		return overlap
+	}
+
+	return edit
+}
+
+var digitsRegexp = regexp.MustCompile(`\d+`)
+
+type similarCluster struct {
+	Kind      string   `json:"kind"`
+	Canonical string   `json:"canonical,omitempty"`
+	Files     []string `json:"files"`
+}
+
+// similarKind tells if the names of a cluster are the same apart from case and separators, differ in their numbers
+// only, or are just similar
+func similarKind(tokens [][]string) string {
+	kind := similarDuplicate
+	for _, t := range tokens[1:] {
+		if strings.Join(t, " ") == strings.Join(tokens[0], " ") {
+			continue
+		}
+
+		if digitsRegexp.ReplaceAllString(strings.Join(t, " "), "#") != digitsRegexp.ReplaceAllString(strings.Join(tokens[0], " "), "#") {
+			return similarSimilar
+		}
+
+		kind = similarSeries
+	}
+
+	return kind
+}
+
+// findSimilarNames clusters file names which are at least threshold similar to another name in the cluster. If
+// propose is set, the dash-separated lowercase tokens of the name most similar to the others are proposed as the
+// canonical name of each cluster, with the numbers replaced by # for series.
+func findSimilarNames(filePaths []string, threshold float64, propose bool) []similarCluster {
+	tokens := make([][]string, len(filePaths))
+	for i, filePath := range filePaths {
+		tokens[i] = nameTokens(filePath)
+	}
+
+	// union-find of the files, each cluster is identified by its first file
+	parent := make([]int, len(filePaths))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+
+		return parent[i]
+	}
+
+	similarity := make([][]float64, len(filePaths))
+	for i := range filePaths {
+		similarity[i] = make([]float64, len(filePaths))
+		for j := 0; j < i; j++ {
+			similarity[i][j] = nameSimilarity(tokens[i], tokens[j])
+			similarity[j][i] = similarity[i][j]
+
+			if similarity[i][j] < threshold {
+				continue
+			}
+
+			ri, rj := find(i), find(j)
+			if ri < rj {
+				parent[rj] = ri
+			} else {
+				parent[ri] = rj
+			}
+		}
+	}
+
+	members := map[int][]int{}
+	var roots []int
+	for i := range filePaths {
+		root := find(i)
+		if _, ok := members[root]; !ok {
+			roots = append(roots, root)
+		}
+		members[root] = append(members[root], i)
+	}
+
+	var clusters []similarCluster
+	for _, root := range roots {
+		indexes := members[root]
+		if len(indexes) < 2 {
+			continue
+		}
+
+		cluster := similarCluster{}
+		clusterTokens := make([][]string, 0, len(indexes))
+		for _, i := range indexes {
+			cluster.Files = append(cluster.Files, filePaths[i])
+			clusterTokens = append(clusterTokens, tokens[i])
+		}
+		cluster.Kind = similarKind(clusterTokens)
+
+		if propose {
+			best, bestTotal := indexes[0], -1.0
+			for _, i := range indexes {
+				total := 0.0
+				for _, j := range indexes {
+					total += similarity[i][j]
+				}
+				if total > bestTotal {
+					best, bestTotal = i, total
+				}
+			}
+
+			cluster.Canonical = strings.Join(tokens[best], "-")
+			if cluster.Kind == similarSeries {
+				cluster.Canonical = digitsRegexp.ReplaceAllString(cluster.Canonical, "#")
+			}
+			cluster.Canonical += strings.ToLower(filepath.Ext(filePaths[best]))
+		}
+
+		clusters = append(clusters, cluster)
+	}
+
+	return clusters
+}
+
+func similarNames(fileList []os.FileInfo, threshold float64, propose bool, format string) error {
+	if threshold <= 0 || threshold > 1 {
+		return fmt.Errorf("invalid threshold, it must be between 0 and 1. threshold: %v", threshold)
+	}
+
+	filePaths := make([]string, 0, len(fileList))
+	for _, fi := range fileList {
+		filePaths = append(filePaths, fi.Name())
+	}
+
+	clusters := findSimilarNames(filePaths, threshold, propose)
+
+	switch format {
+	case formatJSON:
+		if clusters == nil {
+			clusters = []similarCluster{}
+		}
+
+		data, err := json.MarshalIndent(clusters, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(string(data))
+	case formatTable, "":
+		t := tabby.New()
+		if propose {
+			t.AddHeader("CLUSTER", "KIND", "FILE", "CANONICAL")
+		} else {
+			t.AddHeader("CLUSTER", "KIND", "FILE")
+		}
+
+		for i, cluster := range clusters {
+			for _, filePath := range cluster.Files {
+				if propose {
+					t.AddLine(i+1, cluster.Kind, filePath, cluster.Canonical)
+				} else {
+					t.AddLine(i+1, cluster.Kind, filePath)
+				}
+			}
+		}
+
+		t.Print()
+	default:
+		return fmt.Errorf("invalid format. format: %s", format)
+	}
+
+	return nil
+}
+
+func (a App) similarNames(c *cli.Context, args []string, fileList []os.FileInfo, dryRun bool) error {
+	threshold := c.Float64(similarityFlag)
+	propose := c.Bool(proposeFlag)
+	format := c.String(formatFlag)
+
+	return similarNames(fileList, threshold, propose, format)
+}
+
+// namingPolicyFileName is the name of the file defining the naming policy of a directory and its subdirectories
+const namingPolicyFileName = ".ffr.yaml"
+
+const (
+	namePartDate       = "date"
+	namePartDimensions = "dimensions"
+	namePartID         = "id"
+	namePartNumber     = "number"
+	namePartText       = "text"
+)
+
+const (
+	ruleAllowedChars = "allowed-chars"
+	ruleDatePrefix   = "date-prefix"
+	ruleTokenOrder   = "token-order"
+	ruleMaxLength    = "max-length"
+)
+
+// namingPolicy is the naming policy of a directory tree, defined in the naming section of a .ffr.yaml file, e.g.
+//
+//	naming:
+//	  tokens: [date, text, dimensions, id]
+//	  datePrefix: true
+//	  allowedChars: a-z0-9.-
+//	  maxLength: 80
+type namingPolicy struct {
+	// Tokens is the order of the kinds of dash-separated parts: date, dimensions, id, number and text
+	Tokens []string `yaml:"tokens"`
+	// DatePrefix requires names to start with a date
+	DatePrefix bool `yaml:"datePrefix"`
+	// AllowedChars is a regular expression character class of the characters allowed in base names
+	AllowedChars string `yaml:"allowedChars"`
+	// MaxLength is the maximum length of names including the extension, in characters
+	MaxLength int `yaml:"maxLength"`
+
+	disallowed *regexp.Regexp
+}
+
+type policyFile struct {
+	Naming namingPolicy `yaml:"naming"`
+}
+
+// readNamingPolicy reads and validates the naming policy of a policy file
+func readNamingPolicy(path string) (namingPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return namingPolicy{}, fmt.Errorf("failed to read naming policy. path: %q, err: %w", path, err)
+	}
+
+	var pf policyFile
+	err = yaml.Unmarshal(data, &pf)
+	if err != nil {
+		return namingPolicy{}, fmt.Errorf("failed to parse naming policy. path: %q, err: %w", path, err)
+	}
+
+	p := pf.Naming
+	for _, token := range p.Tokens {
+		switch token {
+		case namePartDate, namePartDimensions, namePartID, namePartNumber, namePartText:
+		default:
+			return namingPolicy{}, fmt.Errorf("invalid token in naming policy. path: %q, token: %s", path, token)
+		}
+	}
+
+	if p.AllowedChars != "" {
+		p.disallowed, err = regexp.Compile("[^" + p.AllowedChars + "]+")
+		if err != nil {
+			return namingPolicy{}, fmt.Errorf("invalid allowed characters in naming policy. path: %q, err: %w", path, err)
+		}
+	}
+
+	if p.MaxLength < 0 {
+		return namingPolicy{}, fmt.Errorf("invalid max length in naming policy. path: %q, max length: %d", path, p.MaxLength)
+	}
+
+	return p, nil
+}
+
+// policyFinder finds the naming policies of directories, the closest policy file in a directory or its parents wins
+type policyFinder struct {
+	policies map[string]*namingPolicy
+}
+
+func newPolicyFinder() *policyFinder {
+	return &policyFinder{policies: map[string]*namingPolicy{}}
+}
+
+// Find returns the naming policy of a directory, or nil if neither it nor its parents have one
+func (f *policyFinder) Find(dir string) (*namingPolicy, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if p, ok := f.policies[dir]; ok {
+		return p, nil
+	}
+
+	var p *namingPolicy
+
+	path := filepath.Join(dir, namingPolicyFileName)
+	if _, err := os.Stat(path); err == nil {
+		policy, err := readNamingPolicy(path)
+		if err != nil {
+			return nil, err
+		}
+		p = &policy
+	} else if parent := filepath.Dir(dir); parent != dir {
+		p, err = f.Find(parent)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	f.policies[dir] = p
+
+	return p, nil
+}
+
+var (
+	namePartDateRegexp       = regexp.MustCompile(`^(?:19|20)\d{2}(?:\.\d{2}\.\d{2}|\d{4})$`)
+	namePartDimensionsRegexp = regexp.MustCompile(`^(?:\d{2,5}x\d{2,5}|\d{3,4}p|sd|hd|fullhd|qhd|2k|4k|8k)$`)
+	namePartIDRegexp         = regexp.MustCompile(`^` + contentIDPrefix + `[0-9a-f]{` + strconv.Itoa(contentIDLength) + `}$`)
+	namePartNumberRegexp     = regexp.MustCompile(`^\d+$`)
+)
+
+// classifyNamePart returns the kind of a dash-separated part of a name
+func classifyNamePart(part string) string {
+	switch {
+	case namePartDateRegexp.MatchString(part):
+		return namePartDate
+	case namePartDimensionsRegexp.MatchString(strings.ToLower(part)):
+		return namePartDimensions
+	case namePartIDRegexp.MatchString(part):
+		return namePartID
+	case namePartNumberRegexp.MatchString(part):
+		return namePartNumber
+	}
+
+	return namePartText
+}
+
+// tokenRank returns the position of the kind of a part in the token order, kinds not listed rank as text, or last if
+// text is not listed either
+func (p namingPolicy) tokenRank(part string) int {
+	kind := classifyNamePart(part)
+	for _, k := range []string{kind, namePartText} {
+		for i, token := range p.Tokens {
+			if token == k {
+				return i
+			}
+		}
+	}
+
+	return len(p.Tokens)
+}
+
+type nameViolation struct {
+	File    string `json:"file"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+	Fixed   bool   `json:"fixed"`
+}
+
+// lintName returns the rules of a policy a file name violates
+func lintName(filePath string, p namingPolicy) []nameViolation {
+	name := filepath.Base(filePath)
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	parts := dashParts(base)
+
+	var violations []nameViolation
+	add := func(rule, msg string, args ...any) {
+		violations = append(violations, nameViolation{File: filePath, Rule: rule, Message: fmt.Sprintf(msg, args...)})
+	}
+
+	if p.disallowed != nil {
+		if found := p.disallowed.FindAllString(base, -1); len(found) > 0 {
+			add(ruleAllowedChars, "disallowed characters: %q", strings.Join(found, ""))
+		}
+	}
+
+	if p.DatePrefix && (len(parts) == 0 || classifyNamePart(parts[0]) != namePartDate) {
+		add(ruleDatePrefix, "name does not start with a date")
+	}
+
+	if len(p.Tokens) > 0 {
+		for i := 1; i < len(parts); i++ {
+			if p.tokenRank(parts[i]) < p.tokenRank(parts[i-1]) {
+				add(ruleTokenOrder, "%s %q is after %s %q", classifyNamePart(parts[i]), parts[i], classifyNamePart(parts[i-1]), parts[i-1])
+
+				break
+			}
+		}
+	}
+
+	if p.MaxLength > 0 {
+		if n := truncate.Len(name); n > p.MaxLength {
+			add(ruleMaxLength, "name is %d characters long, %d allowed", n, p.MaxLength)
+		}
+	}
+
+	return violations
+}
+
+// fixName returns the name of a file fixed to follow a policy as far as possible. Names are lowercased if only
+// lowercase letters are allowed, other disallowed characters are replaced by dashes, dates found in the name are moved
+// to its beginning, parts are sorted by the token order and names too long are cut at the end of the base name.
+func fixName(l *logger, filePath string, p namingPolicy) string {
+	name := filepath.Base(filePath)
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	if p.disallowed != nil {
+		if p.disallowed.MatchString("A") && !p.disallowed.MatchString("a") {
+			base = strings.ToLower(base)
+		}
+		base = p.disallowed.ReplaceAllString(base, "-")
+	}
+
+	parts := dashParts(base)
+
+	if p.DatePrefix && (len(parts) == 0 || classifyNamePart(parts[0]) != namePartDate) {
+		for i, part := range parts {
+			if classifyNamePart(part) == namePartDate {
+				parts = append(append([]string{part}, parts[:i]...), parts[i+1:]...)
+
+				break
+			}
+		}
+
+		if len(parts) > 0 && classifyNamePart(parts[0]) != namePartDate {
+			if candidates := findDates(l, base, datePatterns); len(candidates) > 0 {
+				rest := make([]string, 0, len(parts))
+				for _, part := range parts {
+					if part != candidates[0].text {
+						rest = append(rest, part)
+					}
+				}
+				parts = append([]string{candidates[0].date.Format(dateFormat3)}, rest...)
+			}
+		}
+	}
+
+	if len(p.Tokens) > 0 {
+		sort.SliceStable(parts, func(i, j int) bool {
+			return p.tokenRank(parts[i]) < p.tokenRank(parts[j])
+		})
+	}
+
+	base = strings.Join(parts, "-")
+
+	if p.MaxLength > 0 && truncate.Len(base+ext) > p.MaxLength {
+		graphemes := truncate.Graphemes(base)
+		keep := p.MaxLength - truncate.Len(ext)
+		if keep > 0 && keep < len(graphemes) {
+			base = strings.TrimRight(strings.Join(graphemes[:keep], ""), "-")
+		}
+	}
+
+	return filepath.Join(filepath.Dir(filePath), base+ext)
+}
+
+// lintNames checks the names of files against the naming policies of their directories and optionally fixes them.
+// Violations left are returned as an error so that the command can be used in scripts.
+func lintNames(st *state, fileList []os.FileInfo, fix bool, format string, forceOverwrite, dryRun bool) error {
+	finder := newPolicyFinder()
+
+	var (
+		violations []nameViolation
+		pairs      []renamePair
+	)
+	for _, fi := range fileList {
+		filePath := fi.Name()
+
+		p, err := finder.Find(filepath.Dir(filePath))
+		if err != nil {
+			return err
+		}
+		if p == nil {
+			st.log.Printf("no naming policy found. file: %q", filePath)
+
+			continue
+		}
+
+		found := lintName(filePath, *p)
+		if fix && len(found) > 0 {
+			newPath := fixName(st.log, filePath, *p)
+			remaining := lintName(newPath, *p)
+			for i := range found {
+				found[i].Fixed = !containsViolation(remaining, found[i].Rule)
+			}
+
+			if newPath != filePath {
+				pairs = append(pairs, renamePair{oldPath: filePath, newPath: newPath})
+			}
+		}
+
+		violations = append(violations, found...)
+	}
+
+	switch format {
+	case formatJSON:
+		if violations == nil {
+			violations = []nameViolation{}
+		}
+
+		data, err := json.MarshalIndent(violations, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(string(data))
+	case formatTable, "":
+		t := tabby.New()
+		if fix {
+			t.AddHeader("FILE", "RULE", "VIOLATION", "FIXED")
+		} else {
+			t.AddHeader("FILE", "RULE", "VIOLATION")
+		}
+
+		for _, v := range violations {
+			if fix {
+				t.AddLine(v.File, v.Rule, v.Message, v.Fixed)
+			} else {
+				t.AddLine(v.File, v.Rule, v.Message)
+			}
+		}
+
+		t.Print()
+	default:
+		return fmt.Errorf("invalid format. format: %s", format)
+	}
+
+	if len(pairs) > 0 {
+		ordered, err := orderRenameMapping(st, pairs, nil, forceOverwrite)
+		if err != nil {
+			return err
+		}
+
+		err = applyRenames(st, ordered, forceOverwrite, dryRun)
+		if err != nil {
+			return err
+		}
+	}
+
+	remaining := 0
+	for _, v := range violations {
+		if !v.Fixed {
+			remaining++
+		}
+	}
+	if remaining > 0 {
+		return fmt.Errorf("naming policy violations found. violations: %d", remaining)
+	}
+
+	return nil
+}
+
+// dashParts returns the non-empty dash-separated parts of a base name
+func dashParts(base string) []string {
+	return strings.FieldsFunc(base, func(r rune) bool {
+		return r == '-'
+	})
+}
+
+func containsViolation(violations []nameViolation, rule string) bool {
+	for _, v := range violations {
+		if v.Rule == rule {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (a App) lintNames(c *cli.Context, args []string, fileList []os.FileInfo, dryRun bool) error {
+	fix := c.Bool(fixFlag)
+	format := c.String(formatFlag)
+	forceOverwrite := c.Bool(forceFlag)
+
+	err := lintNames(a.state, fileList, fix, format, forceOverwrite, dryRun)
+
+	if dryRun && fix && a.resultFormat == "" {
+		previewChanges(a.changes, getMaxNameLength(c))
+	}
+
+	return err
+}
+
+// dirIndexFileName is the name of the optional index of the canonical names of the files in a directory
+const dirIndexFileName = ".ffr-index.json"
+
+// dirIndex maps the canonical names of the files in a directory to their names
+type dirIndex map[string]string
+
+// canonicalName returns the lowercase dash-separated tokens of a file name without its extension, so that names
+// differing only in case, separators or extension are the same, e.g. "Holiday 2023.MP4" and "holiday-2023.mkv"
+func canonicalName(filePath string) string {
+	return strings.Join(nameTokens(filePath), "-")
+}
+
+// readDirIndex reads the index of a directory, nil is returned if the directory is not indexed
+func readDirIndex(dir string) (dirIndex, error) {
+	data, err := os.ReadFile(filepath.Join(dir, dirIndexFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	index := dirIndex{}
+	err = json.Unmarshal(data, &index)
+	if err != nil {
+		return nil, fmt.Errorf("invalid index file. dir: %q, err: %w", dir, err)
+	}
+
+	return index, nil
+}
+
+func writeDirIndex(dir string, index dirIndex) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, dirIndexFileName), append(data, '\n'), 0644)
+}
+
+// buildDirIndex indexes the files of a directory, hidden files are skipped. If several files share a canonical
+// name, the first one in alphabetical order is indexed.
+func buildDirIndex(dir string) (dirIndex, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list directory. dir: %q, err: %w", dir, err)
+	}
+
+	index := dirIndex{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+
+		canonical := canonicalName(name)
+		if _, ok := index[canonical]; !ok && canonical != "" {
+			index[canonical] = name
+		}
+	}
+
+	return index, nil
+}
+
+// updateDirIndex keeps the indexes of the directories of a renamed (or linked) file up to date, directories which are
+// not indexed are skipped
+func updateDirIndex(oldPath, newPath string, keepOld bool) error {
+	if !keepOld {
+		dir := filepath.Dir(oldPath)
+
+		index, err := readDirIndex(dir)
+		if err != nil {
+			return err
+		}
+
+		canonical := canonicalName(oldPath)
+		if index[canonical] == filepath.Base(oldPath) {
+			delete(index, canonical)
+
+			err = writeDirIndex(dir, index)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	dir := filepath.Dir(newPath)
+
+	index, err := readDirIndex(dir)
+	if err != nil || index == nil {
+		return err
+	}
+
+	canonical := canonicalName(newPath)
+	if _, ok := index[canonical]; ok || canonical == "" {
+		return nil
+	}
+	index[canonical] = filepath.Base(newPath)
+
+	return writeDirIndex(dir, index)
+}
+
+const (
+	problemCollision = "collision"
+	problemDuplicate = "duplicate"
+	problemPolicy    = "policy"
+)
+
+// newFileProblem is a reason a file should not be moved into a directory as it is
+type newFileProblem struct {
+	File    string `json:"file"`
+	Problem string `json:"problem"`
+	Message string `json:"message"`
+}
+
+// checkNewFiles checks files before they are moved into dir: their names must not be taken, must not have the
+// canonical name of a file indexed in dir or of another new file, and must follow the naming policy of dir. Without an
+// index, the files in dir are indexed on the fly. updateIndex (re)builds and saves the index of dir.
+func checkNewFiles(l *logger, dir string, fileList []os.FileInfo, updateIndex bool, format string, dryRun bool) ([]newFileProblem, error) {
+	index, err := readDirIndex(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if index == nil || updateIndex {
+		if index == nil {
+			l.Printf("directory is not indexed, indexing its files. dir: %q", dir)
+		}
+
+		index, err = buildDirIndex(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		if updateIndex && !dryRun {
+			err = writeDirIndex(dir, index)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	p, err := newPolicyFinder().Find(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	problems := []newFileProblem{}
+	add := func(filePath, problem, msg string, args ...any) {
+		problems = append(problems, newFileProblem{File: filePath, Problem: problem, Message: fmt.Sprintf(msg, args...)})
+	}
+
+	seen := map[string]string{}
+	for _, fi := range fileList {
+		filePath := fi.Name()
+		name := filepath.Base(filePath)
+		target := filepath.Join(dir, name)
+
+		if absPath, err := filepath.Abs(filePath); err == nil && filepath.Dir(absPath) == absDir {
+			l.Printf("file is already in the directory. file: %q", filePath)
+
+			continue
+		}
+
+		canonical := canonicalName(name)
+		if _, err := os.Lstat(target); err == nil {
+			add(filePath, problemCollision, "name is taken: %q", target)
+		} else if existing, ok := index[canonical]; ok {
+			add(filePath, problemDuplicate, "same canonical name as %q", filepath.Join(dir, existing))
+		}
+
+		if other, ok := seen[canonical]; ok {
+			add(filePath, problemDuplicate, "same canonical name as the new file %q", other)
+		} else {
+			seen[canonical] = filePath
+		}
+
+		if p != nil {
+			for _, v := range lintName(target, *p) {
+				add(filePath, problemPolicy, "%s: %s", v.Rule, v.Message)
+			}
+		}
+	}
+
+	switch format {
+	case formatJSON:
+		data, err := json.MarshalIndent(problems, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+
+		fmt.Println(string(data))
+	case formatTable, "":
+		t := tabby.New()
+		t.AddHeader("FILE", "PROBLEM", "DETAILS")
+		for _, problem := range problems {
+			t.AddLine(problem.File, problem.Problem, problem.Message)
+		}
+		t.Print()
+	default:
+		return nil, fmt.Errorf("invalid format. format: %s", format)
+	}
+
+	if len(problems) > 0 {
+		return problems, fmt.Errorf("new files conflict with the directory. problems: %d", len(problems))
+	}
+
+	return problems, nil
+}
+
+func (a App) checkNew(c *cli.Context, args []string, fileList []os.FileInfo, dryRun bool) error {
+	dir := args[0]
+
+	fi, err := os.Stat(dir)
+	if err != nil || !fi.IsDir() {
+		return fmt.Errorf("target is not a directory. dir: %q", dir)
+	}
+
+	_, err = checkNewFiles(a.log, dir, fileList, c.Bool(updateIndexFlag), c.String(formatFlag), dryRun)
+
+	return err
+}
+
+func filterJournal(entries []journalEntry, since, until time.Time, command, file string) []journalEntry {
+	var result []journalEntry
+	for _, entry := range entries {
+		if !since.IsZero() && entry.Time.Before(since) {
+			continue
+		}
+
+		if !until.IsZero() && !entry.Time.Before(until) {
+			continue
+		}
+
+		if command != "" && entry.Command != command {
+			continue
+		}
+
+		if file != "" && !strings.Contains(entry.From, file) && !strings.Contains(entry.To, file) {
+			continue
+		}
+
+		result = append(result, entry)
+	}
+
+	return result
+}
+
+func parseDateFlag(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date, expected format: YYYY-MM-DD. date: %s", value)
+	}
+
+	return t, nil
+}
+
+func history(journalPath string, since, until time.Time, command, file, export string) error {
+	entries, err := readJournal(journalPath)
+	if err != nil {
+		return err
+	}
+
+	entries = filterJournal(entries, since, until, command, file)
+
+	switch export {
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		_ = w.Write([]string{"time", "run", "command", "action", "from", "to"})
+		for _, entry := range entries {
+			_ = w.Write([]string{entry.Time.Format(time.RFC3339), entry.Run, entry.Command, entry.Action, entry.From, entry.To})
+		}
+		w.Flush()
+
+		return w.Error()
+	case formatJSON:
+		data, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(string(data))
+	case "", formatTable:
+		t := tabby.New()
+		t.AddHeader("TIME", "COMMAND", "ACTION", "FROM", "TO")
+		for _, entry := range entries {
+			t.AddLine(entry.Time.Format("2006-01-02 15:04:05"), entry.Command, entry.Action, entry.From, entry.To)
+		}
+		t.Print()
+	default:
+		return fmt.Errorf("invalid export format. format: %s", export)
+	}
+
+	return nil
+}
+
+func (a App) history(c *cli.Context) error {
+	err := configure(a.state, c)
+	if err != nil {
+		return err
+	}
+
+	since, err := parseDateFlag(c.String(sinceFlag))
+	if err != nil {
+		return err
+	}
+
+	until, err := parseDateFlag(c.String(untilFlag))
+	if err != nil {
+		return err
+	}
+	if !until.IsZero() {
+		// until is inclusive
+		until = until.AddDate(0, 0, 1)
+	}
+
+	return history(c.String(journalFlag), since, until, c.String(commandFilterFlag), c.String(fileFilterFlag), c.String(exportFlag))
+}
+
+// expandMacro returns the command lines to run for args. If the command is not a built-in one but a macro defined in
+// the config, a command line is returned for each step of the macro, followed by the rest of the arguments.
+func expandMacro(app *cli.App, args []string) ([][]string, error) {
+	configPath := defaultConfigPath()
+
+	i := 1
+	for i < len(args) {
+		flag := findFlag(app.Flags, args[i])
+		if flag == nil {
+			break
+		}
+
+		n := flagArgCount(flag, args[i:])
+		if containsString(flag.Names(), configFlag) {
+			if n == 2 {
+				configPath = args[i+1]
+			} else if parts := strings.SplitN(args[i], "=", 2); len(parts) == 2 {
+				configPath = parts[1]
+			}
+		}
+		i += n
+	}
+
+	if i >= len(args) || app.Command(args[i]) != nil {
+		return [][]string{args}, nil
+	}
+
+	c, err := readConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	m, ok := c.Macros[args[i]]
+	if !ok {
+		return [][]string{args}, nil
+	}
+
+	var steps [][]string
+	for _, line := range m {
+		words, err := shell.Fields(line, nil)
+		if err != nil {
+			return nil, fmt.Errorf("invalid macro. macro: %s, err: %w", args[i], err)
+		}
+
+		step := append(append([]string{}, args[:i]...), words...)
+		steps = append(steps, append(step, args[i+1:]...))
+	}
+
+	return steps, nil
+}
+
+// followChanges replaces the paths in args which were renamed by the previous step of a macro
+func followChanges(args []string, pairs []renamePair) []string {
+	result := append([]string{}, args...)
+	for _, pair := range pairs {
+		// planned renames of dry runs are not followed as the new files do not exist
+		if _, err := os.Stat(pair.newPath); err != nil {
+			continue
+		}
+
+		for i, arg := range result {
+			if arg == pair.oldPath {
+				result[i] = pair.newPath
+			}
+		}
+	}
+
+	return result
+}
+
+// run runs the command line args, expanding macros
+func run(st *state, app *cli.App, args []string) error {
+	steps, err := expandMacro(app, args)
+	if err != nil {
+		return err
+	}
+
+	for i, step := range steps {
+		invocation = reorderArgs(app, step)
+
+		err = app.Run(invocation)
+		if err != nil {
+			return err
+		}
+
+		for j := i + 1; j < len(steps); j++ {
+			steps[j] = followChanges(steps[j], st.changes)
+		}
+	}
+
+	return nil
+}
+
+// invocation contains the arguments ffr was run with after reordering, recorded in the command history
+var invocation []string
+
+// maxPickEntries is the number of recent invocations offered by again --pick
+const maxPickEntries = 20
+
+// invocationEntry is a recorded run of a file processing command
+type invocationEntry struct {
+	Time        time.Time `json:"time"`
+	Dir         string    `json:"dir"`
+	Args        []string  `json:"args"`
+	Files       []string  `json:"files"`
+	PassThrough []string  `json:"passThrough,omitempty"`
+}
+
+func defaultCommandHistoryPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "ffr", "history.jsonl")
+}
+
+// newInvocationEntry separates the files of the current invocation from the global flags, the command, its flags and
+// its first argCount arguments
+func newInvocationEntry(args []string, argCount int) (invocationEntry, bool) {
+	if len(invocation) < len(args)+1 {
+		return invocationEntry{}, false
+	}
+
+	files, passThrough := splitPassThrough(args[argCount:])
+
+	dir, _ := os.Getwd()
+	entry := invocationEntry{
+		Time:        time.Now(),
+		Dir:         dir,
+		Args:        append(append([]string{}, invocation[1:len(invocation)-len(args)]...), args[:argCount]...),
+		Files:       files,
+		PassThrough: passThrough,
+	}
+
+	return entry, true
+}
+
+// recordInvocation appends the current invocation to the command history, separating the files from the global
+// flags, the command, its flags and its first argCount arguments so that the command can be repeated on other files
+func recordInvocation(l *logger, path string, args []string, argCount int) {
+	if path == "" {
+		return
+	}
+
+	entry, ok := newInvocationEntry(args, argCount)
+	if !ok {
+		return
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		l.Printf("failed to encode command history entry. err: %s", err)
+		return
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		l.Printf("failed to create command history directory. path: %q, err: %s", path, err)
+		return
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		l.Printf("failed to open command history. path: %q, err: %s", path, err)
+		return
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(data, '\n'))
+	if err != nil {
+		l.Printf("failed to write command history. path: %q, err: %s", path, err)
+	}
+}
+
+func readCommandHistory(path string) ([]invocationEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read command history. path: %q, err: %w", path, err)
+	}
+
+	var entries []invocationEntry
+	for i, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		var entry invocationEntry
+		err = json.Unmarshal([]byte(line), &entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid command history entry. path: %q, line: %d, err: %w", path, i+1, err)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// repeatArgs returns the arguments to repeat entry with, files replace the recorded files if provided
+func (e invocationEntry) repeatArgs(files []string) []string {
+	if len(files) == 0 {
+		files = e.Files
+	}
+
+	args := append(append([]string{}, e.Args...), files...)
+	if len(e.PassThrough) > 0 {
+		args = append(append(args, "--"), e.PassThrough...)
+	}
+
+	return args
+}
+
+// pickInvocation lists the most recent invocations and reads the number of the chosen one from r
+func pickInvocation(entries []invocationEntry, r io.Reader) (invocationEntry, error) {
+	if len(entries) > maxPickEntries {
+		entries = entries[len(entries)-maxPickEntries:]
+	}
+
+	t := tabby.New()
+	t.AddHeader("#", "TIME", "DIR", "COMMAND")
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		t.AddLine(len(entries)-i, e.Time.Format("2006-01-02 15:04:05"), e.Dir, strings.Join(e.repeatArgs(nil), " "))
+	}
+	t.Print()
+
+	fmt.Print("command to repeat: ")
+
+	var n int
+	_, err := fmt.Fscanln(r, &n)
+	if err != nil || n < 1 || n > len(entries) {
+		return invocationEntry{}, fmt.Errorf("invalid choice. choice: %d", n)
+	}
+
+	return entries[len(entries)-n], nil
+}
+
+func (a App) again(c *cli.Context) error {
+	err := configure(a.state, c)
+	if err != nil {
+		return err
+	}
+
+	entries, err := readCommandHistory(c.String(commandHistoryFlag))
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return errors.New("command history is empty")
+	}
+
+	entry := entries[len(entries)-1]
+	if c.Bool(pickFlag) {
+		entry, err = pickInvocation(entries, os.Stdin)
+		if err != nil {
+			return err
+		}
+	}
+
+	files := c.Args().Slice()
+	if len(files) == 0 && entry.Dir != "" {
+		// the recorded files are relative to the directory the command was run in
+		err = os.Chdir(entry.Dir)
+		if err != nil {
+			return fmt.Errorf("failed to change directory. dir: %q, err: %w", entry.Dir, err)
+		}
+	}
+
+	args := entry.repeatArgs(files)
+	a.progress.Printf("repeating: ffr %s", strings.Join(args, " "))
+
+	invocation = reorderArgs(c.App, append([]string{c.App.Name}, args...))
+
+	return c.App.Run(invocation)
+}
+
+const (
+	windowCloseWait = "wait"
+	windowCloseExit = "exit"
+)
+
+// scheduleWindow is a time window of the day, in minutes since midnight. Windows ending before they start span
+// midnight, e.g. 22:00-06:00.
+type scheduleWindow struct {
+	start, end int
+}
+
+// parseScheduleWindow parses windows like 22:00-06:00, an empty string means no window
+func parseScheduleWindow(s string) (*scheduleWindow, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid schedule, expected a window like 22:00-06:00. schedule: %q", s)
+	}
+
+	var minutes []int
+	for _, part := range []string{from, to} {
+		t, err := time.Parse("15:04", strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule, expected a window like 22:00-06:00. schedule: %q, err: %w", s, err)
+		}
+
+		minutes = append(minutes, t.Hour()*60+t.Minute())
+	}
+
+	if minutes[0] == minutes[1] {
+		return nil, fmt.Errorf("invalid schedule, the window is empty. schedule: %q", s)
+	}
+
+	return &scheduleWindow{start: minutes[0], end: minutes[1]}, nil
+}
+
+// contains checks if t is inside of the window
+func (w *scheduleWindow) contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	if w.start < w.end {
+		return m >= w.start && m < w.end
+	}
+
+	return m >= w.start || m < w.end
+}
+
+// untilOpen returns the time left from t until the next opening of the window
+func (w *scheduleWindow) untilOpen(t time.Time) time.Duration {
+	next := time.Date(t.Year(), t.Month(), t.Day(), w.start/60, w.start%60, 0, 0, t.Location())
+	if next.Before(t) {
+		next = next.AddDate(0, 0, 1)
+	}
+
+	return next.Sub(t)
+}
+
+func defaultResumePath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "ffr", "resume.json")
+}
+
+// writeResume records the files left by a run stopped by its time budget in the resume manifest, so that resume can
+// continue the run with the same flags
+func writeResume(path string, args []string, argCount int, remaining []os.FileInfo) error {
+	if path == "" {
+		return errors.New("resume manifest path is not set")
+	}
+
+	entry, ok := newInvocationEntry(args, argCount)
+	if !ok {
+		return errors.New("failed to record the invocation")
+	}
+
+	entry.Files = nil
+	for _, fi := range remaining {
+		entry.Files = append(entry.Files, fi.Name())
+	}
+
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode resume manifest. err: %w", err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create resume manifest directory. path: %q, err: %w", path, err)
+	}
+
+	err = os.WriteFile(path, data, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write resume manifest. path: %q, err: %w", path, err)
+	}
+
+	return nil
+}
+
+func readResume(path string) (invocationEntry, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return invocationEntry{}, errors.New("nothing to resume, no run was stopped early")
+	}
+	if err != nil {
+		return invocationEntry{}, fmt.Errorf("failed to read resume manifest. path: %q, err: %w", path, err)
+	}
+
+	var entry invocationEntry
+	err = json.Unmarshal(data, &entry)
+	if err != nil {
+		return invocationEntry{}, fmt.Errorf("invalid resume manifest. path: %q, err: %w", path, err)
+	}
+
+	return entry, nil
+}
+
+// stopEarly lists the files left by a run stopped by its time budget or schedule and records them in the resume
+// manifest
+func stopEarly(st *state, path, reason string, args []string, argCount int, remaining []os.FileInfo) {
+	log.Print(colorize(st, colorYellow, fmt.Sprintf("%s, %d file(s) left:", reason, len(remaining))))
+	for _, fi := range remaining {
+		log.Printf("  %s", fi.Name())
+	}
+
+	err := writeResume(path, args, argCount, remaining)
+	if err != nil {
+		log.Print(colorize(st, colorRed, err.Error()))
+		return
+	}
+
+	log.Printf("run ffr %s to continue", resumeCommand)
+}
+
+func (a App) resume(c *cli.Context) error {
+	err := configure(a.state, c)
+	if err != nil {
+		return err
+	}
+
+	path := c.String(resumeManifestFlag)
+	entry, err := readResume(path)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read resume manifest. path: %q, err: %w", path, err)
+	}
+
+	if entry.Dir != "" {
+		// the recorded files are relative to the directory the command was run in
+		err = os.Chdir(entry.Dir)
+		if err != nil {
+			return fmt.Errorf("failed to change directory. dir: %q, err: %w", entry.Dir, err)
+		}
+	}
+
+	args := entry.repeatArgs(nil)
+	a.progress.Printf("resuming: ffr %s", strings.Join(args, " "))
+
+	invocation = reorderArgs(c.App, append([]string{c.App.Name}, args...))
+
+	err = c.App.Run(invocation)
+	if err != nil {
+		return err
+	}
+
+	// the manifest is kept if the run records the files left in it again, e.g. when it runs out of time again
+	current, err := os.ReadFile(path)
+	if err != nil || !bytes.Equal(current, manifest) {
+		return nil
+	}
+
+	err = os.Remove(path)
+	if err != nil {
+		return fmt.Errorf("failed to remove resume manifest. path: %q, err: %w", path, err)
+	}
+
+	return nil
+}
+
+// stagedRename is a rename in the staging manifest. Paths are absolute so that the staged renames can be committed
+// from any directory.
+type stagedRename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// stagedInvocation is a staged command together with the renames its dry-run planned
+type stagedInvocation struct {
+	Time     time.Time      `json:"time"`
+	Dir      string         `json:"dir"`
+	Args     []string       `json:"args"`
+	Sidecars []string       `json:"sidecars,omitempty"`
+	Renames  []stagedRename `json:"renames"`
+}
+
+func defaultStagingPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "ffr", "staging.json")
+}
+
+// readStaging reads the staging manifest, a missing manifest means that nothing is staged
+func readStaging(path string) ([]stagedInvocation, error) {
+	if path == "" {
+		return nil, errors.New("staging manifest is not set")
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read staging manifest. path: %q, err: %w", path, err)
+	}
+
+	var staged []stagedInvocation
+	err = json.Unmarshal(data, &staged)
+	if err != nil {
+		return nil, fmt.Errorf("invalid staging manifest. path: %q, err: %w", path, err)
+	}
+
+	return staged, nil
+}
+
+// writeStaging writes the staging manifest, removing it if nothing is staged
+func writeStaging(path string, staged []stagedInvocation) error {
+	if len(staged) == 0 {
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove staging manifest. path: %q, err: %w", path, err)
+		}
+
+		return nil
+	}
+
+	data, err := json.MarshalIndent(staged, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create staging directory. path: %q, err: %w", path, err)
+	}
+
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// stagedPairs returns the staged renames of all the staged commands and the sidecar extensions to rename with them
+func stagedPairs(staged []stagedInvocation) ([]renamePair, map[string][]string) {
+	var pairs []renamePair
+	sidecars := map[string][]string{}
+	for _, entry := range staged {
+		for _, r := range entry.Renames {
+			pairs = append(pairs, renamePair{oldPath: r.From, newPath: r.To})
+			sidecars[r.From] = entry.Sidecars
+		}
+	}
+
+	return pairs, sidecars
+}
+
+// stage adds the renames planned by a command to the staging manifest. Renames conflicting with the ones staged
+// before are refused, so that the staged renames can always be committed together.
+func stage(st *state, path string, args []string, pairs []renamePair, sidecars []string) error {
+	staged, err := readStaging(path)
+	if err != nil {
+		return err
+	}
+
+	sources, targets := map[string]bool{}, map[string]bool{}
+	previous, _ := stagedPairs(staged)
+	for _, pair := range previous {
+		sources[pair.oldPath] = true
+		targets[pair.newPath] = true
+	}
+
+	dir, _ := os.Getwd()
+	entry := stagedInvocation{
+		Time:     time.Now(),
+		Dir:      dir,
+		Args:     args,
+		Sidecars: sidecars,
+	}
+
+	var errs []error
+	for _, pair := range pairs {
+		from, err := filepath.Abs(pair.oldPath)
+		if err != nil {
+			return fmt.Errorf("failed to resolve path. path: %q, err: %w", pair.oldPath, err)
+		}
+
+		to, err := filepath.Abs(pair.newPath)
+		if err != nil {
+			return fmt.Errorf("failed to resolve path. path: %q, err: %w", pair.newPath, err)
+		}
+
+		if sources[from] {
+			errs = append(errs, fmt.Errorf("file is already staged. path: %q", from))
+		}
+		if targets[to] {
+			errs = append(errs, fmt.Errorf("several files are staged to be renamed to the same path. path: %q", to))
+		}
+		sources[from], targets[to] = true, true
+
+		entry.Renames = append(entry.Renames, stagedRename{From: from, To: to})
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	err = writeStaging(path, append(staged, entry))
+	if err != nil {
+		return err
+	}
+
+	st.progress.Printf("%d rename(s) staged, %d in total.", len(entry.Renames), len(previous)+len(entry.Renames))
+
+	return nil
+}
+
+// stagingStatus lists the staged commands and renames, warning about the problems which would prevent the commit
+func stagingStatus(st *state, path string, maxNameLength int) error {
+	staged, err := readStaging(path)
+	if err != nil {
+		return err
+	}
+
+	if len(staged) == 0 {
+		st.progress.Println("nothing staged.")
+
+		return nil
+	}
+
+	t := tabby.New()
+	t.AddHeader("#", "TIME", "DIR", "COMMAND", "RENAMES")
+	for i, entry := range staged {
+		t.AddLine(i+1, entry.Time.Format("2006-01-02 15:04:05"), entry.Dir, strings.Join(entry.Args, " "), len(entry.Renames))
+	}
+	t.Print()
+	fmt.Println()
+
+	pairs, _ := stagedPairs(staged)
+	previewChanges(pairs, maxNameLength)
+
+	_, err = orderRenameMapping(st, pairs, nil, false)
+	if err != nil {
+		st.progress.Println(colorize(st, colorYellow, fmt.Sprintf("staged renames can not be committed as they are. err: %s", err)))
+	}
+
+	st.progress.Printf("%d rename(s) staged.", len(pairs))
+
+	return nil
+}
+
+// commitStaged applies all the staged renames after validating them together. If a rename fails, the ones already
+// done are reverted, so either all or none of the staged renames are applied. The manifest is cleared on success.
+func commitStaged(st *state, path string, forceOverwrite, dryRun bool) error {
+	staged, err := readStaging(path)
+	if err != nil {
+		return err
+	}
+
+	if len(staged) == 0 {
+		return errors.New("nothing staged")
+	}
+
+	pairs, sidecars := stagedPairs(staged)
+
+	pairs, err = orderRenameMapping(st, pairs, nil, forceOverwrite)
+	if err != nil {
+		return err
+	}
+
+	if dryRun {
+		for _, pair := range pairs {
+			planRename(st, pair.oldPath, pair.newPath)
+		}
+
+		st.progress.Printf("%d rename(s) planned.", len(pairs))
+
+		return nil
+	}
+
+	var done []renamePair
+	for _, pair := range pairs {
+		st.sidecarExtensions = sidecars[pair.oldPath]
+
+		err = createDirs(st, filepath.Dir(pair.newPath))
+		if err == nil {
+			err = safeRename(st, pair.oldPath, pair.newPath, forceOverwrite)
+		}
+		if err != nil {
+			err = fmt.Errorf("failed to rename, reverting the renames done. old path: %q, new path: %q, err: %w", pair.oldPath, pair.newPath, err)
+
+			return errors.Join(err, rollbackRenames(st, done, sidecars))
+		}
+
+		done = append(done, pair)
+	}
+
+	st.progress.Printf("%d file(s) renamed.", len(pairs))
+
+	return writeStaging(path, nil)
+}
+
+// rollbackRenames reverts the renames done, in reverse order
+func rollbackRenames(st *state, done []renamePair, sidecars map[string][]string) error {
+	var errs []error
+	for i := len(done) - 1; i >= 0; i-- {
+		pair := done[i]
+		st.sidecarExtensions = sidecars[pair.oldPath]
+
+		err := safeRename(st, pair.newPath, pair.oldPath, false)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to revert rename. old path: %q, new path: %q, err: %w", pair.oldPath, pair.newPath, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (a App) stage(c *cli.Context) error {
+	err := configure(a.state, c)
+	if err != nil {
+		return err
+	}
+
+	args := c.Args().Slice()
+	if len(args) == 0 {
+		return missingArgumentError(c, 0)
+	}
+
+	command := c.App.Command(args[0])
+	if command == nil {
+		return fmt.Errorf("unknown command. command: %s", args[0])
+	}
+
+	switch command.Name {
+	case stageCommand, statusCommand, commitCommand, undoCommand, againCommand, resumeCommand:
+		return fmt.Errorf("command can not be staged. command: %s", command.Name)
+	}
+
+	stagingPath := c.String(stagingFlag)
+
+	// the global flags given before stage apply to the staged command too
+	var global []string
+	if i := len(invocation) - len(args) - 1; i > 1 {
+		global = append(global, invocation[1:i]...)
+	}
+	stagedArgs := append(global, args...)
+
+	a.progress.Printf("staging: ffr %s", strings.Join(stagedArgs, " "))
+
+	// the staged command is planned by an app of its own, its state collects the plan without touching this one
+	plan := App{state: newState()}
+	planApp := plan.cliApp()
+
+	err = planApp.Run(reorderArgs(planApp, append([]string{planApp.Name, "--" + dryRunFlag}, stagedArgs...)))
+	if err != nil {
+		return err
+	}
+
+	if len(plan.outputs) > 0 {
+		return fmt.Errorf("only renames can be staged, the command creates new files. command: %s", command.Name)
 	}
 
-	rbr = intToString(rawBitRate, "", "")
-	rbr2 := intToString(rawBitRate*2, "", "")
-	l.Printf("file: %s, old codec: %s, encoder: %s, new bit rate: %d, rbr human: %s", fi.Name(), oldCodec, encoder, rawBitRate, rbr)
+	if len(plan.changes) == 0 {
+		return errors.New("nothing to stage, the command planned no renames")
+	}
 
-	return rbr, rbr2, nil
+	return stage(a.state, stagingPath, stagedArgs, plan.changes, plan.sidecarExtensions)
 }
 
-func reEncode(fi os.FileInfo, codec string, crf int, preset, hwaccel, hwaccelDevice string, dryRun bool) (string, error) {
-	filePath := fi.Name()
+func (a App) status(c *cli.Context) error {
+	err := configure(a.state, c)
+	if err != nil {
+		return err
+	}
 
-	basePath := filepath.Base(filePath)
-	ext := filepath.Ext(filePath)
-	if ext != "" {
-		basePath = basePath[:len(basePath)-len(ext)]
+	return stagingStatus(a.state, c.String(stagingFlag), getMaxNameLength(c))
+}
+
+func (a App) commit(c *cli.Context) error {
+	err := configure(a.state, c)
+	if err != nil {
+		return err
 	}
+	defer exportLogHistory(a.log, c.String(logHistoryFlag))
 
-	extNew := "mp4"
-	params := NewReEncoder()
-	params.
-		Set(hwaccelKey, "auto").
-		Set(hwaccelDeviceKey, hwaccelDevice).
-		Set(inputKey, filePath).
-		Set(crfKey, fmt.Sprintf("%d", crf)).
-		Set(presetKey, preset)
+	if c.Bool(discardFlag) {
+		a.progress.Println("staged renames discarded.")
 
-	switch codec {
-	case encoderH265:
-		const x265Params = "-x265-params"
+		return writeStaging(c.String(stagingFlag), nil)
+	}
 
-		// https://trac.ffmpeg.org/wiki/Encode/H.265
-		if crf == 0 {
-			crf = 23
-		}
+	return commitStaged(a.state, c.String(stagingFlag), c.Bool(forceFlag), c.Bool(dryRunFlag))
+}
 
-		preset, err := findPreset(preset)
-		if err != nil {
-			return "", err
-		}
+// labelsFileName is the name of the sidecar database storing the labels of the files in a directory
+const labelsFileName = ".ffr-labels.json"
 
-		params.
-			Delete(crfKey).
-			Set(videoCodecKey, encoderH265).
-			Set(x265Params, "keyint=1").
-			Set(presetKey, preset).
-			Set(crfKey, fmt.Sprintf("%d", crf)).
-			Set(audioCodecKey, "copy").
-			Set("-tag:v", "hvc1")
+// labelStore maps file names to their labels
+type labelStore map[string][]string
 
-		switch hwaccel {
-		case "qsv":
-			params.
-				Delete(presetKey).
-				Delete(crfKey).
-				// Set(hwaccelKey, "hevc_qsv").
-				Set(videoCodecKey, "hevc_qsv")
-		default:
-			params.
-				Delete(hwaccelKey).
-				Delete(hwaccelDeviceKey)
-		}
+func readLabels(dir string) (labelStore, error) {
+	store := labelStore{}
 
-		break
-	case encoderH264:
-		const x264Params = "-x264-params"
+	data, err := os.ReadFile(filepath.Join(dir, labelsFileName))
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
 
-		// https://trac.ffmpeg.org/wiki/Encode/H.264
-		if crf == 0 {
-			crf = 20
+	err = json.Unmarshal(data, &store)
+	if err != nil {
+		return nil, fmt.Errorf("invalid labels file. dir: %q, err: %w", dir, err)
+	}
+
+	return store, nil
+}
+
+func writeLabels(dir string, store labelStore) error {
+	path := filepath.Join(dir, labelsFileName)
+
+	for name, labels := range store {
+		if len(labels) == 0 {
+			delete(store, name)
 		}
+	}
 
-		preset, err := findPreset(preset)
-		if err != nil {
-			return "", err
+	if len(store) == 0 {
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
 		}
 
-		params.
-			Delete(crfKey).
-			Set(videoCodecKey, encoderH264).
-			Set(x264Params, "keyint=1").
-			Set(presetKey, preset).
-			Set(crfKey, fmt.Sprintf("%d", crf)).
-			Set(audioCodecKey, "copy")
+		return nil
+	}
 
-		switch hwaccel {
-		case "qsv":
-			params.
-				Delete(presetKey).
-				Delete(crfKey).
-				// Set(hwaccelKey, "hevc_qsv").
-				Set(videoCodecKey, "h264_qsv")
-		default:
-			params.
-				Delete(hwaccelKey).
-				Delete(hwaccelDeviceKey)
-		}
+	data, err := json.MarshalIndent(store, "", "  ")
+	if err != nil {
+		return err
+	}
 
-		break
-	case encoderVP9:
-		const vp9KeyFrameKey = "-g"
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
 
-		// https://trac.ffmpeg.org/wiki/Encode/VP9
-		extNew = "mkv"
+func getLabels(filePath string) ([]string, error) {
+	store, err := readLabels(filepath.Dir(filePath))
+	if err != nil {
+		return nil, err
+	}
 
-		params.
-			Delete(presetKey).
-			Delete(crfKey).
-			Set(videoCodecKey, encoderVP9).
-			Set(vp9KeyFrameKey, "1").
-			Set(crfKey, fmt.Sprintf("%d", crf)).
-			Set(audioCodecKey, "copy")
+	return store[filepath.Base(filePath)], nil
+}
 
-		if crf == 0 {
-			params.
-				Delete(crfKey).
-				Set(losslessKey, "1")
-		}
+func addLabels(filePath string, labels []string) error {
+	dir, name := filepath.Dir(filePath), filepath.Base(filePath)
 
-		switch hwaccel {
-		case "qsv":
-			params.
-				Delete(presetKey).
-				Delete(crfKey).
-				// Set(hwaccelKey, "hevc_qsv").
-				Set(videoCodecKey, "vp9_qsv")
-		default:
-			params.
-				Delete(hwaccelKey).
-				Delete(hwaccelDeviceKey)
-		}
+	store, err := readLabels(dir)
+	if err != nil {
+		return err
 	}
 
-	if hwaccel != "" {
-		avgBitRate, maxBitRate, err := getNewBitRates(fi, codec)
-		if err != nil {
-			return "", fmt.Errorf("unable to get bit rates. err: %w", err)
+	for _, label := range labels {
+		if !containsString(store[name], label) {
+			store[name] = append(store[name], label)
 		}
-
-		params.
-			Set(bitRateKey, avgBitRate).
-			Set(maxRateKey, maxBitRate).
-			Set(bufsizeKey, maxBitRate)
 	}
+	sort.Strings(store[name])
 
-	outputPath := fmt.Sprintf("%s-%s.%s", basePath, params.GetPath(), extNew)
-	command := fmt.Sprintf(`ffmpeg %s %q`, params.String(), outputPath)
+	return writeLabels(dir, store)
+}
 
-	l.Printf("new path: %s", outputPath)
-	l.Printf("command: %s", command)
+func removeLabels(filePath string, labels []string) error {
+	dir, name := filepath.Dir(filePath), filepath.Base(filePath)
 
-	if dryRun {
-		return outputPath, nil
+	store, err := readLabels(dir)
+	if err != nil {
+		return err
 	}
 
-	output, err := exec(command)
-	l.Println(output)
+	var kept []string
+	for _, label := range store[name] {
+		if !containsString(labels, label) {
+			kept = append(kept, label)
+		}
+	}
+	store[name] = kept
 
-	return outputPath, err
+	return writeLabels(dir, store)
 }
 
-func (a App) reEncode(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
-	codec := c.String(codecFlag)
-	crf := c.Int(crfFlag)
-	preset := c.String(presetFlag)
-	hwaccel := c.String(hwaccelFlag)
-	hwaccelDevice := c.String(hwaccelDeviceFlag)
+// moveLabels moves (or copies) the labels of a renamed file
+func moveLabels(oldPath, newPath string, keepOld bool) error {
+	labels, err := getLabels(oldPath)
+	if err != nil || len(labels) == 0 {
+		return err
+	}
 
-	_, err := reEncode(fi, codec, crf, preset, hwaccel, hwaccelDevice, dryRun)
+	err = addLabels(newPath, labels)
+	if err != nil || keepOld {
+		return err
+	}
 
-	return err
+	return removeLabels(oldPath, labels)
 }
 
-func prefix(fi os.FileInfo, newPart string, skip int, forceOverwrite bool, dryRun bool) error {
-	filePath := fi.Name()
+func (a App) labelAdd(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
+	labels := splitList(args[0])
 
-	basePath := filepath.Base(filePath)
-	ext := filepath.Ext(filePath)
-	if ext != "" {
-		basePath = basePath[:len(basePath)-len(ext)]
+	a.log.Printf("adding labels. file: %q, labels: %s", fi.Name(), strings.Join(labels, ", "))
+	if dryRun {
+		return nil
 	}
 
-	parts := strings.Split(basePath, separator)
+	return addLabels(fi.Name(), labels)
+}
 
-	newPath := concat(parts, skip, newPart, ext, separator)
+func (a App) labelRemove(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
+	labels := splitList(args[0])
 
+	a.log.Printf("removing labels. file: %q, labels: %s", fi.Name(), strings.Join(labels, ", "))
 	if dryRun {
-		l.Println(filePath, " -> ", newPath)
-
 		return nil
 	}
 
-	return safeRename(filePath, newPath, forceOverwrite)
+	return removeLabels(fi.Name(), labels)
 }
 
-func (a App) prefix(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
-	if len(args) == 0 {
-		return nil
+func (a App) labelList(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
+	labels, err := getLabels(fi.Name())
+	if err != nil {
+		return err
 	}
 
-	newPart := args[0]
-	skip := c.Int(skipPartsFlag)
-	forceOverwrite := c.Bool(forceFlag)
+	fmt.Printf("%s: %s\n", fi.Name(), strings.Join(labels, ", "))
 
-	return prefix(fi, newPart, skip, forceOverwrite, dryRun)
+	return nil
 }
 
-func suffix(fi os.FileInfo, newPart string, skip int, forceOverwrite, dryRun bool) error {
-	filePath := fi.Name()
+const maxRating = 5
+
+var ratingRegexp = regexp.MustCompile(`^r(\d+)$`)
 
+// getRating returns the rating found in the file name as a dash-separated token (e.g. "foo-r4.mp4"), 0 if missing
+func getRating(filePath string) int {
 	basePath := filepath.Base(filePath)
-	ext := filepath.Ext(filePath)
-	if ext != "" {
-		basePath = basePath[:len(basePath)-len(ext)]
-	}
+	basePath = basePath[:len(basePath)-len(filepath.Ext(basePath))]
 
-	parts := strings.Split(basePath, separator)
-	if skip > len(parts) {
-		return fmt.Errorf("more to skip then parts present. file: %q skip: %d, parts: %d", basePath, skip, len(parts))
+	for _, part := range strings.Split(basePath, separator) {
+		if m := ratingRegexp.FindStringSubmatch(part); m != nil {
+			rating, _ := strconv.Atoi(m[1])
+
+			return rating
+		}
 	}
-	skipInverse := len(parts) - skip
 
-	newPath := concat(parts, skipInverse, newPart, ext, separator)
+	return 0
+}
 
-	if dryRun {
-		l.Println(filePath, " -> ", newPath)
+// setRatingToken inserts, updates or (for rating 0) removes the rating token of a base path
+func setRatingToken(basePath string, rating int) string {
+	var (
+		parts []string
+		found bool
+	)
+	for _, part := range strings.Split(basePath, separator) {
+		if ratingRegexp.MatchString(part) {
+			if !found && rating > 0 {
+				parts = append(parts, fmt.Sprintf("r%d", rating))
+			}
+			found = true
 
-		return nil
-	}
+			continue
+		}
 
-	return safeRename(filePath, newPath, forceOverwrite)
-}
+		parts = append(parts, part)
+	}
 
-func (a App) suffix(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
-	skip := c.Int(skipPartsFlag)
-	newPart := args[0]
-	forceOverwrite := c.Bool(forceFlag)
+	if !found && rating > 0 {
+		parts = append(parts, fmt.Sprintf("r%d", rating))
+	}
 
-	return suffix(fi, newPart, skip, forceOverwrite, dryRun)
+	return strings.Join(parts, separator)
 }
 
-func replace(fi os.FileInfo, search, replaceWith string, skip int, forceOverwrite bool, dryRun bool) error {
+func rate(st *state, fi os.FileInfo, rating int, forceOverwrite, dryRun bool) error {
+	if rating < 0 || rating > maxRating {
+		return fmt.Errorf("invalid rating, must be between 0 and %d. rating: %d", maxRating, rating)
+	}
+
 	filePath := fi.Name()
 
 	basePath := filepath.Base(filePath)
@@ -689,847 +12842,1229 @@ func replace(fi os.FileInfo, search, replaceWith string, skip int, forceOverwrit
 		basePath = basePath[:len(basePath)-len(ext)]
 	}
 
-	parts := strings.Split(basePath, search)
-	if skip > len(parts)-1 {
-		return fmt.Errorf("more to skip than found occurances. file: %q, skip: %d, found: %d", basePath, skip, len(parts)-1)
-	}
+	newPath := filepath.Join(filepath.Dir(filePath), setRatingToken(basePath, rating)+ext)
 
-	if len(parts) <= 1 {
-		// safe rename is called to handle standard logging
-		return safeRename(filePath, filePath, false)
+	if err := checkRoot(st, filePath, newPath); err != nil {
+		return err
 	}
 
-	start := strings.Join(parts[:skip+1], search)
-	end := strings.Join(parts[skip+1:], search)
-
-	newPath := start + replaceWith + end + ext
-	l.Printf(`%q -> %q, search: %q, replace with: %q`, filePath, newPath, search, replaceWith)
-
 	if dryRun {
+		planRename(st, filePath, newPath)
+
 		return nil
 	}
 
-	return safeRename(filePath, newPath, forceOverwrite)
+	return safeRename(st, filePath, newPath, forceOverwrite)
 }
 
-func (a App) replace(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
-	if len(args) < 2 {
-		return nil
-	}
-
-	search := args[0]
-	replaceWith := args[1]
-	skip := c.Int(skipFindsFlag)
+func (a App) rate(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
 	forceOverwrite := c.Bool(forceFlag)
 
-	return replace(fi, search, replaceWith, skip, forceOverwrite, dryRun)
+	rating, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid rating. rating: %s, err: %w", args[0], err)
+	}
+
+	return rate(a.state, fi, rating, forceOverwrite, dryRun)
 }
 
-func mergeParts(fi os.FileInfo, regularExpression, deleteText string, forceOverwrite, dryRun bool) error {
-	filePath := fi.Name()
+const (
+	montageTiles = 5
+	montageWidth = 160
+)
 
-	basePath := filepath.Base(filePath)
-	ext := filepath.Ext(filePath)
-	if ext != "" {
-		basePath = basePath[:len(basePath)-len(ext)]
+// createMontage renders a strip of thumbnails evenly spread over the length of a video into a PNG file
+func createMontage(st *state, fi os.FileInfo, outputPath string) error {
+	length, err := getLength(st, fi)
+	if err != nil {
+		return err
+	}
+	if length <= 0 {
+		return fmt.Errorf("invalid video length. file: %q", fi.Name())
 	}
 
-	if regularExpression == "" {
-		regularExpression = "([a-z]+)"
-	} else {
-		re := strings.Replace(strings.Replace(regularExpression, "(", "", -1), ")", "", -1)
-		if len(re) < len(regularExpression)-2 {
-			return errors.New("wrong regular expression received")
-		}
-		if len(re) == len(regularExpression) {
-			regularExpression = `(` + regularExpression + `)`
-		}
+	cmd := []string{
+		"ffmpeg", "-v", "error", "-y",
+		inputKey, fi.Name(),
+		videoFilterKey, fmt.Sprintf("fps=%f,scale=%d:-2,tile=%dx1", float64(montageTiles)/length, montageWidth, montageTiles),
+		"-frames:v", "1",
+		outputPath,
 	}
 
-	r, err := regexp.Compile(`-(\d{1,2})(` + regularExpression + `(-[a-z]+\d*)*)`)
+	output, err := exec(st, cmd)
 	if err != nil {
-		return err
+		st.log.Println(output)
+
+		return fmt.Errorf("failed to create montage. file: %q, err: %w", fi.Name(), err)
 	}
 
-	matches := r.FindAllStringSubmatch(basePath, -1)
-	var (
-		sum   int
-		extra = make([]string, len(matches))
-	)
-	for i := len(matches) - 1; i >= 0; i-- {
-		m := matches[i]
-		basePath = basePath[:len(basePath)-len(m[0])]
+	return nil
+}
 
-		s, err := strconv.ParseInt(m[1], 10, 32)
-		if err != nil {
-			return err
-		}
-		sum += int(s)
-		extra[i] = m[2]
+const (
+	imageProtocolKitty = "kitty"
+	imageProtocolITerm = "iterm"
+)
 
-		l.Printf("base: %s", basePath)
-		l.Printf("extra: %#v", extra)
-		l.Printf("matches: %#v", m)
-		l.Printf("sum: %d", sum)
-		l.Println()
+// terminalImageProtocol returns the inline image protocol supported by the terminal, empty if none is known
+func terminalImageProtocol() string {
+	switch {
+	case os.Getenv("KITTY_WINDOW_ID") != "" || strings.Contains(os.Getenv("TERM"), "kitty"):
+		return imageProtocolKitty
+	case os.Getenv("TERM_PROGRAM") == "iTerm.app" || os.Getenv("TERM_PROGRAM") == "WezTerm":
+		return imageProtocolITerm
 	}
 
-	newPath := fmt.Sprintf("%s-%d%s%s", basePath, sum, strings.Join(extra, "-"), ext)
-	if deleteText != "" {
-		newPath = strings.Replace(newPath, deleteText, "", 1)
+	return ""
+}
+
+// inlineImage returns the escape sequence displaying a PNG image in the terminal
+func inlineImage(protocol string, data []byte) string {
+	encoded := base64.StdEncoding.EncodeToString(data)
+
+	if protocol == imageProtocolITerm {
+		return fmt.Sprintf("\033]1337;File=inline=1;size=%d:%s\a", len(data), encoded)
 	}
 
-	if dryRun {
-		l.Printf(`%q -> %q`, filePath, newPath)
+	// kitty requires the payload to be sent in chunks of at most 4096 bytes
+	const chunkSize = 4096
 
-		return nil
+	sb := &strings.Builder{}
+	for i := 0; i < len(encoded); i += chunkSize {
+		end := i + chunkSize
+		more := 1
+		if end >= len(encoded) {
+			end = len(encoded)
+			more = 0
+		}
+
+		if i == 0 {
+			fmt.Fprintf(sb, "\033_Ga=T,f=100,m=%d;%s\033\\", more, encoded[i:end])
+		} else {
+			fmt.Fprintf(sb, "\033_Gm=%d;%s\033\\", more, encoded[i:end])
+		}
 	}
 
-	return safeRename(filePath, newPath, forceOverwrite)
+	return sb.String()
 }
 
-func (a App) mergeParts(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
-	regularExpression := c.String(regexpFlag)
-	deleteText := c.String(deleteTextFlag)
-	forceOverwrite := c.Bool(forceFlag)
-
-	return mergeParts(fi, regularExpression, deleteText, forceOverwrite, dryRun)
+var montageHTMLTemplate = htmltemplate.Must(htmltemplate.New("montage").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>ffr preview</title>
+<style>
+body { font-family: sans-serif; }
+td { padding: 4px 8px; vertical-align: middle; }
+</style>
+</head>
+<body>
+<table>
+<tr><th>preview</th><th>old name</th><th>new name</th></tr>
+{{- range .}}
+<tr><td>{{if .Image}}<img src="data:image/png;base64,{{.Image}}">{{end}}</td><td>{{.OldPath}}</td><td>{{.NewPath}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+type montageRow struct {
+	OldPath string
+	NewPath string
+	Image   string
 }
 
-func deleteRegexp(fi os.FileInfo, regularExpression string, regexpGroup, skipFinds, maxCount int, forceOverwrite, dryRun bool) error {
-	filePath := fi.Name()
+// previewChanges prints the planned renames of a dry run as a table, a maxNameLength of -1 makes the names fit the
+// terminal width
+func previewChanges(pairs []renamePair, maxNameLength int) {
+	if len(pairs) == 0 {
+		return
+	}
 
-	basePath := filepath.Base(filePath)
-	ext := filepath.Ext(filePath)
-	if ext != "" {
-		basePath = basePath[:len(basePath)-len(ext)]
+	if maxNameLength < 0 {
+		// both names share the width left after the padding between the columns
+		maxNameLength = (terminalWidth(os.Stdout) - 2) / 2
+		if maxNameLength < minNameLength {
+			maxNameLength = minNameLength
+		}
 	}
 
-	if regularExpression == "" {
-		regularExpression = `-\d+[a-z]+`
+	t := tabby.New()
+	t.AddHeader("OLD NAME", "NEW NAME")
+	for _, pair := range pairs {
+		t.AddLine(truncate.String(pair.oldPath, maxNameLength, truncate.Middle), truncate.String(pair.newPath, maxNameLength, truncate.Middle))
 	}
+	t.Print()
+}
 
-	r, err := regexp.Compile(regularExpression)
+// previewMontage displays a thumbnail strip for each planned rename of a video file, either inline in the terminal
+// or in an HTML page if the terminal does not support images
+func previewMontage(st *state, pairs []renamePair) error {
+	dir, err := st.workspace.MkdirTemp("", "montage-")
 	if err != nil {
 		return err
 	}
+	defer os.RemoveAll(dir)
 
-	matches := r.FindAllStringSubmatch(basePath, -1)
-	l.Printf("basePath: %s", basePath)
-	l.Printf("matches: %#v", matches)
+	protocol := terminalImageProtocol()
 
-	if len(matches) == 0 {
-		return errors.New("no matches")
-	}
+	var rows []montageRow
+	for i, pair := range pairs {
+		row := montageRow{OldPath: pair.oldPath, NewPath: pair.newPath}
 
-	matches = matches[skipFinds:]
-	for i, m := range matches {
-		if maxCount > 0 && i >= maxCount {
-			break
-		}
+		fi, err := os.Stat(pair.oldPath)
+		if err == nil && hasExtension(pair.oldPath, defaultVideoExtensions) {
+			imagePath := filepath.Join(dir, fmt.Sprintf("%d.png", i))
 
-		basePath = strings.Replace(basePath, m[regexpGroup], "", 1)
-	}
+			err = createMontage(st, withPath(fi, pair.oldPath), imagePath)
+			if err != nil {
+				st.log.Println(err)
+			} else if data, err := os.ReadFile(imagePath); err == nil {
+				row.Image = base64.StdEncoding.EncodeToString(data)
+
+				if protocol != "" {
+					fmt.Println(inlineImage(protocol, data))
+				}
+			}
+		}
 
-	newPath := basePath + ext
+		if protocol != "" {
+			fmt.Printf("%q -> %q\n", pair.oldPath, pair.newPath)
+		}
 
-	if dryRun {
-		l.Printf(`%q -> %q`, filePath, newPath)
+		rows = append(rows, row)
+	}
 
+	if protocol != "" {
 		return nil
 	}
 
-	return safeRename(filePath, newPath, forceOverwrite)
-}
+	f, err := os.CreateTemp("", "ffr-preview-*.html")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
 
-func (a App) deleteRegexp(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
-	regularExpression := c.String(regexpFlag)
-	forceOverwrite := c.Bool(forceFlag)
-	regexpGroup := c.Int(regexpGroupFlag)
-	skipFinds := c.Int(skipFindsFlag)
-	maxCount := c.Int(maxCountFlag)
+	err = montageHTMLTemplate.Execute(f, rows)
+	if err != nil {
+		return err
+	}
+
+	st.progress.Printf("preview written to: %s", f.Name())
 
-	return deleteRegexp(fi, regularExpression, regexpGroup, skipFinds, maxCount, forceOverwrite, dryRun)
+	return nil
 }
 
-func deleteParts(fi os.FileInfo, partsToDelete []int, fromBack, forceOverwrite, dryRun bool) error {
-	filePath := fi.Name()
+const reportHTML = "html"
+
+type reportRow struct {
+	OldPath    string
+	NewPath    string
+	Image      string
+	Size       string
+	SizeAfter  string
+	Codec      string
+	Dimensions string
+	Length     string
+	BitRate    string
+	Duration   string
+	FPS        string
+	Speed      string
+	MBps       string
+	Error      string
+}
 
-	basePath := filepath.Base(filePath)
-	ext := filepath.Ext(filePath)
-	if ext != "" {
-		basePath = basePath[:len(basePath)-len(ext)]
-	}
+// prefetchers return the probes to run for each file of a command before processing them one by one
+var prefetchers = map[string]func(st *state, c *cli.Context) func(fi os.FileInfo){
+	insertDimensionsCommand: func(st *state, c *cli.Context) func(fi os.FileInfo) {
+		skipDuplicate := c.Bool(skipDuplicateFlag)
 
-	parts := strings.Split(basePath, "-")
+		return func(fi os.FileInfo) {
+			if skipDuplicate && hasDimensionToken(fi.Name()) {
+				return
+			}
 
-	m := make(map[int]struct{}, len(partsToDelete))
-	for _, p := range partsToDelete {
-		p2 := p - 1
-		if fromBack {
-			p2 = len(parts) - p
+			_, _ = getDimensions(st, fi)
 		}
-		m[p2] = struct{}{}
-	}
+	},
+}
 
-	newParts := make([]string, 0, len(parts))
-	for i := 0; i < len(parts); i++ {
-		if _, ok := m[i]; !ok {
-			newParts = append(newParts, parts[i])
-		}
-	}
+// encodingMetadataKey is the key of the app metadata listing the encoding commands, whose throughput is worth measuring
+const encodingMetadataKey = "encodingCommands"
 
-	newPath := strings.Join(newParts, "-") + ext
+// commandSet is a set of command names
+type commandSet map[string]bool
 
-	if dryRun {
-		l.Printf(`%q -> %q`, filePath, newPath)
+// mark adds a command to the set, so that commands can be marked where they are defined
+func (s commandSet) mark(cmd *cli.Command) *cli.Command {
+	s[cmd.Name] = true
 
-		return nil
-	}
+	return cmd
+}
+
+// isEncodingCommand checks if the command run is marked as an encoding command in the definition of the app
+func isEncodingCommand(c *cli.Context) bool {
+	encoders, _ := c.App.Metadata[encodingMetadataKey].(commandSet)
 
-	return safeRename(filePath, newPath, forceOverwrite)
+	return encoders[c.Command.Name]
 }
 
-func (a App) deleteParts(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
-	forceOverwrite := c.Bool(forceFlag)
-	fromBack := c.Bool(fromBackFlag)
+type throughput struct {
+	fps   float64
+	speed float64
+	mbps  float64
+}
 
-	strList := strings.Split(args[0], ",")
-	partsToDelete := make([]int, 0, len(strList))
-	for _, str := range strList {
-		num, err := strconv.ParseInt(str, 10, 32)
-		if err != nil {
-			panic(err)
-		}
+// getThroughput calculates the encoding frames per second, the speed factor compared to realtime playback and the
+// megabytes of input processed per second
+func getThroughput(length, frameRate float64, size int64, elapsed time.Duration) throughput {
+	seconds := elapsed.Seconds()
+	if seconds <= 0 {
+		return throughput{}
+	}
 
-		partsToDelete = append(partsToDelete, int(num))
+	return throughput{
+		fps:   length * frameRate / seconds,
+		speed: length / seconds,
+		mbps:  float64(size) / 1000 / 1000 / seconds,
 	}
+}
 
-	return deleteParts(fi, partsToDelete, fromBack, forceOverwrite, dryRun)
+func (t throughput) String() string {
+	return fmt.Sprintf("%.1f fps, %.1fx, %.1f MB/s", t.fps, t.speed, t.mbps)
 }
 
-func addNumber(fi os.FileInfo, regularExpression string, numberToAdd int64, regexpGroup, skipFinds, maxCount int, forceOverwrite, dryRun bool) error {
-	filePath := fi.Name()
+// batchReport collects what a batch run did for a shareable report. A nil report collects nothing.
+type batchReport struct {
+	format  string
+	command string
+	started time.Time
+	dir     string
+	rows    []reportRow
+}
 
-	basePath := filepath.Base(filePath)
-	ext := filepath.Ext(filePath)
-	if ext != "" {
-		basePath = basePath[:len(basePath)-len(ext)]
+func newBatchReport(format, command string) *batchReport {
+	if format == "" {
+		return nil
 	}
 
-	if regularExpression == "" {
-		regularExpression = `-(\d+)[a-z]+`
-		regexpGroup = 1
+	return &batchReport{
+		format:  format,
+		command: command,
+		started: time.Now(),
 	}
+}
 
-	r, err := regexp.Compile(regularExpression)
-	if err != nil {
-		return err
+// Probe collects the information about a file before it is processed
+func (r *batchReport) Probe(st *state, fi os.FileInfo) reportRow {
+	row := reportRow{OldPath: fi.Name()}
+	if r == nil {
+		return row
 	}
 
-	matches := r.FindAllStringSubmatch(basePath, -1)
-	l.Printf("basePath: %s", basePath)
-	l.Printf("matches: %#v", matches)
+	row.Size = formatUnits(st, fi.Size(), " ", "B")
 
-	if len(matches) == 0 {
-		return errors.New("no matches")
+	if !hasExtension(fi.Name(), defaultVideoExtensions) {
+		return row
 	}
 
-	matches = matches[skipFinds:]
-	for i, m := range matches {
-		if maxCount > 0 && i >= maxCount {
-			break
-		}
+	v := info(st, fi, true)
+	row.Codec = v.codec
+	row.Dimensions = fmt.Sprintf("%dx%d", v.width, v.height)
+	row.Length = formatDuration(st, v.length)
+	row.BitRate = formatUnits(st, v.bitRate, " ", "bit")
 
-		numberFound, err := strconv.ParseInt(m[regexpGroup], 10, 32)
+	if r.dir == "" {
+		dir, err := st.workspace.MkdirTemp("", "report-")
 		if err != nil {
-			return err
-		}
-
-		n1 := strconv.Itoa(int(numberFound))
-		n2 := strconv.Itoa(int(numberFound + numberToAdd))
-		replaceWith := strings.Replace(m[0], n1, n2, 1)
+			st.log.Printf("failed to create temporary directory. err: %s", err)
 
-		basePath = strings.Replace(basePath, m[0], replaceWith, 1)
+			return row
+		}
+		r.dir = dir
 	}
 
-	newPath := basePath + ext
+	imagePath := filepath.Join(r.dir, fmt.Sprintf("%d.png", len(r.rows)))
 
-	if dryRun {
-		l.Printf(`%q -> %q`, filePath, newPath)
+	err := createMontage(st, fi, imagePath)
+	if err != nil {
+		st.log.Println(err)
 
-		return nil
+		return row
 	}
 
-	return safeRename(filePath, newPath, forceOverwrite)
+	data, err := os.ReadFile(imagePath)
+	if err == nil {
+		row.Image = base64.StdEncoding.EncodeToString(data)
+	}
+
+	return row
 }
 
-func (a App) addNumber(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
-	regularExpression := c.String(regexpFlag)
-	forceOverwrite := c.Bool(forceFlag)
-	regexpGroup := c.Int(regexpGroupFlag)
-	skipFinds := c.Int(skipFindsFlag)
-	maxCount := c.Int(maxCountFlag)
+// Add completes a row with the results of processing the file
+func (r *batchReport) Add(st *state, row reportRow, fileChanges []renamePair, elapsed time.Duration, err error) {
+	if r == nil {
+		return
+	}
 
-	numberToAdd, err := strconv.ParseInt(args[0], 10, 32)
-	if err != nil {
-		return err
+	for _, change := range fileChanges {
+		row.NewPath = change.newPath
 	}
 
-	return addNumber(fi, regularExpression, numberToAdd, regexpGroup, skipFinds, maxCount, forceOverwrite, dryRun)
-}
+	if row.NewPath != "" {
+		if fi, err := os.Stat(row.NewPath); err == nil {
+			row.SizeAfter = formatUnits(st, fi.Size(), " ", "B")
+		}
+	}
 
-func insertBefore(fi os.FileInfo, regularExpression, insertText string, skipDuplicate, skipDashPrefix, forceOverwrite, dryRun bool) error {
-	filePath := fi.Name()
+	if elapsed > 0 {
+		row.Duration = elapsed.Round(time.Millisecond).String()
+	}
 
-	if regularExpression == "" {
-		regularExpression = "\\d+[a-z]+"
+	if err != nil {
+		row.Error = err.Error()
 	}
 
-	if skipDuplicate && strings.Contains(filePath, insertText) {
-		l.Printf(`skipping as duplicate is found. needle: %q, haystack: %q`, insertText, filePath)
+	r.rows = append(r.rows, row)
+}
 
+var reportHTMLTemplate = htmltemplate.Must(htmltemplate.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>ffr {{.Command}} report</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { padding: 4px 8px; border-bottom: 1px solid #ddd; text-align: left; vertical-align: middle; }
+.error { color: #b00; }
+</style>
+</head>
+<body>
+<h1>ffr {{.Command}}</h1>
+<p>started: {{.Started}}, total time: {{.Duration}}, files: {{len .Rows}}</p>
+<table>
+<tr><th>preview</th><th>old name</th><th>new name</th><th>size</th><th>codec</th><th>dimensions</th><th>length</th><th>bit rate</th><th>time</th><th>fps</th><th>speed</th><th>MB/s</th><th>new size</th><th>error</th></tr>
+{{- range .Rows}}
+<tr><td>{{if .Image}}<img src="data:image/png;base64,{{.Image}}">{{end}}</td><td>{{.OldPath}}</td><td>{{.NewPath}}</td><td>{{.Size}}</td><td>{{.Codec}}</td><td>{{.Dimensions}}</td><td>{{.Length}}</td><td>{{.BitRate}}</td><td>{{.Duration}}</td><td>{{.FPS}}</td><td>{{.Speed}}</td><td>{{.MBps}}</td><td>{{.SizeAfter}}</td><td class="error">{{.Error}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+// Write renders the report into a standalone file
+func (r *batchReport) Write(st *state, path string, total time.Duration) error {
+	if r == nil {
 		return nil
 	}
 
-	basePath := filepath.Base(filePath)
-	ext := filepath.Ext(filePath)
-	if ext != "" {
-		basePath = basePath[:len(basePath)-len(ext)]
+	if r.dir != "" {
+		defer os.RemoveAll(r.dir)
 	}
 
-	regularExpression = "(" + regularExpression + ")"
-	if !skipDashPrefix {
-		regularExpression = "-" + regularExpression
-	}
-	r, err := regexp.Compile(regularExpression)
-	if err != nil {
-		return fmt.Errorf("regexp failed, err: %w", err)
+	if r.format != reportHTML {
+		return fmt.Errorf("invalid report format. format: %s", r.format)
 	}
-	matched := r.FindAllStringSubmatch(basePath, -1)
 
-	// fallback in case of no match is to insert text at the end of the string
-	newPath := basePath + "-" + insertText + ext
-	if len(matched) > 0 {
-		insertText += "-" + matched[len(matched)-1][1]
-		newPath = strings.Replace(basePath, matched[len(matched)-1][1], insertText, 1) + ext
+	if path == "" {
+		path = fmt.Sprintf("ffr-report-%s.html", r.started.Format("20060102-150405"))
 	}
 
-	l.Printf(`%q -> %q, found: %q, new: %q`, filePath, newPath, matched, insertText)
-
-	if dryRun {
-		return nil
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create report. path: %q, err: %w", path, err)
+	}
+	defer f.Close()
+
+	err = reportHTMLTemplate.Execute(f, map[string]interface{}{
+		"Command":  r.command,
+		"Started":  r.started.Format(time.RFC1123),
+		"Duration": total.Round(time.Millisecond).String(),
+		"Rows":     r.rows,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write report. path: %q, err: %w", path, err)
 	}
 
-	return safeRename(filePath, newPath, forceOverwrite)
+	st.progress.Printf("report written to: %s", path)
+
+	return nil
 }
 
-func (a App) insertBefore(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
-	regularExpression := c.String(regexpFlag)
-	skipDashPrefix := c.Bool(skipDashPrefixFlag)
-	skipDuplicate := c.Bool(skipDuplicateFlag)
-	insert := args[1]
+// summaryTimeout limits how long sending the batch summary may take
+const summaryTimeout = 30 * time.Second
 
-	forceOverwrite := c.Bool(forceFlag)
+// smtpPasswordEnv is the environment variable holding the SMTP password, so that it does not show up in the command
+// history and in process lists
+const smtpPasswordEnv = "FFR_SMTP_PASSWORD"
 
-	return insertBefore(fi, regularExpression, insert, skipDuplicate, skipDashPrefix, forceOverwrite, dryRun)
+// summaryFailure is a file which failed to be processed in a batch
+type summaryFailure struct {
+	File  string `json:"file"`
+	Error string `json:"error"`
 }
 
-var wellKnown = map[string]string{
-	"640x480":   "sd-480p",
-	"1280x720":  "hd-720p",
-	"1920x1080": "fullhd-1080p",
-	"2560x1440": "qhd-1440p",
-	"2048x1080": "2k-1080p",
-	"3840x2160": "4k-2160p",
-	"7680x4320": "8k-4320p",
+// batchSummary is the end-of-batch summary sent to a webhook or via email for unattended runs. A nil summary collects
+// and sends nothing.
+type batchSummary struct {
+	Command    string           `json:"command"`
+	Host       string           `json:"host"`
+	DryRun     bool             `json:"dryRun"`
+	Started    time.Time        `json:"started"`
+	WallTime   string           `json:"wallTime"`
+	Processed  int              `json:"processed"`
+	Failed     int              `json:"failed"`
+	Remaining  int              `json:"remaining,omitempty"`
+	SizeBefore int64            `json:"sizeBefore"`
+	SizeAfter  int64            `json:"sizeAfter"`
+	SizeSaved  int64            `json:"sizeSaved"`
+	Failures   []summaryFailure `json:"failures,omitempty"`
 }
 
-var dimensionsRegexp = regexp.MustCompile(`\d+x\d+$`)
+func newBatchSummary(command string, dryRun, enabled bool) *batchSummary {
+	if !enabled {
+		return nil
+	}
 
-func getDimensions(fi os.FileInfo) (string, error) {
-	fp := strings.Replace(fi.Name(), " ", "\\ ", -1)
-	fp = strings.Replace(fp, "'", "\\'", -1)
-	cmd := fmt.Sprintf(`ffprobe -v error -select_streams v:0 -show_entries stream=width,height -of csv=s=x:p=0 %s`, fp)
+	host, _ := os.Hostname()
 
-	dimensions, err := exec(cmd)
-	if err != nil {
-		return "", fmt.Errorf("failed to probe file. command: %q, err: %w", cmd, err)
+	return &batchSummary{
+		Command: command,
+		Host:    host,
+		DryRun:  dryRun,
+		Started: time.Now(),
 	}
+}
 
-	if dimensions == "" {
-		return "", fmt.Errorf("failed to probe file, output was empty or invalid. command: %q", cmd)
+// Add counts a processed file, comparing its size to the size of the files created from it
+func (s *batchSummary) Add(fi os.FileInfo, fileOutputs []string, err error) {
+	if s == nil {
+		return
 	}
 
-	dimensions = strings.TrimSpace(dimensions)
-
-	dimensions = dimensionsRegexp.FindString(dimensions)
-
-	if dimensions == "" {
-		return "", fmt.Errorf("failed to probe file, output was empty or invalid. command: %q", cmd)
+	s.Processed++
+	if err != nil {
+		s.AddFailure(fi.Name(), err)
+		return
 	}
 
-	return dimensions, nil
-}
+	var after int64
+	for _, output := range fileOutputs {
+		ofi, err := os.Stat(output)
+		if err != nil {
+			return
+		}
+		after += ofi.Size()
+	}
 
-func insertDimensionsBefore(fi os.FileInfo, regularExpression string, skipDuplicatePrefix, skipDashPrefix, forceOverwrite, dryRun bool) error {
-	dimensions, err := getDimensions(fi)
-	if err != nil {
-		return err
+	if len(fileOutputs) > 0 {
+		s.SizeBefore += fi.Size()
+		s.SizeAfter += after
+		s.SizeSaved = s.SizeBefore - s.SizeAfter
 	}
+}
 
-	if found, ok := wellKnown[dimensions]; ok {
-		dimensions = found
+// AddFailure records a failed file, e.g. a failed upload of an output
+func (s *batchSummary) AddFailure(path string, err error) {
+	if s == nil {
+		return
 	}
 
-	return insertBefore(fi, regularExpression, dimensions, skipDuplicatePrefix, skipDashPrefix, forceOverwrite, dryRun)
+	s.Failed++
+	s.Failures = append(s.Failures, summaryFailure{File: path, Error: err.Error()})
 }
 
-var dateRegexp1 = regexp.MustCompile(`20\d{6}`)
-var dateRegexp2 = regexp.MustCompile(`\d{6}`)
-var dateFormat1 = "20060102"
-var dateFormat2 = "060102"
-var dateFormat3 = "2006.01.02"
+func (s *batchSummary) Subject() string {
+	subject := fmt.Sprintf("ffr %s on %s: %d processed, %d failed", s.Command, s.Host, s.Processed, s.Failed)
+	if s.DryRun {
+		subject += " (dry-run)"
+	}
 
-func prefixDate(fi os.FileInfo, forceOverwrite, dryRun bool) error {
-	filePath := fi.Name()
+	return subject
+}
 
-	basePath := filepath.Base(filePath)
-	ext := filepath.Ext(filePath)
-	if ext != "" {
-		basePath = basePath[:len(basePath)-len(ext)]
+func (s *batchSummary) Text(st *state) string {
+	sb := &strings.Builder{}
+	fmt.Fprintln(sb, s.Subject())
+	fmt.Fprintf(sb, "started: %s, wall time: %s\n", s.Started.Format(time.RFC1123), s.WallTime)
+	if s.SizeBefore > 0 {
+		fmt.Fprintf(sb, "size: %s -> %s, saved: %s\n", formatUnits(st, s.SizeBefore, " ", "B"), formatUnits(st, s.SizeAfter, " ", "B"), formatUnits(st, s.SizeSaved, " ", "B"))
+	}
+	if s.Remaining > 0 {
+		fmt.Fprintf(sb, "stopped early, %d file(s) left for resume\n", s.Remaining)
+	}
+	if len(s.Failures) > 0 {
+		fmt.Fprintln(sb, "\nfailures:")
+		for _, f := range s.Failures {
+			fmt.Fprintf(sb, "  %s: %s\n", f.File, f.Error)
+		}
 	}
 
-	matches := dateRegexp1.FindAllString(basePath, -1)
-	format := dateFormat1
-	l.Printf("basePath: %s", basePath)
-	l.Printf("matches: %#v", matches)
+	return sb.String()
+}
 
-	if len(matches) == 0 {
-		matches = dateRegexp2.FindAllString(basePath, -1)
-		format = dateFormat2
-		l.Printf("basePath: %s", basePath)
-		l.Printf("matches: %#v", matches)
+// smtpConfig defines how the batch summary is emailed
+type smtpConfig struct {
+	to     []string
+	from   string
+	server string
+	user   string
+}
 
-		if len(matches) == 0 {
-			return errors.New("no matches")
-		}
+func newSMTPConfig(c *cli.Context) smtpConfig {
+	cfg := smtpConfig{
+		from:   c.String(smtpFromFlag),
+		server: c.String(smtpServerFlag),
+		user:   c.String(smtpUserFlag),
 	}
 
-	if len(matches) > 1 {
-		return errors.New("too many matches")
+	for _, to := range strings.Split(c.String(summaryEmailFlag), ",") {
+		if to = strings.TrimSpace(to); to != "" {
+			cfg.to = append(cfg.to, to)
+		}
 	}
 
-	parsedDate, err := time.Parse(format, matches[0])
-	if err != nil {
-		return fmt.Errorf("failed to parse date. err: %w", err)
+	if cfg.from == "" {
+		host, _ := os.Hostname()
+		cfg.from = fmt.Sprintf("ffr@%s", host)
 	}
 
-	newPath := parsedDate.Format(dateFormat3) + "-" + basePath + ext
+	return cfg
+}
 
-	if dryRun {
-		l.Printf(`%q -> %q`, filePath, newPath)
+// message returns the email of the summary
+func (cfg smtpConfig) message(st *state, s *batchSummary) []byte {
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "From: %s\r\n", cfg.from)
+	fmt.Fprintf(sb, "To: %s\r\n", strings.Join(cfg.to, ", "))
+	fmt.Fprintf(sb, "Subject: %s\r\n", s.Subject())
+	fmt.Fprintf(sb, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	sb.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	sb.WriteString(strings.ReplaceAll(s.Text(st), "\n", "\r\n"))
+
+	return []byte(sb.String())
+}
 
+// Send posts the summary as JSON to webhook and emails it as configured
+func (s *batchSummary) Send(st *state, webhook string, cfg smtpConfig, total time.Duration) error {
+	if s == nil {
 		return nil
 	}
 
-	return safeRename(filePath, newPath, forceOverwrite)
-}
-
-func (a App) datePrefix(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
-	forceOverwrite := c.Bool(forceFlag)
+	s.WallTime = total.Round(time.Second).String()
 
-	return prefixDate(fi, forceOverwrite, dryRun)
-}
+	var errs []error
+	if webhook != "" {
+		errs = append(errs, postSummary(st, webhook, s))
+	}
 
-func (a App) insertDimensionsBefore(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
-	regularExpression := c.String(regexpFlag)
-	skipDashPrefix := c.Bool(skipDashPrefixFlag)
-	skipDuplicatePrefix := c.Bool(skipDuplicateFlag)
-	forceOverwrite := c.Bool(forceFlag)
+	if len(cfg.to) > 0 {
+		errs = append(errs, mailSummary(st, cfg, s))
+	}
 
-	return insertDimensionsBefore(fi, regularExpression, skipDuplicatePrefix, skipDashPrefix, forceOverwrite, dryRun)
+	return errors.Join(errs...)
 }
 
-func parseDimensions(dimensions string) (int, int, error) {
-	d := strings.Split(dimensions, "x")
-	if len(d) != 2 {
-		return 0, 0, fmt.Errorf("wrong old dimensions: %s", dimensions)
+func postSummary(st *state, webhook string, s *batchSummary) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("failed to encode summary. err: %w", err)
 	}
 
-	widthOrigin, err := strconv.Atoi(d[0])
+	client := &http.Client{Timeout: summaryTimeout}
+	resp, err := client.Post(webhook, "application/json", strings.NewReader(string(data)))
 	if err != nil {
-		return 0, 0, fmt.Errorf("wrong old dimensions: %s", dimensions)
+		return fmt.Errorf("failed to send summary to webhook. url: %q, err: %w", webhook, err)
 	}
+	defer resp.Body.Close()
 
-	heightOrigin, err := strconv.Atoi(d[1])
-	if err != nil {
-		return 0, 0, fmt.Errorf("wrong old dimensions: %s", dimensions)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook refused summary. url: %q, status: %s", webhook, resp.Status)
 	}
 
-	return widthOrigin, heightOrigin, nil
+	st.progress.Printf("summary sent to webhook: %s", webhook)
+
+	return nil
 }
 
-func crop(fi os.FileInfo, width, height int, x, y, dimensionPreset string, forceOverwrite, dryRun bool) error {
-	basePath := filepath.Base(fi.Name())
-	ext := filepath.Ext(fi.Name())
-	if ext != "" {
-		basePath = basePath[:len(basePath)-len(ext)]
+func mailSummary(st *state, cfg smtpConfig, s *batchSummary) error {
+	var auth smtp.Auth
+	if cfg.user != "" {
+		host, _, _ := strings.Cut(cfg.server, ":")
+		auth = smtp.PlainAuth("", cfg.user, os.Getenv(smtpPasswordEnv), host)
 	}
 
-	switch dimensionPreset {
-	case eightKPreset, eightKPreset2:
-		width = eightKWidth
-		height = eightKHeight
-	case fourKPreset, fourKPreset2:
-		width = fourKWidth
-		height = fourKHeight
-	case qHDPreset, qHDPreset2:
-		width = qHDWidth
-		height = qHDHeight
-	case twoKPreset:
-		width = twoKWidth
-		height = twoKHeight
-	case fullHDPreset, fullHDPreset2:
-		width = fullHDWidth
-		height = fullHDHeight
-	case hdPreset, hdPreset2:
-		width = hdWidth
-		height = hdHeight
-	case sdPreset, sdPreset2:
-		width = sdWidth
-		height = sdHeight
+	err := smtp.SendMail(cfg.server, auth, cfg.from, cfg.to, cfg.message(st, s))
+	if err != nil {
+		return fmt.Errorf("failed to email summary. server: %s, to: %s, err: %w", cfg.server, strings.Join(cfg.to, ", "), err)
 	}
 
-	l.Printf("preset: %s, width: %d, height: %d", dimensionPreset, width, height)
+	st.progress.Printf("summary emailed to: %s", strings.Join(cfg.to, ", "))
 
-	if width == 0 || height == 0 {
-		return fmt.Errorf("wrong dimensions. width: %d, height: %d", width, height)
-	}
+	return nil
+}
+
+// commandExample is a runnable example invocation of a command
+type commandExample struct {
+	Description string
+	Command     string
+	Result      string
+}
+
+// commandExamples are the curated examples of the commands, shown in the help of the commands and by the examples
+// command. Tests check that every example parses with the flags of its command.
+var commandExamples = map[string][]commandExample{
+	addNumberCommand: {
+		{
+			Description: "Increment the first number in the file name 'foo-1080p-2ffc.mp4'",
+			Command:     "ffr add-number 2 foo-1080p-2ffc.mp4",
+			Result:      "foo-1082p-2ffc.mp4",
+		},
+		{
+			Description: "Increment the number in '2ffc' in the file name 'foo-1080p-2ffc.mp4'",
+			Command:     `ffr add-number --regular-expression '(\d+)ffc' --regexp-group 1 2 foo-1080p-2ffc.mp4`,
+			Result:      "foo-1080p-4ffc.mp4",
+		},
+		{
+			Description: "Increment a zero-padded episode number",
+			Command:     `ffr add-number --regular-expression 'e(\d+)' --regexp-group 1 2 foo-e007.mp4`,
+			Result:      "foo-e009.mp4",
+		},
+		{
+			Description: "Double a decimal number",
+			Command:     "ffr add-number --multiply 2 foo-1.5h.mp4",
+			Result:      "foo-3.0h.mp4",
+		},
+	},
+	deletePartsCommand: {
+		{
+			Description: "Delete the first and third segments in the file name 'foo-bar-baz-2ffc.mp4'",
+			Command:     "ffr delete-parts 1,3 foo-bar-baz-2ffc.mp4",
+			Result:      "bar-2ffc.mp4",
+		},
+		{
+			Description: "Delete the last and the third last segments in the file name 'foo-bar-baz-2ffc.mp4'",
+			Command:     "ffr delete-parts --fb 1,3 foo-bar-baz-2ffc.mp4",
+			Result:      "foo-baz.mp4",
+		},
+	},
+	previewClipCommand: {
+		{
+			Description: "Extract a ten second preview starting around 1:15",
+			Command:     "ffr preview-clip 1:15 foo.mp4",
+			Result:      "foo-preview-74s.mp4",
+		},
+		{
+			Description: "Extract 30 second previews around the middle of hour long recordings",
+			Command:     "ffr preview-clip --clip-length 30 0:30:00 *.mkv",
+			Result:      "bar-preview-1800s.mkv, baz-preview-1798s.mkv, ...",
+		},
+	},
+	mergePartsCommand: {
+		{
+			Description: "Sum every numeric tag, the tags of the merged parts are kept after the sum",
+			Command:     "ffr merge-parts foo-1bar-2baz.mp4",
+			Result:      "foo-3bar-baz.mp4",
+		},
+		{
+			Description: "Sum the ffc tags only, other parts keep their places",
+			Command:     "ffr merge-parts --tags ffc foo-1ffc-2pro-1.5ffc.mp4",
+			Result:      "foo-2.5ffc-ffc-2pro.mp4",
+		},
+		{
+			Description: "Sum durations, written in the largest unit they fit in",
+			Command:     "ffr merge-parts --time-units foo-1.5h-30m.mp4",
+			Result:      "foo-2h.mp4",
+		},
+	},
+	normalizeNumbersCommand: {
+		{
+			Description: "Convert a roman numeral and a number written in words",
+			Command:     "ffr normalize-numbers foo-part-II-take-three.mp4",
+			Result:      "foo-part-2-take-3.mp4",
+		},
+		{
+			Description: "Zero-pad the converted numbers",
+			Command:     `ffr normalize-numbers --padding 2 "Season Four.mp4"`,
+			Result:      "Season 04.mp4",
+		},
+	},
+	normalizeUnicodeCommand: {
+		{
+			Description: "Fix a name decoded as Windows-1252",
+			Command:     `ffr normalize-unicode "JosÃ©.mp4"`,
+			Result:      "José.mp4",
+		},
+		{
+			Description: "Transliterate to ASCII",
+			Command:     `ffr normalize-unicode --ascii "Árvíztűrő Straße.mp4"`,
+			Result:      "Arvizturo Strasse.mp4",
+		},
+	},
+	padNumbersCommand: {
+		{
+			Description: "Pad every number to two digits",
+			Command:     "ffr pad-numbers 2 foo-s1-e7.mp4",
+			Result:      "foo-s01-e07.mp4",
+		},
+		{
+			Description: "Pad the episode numbers only",
+			Command:     `ffr pad-numbers --regular-expression 'e\d+' 3 foo-s1-e7.mp4`,
+			Result:      "foo-s1-e007.mp4",
+		},
+		{
+			Description: "Pad the last number only",
+			Command:     "ffr pad-numbers --position 1 --fb 2 foo-2-3.mp4",
+			Result:      "foo-2-03.mp4",
+		},
+	},
+	reencodeCommand: {
+		{
+			Description: "Fit a clip into the upload limit of Discord, in two passes and scaled down if needed",
+			Command:     "ffr reencode --target-size discord foo.mov",
+			Result:      "foo-discord.mp4",
+		},
+		{
+			Description: "Fit a clip into 8MB without going above 720p",
+			Command:     "ffr reencode --target-size 8MB --max-height 720 foo.mov",
+			Result:      "foo-8MB.mp4",
+		},
+		{
+			Description: "Check the output names and the ffmpeg commands before encoding",
+			Command:     "ffr reencode --print-output-names foo.mp4",
+			Result:      "foo-libx265-23-ultrafast.mp4 and the ffmpeg command are printed, nothing is encoded",
+		},
+		{
+			Description: "Name the outputs after the encoder and the CRF only",
+			Command:     "ffr reencode --name '{{.Base}}-{{.Encoder}}-crf{{.CRF}}' foo.mp4",
+			Result:      "foo-libx265-crf23.mp4",
+		},
+		{
+			Description: "Keep the original names, writing the outputs to another directory",
+			Command:     "ffr reencode --no-suffix --output-dir encoded foo.mov",
+			Result:      "encoded/foo.mp4",
+		},
+		{
+			Description: "Keep the container of the originals",
+			Command:     "ffr reencode --container same foo.mkv",
+			Result:      "foo-libx265-23-ultrafast.mkv",
+		},
+	},
+	overlayTextCommand: {
+		{
+			Description: "Burn the file name and the timecode into a review copy",
+			Command:     "ffr overlay-text filename,timecode foo.mp4",
+			Result:      "foo-review.mp4",
+		},
+		{
+			Description: "Mark review copies as drafts in the top right corner",
+			Command:     "ffr overlay-text --text 'DRAFT v2' --placement top-right --no-box text foo.mp4",
+			Result:      "foo-review.mp4",
+		},
+	},
+	langTagCommand: {
+		{
+			Description: "Tag all streams missing a language as Hungarian",
+			Command:     "ffr lang-tag --lang hun foo.mkv",
+			Result:      "foo-lang.mkv",
+		},
+		{
+			Description: "Tag the second audio stream as English and detect the language of the subtitles",
+			Command:     "ffr lang-tag --lang a:1=eng --detect foo.mkv",
+			Result:      "foo-lang.mkv",
+		},
+	},
+	eachCommand: {
+		{
+			Description: "Convert videos to grayscale, keeping the audio",
+			Command:     "ffr each --tag gray *.mp4 -- -i {in} -vf hue=s=0 -c:a copy {out}",
+			Result:      "foo-gray.mp4 for every foo.mp4",
+		},
+	},
+	proxyCommand: {
+		{
+			Description: "Create DNxHR LB proxies for an editor",
+			Command:     "ffr proxy --proxy-format dnxhr footage/*.mp4",
+			Result:      "footage/Proxy/clip-1.mov, footage/Proxy/clip-2.mov, ...",
+		},
+	},
+	restoreSDCommand: {
+		{
+			Description: "Restore digitized home videos, the scan type and the borders are detected for each file",
+			Command:     "ffr restore-sd tapes/*.avi",
+			Result:      "tapes/tape-1-restored.mkv, tapes/tape-2-restored.mkv, ...",
+		},
+		{
+			Description: "Restore a DVD rip with x264 at a higher quality",
+			Command:     "ffr restore-sd --codec libx264 --crf 18 MY_MOVIE-title-01.mkv",
+			Result:      "MY_MOVIE-title-01-restored.mkv",
+		},
+	},
+	ingestDiscCommand: {
+		{
+			Description: "Ingest a DVD copied to disk",
+			Command:     "ffr ingest-disc MY_MOVIE",
+			Result:      "MY_MOVIE-title-01.mkv from MY_MOVIE/VIDEO_TS/VTS_01_1.VOB, VTS_01_2.VOB, ...",
+		},
+		{
+			Description: "Ingest the main playlist of a Blu-ray",
+			Command:     "ffr ingest-disc --min-length 3600 MY_MOVIE/BDMV",
+			Result:      "MY_MOVIE-title-800.mkv from the clips of MY_MOVIE/BDMV/PLAYLIST/00800.mpls",
+		},
+	},
+	archiveCommand: {
+		{
+			Description: "Archive a capture with FFV1",
+			Command:     "ffr archive capture.avi",
+			Result:      "capture-archive-ffv1.mkv, verified frame by frame via framemd5",
+		},
+	},
+	cleanupRecordingsCommand: {
+		{
+			Description: "Clean up a macOS screen recording",
+			Command:     "ffr cleanup-recordings 'Screen Recording 2024-01-02 at 10.11.12.mov'",
+			Result:      "2024.01.02-101112-screen-recording.mp4",
+		},
+	},
+	organizeCommand: {
+		{
+			Description: "Move a file into a directory based on the date in its name",
+			Command:     "ffr organize 2024.06.12-foo.mp4",
+			Result:      "2024/2024-06/2024.06.12-foo.mp4",
+		},
+		{
+			Description: "Move a file into a directory based on its codec and resolution",
+			Command:     "ffr organize --template '{{.Codec}}/{{.Resolution}}' foo.mp4",
+			Result:      "hevc/fullhd-1080p/foo.mp4",
+		},
+	},
+	streamHashCommand: {
+		{
+			Description: "Verify a remux",
+			Command:     "ffr streamhash --compare foo.mp4 foo.mkv",
+			Result:      "the hashes of the streams are printed and an error is returned if they differ",
+		},
+		{
+			Description: "Find the first differing frame",
+			Command:     "ffr streamhash --compare --frames foo.mp4 foo-archive-ffv1.mkv",
+			Result:      "the first differing frame of each differing stream is reported",
+		},
+	},
+	contentIDCommand: {
+		{
+			Description: "Tag files with their content IDs",
+			Command:     "ffr content-id foo.mp4 bar.mkv",
+			Result:      "foo-id3fa2c91b.mp4, bar-id77e0a41d.mkv",
+		},
+	},
+	checkNewCommand: {
+		{
+			Description: "Index a directory and check new files against it",
+			Command:     "ffr check-new --update-index videos downloads/*",
+			Result:      "downloads/Holiday 2023.MP4 is reported as a duplicate of videos/holiday-2023.mp4",
+		},
+		{
+			Description: "Check new files against the index kept up to date by earlier runs",
+			Command:     "ffr check-new --format json videos downloads/*",
+			Result:      "the problems are listed as json and an error is returned if there are any",
+		},
+	},
+	lintNamesCommand: {
+		{
+			Description: "Check the names of a collection",
+			Command:     "ffr lint-names videos/*",
+			Result:      "the violations are listed and an error is returned if there are any",
+		},
+		{
+			Description: "Fix the names which can be fixed automatically",
+			Command:     "ffr lint-names --fix videos/*",
+			Result:      "videos/Holiday 2023.07.14.mp4 -> videos/2023.07.14-holiday.mp4, ...",
+		},
+	},
+	stageCommand: {
+		{
+			Description: "Stage the renames of a command",
+			Command:     "ffr stage prefix holiday- 2023/*.mp4",
+			Result:      "the renames are planned and staged, no files are renamed yet",
+		},
+		{
+			Description: "Stage more renames for the same reorganization",
+			Command:     "ffr stage organize --template '{{.Codec}}/{{.Resolution}}' 2022/*.mp4",
+			Result:      "the renames are added to the ones staged before, conflicting renames are refused",
+		},
+	},
+	rateCommand: {
+		{
+			Description: "Rate a file with 4 stars",
+			Command:     "ffr rate 4 foo-bar.mp4",
+			Result:      "foo-bar-r4.mp4",
+		},
+		{
+			Description: "Process files rated with at least 4 stars only",
+			Command:     "ffr --filter 'rating>=4' prefix best *.mp4",
+			Result:      "best-foo-bar-r4.mp4, files rated below 4 are skipped",
+		},
+	},
+	flattenCommand: {
+		{
+			Description: "Flatten the current directory",
+			Command:     "ffr flatten",
+			Result:      "paris/day-1/foo.mp4 is renamed to paris-day-1-foo.mp4, paris/day-1 and paris are removed",
+		},
+	},
+	renameFromFileCommand: {
+		{
+			Description: "Apply an edited dry-run plan",
+			Command:     "ffr --dryRun --result json prefix foo *.mp4 > plan.jsonl && ffr rename-from-file plan.jsonl",
+			Result:      "the files are renamed as planned, including the edits made to plan.jsonl",
+		},
+	},
+	mirrorNamesCommand: {
+		{
+			Description: "Name subtitles after the videos they belong to",
+			Command:     "ffr mirror-names --target-ext srt,ass videos subs",
+			Result:      "subs/01.srt is renamed to subs/paris-day-1.srt if videos/paris-day-1.mp4 is the first video",
+		},
+		{
+			Description: "Sync a folder of lower quality renditions",
+			Command:     "ffr mirror-names --match duration videos videos-720p",
+			Result:      "the renditions are renamed after the videos having the same length",
+		},
+	},
+	matchSubsCommand: {
+		{
+			Description: "Match downloaded subtitles",
+			Command:     "ffr match-subs",
+			Result:      "Show.S01E02.1080p.mkv and show-1x02.en.srt result in Show.S01E02.1080p.en.srt",
+		},
+	},
+}
 
-	dimensions, err := getDimensions(fi)
-	if err != nil {
-		return fmt.Errorf("failed to retrieve video dimensions. err: %w", err)
+// formatExamples renders examples the way they are shown in the help of the commands
+func formatExamples(examples []commandExample) string {
+	blocks := make([]string, 0, len(examples))
+	for _, e := range examples {
+		blocks = append(blocks, fmt.Sprintf("Description: %s\nCommand:     %s\nResult:      %s", e.Description, e.Command, e.Result))
 	}
 
-	widthOrigin, heightOrigin, err := parseDimensions(dimensions)
-	if err != nil {
-		return fmt.Errorf("failed to parse video dimensions. err: %w", err)
-	}
+	return "EXAMPLES:\n" + strings.Join(blocks, "\n\n")
+}
 
-	l.Printf("origin width: %d, origin height: %d", width, height)
+// addExamples adds the examples of the commands to their descriptions, so that they are shown in their help
+func addExamples(commands []*cli.Command) {
+	for _, cmd := range commands {
+		examples, ok := commandExamples[cmd.Name]
+		if !ok {
+			continue
+		}
 
-	if widthOrigin < width || heightOrigin < height {
-		return fmt.Errorf("wrong dimensions. new dimensions: %dx%d, old dimensions: %s", width, height, dimensions)
-	}
+		if cmd.Description == "" {
+			cmd.Description = formatExamples(examples)
 
-	var xPos int
-	switch x {
-	case "left":
-	case "center", "":
-		xPos = (widthOrigin - width) / 2
-	case "right":
-		xPos = widthOrigin - width
-	default:
-		xPos, err = strconv.Atoi(x)
-		if err != nil {
-			return fmt.Errorf("wrong instructions, x: %s", x)
+			continue
 		}
-	}
 
-	var yPos int
-	switch y {
-	case "top":
-	case "center", "":
-		yPos = (heightOrigin - height) / 2
-	case "bottom":
-		yPos = heightOrigin - height
-	default:
-		yPos, err = strconv.Atoi(y)
-		if err != nil {
-			return fmt.Errorf("wrong instructions, y: %s", y)
-		}
+		cmd.Description = strings.TrimRight(cmd.Description, "\n") + "\n\n" + formatExamples(examples)
 	}
+}
 
-	l.Printf("x: %d, y: %d", xPos, yPos)
-
-	if widthOrigin < width+yPos || heightOrigin < height+xPos {
-		return fmt.Errorf("wrong instructions. new dimensions: %dx%d, pos x: %d, pos y: %d, old dimensions: %s", width, height, xPos, yPos, dimensions)
+func (a App) examples(c *cli.Context) error {
+	names := c.Args().Slice()
+	if len(names) == 0 {
+		for _, cmd := range c.App.Commands {
+			if _, ok := commandExamples[cmd.Name]; ok {
+				names = append(names, cmd.Name)
+			}
+		}
 	}
 
-	newPath := fmt.Sprintf("%s-%dx%d%s", basePath, width, height, ext)
-
-	cmd := fmt.Sprintf(`ffmpeg -i %q -filter:v "crop=%d:%d:%d:%d" %q`, fi.Name(), width, height, xPos, yPos, newPath)
-	l.Printf(cmd)
+	sb := &strings.Builder{}
+	for _, name := range names {
+		cmd := c.App.Command(name)
+		if cmd == nil {
+			return fmt.Errorf("unknown command. command: %s", name)
+		}
 
-	if dryRun {
-		return nil
-	}
+		examples := commandExamples[cmd.Name]
+		if len(examples) == 0 {
+			return fmt.Errorf("there are no examples for command. command: %s", cmd.Name)
+		}
 
-	if !forceOverwrite {
-		_, err = os.Stat(newPath)
-		if err == nil || !os.IsNotExist(err) {
-			return fmt.Errorf("file already exists. path: %s, err: %w", newPath, err)
+		usage, _, _ := strings.Cut(cmd.Usage, "\n")
+		fmt.Fprintf(sb, "# %s: %s\n\n", cmd.Name, usage)
+		for _, e := range examples {
+			fmt.Fprintf(sb, "# %s\n%s\n# result: %s\n\n", e.Description, e.Command, e.Result)
 		}
 	}
 
-	output, err := exec(cmd)
-	if err != nil {
-		l.Printf(output)
-
-		return fmt.Errorf("failed to crop video. err: %w", err)
-	}
+	fmt.Print(sb.String())
 
 	return nil
 }
 
-func (a App) crop(c *cli.Context, args []string, fi os.FileInfo, dryRun bool) error {
-	forceOverwrite := c.Bool(forceFlag)
-
-	width := c.Int(widthFlag)
-	height := c.Int(heightFlag)
-	x := c.String(xFlag)
-	y := c.String(yFlag)
-
-	dimensionPreset := c.String(dimensionPresetFlag)
-
-	return crop(fi, width, height, x, y, dimensionPreset, forceOverwrite, dryRun)
-}
-
-type videoType struct {
-	name      string
-	size      int64
-	bitRate   int64
-	length    float64
-	frameRate float64
-	width     int64
-	height    int64
-	codec     string
-	indexes   []string
-}
-
-type videoTypes []videoType
-
-func (vs videoTypes) Print(skipKeyFrames bool, maxNameLength int) {
-	t := tabby.New()
-	t.AddHeader("FILE", "SIZE", "BITRATE", "LENGTH", "FRAMERATE", "WIDTH", "HEIGHT", "CODEC", "INDEXES")
+// manDescription returns the description of a command in the markdown the man pages are generated from
+func manDescription(cmd *cli.Command) string {
+	examples := commandExamples[cmd.Name]
 
-	for _, v := range vs {
-		cols := []interface{}{}
+	var parts []string
+	if _, rest, ok := strings.Cut(cmd.Usage, "\n"); ok && strings.TrimSpace(rest) != "" {
+		parts = append(parts, strings.TrimSpace(rest))
+	}
 
-		name := v.name
-		if len(v.name) > maxNameLength {
-			name = v.name[:maxNameLength-12] + "..." + v.name[len(v.name)-9:]
-		}
+	description := cmd.Description
+	if len(examples) > 0 {
+		description = strings.TrimSuffix(description, formatExamples(examples))
+	}
+	if description = strings.TrimSpace(description); description != "" {
+		parts = append(parts, "```\n"+description+"\n```")
+	}
 
-		indexes := "SKIPPED"
-		if !skipKeyFrames {
-			indexes = strings.Join(v.indexes, " ")
+	if len(examples) > 0 {
+		parts = append(parts, "# EXAMPLES")
+		for _, e := range examples {
+			parts = append(parts, fmt.Sprintf("%s\n\n```\n%s\n```\n\nResult: `%s`", e.Description, e.Command, e.Result))
 		}
-
-		cols = append(cols, name)
-		cols = append(cols, intToString(v.size, " ", "B"))
-		cols = append(cols, intToString(v.bitRate, " ", "bit"))
-		cols = append(cols, float64(int(v.length*10))/10)
-		cols = append(cols, float64(int(v.frameRate*10))/10)
-		cols = append(cols, v.width)
-		cols = append(cols, v.height)
-		cols = append(cols, v.codec)
-		cols = append(cols, indexes)
-
-		t.AddLine(cols...)
 	}
 
-	t.Print()
+	return strings.Join(parts, "\n\n")
 }
 
-func intToString(n int64, s, s2 string) string {
-	if n > 1000*1000*1000*1000 {
-		return fmt.Sprintf("%.1f%sT%s", float64(n)/1000/1000/1000/1000, s, s2)
-	} else if n > 1000*1000*1000 {
-		return fmt.Sprintf("%.1f%sG%s", float64(n)/1000/1000/1000, s, s2)
-	} else if n > 1000*1000 {
-		return fmt.Sprintf("%.1f%sM%s", float64(n)/1000/1000, s, s2)
-	} else if n > 1000 {
-		return fmt.Sprintf("%.1f%sK%s", float64(n)/1000, s, s2)
-	}
-
-	return fmt.Sprintf("%d%s%s", n, s, s2)
-}
+// manPages returns the man page of ffr and one for each command, by file name, e.g. ffr.1 and ffr-reencode.1
+func manPages(app *cli.App, section int) (map[string]string, error) {
+	pages := map[string]string{}
 
-func getBitRate(fi os.FileInfo) (int64, error) {
-	bitrateRaw, err := exec(fmt.Sprintf("ffprobe -v quiet -select_streams v:0 -show_entries stream=bit_rate -of default=noprint_wrappers=1 %q", fi.Name()))
+	page, err := app.ToManWithSection(section)
 	if err != nil {
-		return 0, fmt.Errorf("failed to probe file. file: %q, err: %w", fi.Name(), err)
+		return nil, fmt.Errorf("failed to generate man page. err: %w", err)
 	}
+	pages[fmt.Sprintf("%s.%d", app.Name, section)] = page
 
-	if len(bitrateRaw) < 10 {
-		return 0, fmt.Errorf("invalid probe result. file: %q, bitrate found: %s", fi.Name(), bitrateRaw)
-	}
+	for _, cmd := range app.Commands {
+		if cmd.Hidden {
+			continue
+		}
 
-	bitrateRaw = strings.TrimSpace(bitrateRaw[9:])
-	if bitrateRaw == "N/A" {
-		return 0, nil
-	}
+		usage, _, _ := strings.Cut(cmd.Usage, "\n")
+		name := fmt.Sprintf("%s-%s", app.Name, cmd.Name)
+		sub := &cli.App{
+			Name:        name,
+			Usage:       usage,
+			UsageText:   strings.TrimSpace(fmt.Sprintf("%s [global options] %s [command options] %s", app.Name, cmd.Name, cmd.ArgsUsage)),
+			Description: manDescription(cmd),
+			Flags:       cmd.Flags,
+			Commands:    cmd.Subcommands,
+		}
 
-	bitRate, err := strconv.ParseInt(bitrateRaw, 10, 64)
-	if err != nil {
-		return 0, fmt.Errorf("failed to parse bit rate. file: %q, err: %w", fi.Name(), err)
+		page, err := sub.ToManWithSection(section)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate man page. command: %s, err: %w", cmd.Name, err)
+		}
+
+		// the flags of the command are rendered as the flags of an app
+		pages[fmt.Sprintf("%s.%d", name, section)] = strings.ReplaceAll(page, ".SH GLOBAL OPTIONS", ".SH OPTIONS")
 	}
 
-	return bitRate, nil
+	return pages, nil
 }
 
-func getCodec(fi os.FileInfo) (string, error) {
-	codec, err := exec(fmt.Sprintf("ffprobe -v quiet -select_streams v:0 -show_entries stream=codec_name -of default=noprint_wrappers=1:nokey=1 %q", fi.Name()))
-	if err != nil {
-		return "", fmt.Errorf("failed to probe file for codec. file: %q, err: %w", fi.Name(), err)
-	}
+// manPageNames returns the file names of the man pages of commands. Without commands it is the page of ffr itself, or
+// every page if all is set.
+func manPageNames(app *cli.App, commands []string, section int, all bool) ([]string, error) {
+	names := []string{}
+	if len(commands) == 0 {
+		names = append(names, fmt.Sprintf("%s.%d", app.Name, section))
+		if !all {
+			return names, nil
+		}
 
-	parts := strings.Split(strings.TrimSpace(codec), " ")
-	if len(parts) > 1 {
-		return "", fmt.Errorf("suspicious codec found. file: %q, codec: %s", fi.Name(), codec)
+		for _, cmd := range app.Commands {
+			if !cmd.Hidden {
+				commands = append(commands, cmd.Name)
+			}
+		}
 	}
 
-	return parts[0], nil
-}
-
-func getLength(fi os.FileInfo) (float64, error) {
-	lengthRaw, err := exec(fmt.Sprintf("ffprobe -v quiet -show_entries format=duration -of default=noprint_wrappers=1:nokey=1 %q", fi.Name()))
-	if err != nil {
-		return 0.0, fmt.Errorf("failed to probe file for length. file: %q, err: %w", fi.Name(), err)
-	}
+	for _, command := range commands {
+		cmd := app.Command(command)
+		if cmd == nil || cmd.Hidden {
+			return nil, fmt.Errorf("unknown command. command: %s", command)
+		}
 
-	l, err := strconv.ParseFloat(strings.TrimSpace(lengthRaw), 64)
-	if err != nil {
-		return 0.0, fmt.Errorf("failed to parse length. file: %q, err: %w", fi.Name(), err)
+		names = append(names, fmt.Sprintf("%s-%s.%d", app.Name, cmd.Name, section))
 	}
 
-	return l, nil
+	return names, nil
 }
 
-func getFrameRate(fi os.FileInfo) (float64, error) {
-	frameRateRaw, err := exec(fmt.Sprintf("ffprobe -v quiet -select_streams v -of default=noprint_wrappers=1:nokey=1 -show_entries stream=r_frame_rate %q", fi.Name()))
-	if err != nil {
-		return 0.0, fmt.Errorf("failed to probe file for frame rate. file: %q, err: %w", fi.Name(), err)
+func (a App) man(c *cli.Context) error {
+	section := c.Int(sectionFlag)
+	if section < 1 || section > 9 {
+		return fmt.Errorf("invalid man page section. section: %d", section)
 	}
 
-	parts := strings.Split(strings.TrimSpace(frameRateRaw), "/")
-	p0, err := strconv.ParseFloat(parts[0], 64)
-	if err != nil {
-		return 0.0, fmt.Errorf("failed to parse frame rate. file: %q, frame rate: %s, err: %w", fi.Name(), frameRateRaw, err)
-	}
-	p1, err := strconv.ParseFloat(parts[1], 64)
+	pages, err := manPages(c.App, section)
 	if err != nil {
-		return 0.0, fmt.Errorf("failed to parse frame rate. file: %q, frame rate: %s, err: %w", fi.Name(), frameRateRaw, err)
+		return err
 	}
 
-	return p0 / p1, nil
-}
-
-func info(fi os.FileInfo, skipKeyFrames bool) videoType {
-	bitRate, err := getBitRate(fi)
+	dir := c.String(manDirFlag)
+	names, err := manPageNames(c.App, c.Args().Slice(), section, dir != "")
 	if err != nil {
-		l.Printf("failed to retrieve video bitrate. err: %q", err)
+		return err
 	}
 
-	length, err := getLength(fi)
-	if err != nil {
-		l.Printf("failed to retrieve video length. err: %q", err)
-	}
+	if dir == "" {
+		for i, name := range names {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Print(pages[name])
+		}
 
-	frameRate, err := getFrameRate(fi)
-	if err != nil {
-		l.Printf("failed to retrieve video frame rate. err: %q", err)
+		return nil
 	}
 
-	dimensions, err := getDimensions(fi)
-	if err != nil {
-		l.Printf("failed to retrieve video dimensions. err: %q", err)
-	}
+	if c.Bool(dryRunFlag) {
+		for _, name := range names {
+			fmt.Println(filepath.Join(dir, name))
+		}
 
-	width, height, err := parseDimensions(dimensions)
-	if err != nil {
-		l.Printf("failed to parse video dimensions. err: %q", err)
+		return nil
 	}
 
-	codec, err := getCodec(fi)
+	err = os.MkdirAll(dir, 0755)
 	if err != nil {
-		l.Printf("failed to retrieve video codec. err: %q", err)
+		return fmt.Errorf("failed to create man page directory. dir: %q, err: %w", dir, err)
 	}
 
-	var indexes []string
-	if !skipKeyFrames {
-		indexes, err = findKeyFrames(fi)
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		err = os.WriteFile(path, []byte(pages[name]), 0644)
 		if err != nil {
-			l.Printf("failed to find key frames. err: %q", err)
-		}
-	}
-
-	return videoType{
-		name:      fi.Name(),
-		size:      fi.Size(),
-		bitRate:   bitRate,
-		length:    length,
-		frameRate: frameRate,
-		width:     int64(width),
-		height:    int64(height),
-		codec:     codec,
-		indexes:   indexes,
-	}
-}
-
-func infoAll(fileList []os.FileInfo, skipKeyFrames bool, maxNameLength int) error {
-	v := videoTypes{}
-	for _, fi := range fileList {
-		if fi.IsDir() {
-			continue
+			return fmt.Errorf("failed to write man page. path: %q, err: %w", path, err)
 		}
-
-		v = append(v, info(fi, skipKeyFrames))
 	}
 
-	v.Print(skipKeyFrames, maxNameLength)
+	fmt.Printf("%d man pages written to %s\n", len(names), dir)
 
 	return nil
 }
 
-func (a App) infoAll(c *cli.Context, args []string, fileList []os.FileInfo, dryRun bool) error {
-	skipKeyFrames := c.Bool(skipKeyframesFlag)
-	maxNameLength := c.Int(maxNameLengthFlag)
-
-	return infoAll(fileList, skipKeyFrames, maxNameLength)
-}
-
 // commands
 const (
 	addNumberCommand = "add-number"
 	addNumberAliases = "a"
-	addNumberUsage   = `add a number to the last number found in the file
+	addNumberUsage   = `add a number to the numbers found in the file name, or multiply or replace them
 
-EXAMPLES:
-Description: Increment the last number segment in the file name 'foo-1080p-2ffc.mp4'
-Command:     ffr add-number 2 foo-1080p-2ffc.mp4
-Result:      foo-1080p-4ffc.mp4
-
-Description: Increment the number in '1080p' in the file name 'foo-1080p-2ffc.mp4'
-Command:     ffr add-number --regular-expression '-(\d+)p' 2 foo-1080p-2ffc.mp4
-Result:      foo-1080p-4ffc.mp4`
-	addNumberArgsUsage = "[number-to-addNumber] [files...]"
+Decimals and zero-padding of the numbers are kept, results can not be negative.`
+	addNumberArgsUsage = "[number] [files...]"
 
 	deletePartsCommand   = "delete-parts"
 	deletePartsAliases   = "dp"
 	deletePartsUsage     = "delete certain parts based on a comma separated list of parts"
-	deletePartsArgsUsage = `[comma-separated-list] [files...]
-
-EXAMPLES:
-Description: Delete the first and third segments in the file name 'foo-bar-baz-2ffc.mp4'
-Command:     ffr delete-parts 1,3 foo-bar-baz-2ffc.mp4
-Result:      bar-2ffc.mp4
-
-Description: Delete the last and the third last segments in the file name 'foo-bar-baz-2ffc.mp4'
-Command:     ffr delete-parts --fb 1,3 foo-bar-baz-2ffc.mp4
-Result:      foo-baz.mp4`
+	deletePartsArgsUsage = "[comma-separated-list] [files...]"
 
 	deleteRegexpCommand   = "delete-regexp"
 	deleteRegexpAliases   = "dr"
@@ -1551,11 +14086,34 @@ Result:      foo-baz.mp4`
 	keyFramesUsage     = "list keyframes of video file(s)"
 	keyFramesArgsUsage = "[files...]"
 
+	previewClipCommand   = "preview-clip"
+	previewClipAliases   = "pc"
+	previewClipUsage     = "extract short, stream copied clips starting at the keyframe nearest to a timestamp"
+	previewClipArgsUsage = "[timestamp] [files...]"
+
 	mergePartsCommand   = "merge-parts"
 	mergePartsAliases   = "m"
-	mergePartsUsage     = "merge the generated descriptions [foo-12ffc-1bar -> abc-12bar]"
+	mergePartsUsage     = "sum the numbers of numeric tags, parts made of a number and a tag, e.g. 2ffc"
 	mergePartsArgsUsage = "[files...]"
 
+	normalizeNumbersCommand   = "normalize-numbers"
+	normalizeNumbersAliases   = "nn"
+	normalizeNumbersUsage     = "convert roman numerals and numbers written in words into digits, e.g. before sorting"
+	normalizeNumbersArgsUsage = "[files...]"
+
+	normalizeUnicodeCommand = "normalize-unicode"
+	normalizeUnicodeAliases = "nu"
+	normalizeUnicodeUsage   = `normalize the unicode form of file names, fix mojibake and optionally transliterate them to ASCII
+
+File names copied from macOS are often decomposed (NFD), while Linux and Windows tools expect composed (NFC) names.
+Mojibake is UTF-8 text decoded with the wrong encoding, e.g. "JosÃ©" instead of "José".`
+	normalizeUnicodeArgsUsage = "[files...]"
+
+	padNumbersCommand   = "pad-numbers"
+	padNumbersAliases   = "pn"
+	padNumbersUsage     = "zero-pad the numbers in the file name to a width, so that a series sorts the same everywhere"
+	padNumbersArgsUsage = "[width] [files...]"
+
 	prefixCommand   = "prefix"
 	prefixAliases   = "p"
 	prefixUsage     = "prefix file names with a fixed string"
@@ -1568,7 +14126,98 @@ Result:      foo-baz.mp4`
 Find more about the various codecs and their settings here:
 https://trac.ffmpeg.org/wiki/Encode/H.265
 https://trac.ffmpeg.org/wiki/Encode/H.264
-https://trac.ffmpeg.org/wiki/Encode/VP9`
+https://trac.ffmpeg.org/wiki/Encode/VP9
+https://trac.ffmpeg.org/wiki/Encode/VFX (prores_ks, dnxhd)`
+
+	timelapseCommand   = "timelapse"
+	timelapseAliases   = "tl"
+	timelapseUsage     = "speed up videos by a factor or to a target duration"
+	timelapseArgsUsage = "[files...]"
+
+	overlayTextCommand   = "overlay-text"
+	overlayTextAliases   = "ot"
+	overlayTextUsage     = "burn the file name, the timecode or a custom text into review copies of videos"
+	overlayTextArgsUsage = "[filename|timecode|text] [files...]"
+
+	loopCommand   = "loop"
+	loopAliases   = "lp"
+	loopUsage     = "repeat clips, optionally as a forward and backward boomerang"
+	loopArgsUsage = "[files...]"
+
+	muxAudioCommand   = "mux-audio"
+	muxAudioAliases   = "mux"
+	muxAudioUsage     = "replace the audio of videos or add it as a new track"
+	muxAudioArgsUsage = "[audio file] [files...]"
+
+	audioChannelsCommand   = "audio-channels"
+	audioChannelsAliases   = "ach"
+	audioChannelsUsage     = "downmix 5.1 audio to stereo, swap left and right or extract a single channel"
+	audioChannelsArgsUsage = "[downmix|swap|extract] [files...]"
+
+	langTagCommand   = "lang-tag"
+	langTagAliases   = "lt"
+	langTagUsage     = "set missing language metadata of audio and subtitle streams, copying the streams into a new file"
+	langTagArgsUsage = "[files...]"
+
+	filterCommand      = "filter"
+	filterAliases      = "flt"
+	filterCommandUsage = "apply a named filter graph preset from the config file or an ad hoc filter graph"
+	filterArgsUsage    = "[files...]"
+	filterDescription  = `
+Filter presets are defined in the config file, e.g.:
+{"filterPresets": {"insta": "crop=ih:ih,scale=1080:1080"}}`
+
+	eachCommand     = "each"
+	eachAliases     = "ea"
+	eachUsage       = "run a custom ffmpeg command on each file"
+	eachArgsUsage   = "[files...] -- [ffmpeg arguments...]"
+	eachDescription = `
+{in} and {out} in the ffmpeg arguments are replaced with the path of the file and the output path.`
+
+	framesExportCommand   = "frames-export"
+	framesExportAliases   = "fe"
+	framesExportUsage     = "export the frames of videos as numbered images"
+	framesExportArgsUsage = "[files...]"
+
+	framesImportCommand   = "frames-import"
+	framesImportAliases   = "fim"
+	framesImportUsage     = "create a video out of images, in natural order of their names. (The backwards flag is ignored.)"
+	framesImportArgsUsage = "[output] [images...]"
+
+	convertImageCommand   = "convert-image"
+	convertImageAliases   = "ci"
+	convertImageUsage     = "convert images to a different format, optionally resizing them"
+	convertImageArgsUsage = "[files...]"
+
+	proxyCommand   = "proxy"
+	proxyAliases   = "px"
+	proxyUsage     = "create low resolution, all-intra editing proxies in a " + proxyDirName + " directory, keeping the names of the files"
+	proxyArgsUsage = "[files...]"
+
+	restoreSDCommand   = "restore-sd"
+	restoreSDAliases   = "rsd"
+	restoreSDUsage     = "deinterlace or inverse telecine standard definition videos, crop their black borders and encode them at a constant quality"
+	restoreSDArgsUsage = "[files...]"
+
+	ingestDiscCommand   = "ingest-disc"
+	ingestDiscAliases   = "ing"
+	ingestDiscUsage     = "remux the titles of DVD or Blu-ray folders into mkv files, concatenating their segments and keeping the chapters"
+	ingestDiscArgsUsage = "[directories...]"
+
+	archiveCommand   = "archive"
+	archiveAliases   = "ar"
+	archiveUsage     = "encode videos losslessly for preservation and verify the decoded frames against the source"
+	archiveArgsUsage = "[files...]"
+
+	reencodeAudioCommand     = "reencode-audio"
+	reencodeAudioAliases     = "rea"
+	reencodeAudioUsage       = "reencode an audio file via ffmpeg"
+	reencodeAudioArgsUsage   = "[files...]"
+	reencodeAudioDescription = `
+Find more about the various codecs and their settings here:
+https://trac.ffmpeg.org/wiki/Encode/HighQualityAudio
+https://trac.ffmpeg.org/wiki/Encode/AAC
+https://trac.ffmpeg.org/wiki/Encode/MP3`
 
 	replaceCommand   = "replace"
 	replaceAliases   = "r"
@@ -1594,6 +14243,153 @@ https://trac.ffmpeg.org/wiki/Encode/VP9`
 	datePrefixAliases   = "pd"
 	datePrefixUsage     = `add a date prefix to the file name`
 	datePrefixArgsUsage = "[files...]"
+
+	cleanupRecordingsCommand   = "cleanup-recordings"
+	cleanupRecordingsAliases   = "cr"
+	cleanupRecordingsUsage     = "rename screenshots and screen recordings to the dash-separated convention, convert mov to mp4"
+	cleanupRecordingsArgsUsage = "[files...]"
+
+	organizeCommand   = "organize"
+	organizeAliases   = "o"
+	organizeUsage     = "move files into directories derived from their date or other properties"
+	organizeArgsUsage = "[files...]"
+
+	statsCommand   = "stats"
+	statsAliases   = "st"
+	statsUsage     = "aggregate statistics of the video(s) per codec, resolution and container"
+	statsArgsUsage = "[files...]"
+
+	efficiencyCommand   = "efficiency"
+	efficiencyAliases   = "ef"
+	efficiencyUsage     = "report bits per pixel per frame of the video(s), flagging unusually high (worth re-encoding) or low (likely over-compressed) values"
+	efficiencyArgsUsage = "[files...]"
+
+	streamHashCommand   = "streamhash"
+	streamHashAliases   = "sh"
+	streamHashUsage     = "hash the decoded video and audio streams of files, e.g. to verify that a remux or a lossless encode kept the content bit-exact"
+	streamHashArgsUsage = "[files...]"
+
+	contentIDCommand   = "content-id"
+	contentIDAliases   = "cid"
+	contentIDUsage     = "add a short ID derived from the video and audio streams to file names, e.g. to find copies after they were renamed"
+	contentIDArgsUsage = "[files...]"
+
+	similarNamesCommand   = "similar-names"
+	similarNamesAliases   = "sn"
+	similarNamesUsage     = "find files with similar names, e.g. duplicates named differently or series members with inconsistent naming"
+	similarNamesArgsUsage = "[files...]"
+
+	checkNewCommand   = "check-new"
+	checkNewAliases   = "cn"
+	checkNewUsage     = "check files before moving them into a directory: their names must not be taken, must not match the canonical name (lowercase tokens without separators and extension) of a file indexed in the directory and must follow its naming policy. the optional index is stored in a " + dirIndexFileName + " file and kept up to date by renames"
+	checkNewArgsUsage = "[dir] [files...]"
+
+	lintNamesCommand   = "lint-names"
+	lintNamesAliases   = "ln"
+	lintNamesUsage     = "check file names against the naming policy of their directory, defined in a " + namingPolicyFileName + " file in it or in a parent"
+	lintNamesArgsUsage = `[files...]
+
+POLICY:
+naming:
+  tokens: [date, text, dimensions, id]  # order of dash-separated parts: date, dimensions, id, number, text
+  datePrefix: true                      # names must start with a date
+  allowedChars: a-z0-9.-                # character class of the characters allowed in names
+  maxLength: 80                         # maximum length of names including the extension`
+
+	againCommand   = "again"
+	againAliases   = "ag"
+	againUsage     = "repeat the last file processing command with all its flags, optionally on other files"
+	againArgsUsage = "[files...]"
+
+	resumeCommand = "resume"
+	resumeAliases = "rsm"
+	resumeUsage   = "continue the last run stopped by --time-budget or --schedule on the files it left, with the same flags"
+
+	examplesCommand   = "examples"
+	examplesAliases   = "ex"
+	examplesUsage     = "print runnable example invocations of commands, of all commands with examples by default"
+	examplesArgsUsage = "[commands...]"
+
+	manCommand   = "man"
+	manUsage     = "print the man page of ffr or of the commands given, or write the pages to --man-dir, e.g. ffr.1 and ffr-reencode.1"
+	manArgsUsage = "[commands...]"
+
+	historyCommand = "history"
+	historyAliases = "hi"
+	historyUsage   = "show the changes recorded in the journal"
+
+	stageCommand   = "stage"
+	stageAliases   = "sg"
+	stageUsage     = "plan the renames of a command via a dry-run and add them to the staging manifest instead of applying them, to be reviewed via status and applied together via commit. only renames can be staged"
+	stageArgsUsage = "[command] [flags] [args...]"
+
+	statusCommand = "status"
+	statusAliases = "ss"
+	statusUsage   = "list the staged commands and renames"
+
+	commitCommand = "commit"
+	commitAliases = "cm"
+	commitUsage   = "apply all the staged renames after validating them together, the renames done are reverted if any of them fails"
+
+	labelCommand = "label"
+	labelAliases = "lb"
+	labelUsage   = "manage labels of files, stored in a " + labelsFileName + " file per directory. use --filter label=name to process labelled files only"
+
+	labelAddCommand   = "add"
+	labelAddUsage     = "add comma separated labels to files"
+	labelAddArgsUsage = "[labels] [files...]"
+
+	labelRemoveCommand   = "remove"
+	labelRemoveUsage     = "remove comma separated labels from files"
+	labelRemoveArgsUsage = "[labels] [files...]"
+
+	labelListCommand   = "list"
+	labelListUsage     = "list labels of files"
+	labelListArgsUsage = "[files...]"
+
+	rateCommand   = "rate"
+	rateAliases   = "ra"
+	rateUsage     = "insert or update a rating token (e.g. -r4) in file names, 0 removes the rating"
+	rateArgsUsage = "[rating] [files...]"
+
+	flattenCommand   = "flatten"
+	flattenAliases   = "fl"
+	flattenUsage     = "move files from nested subdirectories into the given directory, prefixing them with their relative path"
+	flattenArgsUsage = "[directories...]"
+
+	editCommand   = "edit"
+	editAliases   = "ed"
+	editUsage     = "open the list of files in $EDITOR, then rename the files as edited and remove the ones emptied"
+	editArgsUsage = "[files...]"
+
+	renameFromFileCommand = "rename-from-file"
+	renameFromFileAliases = "rff"
+	renameFromFileUsage   = `rename files according to a mapping of old and new paths, e.g. one edited in a spreadsheet
+
+The mapping is a CSV or TSV file with the old and the new paths in the first two columns and an optional header, or
+JSON with oldPath and newPath fields, e.g. the output of a dry-run with --result json. The whole mapping is validated
+before renaming anything and the renames are journaled, so they can be undone.`
+	renameFromFileArgsUsage = "[mapping file]"
+
+	mirrorNamesCommand = "mirror-names"
+	mirrorNamesAliases = "mn"
+	mirrorNamesUsage   = `rename the files of a target directory to match the names of the corresponding files in a reference directory
+
+Files are matched by their position in natural order by default, by their length with --match duration or by the
+similarity of their names with --match name. Target files keep their own extensions, use --target-ext to list files
+other than videos in the target directory, e.g. subtitles.`
+	mirrorNamesArgsUsage = "[reference directory] [target directory]"
+
+	matchSubsCommand = "match-subs"
+	matchSubsAliases = "ms"
+	matchSubsUsage   = `rename orphaned subtitles after the videos they belong to, in the same directory
+
+Subtitles are matched by their episode numbers (e.g. S01E02, 1x02) and the similarity of their names, subtitles
+ending well after a video are not matched with it. Language suffixes like .en or .pt-BR.forced are kept.`
+	matchSubsArgsUsage = "[directories...]"
+
+	undoCommand = "undo"
+	undoUsage   = "undo the last run recorded in the journal"
 )
 
 // flags
@@ -1606,12 +14402,48 @@ const (
 	deleteTextAlias = "del"
 	deleteTextUsage = "text to delete after merging"
 
+	formFlag  = "form"
+	formUsage = "unicode normalization form [nfc, nfd], nfc is expected by most tools, nfd is used by macOS"
+
+	asciiFlag  = "ascii"
+	asciiUsage = "transliterate file names to ASCII, e.g. á to a and ß to ss"
+
+	noMojibakeFlag  = "no-mojibake"
+	noMojibakeUsage = "do not fix UTF-8 file names decoded as Windows-1252 or Latin-1"
+
+	positionFlag  = "position"
+	positionUsage = "1-based position of the only number to change among the numbers found, counted from the end with --from-back"
+
+	paddingFlag  = "padding"
+	paddingUsage = "minimum number of digits of the converted numbers, shorter ones are padded with zeros"
+
+	maxRomanFlag  = "max-roman"
+	maxRomanUsage = "largest roman numeral to convert, larger ones are more likely to be words or sizes, e.g. MIX or XL"
+
+	noRomanFlag  = "no-roman"
+	noRomanUsage = "do not convert roman numerals"
+
+	noWordsFlag  = "no-words"
+	noWordsUsage = "do not convert numbers written in words"
+
+	multiplyFlag  = "multiply"
+	multiplyUsage = "multiply the numbers found by the number given instead of adding it"
+
+	setFlag  = "set"
+	setUsage = "replace the numbers found with the number given instead of adding it"
+
+	tagsFlag  = "tags"
+	tagsUsage = "comma separated list of the tags to sum, e.g. ffc,pro, all tags are summed if neither this nor --regular-expression is set"
+
+	timeUnitsFlag  = "time-units"
+	timeUnitsUsage = "sum the h, m and s tags as durations, e.g. 1.5h and 30m become 2h"
+
 	dryRunFlag  = "dryRun"
 	dryRunAlias = "d"
 	dryRunUsage = "only print commands, do not execute anything"
 
 	codecFlag  = "codec"
-	codecUsage = "codec to use for encoding [libx264, libx265, vp9]"
+	codecUsage = "codec to use for encoding [libx264, libx265, vp9, prores_ks, dnxhd]"
 
 	crfFlag  = "crf"
 	crfUsage = "crf to use for encoding (https://slhck.info/video/2017/02/24/crf-guide.html)"
@@ -1626,7 +14458,7 @@ const (
 
 	fromBackFlag  = "from-back"
 	fromBackAlias = "fb"
-	fromBackUsage = "comma separated list of part counts to change"
+	fromBackUsage = "count the positions from the end of the file name"
 
 	maxCountFlag  = "max-count"
 	maxCountAlias = "mc"
@@ -1639,21 +14471,200 @@ const (
 	presetFlag  = "preset"
 	presetUsage = "preset to use for encoding [%s] (x264, x265 only)"
 
-	widthFlag  = "width"
-	widthUsage = "width to use for cropping video"
+	widthFlag  = "width"
+	widthUsage = "width to use for cropping video"
+
+	heightFlag  = "height"
+	heightUsage = "height to use for cropping video"
+
+	xFlag  = "x"
+	xUsage = "x position to use for cropping video (number, left, center, right)"
+
+	yFlag  = "y"
+	yUsage = "y position to use for cropping video (number, top, center, bottom)"
+
+	bitDepthFlag  = "bit-depth"
+	bitDepthAlias = "bd"
+	bitDepthUsage = "bit depth to encode with [8, 10], keeps the encoder default if not set"
+
+	profileFlag  = "profile"
+	profileUsage = "encoder profile to use [main, main10, high, high10, 0, 2], defaults to the one matching the bit depth. prores_ks: [proxy, lt, standard, hq, 4444, 4444xq], dnxhd: [lb, sq, hq, hqx, 444], defaults to hq"
+
+	containerFlag  = "container"
+	containerUsage = "container of the output files, \"same\" keeps the container of the original [mp4, mkv, mov, webm, mxf, same], default: mp4, mkv for vp9, mov for prores_ks and dnxhd"
+
+	tuneFlag  = "tune"
+	tuneUsage = "tune to use for encoding [film, animation, grain, stillimage, fastdecode, zerolatency] (x264, x265 only)"
+
+	x265ParamsFlag  = "x265-params"
+	x265ParamsUsage = "colon separated key=value parameters passed to x265, overriding the generated ones"
+
+	x264ParamsFlag  = "x264-params"
+	x264ParamsUsage = "colon separated key=value parameters passed to x264, overriding the generated ones"
+
+	outputsFlag  = "outputs"
+	outputsAlias = "out"
+	outputsUsage = "encode several renditions at once, e.g. 1080p:libx265,720p:libx264,audio"
+
+	keyIntFlag  = "keyint"
+	keyIntAlias = "gop"
+	keyIntUsage = "maximum number of frames between key frames, or auto for ten seconds of video"
+
+	allIntraFlag  = "all-intra"
+	allIntraUsage = "make every frame a key frame, useful for editing but results in much bigger files"
+
+	cfrFlag  = "cfr"
+	cfrUsage = "force a constant frame rate, duplicating or dropping frames of variable frame rate videos, e.g. phone recordings, for editing-compatibility"
+
+	printOutputNamesFlag  = "print-output-names"
+	printOutputNamesUsage = "print the output file names and the ffmpeg commands of every file without encoding anything"
+
+	manDirFlag  = "man-dir"
+	manDirUsage = "directory to write the man pages to, all of them if no command is given. the pages are printed if empty"
+
+	sectionFlag  = "section"
+	sectionUsage = "section of the manual the pages belong to"
+
+	nameTemplateFlag  = "name"
+	nameTemplateUsage = "Go template of output file names without the extension, fields: .Base, .Params, .Encoder, .CRF, .Preset, .Tune, .PixelFormat, .HWAccel, .FPSMode, default: " + defaultReEncodeName
+
+	noSuffixFlag  = "no-suffix"
+	noSuffixUsage = "keep the original file names, only the extension may change, use it with --output-dir to avoid overwriting the originals"
+
+	outputDirFlag  = "output-dir"
+	outputDirUsage = "directory to write the output files to, created if missing, default: the directory of the original file"
+
+	dateRegexFlag  = "date-regex"
+	dateRegexUsage = "regular expression matching the date in file names, the first group is used if there is one, requires --date-format"
+
+	dateFormatFlag  = "date-format"
+	dateFormatUsage = "Go time layout of the date in file names, e.g. 2006_01_02, used to build the regular expression if --date-regex is not set"
+
+	metadataDateFlag  = "metadata-date"
+	metadataDateUsage = "use the creation time of the video or the modification time of the file if the file name contains no date, see --timezone"
+
+	datePickUsage = "date to use if a file name contains different dates: first, last or interactive"
+
+	factorFlag  = "factor"
+	factorAlias = "x"
+	factorUsage = "speed up factor, e.g. 30"
+
+	durationFlag  = "duration"
+	durationUsage = "target duration in seconds, overrides factor"
+
+	dropAudioFlag  = "drop-audio"
+	dropAudioAlias = "da"
+	dropAudioUsage = "drop the audio instead of speeding it up"
+
+	repeatFlag  = "repeat"
+	repeatAlias = "n"
+	repeatUsage = "number of times the clip is repeated"
+
+	boomerangFlag  = "boomerang"
+	boomerangAlias = "bo"
+	boomerangUsage = "play the clip forward then backwards, audio is dropped"
+
+	offsetFlag  = "offset"
+	offsetUsage = "audio offset in seconds, negative values make the audio start earlier"
+
+	addTrackFlag  = "add-track"
+	addTrackAlias = "at"
+	addTrackUsage = "keep the original audio and add the new one as an additional track"
+
+	shortestFlag  = "shortest"
+	shortestUsage = "finish the output when the shorter of the video and audio ends"
+
+	minLengthFlag  = "min-length"
+	minLengthUsage = "minimum length of titles to ingest in seconds, shorter ones are usually menus and trailers"
+
+	targetSizeFlag  = "target-size"
+	targetSizeUsage = "encode in two passes to fit a size, e.g. 8MB, or the upload limit of a messenger [discord, discord-nitro, whatsapp, telegram]"
+
+	maxHeightFlag  = "max-height"
+	maxHeightUsage = "maximum height of videos encoded to a target size, lower heights are picked automatically for low bit rates"
+
+	clipLengthFlag  = "clip-length"
+	clipLengthUsage = "length of the preview clips in seconds"
+
+	hashFlag  = "hash"
+	hashUsage = "hash algorithm [md5, sha160, sha256, sha512, crc32, adler32, murmur3]"
+
+	compareFlag  = "compare"
+	compareUsage = "compare the streams of the files with the ones of the first file, failing if they differ"
+
+	framesFlag  = "frames"
+	framesUsage = "find the first differing frame of the streams which differ, requires --compare"
+
+	archiveCodecFlag  = "archive-codec"
+	archiveCodecUsage = "lossless codec to archive with [ffv1, x265]"
+
+	noVerifyFlag  = "no-verify"
+	noVerifyUsage = "do not compare the decoded frames of the archive with the source"
+
+	proxyFormatFlag  = "proxy-format"
+	proxyFormatUsage = "format of the proxies [h264, dnxhr, prores]"
+
+	proxyHeightFlag  = "proxy-height"
+	proxyHeightUsage = "height of the proxies, the width keeps the aspect ratio"
+
+	textFlag  = "text"
+	textUsage = "custom text to burn into the video"
+
+	placementFlag  = "placement"
+	placementUsage = "placement of the text [top-left, top, top-right, center, bottom-left, bottom, bottom-right]"
+
+	fontSizeFlag  = "font-size"
+	fontSizeUsage = "font size in pixels, defaults to 1/24 of the video height"
+
+	noBoxFlag  = "no-box"
+	noBoxUsage = "do not draw a semi-transparent box behind the text"
+
+	langFlag  = "lang"
+	langUsage = "language to set, e.g. eng for all streams missing a language, s=hun for subtitles or a:1=eng for the second audio stream"
+
+	detectFlag  = "detect"
+	detectUsage = "detect the language of text based subtitle streams missing a language"
+
+	channelFlag  = "channel"
+	channelUsage = "channel to extract, either an index or a name [FL, FR, FC, LFE, BL, BR, SL, SR]"
+
+	filterPresetFlag  = "preset"
+	filterPresetUsage = "name of the filter preset defined in the config file"
+
+	filterGraphFlag  = "graph"
+	filterGraphUsage = "ad hoc ffmpeg filter graph, used if no preset is given"
+
+	tagFlag  = "tag"
+	tagUsage = "text added to the output file names"
+
+	outputExtFlag  = "out-ext"
+	outputExtUsage = "extension of the output files, defaults to the extension of the input"
+
+	fpsFlag  = "fps"
+	fpsUsage = "frames per second, defaults to every frame for exports and 24 for imports"
+
+	imageFormatFlag  = "to"
+	imageFormatUsage = "image format to convert to [jpg, png, webp, avif, ...], defaults to the original format"
+
+	audioCodecFlag  = "audio-codec"
+	audioCodecAlias = "ac"
+	audioCodecUsage = "codec to use for audio encoding [opus, aac, mp3, flac]"
 
-	heightFlag  = "height"
-	heightUsage = "height to use for cropping video"
+	audioBitRateFlag  = "audio-bitrate"
+	audioBitRateAlias = "ab"
+	audioBitRateUsage = "bit rate to use for audio encoding, e.g. 128k"
 
-	xFlag  = "x"
-	xUsage = "x position to use for cropping video (number, left, center, right)"
+	vbrQualityFlag  = "vbr-quality"
+	vbrQualityAlias = "vq"
+	vbrQualityUsage = "variable bit rate quality, encoder specific (e.g. mp3: 0-9, lower is better), -1 means constant bit rate"
 
-	yFlag  = "y"
-	yUsage = "y position to use for cropping video (number, top, center, bottom)"
+	channelsFlag  = "channels"
+	channelsAlias = "ch"
+	channelsUsage = "number of audio channels, 0 keeps the original"
 
 	hwaccelFlag  = "hwaccel"
 	hwaccelAlias = "hw"
-	hwaccelUsage = "hardware acceleration to use for encoding [qsv]"
+	hwaccelUsage = "hardware acceleration to use for encoding [qsv, nvenc, vaapi, videotoolbox, auto-detect]"
 
 	hwaccelDeviceFlag  = "hwaccel_device"
 	hwaccelDeviceAlias = "hwd"
@@ -1687,19 +14698,259 @@ const (
 	verboseAlias = "v"
 	verboseUsage = "print commands before executing them"
 
+	withSidecarsFlag  = "with-sidecars"
+	withSidecarsAlias = "ws"
+	withSidecarsUsage = "comma separated list of sidecar extensions to rename together with the files (e.g. srt,nfo,jpg,xmp)"
+
 	skipKeyframesFlag  = "skip-keyframes"
 	skipKeyframesAlias = "sk"
 	skipKeyframesUsage = "if true, keyframes will not be included in the result"
 
-	maxNameLengthFlag    = "maximum-name-length"
-	maxNameLengthAlias   = "mnl"
-	maxNameLengthUsage   = "maximum length of a file name"
-	maxNameLengthDefault = 50
+	columnsFlag  = "columns"
+	columnsAlias = "col"
+	columnsUsage = "comma separated list of columns to display [name, size, bitrate, length, framerate, width, height, codec, primaries, transfer, hdr, audio, subtitles, indexes]"
+
+	maxNameLengthFlag  = "maximum-name-length"
+	maxNameLengthAlias = "mnl"
+	maxNameLengthUsage = "maximum length of a file name, defaults to fitting the table into the terminal width"
+
+	trimSilenceFlag  = "trim-silence"
+	trimSilenceAlias = "ts"
+	trimSilenceUsage = "if true, leading and trailing silence will be trimmed"
+
+	deleteOriginalFlag  = "delete-original"
+	deleteOriginalAlias = "do"
+	deleteOriginalUsage = "if true, the original file will be moved into " + trashDirName + " after a successful conversion"
+
+	permanentFlag  = "permanent"
+	permanentUsage = "delete removed files instead of moving them into " + trashDirName + ", deleted files can not be restored by undo"
+
+	matchFlag  = "match"
+	matchUsage = "how to match files [index, duration, name]"
+
+	toleranceFlag  = "tolerance"
+	toleranceUsage = "maximum difference in seconds between the lengths of matching files"
+
+	targetExtFlag  = "target-ext"
+	targetExtUsage = "comma separated list of extensions of the target files, defaults to --ext"
+
+	noDurationFlag  = "no-duration"
+	noDurationUsage = "do not compare the length of videos and subtitles, so that videos are not probed"
+
+	mappingFormatFlag  = "mapping-format"
+	mappingFormatUsage = "format of the mapping file [csv, tsv, json], derived from its extension by default"
+
+	similarityFlag  = "similarity"
+	similarityUsage = "minimum similarity of names between 0 and 1, the higher of the share of matching characters and of matching words"
+
+	proposeFlag  = "propose"
+	proposeUsage = "propose a canonical name for each group of similar names"
+
+	fixFlag  = "fix"
+	fixUsage = "rename files to fix the naming policy violations which can be fixed automatically"
+
+	formatFlag  = "format"
+	formatAlias = "fo"
+	formatUsage = "output format [table, json]"
+
+	sinceFlag  = "since"
+	sinceUsage = "only show entries on or after this date (YYYY-MM-DD)"
+
+	untilFlag  = "until"
+	untilUsage = "only show entries on or before this date (YYYY-MM-DD)"
+
+	commandFilterFlag  = "command"
+	commandFilterAlias = "c"
+	commandFilterUsage = "only show entries of this command"
+
+	fileFilterFlag  = "file"
+	fileFilterUsage = "only show entries with paths containing this text"
+
+	exportFlag  = "export"
+	exportUsage = "export format [table, csv, json]"
+
+	templateFlag  = "template"
+	templateAlias = "t"
+	templateUsage = "template of the target directory. fields: Year, Month, Day, Date, Codec, Resolution, Width, Height, Ext"
+
+	rootFlag  = "root"
+	rootUsage = "directory no files can be renamed, deleted or written outside of, defaults to the working directory"
+
+	configFlag  = "config"
+	configUsage = "path of the config file"
+
+	commandHistoryFlag  = "command-history"
+	commandHistoryUsage = "path of the file recording the commands run for repeating them via again, empty to disable"
+
+	updateIndexFlag  = "update-index"
+	updateIndexUsage = "index the files of the directory and save the index, renames keep it up to date afterwards"
+
+	stagingFlag  = "staging"
+	stagingUsage = "path of the staging manifest collecting the renames staged for commit"
+
+	discardFlag  = "discard"
+	discardUsage = "discard the staged renames instead of applying them"
+
+	pickFlag  = "pick"
+	pickAlias = "p"
+	pickUsage = "choose the command to repeat from the recent ones"
+
+	skipMissingFlag  = "skip-missing"
+	skipMissingUsage = "skip the arguments which do not exist instead of failing, they are listed at the end of the run"
+
+	atomicFlag  = "atomic"
+	atomicUsage = "stop at the first file failing and roll back the changes already made by the run using the journal, files created by encoding are kept"
+
+	journalFlag  = "journal"
+	journalAlias = "j"
+	journalUsage = "path of the journal used to record changes, empty to disable journaling"
+
+	unitsFlag  = "units"
+	unitsUsage = "units to display sizes and bit rates in [si, iec], si uses powers of 1000 (KB), iec powers of 1024 (KiB)"
+
+	precisionFlag  = "precision"
+	precisionUsage = "number of decimals to display sizes and bit rates with"
+
+	secondsFlag  = "seconds"
+	secondsUsage = "display durations as seconds instead of hh:mm:ss.s"
+
+	quietFlag  = "quiet"
+	quietAlias = "q"
+	quietUsage = "only print errors and the final summary"
+
+	colorFlag  = "color"
+	colorUsage = "when to color the output [auto, always, never], auto respects NO_COLOR"
+
+	noColorFlag  = "no-color"
+	noColorUsage = "do not color the output, same as --color never"
+
+	saveLogsFlag  = "save-logs"
+	saveLogsUsage = "directory to save the full output of every ffmpeg and ffprobe call to, one log per file"
+
+	includePartialFlag  = "include-partial"
+	includePartialUsage = "process empty files and files with extensions like .part, .tmp or .crdownload, which are skipped by default"
+
+	timeBudgetFlag  = "time-budget"
+	timeBudgetUsage = "stop starting new files once the run took this long, e.g. 4h, and record the files left for the resume command"
+
+	resumeManifestFlag  = "resume-manifest"
+	resumeManifestUsage = "path of the manifest recording the files left by runs stopped by --time-budget or --schedule"
+
+	scheduleFlag  = "schedule"
+	scheduleUsage = "time window of the day to start files in, e.g. 22:00-06:00, files already started are finished"
+
+	onWindowCloseFlag  = "on-window-close"
+	onWindowCloseUsage = "what to do outside of the --schedule window [wait, exit]. exit records the files left for the resume command"
+
+	settleFlag  = "settle"
+	settleUsage = "skip files whose size changes during this duration, e.g. 2s, as they are likely still being written to"
+
+	followSymlinksFlag  = "follow-symlinks"
+	followSymlinksAlias = "fs"
+	followSymlinksUsage = "process the targets of symbolic links under their own names instead of the links themselves, updating the links to the renamed targets"
+
+	noFollowFlag  = "no-follow"
+	noFollowAlias = "nf"
+	noFollowUsage = "skip symbolic links"
+
+	linkFlag  = "link"
+	linkAlias = "l"
+	linkUsage = "keep the original files, create hard links (or copies where linking is not possible) with the new names"
+
+	extFlag  = "ext"
+	extAlias = "e"
+	extUsage = "comma separated list of extensions to process (e.g. mp4,mkv,mov). directories default to common video extensions"
+
+	filterFlag  = "filter"
+	filterAlias = "fi"
+	filterUsage = "only process files matching the filter (e.g. label=todo-encode, label!=done, rating>=4), can be repeated"
+
+	previewMontageFlag  = "preview-montage"
+	previewMontageAlias = "pm"
+	previewMontageUsage = "in dry-run mode, show a thumbnail strip of each video next to its new name (inline in kitty, iTerm2 and WezTerm, as an HTML page otherwise)"
+
+	reportFlag  = "report"
+	reportAlias = "rp"
+	reportUsage = "generate a report of the run [html]"
+
+	reportPathFlag  = "report-path"
+	reportPathUsage = "path of the report, defaults to ffr-report-<time>.html"
+
+	summaryWebhookFlag  = "summary-webhook"
+	summaryWebhookUsage = "URL to post the summary of the batch to as JSON: files processed, failures, total size saved and wall time"
+
+	summaryEmailFlag  = "summary-email"
+	summaryEmailUsage = "comma separated list of addresses to email the summary of the batch to, see --smtp-server"
+
+	smtpServerFlag  = "smtp-server"
+	smtpServerUsage = "SMTP server to send the summary email through"
+
+	smtpFromFlag  = "smtp-from"
+	smtpFromUsage = "sender of the summary email, defaults to ffr@<hostname>"
+
+	smtpUserFlag  = "smtp-user"
+	smtpUserUsage = "user to authenticate with at the SMTP server, the password is read from " + smtpPasswordEnv
+
+	resultFlag  = "result"
+	resultUsage = "print a machine-readable result line per processed file to stdout, logs go to stderr [json]"
+
+	fullNamesFlag  = "full-names"
+	fullNamesAlias = "fn"
+	fullNamesUsage = "never truncate file names in tables, e.g. when piping to a file"
+
+	ioLimitFlag  = "io-limit"
+	ioLimitUsage = "maximum read throughput of copies and ffmpeg inputs, e.g. 50MB/s, so that batches on network shares leave bandwidth for others. ffmpeg is limited via -readrate"
+
+	ioRetriesFlag  = "io-retries"
+	ioRetriesUsage = "number of times files failing with transient I/O errors of network shares, e.g. stale handles or timeouts, are retried with a backoff, ffmpeg runs are retried if they report such errors"
+
+	tempDirFlag  = "temp-dir"
+	tempDirUsage = "directory of intermediate files like two-pass logs, defaults to the system's temporary directory, or the directory of the output if that is on another file system"
+
+	jobsFlag  = "jobs"
+	jobsUsage = "number of files probed at the same time, e.g. by info and insert-dimensions"
+
+	uploadFlag  = "upload"
+	uploadUsage = "upload the outputs of encoding commands once they were created, to s3://bucket/prefix using the aws cli or to rclone:remote:path using rclone"
+
+	uploadJobsFlag  = "upload-jobs"
+	uploadJobsUsage = "number of outputs uploaded at the same time"
+
+	uploadRetriesFlag  = "upload-retries"
+	uploadRetriesUsage = "number of times failed uploads are retried, waiting twice as long before every retry"
+
+	uploadDeleteFlag  = "upload-delete"
+	uploadDeleteUsage = "delete outputs once they were uploaded successfully"
+
+	timezoneFlag  = "timezone"
+	timezoneUsage = "timezone to convert creation and modification times to before dating files, e.g. Europe/Budapest, defaults to the local timezone"
+
+	ignoreRotationFlag  = "ignore-rotation"
+	ignoreRotationUsage = "use the dimensions videos are stored in instead of the ones they are displayed in, e.g. 1920x1080 for a phone video recorded in portrait mode"
+
+	streamFlag  = "stream"
+	streamUsage = "index of the video stream to probe in files with more than one video stream"
+
+	logHistoryFlag  = "log-history"
+	logHistoryUsage = "append every message logged during the run to a file at exit, including the ones hidden without --verbose"
+
+	printCommandFlag  = "print-command"
+	printCommandUsage = "print the ffmpeg and ffprobe commands run (or planned in a dry-run) in a copy-pasteable form"
+
+	sortFlag  = "sort"
+	sortAlias = "so"
+	sortUsage = "order of processing files [name, mtime, size, random, none]. name uses natural ordering (file2 before file10)"
+
+	orderFlag  = "order"
+	orderUsage = "priority of encoding files, applied after --sort [shortest-first, longest-first, smallest-first, largest-first]. small files first give quick feedback, the longest one first keeps parallel jobs busy"
 )
 
-func main() {
-	a := App{}
+func newApp() *cli.App {
+	return App{state: newState()}.cliApp()
+}
 
+// cliApp returns the command line interface of the app
+func (a App) cliApp() *cli.App {
 	globalFlags := map[string]cli.Flag{
 		backwardsFlag: &cli.BoolFlag{
 			Name:    backwardsFlag,
@@ -1725,9 +14976,269 @@ func main() {
 			Value:   false,
 			Usage:   verboseUsage,
 		},
+		withSidecarsFlag: &cli.StringFlag{
+			Name:    withSidecarsFlag,
+			Aliases: []string{withSidecarsAlias},
+			Value:   "",
+			Usage:   withSidecarsUsage,
+		},
+		rootFlag: &cli.StringFlag{
+			Name:  rootFlag,
+			Usage: rootUsage,
+		},
+		configFlag: &cli.StringFlag{
+			Name:  configFlag,
+			Value: defaultConfigPath(),
+			Usage: configUsage,
+		},
+		commandHistoryFlag: &cli.StringFlag{
+			Name:  commandHistoryFlag,
+			Value: defaultCommandHistoryPath(),
+			Usage: commandHistoryUsage,
+		},
+		stagingFlag: &cli.StringFlag{
+			Name:  stagingFlag,
+			Value: defaultStagingPath(),
+			Usage: stagingUsage,
+		},
+		skipMissingFlag: &cli.BoolFlag{
+			Name:  skipMissingFlag,
+			Value: false,
+			Usage: skipMissingUsage,
+		},
+		atomicFlag: &cli.BoolFlag{
+			Name:  atomicFlag,
+			Value: false,
+			Usage: atomicUsage,
+		},
+		journalFlag: &cli.StringFlag{
+			Name:    journalFlag,
+			Aliases: []string{journalAlias},
+			Value:   defaultJournalPath(),
+			Usage:   journalUsage,
+		},
+		unitsFlag: &cli.StringFlag{
+			Name:  unitsFlag,
+			Value: unitsSI,
+			Usage: unitsUsage,
+		},
+		precisionFlag: &cli.IntFlag{
+			Name:  precisionFlag,
+			Value: 1,
+			Usage: precisionUsage,
+		},
+		secondsFlag: &cli.BoolFlag{
+			Name:  secondsFlag,
+			Usage: secondsUsage,
+		},
+		quietFlag: &cli.BoolFlag{
+			Name:    quietFlag,
+			Aliases: []string{quietAlias},
+			Usage:   quietUsage,
+		},
+		colorFlag: &cli.StringFlag{
+			Name:  colorFlag,
+			Value: colorAuto,
+			Usage: colorUsage,
+		},
+		noColorFlag: &cli.BoolFlag{
+			Name:  noColorFlag,
+			Usage: noColorUsage,
+		},
+		saveLogsFlag: &cli.StringFlag{
+			Name:  saveLogsFlag,
+			Usage: saveLogsUsage,
+		},
+		includePartialFlag: &cli.BoolFlag{
+			Name:  includePartialFlag,
+			Usage: includePartialUsage,
+		},
+		timeBudgetFlag: &cli.DurationFlag{
+			Name:  timeBudgetFlag,
+			Usage: timeBudgetUsage,
+		},
+		resumeManifestFlag: &cli.StringFlag{
+			Name:  resumeManifestFlag,
+			Value: defaultResumePath(),
+			Usage: resumeManifestUsage,
+		},
+		scheduleFlag: &cli.StringFlag{
+			Name:  scheduleFlag,
+			Usage: scheduleUsage,
+		},
+		onWindowCloseFlag: &cli.StringFlag{
+			Name:  onWindowCloseFlag,
+			Value: windowCloseWait,
+			Usage: onWindowCloseUsage,
+		},
+		settleFlag: &cli.DurationFlag{
+			Name:  settleFlag,
+			Usage: settleUsage,
+		},
+		followSymlinksFlag: &cli.BoolFlag{
+			Name:    followSymlinksFlag,
+			Aliases: []string{followSymlinksAlias},
+			Value:   false,
+			Usage:   followSymlinksUsage,
+		},
+		noFollowFlag: &cli.BoolFlag{
+			Name:    noFollowFlag,
+			Aliases: []string{noFollowAlias},
+			Value:   false,
+			Usage:   noFollowUsage,
+		},
+		linkFlag: &cli.BoolFlag{
+			Name:    linkFlag,
+			Aliases: []string{linkAlias},
+			Value:   false,
+			Usage:   linkUsage,
+		},
+		extFlag: &cli.StringFlag{
+			Name:    extFlag,
+			Aliases: []string{extAlias},
+			Value:   "",
+			Usage:   extUsage,
+		},
+		filterFlag: &cli.StringSliceFlag{
+			Name:    filterFlag,
+			Aliases: []string{filterAlias},
+			Usage:   filterUsage,
+		},
+		previewMontageFlag: &cli.BoolFlag{
+			Name:    previewMontageFlag,
+			Aliases: []string{previewMontageAlias},
+			Value:   false,
+			Usage:   previewMontageUsage,
+		},
+		reportFlag: &cli.StringFlag{
+			Name:    reportFlag,
+			Aliases: []string{reportAlias},
+			Usage:   reportUsage,
+		},
+		reportPathFlag: &cli.StringFlag{
+			Name:  reportPathFlag,
+			Usage: reportPathUsage,
+		},
+		summaryWebhookFlag: &cli.StringFlag{
+			Name:  summaryWebhookFlag,
+			Usage: summaryWebhookUsage,
+		},
+		summaryEmailFlag: &cli.StringFlag{
+			Name:  summaryEmailFlag,
+			Usage: summaryEmailUsage,
+		},
+		smtpServerFlag: &cli.StringFlag{
+			Name:  smtpServerFlag,
+			Value: "localhost:25",
+			Usage: smtpServerUsage,
+		},
+		smtpFromFlag: &cli.StringFlag{
+			Name:  smtpFromFlag,
+			Usage: smtpFromUsage,
+		},
+		smtpUserFlag: &cli.StringFlag{
+			Name:  smtpUserFlag,
+			Usage: smtpUserUsage,
+		},
+		resultFlag: &cli.StringFlag{
+			Name:  resultFlag,
+			Usage: resultUsage,
+		},
+		fullNamesFlag: &cli.BoolFlag{
+			Name:    fullNamesFlag,
+			Aliases: []string{fullNamesAlias},
+			Value:   false,
+			Usage:   fullNamesUsage,
+		},
+		ioRetriesFlag: &cli.IntFlag{
+			Name:  ioRetriesFlag,
+			Value: 3,
+			Usage: ioRetriesUsage,
+		},
+		tempDirFlag: &cli.StringFlag{
+			Name:  tempDirFlag,
+			Usage: tempDirUsage,
+		},
+		ioLimitFlag: &cli.StringFlag{
+			Name:  ioLimitFlag,
+			Usage: ioLimitUsage,
+		},
+		jobsFlag: &cli.IntFlag{
+			Name:  jobsFlag,
+			Value: runtime.NumCPU(),
+			Usage: jobsUsage,
+		},
+		uploadFlag: &cli.StringFlag{
+			Name:  uploadFlag,
+			Usage: uploadUsage,
+		},
+		uploadJobsFlag: &cli.IntFlag{
+			Name:  uploadJobsFlag,
+			Value: defaultUploadJobs,
+			Usage: uploadJobsUsage,
+		},
+		uploadRetriesFlag: &cli.IntFlag{
+			Name:  uploadRetriesFlag,
+			Value: defaultUploadRetries,
+			Usage: uploadRetriesUsage,
+		},
+		uploadDeleteFlag: &cli.BoolFlag{
+			Name:  uploadDeleteFlag,
+			Value: false,
+			Usage: uploadDeleteUsage,
+		},
+		timezoneFlag: &cli.StringFlag{
+			Name:  timezoneFlag,
+			Usage: timezoneUsage,
+		},
+		ignoreRotationFlag: &cli.BoolFlag{
+			Name:  ignoreRotationFlag,
+			Value: false,
+			Usage: ignoreRotationUsage,
+		},
+		streamFlag: &cli.IntFlag{
+			Name:  streamFlag,
+			Value: 0,
+			Usage: streamUsage,
+		},
+		logHistoryFlag: &cli.StringFlag{
+			Name:  logHistoryFlag,
+			Usage: logHistoryUsage,
+		},
+		printCommandFlag: &cli.BoolFlag{
+			Name:  printCommandFlag,
+			Value: false,
+			Usage: printCommandUsage,
+		},
+		sortFlag: &cli.StringFlag{
+			Name:    sortFlag,
+			Aliases: []string{sortAlias},
+			Value:   sortName,
+			Usage:   sortUsage,
+		},
+		orderFlag: &cli.StringFlag{
+			Name:  orderFlag,
+			Usage: orderUsage,
+		},
 	}
 
 	commandFlags := map[string]cli.Flag{
+		updateIndexFlag: &cli.BoolFlag{
+			Name:  updateIndexFlag,
+			Value: false,
+			Usage: updateIndexUsage,
+		},
+		discardFlag: &cli.BoolFlag{
+			Name:  discardFlag,
+			Value: false,
+			Usage: discardUsage,
+		},
+		pickFlag: &cli.BoolFlag{
+			Name:    pickFlag,
+			Aliases: []string{pickAlias},
+			Value:   false,
+			Usage:   pickUsage,
+		},
 		codecFlag: &cli.StringFlag{
 			Name:  codecFlag,
 			Usage: codecUsage,
@@ -1742,6 +15253,173 @@ func main() {
 			Name:  crfFlag,
 			Usage: crfUsage,
 		},
+		containerFlag: &cli.StringFlag{
+			Name:  containerFlag,
+			Usage: containerUsage,
+		},
+		bitDepthFlag: &cli.IntFlag{
+			Name:    bitDepthFlag,
+			Aliases: []string{bitDepthAlias},
+			Usage:   bitDepthUsage,
+		},
+		profileFlag: &cli.StringFlag{
+			Name:  profileFlag,
+			Usage: profileUsage,
+		},
+		tuneFlag: &cli.StringFlag{
+			Name:  tuneFlag,
+			Usage: tuneUsage,
+		},
+		x265ParamsFlag: &cli.StringFlag{
+			Name:  x265ParamsFlag,
+			Usage: x265ParamsUsage,
+		},
+		x264ParamsFlag: &cli.StringFlag{
+			Name:  x264ParamsFlag,
+			Usage: x264ParamsUsage,
+		},
+		outputsFlag: &cli.StringSliceFlag{
+			Name:    outputsFlag,
+			Aliases: []string{outputsAlias},
+			Usage:   outputsUsage,
+		},
+		keyIntFlag: &cli.StringFlag{
+			Name:    keyIntFlag,
+			Aliases: []string{keyIntAlias},
+			Value:   defaultKeyInt,
+			Usage:   keyIntUsage,
+		},
+		cfrFlag: &cli.BoolFlag{
+			Name:  cfrFlag,
+			Usage: cfrUsage,
+		},
+		printOutputNamesFlag: &cli.BoolFlag{
+			Name:  printOutputNamesFlag,
+			Usage: printOutputNamesUsage,
+		},
+		manDirFlag: &cli.StringFlag{
+			Name:  manDirFlag,
+			Usage: manDirUsage,
+		},
+		sectionFlag: &cli.IntFlag{
+			Name:  sectionFlag,
+			Value: 1,
+			Usage: sectionUsage,
+		},
+		nameTemplateFlag: &cli.StringFlag{
+			Name:  nameTemplateFlag,
+			Usage: nameTemplateUsage,
+		},
+		noSuffixFlag: &cli.BoolFlag{
+			Name:  noSuffixFlag,
+			Usage: noSuffixUsage,
+		},
+		outputDirFlag: &cli.StringFlag{
+			Name:  outputDirFlag,
+			Usage: outputDirUsage,
+		},
+		allIntraFlag: &cli.BoolFlag{
+			Name:  allIntraFlag,
+			Usage: allIntraUsage,
+		},
+		dateRegexFlag: &cli.StringFlag{
+			Name:  dateRegexFlag,
+			Usage: dateRegexUsage,
+		},
+		dateFormatFlag: &cli.StringFlag{
+			Name:  dateFormatFlag,
+			Usage: dateFormatUsage,
+		},
+		metadataDateFlag: &cli.BoolFlag{
+			Name:  metadataDateFlag,
+			Usage: metadataDateUsage,
+		},
+		factorFlag: &cli.Float64Flag{
+			Name:    factorFlag,
+			Aliases: []string{factorAlias},
+			Usage:   factorUsage,
+		},
+		durationFlag: &cli.Float64Flag{
+			Name:  durationFlag,
+			Usage: durationUsage,
+		},
+		dropAudioFlag: &cli.BoolFlag{
+			Name:    dropAudioFlag,
+			Aliases: []string{dropAudioAlias},
+			Usage:   dropAudioUsage,
+		},
+		repeatFlag: &cli.IntFlag{
+			Name:    repeatFlag,
+			Aliases: []string{repeatAlias},
+			Value:   2,
+			Usage:   repeatUsage,
+		},
+		boomerangFlag: &cli.BoolFlag{
+			Name:    boomerangFlag,
+			Aliases: []string{boomerangAlias},
+			Usage:   boomerangUsage,
+		},
+		offsetFlag: &cli.Float64Flag{
+			Name:  offsetFlag,
+			Usage: offsetUsage,
+		},
+		addTrackFlag: &cli.BoolFlag{
+			Name:    addTrackFlag,
+			Aliases: []string{addTrackAlias},
+			Usage:   addTrackUsage,
+		},
+		shortestFlag: &cli.BoolFlag{
+			Name:  shortestFlag,
+			Usage: shortestUsage,
+		},
+		channelFlag: &cli.StringFlag{
+			Name:  channelFlag,
+			Usage: channelUsage,
+		},
+		filterGraphFlag: &cli.StringFlag{
+			Name:  filterGraphFlag,
+			Usage: filterGraphUsage,
+		},
+		tagFlag: &cli.StringFlag{
+			Name:  tagFlag,
+			Value: "out",
+			Usage: tagUsage,
+		},
+		outputExtFlag: &cli.StringFlag{
+			Name:  outputExtFlag,
+			Usage: outputExtUsage,
+		},
+		fpsFlag: &cli.Float64Flag{
+			Name:  fpsFlag,
+			Usage: fpsUsage,
+		},
+		imageFormatFlag: &cli.StringFlag{
+			Name:  imageFormatFlag,
+			Usage: imageFormatUsage,
+		},
+		audioCodecFlag: &cli.StringFlag{
+			Name:    audioCodecFlag,
+			Aliases: []string{audioCodecAlias},
+			Value:   defaultAudioCodec,
+			Usage:   audioCodecUsage,
+		},
+		audioBitRateFlag: &cli.StringFlag{
+			Name:    audioBitRateFlag,
+			Aliases: []string{audioBitRateAlias},
+			Value:   "128k",
+			Usage:   audioBitRateUsage,
+		},
+		vbrQualityFlag: &cli.IntFlag{
+			Name:    vbrQualityFlag,
+			Aliases: []string{vbrQualityAlias},
+			Value:   -1,
+			Usage:   vbrQualityUsage,
+		},
+		channelsFlag: &cli.IntFlag{
+			Name:    channelsFlag,
+			Aliases: []string{channelsAlias},
+			Usage:   channelsUsage,
+		},
 		hwaccelFlag: &cli.StringFlag{
 			Name:    hwaccelFlag,
 			Aliases: []string{hwaccelAlias},
@@ -1780,6 +15458,58 @@ func main() {
 			Value:   false,
 			Usage:   skipDuplicateUsage,
 		},
+		formFlag: &cli.StringFlag{
+			Name:  formFlag,
+			Value: rename.NFC,
+			Usage: formUsage,
+		},
+		asciiFlag: &cli.BoolFlag{
+			Name:  asciiFlag,
+			Usage: asciiUsage,
+		},
+		noMojibakeFlag: &cli.BoolFlag{
+			Name:  noMojibakeFlag,
+			Usage: noMojibakeUsage,
+		},
+		positionFlag: &cli.IntFlag{
+			Name:  positionFlag,
+			Value: 0,
+			Usage: positionUsage,
+		},
+		paddingFlag: &cli.IntFlag{
+			Name:  paddingFlag,
+			Value: 0,
+			Usage: paddingUsage,
+		},
+		maxRomanFlag: &cli.IntFlag{
+			Name:  maxRomanFlag,
+			Value: defaultMaxRoman,
+			Usage: maxRomanUsage,
+		},
+		noRomanFlag: &cli.BoolFlag{
+			Name:  noRomanFlag,
+			Usage: noRomanUsage,
+		},
+		noWordsFlag: &cli.BoolFlag{
+			Name:  noWordsFlag,
+			Usage: noWordsUsage,
+		},
+		multiplyFlag: &cli.BoolFlag{
+			Name:  multiplyFlag,
+			Usage: multiplyUsage,
+		},
+		setFlag: &cli.BoolFlag{
+			Name:  setFlag,
+			Usage: setUsage,
+		},
+		tagsFlag: &cli.StringFlag{
+			Name:  tagsFlag,
+			Usage: tagsUsage,
+		},
+		timeUnitsFlag: &cli.BoolFlag{
+			Name:  timeUnitsFlag,
+			Usage: timeUnitsUsage,
+		},
 		deleteTextFlag: &cli.StringFlag{
 			Name:    deleteTextFlag,
 			Aliases: []string{deleteTextAlias},
@@ -1810,48 +15540,266 @@ func main() {
 			Value:   false,
 			Usage:   fromBackUsage,
 		},
-		skipKeyframesFlag: &cli.BoolFlag{
-			Name:    skipKeyframesFlag,
-			Aliases: []string{skipKeyframesAlias},
-			Value:   false,
-			Usage:   skipKeyframesUsage,
+		skipKeyframesFlag: &cli.BoolFlag{
+			Name:    skipKeyframesFlag,
+			Aliases: []string{skipKeyframesAlias},
+			Value:   false,
+			Usage:   skipKeyframesUsage,
+		},
+		columnsFlag: &cli.StringFlag{
+			Name:    columnsFlag,
+			Aliases: []string{columnsAlias},
+			Usage:   columnsUsage,
+		},
+		maxNameLengthFlag: &cli.IntFlag{
+			Name:    maxNameLengthFlag,
+			Aliases: []string{maxNameLengthAlias},
+			Usage:   maxNameLengthUsage,
+		},
+		dimensionPresetFlag: &cli.StringFlag{
+			Name:    dimensionPresetFlag,
+			Aliases: []string{dimensionPresetAlias},
+			Usage:   dimensionPresetUsage,
+		},
+		widthFlag: &cli.IntFlag{
+			Name:  widthFlag,
+			Usage: widthUsage,
+		},
+		heightFlag: &cli.IntFlag{
+			Name:  heightFlag,
+			Usage: heightUsage,
+		},
+		xFlag: &cli.StringFlag{
+			Name:  xFlag,
+			Usage: xUsage,
+		},
+		yFlag: &cli.StringFlag{
+			Name:  yFlag,
+			Usage: yUsage,
+		},
+		trimSilenceFlag: &cli.BoolFlag{
+			Name:    trimSilenceFlag,
+			Aliases: []string{trimSilenceAlias},
+			Value:   false,
+			Usage:   trimSilenceUsage,
+		},
+		deleteOriginalFlag: &cli.BoolFlag{
+			Name:    deleteOriginalFlag,
+			Aliases: []string{deleteOriginalAlias},
+			Value:   false,
+			Usage:   deleteOriginalUsage,
+		},
+		permanentFlag: &cli.BoolFlag{
+			Name:  permanentFlag,
+			Usage: permanentUsage,
+		},
+		matchFlag: &cli.StringFlag{
+			Name:  matchFlag,
+			Value: mirrorByIndex,
+			Usage: matchUsage,
+		},
+		toleranceFlag: &cli.Float64Flag{
+			Name:  toleranceFlag,
+			Value: defaultDurationTolerance,
+			Usage: toleranceUsage,
+		},
+		targetExtFlag: &cli.StringFlag{
+			Name:  targetExtFlag,
+			Usage: targetExtUsage,
+		},
+		noDurationFlag: &cli.BoolFlag{
+			Name:  noDurationFlag,
+			Usage: noDurationUsage,
+		},
+		mappingFormatFlag: &cli.StringFlag{
+			Name:  mappingFormatFlag,
+			Usage: mappingFormatUsage,
+		},
+		similarityFlag: &cli.Float64Flag{
+			Name:  similarityFlag,
+			Value: defaultSimilarity,
+			Usage: similarityUsage,
+		},
+		proposeFlag: &cli.BoolFlag{
+			Name:  proposeFlag,
+			Usage: proposeUsage,
+		},
+		fixFlag: &cli.BoolFlag{
+			Name:  fixFlag,
+			Usage: fixUsage,
+		},
+		formatFlag: &cli.StringFlag{
+			Name:    formatFlag,
+			Aliases: []string{formatAlias},
+			Value:   formatTable,
+			Usage:   formatUsage,
+		},
+		sinceFlag: &cli.StringFlag{
+			Name:  sinceFlag,
+			Usage: sinceUsage,
+		},
+		untilFlag: &cli.StringFlag{
+			Name:  untilFlag,
+			Usage: untilUsage,
+		},
+		commandFilterFlag: &cli.StringFlag{
+			Name:    commandFilterFlag,
+			Aliases: []string{commandFilterAlias},
+			Usage:   commandFilterUsage,
+		},
+		fileFilterFlag: &cli.StringFlag{
+			Name:  fileFilterFlag,
+			Usage: fileFilterUsage,
+		},
+		exportFlag: &cli.StringFlag{
+			Name:  exportFlag,
+			Value: formatTable,
+			Usage: exportUsage,
+		},
+		templateFlag: &cli.StringFlag{
+			Name:    templateFlag,
+			Aliases: []string{templateAlias},
+			Value:   defaultOrganizeTemplate,
+			Usage:   templateUsage,
+		},
+		langFlag: &cli.StringSliceFlag{
+			Name:  langFlag,
+			Usage: langUsage,
+		},
+		detectFlag: &cli.BoolFlag{
+			Name:  detectFlag,
+			Usage: detectUsage,
+		},
+		textFlag: &cli.StringFlag{
+			Name:  textFlag,
+			Usage: textUsage,
+		},
+		placementFlag: &cli.StringFlag{
+			Name:  placementFlag,
+			Value: defaultOverlayPlacement,
+			Usage: placementUsage,
+		},
+		fontSizeFlag: &cli.IntFlag{
+			Name:  fontSizeFlag,
+			Usage: fontSizeUsage,
+		},
+		noBoxFlag: &cli.BoolFlag{
+			Name:  noBoxFlag,
+			Usage: noBoxUsage,
+		},
+		proxyFormatFlag: &cli.StringFlag{
+			Name:  proxyFormatFlag,
+			Value: proxyH264,
+			Usage: proxyFormatUsage,
+		},
+		proxyHeightFlag: &cli.IntFlag{
+			Name:  proxyHeightFlag,
+			Value: defaultProxyHeight,
+			Usage: proxyHeightUsage,
+		},
+		archiveCodecFlag: &cli.StringFlag{
+			Name:  archiveCodecFlag,
+			Value: archiveFFV1,
+			Usage: archiveCodecUsage,
+		},
+		noVerifyFlag: &cli.BoolFlag{
+			Name:  noVerifyFlag,
+			Usage: noVerifyUsage,
 		},
-		maxNameLengthFlag: &cli.IntFlag{
-			Name:    maxNameLengthFlag,
-			Aliases: []string{maxNameLengthAlias},
-			Value:   maxNameLengthDefault,
-			Usage:   maxNameLengthUsage,
+		hashFlag: &cli.StringFlag{
+			Name:  hashFlag,
+			Value: defaultHashAlgorithm,
+			Usage: hashUsage,
 		},
-		dimensionPresetFlag: &cli.StringFlag{
-			Name:    dimensionPresetFlag,
-			Aliases: []string{dimensionPresetAlias},
-			Usage:   dimensionPresetUsage,
+		framesFlag: &cli.BoolFlag{
+			Name:  framesFlag,
+			Usage: framesUsage,
 		},
-		widthFlag: &cli.IntFlag{
-			Name:  widthFlag,
-			Usage: widthUsage,
+		compareFlag: &cli.BoolFlag{
+			Name:  compareFlag,
+			Usage: compareUsage,
 		},
-		heightFlag: &cli.IntFlag{
-			Name:  heightFlag,
-			Usage: heightUsage,
+		minLengthFlag: &cli.Float64Flag{
+			Name:  minLengthFlag,
+			Value: defaultMinTitleLength,
+			Usage: minLengthUsage,
 		},
-		xFlag: &cli.StringFlag{
-			Name:  xFlag,
-			Usage: xUsage,
+		clipLengthFlag: &cli.Float64Flag{
+			Name:  clipLengthFlag,
+			Value: defaultClipLength,
+			Usage: clipLengthUsage,
 		},
-		yFlag: &cli.StringFlag{
-			Name:  yFlag,
-			Usage: yUsage,
+		targetSizeFlag: &cli.StringFlag{
+			Name:  targetSizeFlag,
+			Usage: targetSizeUsage,
+		},
+		maxHeightFlag: &cli.IntFlag{
+			Name:  maxHeightFlag,
+			Usage: maxHeightUsage,
 		},
 	}
 
+	encoders := commandSet{}
+
 	app := &cli.App{
-		Name: "ffr",
+		Name:     "ffr",
+		Metadata: map[string]interface{}{encodingMetadataKey: encoders},
 		Flags: []cli.Flag{
 			globalFlags[backwardsFlag],
 			globalFlags[dryRunFlag],
 			globalFlags[forceFlag],
 			globalFlags[verboseFlag],
+			globalFlags[withSidecarsFlag],
+			globalFlags[rootFlag],
+			globalFlags[configFlag],
+			globalFlags[journalFlag],
+			globalFlags[atomicFlag],
+			globalFlags[skipMissingFlag],
+			globalFlags[commandHistoryFlag],
+			globalFlags[stagingFlag],
+			globalFlags[unitsFlag],
+			globalFlags[precisionFlag],
+			globalFlags[secondsFlag],
+			globalFlags[quietFlag],
+			globalFlags[colorFlag],
+			globalFlags[noColorFlag],
+			globalFlags[saveLogsFlag],
+			globalFlags[includePartialFlag],
+			globalFlags[settleFlag],
+			globalFlags[timeBudgetFlag],
+			globalFlags[resumeManifestFlag],
+			globalFlags[scheduleFlag],
+			globalFlags[onWindowCloseFlag],
+			globalFlags[followSymlinksFlag],
+			globalFlags[noFollowFlag],
+			globalFlags[linkFlag],
+			globalFlags[extFlag],
+			globalFlags[sortFlag],
+			globalFlags[orderFlag],
+			globalFlags[filterFlag],
+			globalFlags[previewMontageFlag],
+			globalFlags[reportFlag],
+			globalFlags[reportPathFlag],
+			globalFlags[summaryWebhookFlag],
+			globalFlags[summaryEmailFlag],
+			globalFlags[smtpServerFlag],
+			globalFlags[smtpFromFlag],
+			globalFlags[smtpUserFlag],
+			globalFlags[resultFlag],
+			globalFlags[fullNamesFlag],
+			globalFlags[printCommandFlag],
+			globalFlags[logHistoryFlag],
+			globalFlags[streamFlag],
+			globalFlags[timezoneFlag],
+			globalFlags[ignoreRotationFlag],
+			globalFlags[jobsFlag],
+			globalFlags[ioLimitFlag],
+			globalFlags[ioRetriesFlag],
+			globalFlags[tempDirFlag],
+			globalFlags[uploadFlag],
+			globalFlags[uploadJobsFlag],
+			globalFlags[uploadRetriesFlag],
+			globalFlags[uploadDeleteFlag],
 		},
 		Commands: []*cli.Command{
 			{
@@ -1864,9 +15812,11 @@ func main() {
 					commandFlags[regexpFlag],
 					commandFlags[regexpGroupFlag],
 					commandFlags[skipFindsFlag],
+					commandFlags[multiplyFlag],
+					commandFlags[setFlag],
 				},
 				Action: func(c *cli.Context) error {
-					return process(c, 1, a.addNumber)
+					return process(a.state, c, 1, a.addNumber)
 				},
 			},
 			{
@@ -1879,7 +15829,7 @@ func main() {
 					commandFlags[partsFlag],
 				},
 				Action: func(c *cli.Context) error {
-					return process(c, 1, a.deleteParts)
+					return process(a.state, c, 1, a.deleteParts)
 				},
 			},
 			{
@@ -1894,7 +15844,7 @@ func main() {
 					commandFlags[skipPartsFlag],
 				},
 				Action: func(c *cli.Context) error {
-					return process(c, 0, a.deleteRegexp)
+					return process(a.state, c, 0, a.deleteRegexp)
 				},
 			},
 			{
@@ -1908,7 +15858,7 @@ func main() {
 					commandFlags[skipDuplicateFlag],
 				},
 				Action: func(c *cli.Context) error {
-					return process(c, 1, a.insertBefore)
+					return process(a.state, c, 1, a.insertBefore)
 				},
 			},
 			{
@@ -1922,7 +15872,7 @@ func main() {
 					commandFlags[skipDuplicateFlag],
 				},
 				Action: func(c *cli.Context) error {
-					return process(c, 0, a.insertDimensionsBefore)
+					return process(a.state, c, 0, a.insertDimensionsBefore)
 				},
 			},
 			{
@@ -1932,9 +15882,21 @@ func main() {
 				ArgsUsage: keyFramesArgsUsage,
 				Flags:     []cli.Flag{},
 				Action: func(c *cli.Context) error {
-					return process(c, 0, a.keyFrames)
+					return process(a.state, c, 0, a.keyFrames)
 				},
 			},
+			encoders.mark(&cli.Command{
+				Name:      previewClipCommand,
+				Aliases:   strings.Split(previewClipAliases, ", "),
+				Usage:     previewClipUsage,
+				ArgsUsage: previewClipArgsUsage,
+				Flags: []cli.Flag{
+					commandFlags[clipLengthFlag],
+				},
+				Action: func(c *cli.Context) error {
+					return process(a.state, c, 1, a.previewClip)
+				},
+			}),
 			{
 				Name:      mergePartsCommand,
 				Aliases:   strings.Split(mergePartsAliases, ", "),
@@ -1943,10 +15905,56 @@ func main() {
 				Flags: []cli.Flag{
 					commandFlags[deleteTextFlag],
 					commandFlags[regexpFlag],
+					commandFlags[tagsFlag],
+					commandFlags[timeUnitsFlag],
 					commandFlags[skipPartsFlag],
 				},
 				Action: func(c *cli.Context) error {
-					return process(c, 0, a.mergeParts)
+					return process(a.state, c, 0, a.mergeParts)
+				},
+			},
+			{
+				Name:      normalizeNumbersCommand,
+				Aliases:   strings.Split(normalizeNumbersAliases, ", "),
+				Usage:     normalizeNumbersUsage,
+				ArgsUsage: normalizeNumbersArgsUsage,
+				Flags: []cli.Flag{
+					commandFlags[paddingFlag],
+					commandFlags[maxRomanFlag],
+					commandFlags[noRomanFlag],
+					commandFlags[noWordsFlag],
+				},
+				Action: func(c *cli.Context) error {
+					return process(a.state, c, 0, a.normalizeNumbers)
+				},
+			},
+			{
+				Name:      normalizeUnicodeCommand,
+				Aliases:   strings.Split(normalizeUnicodeAliases, ", "),
+				Usage:     normalizeUnicodeUsage,
+				ArgsUsage: normalizeUnicodeArgsUsage,
+				Flags: []cli.Flag{
+					commandFlags[formFlag],
+					commandFlags[asciiFlag],
+					commandFlags[noMojibakeFlag],
+				},
+				Action: func(c *cli.Context) error {
+					return process(a.state, c, 0, a.normalizeUnicode)
+				},
+			},
+			{
+				Name:      padNumbersCommand,
+				Aliases:   strings.Split(padNumbersAliases, ", "),
+				Usage:     padNumbersUsage,
+				ArgsUsage: padNumbersArgsUsage,
+				Flags: []cli.Flag{
+					commandFlags[regexpFlag],
+					commandFlags[regexpGroupFlag],
+					commandFlags[positionFlag],
+					commandFlags[fromBackFlag],
+				},
+				Action: func(c *cli.Context) error {
+					return process(a.state, c, 1, a.padNumbers)
 				},
 			},
 			{
@@ -1958,10 +15966,10 @@ func main() {
 					commandFlags[skipPartsFlag],
 				},
 				Action: func(c *cli.Context) error {
-					return process(c, 1, a.prefix)
+					return process(a.state, c, 1, a.prefix)
 				},
 			},
-			{
+			encoders.mark(&cli.Command{
 				Name:        reencodeCommand,
 				Usage:       reencodeUsage,
 				ArgsUsage:   reencodeArgsUsage,
@@ -1970,13 +15978,261 @@ func main() {
 					commandFlags[codecFlag],
 					commandFlags[crfFlag],
 					commandFlags[presetFlag],
+					commandFlags[bitDepthFlag],
+					commandFlags[profileFlag],
+					commandFlags[containerFlag],
+					commandFlags[tuneFlag],
+					commandFlags[x265ParamsFlag],
+					commandFlags[x264ParamsFlag],
+					commandFlags[keyIntFlag],
+					commandFlags[allIntraFlag],
+					commandFlags[cfrFlag],
+					commandFlags[outputsFlag],
+					commandFlags[targetSizeFlag],
+					commandFlags[maxHeightFlag],
+					commandFlags[audioBitRateFlag],
 					commandFlags[hwaccelFlag],
 					commandFlags[hwaccelDeviceFlag],
+					commandFlags[printOutputNamesFlag],
+					commandFlags[nameTemplateFlag],
+					commandFlags[noSuffixFlag],
+					commandFlags[outputDirFlag],
+				},
+				Action: func(c *cli.Context) error {
+					if c.Bool(printOutputNamesFlag) {
+						_ = c.Set(dryRunFlag, "true")
+						_ = c.Set(printCommandFlag, "true")
+					}
+
+					return process(a.state, c, 0, a.reEncode)
+				},
+			}),
+			encoders.mark(&cli.Command{
+				Name:      timelapseCommand,
+				Aliases:   strings.Split(timelapseAliases, ", "),
+				Usage:     timelapseUsage,
+				ArgsUsage: timelapseArgsUsage,
+				Flags: []cli.Flag{
+					commandFlags[factorFlag],
+					commandFlags[durationFlag],
+					commandFlags[dropAudioFlag],
+					commandFlags[codecFlag],
+					commandFlags[crfFlag],
+				},
+				Action: func(c *cli.Context) error {
+					return process(a.state, c, 0, a.timelapse)
+				},
+			}),
+			{
+				Name:      ingestDiscCommand,
+				Aliases:   strings.Split(ingestDiscAliases, ", "),
+				Usage:     ingestDiscUsage,
+				ArgsUsage: ingestDiscArgsUsage,
+				Flags: []cli.Flag{
+					commandFlags[minLengthFlag],
+				},
+				Action: a.ingestDisc,
+			},
+			encoders.mark(&cli.Command{
+				Name:      archiveCommand,
+				Aliases:   strings.Split(archiveAliases, ", "),
+				Usage:     archiveUsage,
+				ArgsUsage: archiveArgsUsage,
+				Flags: []cli.Flag{
+					commandFlags[archiveCodecFlag],
+					commandFlags[noVerifyFlag],
+				},
+				Action: func(c *cli.Context) error {
+					return process(a.state, c, 0, a.archive)
+				},
+			}),
+			encoders.mark(&cli.Command{
+				Name:      proxyCommand,
+				Aliases:   strings.Split(proxyAliases, ", "),
+				Usage:     proxyUsage,
+				ArgsUsage: proxyArgsUsage,
+				Flags: []cli.Flag{
+					commandFlags[proxyFormatFlag],
+					commandFlags[proxyHeightFlag],
+				},
+				Action: func(c *cli.Context) error {
+					return process(a.state, c, 0, a.proxy)
+				},
+			}),
+			encoders.mark(&cli.Command{
+				Name:      restoreSDCommand,
+				Aliases:   strings.Split(restoreSDAliases, ", "),
+				Usage:     restoreSDUsage,
+				ArgsUsage: restoreSDArgsUsage,
+				Flags: []cli.Flag{
+					commandFlags[codecFlag],
+					commandFlags[crfFlag],
+					commandFlags[presetFlag],
+				},
+				Action: func(c *cli.Context) error {
+					return process(a.state, c, 0, a.restoreSD)
+				},
+			}),
+			encoders.mark(&cli.Command{
+				Name:      overlayTextCommand,
+				Aliases:   strings.Split(overlayTextAliases, ", "),
+				Usage:     overlayTextUsage,
+				ArgsUsage: overlayTextArgsUsage,
+				Flags: []cli.Flag{
+					commandFlags[textFlag],
+					commandFlags[placementFlag],
+					commandFlags[fontSizeFlag],
+					commandFlags[noBoxFlag],
+					commandFlags[codecFlag],
+					commandFlags[crfFlag],
+				},
+				Action: func(c *cli.Context) error {
+					return process(a.state, c, 1, a.overlayText)
+				},
+			}),
+			encoders.mark(&cli.Command{
+				Name:      loopCommand,
+				Aliases:   strings.Split(loopAliases, ", "),
+				Usage:     loopUsage,
+				ArgsUsage: loopArgsUsage,
+				Flags: []cli.Flag{
+					commandFlags[repeatFlag],
+					commandFlags[boomerangFlag],
+				},
+				Action: func(c *cli.Context) error {
+					return process(a.state, c, 0, a.loop)
+				},
+			}),
+			encoders.mark(&cli.Command{
+				Name:      muxAudioCommand,
+				Aliases:   strings.Split(muxAudioAliases, ", "),
+				Usage:     muxAudioUsage,
+				ArgsUsage: muxAudioArgsUsage,
+				Flags: []cli.Flag{
+					commandFlags[offsetFlag],
+					commandFlags[addTrackFlag],
+					commandFlags[shortestFlag],
+				},
+				Action: func(c *cli.Context) error {
+					return process(a.state, c, 1, a.muxAudio)
+				},
+			}),
+			encoders.mark(&cli.Command{
+				Name:      audioChannelsCommand,
+				Aliases:   strings.Split(audioChannelsAliases, ", "),
+				Usage:     audioChannelsUsage,
+				ArgsUsage: audioChannelsArgsUsage,
+				Flags: []cli.Flag{
+					commandFlags[channelFlag],
+				},
+				Action: func(c *cli.Context) error {
+					return process(a.state, c, 1, a.audioChannels)
+				},
+			}),
+			{
+				Name:      langTagCommand,
+				Aliases:   strings.Split(langTagAliases, ", "),
+				Usage:     langTagUsage,
+				ArgsUsage: langTagArgsUsage,
+				Flags: []cli.Flag{
+					commandFlags[langFlag],
+					commandFlags[detectFlag],
 				},
 				Action: func(c *cli.Context) error {
-					return process(c, 0, a.reEncode)
+					return process(a.state, c, 0, a.langTag)
 				},
 			},
+			encoders.mark(&cli.Command{
+				Name:        filterCommand,
+				Aliases:     strings.Split(filterAliases, ", "),
+				Usage:       filterCommandUsage,
+				ArgsUsage:   filterArgsUsage,
+				Description: filterDescription,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  filterPresetFlag,
+						Usage: filterPresetUsage,
+					},
+					commandFlags[filterGraphFlag],
+				},
+				Action: func(c *cli.Context) error {
+					return process(a.state, c, 0, a.filter)
+				},
+			}),
+			encoders.mark(&cli.Command{
+				Name:        eachCommand,
+				Aliases:     strings.Split(eachAliases, ", "),
+				Usage:       eachUsage,
+				ArgsUsage:   eachArgsUsage,
+				Description: eachDescription,
+				Flags: []cli.Flag{
+					commandFlags[tagFlag],
+					commandFlags[outputExtFlag],
+				},
+				Action: func(c *cli.Context) error {
+					return process(a.state, c, 0, a.each)
+				},
+			}),
+			encoders.mark(&cli.Command{
+				Name:      framesExportCommand,
+				Aliases:   strings.Split(framesExportAliases, ", "),
+				Usage:     framesExportUsage,
+				ArgsUsage: framesExportArgsUsage,
+				Flags: []cli.Flag{
+					commandFlags[imageFormatFlag],
+					commandFlags[fpsFlag],
+				},
+				Action: func(c *cli.Context) error {
+					return process(a.state, c, 0, a.framesExport)
+				},
+			}),
+			encoders.mark(&cli.Command{
+				Name:      framesImportCommand,
+				Aliases:   strings.Split(framesImportAliases, ", "),
+				Usage:     framesImportUsage,
+				ArgsUsage: framesImportArgsUsage,
+				Flags: []cli.Flag{
+					commandFlags[codecFlag],
+					commandFlags[crfFlag],
+					commandFlags[fpsFlag],
+				},
+				Action: func(c *cli.Context) error {
+					_ = c.Set(backwardsFlag, "false")
+
+					return processAll(a.state, c, 1, a.framesImport)
+				},
+			}),
+			encoders.mark(&cli.Command{
+				Name:      convertImageCommand,
+				Aliases:   strings.Split(convertImageAliases, ", "),
+				Usage:     convertImageUsage,
+				ArgsUsage: convertImageArgsUsage,
+				Flags: []cli.Flag{
+					commandFlags[imageFormatFlag],
+					commandFlags[widthFlag],
+					commandFlags[heightFlag],
+					commandFlags[dimensionPresetFlag],
+				},
+				Action: func(c *cli.Context) error {
+					return process(a.state, c, 0, a.convertImage)
+				},
+			}),
+			encoders.mark(&cli.Command{
+				Name:        reencodeAudioCommand,
+				Aliases:     strings.Split(reencodeAudioAliases, ", "),
+				Usage:       reencodeAudioUsage,
+				ArgsUsage:   reencodeAudioArgsUsage,
+				Description: reencodeAudioDescription,
+				Flags: []cli.Flag{
+					commandFlags[audioCodecFlag],
+					commandFlags[audioBitRateFlag],
+					commandFlags[vbrQualityFlag],
+					commandFlags[channelsFlag],
+				},
+				Action: func(c *cli.Context) error {
+					return process(a.state, c, 0, a.reEncodeAudio)
+				},
+			}),
 			{
 				Name:      replaceCommand,
 				Aliases:   strings.Split(replaceAliases, ", "),
@@ -1986,7 +16242,7 @@ func main() {
 					commandFlags[skipFindsFlag],
 				},
 				Action: func(c *cli.Context) error {
-					return process(c, 2, a.replace)
+					return process(a.state, c, 2, a.replace)
 				},
 			},
 			{
@@ -1998,10 +16254,10 @@ func main() {
 					commandFlags[skipPartsFlag],
 				},
 				Action: func(c *cli.Context) error {
-					return process(c, 1, a.suffix)
+					return process(a.state, c, 1, a.suffix)
 				},
 			},
-			{
+			encoders.mark(&cli.Command{
 				Name:      cropCommand,
 				Aliases:   strings.Split(cropAliases, ", "),
 				Usage:     cropUsage,
@@ -2014,9 +16270,9 @@ func main() {
 					commandFlags[dimensionPresetFlag],
 				},
 				Action: func(c *cli.Context) error {
-					return process(c, 0, a.crop)
+					return process(a.state, c, 0, a.crop)
 				},
-			},
+			}),
 			{
 				Name:      infoCommand,
 				Aliases:   strings.Split(infoAliases, ", "),
@@ -2025,11 +16281,12 @@ func main() {
 				Flags: []cli.Flag{
 					commandFlags[skipKeyframesFlag],
 					commandFlags[maxNameLengthFlag],
+					commandFlags[columnsFlag],
 				},
 				Action: func(c *cli.Context) error {
 					_ = c.Set(backwardsFlag, "0")
 
-					return processAll(c, 0, a.infoAll)
+					return processAll(a.state, c, 0, a.infoAll)
 				},
 			},
 			{
@@ -2037,15 +16294,373 @@ func main() {
 				Aliases:   strings.Split(datePrefixAliases, ", "),
 				Usage:     datePrefixUsage,
 				ArgsUsage: datePrefixArgsUsage,
+				Flags: []cli.Flag{
+					commandFlags[dateRegexFlag],
+					commandFlags[dateFormatFlag],
+					commandFlags[metadataDateFlag],
+					&cli.StringFlag{
+						Name:  pickFlag,
+						Usage: datePickUsage,
+					},
+				},
+				Action: func(c *cli.Context) error {
+					return process(a.state, c, 0, a.datePrefix)
+				},
+			},
+			encoders.mark(&cli.Command{
+				Name:      cleanupRecordingsCommand,
+				Aliases:   strings.Split(cleanupRecordingsAliases, ", "),
+				Usage:     cleanupRecordingsUsage,
+				ArgsUsage: cleanupRecordingsArgsUsage,
+				Flags: []cli.Flag{
+					commandFlags[trimSilenceFlag],
+					commandFlags[deleteOriginalFlag],
+				},
+				Action: func(c *cli.Context) error {
+					return process(a.state, c, 0, a.cleanupRecording)
+				},
+			}),
+			{
+				Name:      organizeCommand,
+				Aliases:   strings.Split(organizeAliases, ", "),
+				Usage:     organizeUsage,
+				ArgsUsage: organizeArgsUsage,
+				Flags: []cli.Flag{
+					commandFlags[templateFlag],
+				},
+				Action: func(c *cli.Context) error {
+					return process(a.state, c, 0, a.organize)
+				},
+			},
+			{
+				Name:      statsCommand,
+				Aliases:   strings.Split(statsAliases, ", "),
+				Usage:     statsUsage,
+				ArgsUsage: statsArgsUsage,
+				Flags: []cli.Flag{
+					commandFlags[formatFlag],
+				},
+				Action: func(c *cli.Context) error {
+					return processAll(a.state, c, 0, a.stats)
+				},
+			},
+			{
+				Name:      efficiencyCommand,
+				Aliases:   strings.Split(efficiencyAliases, ", "),
+				Usage:     efficiencyUsage,
+				ArgsUsage: efficiencyArgsUsage,
+				Flags: []cli.Flag{
+					commandFlags[formatFlag],
+				},
+				Action: func(c *cli.Context) error {
+					return processAll(a.state, c, 0, a.efficiency)
+				},
+			},
+			{
+				Name:      streamHashCommand,
+				Aliases:   strings.Split(streamHashAliases, ", "),
+				Usage:     streamHashUsage,
+				ArgsUsage: streamHashArgsUsage,
+				Flags: []cli.Flag{
+					commandFlags[hashFlag],
+					commandFlags[compareFlag],
+					commandFlags[framesFlag],
+					commandFlags[formatFlag],
+				},
+				Action: func(c *cli.Context) error {
+					return processAll(a.state, c, 0, a.streamHashes)
+				},
+			},
+			{
+				Name:      contentIDCommand,
+				Aliases:   strings.Split(contentIDAliases, ", "),
+				Usage:     contentIDUsage,
+				ArgsUsage: contentIDArgsUsage,
+				Flags:     []cli.Flag{},
+				Action: func(c *cli.Context) error {
+					return process(a.state, c, 0, a.contentID)
+				},
+			},
+			{
+				Name:      similarNamesCommand,
+				Aliases:   strings.Split(similarNamesAliases, ", "),
+				Usage:     similarNamesUsage,
+				ArgsUsage: similarNamesArgsUsage,
+				Flags: []cli.Flag{
+					commandFlags[similarityFlag],
+					commandFlags[proposeFlag],
+					commandFlags[formatFlag],
+				},
+				Action: func(c *cli.Context) error {
+					return processAll(a.state, c, 0, a.similarNames)
+				},
+			},
+			{
+				Name:      checkNewCommand,
+				Aliases:   strings.Split(checkNewAliases, ", "),
+				Usage:     checkNewUsage,
+				ArgsUsage: checkNewArgsUsage,
+				Flags: []cli.Flag{
+					commandFlags[updateIndexFlag],
+					commandFlags[formatFlag],
+				},
+				Action: func(c *cli.Context) error {
+					return processAll(a.state, c, 1, a.checkNew)
+				},
+			},
+			{
+				Name:      lintNamesCommand,
+				Aliases:   strings.Split(lintNamesAliases, ", "),
+				Usage:     lintNamesUsage,
+				ArgsUsage: lintNamesArgsUsage,
+				Flags: []cli.Flag{
+					commandFlags[fixFlag],
+					commandFlags[formatFlag],
+				},
+				Action: func(c *cli.Context) error {
+					return processAll(a.state, c, 0, a.lintNames)
+				},
+			},
+			{
+				Name:      againCommand,
+				Aliases:   strings.Split(againAliases, ", "),
+				Usage:     againUsage,
+				ArgsUsage: againArgsUsage,
+				Flags: []cli.Flag{
+					commandFlags[pickFlag],
+				},
+				Action: a.again,
+			},
+			{
+				Name:    resumeCommand,
+				Aliases: strings.Split(resumeAliases, ", "),
+				Usage:   resumeUsage,
+				Action:  a.resume,
+			},
+			{
+				Name:      examplesCommand,
+				Aliases:   strings.Split(examplesAliases, ", "),
+				Usage:     examplesUsage,
+				ArgsUsage: examplesArgsUsage,
+				Action:    a.examples,
+			},
+			{
+				Name:      manCommand,
+				Usage:     manUsage,
+				ArgsUsage: manArgsUsage,
+				Flags: []cli.Flag{
+					commandFlags[manDirFlag],
+					commandFlags[sectionFlag],
+				},
+				Action: a.man,
+			},
+			{
+				Name:    historyCommand,
+				Aliases: strings.Split(historyAliases, ", "),
+				Usage:   historyUsage,
+				Flags: []cli.Flag{
+					commandFlags[sinceFlag],
+					commandFlags[untilFlag],
+					commandFlags[commandFilterFlag],
+					commandFlags[fileFilterFlag],
+					commandFlags[exportFlag],
+				},
+				Action: a.history,
+			},
+			{
+				Name:    labelCommand,
+				Aliases: strings.Split(labelAliases, ", "),
+				Usage:   labelUsage,
+				Subcommands: []*cli.Command{
+					{
+						Name:      labelAddCommand,
+						Usage:     labelAddUsage,
+						ArgsUsage: labelAddArgsUsage,
+						Action: func(c *cli.Context) error {
+							return process(a.state, c, 1, a.labelAdd)
+						},
+					},
+					{
+						Name:      labelRemoveCommand,
+						Usage:     labelRemoveUsage,
+						ArgsUsage: labelRemoveArgsUsage,
+						Action: func(c *cli.Context) error {
+							return process(a.state, c, 1, a.labelRemove)
+						},
+					},
+					{
+						Name:      labelListCommand,
+						Usage:     labelListUsage,
+						ArgsUsage: labelListArgsUsage,
+						Action: func(c *cli.Context) error {
+							return process(a.state, c, 0, a.labelList)
+						},
+					},
+				},
+			},
+			{
+				Name:      rateCommand,
+				Aliases:   strings.Split(rateAliases, ", "),
+				Usage:     rateUsage,
+				ArgsUsage: rateArgsUsage,
+				Flags:     []cli.Flag{},
+				Action: func(c *cli.Context) error {
+					return process(a.state, c, 1, a.rate)
+				},
+			},
+			{
+				Name:      flattenCommand,
+				Aliases:   strings.Split(flattenAliases, ", "),
+				Usage:     flattenUsage,
+				ArgsUsage: flattenArgsUsage,
 				Flags:     []cli.Flag{},
+				Action:    a.flatten,
+			},
+			{
+				Name:      editCommand,
+				Aliases:   strings.Split(editAliases, ", "),
+				Usage:     editUsage,
+				ArgsUsage: editArgsUsage,
+				Flags: []cli.Flag{
+					commandFlags[permanentFlag],
+				},
+				Action: func(c *cli.Context) error {
+					return processAll(a.state, c, 0, a.edit)
+				},
+			},
+			{
+				Name:      renameFromFileCommand,
+				Aliases:   strings.Split(renameFromFileAliases, ", "),
+				Usage:     renameFromFileUsage,
+				ArgsUsage: renameFromFileArgsUsage,
+				Flags: []cli.Flag{
+					commandFlags[mappingFormatFlag],
+				},
+				Action: func(c *cli.Context) error {
+					err := configure(a.state, c)
+					if err != nil {
+						return err
+					}
+					defer exportLogHistory(a.log, c.String(logHistoryFlag))
+
+					if c.Args().Len() < 1 {
+						return missingArgumentError(c, c.Args().Len())
+					}
+
+					dryRun := c.Bool(dryRunFlag)
+					err = renameFromFile(a.state, c.Args().First(), c.String(mappingFormatFlag), c.Bool(forceFlag), dryRun)
+					if err != nil {
+						return err
+					}
+
+					if dryRun && a.resultFormat == "" {
+						previewChanges(a.changes, getMaxNameLength(c))
+					}
+
+					return nil
+				},
+			},
+			{
+				Name:      mirrorNamesCommand,
+				Aliases:   strings.Split(mirrorNamesAliases, ", "),
+				Usage:     mirrorNamesUsage,
+				ArgsUsage: mirrorNamesArgsUsage,
+				Flags: []cli.Flag{
+					commandFlags[matchFlag],
+					commandFlags[similarityFlag],
+					commandFlags[toleranceFlag],
+					commandFlags[targetExtFlag],
+				},
+				Action: func(c *cli.Context) error {
+					err := configure(a.state, c)
+					if err != nil {
+						return err
+					}
+					defer exportLogHistory(a.log, c.String(logHistoryFlag))
+
+					if c.Args().Len() < 2 {
+						return missingArgumentError(c, c.Args().Len())
+					}
+
+					dryRun := c.Bool(dryRunFlag)
+					err = mirrorNames(a.state, c.Args().Get(0), c.Args().Get(1), splitList(c.String(targetExtFlag)), c.String(matchFlag), c.Float64(similarityFlag), c.Float64(toleranceFlag), c.Bool(forceFlag), dryRun)
+					if err != nil {
+						return err
+					}
+
+					if dryRun && a.resultFormat == "" {
+						previewChanges(a.changes, getMaxNameLength(c))
+					}
+
+					return nil
+				},
+			},
+			{
+				Name:      matchSubsCommand,
+				Aliases:   strings.Split(matchSubsAliases, ", "),
+				Usage:     matchSubsUsage,
+				ArgsUsage: matchSubsArgsUsage,
+				Flags: []cli.Flag{
+					commandFlags[similarityFlag],
+					commandFlags[noDurationFlag],
+				},
+				Action: a.matchSubs,
+			},
+			{
+				Name:  undoCommand,
+				Usage: undoUsage,
+				Flags: []cli.Flag{},
 				Action: func(c *cli.Context) error {
-					return process(c, 0, a.datePrefix)
+					err := configure(a.state, c)
+					if err != nil {
+						return err
+					}
+					defer exportLogHistory(a.log, c.String(logHistoryFlag))
+
+					return undo(a.state, c.String(journalFlag), c.Bool(dryRunFlag))
 				},
 			},
+			{
+				Name:            stageCommand,
+				Aliases:         strings.Split(stageAliases, ", "),
+				Usage:           stageUsage,
+				ArgsUsage:       stageArgsUsage,
+				SkipFlagParsing: true,
+				Action:          a.stage,
+			},
+			{
+				Name:    statusCommand,
+				Aliases: strings.Split(statusAliases, ", "),
+				Usage:   statusUsage,
+				Flags: []cli.Flag{
+					commandFlags[maxNameLengthFlag],
+				},
+				Action: a.status,
+			},
+			{
+				Name:    commitCommand,
+				Aliases: strings.Split(commitAliases, ", "),
+				Usage:   commitUsage,
+				Flags: []cli.Flag{
+					commandFlags[discardFlag],
+				},
+				Action: a.commit,
+			},
 		},
 	}
 
-	err := app.Run(os.Args)
+	addExamples(app.Commands)
+
+	return app
+}
+
+func main() {
+	a := App{state: newState()}
+	err := run(a.state, a.cliApp(), os.Args)
+	cleanupErr := a.workspace.Cleanup()
+	if cleanupErr != nil {
+		log.Print(cleanupErr)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -1,27 +1,61 @@
 package main
 
 import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/peteraba/ffr/rename"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/syntax"
 )
 
-func init() {
-	l = logger{
-		silent: true,
-	}
+// newTestState returns the state of an unconfigured invocation which logs nothing
+func newTestState() *state {
+	st := newState()
+	st.log = newLogger(true)
+	st.progress = newLogger(true)
+
+	return st
 }
 
 func createExampleVideo(t *testing.T, filePath string) {
-	_, err := exec(fmt.Sprintf(`ffmpeg -f lavfi -i testsrc=duration=10:size=320x240:rate=30 "%s"`, filePath))
+	if _, err := osexec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg is not installed")
+	}
+
+	_, err := exec(newTestState(), []string{"ffmpeg", "-f", "lavfi", "-i", "testsrc=duration=10:size=320x240:rate=30", filePath})
 	require.NoError(t, err)
 }
 
 func cleanUp(t *testing.T, want, need []string) {
+	if t.Skipped() {
+		for _, fileName := range need {
+			_ = os.Remove(fileName)
+		}
+
+		return
+	}
+
 	for _, fileName := range want {
 		assert.FileExists(t, fileName)
 
@@ -36,6 +70,7 @@ func cleanUp(t *testing.T, want, need []string) {
 func Test_prefixDate(t *testing.T) {
 	type args struct {
 		filePath       string
+		options        dateOptions
 		forceOverwrite bool
 		dryRun         bool
 	}
@@ -108,6 +143,52 @@ func Test_prefixDate(t *testing.T) {
 			},
 			want: []string{"foo-231230.txt"},
 		},
+		{
+			name: "pick first",
+			need: []string{"bar-20231229-and-20231230.txt"},
+			args: args{
+				filePath: "bar-20231229-and-20231230.txt",
+				options:  dateOptions{pick: datePickFirst},
+			},
+			want: []string{"2023.12.29-bar-20231229-and-20231230.txt"},
+		},
+		{
+			name: "pick last",
+			need: []string{"baz-20231229-and-20231230.txt"},
+			args: args{
+				filePath: "baz-20231229-and-20231230.txt",
+				options:  dateOptions{pick: datePickLast},
+			},
+			want: []string{"2023.12.30-baz-20231229-and-20231230.txt"},
+		},
+		{
+			name: "pick interactively",
+			need: []string{"qux-20231229-and-20231230.txt"},
+			args: args{
+				filePath: "qux-20231229-and-20231230.txt",
+				options:  dateOptions{pick: datePickInteractive, in: strings.NewReader("2\n")},
+			},
+			want: []string{"2023.12.30-qux-20231229-and-20231230.txt"},
+		},
+		{
+			name: "custom format",
+			need: []string{"foo-29_12_2023.txt"},
+			args: args{
+				filePath: "foo-29_12_2023.txt",
+				options:  dateOptions{format: "02_01_2006"},
+			},
+			want: []string{"2023.12.29-foo-29_12_2023.txt"},
+		},
+		{
+			name: "custom regex requires a format",
+			need: []string{"foo-x20231229.txt"},
+			args: args{
+				filePath: "foo-x20231229.txt",
+				options:  dateOptions{regex: `x(\d{8})`},
+			},
+			wantErr: "date format is required",
+			want:    []string{"foo-x20231229.txt"},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -125,7 +206,7 @@ func Test_prefixDate(t *testing.T) {
 			require.NoError(t, err)
 
 			// execute
-			result := prefixDate(fi, tt.args.forceOverwrite, tt.args.dryRun)
+			result := prefixDate(newTestState(), fi, tt.args.options, tt.args.forceOverwrite, tt.args.dryRun)
 
 			// assert
 			if tt.wantErr != "" {
@@ -141,6 +222,76 @@ func Test_prefixDate(t *testing.T) {
 	}
 }
 
+func Test_findDates(t *testing.T) {
+	tests := []struct {
+		name     string
+		fileName string
+		options  dateOptions
+		want     []string
+	}{
+		{
+			name:     "whatsapp",
+			fileName: "VID-20240102-WA0001",
+			want:     []string{"20240102"},
+		},
+		{
+			name:     "dashed and dotted",
+			fileName: "2024-01-02 and 03.01.2024",
+			want:     []string{"2024-01-02", "03.01.2024"},
+		},
+		{
+			name:     "unix seconds and milliseconds",
+			fileName: "1704153600 and 1704153600000",
+			want:     []string{"1704153600", "1704153600000"},
+		},
+		{
+			name:     "invalid dates are skipped",
+			fileName: "foo-20241399",
+			want:     nil,
+		},
+		{
+			name:     "custom regex",
+			fileName: "x20240102 and 20240103",
+			options: dateOptions{
+				regex:  `x(\d{8})`,
+				format: dateFormat1,
+			},
+			want: []string{"20240102"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			patterns, _, err := tt.options.patterns()
+			require.NoError(t, err)
+
+			// execute
+			candidates := findDates(newLogger(true), tt.fileName, patterns)
+
+			// assert
+			var got []string
+			for _, c := range candidates {
+				got = append(got, c.text)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_pickDate_sameDay(t *testing.T) {
+	// setup
+	patterns, _, err := dateOptions{}.patterns()
+	require.NoError(t, err)
+	candidates := findDates(newLogger(true), "VID-20240102-WA0001 2024-01-02", patterns)
+
+	// execute
+	got, err := pickDate(candidates, "", nil)
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, "2024.01.02", got.date.Format(dateFormat3))
+}
+
 func Test_addNumber(t *testing.T) {
 	type args struct {
 		filePath          string
@@ -295,7 +446,14 @@ func Test_addNumber(t *testing.T) {
 			require.NoError(t, err)
 
 			// execute
-			result := addNumber(fi, tt.args.regularExpression, tt.args.numberToAdd, tt.args.regexpGroup, tt.args.skipFinds, tt.args.maxCount, tt.args.forceOverwrite, tt.args.dryRun)
+			spec := rename.EditNumber{
+				Regexp:      tt.args.regularExpression,
+				RegexpGroup: tt.args.regexpGroup,
+				SkipFinds:   tt.args.skipFinds,
+				MaxCount:    tt.args.maxCount,
+				Operand:     strconv.FormatInt(tt.args.numberToAdd, 10),
+			}
+			result := addNumber(newTestState(), fi, spec, tt.args.forceOverwrite, tt.args.dryRun)
 
 			// assert
 			assert.NoError(t, result)
@@ -306,95 +464,6 @@ func Test_addNumber(t *testing.T) {
 	}
 }
 
-func Test_concat(t *testing.T) {
-	type args struct {
-		parts     []string
-		skip      int
-		newPart   string
-		ext       string
-		separator string
-	}
-
-	panicTests := []struct {
-		name string
-		args args
-	}{
-		{
-			name: "empty-parts",
-			args: args{
-				parts:     []string{},
-				skip:      1,
-				newPart:   "quix",
-				ext:       ".txt",
-				separator: "-",
-			},
-		},
-		{
-			name: "non-empty-parts",
-			args: args{
-				parts:     []string{"foo", "bar", "baz"},
-				skip:      4,
-				newPart:   "quix",
-				ext:       ".txt",
-				separator: "-",
-			},
-		},
-	}
-
-	for _, tt := range panicTests {
-		t.Run(tt.name, func(t *testing.T) {
-			assert.Panics(t, func() { concat(tt.args.parts, tt.args.skip, tt.args.newPart, tt.args.ext, tt.args.separator) })
-		})
-	}
-
-	successTests := []struct {
-		name string
-		args args
-		want string
-	}{
-		{
-			name: "no skip",
-			args: args{
-				parts:     []string{"foo", "bar", "baz"},
-				skip:      0,
-				newPart:   "quix",
-				ext:       ".txt",
-				separator: "-",
-			},
-			want: "quix-foo-bar-baz.txt",
-		},
-		{
-			name: "skip to middle",
-			args: args{
-				parts:     []string{"foo", "bar", "baz"},
-				skip:      2,
-				newPart:   "quix",
-				ext:       ".txt",
-				separator: "-",
-			},
-			want: "foo-bar-quix-baz.txt",
-		},
-		{
-			name: "skip to last",
-			args: args{
-				parts:     []string{"foo", "bar", "baz"},
-				skip:      3,
-				newPart:   "quix",
-				ext:       ".txt",
-				separator: "-",
-			},
-			want: "foo-bar-baz-quix.txt",
-		},
-	}
-	for _, tt := range successTests {
-		t.Run(tt.name, func(t *testing.T) {
-			if got := concat(tt.args.parts, tt.args.skip, tt.args.newPart, tt.args.ext, tt.args.separator); got != tt.want {
-				t.Errorf("concat() = %v, want %v", got, tt.want)
-			}
-		})
-	}
-}
-
 func Test_deleteParts(t *testing.T) {
 	type args struct {
 		filePath       string
@@ -486,7 +555,7 @@ func Test_deleteParts(t *testing.T) {
 			require.NoError(t, err)
 
 			// execute
-			result := deleteParts(fi, tt.args.partsToDelete, tt.args.fromBack, tt.args.forceOverwrite, tt.args.dryRun)
+			result := deleteParts(newTestState(), fi, tt.args.partsToDelete, tt.args.fromBack, tt.args.forceOverwrite, tt.args.dryRun)
 
 			// assert
 			assert.NoError(t, result)
@@ -497,6 +566,46 @@ func Test_deleteParts(t *testing.T) {
 	}
 }
 
+func Test_parsePartNumbers(t *testing.T) {
+	tests := []struct {
+		name    string
+		list    string
+		want    []int
+		wantErr string
+	}{
+		{
+			name: "list",
+			list: "1, 3,4",
+			want: []int{1, 3, 4},
+		},
+		{
+			name:    "not a number",
+			list:    "1,x",
+			wantErr: `part: "x"`,
+		},
+		{
+			name:    "parts are counted from 1",
+			list:    "0",
+			wantErr: "counted from 1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got, err := parsePartNumbers(tt.list)
+
+			// assert
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
 func Test_deleteRegexp(t *testing.T) {
 	type args struct {
 		filePath          string
@@ -628,7 +737,7 @@ func Test_deleteRegexp(t *testing.T) {
 			require.NoError(t, err)
 
 			// execute
-			result := deleteRegexp(fi, tt.args.regularExpression, tt.args.regexpGroup, tt.args.skipFinds, tt.args.maxCount, tt.args.forceOverwrite, tt.args.dryRun)
+			result := deleteRegexp(newTestState(), fi, tt.args.regularExpression, tt.args.regexpGroup, tt.args.skipFinds, tt.args.maxCount, tt.args.forceOverwrite, tt.args.dryRun)
 
 			// assert
 			assert.NoError(t, result)
@@ -641,7 +750,7 @@ func Test_deleteRegexp(t *testing.T) {
 
 func Test_exec(t *testing.T) {
 	type args struct {
-		command string
+		command []string
 	}
 	tests := []struct {
 		name string
@@ -651,14 +760,21 @@ func Test_exec(t *testing.T) {
 		{
 			name: "default",
 			args: args{
-				command: "echo 'hello'",
+				command: []string{"echo", "hello"},
 			},
 			want: "hello\n",
 		},
+		{
+			name: "arguments are not interpreted by a shell",
+			args: args{
+				command: []string{"echo", "it's $HOME"},
+			},
+			want: "it's $HOME\n",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := exec(tt.args.command)
+			got, err := exec(newTestState(), tt.args.command)
 			require.NoError(t, err)
 			assert.Equalf(t, tt.want, got, "exec(%v)", tt.args.command)
 		})
@@ -749,7 +865,7 @@ func Test_insertBefore(t *testing.T) {
 			// execute
 			fi, err := os.Stat(tt.args.filePath)
 			require.NoError(t, err)
-			result := insertBefore(fi, tt.args.regularExpression, tt.args.insertText, tt.args.skipDuplicate, tt.args.skipDashPrefix, tt.args.forceOverwrite, tt.args.dryRun)
+			result := insertBefore(newTestState(), fi, tt.args.regularExpression, tt.args.insertText, tt.args.skipDuplicate, tt.args.skipDashPrefix, tt.args.forceOverwrite, tt.args.dryRun)
 
 			// assert
 			assert.NoError(t, result)
@@ -761,6 +877,7 @@ func Test_insertBefore(t *testing.T) {
 }
 
 func Test_insertDimensionsBefore(t *testing.T) {
+	st := newTestState()
 	t.Run("does not overwrite by default", func(t *testing.T) {
 		t.Parallel()
 
@@ -787,7 +904,7 @@ func Test_insertDimensionsBefore(t *testing.T) {
 		// execute
 		fi, err := os.Stat(vidPath)
 		require.NoError(t, err)
-		result := insertDimensionsBefore(fi, "", false, true, forceOverwrite, dryRun)
+		result := insertDimensionsBefore(st, fi, "", false, true, forceOverwrite, dryRun)
 
 		// assert
 		assert.NoError(t, result)
@@ -922,7 +1039,7 @@ func Test_insertDimensionsBefore(t *testing.T) {
 			// execute
 			fi, err := os.Stat(tt.args.filePath)
 			require.NoError(t, err)
-			result := insertDimensionsBefore(fi, tt.args.regularExpression, tt.args.skipDuplicate, tt.args.skipDashPrefix, tt.args.forceOverwrite, tt.args.dryRun)
+			result := insertDimensionsBefore(st, fi, tt.args.regularExpression, tt.args.skipDuplicate, tt.args.skipDashPrefix, tt.args.forceOverwrite, tt.args.dryRun)
 
 			// assert
 			assert.NoError(t, result)
@@ -936,6 +1053,7 @@ func Test_insertDimensionsBefore(t *testing.T) {
 func Test_getFileInfoList(t *testing.T) {
 	type args struct {
 		filePaths     []string
+		sortBy        string
 		backwardsFlag bool
 	}
 	tests := []struct {
@@ -959,6 +1077,23 @@ func Test_getFileInfoList(t *testing.T) {
 			},
 			want: []string{"bar.txt", "foo.txt"},
 		},
+		{
+			name: "natural sort",
+			args: args{
+				filePaths: []string{"file10.txt", "file2.txt", "File1.txt"},
+				sortBy:    sortName,
+			},
+			want: []string{"File1.txt", "file2.txt", "file10.txt"},
+		},
+		{
+			name: "natural sort backward",
+			args: args{
+				filePaths:     []string{"file10.txt", "file2.txt", "File1.txt"},
+				sortBy:        sortName,
+				backwardsFlag: true,
+			},
+			want: []string{"file10.txt", "file2.txt", "File1.txt"},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -973,9 +1108,10 @@ func Test_getFileInfoList(t *testing.T) {
 			}
 
 			// execute
-			result := getFileInfoList(tt.args.filePaths, tt.args.backwardsFlag)
+			result, err := getFileInfoList(newTestState(), tt.args.filePaths, tt.args.sortBy, tt.args.backwardsFlag)
 
 			// assert
+			require.NoError(t, err)
 			for i, fi := range result {
 				assert.Equal(t, tt.want[i], fi.Name())
 			}
@@ -1021,15 +1157,17 @@ func Test_keyFrames(t *testing.T) {
 			fi, err := os.Stat(tt.args.filePath)
 			require.NoError(t, err)
 
+			l := newLogger(true)
+
 			// execute
-			result := keyFrames(fi)
+			result := keyFrames(newTestState(), fi)
 
 			// assert
 			assert.NoError(t, result)
 			for _, fileName := range tt.want {
 				assert.FileExists(t, fileName)
 			}
-			assert.Contains(t, l.history, tt.wantOutput)
+			assert.Contains(t, l.History(), tt.wantOutput)
 		})
 	}
 }
@@ -1136,7 +1274,118 @@ func Test_mergeParts(t *testing.T) {
 			// execute
 			fi, err := os.Stat(tt.args.filePath)
 			require.NoError(t, err)
-			result := mergeParts(fi, tt.args.regularExpression, tt.args.deleteText, tt.args.forceOverwrite, tt.args.dryRun)
+			spec := rename.MergeParts{Regexp: tt.args.regularExpression, DeleteText: tt.args.deleteText}
+			result := mergeParts(newTestState(), fi, spec, tt.args.forceOverwrite, tt.args.dryRun)
+
+			// assert
+			assert.NoError(t, result)
+			for _, fileName := range tt.want {
+				assert.FileExists(t, fileName)
+			}
+		})
+	}
+}
+
+func Test_normalizeNumbers(t *testing.T) {
+	tests := []struct {
+		name   string
+		need   []string
+		spec   rename.NormalizeNumbers
+		dryRun bool
+		want   []string
+	}{
+		{
+			name: "default",
+			need: []string{"foo-part-II-take-three.txt"},
+			spec: rename.NormalizeNumbers{MaxRoman: defaultMaxRoman},
+			want: []string{"foo-part-2-take-3.txt"},
+		},
+		{
+			name:   "dry run",
+			need:   []string{"foo-part-IV.txt"},
+			spec:   rename.NormalizeNumbers{MaxRoman: defaultMaxRoman},
+			dryRun: true,
+			want:   []string{"foo-part-IV.txt"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer cleanUp(t, tt.want, tt.need)
+
+			// setup
+			for _, filePath := range tt.need {
+				err := os.WriteFile(filePath, nil, 0777)
+				require.NoError(t, err)
+			}
+
+			fi, err := os.Stat(tt.need[0])
+			require.NoError(t, err)
+
+			// execute
+			result := normalizeNumbers(newTestState(), fi, tt.spec, false, tt.dryRun)
+
+			// assert
+			assert.NoError(t, result)
+			for _, fileName := range tt.want {
+				assert.FileExists(t, fileName)
+			}
+		})
+	}
+}
+
+func Test_normalizeUnicode(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "JosÃ©.txt")
+	require.NoError(t, os.WriteFile(filePath, nil, 0644))
+	fi, err := os.Stat(filePath)
+	require.NoError(t, err)
+
+	// execute
+	err = normalizeUnicode(newTestState(), withPath(fi, filePath), rename.NormalizeUnicode{ASCII: true}, false, false)
+
+	// assert
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(dir, "Jose.txt"))
+}
+
+func Test_padNumbers(t *testing.T) {
+	tests := []struct {
+		name   string
+		need   []string
+		spec   rename.PadNumbers
+		dryRun bool
+		want   []string
+	}{
+		{
+			name: "default",
+			need: []string{"foo-s1-e7.txt"},
+			spec: rename.PadNumbers{Width: 2},
+			want: []string{"foo-s01-e07.txt"},
+		},
+		{
+			name:   "dry run",
+			need:   []string{"foo-s2-e7.txt"},
+			spec:   rename.PadNumbers{Width: 2},
+			dryRun: true,
+			want:   []string{"foo-s2-e7.txt"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer cleanUp(t, tt.want, tt.need)
+
+			// setup
+			for _, filePath := range tt.need {
+				err := os.WriteFile(filePath, nil, 0777)
+				require.NoError(t, err)
+			}
+
+			fi, err := os.Stat(tt.need[0])
+			require.NoError(t, err)
+
+			// execute
+			result := padNumbers(newTestState(), fi, tt.spec, false, tt.dryRun)
 
 			// assert
 			assert.NoError(t, result)
@@ -1238,7 +1487,7 @@ func Test_prefix(t *testing.T) {
 			// execute
 			fi, err := os.Stat(tt.args.filePath)
 			require.NoError(t, err)
-			result := prefix(fi, tt.args.newPart, tt.args.skip, tt.args.forceOverwrite, tt.args.dryRun)
+			result := prefix(newTestState(), fi, tt.args.newPart, tt.args.skip, tt.args.forceOverwrite, tt.args.dryRun)
 
 			// assert
 			assert.NoError(t, result)
@@ -1362,7 +1611,7 @@ func Test_reEncode(t *testing.T) {
 			require.NoError(t, err)
 
 			// execute
-			_, result := reEncode(fi, tt.args.codec, tt.args.crf, tt.args.preset, tt.args.hwaccel, "", tt.args.dryRun)
+			_, result := reEncode(newTestState(), fi, reEncodeOptions{codec: tt.args.codec, crf: tt.args.crf, preset: tt.args.preset, hwaccel: tt.args.hwaccel}, tt.args.dryRun)
 
 			// assert
 			assert.NoError(t, result)
@@ -1457,7 +1706,7 @@ func Test_replace(t *testing.T) {
 			// execute
 			fi, err := os.Stat(tt.args.filePath)
 			require.NoError(t, err)
-			result := replace(fi, tt.args.search, tt.args.replaceWith, tt.args.skip, tt.args.forceOverwrite, tt.args.dryRun)
+			result := replace(newTestState(), fi, tt.args.search, tt.args.replaceWith, tt.args.skip, tt.args.forceOverwrite, tt.args.dryRun)
 
 			// assert
 			assert.NoError(t, result)
@@ -1528,7 +1777,7 @@ func Test_safeRename(t *testing.T) {
 			}
 
 			// execute
-			if err := safeRename(tt.args.oldPath, tt.args.newPath, tt.args.forceOverwrite); (err != nil) != tt.wantErr {
+			if err := safeRename(newTestState(), tt.args.oldPath, tt.args.newPath, tt.args.forceOverwrite); (err != nil) != tt.wantErr {
 				t.Errorf("safeRename() error = %v, wantErr %v", err, tt.wantErr)
 			}
 
@@ -1619,7 +1868,7 @@ func Test_suffix(t *testing.T) {
 			// execute
 			fi, err := os.Stat(tt.args.filePath)
 			require.NoError(t, err)
-			result := suffix(fi, tt.args.newPart, tt.args.skip, tt.args.forceOverwrite, tt.args.dryRun)
+			result := suffix(newTestState(), fi, tt.args.newPart, tt.args.skip, tt.args.forceOverwrite, tt.args.dryRun)
 
 			// assert
 			assert.NoError(t, result)
@@ -1660,7 +1909,7 @@ func Test_crop(t *testing.T) {
 				x:        "left",
 				y:        "top",
 			},
-			wantOutput: "ffmpeg -i \"foo.mp4\" -filter:v \"crop=120:80:0:0\" \"foo-120x80.mp4\"",
+			wantOutput: "ffmpeg -i foo.mp4 -filter:v crop=120:80:0:0 foo-120x80.mp4",
 			want:       []string{"foo-120x80.mp4"},
 		},
 		{
@@ -1675,7 +1924,7 @@ func Test_crop(t *testing.T) {
 				x:        "center",
 				y:        "center",
 			},
-			wantOutput: "ffmpeg -i \"foo.mp4\" -filter:v \"crop=120:80:100:80\" \"foo-120x80.mp4\"",
+			wantOutput: "ffmpeg -i foo.mp4 -filter:v crop=120:80:100:80 foo-120x80.mp4",
 			want:       []string{"foo-120x80.mp4"},
 		},
 	}
@@ -1693,16 +1942,7136 @@ func Test_crop(t *testing.T) {
 			fi, err := os.Stat(tt.args.filePath)
 			require.NoError(t, err)
 
+			l := newLogger(true)
+
 			// execute
 			a := tt.args
-			result := crop(fi, a.width, a.height, a.x, a.y, a.dimensionPreset, a.forceOverwrite, a.dryRun)
+			result := crop(newTestState(), fi, a.width, a.height, a.x, a.y, a.dimensionPreset, a.forceOverwrite, a.dryRun)
 
 			// assert
 			assert.NoError(t, result)
 			for _, fileName := range tt.want {
 				assert.FileExists(t, fileName)
 			}
-			assert.Contains(t, l.history, tt.wantOutput)
+			assert.Contains(t, l.History(), tt.wantOutput)
+		})
+	}
+}
+
+func Test_getRecordingName(t *testing.T) {
+	tests := []struct {
+		name     string
+		basePath string
+		want     string
+		wantErr  string
+	}{
+		{
+			name:     "macos screen recording",
+			basePath: "Screen Recording 2024-01-02 at 10.11.12",
+			want:     "2024.01.02-101112-screen-recording",
+		},
+		{
+			name:     "macos screenshot with pm and duplicate counter",
+			basePath: "Screen Shot 2020-01-02 at 1.02.03 PM (2)",
+			want:     "2020.01.02-130203-screenshot-2",
+		},
+		{
+			name:     "gnome screencast",
+			basePath: "Screencast from 2024-01-02 10-11-12",
+			want:     "2024.01.02-101112-screencast",
+		},
+		{
+			name:     "midnight",
+			basePath: "Screenshot 2024-01-02 at 12.00.01 AM",
+			want:     "2024.01.02-000001-screenshot",
+		},
+		{
+			name:     "narrow no-break space before pm",
+			basePath: "Screenshot 2024-01-02 at 1.02.03\u202fPM",
+			want:     "2024.01.02-130203-screenshot",
+		},
+		{
+			name:     "not a recording",
+			basePath: "foo-bar",
+			wantErr:  "not a screenshot or screen recording name",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got, err := getRecordingName(tt.basePath)
+
+			// assert
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
 		})
 	}
 }
+
+func Test_parseSilenceBounds(t *testing.T) {
+	tests := []struct {
+		name      string
+		output    string
+		length    float64
+		wantStart float64
+		wantEnd   float64
+	}{
+		{
+			name:      "no silence",
+			output:    "",
+			length:    10,
+			wantStart: 0,
+			wantEnd:   10,
+		},
+		{
+			name: "leading and trailing silence",
+			output: `[silencedetect @ 0x1] silence_start: 0
+[silencedetect @ 0x1] silence_end: 1.5 | silence_duration: 1.5
+[silencedetect @ 0x1] silence_start: 4.2
+[silencedetect @ 0x1] silence_end: 5 | silence_duration: 0.8
+[silencedetect @ 0x1] silence_start: 8.25`,
+			length:    10,
+			wantStart: 1.5,
+			wantEnd:   8.25,
+		},
+		{
+			name: "silence in the middle only",
+			output: `[silencedetect @ 0x1] silence_start: 4.2
+[silencedetect @ 0x1] silence_end: 5 | silence_duration: 0.8`,
+			length:    10,
+			wantStart: 0,
+			wantEnd:   10,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			start, end := parseSilenceBounds(tt.output, tt.length)
+
+			// assert
+			assert.Equal(t, tt.wantStart, start)
+			assert.Equal(t, tt.wantEnd, end)
+		})
+	}
+}
+
+func Test_cleanupRecording(t *testing.T) {
+	type args struct {
+		filePath       string
+		forceOverwrite bool
+		dryRun         bool
+	}
+	tests := []struct {
+		name    string
+		need    []string
+		args    args
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "screenshot is renamed",
+			need: []string{"Screenshot 2024-01-02 at 10.11.12.png"},
+			args: args{
+				filePath: "Screenshot 2024-01-02 at 10.11.12.png",
+			},
+			want: []string{"2024.01.02-101112-screenshot.png"},
+		},
+		{
+			name: "dry-run",
+			need: []string{"Screenshot 2024-01-02 at 10.11.12.png"},
+			args: args{
+				filePath: "Screenshot 2024-01-02 at 10.11.12.png",
+				dryRun:   true,
+			},
+			want: []string{"Screenshot 2024-01-02 at 10.11.12.png"},
+		},
+		{
+			name: "unknown names are skipped",
+			need: []string{"foo.png"},
+			args: args{
+				filePath: "foo.png",
+			},
+			wantErr: "not a screenshot or screen recording name",
+			want:    []string{"foo.png"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer cleanUp(t, tt.want, tt.need)
+
+			var err error
+
+			// setup
+			for _, filePath := range tt.need {
+				err = os.WriteFile(filePath, nil, 0777)
+				require.NoError(t, err)
+			}
+
+			fi, err := os.Stat(tt.args.filePath)
+			require.NoError(t, err)
+
+			// execute
+			result := cleanupRecording(newTestState(), fi, false, false, tt.args.forceOverwrite, tt.args.dryRun)
+
+			// assert
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, result, tt.wantErr)
+			} else {
+				assert.NoError(t, result)
+			}
+
+			for _, fileName := range tt.want {
+				assert.FileExists(t, fileName)
+			}
+		})
+	}
+}
+
+func Test_cleanupRecording_deleteOriginal(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "Screen Recording 2024-01-02 at 10.11.12.mov")
+	require.NoError(t, os.WriteFile(filePath, []byte("foo"), 0644))
+	fi, err := os.Stat(filePath)
+	require.NoError(t, err)
+
+	useFakeRunner(t, func(args []string) (string, error) {
+		return "", nil
+	})
+
+	// execute
+	err = cleanupRecording(newTestState(), withPath(fi, filePath), false, true, false, false)
+
+	// assert
+	require.NoError(t, err)
+	assert.NoFileExists(t, filePath)
+	assert.FileExists(t, filepath.Join(dir, trashDirName, filepath.Base(filePath)))
+}
+
+func Test_renameSidecars(t *testing.T) {
+	type args struct {
+		oldPath    string
+		newPath    string
+		extensions []string
+	}
+	tests := []struct {
+		name    string
+		need    []string
+		args    args
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "sidecars are renamed",
+			need: []string{"foo.mp4", "foo.srt", "foo.en.srt", "foo.mp4.xmp", "foo.jpg", "foobar.srt"},
+			args: args{
+				oldPath:    "foo.mp4",
+				newPath:    "bar.mp4",
+				extensions: []string{"srt", ".xmp", "jpg"},
+			},
+			want: []string{"bar.mp4", "bar.srt", "bar.en.srt", "bar.mp4.xmp", "bar.jpg", "foobar.srt"},
+		},
+		{
+			name: "sidecars are ignored by default",
+			need: []string{"foo.mp4", "foo.srt"},
+			args: args{
+				oldPath: "foo.mp4",
+				newPath: "bar.mp4",
+			},
+			want: []string{"bar.mp4", "foo.srt"},
+		},
+		{
+			name: "extensions are compared case-insensitively",
+			need: []string{"IMG_0001.MOV", "IMG_0001.HEIC"},
+			args: args{
+				oldPath:    "IMG_0001.MOV",
+				newPath:    "bar.MOV",
+				extensions: []string{"heic"},
+			},
+			want: []string{"bar.MOV", "bar.HEIC"},
+		},
+		{
+			name: "taken sidecar paths are reported",
+			need: []string{"foo.mp4", "foo.srt", "foo.xmp", "bar.srt"},
+			args: args{
+				oldPath:    "foo.mp4",
+				newPath:    "bar.mp4",
+				extensions: []string{"srt", "xmp"},
+			},
+			want:    []string{"bar.mp4", "foo.srt", "bar.srt", "bar.xmp"},
+			wantErr: "file already exists",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer cleanUp(t, tt.want, tt.need)
+
+			var err error
+
+			// setup
+			st := newTestState()
+			for _, fileName := range tt.need {
+				err = os.WriteFile(fileName, nil, 0777)
+				require.NoError(t, err)
+			}
+			st.sidecarExtensions = tt.args.extensions
+
+			// execute
+			err = safeRename(st, tt.args.oldPath, tt.args.newPath, false)
+
+			// assert
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			for _, fileName := range tt.want {
+				assert.FileExists(t, fileName)
+			}
+		})
+	}
+}
+
+func Test_organize(t *testing.T) {
+	type args struct {
+		filePath     string
+		pathTemplate string
+		dryRun       bool
+	}
+	tests := []struct {
+		name    string
+		need    []string
+		args    args
+		want    []string
+		wantDir string
+	}{
+		{
+			name: "date from file name",
+			need: []string{"foo-20231229.txt"},
+			args: args{
+				filePath:     "foo-20231229.txt",
+				pathTemplate: defaultOrganizeTemplate,
+			},
+			want:    []string{"2023/2023-12/foo-20231229.txt"},
+			wantDir: "2023",
+		},
+		{
+			name: "custom template",
+			need: []string{"2023.12.29-foo.txt"},
+			args: args{
+				filePath:     "2023.12.29-foo.txt",
+				pathTemplate: "{{.Ext}}/{{.Date}}",
+			},
+			want:    []string{"txt/2023.12.29/2023.12.29-foo.txt"},
+			wantDir: "txt",
+		},
+		{
+			name: "dry-run",
+			need: []string{"foo-20231229.txt"},
+			args: args{
+				filePath:     "foo-20231229.txt",
+				pathTemplate: defaultOrganizeTemplate,
+				dryRun:       true,
+			},
+			want:    []string{"foo-20231229.txt"},
+			wantDir: "2023",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer os.RemoveAll(tt.wantDir)
+			defer cleanUp(t, tt.want, tt.need)
+
+			var err error
+
+			// setup
+			for _, filePath := range tt.need {
+				err = os.WriteFile(filePath, nil, 0777)
+				require.NoError(t, err)
+			}
+
+			fi, err := os.Stat(tt.args.filePath)
+			require.NoError(t, err)
+
+			// execute
+			result := organize(newTestState(), fi, tt.args.pathTemplate, false, tt.args.dryRun)
+
+			// assert
+			assert.NoError(t, result)
+			for _, fileName := range tt.want {
+				assert.FileExists(t, fileName)
+			}
+		})
+	}
+}
+
+func Test_undo(t *testing.T) {
+	journalPath := t.TempDir() + "/journal.jsonl"
+
+	// setup
+	st := newTestState()
+	err := os.WriteFile("foo-20231229.txt", nil, 0777)
+	require.NoError(t, err)
+	defer cleanUp(t, []string{"foo-20231229.txt"}, nil)
+
+	fi, err := os.Stat("foo-20231229.txt")
+	require.NoError(t, err)
+
+	st.journal = newJournal(newLogger(true), journalPath, organizeCommand)
+	err = organize(st, fi, defaultOrganizeTemplate, false, false)
+	require.NoError(t, err)
+	require.FileExists(t, "2023/2023-12/foo-20231229.txt")
+
+	// execute
+	st.journal = newJournal(newLogger(true), journalPath, undoCommand)
+	err = undo(st, journalPath, false)
+
+	// assert
+	assert.NoError(t, err)
+	assert.FileExists(t, "foo-20231229.txt")
+	assert.NoDirExists(t, "2023")
+
+	err = undo(st, journalPath, false)
+	assert.ErrorContains(t, err, "nothing to undo")
+}
+
+func Test_rollbackRun(t *testing.T) {
+	journalPath := t.TempDir() + "/journal.jsonl"
+
+	// setup
+	st := newTestState()
+	dir := t.TempDir()
+	for _, name := range []string{"a.mp4", "b.mp4"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0644))
+	}
+
+	st.journal = newJournal(newLogger(true), journalPath, organizeCommand)
+	require.NoError(t, createDirs(st, filepath.Join(dir, "sub")))
+	require.NoError(t, safeRename(st, filepath.Join(dir, "a.mp4"), filepath.Join(dir, "sub", "a.mp4"), false))
+	require.NoError(t, safeRename(st, filepath.Join(dir, "b.mp4"), filepath.Join(dir, "x-b.mp4"), false))
+
+	// execute
+	err := rollbackRun(st)
+
+	// assert
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(dir, "a.mp4"))
+	assert.FileExists(t, filepath.Join(dir, "b.mp4"))
+	assert.NoDirExists(t, filepath.Join(dir, "sub"))
+
+	err = undo(st, journalPath, true)
+	assert.ErrorContains(t, err, "nothing to undo")
+}
+
+func Test_rollbackRun_noJournal(t *testing.T) {
+	// setup
+	st := newTestState()
+	st.journal = nil
+
+	// execute
+	err := rollbackRun(st)
+
+	// assert
+	assert.ErrorContains(t, err, "journal is disabled")
+}
+
+func Test_flatten(t *testing.T) {
+	tests := []struct {
+		name       string
+		need       []string
+		dryRun     bool
+		want       []string
+		wantNoDirs []string
+	}{
+		{
+			name:       "nested files are moved up",
+			need:       []string{"foo.mp4", "paris/bar.mp4", "paris/day-1/baz.mp4"},
+			want:       []string{"foo.mp4", "paris-bar.mp4", "paris-day-1-baz.mp4"},
+			wantNoDirs: []string{"paris"},
+		},
+		{
+			name:   "dry-run",
+			need:   []string{"paris/day-1/baz.mp4"},
+			dryRun: true,
+			want:   []string{"paris/day-1/baz.mp4"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			// setup
+			for _, filePath := range tt.need {
+				err := os.MkdirAll(filepath.Dir(filepath.Join(dir, filePath)), 0755)
+				require.NoError(t, err)
+				err = os.WriteFile(filepath.Join(dir, filePath), nil, 0777)
+				require.NoError(t, err)
+			}
+
+			// execute
+			result := flatten(newTestState(), dir, false, tt.dryRun)
+
+			// assert
+			assert.NoError(t, result)
+			for _, fileName := range tt.want {
+				assert.FileExists(t, filepath.Join(dir, fileName))
+			}
+			for _, dirName := range tt.wantNoDirs {
+				assert.NoDirExists(t, filepath.Join(dir, dirName))
+			}
+		})
+	}
+}
+
+func Test_safeRename_links(t *testing.T) {
+	t.Run("dangling symlink is not overwritten", func(t *testing.T) {
+		defer cleanUp(t, []string{"1.txt"}, []string{"2.txt"})
+
+		// setup
+		err := os.WriteFile("1.txt", nil, 0777)
+		require.NoError(t, err)
+		err = os.Symlink("missing.txt", "2.txt")
+		require.NoError(t, err)
+
+		// execute
+		err = safeRename(newTestState(), "1.txt", "2.txt", false)
+
+		// assert
+		var collision *RenameCollision
+		assert.ErrorAs(t, err, &collision)
+		assert.True(t, isSymlink("2.txt"))
+	})
+
+	t.Run("link mode keeps the original", func(t *testing.T) {
+		defer cleanUp(t, []string{"1.txt", "2.txt"}, nil)
+
+		// setup
+		st := newTestState()
+		err := os.WriteFile("1.txt", []byte("foo"), 0777)
+		require.NoError(t, err)
+		st.linkMode = true
+
+		// execute
+		err = safeRename(st, "1.txt", "2.txt", false)
+
+		// assert
+		assert.NoError(t, err)
+		data, err := os.ReadFile("2.txt")
+		require.NoError(t, err)
+		assert.Equal(t, "foo", string(data))
+	})
+
+	t.Run("follow symlinks renames the target under its own name", func(t *testing.T) {
+		dir := t.TempDir()
+		defer cleanUp(t, []string{"1.txt"}, nil)
+
+		// setup
+		err := os.WriteFile(filepath.Join(dir, "foo.txt"), []byte("foo"), 0777)
+		require.NoError(t, err)
+		err = os.Symlink(filepath.Join(dir, "foo.txt"), "1.txt")
+		require.NoError(t, err)
+		st := newTestState()
+		st.followSymlinks = true
+
+		fileInfoList, err := getFileInfoList(st, []string{"1.txt"}, "", false)
+		require.NoError(t, err)
+		require.Len(t, fileInfoList, 1)
+		require.Equal(t, filepath.Join(dir, "foo.txt"), fileInfoList[0].Name())
+
+		// execute
+		err = safeRename(st, fileInfoList[0].Name(), filepath.Join(dir, "foo-bar.txt"), false)
+
+		// assert
+		assert.NoError(t, err)
+		assert.FileExists(t, filepath.Join(dir, "foo-bar.txt"))
+		dest, err := os.Readlink("1.txt")
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(dir, "foo-bar.txt"), dest)
+	})
+
+	t.Run("relative symlinks are kept relative", func(t *testing.T) {
+		dir := t.TempDir()
+
+		// setup
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "videos"), 0755))
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "library"), 0755))
+		err := os.WriteFile(filepath.Join(dir, "videos", "foo.mp4"), []byte("foo"), 0777)
+		require.NoError(t, err)
+		link := filepath.Join(dir, "library", "Foo.mp4")
+		err = os.Symlink(filepath.Join("..", "videos", "foo.mp4"), link)
+		require.NoError(t, err)
+		st := newTestState()
+		st.followSymlinks = true
+
+		fileInfoList, err := getFileInfoList(st, []string{filepath.Join(dir, "library")}, "", false)
+		require.NoError(t, err)
+		require.Len(t, fileInfoList, 1)
+
+		// execute
+		err = safeRename(st, fileInfoList[0].Name(), filepath.Join(dir, "videos", "2023-foo.mp4"), false)
+
+		// assert
+		assert.NoError(t, err)
+		assert.FileExists(t, filepath.Join(dir, "videos", "2023-foo.mp4"))
+		dest, err := os.Readlink(link)
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join("..", "videos", "2023-foo.mp4"), dest)
+	})
+}
+
+func Test_naturalLess(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{a: "file2", b: "file10", want: true},
+		{a: "file10", b: "file2", want: false},
+		{a: "ep01", b: "ep1", want: false},
+		{a: "ep1", b: "ep01", want: true},
+		{a: "a", b: "B", want: true},
+		{a: "foo", b: "foo-1", want: true},
+		{a: "foo", b: "foo", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+" < "+tt.b, func(t *testing.T) {
+			assert.Equal(t, tt.want, naturalLess(tt.a, tt.b))
+		})
+	}
+}
+
+func Test_orderFileInfoList(t *testing.T) {
+	sizes := map[string]int{"a.mp4": 30, "b.mp4": 10, "c.mp4": 20, "d.mp4": 5}
+	lengths := map[string]float64{"a.mp4": 5, "b.mp4": 60, "c.mp4": 1}
+
+	tests := []struct {
+		name    string
+		order   string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:  "unchanged",
+			order: "",
+			want:  []string{"a.mp4", "b.mp4", "c.mp4", "d.mp4"},
+		},
+		{
+			name:  "smallest first",
+			order: orderSmallestFirst,
+			want:  []string{"d.mp4", "b.mp4", "c.mp4", "a.mp4"},
+		},
+		{
+			name:  "largest first",
+			order: orderLargestFirst,
+			want:  []string{"a.mp4", "c.mp4", "b.mp4", "d.mp4"},
+		},
+		{
+			name:  "shortest first, unknown length last",
+			order: orderShortestFirst,
+			want:  []string{"c.mp4", "a.mp4", "b.mp4", "d.mp4"},
+		},
+		{
+			name:  "longest first, unknown length last",
+			order: orderLongestFirst,
+			want:  []string{"b.mp4", "a.mp4", "c.mp4", "d.mp4"},
+		},
+		{
+			name:    "invalid",
+			order:   "newest-first",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			dir := t.TempDir()
+			var fileInfoList []os.FileInfo
+			for _, name := range []string{"a.mp4", "b.mp4", "c.mp4", "d.mp4"} {
+				path := filepath.Join(dir, name)
+				err := os.WriteFile(path, make([]byte, sizes[name]), 0777)
+				require.NoError(t, err)
+				fi, err := os.Stat(path)
+				require.NoError(t, err)
+				fileInfoList = append(fileInfoList, fi)
+			}
+			lengthOf := func(fi os.FileInfo) (float64, error) {
+				length, ok := lengths[fi.Name()]
+				if !ok {
+					return 0, errors.New("no length")
+				}
+
+				return length, nil
+			}
+
+			// execute
+			err := orderFileInfoList(newLogger(true), fileInfoList, tt.order, lengthOf)
+
+			// assert
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			var got []string
+			for _, fi := range fileInfoList {
+				got = append(got, fi.Name())
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_getFileInfoList_extensions(t *testing.T) {
+	tests := []struct {
+		name       string
+		need       []string
+		args       []string
+		extensions []string
+		want       []string
+	}{
+		{
+			name:       "explicit files are filtered",
+			need:       []string{"a.mp4", "a.srt", "b.mkv"},
+			args:       []string{"a.mp4", "a.srt", "b.mkv"},
+			extensions: []string{"mp4", ".mkv"},
+			want:       []string{"a.mp4", "b.mkv"},
+		},
+		{
+			name: "explicit files are not filtered by default",
+			need: []string{"a.mp4", "a.srt"},
+			args: []string{"a.mp4", "a.srt"},
+			want: []string{"a.mp4", "a.srt"},
+		},
+		{
+			name: "directories default to video files",
+			need: []string{"a.mp4", "a.srt", "b.MOV", "c.part"},
+			args: []string{"."},
+			want: []string{"a.mp4", "b.MOV"},
+		},
+		{
+			name:       "directories use allowed extensions",
+			need:       []string{"a.mp4", "a.srt"},
+			args:       []string{"."},
+			extensions: []string{"srt"},
+			want:       []string{"a.srt"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			// setup
+			st := newTestState()
+			var args []string
+			for _, filePath := range tt.need {
+				err := os.WriteFile(filepath.Join(dir, filePath), nil, 0777)
+				require.NoError(t, err)
+			}
+			for _, arg := range tt.args {
+				args = append(args, filepath.Join(dir, arg))
+			}
+			st.allowedExtensions = tt.extensions
+
+			// execute
+			result, err := getFileInfoList(st, args, sortName, false)
+
+			// assert
+			require.NoError(t, err)
+			var got []string
+			for _, fi := range result {
+				got = append(got, filepath.Base(fi.Name()))
+				assert.FileExists(t, fi.Name())
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_prefix_subdirectory(t *testing.T) {
+	dir := t.TempDir()
+
+	// setup
+	st := newTestState()
+	err := os.WriteFile(filepath.Join(dir, "foo.txt"), nil, 0777)
+	require.NoError(t, err)
+	list, err := getFileInfoList(st, []string{dir}, sortName, false)
+	require.NoError(t, err)
+	require.Empty(t, list)
+	st.allowedExtensions = []string{"txt"}
+	list, err = getFileInfoList(st, []string{dir}, sortName, false)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+
+	// execute
+	result := prefix(st, list[0], "bar", 0, false, false)
+
+	// assert
+	assert.NoError(t, result)
+	assert.FileExists(t, filepath.Join(dir, "bar-foo.txt"))
+}
+
+func Test_computeStats(t *testing.T) {
+	// setup
+	vs := videoTypes{
+		{name: "a.mp4", size: 100, bitRate: 1000, length: 10, height: 1080, codec: "hevc"},
+		{name: "b.MP4", size: 300, bitRate: 3000, length: 30, height: 720, codec: "h264"},
+		{name: "c.mkv", size: 50, length: 5, height: 2160, codec: "hevc"},
+	}
+
+	// execute
+	s := computeStats(vs)
+
+	// assert
+	assert.Equal(t, 3, s.Count)
+	assert.Equal(t, int64(450), s.Size)
+	assert.Equal(t, 45.0, s.Length)
+	assert.Equal(t, int64(2500), s.AverageBitRate)
+	assert.Equal(t, []statsGroup{
+		{Name: "h264", Count: 1, Size: 300, Length: 30},
+		{Name: "hevc", Count: 2, Size: 150, Length: 15},
+	}, s.Codecs)
+	assert.Equal(t, []statsGroup{
+		{Name: hdPreset, Count: 1, Size: 300, Length: 30},
+		{Name: fullHDPreset, Count: 1, Size: 100, Length: 10},
+		{Name: fourKPreset, Count: 1, Size: 50, Length: 5},
+	}, s.Resolutions)
+	assert.Equal(t, []statsGroup{
+		{Name: "mp4", Count: 2, Size: 400, Length: 40},
+		{Name: "mkv", Count: 1, Size: 50, Length: 5},
+	}, s.Containers)
+}
+
+func Test_rateEfficiency(t *testing.T) {
+	tests := []struct {
+		name string
+		v    videoType
+		want string
+	}{
+		{
+			name: "typical h264",
+			v:    videoType{codec: codecH264, bitRate: 6_000_000, width: 1920, height: 1080, frameRate: 30},
+			want: efficiencyOk,
+		},
+		{
+			name: "bloated h264",
+			v:    videoType{codec: codecH264, bitRate: 60_000_000, width: 1920, height: 1080, frameRate: 30},
+			want: efficiencyHigh,
+		},
+		{
+			name: "crushed hevc",
+			v:    videoType{codec: codecH265, bitRate: 500_000, width: 1920, height: 1080, frameRate: 30},
+			want: efficiencyLow,
+		},
+		{
+			name: "unknown codec",
+			v:    videoType{codec: "foo", bitRate: 500_000, width: 1920, height: 1080, frameRate: 30},
+			want: efficiencyUnknown,
+		},
+		{
+			name: "missing frame rate",
+			v:    videoType{codec: codecH264, bitRate: 500_000, width: 1920, height: 1080},
+			want: efficiencyUnknown,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, rateEfficiency(tt.v.codec, bitsPerPixel(tt.v)))
+		})
+	}
+}
+
+func Test_filterJournal(t *testing.T) {
+	// setup
+	day := func(d int) time.Time {
+		return time.Date(2024, 1, d, 12, 0, 0, 0, time.UTC)
+	}
+	entries := []journalEntry{
+		{Time: day(1), Command: prefixCommand, Action: journalRename, From: "/a/foo.mp4", To: "/a/x-foo.mp4"},
+		{Time: day(2), Command: organizeCommand, Action: journalMkdir, To: "/a/2024"},
+		{Time: day(3), Command: organizeCommand, Action: journalRename, From: "/a/bar.mp4", To: "/a/2024/bar.mp4"},
+	}
+
+	tests := []struct {
+		name    string
+		since   time.Time
+		until   time.Time
+		command string
+		file    string
+		want    []journalEntry
+	}{
+		{
+			name: "no filter",
+			want: entries,
+		},
+		{
+			name:  "date range",
+			since: day(2),
+			until: day(3),
+			want:  entries[1:2],
+		},
+		{
+			name:    "command",
+			command: organizeCommand,
+			want:    entries[1:],
+		},
+		{
+			name: "file",
+			file: "foo",
+			want: entries[:1],
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, filterJournal(entries, tt.since, tt.until, tt.command, tt.file))
+		})
+	}
+}
+
+func Test_labels(t *testing.T) {
+	dir := t.TempDir()
+	foo := filepath.Join(dir, "foo.mp4")
+	bar := filepath.Join(dir, "bar.mp4")
+
+	// setup
+	err := os.WriteFile(foo, nil, 0777)
+	require.NoError(t, err)
+	err = os.WriteFile(bar, nil, 0777)
+	require.NoError(t, err)
+
+	// execute
+	err = addLabels(foo, []string{"todo-encode", "holiday"})
+	require.NoError(t, err)
+
+	// assert
+	labels, err := getLabels(foo)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"holiday", "todo-encode"}, labels)
+
+	filter, err := parseFilter("label=todo-encode")
+	require.NoError(t, err)
+	fooInfo, err := os.Stat(foo)
+	require.NoError(t, err)
+	barInfo, err := os.Stat(bar)
+	require.NoError(t, err)
+	assert.True(t, matchesFilters(newLogger(true), withPath(fooInfo, foo), []fileFilter{filter}))
+	assert.False(t, matchesFilters(newLogger(true), withPath(barInfo, bar), []fileFilter{filter}))
+
+	// labels follow renames
+	baz := filepath.Join(dir, "baz.mp4")
+	err = safeRename(newTestState(), foo, baz, false)
+	require.NoError(t, err)
+	labels, err = getLabels(baz)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"holiday", "todo-encode"}, labels)
+	labels, err = getLabels(foo)
+	require.NoError(t, err)
+	assert.Empty(t, labels)
+
+	// the database is removed once empty
+	err = removeLabels(baz, []string{"todo-encode", "holiday"})
+	require.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(dir, labelsFileName))
+
+	_, err = parseFilter("foo")
+	assert.ErrorContains(t, err, "invalid filter")
+}
+
+func Test_setRatingToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		basePath string
+		rating   int
+		want     string
+	}{
+		{name: "insert", basePath: "foo-bar", rating: 4, want: "foo-bar-r4"},
+		{name: "update", basePath: "foo-r2-bar", rating: 5, want: "foo-r5-bar"},
+		{name: "remove", basePath: "foo-r2-bar", rating: 0, want: "foo-bar"},
+		{name: "similar tokens are kept", basePath: "foo-rx-r", rating: 1, want: "foo-rx-r-r1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, setRatingToken(tt.basePath, tt.rating))
+		})
+	}
+}
+
+func Test_rate(t *testing.T) {
+	type args struct {
+		filePath string
+		rating   int
+		dryRun   bool
+	}
+	tests := []struct {
+		name    string
+		need    []string
+		args    args
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "rate",
+			need: []string{"foo-r2.txt"},
+			args: args{
+				filePath: "foo-r2.txt",
+				rating:   4,
+			},
+			want: []string{"foo-r4.txt"},
+		},
+		{
+			name: "invalid rating",
+			need: []string{"foo.txt"},
+			args: args{
+				filePath: "foo.txt",
+				rating:   6,
+			},
+			want:    []string{"foo.txt"},
+			wantErr: "invalid rating",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer cleanUp(t, tt.want, tt.need)
+
+			var err error
+
+			// setup
+			for _, filePath := range tt.need {
+				err = os.WriteFile(filePath, nil, 0777)
+				require.NoError(t, err)
+			}
+
+			fi, err := os.Stat(tt.args.filePath)
+			require.NoError(t, err)
+
+			// execute
+			result := rate(newTestState(), fi, tt.args.rating, false, tt.args.dryRun)
+
+			// assert
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, result, tt.wantErr)
+			} else {
+				assert.NoError(t, result)
+			}
+
+			for _, fileName := range tt.want {
+				assert.FileExists(t, fileName)
+			}
+
+			filter, err := parseFilter(fmt.Sprintf("rating>=%d", tt.args.rating))
+			require.NoError(t, err)
+			fi, err = os.Stat(tt.want[0])
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantErr == "", matchesFilters(newLogger(true), fi, []fileFilter{filter}))
+		})
+	}
+}
+
+func Test_inlineImage(t *testing.T) {
+	// setup
+	data := make([]byte, 5000)
+
+	// execute
+	iterm := inlineImage(imageProtocolITerm, data)
+	kitty := inlineImage(imageProtocolKitty, data)
+
+	// assert
+	assert.True(t, strings.HasPrefix(iterm, "\033]1337;File=inline=1;size=5000:"))
+	assert.Equal(t, 2, strings.Count(kitty, "\033_G"))
+	assert.Contains(t, kitty, "\033_Ga=T,f=100,m=1;")
+	assert.Contains(t, kitty, "\033_Gm=0;")
+}
+
+func Test_planRename(t *testing.T) {
+	// setup
+	st := newTestState()
+	err := os.WriteFile("foo.txt", nil, 0777)
+	require.NoError(t, err)
+	defer cleanUp(t, []string{"foo.txt"}, nil)
+
+	fi, err := os.Stat("foo.txt")
+	require.NoError(t, err)
+
+	// execute
+	err = prefix(st, fi, "bar", 0, false, true)
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, []renamePair{{oldPath: "foo.txt", newPath: "bar-foo.txt"}}, st.changes)
+}
+
+func Test_pathExists(t *testing.T) {
+	// setup
+	st := newTestState()
+	dir := t.TempDir()
+	path := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+	for _, name := range []string{"a.mp4", "b.mp4"} {
+		require.NoError(t, os.WriteFile(path(name), nil, 0644))
+	}
+
+	st.changes, st.outputs = nil, nil
+	planRename(st, path("a.mp4"), path("c.mp4"))
+	planRename(st, path("c.mp4"), path("d.mp4"))
+	planEncode(st, path("b.mp4"), path("b-x265.mp4"))
+
+	tests := []struct {
+		name string
+		path string
+		want bool
+	}{
+		{name: "renamed away", path: path("a.mp4"), want: false},
+		{name: "renamed again", path: path("c.mp4"), want: false},
+		{name: "planned rename", path: path("d.mp4"), want: true},
+		{name: "encoded files are kept", path: path("b.mp4"), want: true},
+		{name: "planned output", path: path("b-x265.mp4"), want: true},
+		{name: "missing", path: path("e.mp4"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got := pathExists(st, tt.path)
+
+			// assert
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_checkCollision(t *testing.T) {
+	// setup
+	st := newTestState()
+	dir := t.TempDir()
+	path := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+	require.NoError(t, os.WriteFile(path("a.mp4"), nil, 0644))
+
+	planEncode(st, path("a.mp4"), path("a-x265.mp4"))
+
+	tests := []struct {
+		name           string
+		path           string
+		forceOverwrite bool
+		wantErr        bool
+	}{
+		{name: "existing file", path: path("a.mp4"), wantErr: true},
+		{name: "planned output", path: path("a-x265.mp4"), wantErr: true},
+		{name: "forced overwrite", path: path("a.mp4"), forceOverwrite: true},
+		{name: "free path", path: path("b.mp4")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			err := checkCollision(st, tt.path, tt.forceOverwrite)
+
+			// assert
+			if tt.wantErr {
+				var collision *RenameCollision
+				require.ErrorAs(t, err, &collision)
+				assert.Equal(t, tt.path, collision.Path)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func Test_removeFile_dryRunNumbering(t *testing.T) {
+	// setup
+	st := newTestState()
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.mp4"), nil, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "b.mp4"), nil, 0644))
+
+	st.changes, st.outputs = nil, nil
+
+	// execute
+	require.NoError(t, removeFile(st, filepath.Join(dir, "a.mp4"), false, true))
+	planRename(st, filepath.Join(dir, "b.mp4"), filepath.Join(dir, "a.mp4"))
+	require.NoError(t, removeFile(st, filepath.Join(dir, "a.mp4"), false, true))
+
+	// assert
+	assert.Equal(t, filepath.Join(dir, trashDirName, "a.mp4"), st.changes[0].newPath)
+	assert.Equal(t, filepath.Join(dir, trashDirName, "a-1.mp4"), st.changes[2].newPath)
+}
+
+func Test_batchReport(t *testing.T) {
+	// setup
+	st := newTestState()
+	dir := t.TempDir()
+	newPath := filepath.Join(dir, "bar-foo.txt")
+	err := os.WriteFile(newPath, []byte("foo"), 0777)
+	require.NoError(t, err)
+
+	reportPath := filepath.Join(dir, "report.html")
+
+	r := newBatchReport(reportHTML, "prefix")
+	row := reportRow{OldPath: "<foo>.txt"}
+
+	// execute
+	r.Add(st, row, []renamePair{{oldPath: "<foo>.txt", newPath: newPath}}, time.Second, nil)
+	err = r.Write(st, reportPath, time.Second)
+
+	// assert
+	require.NoError(t, err)
+	require.Len(t, r.rows, 1)
+	assert.Equal(t, newPath, r.rows[0].NewPath)
+	assert.Equal(t, "3 B", r.rows[0].SizeAfter)
+
+	data, err := os.ReadFile(reportPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "&lt;foo&gt;.txt")
+	assert.Contains(t, string(data), "ffr prefix")
+}
+
+func Test_batchReport_nil(t *testing.T) {
+	r := newBatchReport("", "prefix")
+
+	assert.Nil(t, r)
+	assert.NoError(t, r.Write(newTestState(), "", 0))
+}
+
+func Test_batchSummary(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	oldPath := filepath.Join(dir, "foo.mp4")
+	err := os.WriteFile(oldPath, make([]byte, 100), 0777)
+	require.NoError(t, err)
+	newPath := filepath.Join(dir, "foo-libx265.mp4")
+	err = os.WriteFile(newPath, make([]byte, 40), 0777)
+	require.NoError(t, err)
+	fi, err := os.Stat(oldPath)
+	require.NoError(t, err)
+
+	var got batchSummary
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+	}))
+	defer server.Close()
+
+	s := newBatchSummary("reencode", false, true)
+
+	// execute
+	s.Add(fi, []string{newPath}, nil)
+	s.Add(pathFileInfo{path: "bar.mp4"}, nil, errors.New("failed"))
+	err = s.Send(newTestState(), server.URL, smtpConfig{}, time.Minute)
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, "reencode", got.Command)
+	assert.Equal(t, 2, got.Processed)
+	assert.Equal(t, 1, got.Failed)
+	assert.Equal(t, int64(60), got.SizeSaved)
+	assert.Equal(t, "1m0s", got.WallTime)
+	assert.Equal(t, []summaryFailure{{File: "bar.mp4", Error: "failed"}}, got.Failures)
+}
+
+func Test_batchSummary_webhookFails(t *testing.T) {
+	// setup
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	s := newBatchSummary("reencode", false, true)
+
+	// execute
+	err := s.Send(newTestState(), server.URL, smtpConfig{}, time.Minute)
+
+	// assert
+	assert.Error(t, err)
+}
+
+func Test_batchSummary_nil(t *testing.T) {
+	s := newBatchSummary("reencode", false, false)
+
+	assert.Nil(t, s)
+	assert.NoError(t, s.Send(newTestState(), "http://localhost:0", smtpConfig{to: []string{"foo@example.com"}}, 0))
+}
+
+func Test_process_withoutSummary(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "a-b.txt")
+	require.NoError(t, os.WriteFile(filePath, []byte("foo"), 0644))
+
+	app := newApp()
+
+	// execute
+	err := app.Run([]string{"ffr", "--" + rootFlag, dir, "--" + commandHistoryFlag + "=", "--" + journalFlag + "=", prefixCommand, "zz", filePath})
+
+	// assert
+	require.NoError(t, err)
+	assert.NoFileExists(t, filePath)
+	assert.FileExists(t, filepath.Join(dir, "zz-a-b.txt"))
+}
+
+func Test_isEncodingCommand(t *testing.T) {
+	encoders := []string{
+		previewClipCommand,
+		reencodeCommand,
+		timelapseCommand,
+		archiveCommand,
+		proxyCommand,
+		restoreSDCommand,
+		overlayTextCommand,
+		loopCommand,
+		muxAudioCommand,
+		audioChannelsCommand,
+		filterCommand,
+		eachCommand,
+		framesExportCommand,
+		framesImportCommand,
+		convertImageCommand,
+		reencodeAudioCommand,
+		cropCommand,
+		cleanupRecordingsCommand,
+	}
+
+	t.Run("every encoding command is marked", func(t *testing.T) {
+		// setup
+		app := newApp()
+
+		// execute
+		got, ok := app.Metadata[encodingMetadataKey].(commandSet)
+
+		// assert
+		require.True(t, ok)
+		for _, name := range encoders {
+			assert.True(t, got[name], name)
+		}
+		assert.Len(t, got, len(encoders))
+	})
+
+	t.Run("order applies to proxy", func(t *testing.T) {
+		// setup
+		dir := t.TempDir()
+		filePath := filepath.Join(dir, "foo.mp4")
+		require.NoError(t, os.WriteFile(filePath, []byte("foo"), 0644))
+
+		useFakeRunner(t, func(args []string) (string, error) {
+			return "10", nil
+		})
+
+		app := newApp()
+
+		// execute
+		err := app.Run([]string{"ffr", "--" + rootFlag, dir, "--" + commandHistoryFlag + "=", "--" + journalFlag + "=", "--" + dryRunFlag, "--" + orderFlag, orderShortestFirst, proxyCommand, filePath})
+
+		// assert
+		assert.NoError(t, err)
+	})
+}
+
+func Test_smtpConfig_message(t *testing.T) {
+	// setup
+	cfg := smtpConfig{to: []string{"foo@example.com", "bar@example.com"}, from: "ffr@example.com"}
+	s := &batchSummary{Command: "reencode", Host: "nas", Processed: 3, Failed: 1, Failures: []summaryFailure{{File: "a.mp4", Error: "failed"}}}
+
+	// execute
+	got := string(cfg.message(newTestState(), s))
+
+	// assert
+	assert.Contains(t, got, "To: foo@example.com, bar@example.com\r\n")
+	assert.Contains(t, got, "Subject: ffr reencode on nas: 3 processed, 1 failed\r\n")
+	assert.Contains(t, got, "  a.mp4: failed\r\n")
+}
+
+func Test_getThroughput(t *testing.T) {
+	type args struct {
+		length    float64
+		frameRate float64
+		size      int64
+		elapsed   time.Duration
+	}
+	tests := []struct {
+		name string
+		args args
+		want throughput
+	}{
+		{
+			name: "empty elapsed",
+			args: args{length: 60, frameRate: 30, size: 1000 * 1000, elapsed: 0},
+			want: throughput{},
+		},
+		{
+			name: "twice realtime",
+			args: args{length: 60, frameRate: 30, size: 60 * 1000 * 1000, elapsed: 30 * time.Second},
+			want: throughput{fps: 60, speed: 2, mbps: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got := getThroughput(tt.args.length, tt.args.frameRate, tt.args.size, tt.args.elapsed)
+
+			// assert
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.want.String(), got.String())
+		})
+	}
+}
+
+func Test_detectHWAccel(t *testing.T) {
+	type args struct {
+		encoder string
+		working []string
+		cache   string
+	}
+	tests := []struct {
+		name      string
+		args      args
+		want      string
+		wantCache string
+	}{
+		{
+			name:      "no working backend",
+			args:      args{encoder: encoderH265},
+			want:      "",
+			wantCache: `{"libx265":""}`,
+		},
+		{
+			name:      "best working backend is picked",
+			args:      args{encoder: encoderH265, working: []string{"hevc_vaapi", "hevc_qsv"}},
+			want:      hwaccelQSV,
+			wantCache: `{"libx265":"qsv"}`,
+		},
+		{
+			name:      "backends without an encoder are skipped",
+			args:      args{encoder: encoderVP9, working: []string{"vp9_vaapi"}},
+			want:      hwaccelVAAPI,
+			wantCache: `{"vp9":"vaapi"}`,
+		},
+		{
+			name:      "cached result is used",
+			args:      args{encoder: encoderH264, working: []string{"h264_nvenc"}, cache: `{"libx264":"vaapi"}`},
+			want:      hwaccelVAAPI,
+			wantCache: `{"libx264":"vaapi"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			cachePath := filepath.Join(t.TempDir(), "ffr", "hwaccel.json")
+			if tt.args.cache != "" {
+				require.NoError(t, os.MkdirAll(filepath.Dir(cachePath), 0755))
+				require.NoError(t, os.WriteFile(cachePath, []byte(tt.args.cache), 0644))
+			}
+
+			probe := func(backend, encoder string) bool {
+				return containsString(tt.args.working, encoder)
+			}
+
+			// execute
+			got := detectHWAccel(newLogger(true), tt.args.encoder, cachePath, probe)
+
+			// assert
+			assert.Equal(t, tt.want, got)
+
+			data, err := os.ReadFile(cachePath)
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.wantCache, string(data))
+		})
+	}
+}
+
+func Test_reEncode_options(t *testing.T) {
+	type args struct {
+		o reEncodeOptions
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "libx265 10-bit",
+			args: args{o: reEncodeOptions{codec: encoderH265, crf: 28, preset: "ultrafast", bitDepth: 10}},
+			want: "foo-libx265-yuv420p10le-28-ultrafast.mp4",
+		},
+		{
+			name: "libx264 8-bit",
+			args: args{o: reEncodeOptions{codec: encoderH264, crf: 23, preset: "ultrafast", bitDepth: 8}},
+			want: "foo-libx264-yuv420p-23-ultrafast.mp4",
+		},
+		{
+			name: "libx264 tune",
+			args: args{o: reEncodeOptions{codec: encoderH264, crf: 23, preset: "ultrafast", tune: "film"}},
+			want: "foo-libx264-23-ultrafast-film.mp4",
+		},
+		{
+			name: "constant frame rate",
+			args: args{o: reEncodeOptions{codec: encoderH264, crf: 23, preset: "ultrafast", cfr: true}},
+			want: "foo-libx264-23-ultrafast-cfr.mp4",
+		},
+		{
+			name:    "tune not supported by x265",
+			args:    args{o: reEncodeOptions{codec: encoderH265, crf: 28, preset: "ultrafast", tune: "film"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid bit depth",
+			args:    args{o: reEncodeOptions{codec: encoderH265, crf: 28, preset: "ultrafast", bitDepth: 12}},
+			wantErr: true,
+		},
+		{
+			name: "prores default profile",
+			args: args{o: reEncodeOptions{codec: encoderProRes, crf: 28, preset: "ultrafast"}},
+			want: "foo-prores_ks-yuv422p10le.mov",
+		},
+		{
+			name: "dnxhr hqx in mxf",
+			args: args{o: reEncodeOptions{codec: encoderDNxHR, profile: "hqx", container: "mxf"}},
+			want: "foo-dnxhd-yuv422p10le.mxf",
+		},
+		{
+			name:    "bit depth is set by the prores profile",
+			args:    args{o: reEncodeOptions{codec: encoderProRes, bitDepth: 10}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid dnxhr profile",
+			args:    args{o: reEncodeOptions{codec: encoderDNxHR, profile: "4444"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid container",
+			args:    args{o: reEncodeOptions{codec: encoderProRes, container: "mp4"}},
+			wantErr: true,
+		}, {
+			name: "name template",
+			args: args{o: reEncodeOptions{codec: encoderH264, crf: 23, preset: "ultrafast", nameTemplate: "{{.Base}}-{{.Encoder}}-crf{{.CRF}}"}},
+			want: "foo-libx264-crf23.mp4",
+		},
+		{
+			name: "name template using the default suffix",
+			args: args{o: reEncodeOptions{codec: encoderH264, crf: 23, preset: "ultrafast", nameTemplate: "small-{{.Base}}-{{.Params}}"}},
+			want: "small-foo-libx264-23-ultrafast.mp4",
+		},
+		{
+			name: "no suffix in output dir",
+			args: args{o: reEncodeOptions{codec: encoderH264, crf: 23, preset: "ultrafast", nameTemplate: noSuffixReEncodeName, outputDir: "encoded"}},
+			want: "encoded/foo.mp4",
+		},
+		{
+			name:    "invalid name template",
+			args:    args{o: reEncodeOptions{codec: encoderH264, crf: 23, preset: "ultrafast", nameTemplate: "{{.Foo}}"}},
+			wantErr: true,
+		},
+		{
+			name:    "name template with a path separator",
+			args:    args{o: reEncodeOptions{codec: encoderH264, crf: 23, preset: "ultrafast", nameTemplate: "x/{{.Base}}"}},
+			wantErr: true,
+		},
+		{
+			name:    "empty name",
+			args:    args{o: reEncodeOptions{codec: encoderH264, crf: 23, preset: "ultrafast", nameTemplate: "{{if false}}x{{end}}"}},
+			wantErr: true,
+		},
+		{
+			name:    "no suffix would overwrite the input",
+			args:    args{o: reEncodeOptions{codec: encoderH264, crf: 23, preset: "ultrafast", nameTemplate: noSuffixReEncodeName}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			err := os.WriteFile("foo.mp4", nil, 0777)
+			require.NoError(t, err)
+			defer cleanUp(t, []string{"foo.mp4"}, nil)
+
+			fi, err := os.Stat("foo.mp4")
+			require.NoError(t, err)
+
+			// execute
+			got, err := reEncode(newTestState(), fi, tt.args.o, true)
+
+			// assert
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_getContainer(t *testing.T) {
+	type args struct {
+		codec     string
+		container string
+		filePath  string
+		force     bool
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "default of libx265",
+			args: args{codec: encoderH265, filePath: "foo.mov"},
+			want: "mp4",
+		},
+		{
+			name: "default of vp9",
+			args: args{codec: encoderVP9, filePath: "foo.mp4"},
+			want: "mkv",
+		},
+		{
+			name: "default of prores",
+			args: args{codec: encoderProRes, filePath: "foo.mp4"},
+			want: "mov",
+		},
+		{
+			name: "mkv",
+			args: args{codec: encoderH264, container: "mkv", filePath: "foo.mp4"},
+			want: "mkv",
+		},
+		{
+			name: "same",
+			args: args{codec: encoderH265, container: containerSame, filePath: "foo.MKV"},
+			want: "mkv",
+		},
+		{
+			name:    "same, but not supported by the encoder",
+			args:    args{codec: encoderH265, container: containerSame, filePath: "foo.webm"},
+			wantErr: true,
+		},
+		{
+			name:    "vp9 in mp4",
+			args:    args{codec: encoderVP9, container: "mp4", filePath: "foo.mkv"},
+			wantErr: true,
+		},
+		{
+			name: "vp9 in mp4 forced",
+			args: args{codec: encoderVP9, container: "mp4", filePath: "foo.mkv", force: true},
+			want: "mp4",
+		},
+		{
+			name:    "forcing does not allow invalid containers",
+			args:    args{codec: encoderH264, container: "webm", filePath: "foo.mkv", force: true},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got, err := getContainer(tt.args.codec, tt.args.container, tt.args.filePath, tt.args.force)
+
+			// assert
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_mergeEncoderParams(t *testing.T) {
+	type args struct {
+		generated string
+		custom    string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "no custom params",
+			args: args{generated: "keyint=1"},
+			want: "keyint=1",
+		},
+		{
+			name: "custom params override generated ones",
+			args: args{generated: "keyint=1:bframes=3", custom: "keyint=250:aq-mode=3"},
+			want: "keyint=250:bframes=3:aq-mode=3",
+		},
+		{
+			name:    "invalid custom param",
+			args:    args{generated: "keyint=1", custom: "keyint"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got, err := mergeEncoderParams(tt.args.generated, tt.args.custom)
+
+			// assert
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_getKeyInt(t *testing.T) {
+	type args struct {
+		keyInt   string
+		allIntra bool
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    int
+		wantErr bool
+	}{
+		{
+			name: "default",
+			args: args{},
+			want: 250,
+		},
+		{
+			name: "custom",
+			args: args{keyInt: "120"},
+			want: 120,
+		},
+		{
+			name: "all intra wins",
+			args: args{keyInt: "120", allIntra: true},
+			want: 1,
+		},
+		{
+			name:    "invalid",
+			args:    args{keyInt: "0"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got, err := getKeyInt(newTestState(), nil, tt.args.keyInt, tt.args.allIntra)
+
+			// assert
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_reEncodeAudio(t *testing.T) {
+	type args struct {
+		codec      string
+		bitRate    string
+		vbrQuality int
+		channels   int
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "opus constant bit rate",
+			args: args{codec: "opus", bitRate: "96k", vbrQuality: -1},
+			want: "foo-opus-96k.opus",
+		},
+		{
+			name: "opus variable bit rate",
+			args: args{codec: "opus", bitRate: "128k", vbrQuality: 0},
+			want: "foo-opus-128k-vbr.opus",
+		},
+		{
+			name: "mp3 variable bit rate mono",
+			args: args{codec: "mp3", bitRate: "128k", vbrQuality: 2, channels: 1},
+			want: "foo-mp3-q2-1ch.mp3",
+		},
+		{
+			name: "flac ignores bit rate",
+			args: args{codec: "flac", bitRate: "128k", vbrQuality: -1},
+			want: "foo-flac.flac",
+		},
+		{
+			name:    "invalid codec",
+			args:    args{codec: "wma", vbrQuality: -1},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			err := os.WriteFile("foo.wav", nil, 0777)
+			require.NoError(t, err)
+			defer cleanUp(t, []string{"foo.wav"}, nil)
+
+			fi, err := os.Stat("foo.wav")
+			require.NoError(t, err)
+
+			// execute
+			got, err := reEncodeAudio(newTestState(), fi, tt.args.codec, tt.args.bitRate, tt.args.vbrQuality, tt.args.channels, false, true)
+
+			// assert
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_convertImage(t *testing.T) {
+	type args struct {
+		format          string
+		width           int
+		height          int
+		dimensionPreset string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "format only",
+			args: args{format: "webp"},
+			want: "foo.webp",
+		},
+		{
+			name: "resize with preset",
+			args: args{dimensionPreset: hdPreset},
+			want: "foo-1280x720.jpg",
+		},
+		{
+			name: "resize width only",
+			args: args{format: ".PNG", width: 800},
+			want: "foo-800x.png",
+		},
+		{
+			name:    "nothing to do",
+			args:    args{format: "jpg"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid format",
+			args:    args{format: "doc"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			err := os.WriteFile("foo.jpg", nil, 0777)
+			require.NoError(t, err)
+			defer cleanUp(t, []string{"foo.jpg"}, nil)
+
+			fi, err := os.Stat("foo.jpg")
+			require.NoError(t, err)
+
+			// execute
+			got, err := convertImage(newTestState(), fi, tt.args.format, tt.args.width, tt.args.height, tt.args.dimensionPreset, false, true)
+
+			// assert
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_framesExport(t *testing.T) {
+	// setup
+	st := newTestState()
+	err := os.WriteFile("foo.mp4", nil, 0777)
+	require.NoError(t, err)
+	defer cleanUp(t, []string{"foo.mp4"}, nil)
+
+	fi, err := os.Stat("foo.mp4")
+	require.NoError(t, err)
+
+	st.changes = nil
+
+	// execute
+	got, err := framesExport(st, fi, "jpg", 1, false, true)
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, "foo-frames", got)
+	assert.Equal(t, []renamePair{{oldPath: "foo.mp4", newPath: filepath.Join("foo-frames", "foo-%06d.jpg")}}, st.changes)
+}
+
+func Test_writeConcatList(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	var fileList []os.FileInfo
+	for _, name := range []string{"a.png", "it's.png"} {
+		filePath := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(filePath, nil, 0644))
+
+		fi, err := os.Stat(filePath)
+		require.NoError(t, err)
+
+		fileList = append(fileList, withPath(fi, filePath))
+	}
+
+	sb := &strings.Builder{}
+
+	// execute
+	err := writeConcatList(sb, fileList, 4)
+
+	// assert
+	require.NoError(t, err)
+	want := fmt.Sprintf("file '%s/a.png'\nduration 0.25\nfile '%s/it'\\''s.png'\nduration 0.25\nfile '%s/it'\\''s.png'\n", dir, dir, dir)
+	assert.Equal(t, want, sb.String())
+}
+
+func Test_framesImport_order(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	var args []string
+	for _, name := range []string{"img10.png", "img2.png", "img1.png", "img3.png"} {
+		filePath := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(filePath, []byte("foo"), 0644))
+		args = append(args, filePath)
+	}
+
+	var list string
+	useFakeRunner(t, func(args []string) (string, error) {
+		data, err := os.ReadFile(args[6])
+		require.NoError(t, err)
+		list = string(data)
+
+		return "", nil
+	})
+
+	app := newApp()
+
+	// execute
+	err := app.Run(append([]string{"ffr", "--" + rootFlag, dir, "--" + commandHistoryFlag + "=", "--" + journalFlag + "=", framesImportCommand, filepath.Join(dir, "out.mp4")}, args...))
+
+	// assert
+	require.NoError(t, err)
+	var got []string
+	for _, line := range strings.Split(list, "\n") {
+		if strings.HasPrefix(line, "file ") {
+			got = append(got, filepath.Base(strings.Trim(strings.TrimPrefix(line, "file "), "'")))
+		}
+	}
+	assert.Equal(t, []string{"img1.png", "img2.png", "img3.png", "img10.png", "img10.png"}, got)
+}
+
+func Test_getAtempoFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		factor float64
+		want   string
+	}{
+		{
+			name:   "single filter",
+			factor: 1.5,
+			want:   "atempo=1.5",
+		},
+		{
+			name:   "chained filters",
+			factor: 30,
+			want:   "atempo=2,atempo=2,atempo=2,atempo=2,atempo=1.875",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got := getAtempoFilter(tt.factor)
+
+			// assert
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_timelapse(t *testing.T) {
+	type args struct {
+		factor float64
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "factor",
+			args: args{factor: 30},
+			want: "foo-x30.mp4",
+		},
+		{
+			name: "fractional factor",
+			args: args{factor: 2.5},
+			want: "foo-x2.5.mp4",
+		},
+		{
+			name:    "slow down is not a timelapse",
+			args:    args{factor: 0.5},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			err := os.WriteFile("foo.mp4", nil, 0777)
+			require.NoError(t, err)
+			defer cleanUp(t, []string{"foo.mp4"}, nil)
+
+			fi, err := os.Stat("foo.mp4")
+			require.NoError(t, err)
+
+			// execute
+			got, err := timelapse(newTestState(), fi, tt.args.factor, 0, true, encoderH264, 0, false, true)
+
+			// assert
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_loop(t *testing.T) {
+	type args struct {
+		count     int
+		boomerang bool
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "loop",
+			args: args{count: 3},
+			want: "foo-loop3.mp4",
+		},
+		{
+			name: "single boomerang",
+			args: args{count: 1, boomerang: true},
+			want: "foo-boomerang1.mp4",
+		},
+		{
+			name:    "single loop is no loop",
+			args:    args{count: 1},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			err := os.WriteFile("foo.mp4", nil, 0777)
+			require.NoError(t, err)
+			defer cleanUp(t, []string{"foo.mp4"}, nil)
+
+			fi, err := os.Stat("foo.mp4")
+			require.NoError(t, err)
+
+			// execute
+			got, err := loop(newTestState(), fi, tt.args.count, tt.args.boomerang, false, true)
+
+			// assert
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_muxAudio(t *testing.T) {
+	type args struct {
+		audioPath string
+	}
+	tests := []struct {
+		name    string
+		need    []string
+		args    args
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "mux",
+			need: []string{"foo.mp4", "foo.m4a"},
+			args: args{audioPath: "foo.m4a"},
+			want: "foo-muxed.mp4",
+		},
+		{
+			name:    "missing audio",
+			need:    []string{"foo.mp4"},
+			args:    args{audioPath: "foo.m4a"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			for _, filePath := range tt.need {
+				err := os.WriteFile(filePath, nil, 0777)
+				require.NoError(t, err)
+			}
+			defer cleanUp(t, tt.need, nil)
+
+			fi, err := os.Stat("foo.mp4")
+			require.NoError(t, err)
+
+			// execute
+			got, err := muxAudio(newTestState(), fi, tt.args.audioPath, -0.5, false, true, false, true)
+
+			// assert
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_getPanFilter(t *testing.T) {
+	type args struct {
+		mode    string
+		channel string
+	}
+	tests := []struct {
+		name      string
+		args      args
+		want      string
+		wantToken string
+		wantErr   bool
+	}{
+		{
+			name:      "downmix",
+			args:      args{mode: channelsDownmix},
+			want:      "pan=stereo|c0<c0+0.707*c2+0.707*c4|c1<c1+0.707*c2+0.707*c5",
+			wantToken: "stereo",
+		},
+		{
+			name:      "swap",
+			args:      args{mode: channelsSwap},
+			want:      "pan=stereo|c0=c1|c1=c0",
+			wantToken: "swapped",
+		},
+		{
+			name:      "extract by name",
+			args:      args{mode: channelsExtract, channel: "FC"},
+			want:      "pan=mono|c0=FC",
+			wantToken: "FC",
+		},
+		{
+			name:      "extract by index",
+			args:      args{mode: channelsExtract, channel: "3"},
+			want:      "pan=mono|c0=c3",
+			wantToken: "c3",
+		},
+		{
+			name:    "extract invalid channel",
+			args:    args{mode: channelsExtract, channel: "FC|c1=FL"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid mode",
+			args:    args{mode: "upmix"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got, gotToken, err := getPanFilter(tt.args.mode, tt.args.channel)
+
+			// assert
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantToken, gotToken)
+		})
+	}
+}
+
+func Test_reEncodeOutputs(t *testing.T) {
+	type args struct {
+		outputs []string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "several renditions",
+			args: args{outputs: []string{"1080p", "720p:libx264", "audio"}},
+			want: []string{"foo-1080p-libx265.mp4", "foo-720p-libx264.mp4", "foo-audio.opus"},
+		},
+		{
+			name:    "invalid preset",
+			args:    args{outputs: []string{"999p"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid codec",
+			args:    args{outputs: []string{"hd:mpeg2"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			err := os.WriteFile("foo.mp4", nil, 0777)
+			require.NoError(t, err)
+			defer cleanUp(t, []string{"foo.mp4"}, nil)
+
+			fi, err := os.Stat("foo.mp4")
+			require.NoError(t, err)
+
+			// execute
+			got, err := reEncodeOutputs(newTestState(), fi, tt.args.outputs, reEncodeOptions{codec: encoderH265, preset: "fast"}, true)
+
+			// assert
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_readConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    config
+		wantErr bool
+	}{
+		{
+			name: "missing config",
+			want: config{},
+		},
+		{
+			name:    "filter presets",
+			content: `{"filterPresets": {"insta": "crop=ih:ih,scale=1080:1080"}}`,
+			want:    config{FilterPresets: map[string]string{"insta": "crop=ih:ih,scale=1080:1080"}},
+		},
+		{
+			name:    "macros",
+			content: `{"macros": {"shrink": "reencode --crf 24", "tidy": ["date-prefix", "rate 3"]}}`,
+			want: config{Macros: map[string]macro{
+				"shrink": {"reencode --crf 24"},
+				"tidy":   {"date-prefix", "rate 3"},
+			}},
+		},
+		{
+			name:    "invalid macro",
+			content: `{"macros": {"shrink": 3}}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid config",
+			content: `{"filterPresets": [}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			path := filepath.Join(t.TempDir(), "config.json")
+			if tt.content != "" {
+				require.NoError(t, os.WriteFile(path, []byte(tt.content), 0644))
+			}
+
+			// execute
+			got, err := readConfig(path)
+
+			// assert
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_getFilterGraph(t *testing.T) {
+	presets := map[string]string{"insta": "crop=ih:ih,scale=1080:1080"}
+
+	type args struct {
+		preset string
+		graph  string
+	}
+	tests := []struct {
+		name      string
+		args      args
+		want      string
+		wantToken string
+		wantErr   bool
+	}{
+		{
+			name:      "preset",
+			args:      args{preset: "insta", graph: "hflip"},
+			want:      "crop=ih:ih,scale=1080:1080",
+			wantToken: "insta",
+		},
+		{
+			name:      "ad hoc graph",
+			args:      args{graph: "hflip"},
+			want:      "hflip",
+			wantToken: "filtered",
+		},
+		{
+			name:    "unknown preset",
+			args:    args{preset: "tiktok"},
+			wantErr: true,
+		},
+		{
+			name:    "nothing to apply",
+			args:    args{},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got, gotToken, err := getFilterGraph(presets, tt.args.preset, tt.args.graph)
+
+			// assert
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantToken, gotToken)
+		})
+	}
+}
+
+func Test_splitPassThrough(t *testing.T) {
+	tests := []struct {
+		name            string
+		args            []string
+		wantFiles       []string
+		wantPassThrough []string
+	}{
+		{
+			name:      "no separator",
+			args:      []string{"a.mp4", "b.mp4"},
+			wantFiles: []string{"a.mp4", "b.mp4"},
+		},
+		{
+			name:            "separator",
+			args:            []string{"a.mp4", "--", "-i", "{in}", "{out}"},
+			wantFiles:       []string{"a.mp4"},
+			wantPassThrough: []string{"-i", "{in}", "{out}"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			gotFiles, gotPassThrough := splitPassThrough(tt.args)
+
+			// assert
+			assert.Equal(t, tt.wantFiles, gotFiles)
+			assert.Equal(t, tt.wantPassThrough, gotPassThrough)
+		})
+	}
+}
+
+func Test_getEachCommand(t *testing.T) {
+	tests := []struct {
+		name       string
+		ffmpegArgs []string
+		want       []string
+		wantErr    bool
+	}{
+		{
+			name:       "placeholders",
+			ffmpegArgs: []string{"-i", "{in}", "-vf", "hue=s=0", "{out}"},
+			want:       []string{"ffmpeg", "-i", "foo bar.mp4", "-vf", "hue=s=0", "foo bar-gray.mp4"},
+		},
+		{
+			name:       "missing output",
+			ffmpegArgs: []string{"-i", "{in}", "-f", "null", "-"},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got, err := getEachCommand(tt.ffmpegArgs, "foo bar.mp4", "foo bar-gray.mp4")
+
+			// assert
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_quoteArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{
+			name: "safe arguments",
+			args: []string{"ffmpeg", "-i", "foo.mp4", "-vf", "scale=-2:720", "out/foo-720p.mp4"},
+			want: "ffmpeg -i foo.mp4 -vf scale=-2:720 out/foo-720p.mp4",
+		},
+		{
+			name: "spaces and shell characters",
+			args: []string{"ffmpeg", "-i", "foo bar.mp4", "-map", "[v]", "$HOME;rm"},
+			want: "ffmpeg -i 'foo bar.mp4' -map '[v]' '$HOME;rm'",
+		},
+		{
+			name: "single quote",
+			args: []string{"ffmpeg", "-i", "it's.mp4"},
+			want: `ffmpeg -i 'it'\''s.mp4'`,
+		},
+		{
+			name: "empty argument",
+			args: []string{"echo", ""},
+			want: "echo ''",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got := quoteArgs(tt.args)
+
+			// assert
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestReEncoder_Args(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(r *ReEncoder)
+		want  []string
+	}{
+		{
+			name: "input options precede the input, output options follow a fixed order",
+			setup: func(r *ReEncoder) {
+				r.
+					Set(audioCodecKey, "copy").
+					Set(crfKey, "23").
+					Set(inputKey, "foo bar.mp4").
+					Set(hwaccelKey, "auto").
+					Set(videoCodecKey, encoderH265).
+					Set("-tag:v", "hvc1").
+					Set(presetKey, "slow")
+			},
+			want: []string{"ffmpeg", "-hwaccel", "auto", "-i", "foo bar.mp4", "-c:v", "libx265", "-crf", "23", "-preset", "slow", "-c:a", "copy", "-tag:v", "hvc1", "out.mp4"},
+		},
+		{
+			name: "deleted options are left out",
+			setup: func(r *ReEncoder) {
+				r.
+					Set(inputKey, "foo.mp4").
+					Set(presetKey, "slow").
+					Set(crfKey, "23").
+					Set(tuneKey, "film").
+					Delete(crfKey).
+					Delete(presetKey).
+					Set(videoCodecKey, encoderVP9)
+			},
+			want: []string{"ffmpeg", "-i", "foo.mp4", "-c:v", "vp9", "-tune", "film", "out.mp4"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			r := NewReEncoder()
+			tt.setup(r)
+
+			// execute
+			got := r.Args("out.mp4")
+
+			// assert
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_checkRoot(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		paths   []string
+		wantErr bool
+	}{
+		{
+			name:  "inside root",
+			paths: []string{filepath.Join(dir, "foo.mp4"), filepath.Join(dir, "bar", "foo.mp4")},
+		},
+		{
+			name:  "dots in file name",
+			paths: []string{filepath.Join(dir, "..foo.mp4")},
+		},
+		{
+			name:    "escaping via parent directory",
+			paths:   []string{filepath.Join(dir, "foo.mp4"), filepath.Join(dir, "bar", "..", "..", "foo.mp4")},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			st := newTestState()
+			st.rootDir = dir
+
+			// execute
+			err := checkRoot(st, tt.paths...)
+
+			// assert
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func Test_replace_root(t *testing.T) {
+	// setup
+	st := newTestState()
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "foo.mp4")
+	require.NoError(t, os.WriteFile(filePath, nil, 0644))
+
+	fi, err := os.Stat(filePath)
+	require.NoError(t, err)
+
+	st.rootDir = dir
+
+	// execute
+	err = replace(st, withPath(fi, filePath), "foo", "../foo", 0, false, false)
+
+	// assert
+	assert.Error(t, err)
+	assert.FileExists(t, filePath)
+}
+
+func Test_safeRename_symlinkRoot(t *testing.T) {
+	// setup
+	st := newTestState()
+	dir := t.TempDir()
+	inside, outside := filepath.Join(dir, "inside"), filepath.Join(dir, "outside")
+	require.NoError(t, os.MkdirAll(inside, 0755))
+	require.NoError(t, os.MkdirAll(outside, 0755))
+
+	target := filepath.Join(outside, "foo.mp4")
+	require.NoError(t, os.WriteFile(target, []byte("foo"), 0644))
+	link := filepath.Join(inside, "foo.mp4")
+	require.NoError(t, os.Symlink(target, link))
+
+	st.rootDir = inside
+	st.followSymlinks = true
+
+	fileInfoList, err := getFileInfoList(st, []string{link}, "", false)
+	require.NoError(t, err)
+	require.Len(t, fileInfoList, 1)
+
+	// execute
+	err = safeRename(st, fileInfoList[0].Name(), filepath.Join(outside, "bar-foo.mp4"), false)
+
+	// assert
+	var rootErr *RootError
+	assert.ErrorAs(t, err, &rootErr)
+	assert.FileExists(t, target)
+	dest, err := os.Readlink(link)
+	require.NoError(t, err)
+	assert.Equal(t, target, dest)
+}
+
+func Test_lockDirs(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "foo.mp4")
+	require.NoError(t, os.WriteFile(filePath, nil, 0644))
+
+	fi, err := os.Stat(filePath)
+	require.NoError(t, err)
+
+	fileInfoList := []os.FileInfo{withPath(fi, filePath)}
+
+	// execute
+	unlock, err := lockDirs(newLogger(true), fileInfoList)
+
+	// assert
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(dir, lockFileName))
+
+	_, err = lockDirs(newLogger(true), fileInfoList)
+	assert.Error(t, err)
+
+	unlock()
+	assert.NoFileExists(t, filepath.Join(dir, lockFileName))
+}
+
+func Test_lockDirs_stale(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "foo.mp4")
+	require.NoError(t, os.WriteFile(filePath, nil, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, lockFileName), []byte("0\n"), 0644))
+
+	fi, err := os.Stat(filePath)
+	require.NoError(t, err)
+
+	// execute
+	unlock, err := lockDirs(newLogger(true), []os.FileInfo{withPath(fi, filePath)})
+
+	// assert
+	require.NoError(t, err)
+	unlock()
+}
+
+func Test_checkFiles(t *testing.T) {
+	// setup
+	st := newTestState()
+	dir := t.TempDir()
+
+	var fileInfoList []os.FileInfo
+	for name, perm := range map[string]os.FileMode{"writable.mp4": 0644, "readonly.mp4": 0444} {
+		filePath := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(filePath, nil, perm))
+
+		fi, err := os.Stat(filePath)
+		require.NoError(t, err)
+
+		fileInfoList = append(fileInfoList, withPath(fi, filePath))
+	}
+
+	// execute
+	got := checkFiles(st, fileInfoList, true, 0)
+
+	// assert
+	require.Len(t, got, 1)
+	assert.Equal(t, filepath.Join(dir, "writable.mp4"), got[0].Name())
+	assert.Len(t, checkFiles(st, fileInfoList, false, 0), 2)
+}
+
+func Test_prepareFiles(t *testing.T) {
+	tests := []struct {
+		name      string
+		command   string
+		fix       bool
+		wantFiles int
+		wantLock  bool
+	}{
+		{name: "renaming commands skip read-only files", command: prefixCommand, wantFiles: 1, wantLock: true},
+		{name: "cleanup-recordings renames its files", command: cleanupRecordingsCommand, wantFiles: 1, wantLock: true},
+		{name: "encoding commands keep read-only files", command: proxyCommand, wantFiles: 2, wantLock: true},
+		{name: "labels are not stored in the files", command: labelAddCommand, wantFiles: 2, wantLock: true},
+		{name: "keyframes is read-only", command: keyFramesCommand, wantFiles: 2},
+		{name: "label list is read-only", command: labelListCommand, wantFiles: 2},
+		{name: "lint-names is read-only without fixing", command: lintNamesCommand, wantFiles: 2},
+		{name: "lint-names fixing names skips read-only files", command: lintNamesCommand, fix: true, wantFiles: 1, wantLock: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			dir := t.TempDir()
+
+			var fileInfoList []os.FileInfo
+			for name, perm := range map[string]os.FileMode{"writable.mp4": 0644, "readonly.mp4": 0444} {
+				filePath := filepath.Join(dir, name)
+				require.NoError(t, os.WriteFile(filePath, nil, perm))
+
+				fi, err := os.Stat(filePath)
+				require.NoError(t, err)
+
+				fileInfoList = append(fileInfoList, withPath(fi, filePath))
+			}
+
+			set := flag.NewFlagSet(tt.command, flag.ContinueOnError)
+			set.Bool(fixFlag, tt.fix, "")
+			c := cli.NewContext(newApp(), set, nil)
+			c.Command = &cli.Command{Name: tt.command}
+
+			// execute
+			got, unlock, err := prepareFiles(newTestState(), c, fileInfoList)
+
+			// assert
+			require.NoError(t, err)
+			defer unlock()
+			assert.Len(t, got, tt.wantFiles)
+			if tt.wantLock {
+				assert.FileExists(t, filepath.Join(dir, lockFileName))
+			} else {
+				assert.NoFileExists(t, filepath.Join(dir, lockFileName))
+			}
+		})
+	}
+}
+
+func Test_getFileInfoList_partial(t *testing.T) {
+	// setup
+	st := newTestState()
+	dir := t.TempDir()
+	for name, content := range map[string]string{"foo.mp4": "foo", "bar.mp4.part": "bar", "baz.mp4": "", "qux.crdownload": "qux"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+
+	st.skipPartial = true
+
+	// execute
+	got, err := getFileInfoList(st, []string{
+		filepath.Join(dir, "foo.mp4"),
+		filepath.Join(dir, "bar.mp4.part"),
+		filepath.Join(dir, "baz.mp4"),
+		filepath.Join(dir, "qux.crdownload"),
+	}, sortName, false)
+
+	// assert
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.Equal(t, filepath.Join(dir, "foo.mp4"), got[0].Name())
+}
+
+func Test_getFileInfoList_missing(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	var filePaths []string
+	for i := 0; i < 100; i++ {
+		filePath := filepath.Join(dir, fmt.Sprintf("%03d.mp4", i))
+		if i%10 != 3 {
+			require.NoError(t, os.WriteFile(filePath, []byte("foo"), 0644))
+		}
+		filePaths = append(filePaths, filePath)
+	}
+
+	tests := []struct {
+		name        string
+		skipMissing bool
+		want        int
+		wantSkipped []string
+		wantErr     string
+	}{
+		{
+			name:    "all problems are counted",
+			wantErr: "invalid: 10, missing: 10",
+		},
+		{
+			name:        "missing files are skipped",
+			skipMissing: true,
+			want:        90,
+			wantSkipped: []string{filePaths[3], filePaths[13], filePaths[23], filePaths[33], filePaths[43], filePaths[53], filePaths[63], filePaths[73], filePaths[83], filePaths[93]},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			st := newTestState()
+			st.skipMissing = tt.skipMissing
+
+			// execute
+			got, err := getFileInfoList(st, filePaths, "", false)
+
+			// assert
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, got, tt.want)
+			assert.Equal(t, filePaths[0], got[0].Name())
+			assert.Equal(t, tt.wantSkipped, st.skippedArgs)
+		})
+	}
+}
+
+func Test_errorKind(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{
+			name: "probe error",
+			err:  &ProbeError{Path: "foo.mp4", Err: errors.New("foo")},
+			want: "probe errors",
+		},
+		{
+			name: "wrapped encode error",
+			err:  fmt.Errorf("bar: %w", &EncodeError{Path: "foo.mp4", Operation: "crop video", Err: errors.New("foo")}),
+			want: "encode errors",
+		},
+		{
+			name: "collision",
+			err:  &RenameCollision{Path: "foo.mp4"},
+			want: "rename collisions",
+		},
+		{
+			name: "other",
+			err:  errors.New("foo"),
+			want: "other errors",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got := errorKind(tt.err)
+
+			// assert
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_failureSummary(t *testing.T) {
+	// setup
+	f := &failureSummary{}
+
+	// execute
+	assert.NoError(t, f.Err())
+	f.Add("a.mp4", &RenameCollision{Path: "b.mp4"})
+	f.Add("c.mp4", &ProbeError{Path: "c.mp4", Err: errors.New("foo")})
+	f.Add("d.mp4", &RenameCollision{Path: "e.mp4"})
+
+	// assert
+	assert.Equal(t, []string{"rename collisions", "probe errors"}, f.kinds)
+	assert.Equal(t, []summaryFailure{{File: "c.mp4", Error: (&ProbeError{Path: "c.mp4", Err: errors.New("foo")}).Error()}}, f.files["probe errors"])
+	assert.Len(t, f.files["rename collisions"], 2)
+	assert.Equal(t, "d.mp4", f.files["rename collisions"][1].File)
+	assert.EqualError(t, f.Err(), "3 file(s) failed")
+}
+
+func Test_failureSummary_Print(t *testing.T) {
+	// setup
+	buf := &strings.Builder{}
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+
+	f := &failureSummary{}
+	f.Add("a.mp4", errors.New("foo failed"))
+
+	// execute
+	f.Print(newTestState())
+
+	// assert
+	assert.Contains(t, buf.String(), "  a.mp4: foo failed\n")
+}
+
+func Test_saveCommandLog(t *testing.T) {
+	// setup
+	st := newTestState()
+	st.commandLogDir = t.TempDir()
+
+	setCommandLog(st, filepath.Join("foo", "bar.mp4"))
+
+	// execute
+	_, err := exec(st, []string{"echo", "hello"})
+	require.NoError(t, err)
+	_, err = exec(st, []string{"ls", "does-not-exist.mp4"})
+	require.Error(t, err)
+
+	// assert
+	data, err := os.ReadFile(filepath.Join(st.commandLogDir, "foo_bar.mp4.log"))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "$ echo hello\nhello\n")
+	assert.Contains(t, string(data), "$ ls does-not-exist.mp4\n")
+	assert.Contains(t, string(data), "exit status")
+}
+
+func Test_shouldUseColor(t *testing.T) {
+	type args struct {
+		mode       string
+		noColor    bool
+		noColorEnv string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    bool
+		wantErr bool
+	}{
+		{
+			name: "always",
+			args: args{mode: colorAlways},
+			want: true,
+		},
+		{
+			name: "no color flag wins",
+			args: args{mode: colorAlways, noColor: true},
+			want: false,
+		},
+		{
+			name: "always ignores NO_COLOR",
+			args: args{mode: colorAlways, noColorEnv: "1"},
+			want: true,
+		},
+		{
+			name: "auto respects NO_COLOR",
+			args: args{mode: colorAuto, noColorEnv: "1"},
+			want: false,
+		},
+		{
+			name: "auto without a terminal",
+			args: args{mode: colorAuto},
+			want: false,
+		},
+		{
+			name:    "invalid mode",
+			args:    args{mode: "sometimes"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			t.Setenv("NO_COLOR", tt.args.noColorEnv)
+
+			f, err := os.CreateTemp(t.TempDir(), "output")
+			require.NoError(t, err)
+			defer f.Close()
+
+			// execute
+			got, err := shouldUseColor(tt.args.mode, tt.args.noColor, f)
+
+			// assert
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_formatUnits(t *testing.T) {
+	type args struct {
+		n         int64
+		system    string
+		precision int
+	}
+	tests := []struct {
+		name string
+		args args
+		want string
+	}{
+		{
+			name: "small number",
+			args: args{n: 999, system: unitsSI, precision: 1},
+			want: "999 B",
+		},
+		{
+			name: "si",
+			args: args{n: 1500000, system: unitsSI, precision: 1},
+			want: "1.5 MB",
+		},
+		{
+			name: "iec",
+			args: args{n: 1536 * 1024, system: unitsIEC, precision: 2},
+			want: "1.50 MiB",
+		},
+		{
+			name: "no decimals",
+			args: args{n: 2500, system: unitsSI, precision: 0},
+			want: "2 KB",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			st := newTestState()
+			st.unitSystem, st.unitPrecision = tt.args.system, tt.args.precision
+
+			// execute
+			got := formatUnits(st, tt.args.n, " ", "B")
+
+			// assert
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_formatDuration(t *testing.T) {
+	tests := []struct {
+		name       string
+		seconds    float64
+		rawSeconds bool
+		want       string
+	}{
+		{
+			name:    "short",
+			seconds: 5.25,
+			want:    "00:00:05.3",
+		},
+		{
+			name:    "long",
+			seconds: 5403.2,
+			want:    "01:30:03.2",
+		},
+		{
+			name:    "rounding up to a minute",
+			seconds: 59.96,
+			want:    "00:01:00.0",
+		},
+		{
+			name:       "raw seconds",
+			seconds:    5403.2,
+			rawSeconds: true,
+			want:       "5403.2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			st := newTestState()
+			st.rawSeconds = tt.rawSeconds
+
+			// execute
+			got := formatDuration(st, tt.seconds)
+
+			// assert
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_videoTypes_Table(t *testing.T) {
+	vs := videoTypes{
+		{name: "foo.mp4", size: 2000, length: 60, codec: "hevc", width: 1920, height: 1080},
+		{name: "bar.mp4", size: 1000, length: 30, codec: "h264", width: 1280, height: 720},
+	}
+
+	tests := []struct {
+		name       string
+		columns    []string
+		wantHeader []interface{}
+		wantLines  [][]interface{}
+		wantErr    assert.ErrorAssertionFunc
+	}{
+		{
+			name:       "selected columns in order",
+			columns:    []string{"codec", "name", "size"},
+			wantHeader: []interface{}{"CODEC", "FILE", "SIZE"},
+			wantLines: [][]interface{}{
+				{"hevc", "foo.mp4", "2.0 KB"},
+				{"h264", "bar.mp4", "1000 B"},
+				{"TOTAL", "", "3.0 KB"},
+			},
+			wantErr: assert.NoError,
+		},
+		{
+			name:       "totals without size",
+			columns:    []string{"name", "width", "length"},
+			wantHeader: []interface{}{"FILE", "WIDTH", "LENGTH"},
+			wantLines: [][]interface{}{
+				{"foo.mp4", "1920", "00:01:00.0"},
+				{"bar.mp4", "1280", "00:00:30.0"},
+				{"TOTAL", "", "00:01:30.0"},
+			},
+			wantErr: assert.NoError,
+		},
+		{
+			name:    "unknown column",
+			columns: []string{"name", "fps"},
+			wantErr: assert.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			header, lines, err := vs.Table(newTestState(), tt.columns, true, 100)
+
+			// assert
+			tt.wantErr(t, err)
+			assert.Equal(t, tt.wantHeader, header)
+			assert.Equal(t, tt.wantLines, lines)
+		})
+	}
+}
+
+func Test_fitColumn(t *testing.T) {
+	lines := [][]interface{}{
+		{"FILE", "SIZE", "CODEC"},
+		{"a-very-long-file-name-to-truncate.mp4", "2.0 KB", "hevc"},
+	}
+
+	tests := []struct {
+		name  string
+		width int
+		want  int
+	}{
+		{
+			name:  "wide terminal",
+			width: 80,
+			want:  65,
+		},
+		{
+			name:  "narrow terminal",
+			width: 30,
+			want:  minNameLength,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got := fitColumn(lines, 0, tt.width)
+
+			// assert
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_expandBraces(t *testing.T) {
+	tests := []struct {
+		name string
+		arg  string
+		want []string
+	}{
+		{
+			name: "no braces",
+			arg:  "foo.mp4",
+			want: []string{"foo.mp4"},
+		},
+		{
+			name: "list",
+			arg:  "{foo,bar}.mp4",
+			want: []string{"foo.mp4", "bar.mp4"},
+		},
+		{
+			name: "zero padded range",
+			arg:  "ep{08..11}.mkv",
+			want: []string{"ep08.mkv", "ep09.mkv", "ep10.mkv", "ep11.mkv"},
+		},
+		{
+			name: "backwards range",
+			arg:  "ep{3..1}.mkv",
+			want: []string{"ep3.mkv", "ep2.mkv", "ep1.mkv"},
+		},
+		{
+			name: "multiple expressions",
+			arg:  "s{1,2}e{1..2}.mkv",
+			want: []string{"s1e1.mkv", "s1e2.mkv", "s2e1.mkv", "s2e2.mkv"},
+		},
+		{
+			name: "nested list",
+			arg:  "{a,b{1,2}}.mp4",
+			want: []string{"a.mp4", "b1.mp4", "b2.mp4"},
+		},
+		{
+			name: "not an expression",
+			arg:  "{foo}-{1,2}.mp4",
+			want: []string{"{foo}-1.mp4", "{foo}-2.mp4"},
+		},
+		{
+			name: "unclosed brace",
+			arg:  "foo{1,2.mp4",
+			want: []string{"foo{1,2.mp4"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got := expandBraces(tt.arg)
+
+			// assert
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_expandArgs(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	for _, name := range []string{"a.mp4", "b.mp4", "c.mkv", "{x}.mp4"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0644))
+	}
+
+	// execute
+	got := expandArgs(newLogger(true), []string{
+		filepath.Join(dir, "*.mp4"),
+		filepath.Join(dir, "{c,d}.mkv"),
+		filepath.Join(dir, "{x}.mp4"),
+	})
+
+	// assert
+	assert.Equal(t, []string{
+		filepath.Join(dir, "a.mp4"),
+		filepath.Join(dir, "b.mp4"),
+		filepath.Join(dir, "{x}.mp4"),
+		filepath.Join(dir, "c.mkv"),
+		filepath.Join(dir, "d.mkv"),
+		filepath.Join(dir, "{x}.mp4"),
+	}, got)
+}
+
+func Test_reorderArgs(t *testing.T) {
+	app := &cli.App{
+		Flags: []cli.Flag{
+			&cli.BoolFlag{Name: "dryRun", Aliases: []string{"d"}},
+		},
+		Commands: []*cli.Command{
+			{
+				Name:    "reencode",
+				Aliases: []string{"re"},
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "codec"},
+					&cli.BoolFlag{Name: "hw"},
+				},
+			},
+			{
+				Name: "label",
+				Subcommands: []*cli.Command{
+					{Name: "add", Flags: []cli.Flag{&cli.IntFlag{Name: "max"}}},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{
+			name: "flags after files",
+			args: []string{"ffr", "reencode", "a.mp4", "--codec", "libx265", "b.mp4", "--hw"},
+			want: []string{"ffr", "reencode", "--codec", "libx265", "--hw", "a.mp4", "b.mp4"},
+		},
+		{
+			name: "global flag after the command",
+			args: []string{"ffr", "re", "a.mp4", "-d", "--codec=libx265"},
+			want: []string{"ffr", "-d", "re", "--codec=libx265", "a.mp4"},
+		},
+		{
+			name: "subcommand",
+			args: []string{"ffr", "label", "add", "todo", "a.mp4", "--max", "3"},
+			want: []string{"ffr", "label", "add", "--max", "3", "todo", "a.mp4"},
+		},
+		{
+			name: "pass through arguments are kept",
+			args: []string{"ffr", "reencode", "a.mp4", "--", "--codec", "x"},
+			want: []string{"ffr", "reencode", "a.mp4", "--", "--codec", "x"},
+		},
+		{
+			name: "unknown flags are kept in place",
+			args: []string{"ffr", "reencode", "a.mp4", "-5"},
+			want: []string{"ffr", "reencode", "a.mp4", "-5"},
+		},
+		{
+			name: "unknown command",
+			args: []string{"ffr", "foo", "a.mp4", "-d"},
+			want: []string{"ffr", "foo", "a.mp4", "-d"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got := reorderArgs(app, tt.args)
+
+			// assert
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_recordInvocation(t *testing.T) {
+	// setup
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	invocation = []string{"ffr", "-d", "each", "--tag", "x", "a.mp4", "b.mp4", "--", "-vf", "hflip"}
+	defer func() { invocation = nil }()
+
+	// execute
+	recordInvocation(newLogger(true), path, []string{"a.mp4", "b.mp4", "--", "-vf", "hflip"}, 0)
+	entries, err := readCommandHistory(path)
+
+	// assert
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, []string{"-d", "each", "--tag", "x"}, entries[0].Args)
+	assert.Equal(t, []string{"a.mp4", "b.mp4"}, entries[0].Files)
+	assert.Equal(t, []string{"-vf", "hflip"}, entries[0].PassThrough)
+	assert.Equal(t, []string{"-d", "each", "--tag", "x", "c.mp4", "--", "-vf", "hflip"}, entries[0].repeatArgs([]string{"c.mp4"}))
+}
+
+func Test_writeResume(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	path := filepath.Join(dir, "resume.json")
+	invocation = []string{"ffr", "--time-budget", "4h", "reencode", "--crf", "28", "a.mp4", "b.mp4", "c.mp4"}
+	defer func() { invocation = nil }()
+
+	remaining := []os.FileInfo{pathFileInfo{path: "b.mp4"}, pathFileInfo{path: "c.mp4"}}
+
+	// execute
+	err := writeResume(path, []string{"a.mp4", "b.mp4", "c.mp4"}, 0, remaining)
+	require.NoError(t, err)
+	entry, err := readResume(path)
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, []string{"--time-budget", "4h", "reencode", "--crf", "28", "b.mp4", "c.mp4"}, entry.repeatArgs(nil))
+}
+
+func Test_readResume_missing(t *testing.T) {
+	// execute
+	_, err := readResume(filepath.Join(t.TempDir(), "resume.json"))
+
+	// assert
+	assert.Error(t, err)
+}
+
+func Test_resume(t *testing.T) {
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		file         string
+		wantErr      bool
+		wantManifest bool
+	}{
+		{name: "removes the manifest after the run", file: "a-b.txt"},
+		{name: "keeps the manifest if the run fails", file: "missing.txt", wantErr: true, wantManifest: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			dir := t.TempDir()
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "a-b.txt"), []byte("foo"), 0644))
+
+			path := filepath.Join(dir, "resume.json")
+			entry := invocationEntry{
+				Dir:   dir,
+				Args:  []string{"--" + commandHistoryFlag + "=", "--" + journalFlag + "=", prefixCommand, "zz"},
+				Files: []string{tt.file},
+			}
+			data, err := json.Marshal(entry)
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(path, data, 0644))
+
+			defer func() { require.NoError(t, os.Chdir(wd)) }()
+			defer func() { invocation = nil }()
+
+			app := newApp()
+
+			// execute
+			err = app.Run([]string{"ffr", "--" + resumeManifestFlag, path, "--" + commandHistoryFlag + "=", "--" + journalFlag + "=", resumeCommand})
+
+			// assert
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			if tt.wantManifest {
+				assert.FileExists(t, path)
+			} else {
+				assert.NoFileExists(t, path)
+			}
+		})
+	}
+}
+
+func Test_parseScheduleWindow(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule string
+		want     *scheduleWindow
+		wantErr  bool
+	}{
+		{
+			name:     "no schedule",
+			schedule: "",
+			want:     nil,
+		},
+		{
+			name:     "same day",
+			schedule: "09:30-17:00",
+			want:     &scheduleWindow{start: 570, end: 1020},
+		},
+		{
+			name:     "over midnight",
+			schedule: "22:00-06:00",
+			want:     &scheduleWindow{start: 1320, end: 360},
+		},
+		{
+			name:     "missing end",
+			schedule: "22:00",
+			wantErr:  true,
+		},
+		{
+			name:     "invalid time",
+			schedule: "22:00-25:00",
+			wantErr:  true,
+		},
+		{
+			name:     "empty window",
+			schedule: "22:00-22:00",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got, err := parseScheduleWindow(tt.schedule)
+
+			// assert
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_scheduleWindow(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 3, 1, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name          string
+		window        scheduleWindow
+		now           time.Time
+		wantContains  bool
+		wantUntilOpen time.Duration
+	}{
+		{
+			name:         "inside of a same day window",
+			window:       scheduleWindow{start: 9 * 60, end: 17 * 60},
+			now:          at(12, 0),
+			wantContains: true,
+		},
+		{
+			name:          "before a same day window",
+			window:        scheduleWindow{start: 9 * 60, end: 17 * 60},
+			now:           at(8, 30),
+			wantUntilOpen: 30 * time.Minute,
+		},
+		{
+			name:          "end of a same day window is excluded",
+			window:        scheduleWindow{start: 9 * 60, end: 17 * 60},
+			now:           at(17, 0),
+			wantUntilOpen: 16 * time.Hour,
+		},
+		{
+			name:         "inside of a window over midnight, before midnight",
+			window:       scheduleWindow{start: 22 * 60, end: 6 * 60},
+			now:          at(23, 0),
+			wantContains: true,
+		},
+		{
+			name:         "inside of a window over midnight, after midnight",
+			window:       scheduleWindow{start: 22 * 60, end: 6 * 60},
+			now:          at(5, 59),
+			wantContains: true,
+		},
+		{
+			name:          "outside of a window over midnight",
+			window:        scheduleWindow{start: 22 * 60, end: 6 * 60},
+			now:           at(12, 0),
+			wantUntilOpen: 10 * time.Hour,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			gotContains := tt.window.contains(tt.now)
+
+			// assert
+			assert.Equal(t, tt.wantContains, gotContains)
+			if !tt.wantContains {
+				assert.Equal(t, tt.wantUntilOpen, tt.window.untilOpen(tt.now))
+			}
+		})
+	}
+}
+
+func Test_pickInvocation(t *testing.T) {
+	entries := []invocationEntry{
+		{Args: []string{"prefix", "x"}, Files: []string{"a.mp4"}},
+		{Args: []string{"suffix", "y"}, Files: []string{"b.mp4"}},
+	}
+
+	tests := []struct {
+		name    string
+		input   string
+		want    invocationEntry
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{
+			name:    "most recent first",
+			input:   "1\n",
+			want:    entries[1],
+			wantErr: assert.NoError,
+		},
+		{
+			name:    "older entry",
+			input:   "2\n",
+			want:    entries[0],
+			wantErr: assert.NoError,
+		},
+		{
+			name:    "out of range",
+			input:   "3\n",
+			wantErr: assert.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got, err := pickInvocation(entries, strings.NewReader(tt.input))
+
+			// assert
+			tt.wantErr(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_expandMacro(t *testing.T) {
+	// setup
+	path := filepath.Join(t.TempDir(), "config.json")
+	content := `{"macros": {"shrink": "reencode --crf 24 --tag 'small file'", "tidy": ["date-prefix", "rate 3"], "info": "stats"}}`
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+
+	app := &cli.App{
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "config"},
+			&cli.BoolFlag{Name: "dryRun", Aliases: []string{"d"}},
+		},
+		Commands: []*cli.Command{
+			{Name: "info"},
+		},
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want [][]string
+	}{
+		{
+			name: "single command",
+			args: []string{"ffr", "--config", path, "shrink", "a.mp4"},
+			want: [][]string{{"ffr", "--config", path, "reencode", "--crf", "24", "--tag", "small file", "a.mp4"}},
+		},
+		{
+			name: "chained commands",
+			args: []string{"ffr", "-d", "--config=" + path, "tidy", "a.mp4", "b.mp4"},
+			want: [][]string{
+				{"ffr", "-d", "--config=" + path, "date-prefix", "a.mp4", "b.mp4"},
+				{"ffr", "-d", "--config=" + path, "rate", "3", "a.mp4", "b.mp4"},
+			},
+		},
+		{
+			name: "built-in commands are not overridden",
+			args: []string{"ffr", "--config", path, "info", "a.mp4"},
+			want: [][]string{{"ffr", "--config", path, "info", "a.mp4"}},
+		},
+		{
+			name: "unknown command",
+			args: []string{"ffr", "--config", path, "foo", "a.mp4"},
+			want: [][]string{{"ffr", "--config", path, "foo", "a.mp4"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got, err := expandMacro(app, tt.args)
+
+			// assert
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_followChanges(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	renamed := filepath.Join(dir, "2023.12.29-a.mp4")
+	require.NoError(t, os.WriteFile(renamed, nil, 0644))
+
+	pairs := []renamePair{
+		{oldPath: filepath.Join(dir, "a.mp4"), newPath: renamed},
+		{oldPath: filepath.Join(dir, "b.mp4"), newPath: filepath.Join(dir, "planned-b.mp4")},
+	}
+
+	// execute
+	got := followChanges([]string{"ffr", "rate", "3", filepath.Join(dir, "a.mp4"), filepath.Join(dir, "b.mp4")}, pairs)
+
+	// assert
+	assert.Equal(t, []string{"ffr", "rate", "3", renamed, filepath.Join(dir, "b.mp4")}, got)
+}
+
+func Test_newFileResult(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	oldPath := filepath.Join(dir, "a.mp4")
+	newPath := filepath.Join(dir, "a.webm")
+	require.NoError(t, os.WriteFile(oldPath, make([]byte, 100), 0644))
+	require.NoError(t, os.WriteFile(newPath, make([]byte, 40), 0644))
+	fi, err := os.Stat(oldPath)
+	require.NoError(t, err)
+	fi = withPath(fi, oldPath)
+
+	tests := []struct {
+		name        string
+		fileChanges []renamePair
+		err         error
+		dryRun      bool
+		want        fileResult
+	}{
+		{
+			name:        "converted",
+			fileChanges: []renamePair{{oldPath: oldPath, newPath: newPath}},
+			want:        fileResult{OldPath: oldPath, NewPath: newPath, Status: resultOK, Duration: 2, SizeDelta: -60},
+		},
+		{
+			name:        "planned",
+			fileChanges: []renamePair{{oldPath: oldPath, newPath: newPath}},
+			dryRun:      true,
+			want:        fileResult{OldPath: oldPath, NewPath: newPath, Status: resultPlanned, Duration: 2},
+		},
+		{
+			name: "unchanged",
+			want: fileResult{OldPath: oldPath, Status: resultUnchanged, Duration: 2},
+		},
+		{
+			name: "failed",
+			err:  &RenameCollision{Path: newPath},
+			want: fileResult{OldPath: oldPath, Status: resultFailed, Error: (&RenameCollision{Path: newPath}).Error(), Duration: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got := newFileResult(fi, tt.fileChanges, 2*time.Second, tt.err, tt.dryRun)
+
+			// assert
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// useFakeRunner replaces the command runner with a fake one for the duration of the test
+func useFakeRunner(t *testing.T, respond func(args []string) (string, error)) *FakeRunner {
+	fake := &FakeRunner{Respond: respond}
+
+	runner = fake
+	t.Cleanup(func() {
+		runner = ExecRunner{}
+	})
+
+	return fake
+}
+
+func Test_parseIOLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    int64
+		wantErr bool
+	}{
+		{name: "no limit", spec: "", want: 0},
+		{name: "per second", spec: "50MB/s", want: 50_000_000},
+		{name: "binary units", spec: "10MiB", want: 10 << 20},
+		{name: "invalid", spec: "fast", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got, err := parseIOLimit(tt.spec)
+
+			// assert
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_limitedReader(t *testing.T) {
+	// setup
+	r := newLimitedReader(strings.NewReader(strings.Repeat("x", 200)), 1000)
+
+	// execute
+	t0 := time.Now()
+	data, err := io.ReadAll(r)
+	elapsed := time.Since(t0)
+
+	// assert
+	require.NoError(t, err)
+	assert.Len(t, data, 200)
+	assert.GreaterOrEqual(t, elapsed, 190*time.Millisecond)
+}
+
+func Test_withReadRate(t *testing.T) {
+	// setup
+	st := newTestState()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "foo.mp4")
+	err := os.WriteFile(path, make([]byte, 1000), 0777)
+	require.NoError(t, err)
+
+	useFakeRunner(t, func(args []string) (string, error) {
+		return "10.0", nil
+	})
+	st.ioLimit = 200
+
+	// execute
+	got := withReadRate(st, []string{"ffmpeg", "-i", path, "-i", "anullsrc", "out.mp4"})
+
+	// assert
+	assert.Equal(t, []string{"ffmpeg", "-readrate", "1.000", "-i", path, "-i", "anullsrc", "out.mp4"}, got)
+	assert.Equal(t, got, withReadRate(st, got))
+}
+
+func Test_showCommand_readRate(t *testing.T) {
+	// setup
+	st := newTestState()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "foo.mp4")
+	err := os.WriteFile(path, make([]byte, 1000), 0777)
+	require.NoError(t, err)
+
+	useFakeRunner(t, func(args []string) (string, error) {
+		return "10.0", nil
+	})
+	st.ioLimit = 100
+
+	// execute
+	got := showCommand(st, []string{"ffmpeg", "-i", path, "out.mp4"})
+
+	// assert
+	assert.Equal(t, []string{"ffmpeg", "-readrate", "1.000", "-i", path, "out.mp4"}, got)
+	assert.Contains(t, st.log.History(), "command: "+quoteArgs(got))
+}
+
+func Test_isTransientIOError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "no error", err: nil, want: false},
+		{name: "stale file handle", err: &os.PathError{Op: "open", Path: "a.mp4", Err: syscall.ESTALE}, want: true},
+		{name: "wrapped timeout", err: fmt.Errorf("failed: %w", syscall.ETIMEDOUT), want: true},
+		{name: "missing file", err: &os.PathError{Op: "open", Path: "a.mp4", Err: syscall.ENOENT}, want: false},
+		{name: "other error", err: errors.New("exit status 1"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isTransientIOError(tt.err))
+		})
+	}
+}
+
+func Test_classifyExitError(t *testing.T) {
+	exitErr := osexec.Command("false").Run()
+	require.Error(t, exitErr)
+
+	tests := []struct {
+		name          string
+		err           error
+		output        string
+		wantTransient bool
+	}{
+		{name: "stale file handle", err: exitErr, output: "foo.mp4: Stale file handle", wantTransient: true},
+		{name: "input/output error", err: exitErr, output: "av_interleaved_write_frame(): Input/output error", wantTransient: true},
+		{name: "invalid data", err: exitErr, output: "foo.mp4: Invalid data found when processing input"},
+		{name: "not an exit error", err: errors.New("failed"), output: "Input/output error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got := classifyExitError(tt.err, tt.output)
+
+			// assert
+			assert.ErrorIs(t, got, tt.err)
+			assert.Equal(t, tt.wantTransient, isTransientIOError(got))
+		})
+	}
+}
+
+func Test_process_retriesTransientFFmpegErrors(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "foo.mp4")
+	require.NoError(t, os.WriteFile(filePath, []byte("foo"), 0644))
+
+	exitErr := osexec.Command("false").Run()
+	require.Error(t, exitErr)
+
+	delay := ioRetryDelay
+	ioRetryDelay = time.Millisecond
+	defer func() { ioRetryDelay = delay }()
+
+	runs := 0
+	useFakeRunner(t, func(args []string) (string, error) {
+		if args[0] != "ffmpeg" {
+			return "10", nil
+		}
+
+		runs++
+		if runs == 1 {
+			// a partial output is left behind by the failed run
+			require.NoError(t, os.WriteFile(args[len(args)-1], []byte("partial"), 0644))
+
+			return "foo.mp4: Stale file handle", exitErr
+		}
+
+		return "", nil
+	})
+
+	app := newApp()
+
+	// execute
+	err := app.Run([]string{"ffr", "--" + rootFlag, dir, "--" + commandHistoryFlag + "=", "--" + journalFlag + "=", "--" + ioRetriesFlag, "1", proxyCommand, filePath})
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, 2, runs)
+}
+
+func Test_moveFile(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	oldPath := filepath.Join(dir, "a.mp4")
+	newPath := filepath.Join(dir, "b.mp4")
+	require.NoError(t, os.WriteFile(oldPath, []byte("foo"), 0o600))
+
+	// execute
+	err := moveFile(newTestState(), oldPath, newPath)
+
+	// assert
+	require.NoError(t, err)
+	assert.NoFileExists(t, oldPath)
+	content, err := os.ReadFile(newPath)
+	require.NoError(t, err)
+	assert.Equal(t, "foo", string(content))
+}
+
+func Test_moveFile_acrossMounts(t *testing.T) {
+	// setup
+	otherDir, err := os.MkdirTemp("/dev/shm", "ffr")
+	if err != nil {
+		t.Skip("no other mount available")
+	}
+	defer os.RemoveAll(otherDir)
+
+	dir := t.TempDir()
+	if mountOf(t, dir) == mountOf(t, otherDir) {
+		t.Skip("no other mount available")
+	}
+
+	oldPath := filepath.Join(dir, "a.mp4")
+	newPath := filepath.Join(otherDir, "a.mp4")
+	require.NoError(t, os.WriteFile(oldPath, []byte("foo"), 0o600))
+
+	// execute
+	err = moveFile(newTestState(), oldPath, newPath)
+
+	// assert
+	require.NoError(t, err)
+	assert.NoFileExists(t, oldPath)
+	content, err := os.ReadFile(newPath)
+	require.NoError(t, err)
+	assert.Equal(t, "foo", string(content))
+}
+
+func mountOf(t *testing.T, path string) uint64 {
+	t.Helper()
+
+	var stat syscall.Stat_t
+	require.NoError(t, syscall.Stat(path, &stat))
+
+	return uint64(stat.Dev)
+}
+
+func Test_checkMounts(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	fileInfoList := []os.FileInfo{
+		pathFileInfo{path: filepath.Join(dir, "a.mp4")},
+		pathFileInfo{path: filepath.Join(dir, "b.mp4")},
+	}
+
+	// execute
+	err := checkMounts(fileInfoList)
+
+	// assert
+	assert.NoError(t, err)
+}
+
+func Test_checkMounts_missing(t *testing.T) {
+	// setup
+	fileInfoList := []os.FileInfo{pathFileInfo{path: filepath.Join(t.TempDir(), "missing", "a.mp4")}}
+
+	// execute
+	err := checkMounts(fileInfoList)
+
+	// assert
+	assert.Error(t, err)
+}
+
+func Test_existingDir(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+
+	// execute
+	got := existingDir(filepath.Join(dir, "planned", "sub", "a.mp4"))
+
+	// assert
+	assert.Equal(t, dir, got)
+}
+
+func Test_workspace(t *testing.T) {
+	// setup
+	base := t.TempDir()
+	w := newWorkspace(base)
+
+	// execute
+	f, err := w.CreateTemp("", "frames-*.txt")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	dir, err := w.MkdirTemp("", "pass-")
+	require.NoError(t, err)
+
+	// assert
+	assert.Equal(t, filepath.Dir(f.Name()), filepath.Dir(dir))
+	assert.Regexp(t, tempRegexp, filepath.Base(filepath.Dir(dir)))
+	assert.Equal(t, base, filepath.Dir(filepath.Dir(dir)))
+
+	require.NoError(t, w.Cleanup())
+	assert.NoDirExists(t, filepath.Dir(dir))
+}
+
+func Test_sweepTemp(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	orphaned := filepath.Join(dir, ".ffr-99999999-123"+tempSuffix)
+	own := filepath.Join(dir, fmt.Sprintf(".ffr-%d-123%s", os.Getpid(), tempSuffix))
+	other := filepath.Join(dir, ".ffr-99999999-123")
+	for _, path := range []string{orphaned, own, other} {
+		require.NoError(t, os.Mkdir(path, 0755))
+	}
+
+	// execute
+	sweepTemp(newLogger(true), dir, dir)
+
+	// assert
+	assert.NoDirExists(t, orphaned)
+	assert.DirExists(t, own)
+	assert.DirExists(t, other)
+}
+
+func Test_process_dryRunKeepsTemp(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "a-b.txt")
+	require.NoError(t, os.WriteFile(filePath, []byte("foo"), 0644))
+	orphaned := filepath.Join(dir, ".ffr-99999999-123"+tempSuffix)
+	require.NoError(t, os.Mkdir(orphaned, 0755))
+
+	app := newApp()
+
+	// execute
+	err := app.Run([]string{"ffr", "--" + rootFlag, dir, "--" + commandHistoryFlag + "=", "--" + journalFlag + "=", "--" + dryRunFlag, prefixCommand, "zz", filePath})
+
+	// assert
+	require.NoError(t, err)
+	assert.DirExists(t, orphaned)
+	assert.FileExists(t, filePath)
+}
+
+func Test_crop_fakeRunner(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "foo bar.mp4")
+	require.NoError(t, os.WriteFile(filePath, nil, 0644))
+	fi, err := os.Stat(filePath)
+	require.NoError(t, err)
+
+	fake := useFakeRunner(t, func(args []string) (string, error) {
+		if args[0] == "ffprobe" {
+			return `{"streams": [{"width": 320, "height": 240}]}`, nil
+		}
+
+		return "", nil
+	})
+
+	// execute
+	err = crop(newTestState(), withPath(fi, filePath), 120, 80, "center", "center", "", false, false)
+
+	// assert
+	require.NoError(t, err)
+	require.Len(t, fake.Commands, 2)
+	assert.Equal(t, []string{"ffprobe", "-v", "error", "-select_streams", "v:0", "-show_entries", "stream=width,height:stream_tags=rotate:stream_side_data=rotation", "-of", "json", filePath}, fake.Commands[0])
+	assert.Equal(t, []string{"ffmpeg", "-i", filePath, "-filter:v", "crop=120:80:100:80", filepath.Join(dir, "foo bar-120x80.mp4")}, fake.Commands[1])
+}
+
+func Test_findKeyFrames_fakeRunner(t *testing.T) {
+	// setup
+	fake := useFakeRunner(t, func(args []string) (string, error) {
+		return "0.000000,K__\n0.033333,___\n8.333333,K__\n", nil
+	})
+
+	// execute
+	got, err := findKeyFrames(newTestState(), pathFileInfo{path: "foo.mp4"})
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, []string{"0.0", "8.3"}, got)
+	assert.Equal(t, "ffprobe", fake.Commands[0][0])
+	assert.Equal(t, "foo.mp4", fake.Commands[0][len(fake.Commands[0])-1])
+}
+
+func Test_getCodec_fakeRunner(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		err     error
+		want    string
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{
+			name:    "codec found",
+			output:  "hevc\n",
+			want:    "hevc",
+			wantErr: assert.NoError,
+		},
+		{
+			name:    "probe failed",
+			err:     errors.New("exit status 1"),
+			wantErr: assert.Error,
+		},
+		{
+			name:   "no video stream",
+			output: "\n",
+			wantErr: func(t assert.TestingT, err error, _ ...interface{}) bool {
+				return assert.ErrorIs(t, err, ErrNoVideoStream)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			useFakeRunner(t, func(args []string) (string, error) {
+				return tt.output, tt.err
+			})
+
+			// execute
+			got, err := getCodec(newTestState(), pathFileInfo{path: "foo.mp4"})
+
+			// assert
+			tt.wantErr(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_parseFrameRate(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    float64
+		wantErr bool
+	}{
+		{
+			name: "fraction",
+			raw:  "30/1\n",
+			want: 30,
+		},
+		{
+			name: "ntsc",
+			raw:  "30000/1001",
+			want: 30000.0 / 1001,
+		},
+		{
+			name: "no denominator",
+			raw:  "25",
+			want: 25,
+		},
+		{
+			name: "unknown",
+			raw:  "0/0",
+			want: 0,
+		},
+		{
+			name:    "invalid",
+			raw:     "N/A",
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			raw:     "",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got, err := parseFrameRate(tt.raw)
+
+			// assert
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_isVariableFrameRate(t *testing.T) {
+	tests := []struct {
+		name     string
+		realRate float64
+		avgRate  float64
+		want     bool
+	}{
+		{
+			name:     "constant",
+			realRate: 30000.0 / 1001,
+			avgRate:  30000.0 / 1001,
+			want:     false,
+		},
+		{
+			name:     "rounding difference",
+			realRate: 30000.0 / 1001,
+			avgRate:  29.97,
+			want:     false,
+		},
+		{
+			name:     "phone recording",
+			realRate: 30,
+			avgRate:  29.53,
+			want:     true,
+		},
+		{
+			name:     "unknown average",
+			realRate: 30,
+			avgRate:  0,
+			want:     false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got := isVariableFrameRate(tt.realRate, tt.avgRate)
+
+			// assert
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_info_variableFrameRate(t *testing.T) {
+	// setup
+	st := newTestState()
+	filePath := filepath.Join(t.TempDir(), "foo.mp4")
+	require.NoError(t, os.WriteFile(filePath, nil, 0644))
+	fi, err := os.Stat(filePath)
+	require.NoError(t, err)
+
+	useFakeRunner(t, func(args []string) (string, error) {
+		switch {
+		case containsString(args, "stream=r_frame_rate,avg_frame_rate"):
+			return "30/1\n8859/300\n", nil
+		case containsString(args, "stream=codec_name"):
+			return "h264\n", nil
+		}
+
+		return "", nil
+	})
+
+	// execute
+	got := info(st, withPath(fi, filePath), true)
+
+	// assert
+	assert.True(t, got.vfr)
+	assert.Equal(t, float64(30), got.frameRate)
+	assert.Equal(t, "30 VFR", got.column(st, "framerate", true, 0))
+}
+
+func Test_getFrameRate_fakeRunner(t *testing.T) {
+	tests := []struct {
+		name    string
+		stream  int
+		output  string
+		want    float64
+		wantErr error
+	}{
+		{
+			name:   "real frame rate",
+			output: "30/1\n30/1\n",
+			want:   30,
+		},
+		{
+			name:   "unknown real frame rate falls back to average",
+			output: "0/0\n25/1\n",
+			want:   25,
+		},
+		{
+			name:   "unknown frame rate",
+			output: "0/0\n0/0\n",
+			want:   0,
+		},
+		{
+			name:    "no video stream",
+			stream:  1,
+			output:  "",
+			wantErr: ErrNoVideoStream,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			st := newTestState()
+			st.videoStream = tt.stream
+
+			fake := useFakeRunner(t, func(args []string) (string, error) {
+				return tt.output, nil
+			})
+
+			// execute
+			got, err := getFrameRate(st, pathFileInfo{path: "foo.mp4"})
+
+			// assert
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.want, got)
+			assert.Contains(t, fake.Commands[0], fmt.Sprintf("v:%d", tt.stream))
+		})
+	}
+}
+
+func Test_info_audioOnly(t *testing.T) {
+	// setup
+	filePath := filepath.Join(t.TempDir(), "foo.opus")
+	require.NoError(t, os.WriteFile(filePath, nil, 0644))
+	fi, err := os.Stat(filePath)
+	require.NoError(t, err)
+
+	fake := useFakeRunner(t, func(args []string) (string, error) {
+		if containsString(args, "format=duration") {
+			return "12.5\n", nil
+		}
+
+		return "", nil
+	})
+
+	// execute
+	got := info(newTestState(), withPath(fi, filePath), false)
+
+	// assert
+	assert.Equal(t, 12.5, got.length)
+	assert.Equal(t, "", got.codec)
+	assert.Len(t, fake.Commands, 2)
+}
+
+func Test_logger_concurrent(t *testing.T) {
+	// setup
+	lg := newLogger(true)
+	wg := sync.WaitGroup{}
+
+	// execute
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for k := 0; k < 100; k++ {
+				lg.Printf("goroutine: %d, message: %d", i, k)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// assert
+	assert.Len(t, lg.History(), 1000)
+}
+
+func Test_logger_reset(t *testing.T) {
+	// setup
+	lg := newLogger(false)
+	lg.Printf("file found: %q", "foo.mp4")
+
+	// execute
+	lg.reset(true)
+	lg.Printf("file found: %q", "bar.mp4")
+
+	// assert
+	assert.True(t, lg.silent)
+	assert.Equal(t, []string{`file found: "bar.mp4"`}, lg.History())
+}
+
+func Test_logger_WriteHistory(t *testing.T) {
+	// setup
+	path := filepath.Join(t.TempDir(), "log.txt")
+	first := newLogger(true)
+	first.Printf("file found: %q", "foo.mp4")
+	first.Println("done")
+	second := newLogger(false)
+	second.Printf("file found: %q", "bar.mp4")
+
+	// execute
+	require.NoError(t, first.WriteHistory(path, []string{"prefix", "x-", "foo.mp4"}))
+	require.NoError(t, second.WriteHistory(path, []string{"suffix", "-x", "bar.mp4"}))
+	require.NoError(t, second.WriteHistory("", nil))
+
+	// assert
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 5)
+	assert.True(t, strings.HasSuffix(lines[0], " ffr prefix x- foo.mp4"))
+	assert.Equal(t, `file found: "foo.mp4"`, lines[1])
+	assert.Equal(t, "done", lines[2])
+	assert.True(t, strings.HasSuffix(lines[3], " ffr suffix -x bar.mp4"))
+	assert.Equal(t, `file found: "bar.mp4"`, lines[4])
+}
+
+func Test_getColorInfo_fakeRunner(t *testing.T) {
+	tests := []struct {
+		name          string
+		output        string
+		want          colorInfo
+		wantHDRFormat string
+		wantErr       error
+	}{
+		{
+			name:          "sdr",
+			output:        `{"streams": [{"color_primaries": "bt709", "color_transfer": "bt709", "color_space": "bt709"}]}`,
+			want:          colorInfo{primaries: "bt709", transfer: "bt709", space: "bt709"},
+			wantHDRFormat: hdrFormatSDR,
+		},
+		{
+			name:          "hdr10",
+			output:        `{"streams": [{"color_primaries": "bt2020", "color_transfer": "smpte2084", "color_space": "bt2020nc"}]}`,
+			want:          colorInfo{primaries: "bt2020", transfer: "smpte2084", space: "bt2020nc"},
+			wantHDRFormat: hdrFormatHDR10,
+		},
+		{
+			name:          "hlg",
+			output:        `{"streams": [{"color_primaries": "bt2020", "color_transfer": "arib-std-b67", "color_space": "bt2020nc"}]}`,
+			want:          colorInfo{primaries: "bt2020", transfer: "arib-std-b67", space: "bt2020nc"},
+			wantHDRFormat: hdrFormatHLG,
+		},
+		{
+			name:          "dolby vision",
+			output:        `{"streams": [{"color_primaries": "bt2020", "color_transfer": "smpte2084", "color_space": "bt2020nc", "side_data_list": [{"side_data_type": "DOVI configuration record"}]}]}`,
+			want:          colorInfo{primaries: "bt2020", transfer: "smpte2084", space: "bt2020nc", dolbyVision: true},
+			wantHDRFormat: hdrFormatDolbyVision,
+		},
+		{
+			name:    "no video stream",
+			output:  `{"streams": []}`,
+			wantErr: ErrNoVideoStream,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			useFakeRunner(t, func(args []string) (string, error) {
+				return tt.output, nil
+			})
+
+			// execute
+			got, err := getColorInfo(newTestState(), pathFileInfo{path: "foo.mp4"})
+
+			// assert
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantHDRFormat, got.HDRFormat())
+		})
+	}
+}
+
+func Test_hdrWarning(t *testing.T) {
+	hdr10 := colorInfo{primaries: "bt2020", transfer: transferPQ}
+
+	tests := []struct {
+		name        string
+		ci          colorInfo
+		o           reEncodeOptions
+		wantWarning bool
+	}{
+		{
+			name: "sdr",
+			ci:   colorInfo{primaries: "bt709", transfer: "bt709"},
+			o:    reEncodeOptions{codec: encoderH264, bitDepth: 8},
+		},
+		{
+			name:        "dolby vision",
+			ci:          colorInfo{transfer: transferPQ, dolbyVision: true},
+			o:           reEncodeOptions{codec: encoderH265, bitDepth: 10},
+			wantWarning: true,
+		},
+		{
+			name:        "8-bit output",
+			ci:          colorInfo{transfer: transferHLG},
+			o:           reEncodeOptions{codec: encoderH265, bitDepth: 8},
+			wantWarning: true,
+		},
+		{
+			name:        "hdr10 without mastering display",
+			ci:          hdr10,
+			o:           reEncodeOptions{codec: encoderH265, bitDepth: 10},
+			wantWarning: true,
+		},
+		{
+			name: "hdr10 with mastering display",
+			ci:   hdr10,
+			o:    reEncodeOptions{codec: encoderH265, bitDepth: 10, x265Params: "hdr10=1:master-display=G(13250,34500)B(7500,3000)R(34000,16000)WP(15635,16450)L(10000000,1)"},
+		},
+		{
+			name: "hlg",
+			ci:   colorInfo{transfer: transferHLG},
+			o:    reEncodeOptions{codec: encoderH265, bitDepth: 10},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got := hdrWarning(tt.ci, tt.o)
+
+			// assert
+			assert.Equal(t, tt.wantWarning, got != "", got)
+		})
+	}
+}
+
+func Test_prefixDate_metadata(t *testing.T) {
+	tests := []struct {
+		name         string
+		creationTime string
+		location     *time.Location
+		want         string
+	}{
+		{
+			name:         "creation time is converted to the timezone",
+			creationTime: "2024-01-02T23:30:00.000000Z",
+			location:     time.FixedZone("CET", 3600),
+			want:         "2024.01.03-clip.mp4",
+		},
+		{
+			name:         "creation time in utc",
+			creationTime: "2024-01-02T23:30:00.000000Z",
+			location:     time.UTC,
+			want:         "2024.01.02-clip.mp4",
+		},
+		{
+			name:     "modification time is used without a creation time",
+			location: time.FixedZone("JST", 9*3600),
+			want:     "2024.05.06-clip.mp4",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			st := newTestState()
+			dir := t.TempDir()
+			filePath := filepath.Join(dir, "clip.mp4")
+			require.NoError(t, os.WriteFile(filePath, nil, 0644))
+			mtime := time.Date(2024, 5, 5, 20, 0, 0, 0, time.UTC)
+			require.NoError(t, os.Chtimes(filePath, mtime, mtime))
+			fi, err := os.Stat(filePath)
+			require.NoError(t, err)
+
+			useFakeRunner(t, func(args []string) (string, error) {
+				return tt.creationTime, nil
+			})
+
+			st.dateLocation = tt.location
+
+			// execute
+			err = prefixDate(st, withPath(fi, filePath), dateOptions{metadata: true}, false, false)
+
+			// assert
+			require.NoError(t, err)
+			assert.FileExists(t, filepath.Join(dir, tt.want))
+		})
+	}
+}
+
+func Test_getDimensions_fakeRunner(t *testing.T) {
+	tests := []struct {
+		name           string
+		output         string
+		ignoreRotation bool
+		want           string
+		wantErr        bool
+	}{
+		{
+			name:   "landscape",
+			output: `{"streams": [{"width": 1920, "height": 1080}]}`,
+			want:   "1920x1080",
+		},
+		{
+			name:   "display matrix",
+			output: `{"streams": [{"width": 1920, "height": 1080, "side_data_list": [{"rotation": -90}]}]}`,
+			want:   "1080x1920",
+		},
+		{
+			name:   "rotate tag",
+			output: `{"streams": [{"width": 1920, "height": 1080, "tags": {"rotate": "270"}}]}`,
+			want:   "1080x1920",
+		},
+		{
+			name:   "upside down",
+			output: `{"streams": [{"width": 1920, "height": 1080, "side_data_list": [{"rotation": 180}]}]}`,
+			want:   "1920x1080",
+		},
+		{
+			name:           "rotation ignored",
+			output:         `{"streams": [{"width": 1920, "height": 1080, "side_data_list": [{"rotation": 90}]}]}`,
+			ignoreRotation: true,
+			want:           "1920x1080",
+		},
+		{
+			name:    "no video stream",
+			output:  `{"streams": []}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			st := newTestState()
+			st.ignoreRotation = tt.ignoreRotation
+
+			useFakeRunner(t, func(args []string) (string, error) {
+				return tt.output, nil
+			})
+
+			// execute
+			got, err := getDimensions(st, pathFileInfo{path: "foo.mp4"})
+
+			// assert
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_probe_cache(t *testing.T) {
+	// setup
+	st := newTestState()
+	filePath := filepath.Join(t.TempDir(), "foo.mp4")
+	require.NoError(t, os.WriteFile(filePath, nil, 0644))
+
+	fake := useFakeRunner(t, func(args []string) (string, error) {
+		return "h264\n", nil
+	})
+	args := []string{"ffprobe", "-show_entries", "stream=codec_name", filePath}
+
+	// execute
+	first, err1 := probe(st, args)
+	second, err2 := probe(st, args)
+	require.NoError(t, os.WriteFile(filePath, []byte("changed"), 0644))
+	third, err3 := probe(st, args)
+	_, _ = probe(st, []string{"ffprobe", "-show_entries", "stream=codec_name", filepath.Join(filepath.Dir(filePath), "missing.mp4")})
+	_, _ = probe(st, []string{"ffprobe", "-show_entries", "stream=codec_name", filepath.Join(filepath.Dir(filePath), "missing.mp4")})
+
+	// assert
+	require.NoError(t, err1)
+	require.NoError(t, err2)
+	require.NoError(t, err3)
+	assert.Equal(t, "h264\n", first)
+	assert.Equal(t, first, second)
+	assert.Equal(t, first, third)
+	assert.Len(t, fake.Commands, 4)
+}
+
+func Test_prefetch(t *testing.T) {
+	// setup
+	st := newTestState()
+	dir := t.TempDir()
+	var fileList []os.FileInfo
+	for i := 0; i < 20; i++ {
+		filePath := filepath.Join(dir, fmt.Sprintf("foo%02d.mp4", i))
+		require.NoError(t, os.WriteFile(filePath, nil, 0644))
+		fi, err := os.Stat(filePath)
+		require.NoError(t, err)
+		fileList = append(fileList, withPath(fi, filePath))
+	}
+
+	fake := useFakeRunner(t, func(args []string) (string, error) {
+		return `{"streams": [{"width": 1920, "height": 1080}]}`, nil
+	})
+
+	// execute
+	prefetch(st, fileList, 4, func(fi os.FileInfo) {
+		_, _ = getDimensions(st, fi)
+	})
+	for _, fi := range fileList {
+		got, err := getDimensions(st, fi)
+		require.NoError(t, err)
+		assert.Equal(t, "1920x1080", got)
+	}
+
+	// assert
+	assert.Len(t, fake.Commands, len(fileList))
+}
+
+func Test_hasDimensionToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		filePath string
+		want     bool
+	}{
+		{
+			name:     "raw dimensions",
+			filePath: filepath.Join("2023", "foo-1280x720-bar.mp4"),
+			want:     true,
+		},
+		{
+			name:     "well-known dimensions",
+			filePath: "foo-fullhd-1080p.mp4",
+			want:     true,
+		},
+		{
+			name:     "no dimensions",
+			filePath: "foo-20230101.mp4",
+			want:     false,
+		},
+		{
+			name:     "dimensions in the directory only",
+			filePath: filepath.Join("1920x1080", "foo.mp4"),
+			want:     false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got := hasDimensionToken(tt.filePath)
+
+			// assert
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_insertDimensionsBefore_skipsProbe(t *testing.T) {
+	// setup
+	fake := useFakeRunner(t, nil)
+
+	// execute
+	err := insertDimensionsBefore(newTestState(), pathFileInfo{path: "foo-hd-720p.mp4"}, "", true, false, false, true)
+
+	// assert
+	require.NoError(t, err)
+	assert.Empty(t, fake.Commands)
+}
+
+func Test_hasEquivalentDimensions(t *testing.T) {
+	tests := []struct {
+		name       string
+		filePath   string
+		dimensions string
+		want       bool
+	}{
+		{
+			name:       "full label",
+			filePath:   "foo-fullhd-1080p.mp4",
+			dimensions: "1920x1080",
+			want:       true,
+		},
+		{
+			name:       "resolution only",
+			filePath:   "foo-1080p.mp4",
+			dimensions: "1920x1080",
+			want:       true,
+		},
+		{
+			name:       "name only, different separator and case",
+			filePath:   "Foo_FullHD.mp4",
+			dimensions: "1920x1080",
+			want:       true,
+		},
+		{
+			name:       "raw dimensions",
+			filePath:   "foo-1920x1080.mp4",
+			dimensions: "1920x1080",
+			want:       true,
+		},
+		{
+			name:       "part of a word is not a match",
+			filePath:   "foo-fullhd.mp4",
+			dimensions: "1280x720",
+			want:       false,
+		},
+		{
+			name:       "different resolution",
+			filePath:   "foo-720p.mp4",
+			dimensions: "1920x1080",
+			want:       false,
+		},
+		{
+			name:       "not well-known",
+			filePath:   "foo-1080p.mp4",
+			dimensions: "1440x1080",
+			want:       false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got := hasEquivalentDimensions(tt.filePath, tt.dimensions)
+
+			// assert
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_insertDimensionsBefore_equivalent(t *testing.T) {
+	// setup
+	st := newTestState()
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "foo-1080p.mp4")
+	require.NoError(t, os.WriteFile(filePath, nil, 0644))
+	fi, err := os.Stat(filePath)
+	require.NoError(t, err)
+
+	fake := useFakeRunner(t, func(args []string) (string, error) {
+		return `{"streams": [{"width": 1920, "height": 1080}]}`, nil
+	})
+	st.changes = nil
+
+	// execute
+	err = insertDimensionsBefore(st, withPath(fi, filePath), "", true, false, false, true)
+
+	// assert
+	require.NoError(t, err)
+	assert.Len(t, fake.Commands, 1)
+	assert.Empty(t, st.changes)
+}
+
+func Test_levenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"foo", "", 3},
+		{"kitten", "sitting", 3},
+		{"árvíz", "arviz", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+"-"+tt.b, func(t *testing.T) {
+			// execute
+			got := levenshtein(tt.a, tt.b)
+
+			// assert
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_findSimilarNames(t *testing.T) {
+	tests := []struct {
+		name      string
+		filePaths []string
+		threshold float64
+		want      []similarCluster
+	}{
+		{
+			name:      "duplicates differing in case and separators",
+			filePaths: []string{"Foo Bar.mp4", "other.mp4", "foo_bar.MP4"},
+			threshold: defaultSimilarity,
+			want: []similarCluster{
+				{Kind: similarDuplicate, Canonical: "foo-bar.mp4", Files: []string{"Foo Bar.mp4", "foo_bar.MP4"}},
+			},
+		},
+		{
+			name:      "series",
+			filePaths: []string{"show-e01.mkv", "Show E02.mkv", "show-e03.mkv"},
+			threshold: defaultSimilarity,
+			want: []similarCluster{
+				{Kind: similarSeries, Canonical: "show-e#.mkv", Files: []string{"show-e01.mkv", "Show E02.mkv", "show-e03.mkv"}},
+			},
+		},
+		{
+			name:      "reordered words",
+			filePaths: []string{"holiday in paris.mp4", "paris holiday in.mp4", "rome.mp4"},
+			threshold: defaultSimilarity,
+			want: []similarCluster{
+				{Kind: similarSimilar, Canonical: "holiday-in-paris.mp4", Files: []string{"holiday in paris.mp4", "paris holiday in.mp4"}},
+			},
+		},
+		{
+			name:      "nothing similar",
+			filePaths: []string{"foo.mp4", "bar.mp4"},
+			threshold: defaultSimilarity,
+			want:      nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got := findSimilarNames(tt.filePaths, tt.threshold, true)
+
+			// assert
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_readRenameMapping(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		content string
+		want    []renamePair
+		wantErr string
+	}{
+		{
+			name:    "csv with header",
+			format:  mappingCSV,
+			content: "old,new\nfoo.mp4,bar.mp4\n\"a, b.mp4\",c.mp4\nsame.mp4,same.mp4\n",
+			want:    []renamePair{{oldPath: "foo.mp4", newPath: "bar.mp4"}, {oldPath: "a, b.mp4", newPath: "c.mp4"}},
+		},
+		{
+			name:    "tsv without header",
+			format:  mappingTSV,
+			content: "foo.mp4\tbar.mp4\n",
+			want:    []renamePair{{oldPath: "foo.mp4", newPath: "bar.mp4"}},
+		},
+		{
+			name:    "csv with a missing column",
+			format:  mappingCSV,
+			content: "foo.mp4\n",
+			wantErr: "line: 1",
+		},
+		{
+			name:    "json lines of a dry-run",
+			format:  mappingJSON,
+			content: `{"oldPath":"foo.mp4","newPath":"bar.mp4","status":"planned"}` + "\n" + `{"oldPath":"baz.mp4","status":"failed","error":"no matches"}` + "\n",
+			want:    []renamePair{{oldPath: "foo.mp4", newPath: "bar.mp4"}},
+		},
+		{
+			name:    "json array",
+			format:  mappingJSON,
+			content: `[{"oldPath":"foo.mp4","newPath":"bar.mp4"}]`,
+			want:    []renamePair{{oldPath: "foo.mp4", newPath: "bar.mp4"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got, err := readRenameMapping(strings.NewReader(tt.content), tt.format)
+
+			// assert
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_orderRenameMapping(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	for _, name := range []string{"a.mp4", "b.mp4", "c.mp4", "taken.mp4"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0644))
+	}
+	path := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+
+	tests := []struct {
+		name    string
+		pairs   []renamePair
+		want    []renamePair
+		wantErr []string
+	}{
+		{
+			name: "chain is renamed from the end",
+			pairs: []renamePair{
+				{oldPath: path("a.mp4"), newPath: path("b.mp4")},
+				{oldPath: path("b.mp4"), newPath: path("c.mp4")},
+				{oldPath: path("c.mp4"), newPath: path("d.mp4")},
+			},
+			want: []renamePair{
+				{oldPath: path("c.mp4"), newPath: path("d.mp4")},
+				{oldPath: path("b.mp4"), newPath: path("c.mp4")},
+				{oldPath: path("a.mp4"), newPath: path("b.mp4")},
+			},
+		},
+		{
+			name: "all problems are reported",
+			pairs: []renamePair{
+				{oldPath: path("a.mp4"), newPath: path("taken.mp4")},
+				{oldPath: path("missing.mp4"), newPath: path("x.mp4")},
+				{oldPath: path("b.mp4"), newPath: path("x.mp4")},
+			},
+			wantErr: []string{"taken.mp4", "missing.mp4", "same path"},
+		},
+		{
+			name: "cycle",
+			pairs: []renamePair{
+				{oldPath: path("a.mp4"), newPath: path("b.mp4")},
+				{oldPath: path("b.mp4"), newPath: path("a.mp4")},
+			},
+			wantErr: []string{"cycle"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got, err := orderRenameMapping(newTestState(), tt.pairs, nil, false)
+
+			// assert
+			for _, wantErr := range tt.wantErr {
+				assert.ErrorContains(t, err, wantErr)
+			}
+			if len(tt.wantErr) == 0 {
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func Test_renameFromFile(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.mp4"), nil, 0644))
+	mappingPath := filepath.Join(dir, "mapping.csv")
+	mapping := fmt.Sprintf("old,new\n%s,%s\n", filepath.Join(dir, "a.mp4"), filepath.Join(dir, "sub", "b.mp4"))
+	require.NoError(t, os.WriteFile(mappingPath, []byte(mapping), 0644))
+
+	// execute
+	err := renameFromFile(newTestState(), mappingPath, "", false, false)
+
+	// assert
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(dir, "sub", "b.mp4"))
+	assert.NoFileExists(t, filepath.Join(dir, "a.mp4"))
+}
+
+func Test_stage(t *testing.T) {
+	// setup
+	st := newTestState()
+	dir := t.TempDir()
+	stagingPath := filepath.Join(dir, "staging.json")
+	path := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+	require.NoError(t, stage(st, stagingPath, []string{"prefix", "x-", "a.mp4"}, []renamePair{{oldPath: path("a.mp4"), newPath: path("x-a.mp4")}}, []string{"srt"}))
+
+	tests := []struct {
+		name    string
+		pairs   []renamePair
+		want    int
+		wantErr []string
+	}{
+		{
+			name:  "renames are added",
+			pairs: []renamePair{{oldPath: path("b.mp4"), newPath: path("x-b.mp4")}},
+			want:  2,
+		},
+		{
+			name: "conflicts with the staged renames are refused",
+			pairs: []renamePair{
+				{oldPath: path("a.mp4"), newPath: path("y-a.mp4")},
+				{oldPath: path("c.mp4"), newPath: path("x-a.mp4")},
+			},
+			want:    1,
+			wantErr: []string{"already staged", "same path"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			data, err := os.ReadFile(stagingPath)
+			require.NoError(t, err)
+			defer func() { require.NoError(t, os.WriteFile(stagingPath, data, 0644)) }()
+
+			// execute
+			err = stage(st, stagingPath, []string{"test"}, tt.pairs, nil)
+
+			// assert
+			for _, wantErr := range tt.wantErr {
+				assert.ErrorContains(t, err, wantErr)
+			}
+			if len(tt.wantErr) == 0 {
+				require.NoError(t, err)
+			}
+
+			staged, err := readStaging(stagingPath)
+			require.NoError(t, err)
+			assert.Len(t, staged, tt.want)
+			assert.Equal(t, []string{"srt"}, staged[0].Sidecars)
+		})
+	}
+}
+
+func Test_stage_command(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "a-b.txt")
+	require.NoError(t, os.WriteFile(filePath, []byte("foo"), 0644))
+	stagingPath := filepath.Join(dir, "staging.json")
+
+	invocation = []string{"ffr", "--" + rootFlag, dir, "--" + stagingFlag, stagingPath, "--" + commandHistoryFlag + "=", "--" + journalFlag + "=", stageCommand, prefixCommand, "zz", filePath}
+	defer func() { invocation = nil }()
+
+	app := newApp()
+
+	// execute
+	err := app.Run(invocation)
+
+	// assert
+	require.NoError(t, err)
+	assert.FileExists(t, filePath)
+	staged, err := readStaging(stagingPath)
+	require.NoError(t, err)
+	require.Len(t, staged, 1)
+	require.Len(t, staged[0].Renames, 1)
+	assert.Equal(t, filepath.Join(dir, "zz-a-b.txt"), staged[0].Renames[0].To)
+}
+
+func Test_commitStaged(t *testing.T) {
+	tests := []struct {
+		name      string
+		renames   map[string]string
+		wantFiles []string
+		wantErr   string
+	}{
+		{
+			name:      "all renames are applied",
+			renames:   map[string]string{"a.mp4": "sub/x-a.mp4", "b.mp4": "x-b.mp4"},
+			wantFiles: []string{"blocker", "sub/x-a.mp4", "sub/x-a.srt", "x-b.mp4"},
+		},
+		{
+			name:      "renames done are reverted on failure",
+			renames:   map[string]string{"a.mp4": "x-a.mp4", "b.mp4": "blocker/x-b.mp4"},
+			wantFiles: []string{"a.mp4", "a.srt", "b.mp4", "blocker"},
+			wantErr:   "reverting",
+		},
+		{
+			name:      "nothing is renamed if a target is taken",
+			renames:   map[string]string{"a.mp4": "x-a.mp4", "b.mp4": "blocker"},
+			wantFiles: []string{"a.mp4", "a.srt", "b.mp4", "blocker"},
+			wantErr:   "blocker",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			st := newTestState()
+			dir := t.TempDir()
+			for _, name := range []string{"a.mp4", "a.srt", "b.mp4", "blocker"} {
+				require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(name), 0644))
+			}
+			stagingPath := filepath.Join(t.TempDir(), "staging.json")
+
+			// a is staged before b so that b fails after a was renamed
+			for _, oldName := range []string{"a.mp4", "b.mp4"} {
+				pair := renamePair{oldPath: filepath.Join(dir, oldName), newPath: filepath.Join(dir, tt.renames[oldName])}
+				require.NoError(t, stage(st, stagingPath, []string{"test"}, []renamePair{pair}, []string{"srt"}))
+			}
+
+			// execute
+			err := commitStaged(st, stagingPath, false, false)
+
+			// assert
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				assert.FileExists(t, stagingPath)
+			} else {
+				require.NoError(t, err)
+				assert.NoFileExists(t, stagingPath)
+			}
+
+			var files []string
+			err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+				if err == nil && !info.IsDir() {
+					rel, _ := filepath.Rel(dir, path)
+					files = append(files, filepath.ToSlash(rel))
+				}
+
+				return err
+			})
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantFiles, files)
+		})
+	}
+}
+
+func Test_parseEditList(t *testing.T) {
+	filePaths := []string{"a.mp4", "b.mp4", "c.mp4"}
+
+	tests := []struct {
+		name         string
+		content      string
+		wantPairs    []renamePair
+		wantRemovals []string
+		wantErr      string
+	}{
+		{
+			name:         "renames and removals",
+			content:      editHeader + "1\tx.mp4\n2\n\n3\tc.mp4\n",
+			wantPairs:    []renamePair{{oldPath: "a.mp4", newPath: "x.mp4"}},
+			wantRemovals: []string{"b.mp4"},
+		},
+		{
+			name:    "deleted lines are kept",
+			content: "2\tb.mp4\n",
+		},
+		{
+			name:    "unknown number",
+			content: "4\tx.mp4\n",
+			wantErr: "unknown file number",
+		},
+		{
+			name:    "number used twice",
+			content: "1\tx.mp4\n1\ty.mp4\n",
+			wantErr: "more than once",
+		},
+		{
+			name:    "missing number",
+			content: "x.mp4\n",
+			wantErr: "invalid line",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			pairs, removals, err := parseEditList(strings.NewReader(tt.content), filePaths)
+
+			// assert
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantPairs, pairs)
+			assert.Equal(t, tt.wantRemovals, removals)
+		})
+	}
+}
+
+func Test_edit(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	var fileList []os.FileInfo
+	for _, name := range []string{"a.mp4", "b.mp4"} {
+		filePath := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(filePath, []byte(name), 0644))
+		fi, err := os.Stat(filePath)
+		require.NoError(t, err)
+		fileList = append(fileList, withPath(fi, filePath))
+	}
+
+	// a is removed and b takes its name
+	original := runEditor
+	defer func() { runEditor = original }()
+	runEditor = func(path string) error {
+		content := fmt.Sprintf("1\n2\t%s\n", filepath.Join(dir, "a.mp4"))
+
+		return os.WriteFile(path, []byte(content), 0644)
+	}
+
+	// execute
+	err := edit(newTestState(), fileList, false, false, false)
+
+	// assert
+	require.NoError(t, err)
+	content, err := os.ReadFile(filepath.Join(dir, "a.mp4"))
+	require.NoError(t, err)
+	assert.Equal(t, "b.mp4", string(content))
+	assert.FileExists(t, filepath.Join(dir, trashDirName, "a.mp4"))
+	assert.NoFileExists(t, filepath.Join(dir, "b.mp4"))
+}
+
+func Test_matchMirrorFiles(t *testing.T) {
+	lengths := map[string]string{
+		"ref/a.mp4":     "60.0",
+		"ref/b.mp4":     "120.0",
+		"target/x.mp4":  "120.4",
+		"target/y.mp4":  "59.8",
+		"target/z.mp4":  "300.0",
+		"target/01.mp4": "60.0",
+		"target/02.mp4": "60.0",
+		"target/10.mp4": "60.0",
+	}
+	useFakeRunner(t, func(args []string) (string, error) {
+		return lengths[args[len(args)-1]], nil
+	})
+
+	fileList := func(names ...string) []os.FileInfo {
+		var result []os.FileInfo
+		for _, name := range names {
+			result = append(result, pathFileInfo{path: name})
+		}
+
+		return result
+	}
+
+	tests := []struct {
+		name      string
+		reference []string
+		target    []string
+		by        string
+		want      map[string]string
+		wantErr   string
+	}{
+		{
+			name:      "index uses natural order",
+			reference: []string{"ref/b.mp4", "ref/a.mp4"},
+			target:    []string{"target/10.mp4", "target/02.mp4"},
+			by:        mirrorByIndex,
+			want:      map[string]string{"target/02.mp4": "ref/a.mp4", "target/10.mp4": "ref/b.mp4"},
+		},
+		{
+			name:      "index needs the same number of files",
+			reference: []string{"ref/a.mp4"},
+			target:    []string{"target/01.mp4", "target/02.mp4"},
+			by:        mirrorByIndex,
+			wantErr:   "different number of files",
+		},
+		{
+			name:      "duration",
+			reference: []string{"ref/a.mp4", "ref/b.mp4"},
+			target:    []string{"target/x.mp4", "target/y.mp4", "target/z.mp4"},
+			by:        mirrorByDuration,
+			want:      map[string]string{"target/x.mp4": "ref/b.mp4", "target/y.mp4": "ref/a.mp4"},
+		},
+		{
+			name:      "name",
+			reference: []string{"ref/paris-day-1.mp4", "ref/rome-day-2.mp4"},
+			target:    []string{"target/Rome Day 2 720p.mp4", "target/paris_day_1.mp4", "target/other.mp4"},
+			by:        mirrorByName,
+			want:      map[string]string{"target/Rome Day 2 720p.mp4": "ref/rome-day-2.mp4", "target/paris_day_1.mp4": "ref/paris-day-1.mp4"},
+		},
+		{
+			name:    "invalid match",
+			by:      "size",
+			wantErr: "invalid match",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			reference, target := fileList(tt.reference...), fileList(tt.target...)
+
+			// execute
+			matches, err := matchMirrorFiles(newTestState(), reference, target, tt.by, 0.6, defaultDurationTolerance)
+
+			// assert
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+
+				return
+			}
+			require.NoError(t, err)
+			got := map[string]string{}
+			for j, i := range matches {
+				if i >= 0 {
+					got[target[j].Name()] = reference[i].Name()
+				}
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_mirrorNames(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	referenceDir, targetDir := filepath.Join(dir, "videos"), filepath.Join(dir, "subs")
+	require.NoError(t, os.Mkdir(referenceDir, 0755))
+	require.NoError(t, os.Mkdir(targetDir, 0755))
+	for _, filePath := range []string{
+		filepath.Join(referenceDir, "paris.mp4"),
+		filepath.Join(referenceDir, "rome.mp4"),
+		filepath.Join(targetDir, "1.srt"),
+		filepath.Join(targetDir, "2.srt"),
+		filepath.Join(targetDir, "notes.txt"),
+	} {
+		require.NoError(t, os.WriteFile(filePath, []byte(filePath), 0644))
+	}
+
+	// execute
+	err := mirrorNames(newTestState(), referenceDir, targetDir, []string{"srt"}, mirrorByIndex, defaultSimilarity, defaultDurationTolerance, false, false)
+
+	// assert
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(targetDir, "paris.srt"))
+	assert.FileExists(t, filepath.Join(targetDir, "rome.srt"))
+	assert.FileExists(t, filepath.Join(targetDir, "notes.txt"))
+	assert.NoFileExists(t, filepath.Join(targetDir, "1.srt"))
+}
+
+func Test_splitSubtitleName(t *testing.T) {
+	tests := []struct {
+		base       string
+		wantName   string
+		wantSuffix string
+	}{
+		{base: "foo", wantName: "foo"},
+		{base: "foo.en", wantName: "foo", wantSuffix: ".en"},
+		{base: "foo.pt-BR.forced", wantName: "foo", wantSuffix: ".pt-BR.forced"},
+		{base: "foo.eng.SDH", wantName: "foo", wantSuffix: ".eng.SDH"},
+		{base: "Show.S01E02.1080p", wantName: "Show.S01E02.1080p"},
+		{base: "Movie.2019.WEB", wantName: "Movie.2019.WEB"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.base, func(t *testing.T) {
+			// execute
+			name, suffix := splitSubtitleName(tt.base)
+
+			// assert
+			assert.Equal(t, tt.wantName, name)
+			assert.Equal(t, tt.wantSuffix, suffix)
+		})
+	}
+}
+
+func Test_findEpisode(t *testing.T) {
+	tests := []struct {
+		name   string
+		want   episode
+		wantOk bool
+	}{
+		{name: "Show.S01E02.1080p.mkv", want: episode{title: "Show", season: 1, number: 2}, wantOk: true},
+		{name: "show s1 e12.srt", want: episode{title: "show", season: 1, number: 12}, wantOk: true},
+		{name: "show-1x02.en.srt", want: episode{title: "show", season: 1, number: 2}, wantOk: true},
+		{name: "Show Episode 7.mp4", want: episode{title: "Show", season: -1, number: 7}, wantOk: true},
+		{name: "S02E03.srt", want: episode{season: 2, number: 3}, wantOk: true},
+		{name: "show-1920x1080.mp4"},
+		{name: "movie.mp4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got, ok := findEpisode(tt.name)
+
+			// assert
+			assert.Equal(t, tt.wantOk, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_matchSubs(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	files := map[string]string{
+		"Show.S01E01.1080p.mkv": "",
+		"Show.S01E02.1080p.mkv": "",
+		"Show.S01E03.1080p.mkv": "",
+		"show-1x02.en.srt":      "1\n00:40:01,000 --> 00:40:03,500\nbye\n",
+		"show-1x01.en.srt":      "1\n00:41:01,000 --> 00:41:03,500\nbye\n",
+		"show-1x01.hu.srt":      "1\n00:41:01,000 --> 00:41:03,500\nszia\n",
+		"Show.S01E03.1080p.srt": "",
+		"show-1x03.srt":         "",
+		"unrelated-long.srt":    "1\n01:40:01,000 --> 01:40:03,500\nbye\n",
+		"Show.S01E01.1080p.txt": "",
+		"another-show-1x01.vtt": "",
+	}
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content+name), 0644))
+	}
+	useFakeRunner(t, func(args []string) (string, error) {
+		return "2700.0", nil
+	})
+
+	// execute
+	n, err := matchSubs(newTestState(), dir, 0.9, true, false, false)
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.FileExists(t, filepath.Join(dir, "Show.S01E01.1080p.en.srt"))
+	assert.FileExists(t, filepath.Join(dir, "Show.S01E01.1080p.hu.srt"))
+	assert.FileExists(t, filepath.Join(dir, "Show.S01E02.1080p.en.srt"))
+	assert.FileExists(t, filepath.Join(dir, "show-1x03.srt"), "subtitles already exist for the video")
+	assert.FileExists(t, filepath.Join(dir, "unrelated-long.srt"))
+}
+
+func Test_parseLangSpec(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    langSpec
+		wantErr string
+	}{
+		{spec: "eng", want: langSpec{index: -1, language: "eng"}},
+		{spec: "HU", want: langSpec{index: -1, language: "hun"}},
+		{spec: "s=hun", want: langSpec{streamType: streamTypeSubtitle, index: -1, language: "hun"}},
+		{spec: "a:1=en", want: langSpec{streamType: streamTypeAudio, index: 1, language: "eng"}},
+		{spec: "xx", wantErr: "unknown two-letter language code"},
+		{spec: "v:0=eng", wantErr: "invalid language"},
+		{spec: "english", wantErr: "invalid language"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			// execute
+			got, err := parseLangSpec(tt.spec)
+
+			// assert
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_detectLanguage(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{
+			name: "english",
+			text: "1\n00:00:01,000 --> 00:00:02,000\n<i>What is that?</i>\n\nI have no idea what you are talking about, and I do not want to know.\nThis is not the end of it, you know that.",
+			want: "eng",
+		},
+		{
+			name: "hungarian",
+			text: "Mi az, hogy nem tudod? Ez nem egy játék.\nCsak azt mondtam, hogy már nincs meg a kulcs.\nDe hát az is a te hibád, és ez van.",
+			want: "hun",
+		},
+		{
+			name: "too short",
+			text: "What is that?",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got := detectLanguage(tt.text)
+
+			// assert
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_languageArgs(t *testing.T) {
+	streams := []streamInfo{
+		{streamType: streamTypeAudio, index: 0, language: "eng"},
+		{streamType: streamTypeAudio, index: 1},
+		{streamType: streamTypeSubtitle, index: 0, language: undeterminedLanguage},
+		{streamType: streamTypeSubtitle, index: 1},
+	}
+	detect := func(index int) string {
+		return "fre"
+	}
+
+	tests := []struct {
+		name   string
+		specs  []langSpec
+		detect func(index int) string
+		want   []string
+	}{
+		{
+			name:  "missing languages only",
+			specs: []langSpec{{index: -1, language: "hun"}},
+			want:  []string{"-metadata:s:a:1", "language=hun", "-metadata:s:s:0", "language=hun", "-metadata:s:s:1", "language=hun"},
+		},
+		{
+			name:  "stream specs override",
+			specs: []langSpec{{streamType: streamTypeAudio, index: 0, language: "ger"}, {streamType: streamTypeSubtitle, index: -1, language: "hun"}},
+			want:  []string{"-metadata:s:a:0", "language=ger", "-metadata:s:s:0", "language=hun", "-metadata:s:s:1", "language=hun"},
+		},
+		{
+			name:   "detection fills in the rest of the subtitles",
+			specs:  []langSpec{{streamType: streamTypeSubtitle, index: 1, language: "hun"}},
+			detect: detect,
+			want:   []string{"-metadata:s:s:0", "language=fre", "-metadata:s:s:1", "language=hun"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got := languageArgs(streams, tt.specs, tt.detect)
+
+			// assert
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_langTag_fakeRunner(t *testing.T) {
+	// setup
+	fake := useFakeRunner(t, func(args []string) (string, error) {
+		if args[0] == "ffprobe" {
+			return `{"streams": [{"codec_type": "video"}, {"codec_type": "audio", "tags": {"language": "eng"}}, {"codec_type": "subtitle"}]}`, nil
+		}
+
+		return "", nil
+	})
+
+	// execute
+	got, err := langTag(newTestState(), pathFileInfo{path: "foo.mkv"}, []langSpec{{index: -1, language: "hun"}}, false, true, false)
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, "foo-lang.mkv", got)
+	require.Len(t, fake.Commands, 2)
+	assert.Equal(t, []string{"ffmpeg", "-i", "foo.mkv", "-map", "0", "-c", "copy", "-metadata:s:s:0", "language=hun", "foo-lang.mkv"}, fake.Commands[1])
+}
+
+func Test_getOverlayFilter(t *testing.T) {
+	tests := []struct {
+		name      string
+		filePath  string
+		parts     []string
+		text      string
+		placement string
+		fontSize  int
+		box       bool
+		want      string
+		wantErr   string
+	}{
+		{
+			name:     "file name and timecode",
+			filePath: "dir/foo bar.mp4",
+			parts:    []string{overlayFilename, overlayTimecode},
+			box:      true,
+			want:     `drawtext=text=foo bar.mp4 %{pts\\:hms}:fontsize=h/24:fontcolor=white:x=(w-text_w)/2:y=h-text_h-h/40:box=1:boxcolor=black@0.5:boxborderw=10`,
+		},
+		{
+			name:      "special characters are escaped",
+			filePath:  "foo.mp4",
+			parts:     []string{overlayCustom},
+			text:      `it's 50%: [a,b]`,
+			placement: "top-left",
+			fontSize:  32,
+			want:      `drawtext=text=it\\\'s 50\\\\%\\: \[a\,b\]:fontsize=32:fontcolor=white:x=h/40:y=h/40`,
+		},
+		{
+			name:     "missing text",
+			filePath: "foo.mp4",
+			parts:    []string{overlayCustom},
+			wantErr:  "missing text",
+		},
+		{
+			name:     "invalid overlay",
+			filePath: "foo.mp4",
+			parts:    []string{"date"},
+			wantErr:  "invalid overlay",
+		},
+		{
+			name:      "invalid placement",
+			filePath:  "foo.mp4",
+			parts:     []string{overlayFilename},
+			placement: "left",
+			wantErr:   "invalid placement",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got, err := getOverlayFilter(tt.filePath, tt.parts, tt.text, tt.placement, tt.fontSize, tt.box)
+
+			// assert
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_overlayText_fakeRunner(t *testing.T) {
+	// setup
+	fake := useFakeRunner(t, func(args []string) (string, error) {
+		return "", nil
+	})
+
+	// execute
+	got, err := overlayText(newTestState(), pathFileInfo{path: "foo.mp4"}, []string{overlayTimecode}, "", "top", 0, false, "libx264", 0, true, false)
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, "foo-review.mp4", got)
+	require.Len(t, fake.Commands, 1)
+	assert.Equal(t, []string{"ffmpeg", "-i", "foo.mp4", "-filter:v", `drawtext=text=%{pts\\:hms}:fontsize=h/24:fontcolor=white:x=(w-text_w)/2:y=h/40`, "-c:v", "libx264", "-crf", "28", "-c:a", "copy", "foo-review.mp4"}, fake.Commands[0])
+}
+
+func Test_proxy_fakeRunner(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		format  string
+		height  int
+		want    []string
+		wantErr string
+	}{
+		{
+			name:   "h264",
+			format: proxyH264,
+			want:   []string{"ffmpeg", "-i", filepath.Join(dir, "foo.mkv"), "-map", "0:v:0", "-map", "0:a?", "-vf", "scale=-2:540", "-c:v", "libx264", "-crf", "23", "-preset", "veryfast", "-pix_fmt", "yuv420p", "-c:a", "aac", "-g", "1", filepath.Join(dir, "Proxy", "foo.mp4")},
+		},
+		{
+			name:   "prores",
+			format: proxyProRes,
+			height: 720,
+			want:   []string{"ffmpeg", "-i", filepath.Join(dir, "foo.mkv"), "-map", "0:v:0", "-map", "0:a?", "-vf", "scale=-2:720", "-c:v", "prores_ks", "-profile:v", "0", "-pix_fmt", "yuv422p10le", "-c:a", "pcm_s16le", "-g", "1", filepath.Join(dir, "Proxy", "foo.mov")},
+		},
+		{
+			name:    "invalid format",
+			format:  "mjpeg",
+			wantErr: "invalid proxy format",
+		},
+		{
+			name:    "odd height",
+			format:  proxyH264,
+			height:  541,
+			wantErr: "invalid proxy height",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			fake := useFakeRunner(t, func(args []string) (string, error) {
+				return "", nil
+			})
+
+			// execute
+			_, err := proxy(newTestState(), pathFileInfo{path: filepath.Join(dir, "foo.mkv")}, tt.format, tt.height, true, false)
+
+			// assert
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, fake.Commands, 1)
+			assert.Equal(t, tt.want, fake.Commands[0])
+			assert.DirExists(t, filepath.Join(dir, proxyDirName))
+		})
+	}
+}
+
+func Test_reEncode_intermediate_fakeRunner(t *testing.T) {
+	// setup
+	fake := useFakeRunner(t, func(args []string) (string, error) {
+		return "", nil
+	})
+
+	// execute
+	got, err := reEncode(newTestState(), pathFileInfo{path: "foo.mp4"}, reEncodeOptions{codec: encoderProRes, crf: 28, preset: "ultrafast", profile: "4444"}, false)
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, "foo-prores_ks-yuva444p10le.mov", got)
+	require.Len(t, fake.Commands, 2)
+	assert.Equal(t, []string{"ffmpeg", "-i", "foo.mp4", "-c:v", "prores_ks", "-pix_fmt", "yuva444p10le", "-profile:v", "4", "-c:a", "pcm_s16le", "foo-prores_ks-yuva444p10le.mov"}, fake.Commands[1])
+}
+
+func Test_archive_fakeRunner(t *testing.T) {
+	const header = "#format: frame checksums\n#version: 2\n#tb 0: 1/25\n#stream#, dts, pts, duration, size, hash\n"
+
+	tests := []struct {
+		name        string
+		codec       string
+		archiveHash string
+		want        []string
+		wantErr     string
+	}{
+		{
+			name:        "ffv1 verified",
+			codec:       archiveFFV1,
+			archiveHash: header + "0, 0, 0, 40, 3110400, aaa\n0, 40, 40, 40, 3110400, bbb\n",
+			want:        []string{"ffmpeg", "-i", "foo.mp4", "-map", "0", "-c", "copy", "-c:v", "ffv1", "-level", "3", "-coder", "1", "-context", "1", "-g", "1", "-slices", "24", "-slicecrc", "1", "foo-archive-ffv1.mkv"},
+		},
+		{
+			name:        "differing frame",
+			codec:       archiveX265,
+			archiveHash: header + "0, 0, 0, 40, 3110400, aaa\n0, 40, 40, 40, 3110400, ccc\n",
+			wantErr:     "frame: 1",
+		},
+		{
+			name:        "missing frame",
+			codec:       archiveFFV1,
+			archiveHash: header + "0, 0, 0, 40, 3110400, aaa\n",
+			wantErr:     "different number of frames",
+		},
+		{
+			name:    "invalid codec",
+			codec:   "huffyuv",
+			wantErr: "invalid archive codec",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			fake := useFakeRunner(t, func(args []string) (string, error) {
+				if !containsString(args, "framemd5") {
+					return "", nil
+				}
+
+				if containsString(args, "foo.mp4") {
+					return header + "0, 0, 0, 1, 3110400, aaa\n0, 1, 1, 1, 3110400, bbb\n", nil
+				}
+
+				return tt.archiveHash, nil
+			})
+
+			// execute
+			_, err := archive(newTestState(), pathFileInfo{path: "foo.mp4"}, tt.codec, false, true, false)
+
+			// assert
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, fake.Commands, 3)
+			assert.Equal(t, tt.want, fake.Commands[0])
+		})
+	}
+}
+
+func Test_streamHashes_fakeRunner(t *testing.T) {
+	const frameHeader = "#format: frame checksums\n#version: 2\n#hash: MD5\n#stream#, dts, pts, duration, size, hash\n"
+
+	outputs := map[string]map[string]string{
+		"foo.mp4": {
+			"streamhash": "0,v,MD5=aaa\n1,a,MD5=bbb\n",
+			"framehash":  frameHeader + "0, 0, 0, 1, 100, f1\n1, 0, 0, 1024, 10, a1\n0, 1, 1, 1, 100, f2\n",
+		},
+		"foo.mkv": {
+			"streamhash": "0,v,MD5=aaa\n1,a,MD5=bbb\n",
+		},
+		"bar.mkv": {
+			"streamhash": "0,v,MD5=ccc\n1,a,MD5=bbb\n",
+			"framehash":  frameHeader + "0, 0, 0, 1, 100, f1\n1, 0, 0, 1024, 10, a1\n0, 1, 1, 1, 100, f3\n",
+		},
+	}
+
+	tests := []struct {
+		name      string
+		files     []string
+		algorithm string
+		compare   bool
+		frames    bool
+		wantErr   string
+	}{
+		{
+			name:      "hash only",
+			files:     []string{"foo.mp4", "bar.mkv"},
+			algorithm: "md5",
+		},
+		{
+			name:      "identical",
+			files:     []string{"foo.mp4", "foo.mkv"},
+			algorithm: "md5",
+			compare:   true,
+		},
+		{
+			name:      "differing stream",
+			files:     []string{"foo.mp4", "bar.mkv"},
+			algorithm: "md5",
+			compare:   true,
+			wantErr:   "stream differs. file: \"foo.mp4\", other: \"bar.mkv\", stream: 0",
+		},
+		{
+			name:      "differing frame",
+			files:     []string{"foo.mp4", "bar.mkv"},
+			algorithm: "md5",
+			compare:   true,
+			frames:    true,
+			wantErr:   "stream: 0, frame: 1",
+		},
+		{
+			name:      "one file to compare",
+			files:     []string{"foo.mp4"},
+			algorithm: "md5",
+			compare:   true,
+			wantErr:   "at least two files",
+		},
+		{
+			name:      "invalid algorithm",
+			files:     []string{"foo.mp4"},
+			algorithm: "sha1",
+			wantErr:   "invalid hash algorithm",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			useFakeRunner(t, func(args []string) (string, error) {
+				return outputs[args[4]][args[len(args)-4]], nil
+			})
+
+			var fileList []os.FileInfo
+			for _, name := range tt.files {
+				fileList = append(fileList, pathFileInfo{path: name})
+			}
+
+			// execute
+			err := streamHashes(newTestState(), fileList, tt.algorithm, tt.compare, tt.frames, formatJSON)
+
+			// assert
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func toBCD(n int) byte {
+	return byte(n/10<<4 | n%10)
+}
+
+// buildVTSIFO builds a title set information file with a program chain per list of cell lengths in seconds, each
+// program starting at the given cells
+func buildVTSIFO(chains [][]int, entryCells [][]byte) []byte {
+	data := make([]byte, 2*dvdSectorSize)
+	copy(data, "DVDVIDEO-VTS")
+	binary.BigEndian.PutUint32(data[0xcc:], 1)
+
+	dvdTime := func(b []byte, seconds int) {
+		b[0], b[1], b[2], b[3] = toBCD(seconds/3600), toBCD(seconds/60%60), toBCD(seconds%60), 0x40
+	}
+
+	pgciti := dvdSectorSize
+	binary.BigEndian.PutUint16(data[pgciti:], uint16(len(chains)))
+	for i, cells := range chains {
+		offset := 0x20 + i*0x200
+		binary.BigEndian.PutUint32(data[pgciti+8+i*8+4:], uint32(offset))
+
+		pgc := pgciti + offset
+		data[pgc+2], data[pgc+3] = byte(len(entryCells[i])), byte(len(cells))
+		binary.BigEndian.PutUint16(data[pgc+0xe6:], 0xec)
+		binary.BigEndian.PutUint16(data[pgc+0xe8:], 0x100)
+		copy(data[pgc+0xec:], entryCells[i])
+
+		total := 0
+		for c, seconds := range cells {
+			dvdTime(data[pgc+0x100+c*24+4:], seconds)
+			total += seconds
+		}
+		dvdTime(data[pgc+4:], total)
+	}
+
+	return data
+}
+
+// mplsItem is a play item of a test playlist, times are in seconds
+type mplsItem struct {
+	clip    string
+	in, out uint32
+}
+
+// mplsMark is a playlist mark of a test playlist, the time stamp is in seconds
+type mplsMark struct {
+	markType byte
+	item     uint16
+	ts       uint32
+}
+
+func buildMPLS(items []mplsItem, marks []mplsMark) []byte {
+	playList := 40
+	markStart := playList + 10 + len(items)*22
+
+	data := make([]byte, markStart+6+len(marks)*14)
+	copy(data, "MPLS0200")
+	binary.BigEndian.PutUint32(data[8:], uint32(playList))
+	binary.BigEndian.PutUint32(data[12:], uint32(markStart))
+
+	binary.BigEndian.PutUint16(data[playList+6:], uint16(len(items)))
+	for i, item := range items {
+		p := playList + 10 + i*22
+		binary.BigEndian.PutUint16(data[p:], 20)
+		copy(data[p+2:], item.clip+"M2TS")
+		binary.BigEndian.PutUint32(data[p+14:], item.in*blurayTimeBase)
+		binary.BigEndian.PutUint32(data[p+18:], item.out*blurayTimeBase)
+	}
+
+	binary.BigEndian.PutUint16(data[markStart+4:], uint16(len(marks)))
+	for i, mark := range marks {
+		p := markStart + 6 + i*14
+		data[p+1] = mark.markType
+		binary.BigEndian.PutUint16(data[p+2:], mark.item)
+		binary.BigEndian.PutUint32(data[p+4:], mark.ts*blurayTimeBase)
+	}
+
+	return data
+}
+
+func Test_parseVTSIFO(t *testing.T) {
+	// setup
+	data := buildVTSIFO([][]int{{10}, {600, 600, 600, 600}}, [][]byte{{1}, {1, 2, 4}})
+
+	// execute
+	length, chapters, err := parseVTSIFO(data)
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, 2400.0, length)
+	assert.Equal(t, []float64{0, 600, 1800}, chapters)
+
+	_, _, err = parseVTSIFO(data[:100])
+	assert.Error(t, err)
+}
+
+func Test_parseMPLS(t *testing.T) {
+	// setup
+	data := buildMPLS(
+		[]mplsItem{{clip: "00001", in: 0, out: 600}, {clip: "00002", in: 10, out: 310}},
+		[]mplsMark{{markType: 1, item: 0, ts: 0}, {markType: 1, item: 1, ts: 10}, {markType: 2, item: 1, ts: 60}, {markType: 1, item: 1, ts: 110}},
+	)
+
+	// execute
+	clips, length, chapters, err := parseMPLS(data)
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, []string{"00001", "00002"}, clips)
+	assert.Equal(t, 900.0, length)
+	assert.Equal(t, []float64{0, 600, 700}, chapters)
+}
+
+func Test_writeChapters(t *testing.T) {
+	// setup
+	sb := &strings.Builder{}
+
+	// execute
+	err := writeChapters(sb, []float64{0, 61.5}, 120)
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, ";FFMETADATA1\n[CHAPTER]\nTIMEBASE=1/1000\nSTART=0\nEND=61500\ntitle=Chapter 1\n[CHAPTER]\nTIMEBASE=1/1000\nSTART=61500\nEND=120000\ntitle=Chapter 2\n", sb.String())
+}
+
+func Test_ingestDisc(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	disc := filepath.Join(dir, "MY_MOVIE")
+	videoTS := filepath.Join(disc, "VIDEO_TS")
+	require.NoError(t, os.MkdirAll(videoTS, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(videoTS, "VTS_01_0.IFO"), buildVTSIFO([][]int{{600, 600}}, [][]byte{{1, 2}}), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(videoTS, "VTS_02_0.IFO"), buildVTSIFO([][]int{{30}}, [][]byte{{1}}), 0644))
+	for _, name := range []string{"VTS_01_0.VOB", "VTS_01_10.VOB", "VTS_01_2.VOB", "VTS_01_1.VOB", "VTS_02_1.VOB"} {
+		require.NoError(t, os.WriteFile(filepath.Join(videoTS, name), nil, 0644))
+	}
+
+	fake := useFakeRunner(t, func(args []string) (string, error) {
+		return "", nil
+	})
+
+	// execute
+	got, err := ingestDisc(newTestState(), disc, defaultMinTitleLength, false, false)
+
+	// assert
+	require.NoError(t, err)
+	outputPath := filepath.Join(dir, "MY_MOVIE-title-01.mkv")
+	assert.Equal(t, []string{outputPath}, got)
+	require.Len(t, fake.Commands, 1)
+	command := fake.Commands[0]
+	concat := "concat:" + filepath.Join(videoTS, "VTS_01_1.VOB") + "|" + filepath.Join(videoTS, "VTS_01_2.VOB")
+	assert.Equal(t, []string{"ffmpeg", "-fflags", "+genpts", "-analyzeduration", "100M", "-probesize", "100M", "-i", concat}, command[:9])
+	assert.Equal(t, []string{"-map_chapters", "1", "-map", "0:v", "-map", "0:a?", "-map", "0:s?", "-c", "copy", outputPath}, command[11:])
+}
+
+func Test_ingestDisc_commandFails(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	app := newApp()
+
+	// execute
+	err := app.Run([]string{"ffr", "--" + rootFlag, dir, "--" + commandHistoryFlag + "=", "--" + journalFlag + "=", ingestDiscCommand, dir})
+
+	// assert
+	assert.ErrorContains(t, err, "no VIDEO_TS or BDMV directory found")
+}
+
+func Test_parseScanType(t *testing.T) {
+	tests := []struct {
+		name       string
+		output     string
+		wantScan   string
+		wantParity string
+	}{
+		{
+			name:       "progressive",
+			output:     "[Parsed_idet_0 @ 0x1] Repeated Fields: Neither:  1999 Top:     1 Bottom:     0\n[Parsed_idet_0 @ 0x1] Multi frame detection: TFF:     3 BFF:     0 Progressive:  1990 Undetermined:     7\n",
+			wantScan:   scanProgressive,
+			wantParity: "tff",
+		},
+		{
+			name:       "interlaced bottom field first",
+			output:     "[Parsed_idet_0 @ 0x1] Repeated Fields: Neither:  2000 Top:     0 Bottom:     0\n[Parsed_idet_0 @ 0x1] Multi frame detection: TFF:    12 BFF:  1950 Progressive:    30 Undetermined:     8\n",
+			wantScan:   scanInterlaced,
+			wantParity: "bff",
+		},
+		{
+			name:       "telecined",
+			output:     "[Parsed_idet_0 @ 0x1] Repeated Fields: Neither:  1200 Top:   400 Bottom:   400\n[Parsed_idet_0 @ 0x1] Multi frame detection: TFF:   800 BFF:     0 Progressive:  1190 Undetermined:    10\n",
+			wantScan:   scanTelecined,
+			wantParity: "tff",
+		},
+		{
+			name:       "no report",
+			output:     "",
+			wantScan:   scanProgressive,
+			wantParity: "tff",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			gotScan, gotParity := parseScanType(tt.output)
+
+			// assert
+			assert.Equal(t, tt.wantScan, gotScan)
+			assert.Equal(t, tt.wantParity, gotParity)
+		})
+	}
+}
+
+func Test_parseCropDetect(t *testing.T) {
+	// setup
+	output := "[Parsed_cropdetect_1 @ 0x1] x1:0 x2:719 y1:0 y2:575 w:720 h:576 x:0 y:0 pts:0 t:0.000000 crop=720:576:0:0\n" +
+		"[Parsed_cropdetect_1 @ 0x1] x1:8 x2:711 y1:72 y2:503 w:704 h:432 x:8 y:72 pts:1 t:0.040000 crop=704:432:8:72\n" +
+		"[Parsed_cropdetect_1 @ 0x1] x1:8 x2:711 y1:72 y2:503 w:704 h:432 x:8 y:72 pts:2 t:0.080000 crop=704:432:8:72\n"
+
+	// execute
+	got := parseCropDetect(output)
+
+	// assert
+	assert.Equal(t, "704:432:8:72", got)
+}
+
+func Test_getRestoreFilter(t *testing.T) {
+	tests := []struct {
+		name       string
+		scanType   string
+		parity     string
+		cropArea   string
+		dimensions string
+		want       string
+	}{
+		{
+			name:       "progressive without borders",
+			scanType:   scanProgressive,
+			parity:     "tff",
+			cropArea:   "720:576:0:0",
+			dimensions: "720x576",
+			want:       "",
+		},
+		{
+			name:       "interlaced with borders",
+			scanType:   scanInterlaced,
+			parity:     "bff",
+			cropArea:   "704:432:8:72",
+			dimensions: "720x576",
+			want:       "bwdif=mode=send_field:parity=bff,crop=704:432:8:72",
+		},
+		{
+			name:       "telecined",
+			scanType:   scanTelecined,
+			parity:     "tff",
+			cropArea:   "720:480:0:0",
+			dimensions: "720x480",
+			want:       "fieldmatch,yadif=deint=interlaced,decimate",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got := getRestoreFilter(tt.scanType, tt.parity, tt.cropArea, tt.dimensions)
+
+			// assert
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_restoreSD_fakeRunner(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "tape.avi")
+
+	fake := useFakeRunner(t, func(args []string) (string, error) {
+		if args[0] == "ffprobe" {
+			return `{"streams": [{"width": 720, "height": 576}]}`, nil
+		}
+
+		return "[Parsed_idet_0 @ 0x1] Repeated Fields: Neither:  2000 Top:     0 Bottom:     0\n" +
+			"[Parsed_idet_0 @ 0x1] Multi frame detection: TFF:  1980 BFF:     0 Progressive:    12 Undetermined:     8\n" +
+			"[Parsed_cropdetect_1 @ 0x1] crop=704:576:8:0\n", nil
+	})
+
+	// execute
+	got, err := restoreSD(newTestState(), pathFileInfo{path: filePath}, encoderH264, 0, defaultRestorePreset, false, false)
+
+	// assert
+	require.NoError(t, err)
+	outputPath := filepath.Join(dir, "tape-restored.mkv")
+	assert.Equal(t, outputPath, got)
+	require.Len(t, fake.Commands, 3)
+	assert.Equal(t, []string{"ffmpeg", "-hide_banner", "-nostats", "-i", filePath, "-map", "0:v:0", "-frames:v", "2000", "-vf", "idet,cropdetect=round=2", "-f", "null", "-"}, fake.Commands[1])
+	assert.Equal(t, []string{"ffmpeg", "-i", filePath, "-map", "0:v:0", "-map", "0:a?", "-map", "0:s?", "-vf", "bwdif=mode=send_field:parity=tff,crop=704:576:8:0", "-c:v", "libx264", "-crf", "20", "-preset", "slow", "-c:a", "copy", "-c:s", "copy", outputPath}, fake.Commands[2])
+}
+
+func Test_parseTimestamp(t *testing.T) {
+	tests := []struct {
+		name      string
+		timestamp string
+		want      float64
+		wantErr   bool
+	}{
+		{name: "seconds", timestamp: "75.5", want: 75.5},
+		{name: "minutes and seconds", timestamp: "1:15", want: 75},
+		{name: "hours, minutes and seconds", timestamp: "01:02:03.5", want: 3723.5},
+		{name: "seconds out of range", timestamp: "1:75", wantErr: true},
+		{name: "too many parts", timestamp: "1:00:00:00", wantErr: true},
+		{name: "not a number", timestamp: "foo", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got, err := parseTimestamp(tt.timestamp)
+
+			// assert
+			if tt.wantErr {
+				assert.Error(t, err)
+
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_nearestKeyFrame(t *testing.T) {
+	times := []float64{0, 8.3, 16.7, 25}
+
+	tests := []struct {
+		name string
+		at   float64
+		want float64
+	}{
+		{name: "before", at: 10, want: 8.3},
+		{name: "after", at: 15, want: 16.7},
+		{name: "beyond the end", at: 100, want: 25},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got, err := nearestKeyFrame(times, tt.at)
+
+			// assert
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err := nearestKeyFrame(nil, 10)
+	assert.Error(t, err)
+}
+
+func Test_previewClip_fakeRunner(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "foo.mp4")
+
+	fake := useFakeRunner(t, func(args []string) (string, error) {
+		return "0.000000,K__\n0.033333,___\n8.333333,K__\n16.666667,K__\n", nil
+	})
+
+	// execute
+	got, err := previewClip(newTestState(), pathFileInfo{path: filePath}, 10, 0, false, false)
+
+	// assert
+	require.NoError(t, err)
+	outputPath := filepath.Join(dir, "foo-preview-8s.mp4")
+	assert.Equal(t, outputPath, got)
+	require.Len(t, fake.Commands, 2)
+	assert.Equal(t, []string{"ffmpeg", "-ss", "8.333", "-i", filePath, "-t", "10.000", "-map", "0", "-c", "copy", "-avoid_negative_ts", "make_zero", outputPath}, fake.Commands[1])
+}
+
+func Test_getUploadCommand(t *testing.T) {
+	tests := []struct {
+		name        string
+		destination string
+		path        string
+		isDir       bool
+		want        []string
+		wantErr     bool
+	}{
+		{
+			name:        "s3 file",
+			destination: "s3://bucket/videos/",
+			path:        "out/foo.mp4",
+			want:        []string{"aws", "s3", "cp", "--only-show-errors", "out/foo.mp4", "s3://bucket/videos/foo.mp4"},
+		},
+		{
+			name:        "s3 directory",
+			destination: "s3://bucket",
+			path:        "out/frames",
+			isDir:       true,
+			want:        []string{"aws", "s3", "cp", "--only-show-errors", "--recursive", "out/frames", "s3://bucket/frames/"},
+		},
+		{
+			name:        "rclone file",
+			destination: "rclone:backup:videos",
+			path:        "out/foo.mp4",
+			want:        []string{"rclone", "copyto", "out/foo.mp4", "backup:videos/foo.mp4"},
+		},
+		{
+			name:        "rclone root of the remote",
+			destination: "rclone:backup:",
+			path:        "out/frames",
+			isDir:       true,
+			want:        []string{"rclone", "copy", "out/frames", "backup:frames"},
+		},
+		{
+			name:        "rclone without remote",
+			destination: "rclone:videos",
+			path:        "out/foo.mp4",
+			wantErr:     true,
+		},
+		{
+			name:        "unknown scheme",
+			destination: "ftp://example.com/videos",
+			path:        "out/foo.mp4",
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got, err := getUploadCommand(tt.destination, tt.path, tt.isDir)
+
+			// assert
+			if tt.wantErr {
+				assert.Error(t, err)
+
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_uploader_fakeRunner(t *testing.T) {
+	uploadRetryDelay = 0
+	t.Cleanup(func() { uploadRetryDelay = 2 * time.Second })
+
+	tests := []struct {
+		name         string
+		failures     int
+		retries      int
+		deleteLocal  bool
+		wantCommands int
+		wantErr      bool
+		wantExists   bool
+	}{
+		{
+			name:         "uploaded and kept",
+			retries:      3,
+			wantCommands: 1,
+			wantExists:   true,
+		},
+		{
+			name:         "retried, uploaded and deleted",
+			failures:     2,
+			retries:      3,
+			deleteLocal:  true,
+			wantCommands: 3,
+			wantExists:   false,
+		},
+		{
+			name:         "out of retries",
+			failures:     5,
+			retries:      1,
+			deleteLocal:  true,
+			wantCommands: 2,
+			wantErr:      true,
+			wantExists:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			filePath := filepath.Join(t.TempDir(), "foo.mp4")
+			require.NoError(t, os.WriteFile(filePath, nil, 0644))
+
+			calls := 0
+			fake := useFakeRunner(t, func(args []string) (string, error) {
+				calls++
+				if calls <= tt.failures {
+					return "connection reset", errors.New("exit status 1")
+				}
+
+				return "", nil
+			})
+
+			up, err := newUploader("s3://bucket/videos", 2, tt.retries, tt.deleteLocal)
+			require.NoError(t, err)
+
+			// execute
+			up.Add(newTestState(), filePath)
+			got := up.Wait()
+
+			// assert
+			assert.Len(t, fake.Commands, tt.wantCommands)
+			if tt.wantErr {
+				require.Len(t, got, 1)
+				assert.Equal(t, filePath, got[0].Path)
+				assert.Equal(t, "upload errors", errorKind(got[0]))
+			} else {
+				assert.Empty(t, got)
+			}
+			_, err = os.Stat(filePath)
+			assert.Equal(t, tt.wantExists, err == nil)
+		})
+	}
+}
+
+func Test_parseTargetSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    int64
+		wantErr bool
+	}{
+		{name: "megabytes", spec: "8MB", want: 8_000_000},
+		{name: "fraction of gibibytes", spec: "1.5 GiB", want: 1_610_612_736},
+		{name: "short unit", spec: "500k", want: 500_000},
+		{name: "preset", spec: "Discord", want: 10_000_000},
+		{name: "unknown unit", spec: "8 TB", wantErr: true},
+		{name: "zero", spec: "0MB", wantErr: true},
+		{name: "not a size", spec: "small", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got, err := parseTargetSize(tt.spec)
+
+			// assert
+			if tt.wantErr {
+				assert.Error(t, err)
+
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_getSizeBudget(t *testing.T) {
+	tests := []struct {
+		name         string
+		targetSize   int64
+		length       float64
+		audioBitRate int
+		hasAudio     bool
+		want         sizeBudget
+		wantErr      bool
+	}{
+		{
+			name:       "short clip",
+			targetSize: 8_000_000,
+			length:     60,
+			hasAudio:   true,
+			want:       sizeBudget{video: 896, audio: 128, height: 480},
+		},
+		{
+			name:       "long clip gets less audio and a lower height",
+			targetSize: 10_000_000,
+			length:     300,
+			hasAudio:   true,
+			want:       sizeBudget{video: 192, audio: 64, height: 240},
+		},
+		{
+			name:         "fixed audio bit rate",
+			targetSize:   8_000_000,
+			length:       60,
+			audioBitRate: 48,
+			hasAudio:     true,
+			want:         sizeBudget{video: 976, audio: 48, height: 480},
+		},
+		{
+			name:       "no audio",
+			targetSize: 50_000_000,
+			length:     60,
+			want:       sizeBudget{video: 6400, audio: 0, height: 1080},
+		},
+		{
+			name:       "too small",
+			targetSize: 1_000_000,
+			length:     600,
+			hasAudio:   true,
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got, err := getSizeBudget(tt.targetSize, tt.length, tt.audioBitRate, tt.hasAudio)
+
+			// assert
+			if tt.wantErr {
+				assert.Error(t, err)
+
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_encodeToSize_fakeRunner(t *testing.T) {
+	tests := []struct {
+		name           string
+		codec          string
+		outputSize     int
+		forceOverwrite bool
+		wantPath       string
+		wantPass       []string
+		wantErr        string
+	}{
+		{
+			name:       "x264",
+			codec:      encoderH264,
+			outputSize: 100,
+			wantPath:   "foo-8MB.mp4",
+			wantPass:   []string{"-preset", "slow", "-pass", "2"},
+		},
+		{
+			name:       "x265 takes the pass as encoder parameter",
+			codec:      encoderH265,
+			outputSize: 100,
+			wantPath:   "foo-8MB.mp4",
+			wantPass:   []string{"-preset", "slow", "-x265-params"},
+		},
+		{
+			name:       "output larger than the target",
+			codec:      encoderH264,
+			outputSize: 8_000_001,
+			wantPath:   "foo-8MB.mp4",
+			wantErr:    "output is larger than the target size",
+		},
+		{
+			name:           "both passes overwrite if forced",
+			codec:          encoderH264,
+			outputSize:     100,
+			forceOverwrite: true,
+			wantPath:       "foo-8MB.mp4",
+			wantPass:       []string{"-preset", "slow", "-pass", "2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			dir := t.TempDir()
+			filePath := filepath.Join(dir, "foo.mov")
+			outputPath := filepath.Join(dir, tt.wantPath)
+
+			fake := useFakeRunner(t, func(args []string) (string, error) {
+				switch {
+				case containsString(args, "format=duration"):
+					return "60.0\n", nil
+				case args[0] == "ffprobe":
+					return `{"streams": [{"index": 0, "codec_type": "video"}, {"index": 1, "codec_type": "audio"}]}`, nil
+				case args[len(args)-1] == outputPath:
+					return "", os.WriteFile(outputPath, make([]byte, tt.outputSize), 0644)
+				}
+
+				return "", nil
+			})
+
+			// execute
+			if tt.forceOverwrite {
+				require.NoError(t, os.WriteFile(outputPath, []byte("foo"), 0644))
+			}
+
+			got, err := encodeToSize(newTestState(), pathFileInfo{path: filePath}, 8_000_000, "8MB", tt.codec, "slow", 0, 0, tt.forceOverwrite, false)
+
+			// assert
+			assert.Equal(t, outputPath, got)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, fake.Commands, 4)
+
+			firstPass, secondPass := fake.Commands[2], fake.Commands[3]
+			command := ffmpegCommand(tt.forceOverwrite)
+			assert.Equal(t, command, firstPass[:len(command)])
+			assert.Equal(t, command, secondPass[:len(command)])
+			firstPass, secondPass = firstPass[len(command):], secondPass[len(command):]
+			assert.Equal(t, []string{"-i", filePath, "-map", "0:v:0", "-vf", "scale=-2:'min(ih,480)'", "-c:v", tt.codec, "-b:v", "896k"}, firstPass[:10])
+			assert.Equal(t, []string{"-an", "-f", "null", "-"}, firstPass[len(firstPass)-4:])
+			assert.Equal(t, tt.wantPass, secondPass[10:10+len(tt.wantPass)])
+			assert.Equal(t, []string{"-map", "0:a:0", "-c:a", "aac", "-b:a", "128k", "-movflags", "+faststart", outputPath}, secondPass[len(secondPass)-9:])
+		})
+	}
+}
+
+func Test_findContentID(t *testing.T) {
+	tests := []struct {
+		name     string
+		filePath string
+		want     string
+	}{
+		{name: "last part", filePath: "dir/foo-bar-id3fa2c91b.mp4", want: "3fa2c91b"},
+		{name: "middle part", filePath: "foo-id3fa2c91b-bar.mp4", want: "3fa2c91b"},
+		{name: "too short", filePath: "foo-id3fa2c9.mp4", want: ""},
+		{name: "inside a word", filePath: "video3fa2c91b.mp4", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got := findContentID(tt.filePath)
+
+			// assert
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_contentID_fakeRunner(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		want string
+	}{
+		{name: "new ID", file: "foo-bar.mp4", want: "foo-bar-id3fa2c91b.mp4"},
+		{name: "same ID", file: "foo-id3fa2c91b.mp4", want: "foo-id3fa2c91b.mp4"},
+		{name: "outdated ID", file: "foo-id00000000-bar.mp4", want: "foo-id3fa2c91b-bar.mp4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			dir := t.TempDir()
+			filePath := filepath.Join(dir, tt.file)
+			require.NoError(t, os.WriteFile(filePath, nil, 0644))
+
+			fake := useFakeRunner(t, func(args []string) (string, error) {
+				return "MURMUR3=3FA2C91B00a1b2c3d4e5f60718293a4b\n", nil
+			})
+
+			// execute
+			err := contentID(newTestState(), pathFileInfo{path: filePath}, false, false)
+
+			// assert
+			require.NoError(t, err)
+			require.Len(t, fake.Commands, 1)
+			assert.Equal(t, []string{"ffmpeg", "-v", "error", "-i", filePath, "-map", "0:v?", "-map", "0:a?", "-c", "copy", "-f", "hash", "-hash", "murmur3", "-"}, fake.Commands[0])
+			assert.FileExists(t, filepath.Join(dir, tt.want))
+		})
+	}
+}
+
+func Test_classifyNamePart(t *testing.T) {
+	tests := []struct {
+		part string
+		want string
+	}{
+		{part: "2023.07.14", want: namePartDate},
+		{part: "20230714", want: namePartDate},
+		{part: "1920x1080", want: namePartDimensions},
+		{part: "1080p", want: namePartDimensions},
+		{part: "FullHD", want: namePartDimensions},
+		{part: "id3fa2c91b", want: namePartID},
+		{part: "12", want: namePartNumber},
+		{part: "holiday", want: namePartText},
+	}
+	for _, tt := range tests {
+		t.Run(tt.part, func(t *testing.T) {
+			// execute
+			got := classifyNamePart(tt.part)
+
+			// assert
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_lintName(t *testing.T) {
+	policy := namingPolicy{
+		Tokens:     []string{namePartDate, namePartText, namePartDimensions},
+		DatePrefix: true,
+		MaxLength:  30,
+		disallowed: regexp.MustCompile(`[^a-z0-9.-]+`),
+	}
+
+	tests := []struct {
+		name      string
+		filePath  string
+		wantRules []string
+	}{
+		{
+			name:     "valid",
+			filePath: "dir/2023.07.14-holiday-1080p.mp4",
+		},
+		{
+			name:      "disallowed characters and missing date",
+			filePath:  "dir/Holiday 2023.07.14.mp4",
+			wantRules: []string{ruleAllowedChars, ruleDatePrefix},
+		},
+		{
+			name:      "dimensions before text",
+			filePath:  "dir/2023.07.14-1080p-holiday.mp4",
+			wantRules: []string{ruleTokenOrder},
+		},
+		{
+			name:      "too long",
+			filePath:  "dir/2023.07.14-a-very-long-holiday-video.mp4",
+			wantRules: []string{ruleMaxLength},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got := lintName(tt.filePath, policy)
+
+			// assert
+			var rules []string
+			for _, v := range got {
+				rules = append(rules, v.Rule)
+			}
+			assert.Equal(t, tt.wantRules, rules)
+		})
+	}
+}
+
+func Test_fixName(t *testing.T) {
+	policy := namingPolicy{
+		Tokens:     []string{namePartDate, namePartText, namePartDimensions},
+		DatePrefix: true,
+		MaxLength:  30,
+		disallowed: regexp.MustCompile(`[^a-z0-9.-]+`),
+	}
+
+	tests := []struct {
+		name     string
+		filePath string
+		want     string
+	}{
+		{
+			name:     "lowercased, date moved to the front",
+			filePath: filepath.Join("dir", "Holiday 2023.07.14.mp4"),
+			want:     filepath.Join("dir", "2023.07.14-holiday.mp4"),
+		},
+		{
+			name:     "date part moved to the front",
+			filePath: filepath.Join("dir", "holiday_20230714.mp4"),
+			want:     filepath.Join("dir", "20230714-holiday.mp4"),
+		},
+		{
+			name:     "date found in another format",
+			filePath: filepath.Join("dir", "holiday-14.07.2023.mp4"),
+			want:     filepath.Join("dir", "2023.07.14-holiday.mp4"),
+		},
+		{
+			name:     "parts sorted",
+			filePath: filepath.Join("dir", "2023.07.14-1080p-holiday.mp4"),
+			want:     filepath.Join("dir", "2023.07.14-holiday-1080p.mp4"),
+		},
+		{
+			name:     "cut to the max length",
+			filePath: filepath.Join("dir", "2023.07.14-a-very-long-holiday-video.mp4"),
+			want:     filepath.Join("dir", "2023.07.14-a-very-long-hol.mp4"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got := fixName(newLogger(true), tt.filePath, policy)
+
+			// assert
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_lintNames(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	policy := "naming:\n  tokens: [date, text]\n  datePrefix: true\n  allowedChars: a-z0-9.-\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, namingPolicyFileName), []byte(policy), 0644))
+
+	subDir := filepath.Join(dir, "2023")
+	require.NoError(t, os.MkdirAll(subDir, 0755))
+
+	var fileList []os.FileInfo
+	for _, name := range []string{"2023.07.14-beach.mp4", "Holiday 2023.07.15.mp4", "no-date.mp4"} {
+		filePath := filepath.Join(subDir, name)
+		require.NoError(t, os.WriteFile(filePath, nil, 0644))
+		fileList = append(fileList, pathFileInfo{path: filePath})
+	}
+
+	// execute
+	err := lintNames(newTestState(), fileList, true, formatJSON, false, false)
+
+	// assert
+	assert.ErrorContains(t, err, "violations: 1")
+	assert.FileExists(t, filepath.Join(subDir, "2023.07.14-beach.mp4"))
+	assert.FileExists(t, filepath.Join(subDir, "2023.07.15-holiday.mp4"))
+	assert.FileExists(t, filepath.Join(subDir, "no-date.mp4"))
+}
+
+func Test_lintNames_commandFails(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	policy := "naming:\n  allowedChars: a-z0-9.-\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, namingPolicyFileName), []byte(policy), 0644))
+
+	newDir := filepath.Join(dir, "new")
+	require.NoError(t, os.MkdirAll(newDir, 0755))
+	filePath := filepath.Join(newDir, "Holiday 2023.mp4")
+	require.NoError(t, os.WriteFile(filePath, []byte("foo"), 0644))
+
+	app := newApp()
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{
+			name: "lint-names",
+			args: []string{lintNamesCommand, filePath},
+			want: "violations: 1",
+		},
+		{
+			name: "check-new",
+			args: []string{checkNewCommand, dir, filePath},
+			want: "problems: 1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"ffr", "--" + rootFlag, dir, "--" + commandHistoryFlag + "=", "--" + journalFlag + "="}, tt.args...)
+
+			// execute
+			err := app.Run(args)
+
+			// assert
+			assert.ErrorContains(t, err, tt.want)
+		})
+	}
+}
+
+func Test_readNamingPolicy(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	path := filepath.Join(dir, namingPolicyFileName)
+	require.NoError(t, os.WriteFile(path, []byte("naming:\n  tokens: [date, title]\n"), 0644))
+
+	// execute
+	_, err := readNamingPolicy(path)
+
+	// assert
+	assert.ErrorContains(t, err, "invalid token")
+}
+
+func Test_canonicalName(t *testing.T) {
+	tests := []struct {
+		name     string
+		filePath string
+		want     string
+	}{
+		{
+			name:     "case, separators and extension are ignored",
+			filePath: "dl/Holiday  2023_07.MP4",
+			want:     "holiday-2023-07",
+		},
+		{
+			name:     "dashed names are kept",
+			filePath: "holiday-2023-07.mkv",
+			want:     "holiday-2023-07",
+		},
+		{
+			name:     "accented letters are kept",
+			filePath: "Árvíztűrő.mp4",
+			want:     "árvíztűrő",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got := canonicalName(tt.filePath)
+
+			// assert
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_updateDirIndex(t *testing.T) {
+	// setup
+	indexed, plain := t.TempDir(), t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(indexed, "foo.mp4"), nil, 0644))
+	index, err := buildDirIndex(indexed)
+	require.NoError(t, err)
+	require.NoError(t, writeDirIndex(indexed, index))
+
+	// execute
+	err = updateDirIndex(filepath.Join(indexed, "foo.mp4"), filepath.Join(plain, "foo.mp4"), false)
+	require.NoError(t, err)
+	err = updateDirIndex(filepath.Join(plain, "bar.mp4"), filepath.Join(indexed, "Bar Baz.mp4"), false)
+	require.NoError(t, err)
+
+	// assert
+	got, err := readDirIndex(indexed)
+	require.NoError(t, err)
+	assert.Equal(t, dirIndex{"bar-baz": "Bar Baz.mp4"}, got)
+	assert.NoFileExists(t, filepath.Join(plain, dirIndexFileName))
+}
+
+func Test_checkNewFiles(t *testing.T) {
+	// setup
+	dir, newDir := t.TempDir(), t.TempDir()
+	policy := "naming:\n  allowedChars: a-z0-9.-\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, namingPolicyFileName), []byte(policy), 0644))
+	for _, name := range []string{"holiday-2023.mp4", "taken.mp4"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0644))
+	}
+
+	var fileList []os.FileInfo
+	for _, name := range []string{"Holiday 2023.MP4", "taken.mp4", "beach.mp4", "beach.mkv", "new.mp4"} {
+		filePath := filepath.Join(newDir, name)
+		require.NoError(t, os.WriteFile(filePath, nil, 0644))
+		fileList = append(fileList, pathFileInfo{path: filePath})
+	}
+	fileList = append(fileList, pathFileInfo{path: filepath.Join(dir, "taken.mp4")})
+
+	want := []newFileProblem{
+		{File: filepath.Join(newDir, "Holiday 2023.MP4"), Problem: problemDuplicate, Message: fmt.Sprintf("same canonical name as %q", filepath.Join(dir, "holiday-2023.mp4"))},
+		{File: filepath.Join(newDir, "Holiday 2023.MP4"), Problem: problemPolicy, Message: `allowed-chars: disallowed characters: "H "`},
+		{File: filepath.Join(newDir, "taken.mp4"), Problem: problemCollision, Message: fmt.Sprintf("name is taken: %q", filepath.Join(dir, "taken.mp4"))},
+		{File: filepath.Join(newDir, "beach.mkv"), Problem: problemDuplicate, Message: fmt.Sprintf("same canonical name as the new file %q", filepath.Join(newDir, "beach.mp4"))},
+	}
+
+	// execute
+	got, err := checkNewFiles(newLogger(true), dir, fileList, true, formatJSON, false)
+
+	// assert
+	assert.ErrorContains(t, err, "problems: 4")
+	assert.Equal(t, want, got)
+	assert.FileExists(t, filepath.Join(dir, dirIndexFileName))
+}
+
+// exampleCalls returns the arguments of the ffr calls in an example, which can be a list or a pipeline of commands
+func exampleCalls(t *testing.T, command string) [][]string {
+	t.Helper()
+
+	f, err := syntax.NewParser().Parse(strings.NewReader(command), "")
+	require.NoError(t, err)
+
+	var calls [][]string
+	syntax.Walk(f, func(node syntax.Node) bool {
+		call, ok := node.(*syntax.CallExpr)
+		if !ok {
+			return true
+		}
+
+		args, err := expand.Fields(&expand.Config{}, call.Args...)
+		require.NoError(t, err)
+
+		if len(args) > 0 && args[0] == "ffr" {
+			calls = append(calls, args)
+		}
+
+		return true
+	})
+
+	return calls
+}
+
+func Test_commandExamples(t *testing.T) {
+	// setup
+	app := newApp()
+	app.ExitErrHandler = func(*cli.Context, error) {}
+
+	var ran []string
+	for _, cmd := range app.Commands {
+		cmd.Action = func(c *cli.Context) error {
+			ran = append(ran, c.Command.Name)
+
+			return nil
+		}
+	}
+
+	for name, examples := range commandExamples {
+		require.NotNil(t, app.Command(name), "examples of unknown command. command: %s", name)
+		require.NotEmpty(t, examples)
+
+		for _, e := range examples {
+			t.Run(e.Command, func(t *testing.T) {
+				assert.NotEmpty(t, e.Description)
+				assert.NotEmpty(t, e.Result)
+
+				calls := exampleCalls(t, e.Command)
+				require.NotEmpty(t, calls)
+
+				ran = nil
+
+				// execute
+				for _, args := range calls {
+					err := app.Run(reorderArgs(app, args))
+					require.NoError(t, err)
+				}
+
+				// assert
+				assert.Len(t, ran, len(calls))
+			})
+		}
+	}
+}
+
+func Test_commandExamples_results(t *testing.T) {
+	// the examples of the commands only renaming files are run and their results are looked for, the results of the
+	// other commands depend on the videos they are run on
+	commands := []string{addNumberCommand, deletePartsCommand, mergePartsCommand, normalizeNumbersCommand, normalizeUnicodeCommand, padNumbersCommand}
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+
+	for _, command := range commands {
+		for _, e := range commandExamples[command] {
+			t.Run(e.Command, func(t *testing.T) {
+				// setup
+				calls := exampleCalls(t, e.Command)
+				require.Len(t, calls, 1)
+				args := append([]string{"ffr", "--" + commandHistoryFlag + "=", "--" + journalFlag + "="}, calls[0][1:]...)
+
+				require.NoError(t, os.Chdir(t.TempDir()))
+				defer func() { require.NoError(t, os.Chdir(wd)) }()
+				require.NoError(t, os.WriteFile(args[len(args)-1], []byte("foo"), 0644))
+
+				app := newApp()
+
+				// execute
+				err := app.Run(reorderArgs(app, args))
+
+				// assert
+				require.NoError(t, err)
+				assert.FileExists(t, e.Result)
+			})
+		}
+	}
+}
+
+func Test_manPageNames(t *testing.T) {
+	app := newApp()
+
+	tests := []struct {
+		name     string
+		commands []string
+		all      bool
+		want     []string
+		wantErr  assert.ErrorAssertionFunc
+	}{
+		{
+			name:    "ffr itself by default",
+			want:    []string{"ffr.1"},
+			wantErr: assert.NoError,
+		},
+		{
+			name:     "commands by name or alias",
+			commands: []string{reencodeCommand, "a"},
+			want:     []string{"ffr-reencode.1", "ffr-add-number.1"},
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "unknown command",
+			commands: []string{"foo"},
+			wantErr:  assert.Error,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got, err := manPageNames(app, tt.commands, 1, tt.all)
+
+			// assert
+			tt.wantErr(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	t.Run("every page", func(t *testing.T) {
+		// execute
+		got, err := manPageNames(app, nil, 1, true)
+
+		// assert
+		require.NoError(t, err)
+		pages, err := manPages(app, 1)
+		require.NoError(t, err)
+		assert.Len(t, got, len(pages))
+		for _, name := range got {
+			assert.Contains(t, pages, name)
+		}
+	})
+}
+
+func Test_newApp_uniqueCommandNames(t *testing.T) {
+	app := newApp()
+
+	seen := map[string]string{}
+	for _, cmd := range app.Commands {
+		for _, name := range cmd.Names() {
+			other, ok := seen[name]
+			assert.False(t, ok, "name used by two commands. name: %s, commands: %s, %s", name, other, cmd.Name)
+			seen[name] = cmd.Name
+		}
+	}
+}
+
+func Test_formatExamples(t *testing.T) {
+	// setup
+	examples := []commandExample{
+		{Description: "Rate a file with 4 stars", Command: "ffr rate 4 foo.mp4", Result: "foo-r4.mp4"},
+		{Description: "Remove the rating", Command: "ffr rate 0 foo-r4.mp4", Result: "foo.mp4"},
+	}
+	want := `EXAMPLES:
+Description: Rate a file with 4 stars
+Command:     ffr rate 4 foo.mp4
+Result:      foo-r4.mp4
+
+Description: Remove the rating
+Command:     ffr rate 0 foo-r4.mp4
+Result:      foo.mp4`
+
+	// execute
+	got := formatExamples(examples)
+
+	// assert
+	assert.Equal(t, want, got)
+}
+
+func Test_manPages(t *testing.T) {
+	// setup
+	app := newApp()
+
+	// execute
+	got, err := manPages(app, 1)
+
+	// assert
+	require.NoError(t, err)
+	require.Contains(t, got, "ffr.1")
+	assert.Contains(t, got["ffr.1"], ".SH GLOBAL OPTIONS")
+
+	page, ok := got["ffr-reencode.1"]
+	require.True(t, ok)
+	assert.Contains(t, page, ".TH ffr-reencode 1")
+	assert.Contains(t, page, ".SH OPTIONS")
+	assert.NotContains(t, page, ".SH GLOBAL OPTIONS")
+	assert.Contains(t, page, ".SH EXAMPLES")
+	assert.Contains(t, page, commandExamples[reencodeCommand][0].Command)
+	assert.NotContains(t, page, "Description: ")
+}
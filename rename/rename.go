@@ -0,0 +1,914 @@
+// Package rename computes the new names of files for the renaming commands of ffr without touching the file system,
+// so that the naming logic can be validated and reused by other tools.
+package rename
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/unicode/norm"
+)
+
+const separator = "-"
+
+// Spec is a renaming operation
+type Spec interface {
+	// Apply returns the new file name for the base name and extension of a file
+	Apply(base, ext string) (string, error)
+}
+
+// Preview returns the new path of the file at filePath, the directory of the file is kept
+func Preview(spec Spec, filePath string) (string, error) {
+	base := filepath.Base(filePath)
+	ext := filepath.Ext(filePath)
+	if ext != "" {
+		base = base[:len(base)-len(ext)]
+	}
+
+	newName, err := spec.Apply(base, ext)
+	if err != nil {
+		return "", err
+	}
+
+	if strings.Trim(strings.TrimSuffix(newName, ext), separator) == "" || newName == "." || newName == ".." {
+		return "", fmt.Errorf("renaming would result in an empty file name. file: %q", filePath)
+	}
+
+	return filepath.Join(filepath.Dir(filePath), newName), nil
+}
+
+// Prefix inserts Text as a new dash-separated part after the first Skip parts
+type Prefix struct {
+	Text string
+	Skip int
+}
+
+func (p Prefix) Apply(base, ext string) (string, error) {
+	parts := splitParts(base)
+	if p.Skip < 0 || p.Skip > len(parts) {
+		return "", fmt.Errorf("more to skip then parts present. file: %q skip: %d, parts: %d", base, p.Skip, len(parts))
+	}
+
+	return concat(parts, p.Skip, p.Text, ext, separator)
+}
+
+// Suffix inserts Text as a new dash-separated part before the last Skip parts
+type Suffix struct {
+	Text string
+	Skip int
+}
+
+func (s Suffix) Apply(base, ext string) (string, error) {
+	parts := splitParts(base)
+	if s.Skip < 0 || s.Skip > len(parts) {
+		return "", fmt.Errorf("more to skip then parts present. file: %q skip: %d, parts: %d", base, s.Skip, len(parts))
+	}
+
+	return concat(parts, len(parts)-s.Skip, s.Text, ext, separator)
+}
+
+// Replace replaces the occurrence of Search after the first Skip ones with With, names not containing Search are
+// kept as they are
+type Replace struct {
+	Search string
+	With   string
+	Skip   int
+}
+
+func (r Replace) Apply(base, ext string) (string, error) {
+	parts := strings.Split(base, r.Search)
+	if r.Skip < 0 || r.Skip > len(parts)-1 {
+		return "", fmt.Errorf("more to skip than found occurances. file: %q, skip: %d, found: %d", base, r.Skip, len(parts)-1)
+	}
+
+	if len(parts) <= 1 {
+		return base + ext, nil
+	}
+
+	start := strings.Join(parts[:r.Skip+1], r.Search)
+	end := strings.Join(parts[r.Skip+1:], r.Search)
+
+	return start + r.With + end + ext, nil
+}
+
+// DeleteParts deletes the dash-separated parts at the 1-based positions of Parts, counted from the end if FromBack
+// is set
+type DeleteParts struct {
+	Parts    []int
+	FromBack bool
+}
+
+func (d DeleteParts) Apply(base, ext string) (string, error) {
+	parts := strings.Split(base, separator)
+
+	m := make(map[int]struct{}, len(d.Parts))
+	for _, p := range d.Parts {
+		p2 := p - 1
+		if d.FromBack {
+			p2 = len(parts) - p
+		}
+		m[p2] = struct{}{}
+	}
+
+	newParts := make([]string, 0, len(parts))
+	for i := 0; i < len(parts); i++ {
+		if _, ok := m[i]; !ok {
+			newParts = append(newParts, parts[i])
+		}
+	}
+
+	return strings.Join(newParts, separator) + ext, nil
+}
+
+// numericTagRegexp matches numeric tags, dash-separated parts made of a number and a lowercase tag, e.g. "2ffc" or
+// "1.5h". At most two digits are allowed before the decimal point so that resolutions (1080p) and years are not tags,
+// and at most six after it so that sums can not overflow.
+var numericTagRegexp = regexp.MustCompile(`^(\d{1,2}(?:\.\d{1,6})?)([a-z]+)$`)
+
+// timeUnits are the tags summed as durations if units are enabled, in seconds
+var timeUnits = map[string]int64{
+	"h": 3600,
+	"m": 60,
+	"s": 1,
+}
+
+// MergeParts sums the numbers of numeric tags, e.g. "foo-1bar-2baz" becomes "foo-3bar-baz".
+//
+//   - Only the tags listed in Tags or fully matching Regexp are summed, all tags are summed if neither is set. Parts
+//     with more than two digits before the decimal point, e.g. "1080p", are not numeric tags.
+//   - The sum takes the place of the first summed tag, the tags of the other summed parts are kept without their
+//     numbers right after it, in the order they appeared. Other parts keep their places.
+//   - Decimal numbers are summed exactly, the sum has as many decimals as the most precise number summed.
+//   - If Units is set, the time units h, m and s are summed separately as a duration, written in the largest unit in
+//     which the duration has at most two decimals, e.g. "1.5h-30m" becomes "2h" and "1h-20m" becomes "80m".
+//
+// The first occurrence of DeleteText is removed from the result. Names without numeric tags to sum are kept as they are.
+type MergeParts struct {
+	Regexp     string
+	Tags       []string
+	Units      bool
+	DeleteText string
+}
+
+// numericTag is a numeric tag, its number is scaled to an integer by 10^decimals to be summed exactly
+type numericTag struct {
+	index    int
+	scaled   int64
+	decimals int
+	tag      string
+}
+
+func parseNumericTag(index int, part string) (numericTag, bool) {
+	match := numericTagRegexp.FindStringSubmatch(part)
+	if match == nil {
+		return numericTag{}, false
+	}
+
+	digits, decimals := match[1], 0
+	if i := strings.Index(digits, "."); i >= 0 {
+		decimals = len(digits) - i - 1
+		digits = digits[:i] + digits[i+1:]
+	}
+
+	scaled, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return numericTag{}, false
+	}
+
+	return numericTag{index: index, scaled: scaled, decimals: decimals, tag: match[2]}, true
+}
+
+// formatScaled formats an integer scaled by 10^decimals as a decimal number
+func formatScaled(scaled int64, decimals int) string {
+	s := strconv.FormatInt(scaled, 10)
+	if decimals == 0 {
+		return s
+	}
+
+	if len(s) <= decimals {
+		s = strings.Repeat("0", decimals-len(s)+1) + s
+	}
+
+	return s[:len(s)-decimals] + "." + s[len(s)-decimals:]
+}
+
+func pow10(n int) int64 {
+	result := int64(1)
+	for i := 0; i < n; i++ {
+		result *= 10
+	}
+
+	return result
+}
+
+// sumNumbers returns the sum of the numbers of tags and the number of decimals it has
+func sumNumbers(tags []numericTag) (int64, int) {
+	decimals := 0
+	for _, t := range tags {
+		if t.decimals > decimals {
+			decimals = t.decimals
+		}
+	}
+
+	var sum int64
+	for _, t := range tags {
+		sum += t.scaled * pow10(decimals-t.decimals)
+	}
+
+	return sum, decimals
+}
+
+// sumDuration sums time unit tags and returns the merged part
+func sumDuration(tags []numericTag) string {
+	const maxDecimals = 2
+
+	// durations are summed in seconds scaled by 10^decimals to stay exact
+	decimals := 0
+	for _, t := range tags {
+		if t.decimals > decimals {
+			decimals = t.decimals
+		}
+	}
+
+	var total int64
+	for _, t := range tags {
+		total += t.scaled * pow10(decimals-t.decimals) * timeUnits[t.tag]
+	}
+
+	units := make([]string, 0, len(tags))
+	for _, t := range tags {
+		units = append(units, t.tag)
+	}
+	sort.Slice(units, func(i, j int) bool {
+		return timeUnits[units[i]] > timeUnits[units[j]]
+	})
+
+	for _, unit := range units {
+		// the duration in unit scaled by 10^maxDecimals, if exact
+		scaled := total * pow10(maxDecimals)
+		divisor := timeUnits[unit] * pow10(decimals)
+		if scaled%divisor != 0 {
+			continue
+		}
+
+		value := scaled / divisor
+		valueDecimals := maxDecimals
+		for valueDecimals > 0 && value%10 == 0 {
+			value /= 10
+			valueDecimals--
+		}
+
+		return formatScaled(value, valueDecimals) + unit
+	}
+
+	smallest := units[len(units)-1]
+
+	return formatScaled(total/timeUnits[smallest], decimals) + smallest
+}
+
+func (m MergeParts) selector() (func(tag string) bool, error) {
+	if m.Regexp != "" && len(m.Tags) > 0 {
+		return nil, errors.New("tags and regular expression can not be used together")
+	}
+
+	if len(m.Tags) > 0 {
+		tags := make(map[string]struct{}, len(m.Tags))
+		for _, tag := range m.Tags {
+			tags[tag] = struct{}{}
+		}
+
+		return func(tag string) bool {
+			_, ok := tags[tag]
+
+			return ok
+		}, nil
+	}
+
+	if m.Regexp != "" {
+		r, err := regexp.Compile(`^(?:` + m.Regexp + `)$`)
+		if err != nil {
+			return nil, err
+		}
+
+		return r.MatchString, nil
+	}
+
+	return func(string) bool { return true }, nil
+}
+
+func (m MergeParts) Apply(base, ext string) (string, error) {
+	selected, err := m.selector()
+	if err != nil {
+		return "", err
+	}
+
+	parts := splitParts(base)
+
+	var numbers, durations []numericTag
+	for i, part := range parts {
+		t, ok := parseNumericTag(i, part)
+		if !ok || !selected(t.tag) {
+			continue
+		}
+
+		if _, isUnit := timeUnits[t.tag]; m.Units && isUnit {
+			durations = append(durations, t)
+		} else {
+			numbers = append(numbers, t)
+		}
+	}
+
+	if len(numbers) == 0 && len(durations) == 0 {
+		return base + ext, nil
+	}
+
+	// replacements maps the index of each summed part to the parts replacing it
+	replacements := map[int][]string{}
+
+	if len(numbers) > 0 {
+		sum, decimals := sumNumbers(numbers)
+
+		merged := []string{formatScaled(sum, decimals) + numbers[0].tag}
+		for _, t := range numbers[1:] {
+			merged = append(merged, t.tag)
+			replacements[t.index] = nil
+		}
+		replacements[numbers[0].index] = merged
+	}
+
+	if len(durations) > 0 {
+		for _, t := range durations[1:] {
+			replacements[t.index] = nil
+		}
+		replacements[durations[0].index] = []string{sumDuration(durations)}
+	}
+
+	newParts := make([]string, 0, len(parts))
+	for i, part := range parts {
+		if replacement, ok := replacements[i]; ok {
+			newParts = append(newParts, replacement...)
+
+			continue
+		}
+
+		newParts = append(newParts, part)
+	}
+
+	newBase := strings.Join(newParts, separator)
+	if m.DeleteText != "" {
+		newBase = strings.Replace(newBase, m.DeleteText, "", 1)
+	}
+
+	return newBase + ext, nil
+}
+
+// DeleteRegexp deletes the matches of Regexp (or of its RegexpGroup-th group) after the first SkipFinds ones, at most
+// MaxCount of them if MaxCount is positive. Separators left at the beginning or the end of the name are removed.
+type DeleteRegexp struct {
+	Regexp      string
+	RegexpGroup int
+	SkipFinds   int
+	MaxCount    int
+}
+
+func (d DeleteRegexp) Apply(base, ext string) (string, error) {
+	regularExpression := d.Regexp
+	if regularExpression == "" {
+		regularExpression = `-\d+[a-z]+`
+	}
+
+	r, err := regexp.Compile(regularExpression)
+	if err != nil {
+		return "", err
+	}
+
+	if d.RegexpGroup < 0 || d.RegexpGroup > r.NumSubexp() {
+		return "", fmt.Errorf("invalid regexp group. group: %d, groups: %d", d.RegexpGroup, r.NumSubexp())
+	}
+
+	matches := r.FindAllStringSubmatchIndex(base, -1)
+	if len(matches) == 0 {
+		return "", errors.New("no matches")
+	}
+
+	if d.SkipFinds < 0 || d.SkipFinds > len(matches) {
+		return "", fmt.Errorf("more to skip than found matches. file: %q, skip: %d, found: %d", base, d.SkipFinds, len(matches))
+	}
+
+	matches = matches[d.SkipFinds:]
+	if d.MaxCount > 0 && len(matches) > d.MaxCount {
+		matches = matches[:d.MaxCount]
+	}
+
+	// matches are removed from the back so that the indexes of the previous ones stay valid
+	for i := len(matches) - 1; i >= 0; i-- {
+		start, end := matches[i][2*d.RegexpGroup], matches[i][2*d.RegexpGroup+1]
+		if start < 0 {
+			continue
+		}
+
+		base = base[:start] + base[end:]
+	}
+
+	return strings.Trim(base, separator) + ext, nil
+}
+
+// NumberOp is the operation EditNumber applies to the numbers found
+type NumberOp int
+
+const (
+	// Add adds the operand to the numbers found
+	Add NumberOp = iota
+	// Multiply multiplies the numbers found by the operand
+	Multiply
+	// Set replaces the numbers found with the operand
+	Set
+)
+
+var (
+	numberRegexp  = regexp.MustCompile(`\d+(?:\.\d+)?`)
+	operandRegexp = regexp.MustCompile(`^[-+]?\d+(?:\.\d+)?$`)
+)
+
+// maxNumberDigits keeps the numbers edited and their results within the range of int64
+const maxNumberDigits = 9
+
+// decimal is a number scaled to an integer by 10^decimals so that it is computed exactly
+type decimal struct {
+	scaled   int64
+	decimals int
+}
+
+func parseDecimal(s string) (decimal, error) {
+	negative := strings.HasPrefix(s, "-")
+	digits := strings.TrimLeft(s, "-+")
+
+	decimals := 0
+	if i := strings.Index(digits, "."); i >= 0 {
+		decimals = len(digits) - i - 1
+		digits = digits[:i] + digits[i+1:]
+	}
+
+	if len(strings.TrimLeft(digits, "0")) > maxNumberDigits || decimals > maxNumberDigits {
+		return decimal{}, fmt.Errorf("number is too long. number: %s", s)
+	}
+
+	scaled, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return decimal{}, fmt.Errorf("invalid number. number: %s, err: %w", s, err)
+	}
+
+	if negative {
+		scaled = -scaled
+	}
+
+	return decimal{scaled: scaled, decimals: decimals}, nil
+}
+
+// rescale returns d with at least the given decimals
+func (d decimal) rescale(decimals int) decimal {
+	if decimals <= d.decimals {
+		return d
+	}
+
+	return decimal{scaled: d.scaled * pow10(decimals-d.decimals), decimals: decimals}
+}
+
+// trim removes trailing zero decimals, keeping at least minDecimals
+func (d decimal) trim(minDecimals int) decimal {
+	for d.decimals > minDecimals && d.scaled%10 == 0 {
+		d.scaled /= 10
+		d.decimals--
+	}
+
+	return d
+}
+
+// EditNumber changes the numbers of the matches of Regexp (or of its RegexpGroup-th group) after the first SkipFinds
+// ones, at most MaxCount of them if MaxCount is positive. The first number in each match is changed by Op and
+// Operand, e.g. "foo-1bar" becomes "foo-3bar" when adding 2.
+//
+// Results are formatted like the numbers they replace: zero-padding is kept ("007" plus 2 is "009"), and so are the
+// decimals ("1.50" plus 1 is "2.50"), more decimals are only used if the result needs them ("1.5" times 1.25 is
+// "1.875"). Negative results are errors as the minus sign would read as a separator.
+type EditNumber struct {
+	Regexp      string
+	RegexpGroup int
+	SkipFinds   int
+	MaxCount    int
+	Op          NumberOp
+	Operand     string
+}
+
+func (e EditNumber) apply(number, operand decimal) decimal {
+	minDecimals := number.decimals
+
+	switch e.Op {
+	case Multiply:
+		number = decimal{scaled: number.scaled * operand.scaled, decimals: number.decimals + operand.decimals}
+	case Set:
+		number = operand
+	default:
+		decimals := number.decimals
+		if operand.decimals > decimals {
+			decimals = operand.decimals
+		}
+
+		number = decimal{scaled: number.rescale(decimals).scaled + operand.rescale(decimals).scaled, decimals: decimals}
+	}
+
+	return number.trim(minDecimals).rescale(minDecimals)
+}
+
+// format formats d like the number it replaces, keeping its zero-padding
+func (d decimal) format(original string) string {
+	result := formatScaled(d.scaled, d.decimals)
+
+	width := len(original)
+	if i := strings.Index(original, "."); i >= 0 {
+		width = i
+	}
+
+	if width < 2 || original[0] != '0' {
+		return result
+	}
+
+	integerPart := len(result)
+	if i := strings.Index(result, "."); i >= 0 {
+		integerPart = i
+	}
+
+	if integerPart >= width {
+		return result
+	}
+
+	return strings.Repeat("0", width-integerPart) + result
+}
+
+func (e EditNumber) Apply(base, ext string) (string, error) {
+	regularExpression, regexpGroup := e.Regexp, e.RegexpGroup
+	if regularExpression == "" {
+		regularExpression = `-(\d+(?:\.\d+)?)[a-z]+`
+		regexpGroup = 1
+	}
+
+	if !operandRegexp.MatchString(e.Operand) {
+		return "", fmt.Errorf("invalid number. number: %q", e.Operand)
+	}
+
+	operand, err := parseDecimal(e.Operand)
+	if err != nil {
+		return "", err
+	}
+
+	if e.Op == Set && operand.scaled < 0 {
+		return "", fmt.Errorf("number can not be negative. number: %s", e.Operand)
+	}
+
+	r, err := regexp.Compile(regularExpression)
+	if err != nil {
+		return "", err
+	}
+
+	if regexpGroup < 0 || regexpGroup > r.NumSubexp() {
+		return "", fmt.Errorf("invalid regexp group. group: %d, groups: %d", regexpGroup, r.NumSubexp())
+	}
+
+	matches := r.FindAllStringSubmatchIndex(base, -1)
+	if len(matches) == 0 {
+		return "", errors.New("no matches")
+	}
+
+	if e.SkipFinds < 0 || e.SkipFinds > len(matches) {
+		return "", fmt.Errorf("more to skip than found matches. file: %q, skip: %d, found: %d", base, e.SkipFinds, len(matches))
+	}
+
+	matches = matches[e.SkipFinds:]
+	if e.MaxCount > 0 && len(matches) > e.MaxCount {
+		matches = matches[:e.MaxCount]
+	}
+
+	// matches are replaced from the back so that the indexes of the previous ones stay valid
+	for i := len(matches) - 1; i >= 0; i-- {
+		start, end := matches[i][2*regexpGroup], matches[i][2*regexpGroup+1]
+		if start < 0 {
+			continue
+		}
+
+		loc := numberRegexp.FindStringIndex(base[start:end])
+		if loc == nil {
+			return "", fmt.Errorf("no number in match. match: %q", base[start:end])
+		}
+		start, end = start+loc[0], start+loc[1]
+
+		number, err := parseDecimal(base[start:end])
+		if err != nil {
+			return "", err
+		}
+
+		result := e.apply(number, operand)
+		if result.scaled < 0 {
+			return "", fmt.Errorf("result would be negative. number: %s, result: -%s", base[start:end], formatScaled(-result.scaled, result.decimals))
+		}
+
+		base = base[:start] + result.format(base[start:end]) + base[end:]
+	}
+
+	return base + ext, nil
+}
+
+// wordRegexp matches runs of letters, numbers written in words or roman numerals are only converted if they make up a
+// whole run, e.g. the "two" in "network" is kept
+var wordRegexp = regexp.MustCompile(`[A-Za-z]+`)
+
+// romanRegexp matches roman numerals written in the canonical, subtractive form, e.g. IV but not IIII
+var romanRegexp = regexp.MustCompile(`^M{0,3}(CM|CD|D?C{0,3})(XC|XL|L?X{0,3})(IX|IV|V?I{0,3})$`)
+
+var romanValues = map[byte]int{'I': 1, 'V': 5, 'X': 10, 'L': 50, 'C': 100, 'D': 500, 'M': 1000}
+
+// numberWords are the numbers converted from words, matched case-insensitively
+var numberWords = map[string]int{
+	"zero": 0, "one": 1, "two": 2, "three": 3, "four": 4, "five": 5, "six": 6, "seven": 7, "eight": 8, "nine": 9,
+	"ten": 10, "eleven": 11, "twelve": 12, "thirteen": 13, "fourteen": 14, "fifteen": 15, "sixteen": 16,
+	"seventeen": 17, "eighteen": 18, "nineteen": 19, "twenty": 20, "thirty": 30, "forty": 40, "fifty": 50,
+	"sixty": 60, "seventy": 70, "eighty": 80, "ninety": 90,
+}
+
+// parseRoman returns the value of an uppercase roman numeral
+func parseRoman(s string) (int, bool) {
+	if s == "" || !romanRegexp.MatchString(s) {
+		return 0, false
+	}
+
+	total := 0
+	for i := 0; i < len(s); i++ {
+		value := romanValues[s[i]]
+		if i+1 < len(s) && value < romanValues[s[i+1]] {
+			total -= value
+		} else {
+			total += value
+		}
+	}
+
+	return total, true
+}
+
+// NormalizeNumbers converts roman numerals (e.g. "II") and numbers written in words (e.g. "two") into digits, zero-padded
+// to Padding digits. Roman numerals must be uppercase and at most MaxRoman, as larger ones are more likely to be words
+// or sizes, e.g. "MIX" or "XL". Numbers in words are converted from zero to twenty and the tens up to ninety.
+type NormalizeNumbers struct {
+	Padding  int
+	MaxRoman int
+	NoRoman  bool
+	NoWords  bool
+}
+
+func (n NormalizeNumbers) Apply(base, ext string) (string, error) {
+	if n.Padding < 0 {
+		return "", fmt.Errorf("invalid padding. padding: %d", n.Padding)
+	}
+
+	newBase := wordRegexp.ReplaceAllStringFunc(base, func(word string) string {
+		value, ok := numberWords[strings.ToLower(word)]
+		if !ok || n.NoWords {
+			value, ok = parseRoman(word)
+			if !ok || n.NoRoman || value > n.MaxRoman {
+				return word
+			}
+		}
+
+		return fmt.Sprintf("%0*d", n.Padding, value)
+	})
+
+	return newBase + ext, nil
+}
+
+// PadNumbers zero-pads the numbers in a name to Width digits, e.g. "foo-e1" becomes "foo-e01" for a width of 2, so that
+// names sort the same lexicographically and naturally. Only the integer part of decimal numbers is padded and longer
+// numbers are kept as they are.
+//
+// If Regexp is set, only the numbers within its matches (or within its RegexpGroup-th group) are padded. If Position is
+// set, only the Position-th of these numbers is padded, counted from the end if FromBack is set.
+type PadNumbers struct {
+	Width       int
+	Regexp      string
+	RegexpGroup int
+	Position    int
+	FromBack    bool
+}
+
+// spans returns the parts of base in which numbers are padded
+func (p PadNumbers) spans(base string) ([][2]int, error) {
+	if p.Regexp == "" {
+		return [][2]int{{0, len(base)}}, nil
+	}
+
+	r, err := regexp.Compile(p.Regexp)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.RegexpGroup < 0 || p.RegexpGroup > r.NumSubexp() {
+		return nil, fmt.Errorf("invalid regexp group. group: %d, groups: %d", p.RegexpGroup, r.NumSubexp())
+	}
+
+	var spans [][2]int
+	for _, match := range r.FindAllStringSubmatchIndex(base, -1) {
+		start, end := match[2*p.RegexpGroup], match[2*p.RegexpGroup+1]
+		if start >= 0 {
+			spans = append(spans, [2]int{start, end})
+		}
+	}
+
+	return spans, nil
+}
+
+func (p PadNumbers) Apply(base, ext string) (string, error) {
+	if p.Width < 1 {
+		return "", fmt.Errorf("invalid width. width: %d", p.Width)
+	}
+
+	if p.Position < 0 {
+		return "", fmt.Errorf("invalid position. position: %d", p.Position)
+	}
+
+	spans, err := p.spans(base)
+	if err != nil {
+		return "", err
+	}
+
+	// the start and end of the integer part of each number found
+	var numbers [][2]int
+	for _, span := range spans {
+		for _, loc := range numberRegexp.FindAllStringIndex(base[span[0]:span[1]], -1) {
+			end := loc[1]
+			if i := strings.Index(base[span[0]+loc[0]:span[0]+loc[1]], "."); i >= 0 {
+				end = loc[0] + i
+			}
+
+			numbers = append(numbers, [2]int{span[0] + loc[0], span[0] + end})
+		}
+	}
+
+	if p.Position > 0 {
+		if p.Position > len(numbers) {
+			return base + ext, nil
+		}
+
+		i := p.Position - 1
+		if p.FromBack {
+			i = len(numbers) - p.Position
+		}
+		numbers = numbers[i : i+1]
+	}
+
+	// numbers are padded from the back so that the indexes of the previous ones stay valid
+	for i := len(numbers) - 1; i >= 0; i-- {
+		start, end := numbers[i][0], numbers[i][1]
+		if end-start < p.Width {
+			base = base[:start] + strings.Repeat("0", p.Width-(end-start)) + base[start:]
+		}
+	}
+
+	return base + ext, nil
+}
+
+// Unicode normalization forms, see https://unicode.org/reports/tr15/
+const (
+	// NFC composes characters, e.g. "é" is stored as one code point, as on Linux and Windows
+	NFC = "nfc"
+	// NFD decomposes characters, e.g. "é" is stored as "e" and a combining accent, as on macOS
+	NFD = "nfd"
+)
+
+// asciiLetters are the transliterations of letters which do not decompose into an ASCII letter and accents
+var asciiLetters = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'Æ': "AE", 'œ': "oe", 'Œ': "OE", 'ø': "o", 'Ø': "O", 'đ': "d", 'Đ': "D", 'ł': "l",
+	'Ł': "L", 'þ': "th", 'Þ': "Th", 'ð': "d", 'Ð': "D", 'ı': "i", '‘': "'", '’': "'", '“': `"`, '”': `"`,
+	'–': "-", '—': "-", '…': "...",
+}
+
+// windows1252Bytes maps the characters of Windows-1252 and Latin-1 back to their bytes, bytes undefined in
+// Windows-1252 are mapped as in Latin-1 as most decoders do
+var windows1252Bytes = func() map[rune]byte {
+	m := make(map[rune]byte, 256+32)
+	for i := 0; i < 256; i++ {
+		b := byte(i)
+		m[rune(b)] = b
+
+		if r := charmap.Windows1252.DecodeByte(b); r != utf8.RuneError {
+			m[r] = b
+		}
+	}
+
+	return m
+}()
+
+// fixMojibake reverts UTF-8 text decoded as Windows-1252 or Latin-1, e.g. "JosÃ©" becomes "José". Text is only changed
+// if all of its characters map back to bytes which form valid UTF-8 with at least one multi-byte character, so
+// correctly encoded text is left alone. Text encoded badly several times is fixed repeatedly.
+func fixMojibake(s string) string {
+	for i := 0; i < 3; i++ {
+		raw := make([]byte, 0, len(s))
+		for _, r := range s {
+			b, ok := windows1252Bytes[r]
+			if !ok {
+				return s
+			}
+			raw = append(raw, b)
+		}
+
+		fixed := string(raw)
+		if fixed == s || !utf8.ValidString(fixed) {
+			return s
+		}
+
+		s = fixed
+	}
+
+	return s
+}
+
+// toASCII transliterates s to ASCII, dropping accents, characters without an ASCII equivalent, e.g. CJK, are kept
+func toASCII(s string) string {
+	sb := strings.Builder{}
+	for _, r := range norm.NFD.String(s) {
+		switch {
+		case r < utf8.RuneSelf:
+			sb.WriteRune(r)
+		case unicode.Is(unicode.Mn, r):
+		case asciiLetters[r] != "":
+			sb.WriteString(asciiLetters[r])
+		default:
+			sb.WriteRune(r)
+		}
+	}
+
+	return norm.NFC.String(sb.String())
+}
+
+// NormalizeUnicode fixes mojibake in a name unless NoMojibake is set, transliterates it to ASCII if ASCII is set, and
+// normalizes it to Form, NFC by default. The extension is changed too.
+type NormalizeUnicode struct {
+	Form       string
+	ASCII      bool
+	NoMojibake bool
+}
+
+func (n NormalizeUnicode) Apply(base, ext string) (string, error) {
+	name := base + ext
+
+	if !n.NoMojibake {
+		name = fixMojibake(name)
+	}
+
+	if n.ASCII {
+		name = toASCII(name)
+	}
+
+	switch n.Form {
+	case "", NFC:
+		name = norm.NFC.String(name)
+	case NFD:
+		name = norm.NFD.String(name)
+	default:
+		return "", fmt.Errorf("invalid normalization form. form: %s", n.Form)
+	}
+
+	return name, nil
+}
+
+// splitParts returns the dash-separated parts of base, an empty base has no parts
+func splitParts(base string) []string {
+	if base == "" {
+		return nil
+	}
+
+	return strings.Split(base, separator)
+}
+
+func concat(parts []string, skip int, newPart, ext, separator string) (string, error) {
+	if skip < 0 || skip > len(parts) {
+		return "", fmt.Errorf("invalid number of parts to skip. parts: %d, skip: %d", len(parts), skip)
+	}
+
+	start := strings.Join(parts[:skip], separator)
+	if skip > 0 {
+		start += separator
+	}
+
+	end := strings.Join(parts[skip:], separator)
+	if skip < len(parts) {
+		end = separator + end
+	}
+
+	return start + newPart + end + ext, nil
+}
@@ -0,0 +1,838 @@
+package rename
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_concat(t *testing.T) {
+	type args struct {
+		parts     []string
+		skip      int
+		newPart   string
+		ext       string
+		separator string
+	}
+
+	errorTests := []struct {
+		name string
+		args args
+	}{
+		{
+			name: "empty-parts",
+			args: args{
+				parts:     []string{},
+				skip:      1,
+				newPart:   "quix",
+				ext:       ".txt",
+				separator: "-",
+			},
+		},
+		{
+			name: "non-empty-parts",
+			args: args{
+				parts:     []string{"foo", "bar", "baz"},
+				skip:      4,
+				newPart:   "quix",
+				ext:       ".txt",
+				separator: "-",
+			},
+		},
+		{
+			name: "negative-skip",
+			args: args{
+				parts:     []string{"foo", "bar", "baz"},
+				skip:      -1,
+				newPart:   "quix",
+				ext:       ".txt",
+				separator: "-",
+			},
+		},
+	}
+
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := concat(tt.args.parts, tt.args.skip, tt.args.newPart, tt.args.ext, tt.args.separator)
+			assert.Error(t, err)
+		})
+	}
+
+	successTests := []struct {
+		name string
+		args args
+		want string
+	}{
+		{
+			name: "no skip",
+			args: args{
+				parts:     []string{"foo", "bar", "baz"},
+				skip:      0,
+				newPart:   "quix",
+				ext:       ".txt",
+				separator: "-",
+			},
+			want: "quix-foo-bar-baz.txt",
+		},
+		{
+			name: "skip to middle",
+			args: args{
+				parts:     []string{"foo", "bar", "baz"},
+				skip:      2,
+				newPart:   "quix",
+				ext:       ".txt",
+				separator: "-",
+			},
+			want: "foo-bar-quix-baz.txt",
+		},
+		{
+			name: "skip to last",
+			args: args{
+				parts:     []string{"foo", "bar", "baz"},
+				skip:      3,
+				newPart:   "quix",
+				ext:       ".txt",
+				separator: "-",
+			},
+			want: "foo-bar-baz-quix.txt",
+		},
+	}
+	for _, tt := range successTests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := concat(tt.args.parts, tt.args.skip, tt.args.newPart, tt.args.ext, tt.args.separator)
+			if err != nil {
+				t.Errorf("concat() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("concat() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreview(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     Spec
+		filePath string
+		want     string
+		wantErr  assert.ErrorAssertionFunc
+	}{
+		{
+			name:     "prefix",
+			spec:     Prefix{Text: "quix"},
+			filePath: "dir/foo-bar.mp4",
+			want:     "dir/quix-foo-bar.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "prefix with skip",
+			spec:     Prefix{Text: "quix", Skip: 1},
+			filePath: "foo-bar.mp4",
+			want:     "foo-quix-bar.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "prefix skipping too many parts",
+			spec:     Prefix{Text: "quix", Skip: 3},
+			filePath: "foo-bar.mp4",
+			wantErr:  assert.Error,
+		},
+		{
+			name:     "suffix",
+			spec:     Suffix{Text: "quix"},
+			filePath: "foo-bar.mp4",
+			want:     "foo-bar-quix.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "suffix with skip",
+			spec:     Suffix{Text: "quix", Skip: 1},
+			filePath: "foo-bar.mp4",
+			want:     "foo-quix-bar.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "replace",
+			spec:     Replace{Search: "bar", With: "baz", Skip: 1},
+			filePath: "bar-foo-bar.mp4",
+			want:     "bar-foo-baz.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "replace without occurrence",
+			spec:     Replace{Search: "qux", With: "baz"},
+			filePath: "foo-bar.mp4",
+			want:     "foo-bar.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "replace skipping too many occurrences",
+			spec:     Replace{Search: "bar", With: "baz", Skip: 2},
+			filePath: "foo-bar.mp4",
+			wantErr:  assert.Error,
+		},
+		{
+			name:     "delete parts",
+			spec:     DeleteParts{Parts: []int{1, 3}},
+			filePath: "foo-bar-baz-quix.mp4",
+			want:     "bar-quix.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "delete parts from back",
+			spec:     DeleteParts{Parts: []int{1}, FromBack: true},
+			filePath: "foo-bar-baz.mp4",
+			want:     "foo-bar.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "merge parts",
+			spec:     MergeParts{},
+			filePath: "foo-1a-2b.mp4",
+			want:     "foo-3a-b.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "merge parts in the middle",
+			spec:     MergeParts{},
+			filePath: "foo-1a-BAR-2b.mp4",
+			want:     "foo-3a-b-BAR.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "merge parts without matches",
+			spec:     MergeParts{},
+			filePath: "foo.mp4",
+			want:     "foo.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "delete regexp",
+			spec:     DeleteRegexp{SkipFinds: 1},
+			filePath: "foo-1bar-BAZ-1bar.mp4",
+			want:     "foo-1bar-BAZ.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "delete regexp trims separators",
+			spec:     DeleteRegexp{Regexp: "foo"},
+			filePath: "foo-bar.mp4",
+			want:     "bar.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "delete regexp skipping too many matches",
+			spec:     DeleteRegexp{SkipFinds: 2},
+			filePath: "foo-1bar.mp4",
+			wantErr:  assert.Error,
+		},
+		{
+			name:     "delete regexp with invalid group",
+			spec:     DeleteRegexp{RegexpGroup: 1},
+			filePath: "foo-1bar.mp4",
+			wantErr:  assert.Error,
+		},
+		{
+			name:     "prefix with negative skip",
+			spec:     Prefix{Text: "quix", Skip: -1},
+			filePath: "foo-bar.mp4",
+			wantErr:  assert.Error,
+		},
+		{
+			name:     "prefix of a name with an empty part",
+			spec:     Prefix{Text: "quix", Skip: 1},
+			filePath: "-foo.mp4",
+			want:     "-quix-foo.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "empty result",
+			spec:     DeleteParts{Parts: []int{1}},
+			filePath: "foo.mp4",
+			wantErr:  assert.Error,
+		},
+		{
+			name:     "merge parts deleting text",
+			spec:     MergeParts{DeleteText: "-b"},
+			filePath: "foo-1a-2b.mp4",
+			want:     "foo-3a.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "edit number",
+			spec:     EditNumber{Operand: "2"},
+			filePath: "foo-1bar-2baz.mp4",
+			want:     "foo-3bar-4baz.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "edit number keeps zero-padding",
+			spec:     EditNumber{Regexp: `e(\d+)`, RegexpGroup: 1, Operand: "2"},
+			filePath: "foo-e007.mp4",
+			want:     "foo-e009.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "edit number outgrowing zero-padding",
+			spec:     EditNumber{Regexp: `e(\d+)`, RegexpGroup: 1, Operand: "2"},
+			filePath: "foo-e099.mp4",
+			want:     "foo-e101.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "edit number finds the number in the match",
+			spec:     EditNumber{Regexp: `-(\d+)p`, Operand: "2"},
+			filePath: "foo-1080p-2ffc.mp4",
+			want:     "foo-1082p-2ffc.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "edit number with decimals",
+			spec:     EditNumber{Operand: "1"},
+			filePath: "foo-1.50h.mp4",
+			want:     "foo-2.50h.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "edit number adding decimals",
+			spec:     EditNumber{Operand: "0.25"},
+			filePath: "foo-1.5h-2h.mp4",
+			want:     "foo-1.75h-2.25h.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "edit number multiply",
+			spec:     EditNumber{Op: Multiply, Operand: "1.25"},
+			filePath: "foo-1.5h-04ffc.mp4",
+			want:     "foo-1.875h-05ffc.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "edit number set",
+			spec:     EditNumber{Op: Set, Operand: "5"},
+			filePath: "foo-007bar.mp4",
+			want:     "foo-005bar.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "edit number subtract",
+			spec:     EditNumber{Operand: "-2"},
+			filePath: "foo-3bar.mp4",
+			want:     "foo-1bar.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "edit number with negative result",
+			spec:     EditNumber{Operand: "-2"},
+			filePath: "foo-1bar.mp4",
+			wantErr:  assert.Error,
+		},
+		{
+			name:     "edit number with invalid operand",
+			spec:     EditNumber{Operand: "two"},
+			filePath: "foo-1bar.mp4",
+			wantErr:  assert.Error,
+		},
+		{
+			name:     "edit number without matches",
+			spec:     EditNumber{Operand: "2"},
+			filePath: "foo-bar.mp4",
+			wantErr:  assert.Error,
+		},
+		{
+			name:     "normalize numbers",
+			spec:     NormalizeNumbers{MaxRoman: 20},
+			filePath: "foo-part-II-take-Three-network.mp4",
+			want:     "foo-part-2-take-3-network.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "normalize numbers with padding",
+			spec:     NormalizeNumbers{Padding: 2, MaxRoman: 20},
+			filePath: "Season Four Episode IX.mp4",
+			want:     "Season 04 Episode 09.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "normalize numbers skips large and non-canonical roman numerals",
+			spec:     NormalizeNumbers{MaxRoman: 20},
+			filePath: "MIX-XL-IIII-iv.mp4",
+			want:     "MIX-XL-IIII-iv.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "normalize numbers without roman numerals",
+			spec:     NormalizeNumbers{MaxRoman: 20, NoRoman: true},
+			filePath: "two-II.mp4",
+			want:     "2-II.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "normalize numbers without words",
+			spec:     NormalizeNumbers{MaxRoman: 20, NoWords: true},
+			filePath: "two-II.mp4",
+			want:     "two-2.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "pad numbers",
+			spec:     PadNumbers{Width: 2},
+			filePath: "foo-s1-e7-e123.mp4",
+			want:     "foo-s01-e07-e123.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "pad numbers keeps decimals",
+			spec:     PadNumbers{Width: 2},
+			filePath: "foo-1.5h.mp4",
+			want:     "foo-01.5h.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "pad numbers matching a regular expression",
+			spec:     PadNumbers{Width: 3, Regexp: `e\d+`},
+			filePath: "foo-s1-e7.mp4",
+			want:     "foo-s1-e007.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "pad numbers at a position from the back",
+			spec:     PadNumbers{Width: 2, Position: 1, FromBack: true},
+			filePath: "foo-2-3.mp4",
+			want:     "foo-2-03.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "pad numbers at a missing position",
+			spec:     PadNumbers{Width: 2, Position: 3},
+			filePath: "foo-2-3.mp4",
+			want:     "foo-2-3.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "pad numbers with invalid width",
+			spec:     PadNumbers{Width: 0},
+			filePath: "foo-2.mp4",
+			wantErr:  assert.Error,
+		},
+		{
+			name:     "normalize unicode composes",
+			spec:     NormalizeUnicode{},
+			filePath: "Jose\u0301.mp4",
+			want:     "Jos\u00e9.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "normalize unicode decomposes",
+			spec:     NormalizeUnicode{Form: NFD},
+			filePath: "Jos\u00e9.mp4",
+			want:     "Jose\u0301.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "normalize unicode fixes mojibake",
+			spec:     NormalizeUnicode{},
+			filePath: "JosÃ©-Å‘sz.mp4",
+			want:     "José-ősz.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "normalize unicode fixes double mojibake",
+			spec:     NormalizeUnicode{},
+			filePath: "JosÃƒÂ©.mp4",
+			want:     "José.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "normalize unicode keeps mojibake if asked to",
+			spec:     NormalizeUnicode{NoMojibake: true},
+			filePath: "JosÃ©.mp4",
+			want:     "JosÃ©.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "normalize unicode keeps correct names",
+			spec:     NormalizeUnicode{},
+			filePath: "Árvíztűrő-café-日本.mp4",
+			want:     "Árvíztűrő-café-日本.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "normalize unicode to ascii",
+			spec:     NormalizeUnicode{ASCII: true},
+			filePath: "Árvíztűrő Straße Łódź-日本.mp4",
+			want:     "Arvizturo Strasse Lodz-日本.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "normalize unicode with invalid form",
+			spec:     NormalizeUnicode{Form: "nfkc"},
+			filePath: "foo.mp4",
+			wantErr:  assert.Error,
+		},
+		{
+			name:     "merge parts of listed tags",
+			spec:     MergeParts{Tags: []string{"ffc"}},
+			filePath: "foo-1ffc-2pro-1.5ffc.mp4",
+			want:     "foo-2.5ffc-ffc-2pro.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "merge parts matching a regular expression",
+			spec:     MergeParts{Regexp: "ba."},
+			filePath: "foo-1bar-2quix-3baz.mp4",
+			want:     "foo-4bar-baz-2quix.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "merge parts with tags and regular expression",
+			spec:     MergeParts{Regexp: "bar", Tags: []string{"baz"}},
+			filePath: "foo-1bar-2baz.mp4",
+			wantErr:  assert.Error,
+		},
+		{
+			name:     "merge parts keeps resolutions",
+			spec:     MergeParts{},
+			filePath: "foo-1080p-2ffc-720p.mp4",
+			want:     "foo-1080p-2ffc-720p.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "merge parts with decimals",
+			spec:     MergeParts{},
+			filePath: "foo-0.1a-0.25b-1c.mp4",
+			want:     "foo-1.35a-b-c.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "merge parts with time units",
+			spec:     MergeParts{Units: true},
+			filePath: "foo-1.5h-2ffc-30m.mp4",
+			want:     "foo-2h-2ffc.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "merge parts with time units falling back to a smaller unit",
+			spec:     MergeParts{Units: true},
+			filePath: "foo-1h-20m.mp4",
+			want:     "foo-80m.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "merge parts with time units in seconds",
+			spec:     MergeParts{Units: true},
+			filePath: "foo-1.5m-1s.mp4",
+			want:     "foo-91s.mp4",
+			wantErr:  assert.NoError,
+		},
+		{
+			name:     "merge parts without time units",
+			spec:     MergeParts{},
+			filePath: "foo-1h-30m.mp4",
+			want:     "foo-31h-m.mp4",
+			wantErr:  assert.NoError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got, err := Preview(tt.spec, tt.filePath)
+
+			// assert
+			tt.wantErr(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// checkExtension asserts the invariant shared by all specs: the extension of the file is preserved
+func checkExtension(t *testing.T, filePath, newPath string) {
+	t.Helper()
+
+	if !strings.HasSuffix(newPath, filepath.Ext(filePath)) {
+		t.Errorf("extension changed. file: %q, new: %q", filePath, newPath)
+	}
+}
+
+// validName filters out fuzz inputs which are not valid file names
+func validName(s string) bool {
+	return s != "" && !strings.ContainsAny(s, `/\`) && utf8.ValidString(s)
+}
+
+func FuzzPrefix(f *testing.F) {
+	f.Add("foo-bar.mp4", "quix", 0)
+	f.Add("foo-bar.mp4", "quix", 2)
+	f.Add("-foo-.mp4", "", 1)
+
+	f.Fuzz(func(t *testing.T, name, text string, skip int) {
+		if !validName(name) || !validName(text) {
+			t.Skip()
+		}
+
+		got, err := Preview(Prefix{Text: text, Skip: skip}, name)
+		if err != nil {
+			return
+		}
+
+		checkExtension(t, name, got)
+
+		// separator integrity: exactly the parts of the text are added
+		base := strings.TrimSuffix(name, filepath.Ext(name))
+		newBase := strings.TrimSuffix(got, filepath.Ext(name))
+		want := len(splitParts(base)) + len(strings.Split(text, separator))
+		if n := len(strings.Split(newBase, separator)); n != want {
+			t.Errorf("unexpected number of parts. name: %q, new: %q, parts: %d, want: %d", name, got, n, want)
+		}
+	})
+}
+
+func FuzzSuffix(f *testing.F) {
+	f.Add("foo-bar.mp4", "quix", 0)
+	f.Add("foo-bar.mp4", "quix", 1)
+
+	f.Fuzz(func(t *testing.T, name, text string, skip int) {
+		if !validName(name) || !validName(text) || skip < 0 {
+			t.Skip()
+		}
+
+		got, err := Preview(Suffix{Text: text, Skip: skip}, name)
+		if err != nil {
+			return
+		}
+
+		checkExtension(t, name, got)
+
+		base := strings.TrimSuffix(name, filepath.Ext(name))
+		newBase := strings.TrimSuffix(got, filepath.Ext(name))
+		want := len(splitParts(base)) + len(strings.Split(text, separator))
+		if n := len(strings.Split(newBase, separator)); n != want {
+			t.Errorf("unexpected number of parts. name: %q, new: %q, parts: %d, want: %d", name, got, n, want)
+		}
+	})
+}
+
+func FuzzReplace(f *testing.F) {
+	f.Add("bar-foo-bar.mp4", "bar", "baz", 0)
+	f.Add("foo.mp4", "mp4", "avi", 0)
+
+	f.Fuzz(func(t *testing.T, name, search, with string, skip int) {
+		if !validName(name) || search == "" || strings.ContainsAny(with, `/\`) {
+			t.Skip()
+		}
+
+		got, err := Preview(Replace{Search: search, With: with, Skip: skip}, name)
+		if err != nil {
+			return
+		}
+
+		checkExtension(t, name, got)
+
+		// names not containing the searched text are kept
+		base := strings.TrimSuffix(name, filepath.Ext(name))
+		if !strings.Contains(base, search) && got != name {
+			t.Errorf("name without occurrence changed. name: %q, new: %q", name, got)
+		}
+	})
+}
+
+func FuzzDeleteParts(f *testing.F) {
+	f.Add("foo-bar-baz.mp4", 1, false)
+	f.Add("foo-bar-baz.mp4", 3, true)
+	f.Add("foo.mp4", 1, false)
+
+	f.Fuzz(func(t *testing.T, name string, part int, fromBack bool) {
+		if !validName(name) {
+			t.Skip()
+		}
+
+		got, err := Preview(DeleteParts{Parts: []int{part}, FromBack: fromBack}, name)
+		if err != nil {
+			return
+		}
+
+		checkExtension(t, name, got)
+
+		// at most one part is deleted, the others are kept in order
+		base := strings.TrimSuffix(name, filepath.Ext(name))
+		newBase := strings.TrimSuffix(got, filepath.Ext(name))
+		parts, newParts := strings.Split(base, separator), strings.Split(newBase, separator)
+		if len(newParts) < len(parts)-1 || len(newParts) > len(parts) {
+			t.Errorf("unexpected number of parts. name: %q, new: %q", name, got)
+		}
+
+		// deleting nothing is the identity
+		same, err := Preview(DeleteParts{}, name)
+		if err != nil || same != name {
+			t.Errorf("deleting no parts changed the name. name: %q, new: %q, err: %v", name, same, err)
+		}
+	})
+}
+
+func FuzzMergeParts(f *testing.F) {
+	f.Add("foo-1bar-2baz.mp4", "", false)
+	f.Add("foo-1bar-BAZ-2bar.mp4", "", false)
+	f.Add("foo.mp4", "foo", false)
+	f.Add("foo-1.5h-0.25m-3s-2.5ffc.mp4", "", true)
+
+	f.Fuzz(func(t *testing.T, name, deleteText string, units bool) {
+		if !validName(name) {
+			t.Skip()
+		}
+
+		got, err := Preview(MergeParts{Units: units, DeleteText: deleteText}, name)
+		if err != nil {
+			return
+		}
+
+		checkExtension(t, name, got)
+
+		// merging merged parts does not change them any further
+		if deleteText == "" {
+			again, err := Preview(MergeParts{Units: units}, got)
+			if err != nil || again != got {
+				t.Errorf("merge is not idempotent. name: %q, new: %q, again: %q, err: %v", name, got, again, err)
+			}
+		}
+	})
+}
+
+func FuzzDeleteRegexp(f *testing.F) {
+	f.Add("foo-1bar-2bar.mp4", 0, 0)
+	f.Add("1bar-foo.mp4", 1, 0)
+	f.Add("foo-1bar.mp4", 3, 1)
+
+	f.Fuzz(func(t *testing.T, name string, skipFinds, maxCount int) {
+		if !validName(name) {
+			t.Skip()
+		}
+
+		got, err := Preview(DeleteRegexp{SkipFinds: skipFinds, MaxCount: maxCount}, name)
+		if err != nil {
+			return
+		}
+
+		checkExtension(t, name, got)
+
+		newBase := strings.TrimSuffix(got, filepath.Ext(name))
+		if strings.HasPrefix(newBase, separator) || strings.HasSuffix(newBase, separator) {
+			t.Errorf("separator left at the edge of the name. name: %q, new: %q", name, got)
+		}
+		if len(got) > len(name) {
+			t.Errorf("name got longer. name: %q, new: %q", name, got)
+		}
+	})
+}
+
+func FuzzEditNumber(f *testing.F) {
+	f.Add("foo-1bar-2bar.mp4", "2", 0)
+	f.Add("foo-007bar.mp4", "-3", 1)
+	f.Add("foo-1.50h.mp4", "0.25", 2)
+
+	f.Fuzz(func(t *testing.T, name, operand string, op int) {
+		if !validName(name) || op < int(Add) || op > int(Set) {
+			t.Skip()
+		}
+
+		got, err := Preview(EditNumber{Op: NumberOp(op), Operand: operand}, name)
+		if err != nil {
+			return
+		}
+
+		checkExtension(t, name, got)
+
+		// adding zero keeps the formatting of every number
+		same, err := Preview(EditNumber{Operand: "0"}, got)
+		if err != nil || same != got {
+			t.Errorf("adding zero changed the name. name: %q, new: %q, again: %q, err: %v", name, got, same, err)
+		}
+	})
+}
+
+func FuzzNormalizeNumbers(f *testing.F) {
+	f.Add("foo-part-II-take-three.mp4", 0)
+	f.Add("Season Four Episode IX.mp4", 2)
+
+	f.Fuzz(func(t *testing.T, name string, padding int) {
+		if !validName(name) || padding < 0 || padding > 10 {
+			t.Skip()
+		}
+
+		got, err := Preview(NormalizeNumbers{Padding: padding, MaxRoman: 20}, name)
+		if err != nil {
+			return
+		}
+
+		checkExtension(t, name, got)
+
+		// normalized numbers are not normalized any further
+		again, err := Preview(NormalizeNumbers{Padding: padding, MaxRoman: 20}, got)
+		if err != nil || again != got {
+			t.Errorf("normalize is not idempotent. name: %q, new: %q, again: %q, err: %v", name, got, again, err)
+		}
+	})
+}
+
+func FuzzPadNumbers(f *testing.F) {
+	f.Add("foo-s1-e7.mp4", 2, 0, false)
+	f.Add("foo-1.5h-2-3.mp4", 3, 1, true)
+
+	f.Fuzz(func(t *testing.T, name string, width, position int, fromBack bool) {
+		if !validName(name) || width > 10 {
+			t.Skip()
+		}
+
+		got, err := Preview(PadNumbers{Width: width, Position: position, FromBack: fromBack}, name)
+		if err != nil {
+			return
+		}
+
+		checkExtension(t, name, got)
+
+		if len(got) < len(name) {
+			t.Errorf("name got shorter. name: %q, new: %q", name, got)
+		}
+
+		// padded numbers are not padded any further
+		again, err := Preview(PadNumbers{Width: width, Position: position, FromBack: fromBack}, got)
+		if err != nil || again != got {
+			t.Errorf("pad is not idempotent. name: %q, new: %q, again: %q, err: %v", name, got, again, err)
+		}
+	})
+}
+
+func FuzzNormalizeUnicode(f *testing.F) {
+	f.Add("JosÃ©.mp4", false, false)
+	f.Add("Jose\u0301.mp4", true, true)
+	f.Add("Árvíztűrő Straße.mp4", true, false)
+
+	f.Fuzz(func(t *testing.T, name string, ascii, nfd bool) {
+		// names made of an extension only are rejected by Preview once normalized
+		if !validName(name) || strings.TrimSuffix(name, filepath.Ext(name)) == "" {
+			t.Skip()
+		}
+
+		spec := NormalizeUnicode{ASCII: ascii}
+		if nfd {
+			spec.Form = NFD
+		}
+
+		got, err := Preview(spec, name)
+		if err != nil {
+			return
+		}
+
+		if utf8.ValidString(name) && !utf8.ValidString(got) {
+			t.Errorf("normalized name is invalid UTF-8. name: %q, new: %q", name, got)
+		}
+
+		// normalized names are not changed any further
+		again, err := Preview(spec, got)
+		if err != nil || again != got {
+			t.Errorf("normalize is not idempotent. name: %q, new: %q, again: %q, err: %v", name, got, again, err)
+		}
+	})
+}
@@ -0,0 +1,158 @@
+// Package truncate shortens strings for display, e.g. long file names in tables. Lengths are measured in grapheme
+// clusters, so strings are never cut in the middle of a multi-byte rune, an accented letter or an emoji sequence.
+package truncate
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Ellipsis marks the removed part of a truncated string
+const Ellipsis = "..."
+
+// maxMiddleTail is the maximum number of graphemes kept from the end of a string truncated in the middle, enough to
+// keep the extension and the last part of a file name visible
+const maxMiddleTail = 9
+
+// Style defines which part of a string is removed
+type Style int
+
+const (
+	// Middle removes the middle of the string, e.g. "a-very-l...ncate.mp4"
+	Middle Style = iota
+	// Head removes the beginning of the string, e.g. "...-to-truncate.mp4"
+	Head
+	// Tail removes the end of the string, e.g. "a-very-long-file-..."
+	Tail
+)
+
+const (
+	zeroWidthJoiner = '\u200d'
+	carriageReturn  = '\r'
+	lineFeed        = '\n'
+)
+
+// isExtend checks if r belongs to the grapheme cluster of the rune before it
+func isExtend(r rune) bool {
+	switch {
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc):
+		return true
+	case r == zeroWidthJoiner:
+		return true
+	case r >= 0xfe00 && r <= 0xfe0f, r >= 0xe0100 && r <= 0xe01ef: // variation selectors
+		return true
+	case r >= 0x1f3fb && r <= 0x1f3ff: // emoji skin tone modifiers
+		return true
+	case r >= 0xe0020 && r <= 0xe007f: // tags, used in subdivision flags
+		return true
+	}
+
+	return false
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1f1e6 && r <= 0x1f1ff
+}
+
+// Graphemes splits s into user-perceived characters. It follows the most common rules of extended grapheme
+// clusters: combining marks, variation selectors, emoji modifiers and zero width joiner sequences stay with their base,
+// regional indicators form flags in pairs and CR LF is kept together. Invalid UTF-8 bytes are returned one by one.
+func Graphemes(s string) []string {
+	var (
+		result    []string
+		start     int
+		prev      rune
+		regionals int
+	)
+
+	for i, r := range s {
+		if i == 0 {
+			prev = r
+			if isRegionalIndicator(r) {
+				regionals = 1
+			}
+
+			continue
+		}
+
+		join := false
+		switch {
+		case prev == carriageReturn && r == lineFeed:
+			join = true
+		case prev == carriageReturn || prev == lineFeed || r == carriageReturn || r == lineFeed:
+			join = false
+		case prev == utf8.RuneError || r == utf8.RuneError:
+			join = false
+		case isExtend(r):
+			join = true
+		case prev == zeroWidthJoiner:
+			join = true
+		case isRegionalIndicator(r) && regionals%2 == 1:
+			join = true
+		}
+
+		if !join {
+			result = append(result, s[start:i])
+			start = i
+			regionals = 0
+		}
+
+		if isRegionalIndicator(r) {
+			regionals++
+		}
+
+		prev = r
+	}
+
+	if start < len(s) {
+		result = append(result, s[start:])
+	}
+
+	return result
+}
+
+// Len returns the number of graphemes in s
+func Len(s string) int {
+	return len(Graphemes(s))
+}
+
+// String shortens s to at most maxLength graphemes including the ellipsis, removing the part defined by style.
+// Strings are not truncated if maxLength is not positive. If maxLength is too short for the ellipsis, s is cut without
+// one.
+func String(s string, maxLength int, style Style) string {
+	if maxLength <= 0 || len(s) <= maxLength {
+		return s
+	}
+
+	graphemes := Graphemes(s)
+	if len(graphemes) <= maxLength {
+		return s
+	}
+
+	ellipsisLength := len(Ellipsis)
+	if maxLength <= ellipsisLength {
+		if style == Head {
+			return strings.Join(graphemes[len(graphemes)-maxLength:], "")
+		}
+
+		return strings.Join(graphemes[:maxLength], "")
+	}
+
+	available := maxLength - ellipsisLength
+
+	switch style {
+	case Head:
+		return Ellipsis + strings.Join(graphemes[len(graphemes)-available:], "")
+	case Tail:
+		return strings.Join(graphemes[:available], "") + Ellipsis
+	}
+
+	tail := available - available/2
+	if tail > maxMiddleTail {
+		tail = maxMiddleTail
+	}
+	head := available - tail
+
+	return strings.Join(graphemes[:head], "") + Ellipsis + strings.Join(graphemes[len(graphemes)-tail:], "")
+}
@@ -0,0 +1,184 @@
+package truncate
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraphemes(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want []string
+	}{
+		{
+			name: "empty",
+			s:    "",
+			want: nil,
+		},
+		{
+			name: "ascii",
+			s:    "abc",
+			want: []string{"a", "b", "c"},
+		},
+		{
+			name: "multi-byte runes",
+			s:    "árvíz",
+			want: []string{"á", "r", "v", "í", "z"},
+		},
+		{
+			name: "combining marks",
+			s:    "été",
+			want: []string{"é", "t", "é"},
+		},
+		{
+			name: "emoji with skin tone and variation selector",
+			s:    "👍🏽❤️x",
+			want: []string{"👍🏽", "❤️", "x"},
+		},
+		{
+			name: "zero width joiner sequence",
+			s:    "a👨‍👩‍👧b",
+			want: []string{"a", "👨‍👩‍👧", "b"},
+		},
+		{
+			name: "flags are pairs of regional indicators",
+			s:    "🇭🇺🇩🇪🇫",
+			want: []string{"🇭🇺", "🇩🇪", "🇫"},
+		},
+		{
+			name: "CR LF",
+			s:    "a\r\nb",
+			want: []string{"a", "\r\n", "b"},
+		},
+		{
+			name: "invalid UTF-8",
+			s:    "a\xff́",
+			want: []string{"a", "\xff", "́"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got := Graphemes(tt.s)
+
+			// assert
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestString(t *testing.T) {
+	type args struct {
+		s         string
+		maxLength int
+		style     Style
+	}
+	tests := []struct {
+		name string
+		args args
+		want string
+	}{
+		{
+			name: "short",
+			args: args{s: "foo.mp4", maxLength: 20, style: Middle},
+			want: "foo.mp4",
+		},
+		{
+			name: "no limit",
+			args: args{s: "a-very-long-file-name-to-truncate.mp4", maxLength: 0, style: Middle},
+			want: "a-very-long-file-name-to-truncate.mp4",
+		},
+		{
+			name: "middle",
+			args: args{s: "a-very-long-file-name-to-truncate.mp4", maxLength: 20, style: Middle},
+			want: "a-very-l...ncate.mp4",
+		},
+		{
+			name: "middle short limit",
+			args: args{s: "a-very-long-file-name-to-truncate.mp4", maxLength: 10, style: Middle},
+			want: "a-v....mp4",
+		},
+		{
+			name: "head",
+			args: args{s: "a-very-long-file-name-to-truncate.mp4", maxLength: 20, style: Head},
+			want: "...e-to-truncate.mp4",
+		},
+		{
+			name: "tail",
+			args: args{s: "a-very-long-file-name-to-truncate.mp4", maxLength: 20, style: Tail},
+			want: "a-very-long-file-...",
+		},
+		{
+			name: "too short for the ellipsis",
+			args: args{s: "a-very-long-file-name-to-truncate.mp4", maxLength: 3, style: Middle},
+			want: "a-v",
+		},
+		{
+			name: "too short for the ellipsis, head",
+			args: args{s: "a-very-long-file-name-to-truncate.mp4", maxLength: 3, style: Head},
+			want: "mp4",
+		},
+		{
+			name: "multi-byte runes are not split",
+			args: args{s: "árvíztűrő-tükörfúrógép.mp4", maxLength: 12, style: Tail},
+			want: "árvíztűrő...",
+		},
+		{
+			name: "many bytes but few graphemes",
+			args: args{s: "👨‍👩‍👧🇭🇺.mp4", maxLength: 6, style: Middle},
+			want: "👨‍👩‍👧🇭🇺.mp4",
+		},
+		{
+			name: "combining marks are kept with their base",
+			args: args{s: "éééééé", maxLength: 5, style: Tail},
+			want: "éé...",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// execute
+			got := String(tt.args.s, tt.args.maxLength, tt.args.style)
+
+			// assert
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func FuzzString(f *testing.F) {
+	f.Add("a-very-long-file-name-to-truncate.mp4", 20, 0)
+	f.Add("árvíztűrő-tükörfúrógép.mp4", 7, 1)
+	f.Add("👨‍👩‍👧🇭🇺é.mp4", 4, 2)
+
+	f.Fuzz(func(t *testing.T, s string, maxLength, style int) {
+		if style < 0 || style > int(Tail) {
+			t.Skip()
+		}
+
+		got := String(s, maxLength, Style(style))
+
+		if strings.Join(Graphemes(s), "") != s {
+			t.Errorf("graphemes do not add up to the string. s: %q", s)
+		}
+
+		if maxLength <= 0 || Len(s) <= maxLength {
+			if got != s {
+				t.Errorf("string should not be truncated. s: %q, max: %d, got: %q", s, maxLength, got)
+			}
+
+			return
+		}
+
+		if n := Len(got); n > maxLength {
+			t.Errorf("truncated string is too long. s: %q, max: %d, got: %q, length: %d", s, maxLength, got, n)
+		}
+
+		if utf8.ValidString(s) && !utf8.ValidString(got) {
+			t.Errorf("truncated string is invalid UTF-8. s: %q, got: %q", s, got)
+		}
+	})
+}